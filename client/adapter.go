@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Logger is what the logging adapters write to; *AsyncClient and *Router
+// implement it. Log should not block for long, as loggers call it inline.
+type Logger interface {
+	Log(Entry) error
+}
+
+// contextLogger is implemented by loggers that may wait on the network, such
+// as *Router, so adapters can pass the caller's deadline.
+type contextLogger interface {
+	LogContext(ctx context.Context, entry Entry) error
+}
+
+// LogContext writes entry to l, bounded by ctx when l supports it.
+func LogContext(ctx context.Context, l Logger, entry Entry) error {
+	if cl, ok := l.(contextLogger); ok && ctx != nil {
+		return cl.LogContext(ctx, entry)
+	}
+	return l.Log(entry)
+}
+
+// flusher is implemented by loggers the adapters can flush on Sync.
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Flush flushes l if it buffers entries, giving up after timeout.
+func Flush(l Logger, timeout time.Duration) error {
+	f, ok := l.(flusher)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return f.Flush(ctx)
+}
+
+// Set stores a logger field on e: the keys system, user, module, task,
+// trace_id, span_id, session_id and stack_trace set those columns when their value is a
+// string, and other keys go to Fields. Errors and Stringers are stored as
+// their text.
+func (e *Entry) Set(key string, value any) {
+	value = fieldValue(value)
+	if s, ok := value.(string); ok {
+		switch key {
+		case "system":
+			e.System = s
+			return
+		case "user":
+			e.User = s
+			return
+		case "module":
+			e.Module = s
+			return
+		case "task":
+			e.Task = s
+			return
+		case "trace_id":
+			e.TraceID = s
+			return
+		case "span_id":
+			e.SpanID = s
+			return
+		case "session_id":
+			e.SessionID = s
+			return
+		case "stack_trace":
+			e.StackTrace = s
+			return
+		}
+	}
+	e.setField(key, value)
+}
+
+func (e *Entry) setField(key string, value any) {
+	if e.Fields == nil {
+		e.Fields = map[string]any{}
+	}
+	e.Fields[key] = fieldValue(value)
+}
+
+// fieldValue converts values encoding/json would lose to their text.
+func fieldValue(value any) any {
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+var program = filepath.Base(os.Args[0])
+
+// WithDefaults fills the columns the server requires and e leaves empty from
+// defaults, and then from the process: the hostname as system, the program
+// name as user and module, and "log" as task.
+func (e Entry) WithDefaults(defaults Entry) Entry {
+	for _, col := range []struct {
+		v    *string
+		def  string
+		proc func() string
+	}{
+		{&e.System, defaults.System, func() string { h, _ := os.Hostname(); return h }},
+		{&e.User, defaults.User, func() string { return program }},
+		{&e.Module, defaults.Module, func() string { return program }},
+		{&e.Task, defaults.Task, func() string { return "log" }},
+	} {
+		if *col.v == "" {
+			*col.v = col.def
+		}
+		if *col.v == "" {
+			*col.v = col.proc()
+		}
+	}
+	if e.Account == "" {
+		e.Account = defaults.Account
+	}
+	return e
+}
@@ -0,0 +1,359 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueFull is returned by AsyncClient.Log when an entry was dropped
+// because the queue is full and no spool is configured.
+var ErrQueueFull = errors.New("logdata: queue full, entry dropped")
+
+// ErrClosed is returned by AsyncClient.Log after Close.
+var ErrClosed = errors.New("logdata: client closed")
+
+// AsyncConfig configures Client.Async. Zero values select the defaults.
+type AsyncConfig struct {
+	// QueueSize bounds the entries held in memory, default 10000.
+	QueueSize int
+	// BatchSize is the most entries sent per request, default 500.
+	BatchSize int
+	// FlushInterval is how long an entry may wait for its batch to fill,
+	// default 1s.
+	FlushInterval time.Duration
+	// RetryInterval is the first delay after a failed send, doubling up to
+	// MaxRetryInterval; defaults 500ms and 30s.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	// SpoolDir, when set, holds batches that could not be sent, and entries
+	// logged while the queue is full, until the server accepts them. Spooled
+	// batches left by a previous process are sent on start.
+	SpoolDir string
+	// SpoolMaxBytes bounds the spool, dropping the oldest batches; 0 means
+	// unbounded.
+	SpoolMaxBytes int64
+	// OnError, if set, is called from the background goroutine for entries
+	// that were dropped or rejected by the server, and for spool failures.
+	OnError func(dropped int, err error)
+}
+
+// AsyncClient queues entries and sends them in batches from a background
+// goroutine, so logging never waits for the server. While the server is
+// unreachable batches are retried with exponential backoff, or spooled when
+// SpoolDir is set. Entries go through POST /import, which stores them without
+// sampling, deduplication, webhooks or live tail. It is safe for concurrent use.
+type AsyncClient struct {
+	client *Client
+	cfg    AsyncConfig
+	spool  *spool
+
+	queue   chan Entry
+	flushes chan chan struct{}
+	wake    chan struct{}
+	// closing stops accepting entries; abort ends retries at Close's deadline.
+	closing   chan struct{}
+	abort     chan struct{}
+	done      sync.WaitGroup
+	closeOnce sync.Once
+	mu        sync.RWMutex
+	closed    bool
+
+	dropped atomic.Int64
+}
+
+// Async starts an AsyncClient sending through c.
+func (c *Client) Async(cfg AsyncConfig) (*AsyncClient, error) {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = 500 * time.Millisecond
+	}
+	if cfg.MaxRetryInterval < cfg.RetryInterval {
+		cfg.MaxRetryInterval = max(30*time.Second, cfg.RetryInterval)
+	}
+	a := &AsyncClient{
+		client:  c,
+		cfg:     cfg,
+		queue:   make(chan Entry, cfg.QueueSize),
+		flushes: make(chan chan struct{}),
+		wake:    make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		abort:   make(chan struct{}),
+	}
+	if cfg.SpoolDir != "" {
+		var err error
+		if a.spool, err = openSpool(cfg.SpoolDir, cfg.SpoolMaxBytes); err != nil {
+			return nil, err
+		}
+		a.done.Add(1)
+		go a.replay()
+	}
+	a.done.Add(1)
+	go a.run()
+	return a, nil
+}
+
+// Log queues entry without blocking, filling in Account, Timestamp and ULID.
+// When the queue is full the entry is spooled if SpoolDir is set, and
+// otherwise dropped with ErrQueueFull.
+func (a *AsyncClient) Log(entry Entry) error {
+	a.client.prepare(&entry)
+	if entry.ULID == "" {
+		entry.ULID = newULID(entry.Timestamp)
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return ErrClosed
+	}
+	select {
+	case a.queue <- entry:
+		return nil
+	default:
+	}
+	if a.spool != nil {
+		if err := a.spool.write([]Entry{entry}); err == nil {
+			a.signal()
+			return nil
+		}
+	}
+	a.dropped.Add(1)
+	return ErrQueueFull
+}
+
+// Dropped returns how many entries were lost: dropped on a full queue,
+// rejected by the server, or unsent at Close.
+func (a *AsyncClient) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// Flush waits until the entries logged before the call have been sent or
+// spooled, or ctx is done.
+func (a *AsyncClient) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case a.flushes <- done:
+	case <-a.closing:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting entries and sends those queued, then tries the spool
+// once. When ctx ends first, retries stop, unsent batches are dropped unless
+// spooled, and ctx's error is returned. Spooled batches are sent by the next
+// AsyncClient using the same SpoolDir.
+func (a *AsyncClient) Close(ctx context.Context) error {
+	a.closeOnce.Do(func() {
+		a.mu.Lock()
+		a.closed = true
+		a.mu.Unlock()
+		close(a.closing)
+	})
+	stopped := make(chan struct{})
+	go func() {
+		a.done.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		a.stopRetries()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+func (a *AsyncClient) stopRetries() {
+	select {
+	case <-a.abort:
+	default:
+		close(a.abort)
+	}
+}
+
+// run batches queued entries until Close.
+func (a *AsyncClient) run() {
+	defer a.done.Done()
+	ticker := time.NewTicker(a.cfg.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]Entry, 0, a.cfg.BatchSize)
+	deliver := func() {
+		if len(batch) > 0 {
+			a.deliver(batch)
+			batch = make([]Entry, 0, a.cfg.BatchSize)
+		}
+	}
+	// drain moves everything queued into batches
+	drain := func() {
+		for {
+			select {
+			case entry := <-a.queue:
+				if batch = append(batch, entry); len(batch) >= a.cfg.BatchSize {
+					deliver()
+				}
+			default:
+				deliver()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case entry := <-a.queue:
+			if batch = append(batch, entry); len(batch) >= a.cfg.BatchSize {
+				deliver()
+			}
+		case <-ticker.C:
+			deliver()
+		case done := <-a.flushes:
+			drain()
+			close(done)
+		case <-a.closing:
+			drain()
+			// Let replay stop once the spool is empty or retries are aborted
+			a.signal()
+			return
+		}
+	}
+}
+
+// deliver sends batch, retrying until it is accepted, rejected, or Close
+// gives up. Batches are spooled instead when older ones are still spooled,
+// to keep their order, or when a send fails and a spool is configured.
+func (a *AsyncClient) deliver(batch []Entry) {
+	if a.spool != nil && !a.spool.empty() {
+		a.spoolBatch(batch)
+		return
+	}
+	backoff := a.cfg.RetryInterval
+	for {
+		err := a.send(batch)
+		if err == nil {
+			return
+		}
+		if a.spool != nil {
+			a.spoolBatch(batch)
+			return
+		}
+		select {
+		case <-a.abort:
+			a.lost(len(batch), err)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, a.cfg.MaxRetryInterval)
+	}
+}
+
+// send posts batch, reporting an error only when it is worth retrying.
+// Entries the server rejects are counted as dropped.
+func (a *AsyncClient) send(batch []Entry) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-a.abort:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	res, err := a.client.SendBatch(ctx, batch)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && !apiErr.Temporary() {
+		a.lost(len(batch), err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if res.Rejected > 0 {
+		a.lost(res.Rejected, errors.New("logdata: server rejected entries"))
+	}
+	return nil
+}
+
+func (a *AsyncClient) spoolBatch(batch []Entry) {
+	if err := a.spool.write(batch); err != nil {
+		a.lost(len(batch), err)
+		return
+	}
+	a.signal()
+}
+
+// replay sends spooled batches, oldest first, backing off while the server
+// is unreachable. After Close it stops once the spool is empty, a send fails,
+// or Close's deadline passes.
+func (a *AsyncClient) replay() {
+	defer a.done.Done()
+	backoff := a.cfg.RetryInterval
+	for {
+		select {
+		case <-a.abort:
+			return
+		case <-a.wake:
+		case <-time.After(backoff):
+		}
+		failed := false
+		for {
+			name, batch, err := a.spool.oldest()
+			if name == "" {
+				backoff = a.cfg.RetryInterval
+				break
+			}
+			if err != nil {
+				a.spool.remove(name)
+				a.lost(0, err)
+				continue
+			}
+			if err := a.send(batch); err != nil {
+				backoff = min(backoff*2, a.cfg.MaxRetryInterval)
+				failed = true
+				break
+			}
+			a.spool.remove(name)
+			backoff = a.cfg.RetryInterval
+		}
+		select {
+		case <-a.closing:
+			// Spooled batches are safe on disk for the next process
+			if failed || a.spool.empty() {
+				return
+			}
+		default:
+		}
+	}
+}
+
+// signal wakes replay.
+func (a *AsyncClient) signal() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (a *AsyncClient) lost(n int, err error) {
+	a.dropped.Add(int64(n))
+	if a.cfg.OnError != nil {
+		a.cfg.OnError(n, err)
+	}
+}
@@ -0,0 +1,255 @@
+// Package client sends log entries to a logdata server over its HTTP API.
+//
+// Client.Send stores one entry and waits for the server. Client.Async returns
+// an AsyncClient that queues entries in memory and sends them in the
+// background, retrying and optionally spooling to disk while the server is
+// unreachable.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Levels, matching the server's.
+const (
+	LevelTrace = 0
+	LevelDebug = 1
+	LevelInfo  = 2
+	LevelWarn  = 3
+	LevelError = 4
+	LevelFatal = 5
+)
+
+// Entry is a log entry as the server's JSON API reads and returns it.
+type Entry struct {
+	ID         *int64         `json:"id,omitempty"`
+	ULID       string         `json:"ulid,omitempty"`
+	Account    string         `json:"account"`
+	System     string         `json:"system"`
+	User       string         `json:"user"`
+	Module     string         `json:"module"`
+	Task       string         `json:"task"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Msg        string         `json:"msg"`
+	Level      int            `json:"level"`
+	StackTrace string         `json:"stack_trace"`
+	Fields     map[string]any `json:"fields,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	SpanID     string         `json:"span_id,omitempty"`
+	SessionID  string         `json:"session_id,omitempty"`
+	// ExpiresAt, or TTL from the time the server receives the entry, removes
+	// it before the account's retention would.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       string     `json:"ttl,omitempty"`
+	// ClientID is an idempotency key: Send retries carrying the same one
+	// within the server's IDEMPOTENCY_TTL store the entry once.
+	ClientID string `json:"client_id,omitempty"`
+	// SourceSeq identifies the entry at its source: an entry whose SourceSeq
+	// the server already stored for the account is not stored again, however
+	// long ago.
+	SourceSeq string `json:"source_seq,omitempty"`
+}
+
+// ImportResult is the server's answer to a batch.
+type ImportResult struct {
+	Lines    int            `json:"lines"`
+	Imported int            `json:"imported"`
+	Skipped  int            `json:"skipped"`
+	Rejected int            `json:"rejected"`
+	Errors   map[string]int `json:"errors,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// Config configures a Client.
+type Config struct {
+	// Server is the base URL of the logdata server.
+	Server string
+	// Account is set on entries that name none and sent as X-Account.
+	Account string
+	// Token is sent as "Authorization: Bearer <token>" when set; it needs
+	// the ingest scope to send and read to query.
+	Token string
+	// HTTPClient defaults to a client with a 30 second timeout.
+	HTTPClient *http.Client
+}
+
+// Client talks to one server for one account. It is safe for concurrent use.
+type Client struct {
+	server, account, token string
+	http                   *http.Client
+}
+
+// New returns a Client for cfg.
+func New(cfg Config) *Client {
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Client{
+		server:  strings.TrimSuffix(cfg.Server, "/"),
+		account: cfg.Account,
+		token:   cfg.Token,
+		http:    httpClient,
+	}
+}
+
+// Error is returned for requests the server answered with an error status.
+// Code is the server's machine-readable error code, such as
+// VALIDATION_FAILED or QUOTA_EXCEEDED, and is empty for errors from a proxy
+// in between.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("logdata: %d %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed if retried: the server
+// is overloaded, over a rate or row quota, or failing.
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Send stores entry with POST /logdata and returns its ULID. Missing Account
+// and Timestamp are filled in.
+func (c *Client) Send(ctx context.Context, entry Entry) (string, error) {
+	c.prepare(&entry)
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/logdata", "application/json", bytes.NewReader(body), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		ULID string `json:"ulid"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("logdata: invalid response: %v", err)
+	}
+	return res.ULID, nil
+}
+
+// SendBatch stores entries with one POST /import request, gzip compressed.
+// Entries without a ULID get one, so a batch may be resent after an error
+// without storing duplicates. Entries the server rejects are counted in the
+// result rather than returned as an error.
+func (c *Client) SendBatch(ctx context.Context, entries []Entry) (*ImportResult, error) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	enc := json.NewEncoder(gz)
+	for i := range entries {
+		c.prepare(&entries[i])
+		if entries[i].ULID == "" {
+			entries[i].ULID = newULID(entries[i].Timestamp)
+		}
+		if err := enc.Encode(entries[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/import?format=ndjson", "application/x-ndjson", &body,
+		http.Header{"Content-Encoding": {"gzip"}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res ImportResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("logdata: invalid response: %v", err)
+	}
+	return &res, nil
+}
+
+// Query returns the entries matching the /getdata parameters in params,
+// for the client's account unless params names one.
+func (c *Client) Query(ctx context.Context, params url.Values) ([]Entry, error) {
+	query := url.Values{}
+	for k, v := range params {
+		query[k] = v
+	}
+	if query.Get("account") == "" && query.Get("account_prefix") == "" {
+		query.Set("account", c.account)
+	}
+	// The server rejects requests naming different accounts in X-Account
+	// and the query
+	account := query.Get("account")
+	if account == "" {
+		account = query.Get("account_prefix")
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/getdata?"+query.Encode(), "", nil, http.Header{"X-Account": {account}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var entries []Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("logdata: invalid response: %v", err)
+	}
+	return entries, nil
+}
+
+// prepare fills in the defaults the server requires.
+func (c *Client) prepare(entry *Entry) {
+	if entry.Account == "" {
+		entry.Account = c.account
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+}
+
+// do sends a request, returning an *Error for error statuses.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.account != "" && req.Header.Get("X-Account") == "" {
+		req.Header.Set("X-Account", c.account)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Code      string `json:"code"`
+		Error     string `json:"error"`
+		RequestID string `json:"request_id"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(b, &res) != nil || res.Error == "" {
+		res.Error = strings.TrimSpace(string(b))
+	}
+	return nil, &Error{StatusCode: resp.StatusCode, Code: res.Code, Message: res.Error, RequestID: res.RequestID}
+}
@@ -0,0 +1,100 @@
+// Package logrushook provides a logrus hook writing to logdata.
+package logrushook
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"log-server/client"
+)
+
+// Options configures New.
+type Options struct {
+	// Levels are the levels sent, default all from Info up.
+	Levels []logrus.Level
+	// Defaults fills system, user, module and task when no field sets them;
+	// see client.Entry.WithDefaults.
+	Defaults client.Entry
+}
+
+// Hook is a logrus hook writing entries to a client.Logger. Fields named
+// like LogData columns (see client.Entry.Set) set those columns, "logger" or
+// "component" sets the module when "module" is absent, and other fields go
+// to fields.
+type Hook struct {
+	logger client.Logger
+	opts   Options
+}
+
+// New returns a hook writing to l, e.g. logrus.AddHook(logrushook.New(async, nil)).
+func New(l client.Logger, opts *Options) *Hook {
+	h := &Hook{logger: l}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Levels == nil {
+		for _, level := range logrus.AllLevels {
+			if level <= logrus.InfoLevel {
+				h.opts.Levels = append(h.opts.Levels, level)
+			}
+		}
+	}
+	return h
+}
+
+func (h *Hook) Levels() []logrus.Level {
+	return h.opts.Levels
+}
+
+func (h *Hook) Fire(e *logrus.Entry) error {
+	entry := client.Entry{
+		Timestamp: e.Time.UTC(),
+		Msg:       e.Message,
+		Level:     Level(e.Level),
+	}
+	if e.HasCaller() {
+		entry.Set("caller", e.Caller.Function)
+	}
+	for k, v := range e.Data {
+		entry.Set(k, v)
+	}
+	if entry.Module == "" {
+		for _, key := range []string{"logger", "component"} {
+			if name, ok := entry.Fields[key].(string); ok {
+				entry.Module = name
+				delete(entry.Fields, key)
+				break
+			}
+		}
+	}
+	err := client.LogContext(e.Context, h.logger, entry.WithDefaults(h.opts.Defaults))
+	if e.Level <= logrus.FatalLevel {
+		// logrus exits or panics next
+		client.Flush(h.logger, 5*time.Second)
+	}
+	return err
+}
+
+// Flush waits up to timeout for buffered entries, e.g. before os.Exit.
+// Fatal and panic entries are flushed by Fire.
+func (h *Hook) Flush(timeout time.Duration) error {
+	return client.Flush(h.logger, timeout)
+}
+
+// Level maps a logrus level to a logdata level.
+func Level(level logrus.Level) int {
+	switch level {
+	case logrus.TraceLevel:
+		return client.LevelTrace
+	case logrus.DebugLevel:
+		return client.LevelDebug
+	case logrus.InfoLevel:
+		return client.LevelInfo
+	case logrus.WarnLevel:
+		return client.LevelWarn
+	case logrus.ErrorLevel:
+		return client.LevelError
+	}
+	return client.LevelFatal
+}
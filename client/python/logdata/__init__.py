@@ -0,0 +1,20 @@
+"""Sends log entries to a logdata server over its HTTP API.
+
+Client.send stores one entry and Client.send_batch many; both retry
+temporary failures as the ingestion contract in the server's README
+describes, without storing an entry twice.
+"""
+
+from .client import (
+    TRACE,
+    DEBUG,
+    INFO,
+    WARN,
+    ERROR,
+    FATAL,
+    Client,
+    Error,
+    new_ulid,
+)
+
+__all__ = ["TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL", "Client", "Error", "new_ulid"]
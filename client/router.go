@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Route decides how entries of matching modules are sent by a Router.
+type Route struct {
+	// Module is matched exactly, or as a prefix when it ends in "*". Empty
+	// and "*" match every module.
+	Module string
+	// Entries below MinLevel are dropped locally.
+	MinLevel int
+	// Entries at or above SyncLevel are sent synchronously; levels between
+	// MinLevel and SyncLevel are batched. Set it above LevelFatal to batch
+	// everything.
+	SyncLevel int
+}
+
+func (r Route) matches(module string) bool {
+	if prefix, ok := strings.CutSuffix(r.Module, "*"); ok {
+		return strings.HasPrefix(module, prefix)
+	}
+	return r.Module == "" || r.Module == module
+}
+
+// DefaultRoute drops trace entries, batches debug and info, and sends warn
+// and above synchronously.
+var DefaultRoute = Route{MinLevel: LevelDebug, SyncLevel: LevelWarn}
+
+// RouterConfig configures NewRouter.
+type RouterConfig struct {
+	// Routes are tried in order; the first matching an entry's module wins,
+	// and DefaultRoute applies when none does.
+	Routes []Route
+	// SyncTimeout bounds synchronous sends whose context has no earlier
+	// deadline, default 5s.
+	SyncTimeout time.Duration
+}
+
+// Router is a Logger sending each entry synchronously through a Client,
+// batched through an AsyncClient, or nowhere, by module and level, so
+// services pay a network round trip only for the entries that need it.
+// Synchronous sends that fail are queued on the AsyncClient instead of being
+// lost; an entry whose response was lost may then be stored twice.
+type Router struct {
+	client *Client
+	async  *AsyncClient
+	cfg    RouterConfig
+}
+
+// NewRouter returns a Router sending through c and async.
+func NewRouter(c *Client, async *AsyncClient, cfg RouterConfig) *Router {
+	if cfg.SyncTimeout <= 0 {
+		cfg.SyncTimeout = 5 * time.Second
+	}
+	return &Router{client: c, async: async, cfg: cfg}
+}
+
+// Route returns the route for module.
+func (r *Router) Route(module string) Route {
+	for _, route := range r.cfg.Routes {
+		if route.matches(module) {
+			return route
+		}
+	}
+	return DefaultRoute
+}
+
+// Log routes entry, bounding a synchronous send by SyncTimeout.
+func (r *Router) Log(entry Entry) error {
+	return r.LogContext(context.Background(), entry)
+}
+
+// LogContext routes entry, bounding a synchronous send by ctx's deadline
+// and SyncTimeout. A cancelled ctx only shortens the send: the entry is
+// queued like any other failed synchronous send.
+func (r *Router) LogContext(ctx context.Context, entry Entry) error {
+	route := r.Route(entry.Module)
+	switch {
+	case entry.Level < route.MinLevel:
+		return nil
+	case entry.Level < route.SyncLevel:
+		return r.async.Log(entry)
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.SyncTimeout)
+	defer cancel()
+	if _, err := r.client.Send(ctx, entry); err != nil {
+		if apiErr, ok := err.(*Error); ok && !apiErr.Temporary() {
+			return err
+		}
+		return r.async.Log(entry)
+	}
+	return nil
+}
+
+// Flush flushes the AsyncClient.
+func (r *Router) Flush(ctx context.Context) error {
+	return r.async.Flush(ctx)
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogOptions configures NewSlogHandler.
+type SlogOptions struct {
+	// Level is the minimum level logged, default slog.LevelInfo.
+	Level slog.Leveler
+	// Defaults fills system, user, module and task when no attribute sets
+	// them; see Entry.WithDefaults.
+	Defaults Entry
+}
+
+// SlogHandler is a slog.Handler writing records to a Logger. Attributes
+// named like LogData columns (see Entry.Set) set those columns; others go to
+// fields, with groups joined by dots.
+type SlogHandler struct {
+	logger Logger
+	opts   SlogOptions
+	// attrs holds the attributes added with WithAttrs, already prefixed
+	attrs  []slog.Attr
+	prefix string
+}
+
+// NewSlogHandler returns a handler writing to l, e.g.
+// slog.SetDefault(slog.New(client.NewSlogHandler(async, nil))).
+func NewSlogHandler(l Logger, opts *SlogOptions) *SlogHandler {
+	h := &SlogHandler{logger: l}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Level == nil {
+		h.opts.Level = slog.LevelInfo
+	}
+	return h
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	entry := Entry{Msg: r.Message, Level: SlogLevel(r.Level)}
+	if !r.Time.IsZero() {
+		entry.Timestamp = r.Time.UTC()
+	}
+	for _, a := range h.attrs {
+		setSlogAttr(&entry, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		setSlogAttr(&entry, h.prefix, a)
+		return true
+	})
+	return LogContext(ctx, h.logger, entry.WithDefaults(h.opts.Defaults))
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = make([]slog.Attr, len(h.attrs), len(h.attrs)+len(attrs))
+	copy(h2.attrs, h.attrs)
+	for _, a := range attrs {
+		if h.prefix != "" {
+			a.Key = h.prefix + a.Key
+		}
+		h2.attrs = append(h2.attrs, a)
+	}
+	return &h2
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+func setSlogAttr(entry *Entry, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			setSlogAttr(entry, prefix, ga)
+		}
+		return
+	}
+	// Only top-level keys name columns
+	if prefix != "" {
+		entry.setField(prefix+a.Key, a.Value.Any())
+		return
+	}
+	entry.Set(a.Key, a.Value.Any())
+}
+
+// SlogLevel maps a slog level to a logdata level.
+func SlogLevel(level slog.Level) int {
+	switch {
+	case level < slog.LevelDebug:
+		return LevelTrace
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	case level < slog.LevelError+4:
+		return LevelError
+	}
+	return LevelFatal
+}
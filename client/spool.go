@@ -0,0 +1,117 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// spool stores unsent batches as NDJSON files named by creation time, so
+// they sort oldest first. The oldest are dropped once maxBytes is exceeded.
+type spool struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+	seq      int
+}
+
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &spool{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// files lists spooled batches, oldest first.
+func (s *spool) files() []string {
+	matches, _ := filepath.Glob(filepath.Join(s.dir, "*.ndjson"))
+	sort.Strings(matches)
+	return matches
+}
+
+func (s *spool) empty() bool {
+	return len(s.files()) == 0
+}
+
+// write stores entries as a new batch.
+func (s *spool) write(entries []Entry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.seq++
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d-%06d.ndjson", time.Now().UnixNano(), s.seq%1000000))
+	s.mu.Unlock()
+	// Write under a temporary name so a crash never leaves a partial batch
+	if err := os.WriteFile(name+".tmp", buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	s.trim()
+	return nil
+}
+
+// oldest returns the oldest batch, or an empty name when the spool is empty.
+func (s *spool) oldest() (string, []Entry, error) {
+	files := s.files()
+	if len(files) == 0 {
+		return "", nil, nil
+	}
+	name := files[0]
+	f, err := os.Open(name)
+	if err != nil {
+		return name, nil, err
+	}
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return name, nil, fmt.Errorf("invalid spool file %s: %v", name, err)
+		}
+		entries = append(entries, entry)
+	}
+	return name, entries, scanner.Err()
+}
+
+func (s *spool) remove(name string) {
+	os.Remove(name)
+}
+
+// trim drops the oldest batches while the spool exceeds maxBytes.
+func (s *spool) trim() {
+	if s.maxBytes <= 0 {
+		return
+	}
+	files := s.files()
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, name := range files {
+		if info, err := os.Stat(name); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	for i := 0; total > s.maxBytes && i < len(files)-1; i++ {
+		s.remove(files[i])
+		total -= sizes[i]
+	}
+}
@@ -0,0 +1,113 @@
+// Package zaplog provides a zap core writing to logdata.
+package zaplog
+
+import (
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"log-server/client"
+)
+
+// Options configures NewCore.
+type Options struct {
+	// Level is the minimum level logged, default zapcore.InfoLevel.
+	Level zapcore.LevelEnabler
+	// Defaults fills system, user, module and task when no field or logger
+	// name sets them; see client.Entry.WithDefaults.
+	Defaults client.Entry
+	// SyncTimeout bounds how long Sync waits for buffered entries, default 5s.
+	SyncTimeout time.Duration
+}
+
+// Core is a zapcore.Core writing entries to a client.Logger. The logger name
+// becomes the module, and fields named like LogData columns (see
+// client.Entry.Set) set those columns; others go to fields.
+type Core struct {
+	logger client.Logger
+	opts   Options
+	fields []zapcore.Field
+}
+
+// NewCore returns a core writing to l, e.g.
+// zap.New(zapcore.NewTee(existingCore, zaplog.NewCore(async, nil))).
+func NewCore(l client.Logger, opts *Options) *Core {
+	c := &Core{logger: l}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.Level == nil {
+		c.opts.Level = zapcore.InfoLevel
+	}
+	if c.opts.SyncTimeout <= 0 {
+		c.opts.SyncTimeout = 5 * time.Second
+	}
+	return c
+}
+
+func (c *Core) Enabled(level zapcore.Level) bool {
+	return c.opts.Level.Enabled(level)
+}
+
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	c2 := *c
+	c2.fields = append(append([]zapcore.Field{}, c.fields...), fields...)
+	return &c2
+}
+
+func (c *Core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *Core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	entry := client.Entry{
+		Timestamp:  ent.Time.UTC(),
+		Msg:        ent.Message,
+		Level:      Level(ent.Level),
+		Module:     ent.LoggerName,
+		StackTrace: ent.Stack,
+	}
+	if ent.Caller.Defined {
+		entry.Set("caller", ent.Caller.TrimmedPath())
+	}
+	for k, v := range enc.Fields {
+		entry.Set(k, v)
+	}
+	err := c.logger.Log(entry.WithDefaults(c.opts.Defaults))
+	if ent.Level > zapcore.ErrorLevel {
+		// The process may be about to exit
+		c.Sync()
+	}
+	return err
+}
+
+// Sync flushes buffered entries.
+func (c *Core) Sync() error {
+	return client.Flush(c.logger, c.opts.SyncTimeout)
+}
+
+// Level maps a zap level to a logdata level.
+func Level(level zapcore.Level) int {
+	switch {
+	case level < zapcore.InfoLevel:
+		return client.LevelDebug
+	case level == zapcore.InfoLevel:
+		return client.LevelInfo
+	case level == zapcore.WarnLevel:
+		return client.LevelWarn
+	case level == zapcore.ErrorLevel:
+		return client.LevelError
+	}
+	return client.LevelFatal
+}
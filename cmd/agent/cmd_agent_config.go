@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds agent settings read from environment variables.
+type Config struct {
+	// Server is the host:port of the server's gRPC LogService.
+	Server string
+	TLS    bool
+	// HTTPServer, when set, is the base URL of the server's HTTP API, and
+	// batches go to POST /logdata/batch in the binary batch format instead
+	// of the LogService.
+	HTTPServer string
+	// Token is sent as "authorization: Bearer <token>" when set.
+	Token string
+
+	// Account, System and User are set on every entry; Module overrides the
+	// module derived from the source when set.
+	Account string
+	System  string
+	User    string
+	Module  string
+
+	// Source is "journald", "files", "eventlog" or "kubernetes".
+	Source       string
+	JournalUnits []string
+	Files        []string
+
+	// EventLogChannels are the Windows Event Log channels read. Events below
+	// EventLogMinLevel, or of providers other than EventLogProviders when
+	// set, are skipped.
+	EventLogChannels     []string
+	EventLogProviders    []string
+	EventLogMinLevel     int
+	EventLogPollInterval time.Duration
+
+	// KubernetesLogDir holds the kubelet's container log links. Entries of
+	// the namespaces in KubernetesAccounts go to the mapped account, others
+	// to Account. KubernetesLabels adds pod labels from the API server.
+	KubernetesLogDir   string
+	KubernetesAccounts map[string]string
+	KubernetesLabels   bool
+
+	// FileScanInterval is how often the AGENT_FILES patterns are expanded.
+	FileScanInterval time.Duration
+	// MultilineStart marks the first line of an entry; other lines are
+	// appended to the previous one. Disabled when nil.
+	MultilineStart    *regexp.Regexp
+	MultilineTimeout  time.Duration
+	MultilineMaxLines int
+	// FileRegex extracts columns and fields from lines with named groups.
+	FileRegex      *regexp.Regexp
+	FileTimeFormat string
+
+	BatchSize        int
+	BatchWait        time.Duration
+	SendTimeout      time.Duration
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	// SpoolDir holds batches that could not be sent, plus source checkpoints.
+	SpoolDir      string
+	SpoolMaxBytes int64
+}
+
+// loadConfig reads the agent configuration from the environment.
+func loadConfig() (*Config, error) {
+	hostname, _ := os.Hostname()
+	cfg := &Config{
+		Server:       envString("AGENT_SERVER", "localhost:50051"),
+		HTTPServer:   os.Getenv("AGENT_HTTP_SERVER"),
+		Token:        os.Getenv("AGENT_TOKEN"),
+		Account:      os.Getenv("AGENT_ACCOUNT"),
+		System:       envString("AGENT_SYSTEM", hostname),
+		User:         envString("AGENT_USER", "agent"),
+		Module:       os.Getenv("AGENT_MODULE"),
+		Source:       envString("AGENT_SOURCE", "journald"),
+		JournalUnits: envList("AGENT_JOURNAL_UNITS"),
+		Files:        envList("AGENT_FILES"),
+		SpoolDir:     envString("AGENT_SPOOL_DIR", "agent-spool"),
+
+		FileTimeFormat: envString("AGENT_FILE_TIME_FORMAT", time.RFC3339),
+
+		EventLogChannels:  envList("AGENT_EVENTLOG_CHANNELS"),
+		EventLogProviders: envList("AGENT_EVENTLOG_PROVIDERS"),
+		EventLogMinLevel:  parseLevel(os.Getenv("AGENT_EVENTLOG_MIN_LEVEL"), LevelInfo),
+
+		KubernetesLogDir: envString("AGENT_KUBERNETES_LOG_DIR", "/var/log/containers"),
+	}
+	if cfg.Account == "" {
+		return nil, fmt.Errorf("AGENT_ACCOUNT is required")
+	}
+	if cfg.System == "" {
+		return nil, fmt.Errorf("AGENT_SYSTEM is required when the hostname is unknown")
+	}
+	switch cfg.Source {
+	case "journald":
+	case "files":
+		if len(cfg.Files) == 0 {
+			return nil, fmt.Errorf("AGENT_FILES is required with AGENT_SOURCE=files")
+		}
+	case "eventlog":
+		if runtime.GOOS != "windows" {
+			return nil, fmt.Errorf("AGENT_SOURCE=eventlog is only supported on Windows")
+		}
+		if len(cfg.EventLogChannels) == 0 {
+			cfg.EventLogChannels = []string{"Application", "System"}
+		}
+	case "kubernetes":
+		cfg.Files = []string{filepath.Join(cfg.KubernetesLogDir, "*.log")}
+	default:
+		return nil, fmt.Errorf("AGENT_SOURCE must be journald, files, eventlog or kubernetes, got %q", cfg.Source)
+	}
+
+	var err error
+	if cfg.KubernetesAccounts, err = envMap("AGENT_KUBERNETES_ACCOUNTS"); err != nil {
+		return nil, err
+	}
+	if cfg.KubernetesLabels, err = envBool("AGENT_KUBERNETES_LABELS", true); err != nil {
+		return nil, err
+	}
+	if cfg.TLS, err = envBool("AGENT_TLS", false); err != nil {
+		return nil, err
+	}
+	if cfg.BatchSize, err = envInt("AGENT_BATCH_SIZE", 500); err != nil {
+		return nil, err
+	}
+	if cfg.BatchSize <= 0 {
+		return nil, fmt.Errorf("AGENT_BATCH_SIZE must be positive")
+	}
+	if cfg.BatchWait, err = envDuration("AGENT_BATCH_WAIT", 2*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.SendTimeout, err = envDuration("AGENT_SEND_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.RetryInterval, err = envDuration("AGENT_RETRY_INTERVAL", time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.MaxRetryInterval, err = envDuration("AGENT_MAX_RETRY_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.FileScanInterval, err = envDuration("AGENT_FILE_SCAN_INTERVAL", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.EventLogPollInterval, err = envDuration("AGENT_EVENTLOG_POLL_INTERVAL", time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.MultilineTimeout, err = envDuration("AGENT_MULTILINE_TIMEOUT", time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.MultilineMaxLines, err = envInt("AGENT_MULTILINE_MAX_LINES", 500); err != nil {
+		return nil, err
+	}
+	if cfg.MultilineStart, err = envRegexp("AGENT_MULTILINE_START"); err != nil {
+		return nil, err
+	}
+	if cfg.FileRegex, err = envRegexp("AGENT_FILE_REGEX"); err != nil {
+		return nil, err
+	}
+	spoolMax, err := envInt("AGENT_SPOOL_MAX_BYTES", 100<<20)
+	if err != nil {
+		return nil, err
+	}
+	cfg.SpoolMaxBytes = int64(spoolMax)
+	return cfg, nil
+}
+
+// envString returns an environment variable, or def when unset.
+func envString(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+// envList splits a comma-separated environment variable, dropping empty items.
+func envList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envMap parses a comma-separated list of key=value pairs from an
+// environment variable.
+func envMap(name string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, item := range envList(name) {
+		key, value, ok := strings.Cut(item, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid %s: %q is not key=value", name, item)
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// envBool parses a boolean environment variable, returning def when unset.
+func envBool(name string, def bool) (bool, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return b, nil
+}
+
+// envInt parses an integer environment variable, returning def when unset.
+func envInt(name string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return n, nil
+}
+
+// envDuration parses a duration environment variable (e.g. "5m"), returning def when unset.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return d, nil
+}
+
+// envRegexp compiles a regular expression environment variable, returning
+// nil when unset.
+func envRegexp(name string) (*regexp.Regexp, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return re, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"log-server/proto/logdatapb"
+)
+
+// eventLogQueryMax bounds the events read from a channel per query; a full
+// answer is followed by the next query at once.
+const eventLogQueryMax = 500
+
+// eventLogSource polls Windows Event Log channels through wevtutil every
+// EventLogPollInterval. Each channel resumes after its checkpointed record
+// id, the channel's bookmark, or, on first start, from new events only.
+type eventLogSource struct {
+	cfg         *Config
+	checkpoints *checkpoints
+	// last is the record id of the last event read per channel.
+	last map[string]uint64
+}
+
+// eventLogCheckpoint is the checkpoint key of a channel's bookmark.
+func eventLogCheckpoint(channel string) string {
+	return "eventlog:" + channel
+}
+
+func (e *eventLogSource) run(ctx context.Context, out chan<- record) error {
+	e.last = map[string]uint64{}
+	for _, channel := range e.cfg.EventLogChannels {
+		if pos := e.checkpoints.get(eventLogCheckpoint(channel)); pos != "" {
+			id, err := strconv.ParseUint(pos, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid bookmark of channel %s: %q", channel, pos)
+			}
+			e.last[channel] = id
+			continue
+		}
+		id, err := e.latest(ctx, channel)
+		if err != nil {
+			return err
+		}
+		e.last[channel] = id
+	}
+
+	for {
+		for _, channel := range e.cfg.EventLogChannels {
+			if err := e.poll(ctx, channel, out); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				slog.Warn("Failed to read event log", "channel", channel, "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.cfg.EventLogPollInterval):
+		}
+	}
+}
+
+// latest returns the record id of the newest event of channel, 0 when empty.
+func (e *eventLogSource) latest(ctx context.Context, channel string) (uint64, error) {
+	events, err := queryEventLog(ctx, channel, "*", 1, true)
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+	return events[0].System.EventRecordID, nil
+}
+
+// poll sends the events of channel after its last record id until none is
+// left or ctx is done.
+func (e *eventLogSource) poll(ctx context.Context, channel string, out chan<- record) error {
+	for {
+		events, err := queryEventLog(ctx, channel, fmt.Sprintf("*[System[EventRecordID>%d]]", e.last[channel]), eventLogQueryMax, false)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			e.last[channel] = event.System.EventRecordID
+			rec := record{key: eventLogCheckpoint(channel), position: strconv.FormatUint(event.System.EventRecordID, 10)}
+			if rec.entry = eventLogEntry(e.cfg, event); rec.entry == nil {
+				// Skipped events still move the bookmark once later ones are sent
+				continue
+			}
+			select {
+			case out <- rec:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if len(events) < eventLogQueryMax {
+			return nil
+		}
+	}
+}
+
+// queryEventLog runs an XPath query on channel with wevtutil, returning up
+// to count events with their rendered messages, oldest first unless
+// reverse.
+func queryEventLog(ctx context.Context, channel, query string, count int, reverse bool) ([]winEvent, error) {
+	args := []string{"qe", channel, "/q:" + query, "/c:" + strconv.Itoa(count), "/f:RenderedXml", "/e:Events"}
+	if reverse {
+		args = append(args, "/rd:true")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "wevtutil", args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("wevtutil failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var events struct {
+		Events []winEvent `xml:"Event"`
+	}
+	if err := xml.Unmarshal(output, &events); err != nil {
+		return nil, fmt.Errorf("invalid wevtutil output: %v", err)
+	}
+	return events.Events, nil
+}
+
+// winEvent is an event as wevtutil renders it in XML.
+type winEvent struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		}
+		EventID     uint32 `xml:"EventID"`
+		Level       int    `xml:"Level"`
+		TimeCreated struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		}
+		EventRecordID uint64 `xml:"EventRecordID"`
+		Execution     struct {
+			ProcessID uint32 `xml:"ProcessID,attr"`
+		}
+		Channel  string `xml:"Channel"`
+		Computer string `xml:"Computer"`
+		Security struct {
+			UserID string `xml:"UserID,attr"`
+		}
+	}
+	EventData struct {
+		Data []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Data"`
+	}
+	RenderingInfo struct {
+		Message string `xml:"Message"`
+	}
+}
+
+// eventLogEntry maps an event onto LogData: the provider is the module, the
+// channel the task, and the level sets the level. Events below
+// EventLogMinLevel or of providers not in EventLogProviders are skipped.
+func eventLogEntry(cfg *Config, event winEvent) *logdatapb.LogEntry {
+	sys := event.System
+	level := eventLogLevel(sys.Level)
+	if level < cfg.EventLogMinLevel {
+		return nil
+	}
+	if len(cfg.EventLogProviders) > 0 && !containsFold(cfg.EventLogProviders, sys.Provider.Name) {
+		return nil
+	}
+	ts, err := time.Parse(time.RFC3339Nano, sys.TimeCreated.SystemTime)
+	if err != nil {
+		ts = time.Now()
+	}
+
+	fields := map[string]any{
+		"event_id":  strconv.FormatUint(uint64(sys.EventID), 10),
+		"record_id": strconv.FormatUint(sys.EventRecordID, 10),
+		"computer":  sys.Computer,
+	}
+	if sys.Execution.ProcessID != 0 {
+		fields["pid"] = strconv.FormatUint(uint64(sys.Execution.ProcessID), 10)
+	}
+	if sys.Security.UserID != "" {
+		fields["user_sid"] = sys.Security.UserID
+	}
+	data := map[string]any{}
+	var values []string
+	for i, d := range event.EventData.Data {
+		name := d.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		data[name] = d.Value
+		if d.Value != "" {
+			values = append(values, d.Value)
+		}
+	}
+	if len(data) > 0 {
+		fields["event_data"] = data
+	}
+
+	// Without the provider's message file installed there is no rendered
+	// message; the event data is the closest to one
+	msg := strings.TrimSpace(event.RenderingInfo.Message)
+	if msg == "" {
+		msg = strings.Join(values, " ")
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("Event %d", sys.EventID)
+	}
+	return newEntry(cfg, firstNonEmpty(sys.Provider.Name, "eventlog"), firstNonEmpty(sys.Channel, "eventlog"), ts, msg, level, fields)
+}
+
+// eventLogLevel maps an event level (1 critical .. 5 verbose, 0 for events
+// logged always, such as audits) to a level.
+func eventLogLevel(level int) int {
+	switch level {
+	case 1:
+		return LevelFatal
+	case 2:
+		return LevelError
+	case 3:
+		return LevelWarn
+	case 5:
+		return LevelDebug
+	}
+	return LevelInfo
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
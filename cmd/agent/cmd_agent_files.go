@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"log-server/proto/logdatapb"
+)
+
+// pollInterval is how often tailed files are checked for new data.
+const pollInterval = 250 * time.Millisecond
+
+// fileSource tails the files matching AGENT_FILES, rescanning the patterns
+// for new files every FileScanInterval. Each file resumes from its
+// checkpoint; without one, files present at startup are read from their end
+// and files appearing later from the start.
+type fileSource struct {
+	cfg         *Config
+	checkpoints *checkpoints
+	// kubernetes, set for AGENT_SOURCE=kubernetes, decodes container logs.
+	kubernetes *kubernetesMeta
+
+	mu      sync.Mutex
+	tailing map[string]bool
+}
+
+func (f *fileSource) run(ctx context.Context, out chan<- record) error {
+	f.tailing = map[string]bool{}
+	var wg sync.WaitGroup
+	startup := true
+	for {
+		for _, path := range f.match() {
+			f.mu.Lock()
+			started := f.tailing[path]
+			f.tailing[path] = true
+			f.mu.Unlock()
+			if started {
+				continue
+			}
+			fromEnd := startup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f.tail(ctx, path, fromEnd, out)
+				f.mu.Lock()
+				delete(f.tailing, path)
+				f.mu.Unlock()
+			}()
+		}
+		startup = false
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case <-time.After(f.cfg.FileScanInterval):
+		}
+	}
+}
+
+// match expands the AGENT_FILES patterns.
+func (f *fileSource) match() []string {
+	seen := map[string]bool{}
+	var paths []string
+	for _, pattern := range f.cfg.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			slog.Warn("Invalid file pattern", "pattern", pattern, "err", err)
+			continue
+		}
+		for _, path := range matches {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// tail follows path across rotations until ctx is done or the path is gone.
+func (f *fileSource) tail(ctx context.Context, path string, fromEnd bool, out chan<- record) {
+	for f.follow(ctx, path, fromEnd, out) {
+		if _, err := os.Stat(path); err != nil {
+			return
+		}
+		fromEnd = false
+	}
+}
+
+// tailState is the read position in one opened file and the entry being
+// assembled from its lines.
+type tailState struct {
+	inode   uint64
+	offset  int64
+	partial string
+	lines   []string
+	// position is the offset after the last line in lines.
+	position int64
+	lastRead time.Time
+}
+
+// follow reads one opened file until it is rotated away, then reports true.
+// It reports false when ctx is done or the file cannot be opened.
+func (f *fileSource) follow(ctx context.Context, path string, fromEnd bool, out chan<- record) bool {
+	logger := slog.Default().With("path", path)
+	file, err := os.Open(path)
+	if err != nil {
+		logger.Warn("Failed to open file", "err", err)
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		logger.Warn("Failed to open file", "err", err)
+		return false
+	}
+
+	key := "file:" + path
+	state := &tailState{inode: fileInode(info), lastRead: time.Now()}
+	var inode uint64
+	var offset int64
+	if _, err := fmt.Sscanf(f.checkpoints.get(key), "%d:%d", &inode, &offset); err == nil {
+		// The checkpoint only applies to the same file, not one rotated into place
+		if inode == state.inode && offset <= info.Size() {
+			state.offset = offset
+		}
+	} else if fromEnd {
+		state.offset = info.Size()
+	}
+	if _, err := file.Seek(state.offset, io.SeekStart); err != nil {
+		logger.Warn("Failed to seek file", "err", err)
+		return false
+	}
+	reader := bufio.NewReader(file)
+	logger.Info("Tailing file", "offset", state.offset)
+
+	emit := func() bool {
+		if len(state.lines) == 0 {
+			return true
+		}
+		rec := record{
+			entry:    f.entry(path, state.lines),
+			key:      key,
+			position: fmt.Sprintf("%d:%d", state.inode, state.position),
+		}
+		state.lines = nil
+		select {
+		case out <- rec:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		for {
+			line, err := reader.ReadString('\n')
+			state.offset += int64(len(line))
+			if err != nil {
+				// Keep an unterminated line until the rest is written
+				state.partial += line
+				break
+			}
+			line = strings.TrimRight(state.partial+line, "\r\n")
+			state.partial = ""
+			state.lastRead = time.Now()
+			if !f.continues(state.lines, line) && !emit() {
+				return false
+			}
+			state.lines = append(state.lines, line)
+			state.position = state.offset
+		}
+		// A multiline entry is complete once its file has been idle long enough
+		if len(state.lines) > 0 && time.Since(state.lastRead) >= f.cfg.MultilineTimeout && !emit() {
+			return false
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(pollInterval):
+		}
+
+		current, err := os.Stat(path)
+		switch {
+		case err != nil || !os.SameFile(current, info):
+			// Rotated: the old file was fully read above, switch to the new one
+			logger.Info("File rotated")
+			return emit()
+		case current.Size() < state.offset:
+			logger.Info("File truncated")
+			if !emit() {
+				return false
+			}
+			file.Seek(0, io.SeekStart)
+			reader.Reset(file)
+			state.offset, state.partial = 0, ""
+		}
+	}
+}
+
+// continues reports whether line belongs to the entry in lines, which is
+// the case when AGENT_MULTILINE_START is set and line does not match it.
+func (f *fileSource) continues(lines []string, line string) bool {
+	if f.kubernetes != nil {
+		return f.kubernetes.continues(lines, line)
+	}
+	if len(lines) == 0 || f.cfg.MultilineStart == nil {
+		return false
+	}
+	return len(lines) < f.cfg.MultilineMaxLines && !f.cfg.MultilineStart.MatchString(line)
+}
+
+// entry builds the entry for the lines of one record of path. The first line
+// is matched against AGENT_FILE_REGEX: the named groups msg, level,
+// timestamp, module and task set those columns and other groups are kept in
+// Fields. Continuation lines become the stack trace. Container logs are
+// decoded first.
+func (f *fileSource) entry(path string, lines []string) *logdatapb.LogEntry {
+	fields := map[string]any{"path": path}
+	ts, defModule, defTask, account := time.Now(), filepath.Base(path), "file", f.cfg.Account
+	if f.kubernetes != nil {
+		lines, ts, defModule, defTask, account = f.kubernetes.decode(path, lines, fields)
+	}
+	msg, level := lines[0], LevelInfo
+	var module, task string
+	if re := f.cfg.FileRegex; re != nil {
+		if m := re.FindStringSubmatch(lines[0]); m != nil {
+			for i, name := range re.SubexpNames() {
+				if name == "" || m[i] == "" {
+					continue
+				}
+				switch name {
+				case "msg":
+					msg = m[i]
+				case "level":
+					level = parseLevel(m[i], LevelInfo)
+				case "timestamp":
+					if t, err := time.Parse(f.cfg.FileTimeFormat, m[i]); err == nil {
+						ts = t
+					}
+				case "module":
+					module = m[i]
+				case "task":
+					task = m[i]
+				default:
+					fields[name] = m[i]
+				}
+			}
+		}
+	}
+
+	entry := newEntry(f.cfg, defModule, firstNonEmpty(task, defTask), ts, msg, level, fields)
+	entry.Account = account
+	if module != "" {
+		entry.Module = module
+	}
+	if len(lines) > 1 {
+		entry.StackTrace = strings.Join(lines[1:], "\n")
+	}
+	return entry
+}
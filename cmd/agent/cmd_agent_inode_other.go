@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileInode is unavailable here; checkpoints then only check the offset.
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"log-server/proto/logdatapb"
+)
+
+// journalCheckpoint is the checkpoint key of the journal cursor.
+const journalCheckpoint = "journald"
+
+// journalFields are journal fields kept in LogData.Fields, by field name.
+var journalFields = map[string]string{
+	"_HOSTNAME":         "hostname",
+	"_PID":              "pid",
+	"_UID":              "uid",
+	"_SYSTEMD_UNIT":     "unit",
+	"SYSLOG_IDENTIFIER": "identifier",
+	"_BOOT_ID":          "boot_id",
+}
+
+// journalSource follows the systemd journal through journalctl, resuming
+// after the checkpointed cursor or, on first start, from new entries only.
+type journalSource struct {
+	cfg         *Config
+	checkpoints *checkpoints
+}
+
+func (j *journalSource) run(ctx context.Context, out chan<- record) error {
+	args := []string{"--follow", "--output=json", "--no-pager"}
+	if cursor := j.checkpoints.get(journalCheckpoint); cursor != "" {
+		args = append(args, "--after-cursor="+cursor)
+	} else {
+		args = append(args, "--lines=0")
+	}
+	for _, unit := range j.cfg.JournalUnits {
+		args = append(args, "--unit="+unit)
+	}
+
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start journalctl: %v", err)
+	}
+	// Unblock the scanner on shutdown even if the output stays open
+	stop := context.AfterFunc(ctx, func() { stdout.Close() })
+	defer stop()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var fields map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			slog.Warn("Skipping invalid journal entry", "err", err)
+			continue
+		}
+		rec := record{key: journalCheckpoint, position: journalString(fields["__CURSOR"])}
+		if rec.entry = journalEntry(j.cfg, fields); rec.entry == nil {
+			continue
+		}
+		select {
+		case out <- rec:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	return fmt.Errorf("journalctl exited: %v", err)
+}
+
+// journalEntry maps a journal entry onto LogData: the syslog identifier is
+// the module and the systemd unit the task. Entries without a message are
+// skipped.
+func journalEntry(cfg *Config, fields map[string]any) *logdatapb.LogEntry {
+	msg := journalString(fields["MESSAGE"])
+	if msg == "" {
+		return nil
+	}
+	ts := time.Now()
+	if us, err := strconv.ParseInt(journalString(fields["__REALTIME_TIMESTAMP"]), 10, 64); err == nil {
+		ts = time.UnixMicro(us)
+	}
+	extra := map[string]any{}
+	for field, name := range journalFields {
+		if v := journalString(fields[field]); v != "" {
+			extra[name] = v
+		}
+	}
+	module := firstNonEmpty(journalString(fields["SYSLOG_IDENTIFIER"]), journalString(fields["_COMM"]), "journald")
+	task := firstNonEmpty(journalString(fields["_SYSTEMD_UNIT"]), "journald")
+	return newEntry(cfg, module, task, ts, msg, journalLevel(journalString(fields["PRIORITY"])), extra)
+}
+
+// journalString returns a journal field as a string. journalctl encodes
+// binary values as byte arrays and repeated fields as arrays of strings.
+func journalString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []any:
+		if len(t) == 0 {
+			return ""
+		}
+		if s, ok := t[0].(string); ok {
+			return s
+		}
+		b := make([]byte, 0, len(t))
+		for _, c := range t {
+			if n, ok := c.(float64); ok {
+				b = append(b, byte(n))
+			}
+		}
+		return string(b)
+	}
+	return ""
+}
+
+// journalLevel maps a syslog priority (0 emerg .. 7 debug) to a level.
+func journalLevel(priority string) int {
+	n, err := strconv.Atoi(priority)
+	if err != nil {
+		return LevelInfo
+	}
+	switch {
+	case n <= 2:
+		return LevelFatal
+	case n == 3:
+		return LevelError
+	case n == 4:
+		return LevelWarn
+	case n == 7:
+		return LevelDebug
+	}
+	return LevelInfo
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into pods.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// podLabelsTTL is how long the labels of a pod are cached.
+const podLabelsTTL = 5 * time.Minute
+
+// containerLogName matches the kubelet's /var/log/containers file names,
+// <pod>_<namespace>_<container>-<container id>.log.
+var containerLogName = regexp.MustCompile(`^([^_]+)_([^_]+)_(.+)-([0-9a-f]{64})\.log$`)
+
+// kubernetesMeta turns container runtime lines into entries for
+// AGENT_SOURCE=kubernetes: it strips the runtime framing, joins lines the
+// runtime split, and adds the pod's metadata and account.
+type kubernetesMeta struct {
+	cfg    *Config
+	labels *podLabels
+}
+
+func newKubernetesMeta(cfg *Config) *kubernetesMeta {
+	k := &kubernetesMeta{cfg: cfg}
+	if cfg.KubernetesLabels {
+		labels, err := newPodLabels()
+		if err != nil {
+			slog.Warn("Pod labels disabled", "err", err)
+		}
+		k.labels = labels
+	}
+	return k
+}
+
+// containerLine is one line of a container log as the runtime wrote it.
+type containerLine struct {
+	time    time.Time
+	stream  string
+	content string
+	// partial is set when the runtime split a longer line and content is
+	// continued by the next line.
+	partial bool
+}
+
+// parseContainerLine parses the CRI format (containerd, CRI-O), "<time>
+// <stream> <P|F> <content>", or Docker's json-file format. Other lines are
+// kept as they are.
+func parseContainerLine(line string) containerLine {
+	if strings.HasPrefix(line, "{") {
+		var l struct {
+			Log    string    `json:"log"`
+			Stream string    `json:"stream"`
+			Time   time.Time `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(line), &l); err == nil {
+			content := strings.TrimSuffix(l.Log, "\n")
+			return containerLine{time: l.Time, stream: l.Stream, content: strings.TrimSuffix(content, "\r"), partial: !strings.HasSuffix(l.Log, "\n")}
+		}
+	}
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) == 4 && (parts[2] == "P" || parts[2] == "F") {
+		if ts, err := time.Parse(time.RFC3339Nano, parts[0]); err == nil {
+			return containerLine{time: ts, stream: parts[1], content: parts[3], partial: parts[2] == "P"}
+		}
+	}
+	return containerLine{time: time.Now(), content: line}
+}
+
+// continues reports whether line belongs to the entry in lines, up to
+// AGENT_MULTILINE_MAX_LINES: it does when the runtime split the previous
+// line, or when AGENT_MULTILINE_START is set and the content of line does not
+// match it.
+func (k *kubernetesMeta) continues(lines []string, line string) bool {
+	if len(lines) == 0 {
+		return false
+	}
+	if len(lines) >= k.cfg.MultilineMaxLines {
+		return false
+	}
+	if parseContainerLine(lines[len(lines)-1]).partial {
+		return true
+	}
+	return k.cfg.MultilineStart != nil && !k.cfg.MultilineStart.MatchString(parseContainerLine(line).content)
+}
+
+// decode strips the runtime framing of lines, joining split ones, and adds
+// the pod's metadata to fields. It returns the content lines, the time of
+// the first one, the container as the module, the pod as the task, and the
+// namespace's account.
+func (k *kubernetesMeta) decode(path string, lines []string, fields map[string]any) (content []string, ts time.Time, module, task, account string) {
+	var current strings.Builder
+	for i, raw := range lines {
+		line := parseContainerLine(raw)
+		if i == 0 {
+			ts = line.time
+			if line.stream != "" {
+				fields["stream"] = line.stream
+			}
+		}
+		current.WriteString(line.content)
+		if !line.partial {
+			content = append(content, current.String())
+			current.Reset()
+		}
+	}
+	if current.Len() > 0 {
+		content = append(content, current.String())
+	}
+
+	account = k.cfg.Account
+	m := containerLogName.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return content, ts, filepath.Base(path), "kubernetes", account
+	}
+	pod, namespace, container := m[1], m[2], m[3]
+	fields["pod"] = pod
+	fields["namespace"] = namespace
+	fields["container"] = container
+	fields["container_id"] = m[4]
+	if labels := k.labels.get(namespace, pod); len(labels) > 0 {
+		fields["labels"] = labels
+	}
+	if a, ok := k.cfg.KubernetesAccounts[namespace]; ok {
+		account = a
+	}
+	return content, ts, container, pod, account
+}
+
+// podLabels fetches and caches pod labels from the API server, using the
+// agent pod's service account, which must be allowed to get pods.
+type podLabels struct {
+	client *http.Client
+	base   string
+
+	mu    sync.Mutex
+	cache map[string]cachedLabels
+}
+
+type cachedLabels struct {
+	labels  map[string]any
+	fetched time.Time
+}
+
+// newPodLabels configures access to the API server from within the cluster.
+func newPodLabels() (*podLabels, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster")
+	}
+	if _, err := os.Stat(filepath.Join(serviceAccountDir, "token")); err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid %s", filepath.Join(serviceAccountDir, "ca.crt"))
+	}
+	return &podLabels{
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		base:  "https://" + net.JoinHostPort(host, port),
+		cache: map[string]cachedLabels{},
+	}, nil
+}
+
+// get returns the labels of a pod, nil when they cannot be fetched. A nil
+// podLabels has no labels.
+func (p *podLabels) get(namespace, pod string) map[string]any {
+	if p == nil {
+		return nil
+	}
+	key := namespace + "/" + pod
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.cache[key]; ok && time.Since(c.fetched) < podLabelsTTL {
+		return c.labels
+	}
+	for k, c := range p.cache {
+		if time.Since(c.fetched) >= podLabelsTTL {
+			delete(p.cache, k)
+		}
+	}
+	labels, err := p.fetch(namespace, pod)
+	if err != nil {
+		slog.Warn("Failed to fetch pod labels", "namespace", namespace, "pod", pod, "err", err)
+	}
+	p.cache[key] = cachedLabels{labels: labels, fetched: time.Now()}
+	return labels
+}
+
+func (p *podLabels) fetch(namespace, pod string) (map[string]any, error) {
+	// The kubelet rotates the token, so it is read again for every request
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, p.base+"/api/v1/namespaces/"+url.PathEscape(namespace)+"/pods/"+url.PathEscape(pod), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API server answered %s", resp.Status)
+	}
+	var body struct {
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	labels := make(map[string]any, len(body.Metadata.Labels))
+	for k, v := range body.Metadata.Labels {
+		labels[k] = v
+	}
+	return labels, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"log-server/proto/logdatapb"
+)
+
+// record is one entry read by a source. When key is set, position is the
+// source position after the entry and is checkpointed once the entry has
+// been sent or spooled.
+type record struct {
+	entry    *logdatapb.LogEntry
+	key      string
+	position string
+}
+
+// source reads entries until ctx is done or it fails.
+type source interface {
+	run(ctx context.Context, out chan<- record) error
+}
+
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		slog.Info("No .env file found, using environment variables")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+
+	creds := insecure.NewCredentials()
+	if cfg.TLS {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	conn, err := grpc.NewClient(cfg.Server, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		fatal("Failed to create gRPC client", "err", err)
+	}
+	defer conn.Close()
+
+	spool, err := openSpool(cfg.SpoolDir, cfg.SpoolMaxBytes)
+	if err != nil {
+		fatal("Failed to open spool", "err", err)
+	}
+	cp, err := loadCheckpoints(filepath.Join(cfg.SpoolDir, "checkpoints.json"))
+	if err != nil {
+		fatal("Failed to load checkpoints", "err", err)
+	}
+
+	var src source
+	switch cfg.Source {
+	case "journald":
+		src = &journalSource{cfg: cfg, checkpoints: cp}
+	case "files":
+		src = &fileSource{cfg: cfg, checkpoints: cp}
+	case "eventlog":
+		src = &eventLogSource{cfg: cfg, checkpoints: cp}
+	case "kubernetes":
+		src = &fileSource{cfg: cfg, checkpoints: cp, kubernetes: newKubernetesMeta(cfg)}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go cp.run(ctx, time.Second)
+
+	ship := newShipper(cfg, logdatapb.NewLogServiceClient(conn), spool, cp)
+	records := make(chan record, cfg.BatchSize)
+	done := make(chan struct{})
+	go func() {
+		ship.run(ctx, records)
+		close(done)
+	}()
+
+	server := cfg.Server
+	if cfg.HTTPServer != "" {
+		server = cfg.HTTPServer
+	}
+	slog.Info("Starting agent", "source", cfg.Source, "server", server, "account", cfg.Account)
+	err = src.run(ctx, records)
+	close(records)
+	<-done
+	if err := cp.save(); err != nil {
+		slog.Error("Failed to save checkpoints", "err", err)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fatal("Source failed", "err", err)
+	}
+	slog.Info("Agent stopped")
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// newEntry builds an entry with the configured account, system and user.
+func newEntry(cfg *Config, module, task string, ts time.Time, msg string, level int, fields map[string]any) *logdatapb.LogEntry {
+	if cfg.Module != "" {
+		module = cfg.Module
+	}
+	entry := &logdatapb.LogEntry{
+		Account:   cfg.Account,
+		System:    cfg.System,
+		User:      cfg.User,
+		Module:    module,
+		Task:      task,
+		Timestamp: timestamppb.New(ts),
+		Msg:       msg,
+		Level:     int32(level),
+	}
+	if len(fields) > 0 {
+		if s, err := structpb.NewStruct(fields); err == nil {
+			entry.Fields = s
+		}
+	}
+	return entry
+}
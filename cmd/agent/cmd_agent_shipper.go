@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"log-server/client"
+	"log-server/proto/logdatapb"
+)
+
+// shipper batches records and sends each batch over one PushLogStream call,
+// or one POST /logdata/batch request with AGENT_HTTP_SERVER.
+// Batches that cannot be sent are spooled and replayed, oldest first, with
+// exponential backoff until the server accepts them. Each entry carries a
+// source_seq unique to this run, so the server ignores replays of batches it
+// had stored before the send failed.
+type shipper struct {
+	cfg         *Config
+	client      logdatapb.LogServiceClient
+	http        *http.Client
+	spool       *spool
+	checkpoints *checkpoints
+	wake        chan struct{}
+	// runID and seq make up the source_seq of the next entry.
+	runID string
+	seq   uint64
+}
+
+func newShipper(cfg *Config, client logdatapb.LogServiceClient, spool *spool, cp *checkpoints) *shipper {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &shipper{cfg: cfg, client: client, http: &http.Client{}, spool: spool, checkpoints: cp, wake: make(chan struct{}, 1), runID: hex.EncodeToString(b)}
+}
+
+// run batches records until the channel is closed, flushing a batch when it
+// reaches BatchSize or BatchWait after its first record.
+func (s *shipper) run(ctx context.Context, records <-chan record) {
+	go s.replay(ctx)
+
+	timer := time.NewTimer(s.cfg.BatchWait)
+	timer.Stop()
+	var batch []record
+	flush := func() {
+		if len(batch) > 0 {
+			s.deliver(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case rec, ok := <-records:
+			if !ok {
+				timer.Stop()
+				flush()
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(s.cfg.BatchWait)
+			}
+			batch = append(batch, rec)
+			if len(batch) >= s.cfg.BatchSize {
+				timer.Stop()
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// deliver sends batch, or spools it when the server is unreachable or older
+// batches are still waiting, then checkpoints its source positions.
+func (s *shipper) deliver(batch []record) {
+	entries := make([]*logdatapb.LogEntry, len(batch))
+	for i, rec := range batch {
+		s.seq++
+		rec.entry.SourceSeq = fmt.Sprintf("%s-%s-%d", s.cfg.System, s.runID, s.seq)
+		entries[i] = rec.entry
+	}
+
+	sent := false
+	if s.spool.empty() {
+		if err := s.send(entries); err != nil {
+			slog.Warn("Failed to send batch, spooling", "entries", len(entries), "err", err)
+		} else {
+			sent = true
+		}
+	}
+	if !sent {
+		if err := s.spool.write(entries); err != nil {
+			// Leave the checkpoint behind so the entries are read again
+			slog.Error("Failed to spool batch", "entries", len(entries), "err", err)
+			return
+		}
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	for _, rec := range batch {
+		if rec.key != "" {
+			s.checkpoints.set(rec.key, rec.position)
+		}
+	}
+}
+
+// replay sends spooled batches until ctx is done.
+func (s *shipper) replay(ctx context.Context) {
+	backoff := s.cfg.RetryInterval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-time.After(backoff):
+		}
+		for {
+			name, entries, err := s.spool.oldest()
+			if name == "" {
+				backoff = s.cfg.RetryInterval
+				break
+			}
+			if err != nil {
+				slog.Error("Dropping unreadable spool file", "file", name, "err", err)
+				s.spool.remove(name)
+				continue
+			}
+			if err := s.send(entries); err != nil {
+				backoff = min(backoff*2, s.cfg.MaxRetryInterval)
+				slog.Warn("Failed to send spooled batch", "file", name, "retry_in", backoff, "err", err)
+				break
+			}
+			s.spool.remove(name)
+			slog.Info("Sent spooled batch", "file", name, "entries", len(entries))
+			backoff = s.cfg.RetryInterval
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// send pushes entries over one stream per account, as the server takes the
+// account of a stream from its metadata. Entries the server rejects (invalid
+// or over quota) are logged and not retried. When a later stream fails, the
+// whole batch is retried, and the source_seq of the entries already stored
+// skips them.
+func (s *shipper) send(entries []*logdatapb.LogEntry) error {
+	var accounts []string
+	byAccount := map[string][]*logdatapb.LogEntry{}
+	for _, entry := range entries {
+		account := entry.GetAccount()
+		if _, ok := byAccount[account]; !ok {
+			accounts = append(accounts, account)
+		}
+		byAccount[account] = append(byAccount[account], entry)
+	}
+	for _, account := range accounts {
+		if err := s.sendAccount(account, byAccount[account]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendAccount pushes the entries of account over one stream, or one HTTP
+// request.
+func (s *shipper) sendAccount(account string, entries []*logdatapb.LogEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.SendTimeout)
+	defer cancel()
+	if s.cfg.HTTPServer != "" {
+		return s.sendHTTP(ctx, account, entries)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-account", account)
+	if s.cfg.Token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+s.cfg.Token)
+	}
+
+	stream, err := s.client.PushLogStream(ctx)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		// A failed Send means the stream broke; CloseAndRecv reports why
+		if err := stream.Send(&logdatapb.PushLogRequest{Entry: entry}); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+			break
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if resp.GetRejected() > 0 {
+		rejections := make([]string, len(resp.GetRejections()))
+		for i, rejection := range resp.GetRejections() {
+			rejections[i] = fmt.Sprintf("%d: %s", rejection.GetIndex(), rejection.GetError())
+		}
+		slog.Warn("Server rejected entries", "accepted", resp.GetAccepted(), "rejected", resp.GetRejected(), "errors", rejections)
+	}
+	return nil
+}
+
+// sendHTTP posts the entries of account as one zstd compressed binary
+// batch. Rejected entries are logged and not retried, as over gRPC.
+func (s *shipper) sendHTTP(ctx context.Context, account string, entries []*logdatapb.LogEntry) error {
+	c := client.New(client.Config{Server: s.cfg.HTTPServer, Account: account, Token: s.cfg.Token, HTTPClient: s.http})
+	res, err := c.PushEntries(ctx, entries)
+	if err != nil {
+		return err
+	}
+	if res.Rejected > 0 {
+		slog.Warn("Server rejected entries", "stored", res.Stored, "rejected", res.Rejected, "errors", res.Errors)
+	}
+	return nil
+}
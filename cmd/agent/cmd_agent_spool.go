@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"log-server/proto/logdatapb"
+)
+
+// spool stores unsent batches as NDJSON files named by creation time, so
+// they sort oldest first. The oldest are dropped once maxBytes is exceeded.
+type spool struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+	seq      int
+}
+
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &spool{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// files lists spooled batches, oldest first.
+func (s *spool) files() []string {
+	matches, _ := filepath.Glob(filepath.Join(s.dir, "*.ndjson"))
+	sort.Strings(matches)
+	return matches
+}
+
+func (s *spool) empty() bool {
+	return len(s.files()) == 0
+}
+
+// write stores entries as a new batch.
+func (s *spool) write(entries []*logdatapb.LogEntry) error {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		b, err := protojson.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	s.seq++
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d-%06d.ndjson", time.Now().UnixNano(), s.seq%1000000))
+	s.mu.Unlock()
+	// Write under a temporary name so a crash never leaves a partial batch
+	if err := os.WriteFile(name+".tmp", buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	s.trim()
+	return nil
+}
+
+// oldest returns the oldest batch, or an empty name when the spool is empty.
+func (s *spool) oldest() (string, []*logdatapb.LogEntry, error) {
+	files := s.files()
+	if len(files) == 0 {
+		return "", nil, nil
+	}
+	name := files[0]
+	f, err := os.Open(name)
+	if err != nil {
+		return name, nil, err
+	}
+	defer f.Close()
+	var entries []*logdatapb.LogEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry := &logdatapb.LogEntry{}
+		if err := protojson.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return name, nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return name, entries, scanner.Err()
+}
+
+func (s *spool) remove(name string) {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove spool file", "file", name, "err", err)
+	}
+}
+
+// trim drops the oldest batches while the spool exceeds maxBytes.
+func (s *spool) trim() {
+	if s.maxBytes <= 0 {
+		return
+	}
+	files := s.files()
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, name := range files {
+		if info, err := os.Stat(name); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	for i := 0; total > s.maxBytes && i < len(files)-1; i++ {
+		slog.Warn("Spool full, dropping oldest batch", "file", files[i], "max_bytes", s.maxBytes)
+		s.remove(files[i])
+		total -= sizes[i]
+	}
+}
+
+// checkpoints tracks source positions that have been sent or spooled and
+// saves them to path, so a restarted agent resumes where it stopped.
+type checkpoints struct {
+	path      string
+	mu        sync.Mutex
+	positions map[string]string
+	dirty     bool
+}
+
+func loadCheckpoints(path string) (*checkpoints, error) {
+	c := &checkpoints{path: path, positions: map[string]string{}}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &c.positions); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *checkpoints) get(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.positions[key]
+}
+
+func (c *checkpoints) set(key, position string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.positions[key] != position {
+		c.positions[key] = position
+		c.dirty = true
+	}
+}
+
+// save writes the checkpoints if they changed since the last save.
+func (c *checkpoints) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	b, err := json.Marshal(c.positions)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.path+".tmp", b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(c.path+".tmp", c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// run saves the checkpoints every interval until ctx is done.
+func (c *checkpoints) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.save(); err != nil {
+				slog.Error("Failed to save checkpoints", "err", err)
+			}
+		}
+	}
+}
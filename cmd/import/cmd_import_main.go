@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// result mirrors the server's ImportResult.
+type result struct {
+	Lines    int            `json:"lines"`
+	Imported int            `json:"imported"`
+	Skipped  int            `json:"skipped"`
+	Rejected int            `json:"rejected"`
+	Errors   map[string]int `json:"errors"`
+	Samples  []struct {
+		Line  int    `json:"line"`
+		Error string `json:"error"`
+	} `json:"samples"`
+	Error string `json:"error"`
+}
+
+// chunk is one POST /import body. lines maps each body line starting a
+// record to its line in the file, for reporting rejected lines.
+type chunk struct {
+	body    bytes.Buffer
+	records int
+	lines   map[int]int
+}
+
+type importer struct {
+	server, account, token string
+	client                 *http.Client
+
+	total   result
+	samples []string
+}
+
+func main() {
+	server := flag.String("server", "http://localhost:8080", "logdata server URL")
+	account := flag.String("account", "", "account to import into (required)")
+	token := flag.String("token", os.Getenv("IMPORT_TOKEN"), "bearer token with the ingest scope (default $IMPORT_TOKEN)")
+	format := flag.String("format", "auto", "ndjson, csv, or auto to use the file extension")
+	chunkSize := flag.Int("chunk", 10000, "records sent per request")
+	skip := flag.Int("skip", 0, "records of the first file to skip, to resume an interrupted import")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: import -account <account> [flags] file...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *account == "" || flag.NArg() == 0 || *chunkSize <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	imp := &importer{
+		server:  strings.TrimSuffix(*server, "/"),
+		account: *account,
+		token:   *token,
+		client:  &http.Client{Timeout: 10 * time.Minute},
+	}
+	start := time.Now()
+	failed := false
+	for i, path := range flag.Args() {
+		fileFormat := *format
+		if fileFormat == "auto" {
+			fileFormat = "ndjson"
+			if strings.HasSuffix(strings.TrimSuffix(path, ".gz"), ".csv") {
+				fileFormat = "csv"
+			}
+		}
+		skipRecords := 0
+		if i == 0 {
+			skipRecords = *skip
+		}
+		if err := imp.importFile(path, fileFormat, *chunkSize, skipRecords); err != nil {
+			slog.Error("Import failed", "file", path, "err", err)
+			failed = true
+			break
+		}
+	}
+
+	slog.Info("Import finished", "records", imp.total.Lines, "imported", imp.total.Imported,
+		"skipped", imp.total.Skipped, "rejected", imp.total.Rejected, "elapsed", time.Since(start).Round(time.Millisecond))
+	reasons := make([]string, 0, len(imp.total.Errors))
+	for reason := range imp.total.Errors {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return imp.total.Errors[reasons[i]] > imp.total.Errors[reasons[j]] })
+	for _, reason := range reasons {
+		fmt.Fprintf(os.Stderr, "%8d  %s\n", imp.total.Errors[reason], reason)
+	}
+	for _, sample := range imp.samples {
+		fmt.Fprintln(os.Stderr, sample)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// importFile sends path in chunks of chunkSize records, skipping the first
+// skip records.
+func (imp *importer) importFile(path, format string, chunkSize, skip int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var next func(n int) (*chunk, error)
+	switch format {
+	case "ndjson":
+		next = ndjsonChunks(r)
+	case "csv":
+		next = csvChunks(r)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	sent := 0
+	for sent < skip {
+		c, err := next(min(chunkSize, skip-sent))
+		if err != nil || c == nil {
+			return err
+		}
+		sent += c.records
+	}
+	start := time.Now()
+	for {
+		c, err := next(chunkSize)
+		if err != nil {
+			return fmt.Errorf("%v (resume with -skip %d)", err, sent)
+		}
+		if c == nil {
+			return nil
+		}
+		res, err := imp.send(format, c)
+		if err != nil {
+			return fmt.Errorf("%v (resume with -skip %d)", err, sent)
+		}
+		sent += c.records
+		imp.add(path, c, res)
+		rate := float64(sent-skip) / time.Since(start).Seconds()
+		slog.Info("Imported chunk", "file", path, "records", sent, "imported", res.Imported,
+			"rejected", res.Rejected, "records_per_second", int(rate))
+		if res.Error != "" {
+			return fmt.Errorf("server stopped the import: %s (resume with -skip %d)", res.Error, sent-c.records)
+		}
+	}
+}
+
+// send posts a chunk gzip compressed.
+func (imp *importer) send(format string, c *chunk) (*result, error) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write(c.body.Bytes())
+	gz.Close()
+	req, err := http.NewRequest(http.MethodPost, imp.server+"/import?format="+format, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-Account", imp.account)
+	if imp.token != "" {
+		req.Header.Set("Authorization", "Bearer "+imp.token)
+	}
+	resp, err := imp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res result
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("invalid response from server (%s): %v", resp.Status, err)
+	}
+	if res.Error == "" && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return &res, nil
+}
+
+// add folds a chunk result into the totals, mapping rejected lines back to
+// the file.
+func (imp *importer) add(path string, c *chunk, res *result) {
+	imp.total.Lines += res.Lines
+	imp.total.Imported += res.Imported
+	imp.total.Skipped += res.Skipped
+	imp.total.Rejected += res.Rejected
+	if imp.total.Errors == nil {
+		imp.total.Errors = map[string]int{}
+	}
+	for reason, n := range res.Errors {
+		imp.total.Errors[reason] += n
+	}
+	for _, sample := range res.Samples {
+		if len(imp.samples) >= 100 {
+			break
+		}
+		imp.samples = append(imp.samples, fmt.Sprintf("%s:%d: %s", path, c.lines[sample.Line], sample.Error))
+	}
+}
+
+// ndjsonChunks returns a function reading the next chunk of n non-blank
+// lines from r, or nil at the end.
+func ndjsonChunks(r io.Reader) func(n int) (*chunk, error) {
+	reader := bufio.NewReader(r)
+	line := 0
+	return func(n int) (*chunk, error) {
+		c := &chunk{lines: map[int]int{}}
+		bodyLine := 0
+		for c.records < n {
+			text, err := reader.ReadBytes('\n')
+			if len(text) > 0 {
+				line++
+				if len(bytes.TrimSpace(text)) > 0 {
+					bodyLine++
+					c.lines[bodyLine] = line
+					c.body.Write(bytes.TrimRight(text, "\r\n"))
+					c.body.WriteByte('\n')
+					c.records++
+				}
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if c.records == 0 {
+			return nil, nil
+		}
+		return c, nil
+	}
+}
+
+// csvChunks returns a function reading the next chunk of n records from r,
+// each chunk starting with the header row, or nil at the end.
+func csvChunks(r io.Reader) func(n int) (*chunk, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	var header []string
+	return func(n int) (*chunk, error) {
+		if header == nil {
+			var err error
+			if header, err = reader.Read(); err == io.EOF {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		c := &chunk{lines: map[int]int{}}
+		w := csv.NewWriter(&c.body)
+		w.Write(header)
+		w.Flush()
+		bodyLines := bytes.Count(c.body.Bytes(), []byte("\n"))
+		for c.records < n {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			line, _ := reader.FieldPos(0)
+			// The server numbers records by the body line they start on
+			c.lines[bodyLines+1] = line
+			size := c.body.Len()
+			w.Write(record)
+			w.Flush()
+			bodyLines += bytes.Count(c.body.Bytes()[size:], []byte("\n"))
+			c.records++
+		}
+		if c.records == 0 {
+			return nil, nil
+		}
+		return c, nil
+	}
+}
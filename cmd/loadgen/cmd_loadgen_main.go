@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// loadgen sends synthetic traffic to a server: ingest requests at rate
+// entries per second, and queries at queryRate per second.
+type loadgen struct {
+	server, token string
+	client        *http.Client
+	payload       payloadConfig
+
+	rate        float64
+	batch       int
+	queryRate   float64
+	concurrency int
+	seed        uint64
+
+	ingest, query *recorder
+}
+
+func main() {
+	server := flag.String("server", "http://localhost:8080", "logdata server URL")
+	token := flag.String("token", os.Getenv("LOADGEN_TOKEN"), "bearer token with the ingest and read scopes (default $LOADGEN_TOKEN)")
+	rate := flag.Float64("rate", 100, "entries sent per second")
+	batch := flag.Int("batch", 1, "entries per request: 1 posts to /logdata, more to /import")
+	queryRate := flag.Float64("query-rate", 0, "/getdata queries per second")
+	duration := flag.Duration("duration", 30*time.Second, "how long to send; interrupt to stop earlier")
+	concurrency := flag.Int("concurrency", 16, "requests in flight at most")
+	accounts := flag.Int("accounts", 10, "accounts to spread entries over, a few of them busier than the others")
+	levels := flag.String("levels", "trace=2,debug=15,info=65,warn=12,error=5,fatal=1", "relative weights of the levels")
+	msgBytes := flag.Int("msg-bytes", 120, "approximate length of messages")
+	fields := flag.Int("fields", 4, "structured fields per entry")
+	seed := flag.Uint64("seed", 1, "random seed, for repeatable traffic")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: loadgen [flags]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	weights, err := parseLevels(*levels)
+	if err != nil || *rate <= 0 || *batch <= 0 || *queryRate < 0 || *concurrency <= 0 || *accounts <= 0 || *msgBytes <= 0 || *fields < 0 {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	lg := &loadgen{
+		server:      strings.TrimSuffix(*server, "/"),
+		token:       *token,
+		client:      &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency}},
+		payload:     payloadConfig{accounts: *accounts, levels: weights, msgBytes: *msgBytes, fields: *fields},
+		rate:        *rate,
+		batch:       *batch,
+		queryRate:   *queryRate,
+		concurrency: *concurrency,
+		seed:        *seed,
+		ingest:      newRecorder(),
+		query:       newRecorder(),
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+	ctx, cancelRun := context.WithTimeout(ctx, *duration)
+	defer cancelRun()
+
+	slog.Info("Sending load", "server", lg.server, "rate", lg.rate, "batch", lg.batch, "query_rate", lg.queryRate, "duration", *duration)
+	start := time.Now()
+	lg.run(ctx)
+	elapsed := time.Since(start)
+
+	fmt.Printf("%-7s %9s %9s %7s %8s %10s %9s %9s %9s %9s\n", "", "requests", "entries", "errors", "behind", "entries/s", "p50", "p90", "p99", "max")
+	lg.ingest.print(os.Stdout, "ingest", elapsed)
+	if lg.queryRate > 0 {
+		lg.query.print(os.Stdout, "query", elapsed)
+	}
+	for _, r := range []*recorder{lg.ingest, lg.query} {
+		for _, failure := range r.failures() {
+			fmt.Fprintln(os.Stderr, failure)
+		}
+	}
+}
+
+// run sends traffic until ctx is done, then waits for the requests in
+// flight.
+func (lg *loadgen) run(ctx context.Context) {
+	jobs := make(chan func(*rand.Rand), lg.concurrency)
+	var wg sync.WaitGroup
+	for i := range lg.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(lg.seed, uint64(i)))
+			for job := range jobs {
+				job(rng)
+			}
+		}()
+	}
+
+	var schedulers sync.WaitGroup
+	schedulers.Add(1)
+	go func() {
+		defer schedulers.Done()
+		lg.schedule(ctx, jobs, lg.rate/float64(lg.batch), lg.ingest, lg.sendEntries)
+	}()
+	if lg.queryRate > 0 {
+		schedulers.Add(1)
+		go func() {
+			defer schedulers.Done()
+			lg.schedule(ctx, jobs, lg.queryRate, lg.query, lg.sendQuery)
+		}()
+	}
+	schedulers.Wait()
+	close(jobs)
+	wg.Wait()
+}
+
+// schedule queues send perSecond times per second until ctx is done.
+// Requests that find every worker busy are counted as behind in rec rather
+// than delayed, so the rate stays the one asked for.
+func (lg *loadgen) schedule(ctx context.Context, jobs chan<- func(*rand.Rand), perSecond float64, rec *recorder, send func(*rand.Rand) (int, error)) {
+	interval := time.Duration(float64(time.Second) / perSecond)
+	start := time.Now()
+	for n := 0; ; n++ {
+		wait := time.Until(start.Add(time.Duration(n) * interval))
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+		job := func(rng *rand.Rand) {
+			started := time.Now()
+			entries, err := send(rng)
+			rec.record(time.Since(started), entries, err)
+		}
+		select {
+		case jobs <- job:
+		default:
+			rec.behind()
+		}
+	}
+}
+
+// sendEntries posts one request of lg.batch entries of a single account.
+func (lg *loadgen) sendEntries(rng *rand.Rand) (int, error) {
+	account := lg.payload.account(rng)
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for range lg.batch {
+		enc.Encode(lg.payload.entry(rng, account))
+	}
+	path := "/logdata"
+	if lg.batch > 1 {
+		path = "/import"
+	}
+	req, err := http.NewRequest(http.MethodPost, lg.server+path, &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Account", account)
+	resBody, err := lg.do(req)
+	if err != nil {
+		return 0, err
+	}
+	if lg.batch == 1 {
+		return 1, nil
+	}
+	// Imports answer 200 with the lines they rejected
+	var res struct {
+		Rejected int            `json:"rejected"`
+		Errors   map[string]int `json:"errors"`
+	}
+	if err := json.Unmarshal(resBody, &res); err != nil {
+		return 0, fmt.Errorf("invalid import response: %v", err)
+	}
+	if res.Rejected > 0 {
+		return lg.batch - res.Rejected, fmt.Errorf("%d entries rejected: %v", res.Rejected, res.Errors)
+	}
+	return lg.batch, nil
+}
+
+// sendQuery runs one of the /getdata queries a dashboard would, returning the
+// number of entries read.
+func (lg *loadgen) sendQuery(rng *rand.Rand) (int, error) {
+	query := url.Values{"account": {lg.payload.account(rng)}, "limit": {"100"}}
+	switch rng.IntN(4) {
+	case 0:
+		query.Set("min_level", "warn")
+	case 1:
+		query.Set("module", modules[rng.IntN(len(modules))])
+	case 2:
+		query.Set("start_time", time.Now().UTC().Add(-5*time.Minute).Format(time.RFC3339))
+	case 3:
+		query.Set("msg_regex", "timed out")
+	}
+	req, err := http.NewRequest(http.MethodGet, lg.server+"/getdata?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resBody, err := lg.do(req)
+	if err != nil {
+		return 0, err
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(resBody, &entries); err != nil {
+		return 0, fmt.Errorf("invalid query response: %v", err)
+	}
+	return len(entries), nil
+}
+
+// do sends req and returns the response body, read in full so the
+// connection is reused.
+func (lg *loadgen) do(req *http.Request) ([]byte, error) {
+	if lg.token != "" {
+		req.Header.Set("Authorization", "Bearer "+lg.token)
+	}
+	resp, err := lg.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		var res struct {
+			Code  string `json:"code"`
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &res) == nil && res.Code != "" {
+			return nil, fmt.Errorf("%d %s: %s", resp.StatusCode, res.Code, res.Error)
+		}
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return body, err
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entry is a log entry as POST /logdata takes it.
+type entry struct {
+	Account   string         `json:"account"`
+	System    string         `json:"system"`
+	User      string         `json:"user"`
+	Module    string         `json:"module"`
+	Task      string         `json:"task"`
+	Timestamp time.Time      `json:"timestamp"`
+	Msg       string         `json:"msg"`
+	Level     int            `json:"level"`
+	Fields    map[string]any `json:"fields,omitempty"`
+	TraceID   string         `json:"trace_id,omitempty"`
+}
+
+// levelWeight is the share of entries at a canonical level.
+type levelWeight struct {
+	level  int
+	weight float64
+}
+
+var levelValues = map[string]int{"trace": 10, "debug": 20, "info": 30, "warn": 40, "error": 50, "fatal": 60}
+
+// parseLevels parses name=weight pairs, such as "info=90,error=10".
+func parseLevels(spec string) ([]levelWeight, error) {
+	var weights []levelWeight
+	for _, pair := range strings.Split(spec, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		level, known := levelValues[name]
+		w, err := strconv.ParseFloat(weight, 64)
+		if !ok || !known || err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid level weight %q", pair)
+		}
+		weights = append(weights, levelWeight{level, w})
+	}
+	return weights, nil
+}
+
+var (
+	systems = []string{"web-1", "web-2", "web-3", "worker-1", "worker-2"}
+	modules = []string{"auth", "orders", "payments", "search", "db", "cache"}
+	tasks   = []string{"request", "job", "sync"}
+)
+
+// messages are the templates of messages by level; %d is replaced with
+// numbers so fingerprints group them as real messages would.
+var messages = map[int][]string{
+	10: {"entering handler %d", "cache lookup key=item:%d"},
+	20: {"query took %dms rows=%d", "retrying request %d attempt %d"},
+	30: {"GET /api/orders/%d completed in %dms", "user %d logged in", "job %d finished in %dms"},
+	40: {"slow query on table orders took %dms", "rate limit at %d%% for client %d"},
+	50: {"connection to db-%d timed out after %ds", "payment %d failed: card declined", "upstream returned 502 for request %d"},
+	60: {"out of memory after %d allocations", "cannot open database file %d"},
+}
+
+// filler pads messages to the configured length.
+var filler = strings.Fields("request upstream handler session client retry cache token region shard latency payload timeout status")
+
+// payloadConfig shapes the generated entries.
+type payloadConfig struct {
+	accounts int
+	levels   []levelWeight
+	msgBytes int
+	fields   int
+}
+
+// account picks an account, the first ones being the busiest: account i
+// gets entries in proportion to 1/i.
+func (p payloadConfig) account(rng *rand.Rand) string {
+	var total float64
+	for i := 1; i <= p.accounts; i++ {
+		total += 1 / float64(i)
+	}
+	x := rng.Float64() * total
+	for i := 1; i <= p.accounts; i++ {
+		if x -= 1 / float64(i); x < 0 {
+			return "loadgen-" + strconv.Itoa(i)
+		}
+	}
+	return "loadgen-" + strconv.Itoa(p.accounts)
+}
+
+// level picks a level by weight.
+func (p payloadConfig) level(rng *rand.Rand) int {
+	var total float64
+	for _, w := range p.levels {
+		total += w.weight
+	}
+	x := rng.Float64() * total
+	for _, w := range p.levels {
+		if x -= w.weight; x < 0 {
+			return w.level
+		}
+	}
+	return p.levels[len(p.levels)-1].level
+}
+
+// entry generates an entry of account.
+func (p payloadConfig) entry(rng *rand.Rand, account string) entry {
+	level := p.level(rng)
+	templates := messages[level]
+	msg := templates[rng.IntN(len(templates))]
+	for strings.Contains(msg, "%d") {
+		msg = strings.Replace(msg, "%d", strconv.Itoa(rng.IntN(10000)), 1)
+	}
+	msg = strings.ReplaceAll(msg, "%%", "%")
+	var b strings.Builder
+	b.WriteString(msg)
+	for b.Len() < p.msgBytes {
+		b.WriteByte(' ')
+		b.WriteString(filler[rng.IntN(len(filler))])
+	}
+	e := entry{
+		Account:   account,
+		System:    systems[rng.IntN(len(systems))],
+		User:      "user-" + strconv.Itoa(rng.IntN(1000)),
+		Module:    modules[rng.IntN(len(modules))],
+		Task:      tasks[rng.IntN(len(tasks))],
+		Timestamp: time.Now().UTC(),
+		Msg:       b.String(),
+		Level:     level,
+	}
+	if p.fields > 0 {
+		e.Fields = make(map[string]any, p.fields)
+		for i := range p.fields {
+			if i%2 == 0 {
+				e.Fields["field_"+strconv.Itoa(i)] = rng.IntN(100000)
+			} else {
+				e.Fields["field_"+strconv.Itoa(i)] = filler[rng.IntN(len(filler))]
+			}
+		}
+	}
+	if rng.IntN(10) < 3 {
+		e.TraceID = fmt.Sprintf("%016x%016x", rng.Uint64(), rng.Uint64())
+	}
+	return e
+}
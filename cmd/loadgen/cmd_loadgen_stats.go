@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// recorder collects the outcome of requests of one kind.
+type recorder struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	entries   int
+	errors    int
+	late      int
+	reasons   map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{reasons: map[string]int{}}
+}
+
+// record adds a request that took d and stored entries, failing with err.
+func (r *recorder) record(d time.Duration, entries int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies = append(r.latencies, d)
+	r.entries += entries
+	if err != nil {
+		r.errors++
+		r.reasons[err.Error()]++
+	}
+}
+
+// behind counts a request that was not sent as every worker was busy.
+func (r *recorder) behind() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.late++
+}
+
+// print writes the summary line of the requests, sent over elapsed.
+func (r *recorder) print(w io.Writer, name string, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latencies := slices.Clone(r.latencies)
+	slices.Sort(latencies)
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	round := func(d time.Duration) string { return d.Round(10 * time.Microsecond).String() }
+	fmt.Fprintf(w, "%-7s %9d %9d %7d %8d %10.0f %9s %9s %9s %9s\n", name, len(latencies), r.entries, r.errors, r.late,
+		float64(r.entries)/elapsed.Seconds(), round(percentile(0.5)), round(percentile(0.9)), round(percentile(0.99)), round(percentile(1)))
+}
+
+// failures describes the errors, most frequent first.
+func (r *recorder) failures() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reasons := make([]string, 0, len(r.reasons))
+	for reason := range r.reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return r.reasons[reasons[i]] > r.reasons[reasons[j]] })
+	lines := make([]string, len(reasons))
+	for i, reason := range reasons {
+		lines[i] = fmt.Sprintf("%8d  %s", r.reasons[reason], reason)
+	}
+	return lines
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config holds router settings read from environment variables.
+type Config struct {
+	Port string
+	// Shards maps shard names to server base URLs. Accounts are placed on
+	// the ring by shard name, so a shard can move to a new URL without
+	// rebalancing.
+	Shards map[string]string
+	// AdminToken is the shards' admin token, used by rebalance.
+	AdminToken string
+	// MaxBodyBytes bounds the body read to find the account of requests
+	// naming it only in their JSON body.
+	MaxBodyBytes int64
+}
+
+// loadConfig reads the router configuration from the environment.
+func loadConfig() (*Config, error) {
+	cfg := &Config{
+		Port:       envString("ROUTER_PORT", "8080"),
+		AdminToken: os.Getenv("ROUTER_ADMIN_TOKEN"),
+	}
+	v := os.Getenv("ROUTER_SHARDS")
+	if v == "" {
+		return nil, fmt.Errorf("ROUTER_SHARDS is required")
+	}
+	if err := json.Unmarshal([]byte(v), &cfg.Shards); err != nil {
+		return nil, fmt.Errorf("invalid ROUTER_SHARDS: %v", err)
+	}
+	if len(cfg.Shards) == 0 {
+		return nil, fmt.Errorf("ROUTER_SHARDS must name at least one shard")
+	}
+	for name, shardURL := range cfg.Shards {
+		u, err := url.Parse(shardURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid ROUTER_SHARDS: shard %s needs an absolute URL", name)
+		}
+	}
+	maxBody, err := envInt("ROUTER_MAX_BODY_BYTES", 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MaxBodyBytes = int64(maxBody)
+	return cfg, nil
+}
+
+// envString returns an environment variable, or def when unset.
+func envString(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt parses an integer environment variable, returning def when unset.
+func envInt(name string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+)
+
+// router forwards each request to the shard owning its account.
+type router struct {
+	cfg     *Config
+	ring    *ring
+	proxies map[string]*httputil.ReverseProxy
+}
+
+func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+	rt := newRouter(cfg)
+
+	if len(os.Args) > 1 && os.Args[1] == "rebalance" {
+		flags := flag.NewFlagSet("rebalance", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "only list the accounts that would move")
+		flags.Parse(os.Args[2:])
+		if err := rt.rebalance(*dryRun); err != nil {
+			fatal("Rebalance failed", "err", err)
+		}
+		return
+	} else if len(os.Args) > 1 {
+		fatal("Unknown command", "command", os.Args[1])
+	}
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	})
+	http.Handle("/", rt)
+	slog.Info("Starting router", "port", cfg.Port, "shards", len(cfg.Shards))
+	if err := http.ListenAndServe(":"+cfg.Port, nil); err != nil {
+		fatal("Router failed", "err", err)
+	}
+}
+
+// writeError writes an error in the body shards answer errors with.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": code, "error": message})
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func newRouter(cfg *Config) *router {
+	names := make([]string, 0, len(cfg.Shards))
+	for name := range cfg.Shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rt := &router{cfg: cfg, ring: newRing(names), proxies: map[string]*httputil.ReverseProxy{}}
+	for name, shardURL := range cfg.Shards {
+		u, _ := url.Parse(shardURL)
+		proxy := httputil.NewSingleHostReverseProxy(u)
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Error("Shard unreachable", "shard", name, "path", r.URL.Path, "err", err)
+			writeError(w, http.StatusBadGateway, "UPSTREAM_FAILED", fmt.Sprintf("Shard %s unreachable", name))
+		}
+		rt.proxies[name] = proxy
+	}
+	return rt
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	account, err := rt.requestAccount(r)
+	if err != nil {
+		slog.Warn("Cannot route request", "method", r.Method, "path", r.URL.Path, "err", err)
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", err.Error())
+		return
+	}
+	shard := rt.ring.shard(account)
+	w.Header().Set("X-Logdata-Shard", shard)
+	rt.proxies[shard].ServeHTTP(w, r)
+}
+
+// requestAccount returns the account a request is routed by: X-Account, the
+// account query parameter, X-Scope-OrgID, or else the "account" key of a
+// JSON object body, which is restored for the shard.
+func (rt *router) requestAccount(r *http.Request) (string, error) {
+	account := r.Header.Get("X-Account")
+	if account == "" {
+		account = r.URL.Query().Get("account")
+	}
+	if account == "" {
+		account = r.Header.Get("X-Scope-OrgID")
+	}
+	if account == "" && r.Body != nil && r.Header.Get("Content-Encoding") == "" {
+		body, err := io.ReadAll(io.LimitReader(r.Body, rt.cfg.MaxBodyBytes+1))
+		if err != nil {
+			return "", fmt.Errorf("Failed to read request body")
+		}
+		if int64(len(body)) > rt.cfg.MaxBodyBytes {
+			return "", fmt.Errorf("Request body too large to find its account, set X-Account")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		var v struct {
+			Account string `json:"account"`
+		}
+		json.Unmarshal(body, &v)
+		account = v.Account
+	}
+	switch account {
+	case "":
+		if r.URL.Query().Get("account_prefix") != "" {
+			return "", fmt.Errorf("Subtree queries are not routed, query each shard")
+		}
+		return "", fmt.Errorf("Account required to route the request")
+	case "*":
+		return "", fmt.Errorf("Cross-account queries are not routed, query each shard")
+	}
+	return account, nil
+}
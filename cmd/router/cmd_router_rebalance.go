@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// moveBatchSize is the number of entries copied per request while moving an
+// account.
+const moveBatchSize = 500
+
+var client = &http.Client{Timeout: 5 * time.Minute}
+
+// rebalance moves every account stored on a shard other than the one the
+// ring assigns it to. Routers must already use the new ROUTER_SHARDS so no
+// entries are written to the old shard while it runs.
+func (rt *router) rebalance(dryRun bool) error {
+	if rt.cfg.AdminToken == "" {
+		return fmt.Errorf("ROUTER_ADMIN_TOKEN is required to rebalance")
+	}
+	names := make([]string, 0, len(rt.cfg.Shards))
+	for name := range rt.cfg.Shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	moved := 0
+	for _, name := range names {
+		var usage []struct {
+			Account string `json:"account"`
+			Rows    int64  `json:"rows"`
+		}
+		if err := rt.call(name, http.MethodGet, "/usage", nil, &usage); err != nil {
+			return fmt.Errorf("failed to list accounts on %s: %v", name, err)
+		}
+		for _, u := range usage {
+			owner := rt.ring.shard(u.Account)
+			if u.Rows == 0 || owner == name {
+				continue
+			}
+			slog.Info("Moving account", "account", u.Account, "from", name, "to", owner, "rows", u.Rows, "dry_run", dryRun)
+			if dryRun {
+				continue
+			}
+			if err := rt.move(u.Account, name, owner); err != nil {
+				return fmt.Errorf("failed to move %s from %s to %s: %v", u.Account, name, owner, err)
+			}
+			moved++
+		}
+	}
+	slog.Info("Rebalance finished", "moved_accounts", moved, "dry_run", dryRun)
+	return nil
+}
+
+// move copies the entries of account from one shard to another, then purges
+// them from the source. Copying skips entries already on the target, so a
+// failed move can be rerun.
+func (rt *router) move(account, from, to string) error {
+	var afterID int64
+	copied := 0
+	for {
+		var entries []json.RawMessage
+		path := fmt.Sprintf("/replication/entries?account=%s&after_id=%d&limit=%d", url.QueryEscape(account), afterID, moveBatchSize)
+		if err := rt.call(from, http.MethodGet, path, nil, &entries); err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		var result struct {
+			Stored int `json:"stored"`
+		}
+		body, _ := json.Marshal(entries)
+		if err := rt.call(to, http.MethodPost, "/replication/entries", body, &result); err != nil {
+			return err
+		}
+		// Entries are copied through untouched; only the id is needed
+		var last struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(entries[len(entries)-1], &last); err != nil {
+			return fmt.Errorf("invalid entry from %s: %v", from, err)
+		}
+		afterID = last.ID
+		copied += result.Stored
+	}
+
+	var purge struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := rt.call(from, http.MethodDelete, "/logdata?account="+url.QueryEscape(account), nil, &purge); err != nil {
+		return err
+	}
+	slog.Info("Moved account", "account", account, "from", from, "to", to, "copied", copied, "deleted", purge.Deleted)
+	return nil
+}
+
+// call sends an admin request to shard and decodes the JSON response into out.
+func (rt *router) call(shard, method, path string, body []byte, out any) error {
+	req, err := http.NewRequest(method, strings.TrimSuffix(rt.cfg.Shards[shard], "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+rt.cfg.AdminToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
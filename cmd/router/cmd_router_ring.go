@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// ringReplicas is the number of points each shard has on the ring. More
+// points spread accounts more evenly.
+const ringReplicas = 128
+
+// ring is a consistent hash ring over shard names. Adding a shard only moves
+// the accounts that land on its points.
+type ring struct {
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard string
+}
+
+func newRing(shards []string) *ring {
+	r := &ring{}
+	for _, shard := range shards {
+		for i := 0; i < ringReplicas; i++ {
+			r.points = append(r.points, ringPoint{hash: ringHash(shard + "#" + strconv.Itoa(i)), shard: shard})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			return r.points[i].shard < r.points[j].shard
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+// shard returns the shard owning account: the first point at or after the
+// account's hash, wrapping around.
+func (r *ring) shard(account string) string {
+	h := ringHash(account)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].shard
+}
+
+func ringHash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,96 @@
+// Package logdata embeds the logdata ingestion and query engine in another
+// Go service, instead of deploying the server binary next to it.
+//
+// New opens the store and returns the handler serving the logdata HTTP API,
+// to mount on the service's own mux, and a Writer storing entries
+// in-process. The Writer is a client.Logger, so the client package's slog,
+// zap and logrus adapters can write the service's own logs to it:
+//
+//	h, w, err := logdata.New("data/logs.db")
+//	mux.Handle("/logs/", http.StripPrefix("/logs", h))
+//	logger := slog.New(client.NewSlogHandler(w, nil))
+//
+// The engine logs through slog's default logger, which should not write to
+// the Writer, or storing an entry would log more entries.
+//
+// Settings other than the store are read from the environment as the server
+// binary reads them (see _.env), and the background jobs they enable run for
+// the life of the process. As the engine's state is process-wide, a process
+// embeds one engine at a time.
+package logdata
+
+import (
+	"context"
+	"net/http"
+
+	"log-server/client"
+	"log-server/server"
+)
+
+// Option customizes the engine, such as server.WithEntryInterceptors.
+type Option = server.Option
+
+// Entry is a log entry as the client package writes it.
+type Entry = client.Entry
+
+// Writer stores entries in the embedded engine.
+type Writer struct {
+	srv *server.Server
+}
+
+// New opens store, the path of a SQLite database or ":memory:", migrating
+// its schema, and starts the engine's background jobs.
+func New(store string, opts ...Option) (http.Handler, *Writer, error) {
+	cfg, err := server.LoadEmbeddedConfig(store)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv, err := server.New(cfg, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv.Start()
+	return srv, &Writer{srv: srv}, nil
+}
+
+// Write stores entry as POST /logdata does and returns its ULID, empty when
+// it was sampled out. Entry.ClientID is ignored, as there is no retry to
+// deduplicate; Entry.SourceSeq still skips replays.
+func (w *Writer) Write(entry Entry) (string, error) {
+	return w.srv.Write(server.LogData{
+		Account:    entry.Account,
+		System:     entry.System,
+		User:       entry.User,
+		Module:     entry.Module,
+		Task:       entry.Task,
+		Timestamp:  entry.Timestamp,
+		Msg:        entry.Msg,
+		Level:      entry.Level,
+		StackTrace: entry.StackTrace,
+		Fields:     entry.Fields,
+		TraceID:    entry.TraceID,
+		SpanID:     entry.SpanID,
+		SessionID:  entry.SessionID,
+		SourceSeq:  entry.SourceSeq,
+	})
+}
+
+// Log stores entry, implementing client.Logger.
+func (w *Writer) Log(entry Entry) error {
+	_, err := w.Write(entry)
+	return err
+}
+
+// LogContext stores entry unless ctx is done.
+func (w *Writer) LogContext(ctx context.Context, entry Entry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return w.Log(entry)
+}
+
+// Close waits up to SHUTDOWN_TIMEOUT for the entries accepted with ack=async
+// to be stored, then closes the store. The handler must not be used after.
+func (w *Writer) Close() error {
+	return w.srv.Close()
+}
@@ -0,0 +1,709 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: logdata.proto
+
+package logdatapb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// LogEntry is the protobuf form of LogData.
+type LogEntry struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Id         int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Account    string                 `protobuf:"bytes,2,opt,name=account,proto3" json:"account,omitempty"`
+	System     string                 `protobuf:"bytes,3,opt,name=system,proto3" json:"system,omitempty"`
+	User       string                 `protobuf:"bytes,4,opt,name=user,proto3" json:"user,omitempty"`
+	Module     string                 `protobuf:"bytes,5,opt,name=module,proto3" json:"module,omitempty"`
+	Task       string                 `protobuf:"bytes,6,opt,name=task,proto3" json:"task,omitempty"`
+	Timestamp  *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Msg        string                 `protobuf:"bytes,8,opt,name=msg,proto3" json:"msg,omitempty"`
+	Level      int32                  `protobuf:"varint,9,opt,name=level,proto3" json:"level,omitempty"`
+	StackTrace string                 `protobuf:"bytes,10,opt,name=stack_trace,json=stackTrace,proto3" json:"stack_trace,omitempty"`
+	Fields     *structpb.Struct       `protobuf:"bytes,11,opt,name=fields,proto3" json:"fields,omitempty"`
+	TraceId    string                 `protobuf:"bytes,12,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`
+	SpanId     string                 `protobuf:"bytes,13,opt,name=span_id,json=spanId,proto3" json:"span_id,omitempty"`
+	// ulid is assigned by the server and ignored on push.
+	Ulid string `protobuf:"bytes,14,opt,name=ulid,proto3" json:"ulid,omitempty"`
+	// repeat_count is how many identical entries the row stands for when the
+	// server deduplicates; ignored on push.
+	RepeatCount int64 `protobuf:"varint,15,opt,name=repeat_count,json=repeatCount,proto3" json:"repeat_count,omitempty"`
+	// sampled_rate is the fraction of similar entries kept by the server's
+	// sampling rules, 0 when not sampled; ignored on push.
+	SampledRate float64 `protobuf:"fixed64,16,opt,name=sampled_rate,json=sampledRate,proto3" json:"sampled_rate,omitempty"`
+	// source_seq identifies the entry at its source, such as an agent's spool
+	// sequence number; a push whose source_seq is already stored for the
+	// account is a replay and is not stored again.
+	SourceSeq string `protobuf:"bytes,17,opt,name=source_seq,json=sourceSeq,proto3" json:"source_seq,omitempty"`
+	// session_id groups the entries of one user session across systems.
+	SessionId     string `protobuf:"bytes,18,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogEntry) Reset() {
+	*x = LogEntry{}
+	mi := &file_logdata_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogEntry) ProtoMessage() {}
+
+func (x *LogEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_logdata_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogEntry.ProtoReflect.Descriptor instead.
+func (*LogEntry) Descriptor() ([]byte, []int) {
+	return file_logdata_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *LogEntry) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *LogEntry) GetAccount() string {
+	if x != nil {
+		return x.Account
+	}
+	return ""
+}
+
+func (x *LogEntry) GetSystem() string {
+	if x != nil {
+		return x.System
+	}
+	return ""
+}
+
+func (x *LogEntry) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *LogEntry) GetModule() string {
+	if x != nil {
+		return x.Module
+	}
+	return ""
+}
+
+func (x *LogEntry) GetTask() string {
+	if x != nil {
+		return x.Task
+	}
+	return ""
+}
+
+func (x *LogEntry) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *LogEntry) GetMsg() string {
+	if x != nil {
+		return x.Msg
+	}
+	return ""
+}
+
+func (x *LogEntry) GetLevel() int32 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+func (x *LogEntry) GetStackTrace() string {
+	if x != nil {
+		return x.StackTrace
+	}
+	return ""
+}
+
+func (x *LogEntry) GetFields() *structpb.Struct {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+func (x *LogEntry) GetTraceId() string {
+	if x != nil {
+		return x.TraceId
+	}
+	return ""
+}
+
+func (x *LogEntry) GetSpanId() string {
+	if x != nil {
+		return x.SpanId
+	}
+	return ""
+}
+
+func (x *LogEntry) GetUlid() string {
+	if x != nil {
+		return x.Ulid
+	}
+	return ""
+}
+
+func (x *LogEntry) GetRepeatCount() int64 {
+	if x != nil {
+		return x.RepeatCount
+	}
+	return 0
+}
+
+func (x *LogEntry) GetSampledRate() float64 {
+	if x != nil {
+		return x.SampledRate
+	}
+	return 0
+}
+
+func (x *LogEntry) GetSourceSeq() string {
+	if x != nil {
+		return x.SourceSeq
+	}
+	return ""
+}
+
+func (x *LogEntry) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+type PushLogRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Entry         *LogEntry              `protobuf:"bytes,1,opt,name=entry,proto3" json:"entry,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PushLogRequest) Reset() {
+	*x = PushLogRequest{}
+	mi := &file_logdata_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PushLogRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PushLogRequest) ProtoMessage() {}
+
+func (x *PushLogRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_logdata_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PushLogRequest.ProtoReflect.Descriptor instead.
+func (*PushLogRequest) Descriptor() ([]byte, []int) {
+	return file_logdata_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PushLogRequest) GetEntry() *LogEntry {
+	if x != nil {
+		return x.Entry
+	}
+	return nil
+}
+
+type PushLogResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	Ulid          string                 `protobuf:"bytes,2,opt,name=ulid,proto3" json:"ulid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PushLogResponse) Reset() {
+	*x = PushLogResponse{}
+	mi := &file_logdata_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PushLogResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PushLogResponse) ProtoMessage() {}
+
+func (x *PushLogResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_logdata_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PushLogResponse.ProtoReflect.Descriptor instead.
+func (*PushLogResponse) Descriptor() ([]byte, []int) {
+	return file_logdata_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PushLogResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *PushLogResponse) GetUlid() string {
+	if x != nil {
+		return x.Ulid
+	}
+	return ""
+}
+
+type PushLogStreamResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Accepted int64                  `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	Rejected int64                  `protobuf:"varint,2,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	// errors holds the messages of rejections, for clients predating it.
+	//
+	// Deprecated: Marked as deprecated in logdata.proto.
+	Errors []string `protobuf:"bytes,3,rep,name=errors,proto3" json:"errors,omitempty"`
+	// rejections lists the first 100 rejected entries, in stream order.
+	Rejections    []*PushRejection `protobuf:"bytes,4,rep,name=rejections,proto3" json:"rejections,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PushLogStreamResponse) Reset() {
+	*x = PushLogStreamResponse{}
+	mi := &file_logdata_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PushLogStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PushLogStreamResponse) ProtoMessage() {}
+
+func (x *PushLogStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_logdata_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PushLogStreamResponse.ProtoReflect.Descriptor instead.
+func (*PushLogStreamResponse) Descriptor() ([]byte, []int) {
+	return file_logdata_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *PushLogStreamResponse) GetAccepted() int64 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *PushLogStreamResponse) GetRejected() int64 {
+	if x != nil {
+		return x.Rejected
+	}
+	return 0
+}
+
+// Deprecated: Marked as deprecated in logdata.proto.
+func (x *PushLogStreamResponse) GetErrors() []string {
+	if x != nil {
+		return x.Errors
+	}
+	return nil
+}
+
+func (x *PushLogStreamResponse) GetRejections() []*PushRejection {
+	if x != nil {
+		return x.Rejections
+	}
+	return nil
+}
+
+// PushRejection is an entry of a PushLogStream call that was not stored.
+type PushRejection struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// index is the position of the entry in the stream, from 0.
+	Index         int64  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Error         string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PushRejection) Reset() {
+	*x = PushRejection{}
+	mi := &file_logdata_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PushRejection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PushRejection) ProtoMessage() {}
+
+func (x *PushRejection) ProtoReflect() protoreflect.Message {
+	mi := &file_logdata_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PushRejection.ProtoReflect.Descriptor instead.
+func (*PushRejection) Descriptor() ([]byte, []int) {
+	return file_logdata_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *PushRejection) GetIndex() int64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *PushRejection) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// QueryLogsRequest carries the same filters as GET /getdata.
+type QueryLogsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Account       string                 `protobuf:"bytes,1,opt,name=account,proto3" json:"account,omitempty"`
+	System        string                 `protobuf:"bytes,2,opt,name=system,proto3" json:"system,omitempty"`
+	User          string                 `protobuf:"bytes,3,opt,name=user,proto3" json:"user,omitempty"`
+	Module        string                 `protobuf:"bytes,4,opt,name=module,proto3" json:"module,omitempty"`
+	Task          string                 `protobuf:"bytes,5,opt,name=task,proto3" json:"task,omitempty"`
+	TraceId       string                 `protobuf:"bytes,6,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`
+	Level         *int32                 `protobuf:"varint,7,opt,name=level,proto3,oneof" json:"level,omitempty"`
+	StartTime     string                 `protobuf:"bytes,8,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	EndTime       string                 `protobuf:"bytes,9,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"`
+	Limit         *int64                 `protobuf:"varint,10,opt,name=limit,proto3,oneof" json:"limit,omitempty"`
+	Offset        *int64                 `protobuf:"varint,11,opt,name=offset,proto3,oneof" json:"offset,omitempty"`
+	Fields        map[string]string      `protobuf:"bytes,12,rep,name=fields,proto3" json:"fields,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryLogsRequest) Reset() {
+	*x = QueryLogsRequest{}
+	mi := &file_logdata_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryLogsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryLogsRequest) ProtoMessage() {}
+
+func (x *QueryLogsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_logdata_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryLogsRequest.ProtoReflect.Descriptor instead.
+func (*QueryLogsRequest) Descriptor() ([]byte, []int) {
+	return file_logdata_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *QueryLogsRequest) GetAccount() string {
+	if x != nil {
+		return x.Account
+	}
+	return ""
+}
+
+func (x *QueryLogsRequest) GetSystem() string {
+	if x != nil {
+		return x.System
+	}
+	return ""
+}
+
+func (x *QueryLogsRequest) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *QueryLogsRequest) GetModule() string {
+	if x != nil {
+		return x.Module
+	}
+	return ""
+}
+
+func (x *QueryLogsRequest) GetTask() string {
+	if x != nil {
+		return x.Task
+	}
+	return ""
+}
+
+func (x *QueryLogsRequest) GetTraceId() string {
+	if x != nil {
+		return x.TraceId
+	}
+	return ""
+}
+
+func (x *QueryLogsRequest) GetLevel() int32 {
+	if x != nil && x.Level != nil {
+		return *x.Level
+	}
+	return 0
+}
+
+func (x *QueryLogsRequest) GetStartTime() string {
+	if x != nil {
+		return x.StartTime
+	}
+	return ""
+}
+
+func (x *QueryLogsRequest) GetEndTime() string {
+	if x != nil {
+		return x.EndTime
+	}
+	return ""
+}
+
+func (x *QueryLogsRequest) GetLimit() int64 {
+	if x != nil && x.Limit != nil {
+		return *x.Limit
+	}
+	return 0
+}
+
+func (x *QueryLogsRequest) GetOffset() int64 {
+	if x != nil && x.Offset != nil {
+		return *x.Offset
+	}
+	return 0
+}
+
+func (x *QueryLogsRequest) GetFields() map[string]string {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+var File_logdata_proto protoreflect.FileDescriptor
+
+const file_logdata_proto_rawDesc = "" +
+	"\n" +
+	"\rlogdata.proto\x12\n" +
+	"logdata.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x8c\x04\n" +
+	"\bLogEntry\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x18\n" +
+	"\aaccount\x18\x02 \x01(\tR\aaccount\x12\x16\n" +
+	"\x06system\x18\x03 \x01(\tR\x06system\x12\x12\n" +
+	"\x04user\x18\x04 \x01(\tR\x04user\x12\x16\n" +
+	"\x06module\x18\x05 \x01(\tR\x06module\x12\x12\n" +
+	"\x04task\x18\x06 \x01(\tR\x04task\x128\n" +
+	"\ttimestamp\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x10\n" +
+	"\x03msg\x18\b \x01(\tR\x03msg\x12\x14\n" +
+	"\x05level\x18\t \x01(\x05R\x05level\x12\x1f\n" +
+	"\vstack_trace\x18\n" +
+	" \x01(\tR\n" +
+	"stackTrace\x12/\n" +
+	"\x06fields\x18\v \x01(\v2\x17.google.protobuf.StructR\x06fields\x12\x19\n" +
+	"\btrace_id\x18\f \x01(\tR\atraceId\x12\x17\n" +
+	"\aspan_id\x18\r \x01(\tR\x06spanId\x12\x12\n" +
+	"\x04ulid\x18\x0e \x01(\tR\x04ulid\x12!\n" +
+	"\frepeat_count\x18\x0f \x01(\x03R\vrepeatCount\x12!\n" +
+	"\fsampled_rate\x18\x10 \x01(\x01R\vsampledRate\x12\x1d\n" +
+	"\n" +
+	"source_seq\x18\x11 \x01(\tR\tsourceSeq\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x12 \x01(\tR\tsessionId\"<\n" +
+	"\x0ePushLogRequest\x12*\n" +
+	"\x05entry\x18\x01 \x01(\v2\x14.logdata.v1.LogEntryR\x05entry\"?\n" +
+	"\x0fPushLogResponse\x12\x18\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage\x12\x12\n" +
+	"\x04ulid\x18\x02 \x01(\tR\x04ulid\"\xa6\x01\n" +
+	"\x15PushLogStreamResponse\x12\x1a\n" +
+	"\baccepted\x18\x01 \x01(\x03R\baccepted\x12\x1a\n" +
+	"\brejected\x18\x02 \x01(\x03R\brejected\x12\x1a\n" +
+	"\x06errors\x18\x03 \x03(\tB\x02\x18\x01R\x06errors\x129\n" +
+	"\n" +
+	"rejections\x18\x04 \x03(\v2\x19.logdata.v1.PushRejectionR\n" +
+	"rejections\";\n" +
+	"\rPushRejection\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x03R\x05index\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"\xc8\x03\n" +
+	"\x10QueryLogsRequest\x12\x18\n" +
+	"\aaccount\x18\x01 \x01(\tR\aaccount\x12\x16\n" +
+	"\x06system\x18\x02 \x01(\tR\x06system\x12\x12\n" +
+	"\x04user\x18\x03 \x01(\tR\x04user\x12\x16\n" +
+	"\x06module\x18\x04 \x01(\tR\x06module\x12\x12\n" +
+	"\x04task\x18\x05 \x01(\tR\x04task\x12\x19\n" +
+	"\btrace_id\x18\x06 \x01(\tR\atraceId\x12\x19\n" +
+	"\x05level\x18\a \x01(\x05H\x00R\x05level\x88\x01\x01\x12\x1d\n" +
+	"\n" +
+	"start_time\x18\b \x01(\tR\tstartTime\x12\x19\n" +
+	"\bend_time\x18\t \x01(\tR\aendTime\x12\x19\n" +
+	"\x05limit\x18\n" +
+	" \x01(\x03H\x01R\x05limit\x88\x01\x01\x12\x1b\n" +
+	"\x06offset\x18\v \x01(\x03H\x02R\x06offset\x88\x01\x01\x12@\n" +
+	"\x06fields\x18\f \x03(\v2(.logdata.v1.QueryLogsRequest.FieldsEntryR\x06fields\x1a9\n" +
+	"\vFieldsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\b\n" +
+	"\x06_levelB\b\n" +
+	"\x06_limitB\t\n" +
+	"\a_offset2\xe5\x01\n" +
+	"\n" +
+	"LogService\x12B\n" +
+	"\aPushLog\x12\x1a.logdata.v1.PushLogRequest\x1a\x1b.logdata.v1.PushLogResponse\x12P\n" +
+	"\rPushLogStream\x12\x1a.logdata.v1.PushLogRequest\x1a!.logdata.v1.PushLogStreamResponse(\x01\x12A\n" +
+	"\tQueryLogs\x12\x1c.logdata.v1.QueryLogsRequest\x1a\x14.logdata.v1.LogEntry0\x01B&Z$log-server/proto/logdatapb;logdatapbb\x06proto3"
+
+var (
+	file_logdata_proto_rawDescOnce sync.Once
+	file_logdata_proto_rawDescData []byte
+)
+
+func file_logdata_proto_rawDescGZIP() []byte {
+	file_logdata_proto_rawDescOnce.Do(func() {
+		file_logdata_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_logdata_proto_rawDesc), len(file_logdata_proto_rawDesc)))
+	})
+	return file_logdata_proto_rawDescData
+}
+
+var file_logdata_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_logdata_proto_goTypes = []any{
+	(*LogEntry)(nil),              // 0: logdata.v1.LogEntry
+	(*PushLogRequest)(nil),        // 1: logdata.v1.PushLogRequest
+	(*PushLogResponse)(nil),       // 2: logdata.v1.PushLogResponse
+	(*PushLogStreamResponse)(nil), // 3: logdata.v1.PushLogStreamResponse
+	(*PushRejection)(nil),         // 4: logdata.v1.PushRejection
+	(*QueryLogsRequest)(nil),      // 5: logdata.v1.QueryLogsRequest
+	nil,                           // 6: logdata.v1.QueryLogsRequest.FieldsEntry
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+	(*structpb.Struct)(nil),       // 8: google.protobuf.Struct
+}
+var file_logdata_proto_depIdxs = []int32{
+	7, // 0: logdata.v1.LogEntry.timestamp:type_name -> google.protobuf.Timestamp
+	8, // 1: logdata.v1.LogEntry.fields:type_name -> google.protobuf.Struct
+	0, // 2: logdata.v1.PushLogRequest.entry:type_name -> logdata.v1.LogEntry
+	4, // 3: logdata.v1.PushLogStreamResponse.rejections:type_name -> logdata.v1.PushRejection
+	6, // 4: logdata.v1.QueryLogsRequest.fields:type_name -> logdata.v1.QueryLogsRequest.FieldsEntry
+	1, // 5: logdata.v1.LogService.PushLog:input_type -> logdata.v1.PushLogRequest
+	1, // 6: logdata.v1.LogService.PushLogStream:input_type -> logdata.v1.PushLogRequest
+	5, // 7: logdata.v1.LogService.QueryLogs:input_type -> logdata.v1.QueryLogsRequest
+	2, // 8: logdata.v1.LogService.PushLog:output_type -> logdata.v1.PushLogResponse
+	3, // 9: logdata.v1.LogService.PushLogStream:output_type -> logdata.v1.PushLogStreamResponse
+	0, // 10: logdata.v1.LogService.QueryLogs:output_type -> logdata.v1.LogEntry
+	8, // [8:11] is the sub-list for method output_type
+	5, // [5:8] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_logdata_proto_init() }
+func file_logdata_proto_init() {
+	if File_logdata_proto != nil {
+		return
+	}
+	file_logdata_proto_msgTypes[5].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_logdata_proto_rawDesc), len(file_logdata_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_logdata_proto_goTypes,
+		DependencyIndexes: file_logdata_proto_depIdxs,
+		MessageInfos:      file_logdata_proto_msgTypes,
+	}.Build()
+	File_logdata_proto = out.File
+	file_logdata_proto_goTypes = nil
+	file_logdata_proto_depIdxs = nil
+}
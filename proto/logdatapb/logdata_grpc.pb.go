@@ -0,0 +1,210 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: logdata.proto
+
+package logdatapb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LogService_PushLog_FullMethodName       = "/logdata.v1.LogService/PushLog"
+	LogService_PushLogStream_FullMethodName = "/logdata.v1.LogService/PushLogStream"
+	LogService_QueryLogs_FullMethodName     = "/logdata.v1.LogService/QueryLogs"
+)
+
+// LogServiceClient is the client API for LogService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// LogService mirrors the HTTP ingestion and query API over gRPC.
+// Calls identify the writing account with the "x-account" metadata key, as
+// the X-Account header does over HTTP.
+type LogServiceClient interface {
+	// PushLog stores a single log entry.
+	PushLog(ctx context.Context, in *PushLogRequest, opts ...grpc.CallOption) (*PushLogResponse, error)
+	// PushLogStream stores every entry sent on the stream and reports a summary
+	// when the client closes it. Flow control on the stream provides backpressure.
+	PushLogStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PushLogRequest, PushLogStreamResponse], error)
+	// QueryLogs streams entries matching the filter, newest first.
+	QueryLogs(ctx context.Context, in *QueryLogsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LogEntry], error)
+}
+
+type logServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLogServiceClient(cc grpc.ClientConnInterface) LogServiceClient {
+	return &logServiceClient{cc}
+}
+
+func (c *logServiceClient) PushLog(ctx context.Context, in *PushLogRequest, opts ...grpc.CallOption) (*PushLogResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PushLogResponse)
+	err := c.cc.Invoke(ctx, LogService_PushLog_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logServiceClient) PushLogStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PushLogRequest, PushLogStreamResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LogService_ServiceDesc.Streams[0], LogService_PushLogStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PushLogRequest, PushLogStreamResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_PushLogStreamClient = grpc.ClientStreamingClient[PushLogRequest, PushLogStreamResponse]
+
+func (c *logServiceClient) QueryLogs(ctx context.Context, in *QueryLogsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LogEntry], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LogService_ServiceDesc.Streams[1], LogService_QueryLogs_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[QueryLogsRequest, LogEntry]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_QueryLogsClient = grpc.ServerStreamingClient[LogEntry]
+
+// LogServiceServer is the server API for LogService service.
+// All implementations must embed UnimplementedLogServiceServer
+// for forward compatibility.
+//
+// LogService mirrors the HTTP ingestion and query API over gRPC.
+// Calls identify the writing account with the "x-account" metadata key, as
+// the X-Account header does over HTTP.
+type LogServiceServer interface {
+	// PushLog stores a single log entry.
+	PushLog(context.Context, *PushLogRequest) (*PushLogResponse, error)
+	// PushLogStream stores every entry sent on the stream and reports a summary
+	// when the client closes it. Flow control on the stream provides backpressure.
+	PushLogStream(grpc.ClientStreamingServer[PushLogRequest, PushLogStreamResponse]) error
+	// QueryLogs streams entries matching the filter, newest first.
+	QueryLogs(*QueryLogsRequest, grpc.ServerStreamingServer[LogEntry]) error
+	mustEmbedUnimplementedLogServiceServer()
+}
+
+// UnimplementedLogServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLogServiceServer struct{}
+
+func (UnimplementedLogServiceServer) PushLog(context.Context, *PushLogRequest) (*PushLogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PushLog not implemented")
+}
+func (UnimplementedLogServiceServer) PushLogStream(grpc.ClientStreamingServer[PushLogRequest, PushLogStreamResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method PushLogStream not implemented")
+}
+func (UnimplementedLogServiceServer) QueryLogs(*QueryLogsRequest, grpc.ServerStreamingServer[LogEntry]) error {
+	return status.Errorf(codes.Unimplemented, "method QueryLogs not implemented")
+}
+func (UnimplementedLogServiceServer) mustEmbedUnimplementedLogServiceServer() {}
+func (UnimplementedLogServiceServer) testEmbeddedByValue()                    {}
+
+// UnsafeLogServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LogServiceServer will
+// result in compilation errors.
+type UnsafeLogServiceServer interface {
+	mustEmbedUnimplementedLogServiceServer()
+}
+
+func RegisterLogServiceServer(s grpc.ServiceRegistrar, srv LogServiceServer) {
+	// If the following call pancis, it indicates UnimplementedLogServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&LogService_ServiceDesc, srv)
+}
+
+func _LogService_PushLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PushLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServiceServer).PushLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LogService_PushLog_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServiceServer).PushLog(ctx, req.(*PushLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LogService_PushLogStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LogServiceServer).PushLogStream(&grpc.GenericServerStream[PushLogRequest, PushLogStreamResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_PushLogStreamServer = grpc.ClientStreamingServer[PushLogRequest, PushLogStreamResponse]
+
+func _LogService_QueryLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryLogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LogServiceServer).QueryLogs(m, &grpc.GenericServerStream[QueryLogsRequest, LogEntry]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LogService_QueryLogsServer = grpc.ServerStreamingServer[LogEntry]
+
+// LogService_ServiceDesc is the grpc.ServiceDesc for LogService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LogService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "logdata.v1.LogService",
+	HandlerType: (*LogServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PushLog",
+			Handler:    _LogService_PushLog_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PushLogStream",
+			Handler:       _LogService_PushLogStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "QueryLogs",
+			Handler:       _LogService_QueryLogs_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "logdata.proto",
+}
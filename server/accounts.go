@@ -0,0 +1,463 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// accountDeletionTTL is how long a token from POST
+// /admin/accounts/{account}/deletion confirms the deletion.
+const accountDeletionTTL = 15 * time.Minute
+
+// accountTables are the tables besides logData holding rows of an account in
+// an account column. The data keys are deleted but never exported, and
+// audit_log outlives the account as the record of its deletion.
+// anomaly_state, the last hour analyzed for all accounts, holds no rows of
+// one.
+var accountTables = []struct {
+	name           string
+	export, delete bool
+}{
+	{"annotations", true, true},
+	{"saved_searches", true, true},
+	{"alert_rules", true, true},
+	{"reports", true, true},
+	{"webhook_subscriptions", true, true},
+	{"email_notifiers", true, true},
+	{"level_schemes", true, true},
+	{"extraction_rules", true, true},
+	{"level_thresholds", true, true},
+	{"level_retention", true, true},
+	{"custom_columns", true, true},
+	{"custom_values", true, true},
+	{"account_networks", true, true},
+	{"account_settings", true, true},
+	{"fingerprints", true, true},
+	{"source_heartbeats", true, true},
+	{"entry_hashes", true, true},
+	{"log_rollups_hourly", true, true},
+	{"log_rollups_daily", true, true},
+	{"volume_baselines", true, true},
+	{"anomalies", true, true},
+	{"account_usage", true, true},
+	{"archives", true, true},
+	{"rejected_logs", true, true},
+	{"idempotency_keys", false, true},
+	{"account_keys", false, true},
+	{"audit_log", true, false},
+}
+
+// AccountExportManifest is manifest.json in an account export, counting the
+// rows of every file in it.
+type AccountExportManifest struct {
+	Account        string           `json:"account"`
+	ExportedAt     time.Time        `json:"exported_at"`
+	Entries        int64            `json:"entries"`
+	Tables         map[string]int64 `json:"tables"`
+	ArchiveObjects int              `json:"archive_objects"`
+}
+
+// AccountDeletion is a pending deletion of an account: the rows it would
+// remove and the token confirming it until ExpiresAt.
+type AccountDeletion struct {
+	Account   string           `json:"account"`
+	Token     string           `json:"token"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	Rows      map[string]int64 `json:"rows"`
+}
+
+// AccountDeleted reports the rows removed by a confirmed deletion.
+type AccountDeleted struct {
+	Account        string           `json:"account"`
+	Rows           map[string]int64 `json:"rows"`
+	ArchiveObjects int              `json:"archive_objects"`
+}
+
+// pendingDeletions holds the unconfirmed deletion of each account. Tokens
+// are lost on restart, which only means requesting a new one.
+var pendingDeletions = struct {
+	sync.Mutex
+	m map[string]AccountDeletion
+}{m: map[string]AccountDeletion{}}
+
+// handleAccounts serves the offboarding API: GET
+// /admin/accounts/{account}/export, POST /admin/accounts/{account}/deletion
+// and DELETE /admin/accounts/{account}?confirm=, and the account's settings
+// at /admin/accounts/{account}/settings. archive is nil unless archival is
+// configured.
+func handleAccounts(db, readDB *sql.DB, cfg *Config, archive *archiver, webhooks *webhookDispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/accounts"), "/")
+		account, action, _ := strings.Cut(rest, "/")
+		if account == "" || account == allAccounts {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "A single account is required")
+			return
+		}
+		switch {
+		case action == "export" && r.Method == http.MethodGet:
+			exportAccount(db, readDB, cfg, archive, w, r, account)
+		case action == "deletion" && r.Method == http.MethodPost:
+			requestAccountDeletion(db, w, r, account)
+		case action == "" && r.Method == http.MethodDelete:
+			deleteAccount(db, w, r, account, archive, webhooks)
+		case action == "settings":
+			handleAccountSettings(db, w, r, account)
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// exportAccount streams a zip of everything stored for account:
+// logs.ndjson in the format POST /import reads, tables/{table}.ndjson with
+// the account's rows of each other table, archive/ with its objects in
+// object storage and manifest.json. The files are read in one transaction,
+// so they are consistent with each other. Like /export, a failure
+// mid-stream leaves the zip without its directory.
+func exportAccount(db, readDB *sql.DB, cfg *Config, archive *archiver, w http.ResponseWriter, r *http.Request, account string) {
+	tx, err := readDB.BeginTx(r.Context(), nil)
+	if err != nil {
+		requestLogger(r).Error("Error starting account export", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to export account")
+		return
+	}
+	defer tx.Rollback()
+
+	manifest := AccountExportManifest{Account: account, ExportedAt: timeNow().UTC(), Tables: map[string]int64{}}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-account-%s.zip"`, account, manifest.ExportedAt.Format("20060102T150405Z")))
+	zw := zip.NewWriter(w)
+	create := func(name string, method uint16) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: manifest.ExportedAt})
+	}
+	defer func() { recordRead(db, r, cfg, "account_export", account, int(manifest.Entries)) }()
+
+	f, err := create("logs.ndjson", zip.Deflate)
+	if err == nil {
+		manifest.Entries, err = exportAccountEntries(r.Context(), tx, f, account)
+	}
+	if err != nil {
+		requestLogger(r).Warn("Account export aborted", "account", account, "file", "logs.ndjson", "err", err)
+		return
+	}
+	for _, table := range accountTables {
+		if !table.export {
+			continue
+		}
+		f, err := create("tables/"+table.name+".ndjson", zip.Deflate)
+		if err == nil {
+			manifest.Tables[table.name], err = exportAccountRows(r.Context(), tx, f, table.name, account)
+		}
+		if err != nil {
+			requestLogger(r).Warn("Account export aborted", "account", account, "file", table.name, "err", err)
+			return
+		}
+	}
+
+	keys, err := archiveObjectKeys(r.Context(), tx, account)
+	if err != nil {
+		requestLogger(r).Warn("Account export aborted", "account", account, "file", "archive", "err", err)
+		return
+	}
+	if len(keys) > 0 && archive == nil {
+		requestLogger(r).Warn("Account export skips archived objects: archival is not configured", "account", account, "objects", len(keys))
+	}
+	for _, key := range keys {
+		if archive == nil {
+			break
+		}
+		// The objects are gzipped already
+		f, err := create("archive/"+path.Base(key), zip.Store)
+		if err == nil {
+			err = archive.copyObject(r.Context(), f, key)
+		}
+		if err != nil {
+			requestLogger(r).Warn("Account export aborted", "account", account, "file", key, "err", err)
+			return
+		}
+		manifest.ArchiveObjects++
+	}
+
+	f, err = create("manifest.json", zip.Deflate)
+	if err == nil {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(manifest)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		requestLogger(r).Warn("Account export aborted", "account", account, "file", "manifest.json", "err", err)
+		return
+	}
+	requestLogger(r).Info("Exported account", "account", account, "entries", manifest.Entries, "archive_objects", manifest.ArchiveObjects)
+}
+
+func exportAccountEntries(ctx context.Context, tx *sql.Tx, w io.Writer, account string) (int64, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT "+logDataColumns+" FROM "+logDataSource(time.Time{}, time.Time{})+
+		" WHERE account = ? ORDER BY timestamp, id", account)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	enc := json.NewEncoder(w)
+	var n int64
+	for rows.Next() {
+		logData, err := scanLogData(rows)
+		if err != nil {
+			return n, err
+		}
+		if err := enc.Encode(logData); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// exportAccountRows writes the account's rows of table as JSON objects keyed
+// by column.
+func exportAccountRows(ctx context.Context, tx *sql.Tx, w io.Writer, table, account string) (int64, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT * FROM "+table+" WHERE account = ?", account)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	enc := json.NewEncoder(w)
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[column] = values[i]
+		}
+		if err := enc.Encode(row); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func archiveObjectKeys(ctx context.Context, q queryer, account string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT object_key FROM archives WHERE account = ? ORDER BY day, id", account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// copyObject writes the stored bytes of an archived object to w.
+func (a *archiver) copyObject(ctx context.Context, w io.Writer, key string) error {
+	obj, err := a.client.GetObject(ctx, a.cfg.ArchiveBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	_, err = io.Copy(w, obj)
+	return err
+}
+
+// requestAccountDeletion counts what deleting account would remove and
+// issues the token confirming it, replacing any earlier one.
+func requestAccountDeletion(db *sql.DB, w http.ResponseWriter, r *http.Request, account string) {
+	rows, err := countAccountRows(db, account)
+	if err != nil {
+		requestLogger(r).Error("Error counting account rows", "account", account, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to count account data")
+		return
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		requestLogger(r).Error("Error generating confirmation token", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to request account deletion")
+		return
+	}
+	deletion := AccountDeletion{Account: account, Token: hex.EncodeToString(b), ExpiresAt: timeNow().UTC().Add(accountDeletionTTL), Rows: rows}
+	pendingDeletions.Lock()
+	pendingDeletions.m[account] = deletion
+	pendingDeletions.Unlock()
+
+	recordAudit(db, r, "admin", "account_deletion_requested", account, formatRowCounts(rows))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deletion)
+}
+
+// countAccountRows counts the rows of account that deleteAccount removes, by
+// table, with its entries as "logData".
+func countAccountRows(db *sql.DB, account string) (map[string]int64, error) {
+	rows := map[string]int64{}
+	var n int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM "+logDataSource(time.Time{}, time.Time{})+" WHERE account = ?", account).Scan(&n); err != nil {
+		return nil, err
+	}
+	rows["logData"] = n
+	for _, table := range accountTables {
+		if !table.delete {
+			continue
+		}
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+table.name+" WHERE account = ?", account).Scan(&n); err != nil {
+			return nil, err
+		}
+		rows[table.name] = n
+	}
+	return rows, nil
+}
+
+// deleteAccount irreversibly removes every row of account, and its archived
+// objects, once confirmed by the token of a pending deletion. Objects are
+// removed first: if that fails nothing else is, and the deletion can be
+// retried with the same token.
+func deleteAccount(db *sql.DB, w http.ResponseWriter, r *http.Request, account string, archive *archiver, webhooks *webhookDispatcher) {
+	confirm := r.URL.Query().Get("confirm")
+	pendingDeletions.Lock()
+	pending, ok := pendingDeletions.m[account]
+	pendingDeletions.Unlock()
+	if !ok || timeNow().After(pending.ExpiresAt) || subtle.ConstantTimeCompare([]byte(confirm), []byte(pending.Token)) != 1 {
+		requestLogger(r).Warn("Account deletion not confirmed", "account", account)
+		writeError(w, http.StatusConflict, codeConflict, "A confirm token from POST /admin/accounts/{account}/deletion is required")
+		return
+	}
+
+	keys, err := archiveObjectKeys(r.Context(), db, account)
+	if err != nil {
+		requestLogger(r).Error("Error listing archived objects", "account", account, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete account")
+		return
+	}
+	if len(keys) > 0 && archive == nil {
+		writeError(w, http.StatusConflict, codeConflict, "The account has archived objects; configure archival to delete them")
+		return
+	}
+	for _, key := range keys {
+		if err := archive.client.RemoveObject(r.Context(), archive.cfg.ArchiveBucket, key, minio.RemoveObjectOptions{}); err != nil {
+			requestLogger(r).Error("Error removing archived object", "key", key, "err", err)
+			writeError(w, http.StatusBadGateway, codeUpstreamFailed, "Failed to remove archived objects")
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		requestLogger(r).Error("Error starting account deletion", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete account")
+		return
+	}
+	defer tx.Rollback()
+	deleted := AccountDeleted{Account: account, Rows: map[string]int64{}, ArchiveObjects: len(keys)}
+	if deleted.Rows["logData"], err = deleteLogData(tx, "account = ?", []interface{}{account}); err != nil {
+		requestLogger(r).Error("Error deleting log data", "account", account, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete account")
+		return
+	}
+	for _, table := range accountTables {
+		if !table.delete {
+			continue
+		}
+		res, err := tx.Exec("DELETE FROM "+table.name+" WHERE account = ?", account)
+		if err != nil {
+			requestLogger(r).Error("Error deleting account rows", "account", account, "table", table.name, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete account")
+			return
+		}
+		deleted.Rows[table.name], _ = res.RowsAffected()
+	}
+	if err := tx.Commit(); err != nil {
+		requestLogger(r).Error("Error committing account deletion", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete account")
+		return
+	}
+	pendingDeletions.Lock()
+	delete(pendingDeletions.m, account)
+	pendingDeletions.Unlock()
+
+	encryption.forget(account)
+	queryResults.invalidateAccount(account)
+	vacuums.deleted(deleted.Rows["logData"])
+	if err := allowlists.reload(); err != nil {
+		requestLogger(r).Error("Error reloading allowlists", "err", err)
+	}
+	if err := accountSettings.reload(); err != nil {
+		requestLogger(r).Error("Error reloading account settings", "err", err)
+	}
+	if err := levelSchemes.reload(); err != nil {
+		requestLogger(r).Error("Error reloading level schemes", "err", err)
+	}
+	if err := extractionRules.reload(); err != nil {
+		requestLogger(r).Error("Error reloading extraction rules", "err", err)
+	}
+	if err := levelThresholds.reload(); err != nil {
+		requestLogger(r).Error("Error reloading level thresholds", "err", err)
+	}
+	if err := retentionRules.reload(); err != nil {
+		requestLogger(r).Error("Error reloading retention rules", "err", err)
+	}
+	if err := webhooks.reload(); err != nil {
+		requestLogger(r).Error("Error reloading webhook subscriptions", "err", err)
+	}
+	if err := emailNotifiers.reload(); err != nil {
+		requestLogger(r).Error("Error reloading email notifiers", "err", err)
+	}
+	recordAudit(db, r, "admin", "account_delete", account, fmt.Sprintf("%s archive_objects=%d", formatRowCounts(deleted.Rows), len(keys)))
+	requestLogger(r).Info("Deleted account", "account", account, "entries", deleted.Rows["logData"], "archive_objects", len(keys))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deleted)
+}
+
+// formatRowCounts renders row counts as "table=n" pairs in table order.
+func formatRowCounts(rows map[string]int64) string {
+	tables := make([]string, 0, len(rows))
+	for table := range rows {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	parts := make([]string, len(tables))
+	for i, table := range tables {
+		parts[i] = fmt.Sprintf("%s=%d", table, rows[table])
+	}
+	return strings.Join(parts, " ")
+}
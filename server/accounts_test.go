@@ -0,0 +1,25 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"log-server/server"
+	"log-server/server/testutil"
+)
+
+func TestAccountDeletionCoversAnomalies(t *testing.T) {
+	srv := testutil.NewServer(t, nil)
+	srv.Seed(srv.Entry("acme", "to be deleted"))
+	rec := serve(srv, http.MethodPost, "/admin/accounts/acme/deletion", "", nil)
+	var deletion server.AccountDeletion
+	if err := json.NewDecoder(rec.Body).Decode(&deletion); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("POST /admin/accounts/acme/deletion: %d %v", rec.Code, err)
+	}
+	for _, table := range []string{"volume_baselines", "anomalies"} {
+		if _, ok := deletion.Rows[table]; !ok {
+			t.Errorf("deletion of acme leaves %s, want its rows counted: %v", table, deletion.Rows)
+		}
+	}
+}
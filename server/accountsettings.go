@@ -0,0 +1,277 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const accountSettingsSchema = `CREATE TABLE IF NOT EXISTS account_settings (
+    account TEXT PRIMARY KEY,
+    settings TEXT NOT NULL,
+    updated_at DATETIME NOT NULL
+)`
+
+// AccountSettings turns the heavier features off for one account, or sets
+// its own limits, so they can be rolled out account by account. Unset
+// fields keep the server's behavior: features on and the global limits.
+type AccountSettings struct {
+	Account string `json:"account"`
+	// FullTextSearch allows msg_regex and query= search strings, which scan
+	// messages.
+	FullTextSearch *bool `json:"full_text_search,omitempty"`
+	// Streaming allows /getdata?format=ndjson and /export.
+	Streaming *bool `json:"streaming,omitempty"`
+	// Webhooks allows deliveries of the account's entries to its webhook
+	// subscriptions.
+	Webhooks *bool `json:"webhooks,omitempty"`
+	// MaxBatchSize replaces MAX_BATCH_SIZE for the account's batches.
+	MaxBatchSize *int `json:"max_batch_size,omitempty"`
+	// MaxQueryRange bounds end_time - start_time of the account's queries,
+	// as a duration such as 24h or 7d.
+	MaxQueryRange string    `json:"max_query_range,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	maxQueryRange time.Duration
+}
+
+// Validate checks the limits, parsing MaxQueryRange.
+func (s *AccountSettings) Validate() error {
+	if s.MaxBatchSize != nil && *s.MaxBatchSize < 1 {
+		return fmt.Errorf("max_batch_size must be at least 1")
+	}
+	if s.MaxQueryRange != "" {
+		d, err := parseRelativeDuration(s.MaxQueryRange)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("max_query_range must be a positive duration such as 24h or 7d")
+		}
+		s.maxQueryRange = d
+	}
+	return nil
+}
+
+// accountSettings holds the account_settings rows in memory. It is set at
+// startup.
+var accountSettings *accountSettingSet
+
+type accountSettingSet struct {
+	db *sql.DB
+
+	mu        sync.RWMutex
+	byAccount map[string]AccountSettings
+}
+
+func newAccountSettingSet(db *sql.DB) *accountSettingSet {
+	return &accountSettingSet{db: db, byAccount: map[string]AccountSettings{}}
+}
+
+// reload refreshes the in-memory settings from the database.
+func (s *accountSettingSet) reload() error {
+	rows, err := s.db.Query("SELECT account, settings, updated_at FROM account_settings")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byAccount := map[string]AccountSettings{}
+	for rows.Next() {
+		var settings AccountSettings
+		var account, encoded string
+		var updatedAt time.Time
+		if err := rows.Scan(&account, &encoded, &updatedAt); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(encoded), &settings); err != nil {
+			return fmt.Errorf("invalid settings for account %s: %v", account, err)
+		}
+		if err := settings.Validate(); err != nil {
+			return fmt.Errorf("invalid settings for account %s: %v", account, err)
+		}
+		settings.Account, settings.UpdatedAt = account, updatedAt
+		byAccount[account] = settings
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.byAccount = byAccount
+	s.mu.Unlock()
+	return nil
+}
+
+// get returns the settings of account, which are empty without a row.
+func (s *accountSettingSet) get(account string) AccountSettings {
+	if s == nil {
+		return AccountSettings{Account: account}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	settings, ok := s.byAccount[account]
+	if !ok {
+		settings.Account = account
+	}
+	return settings
+}
+
+// enabled reports whether a feature flag is on, which unset flags are.
+func enabled(flag *bool) bool {
+	return flag == nil || *flag
+}
+
+// webhooksEnabled reports whether the entries of account are delivered to
+// webhooks.
+func (s *accountSettingSet) webhooksEnabled(account string) bool {
+	return enabled(s.get(account).Webhooks)
+}
+
+// maxBatchSize returns the largest batch accepted with entries of accounts:
+// the lowest of their MaxBatchSize, or MAX_BATCH_SIZE for accounts without.
+func (s *accountSettingSet) maxBatchSize(cfg *Config, accounts ...string) int {
+	limit := 0
+	for _, account := range accounts {
+		n := cfg.Live().MaxBatchSize
+		if size := s.get(account).MaxBatchSize; size != nil {
+			n = *size
+		}
+		if limit == 0 || n < limit {
+			limit = n
+		}
+	}
+	if limit == 0 {
+		return cfg.Live().MaxBatchSize
+	}
+	return limit
+}
+
+// batchAccounts returns the distinct accounts of entries, for maxBatchSize.
+func batchAccounts(entries []LogData) []string {
+	var accounts []string
+	for _, entry := range entries {
+		if !slices.Contains(accounts, entry.Account) {
+			accounts = append(accounts, entry.Account)
+		}
+	}
+	return accounts
+}
+
+// settingError is a request refused by an account's settings.
+type settingError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *settingError) Error() string { return e.msg }
+
+// checkQuery refuses a query of params that the account's settings do not
+// allow: a full-text filter without FullTextSearch, or a range longer than
+// MaxQueryRange. Cross-account queries are not limited.
+func (s *accountSettingSet) checkQuery(params QueryParams) error {
+	if params.Account == allAccounts {
+		return nil
+	}
+	settings := s.get(params.Account)
+	if !enabled(settings.FullTextSearch) && (params.MsgRegex != "" || params.Search != "") {
+		return &settingError{http.StatusForbidden, codeForbidden, "Full-text search (msg_regex and query) is disabled for this account"}
+	}
+	if settings.maxQueryRange > 0 {
+		start, err := time.Parse(time.RFC3339Nano, params.StartTime)
+		if err != nil {
+			return &settingError{http.StatusRequestEntityTooLarge, codeQueryTooLarge,
+				fmt.Sprintf("start_time is required; the time range of this account's queries is limited to %s", settings.MaxQueryRange)}
+		}
+		end, err := time.Parse(time.RFC3339Nano, params.EndTime)
+		if err != nil {
+			end = timeNow()
+		}
+		if end.Sub(start) > settings.maxQueryRange {
+			return &settingError{http.StatusRequestEntityTooLarge, codeQueryTooLarge,
+				fmt.Sprintf("The time range exceeds this account's maximum of %s; narrow start_time and end_time", settings.MaxQueryRange)}
+		}
+	}
+	return nil
+}
+
+// checkStreaming refuses a streamed read of account without Streaming.
+func (s *accountSettingSet) checkStreaming(account string) error {
+	if account != allAccounts && !enabled(s.get(account).Streaming) {
+		return &settingError{http.StatusForbidden, codeForbidden, "Streaming reads (format=ndjson and /export) are disabled for this account"}
+	}
+	return nil
+}
+
+// allowedBySettings answers the error of checkQuery or checkStreaming, nil
+// when the account's settings allow the request. It reports whether the
+// request may proceed.
+func allowedBySettings(w http.ResponseWriter, r *http.Request, err error) bool {
+	if err == nil {
+		return true
+	}
+	settingErr := err.(*settingError)
+	requestLogger(r).Warn("Refused by account settings", "err", err)
+	writeError(w, settingErr.status, settingErr.code, settingErr.msg)
+	return false
+}
+
+// handleAccountSettings serves GET, PUT and DELETE
+// /admin/accounts/{account}/settings. PUT replaces every setting, and
+// DELETE restores the server's defaults.
+func handleAccountSettings(db *sql.DB, w http.ResponseWriter, r *http.Request, account string) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(accountSettings.get(account))
+
+	case http.MethodPut:
+		var settings AccountSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			requestLogger(r).Warn("Invalid request body", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		if err := settings.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+			return
+		}
+		settings.Account, settings.UpdatedAt = account, timeNow().UTC()
+		encoded, _ := json.Marshal(settings)
+		if _, err := db.Exec(`INSERT INTO account_settings (account, settings, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT (account) DO UPDATE SET settings = excluded.settings, updated_at = excluded.updated_at`,
+			account, string(encoded), settings.UpdatedAt); err != nil {
+			requestLogger(r).Error("Error saving account settings", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save account settings")
+			return
+		}
+		if err := accountSettings.reload(); err != nil {
+			requestLogger(r).Error("Error reloading account settings", "err", err)
+		}
+		recordAudit(db, r, "admin", "settings_update", account, string(encoded))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+
+	case http.MethodDelete:
+		res, err := db.Exec("DELETE FROM account_settings WHERE account = ?", account)
+		if err != nil {
+			requestLogger(r).Error("Error deleting account settings", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete account settings")
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, http.StatusNotFound, codeNotFound, "Account settings not found")
+			return
+		}
+		if err := accountSettings.reload(); err != nil {
+			requestLogger(r).Error("Error reloading account settings", "err", err)
+		}
+		recordAudit(db, r, "admin", "settings_delete", account, "")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Account settings deleted"})
+
+	default:
+		requestLogger(r).Warn("Method not allowed", "method", r.Method)
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
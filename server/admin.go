@@ -0,0 +1,44 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>"
+// header, or the "Splunk <token>" header of Splunk HEC clients.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		if token, ok = strings.CutPrefix(header, "Splunk "); !ok {
+			return ""
+		}
+	}
+	return strings.TrimSpace(token)
+}
+
+// isAdmin reports whether the request carries the configured admin token.
+func isAdmin(r *http.Request, cfg *Config) bool {
+	return isAdminToken(bearerToken(r), cfg)
+}
+
+// isAdminToken reports whether token is the configured admin token.
+func isAdminToken(token string, cfg *Config) bool {
+	token = strings.TrimSpace(token)
+	return cfg.AdminToken != "" && token != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1
+}
+
+// requireAdmin rejects requests without the admin token.
+func requireAdmin(cfg *Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r, cfg) {
+			requestLogger(r).Warn("Admin access denied", "method", r.Method, "path", r.URL.Path)
+			writeError(w, http.StatusForbidden, codeForbidden, "Admin token required")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Writes are admitted unless an admin put the server in maintenance mode or
+// it applies backpressure: the ack=async queue is filling up, or the disk
+// holding the database runs out of space. Refused writes get 503 with
+// Retry-After, so producers keep their entries and send them again.
+
+// diskCheckInterval is how often the free disk space is measured.
+const diskCheckInterval = time.Second
+
+// Retry-After of refused writes in seconds: by default in maintenance mode,
+// and under backpressure from the queue or the disk.
+const (
+	maintenanceRetryAfter = 60
+	queueRetryAfter       = 1
+	diskRetryAfter        = 60
+)
+
+// Maintenance is the state of maintenance mode, set by PUT
+// /admin/maintenance. While Enabled, the server only serves reads and
+// /admin endpoints.
+type Maintenance struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+	// RetryAfter is the Retry-After of refused writes in seconds,
+	// maintenanceRetryAfter when unset.
+	RetryAfter int        `json:"retry_after,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+}
+
+// AdmissionStatus is the response of GET /admin/maintenance.
+type AdmissionStatus struct {
+	Maintenance Maintenance `json:"maintenance"`
+	// Backpressure says why writes are refused automatically, empty while
+	// they are accepted.
+	Backpressure  string  `json:"backpressure,omitempty"`
+	QueuedWrites  int     `json:"queued_writes"`
+	QueueSize     int     `json:"queue_size"`
+	FreeDiskBytes *uint64 `json:"free_disk_bytes,omitempty"`
+}
+
+// refusalError is why a write is not admitted.
+type refusalError struct {
+	code       string
+	message    string
+	retryAfter int
+}
+
+func (e *refusalError) Error() string { return e.message }
+
+// admission holds maintenance mode and measures backpressure. It is set by
+// New.
+var admission *admissionControl
+
+type admissionControl struct {
+	cfg *Config
+	aw  *asyncWriter
+	// dir holds the database, empty when in memory.
+	dir string
+
+	mu          sync.Mutex
+	maintenance Maintenance
+	checkedAt   time.Time
+	freeDisk    uint64
+	diskKnown   bool
+}
+
+func newAdmissionControl(cfg *Config, aw *asyncWriter) *admissionControl {
+	a := &admissionControl{cfg: cfg, aw: aw}
+	if !isMemoryDatabase(cfg.DatabasePath) {
+		path := strings.TrimPrefix(cfg.DatabasePath, "file:")
+		path, _, _ = strings.Cut(path, "?")
+		a.dir = filepath.Dir(path)
+	}
+	return a
+}
+
+// refusal returns why writes are refused, or nil when they are admitted.
+func (a *admissionControl) refusal() *refusalError {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maintenance.Enabled {
+		message := "Server in maintenance mode; writes are refused"
+		if a.maintenance.Reason != "" {
+			message += ": " + a.maintenance.Reason
+		}
+		return &refusalError{code: codeMaintenance, message: message, retryAfter: a.maintenance.RetryAfter}
+	}
+	if reason, retryAfter := a.backpressure(); reason != "" {
+		return &refusalError{code: codeUnavailable, message: reason + "; retry later", retryAfter: retryAfter}
+	}
+	return nil
+}
+
+// backpressure returns why writes are refused automatically and when to
+// retry, measuring the free disk space when the last measure is older than
+// diskCheckInterval. a.mu must be held.
+func (a *admissionControl) backpressure() (string, int) {
+	if a.cfg.MinFreeDiskBytes > 0 && a.dir != "" {
+		if now := time.Now(); now.Sub(a.checkedAt) >= diskCheckInterval {
+			a.freeDisk, a.diskKnown = freeDiskSpace(a.dir)
+			a.checkedAt = now
+		}
+		if a.diskKnown && a.freeDisk < uint64(a.cfg.MinFreeDiskBytes) {
+			return fmt.Sprintf("Free disk space below %d bytes", a.cfg.MinFreeDiskBytes), diskRetryAfter
+		}
+	}
+	if ratio := a.cfg.BackpressureQueueRatio; ratio > 0 && a.aw != nil && cap(a.aw.queue) > 0 {
+		if float64(len(a.aw.queue)) >= ratio*float64(cap(a.aw.queue)) {
+			return "Write queue filling up", queueRetryAfter
+		}
+	}
+	return "", 0
+}
+
+func (a *admissionControl) status() AdmissionStatus {
+	if a == nil {
+		return AdmissionStatus{}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	status := AdmissionStatus{Maintenance: a.maintenance}
+	status.Backpressure, _ = a.backpressure()
+	if a.aw != nil {
+		status.QueuedWrites, status.QueueSize = len(a.aw.queue), cap(a.aw.queue)
+	}
+	if a.diskKnown {
+		free := a.freeDisk
+		status.FreeDiskBytes = &free
+	}
+	return status
+}
+
+// writeRefusal answers a refused write.
+func writeRefusal(w http.ResponseWriter, refusal *refusalError) {
+	w.Header().Set("Retry-After", strconv.Itoa(refusal.retryAfter))
+	writeError(w, http.StatusServiceUnavailable, refusal.code, refusal.message)
+}
+
+// withAdmission answers requests other than GET and HEAD with writeRefusal
+// while writes are refused. /admin endpoints are exempt, so maintenance mode
+// can be turned off and space freed by purging.
+func withAdmission(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !strings.HasPrefix(r.URL.Path, "/admin/") {
+			if refusal := admission.refusal(); refusal != nil {
+				requestLogger(r).Warn("Write refused", "method", r.Method, "path", r.URL.Path, "reason", refusal.message)
+				writeRefusal(w, refusal)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleMaintenance serves GET /admin/maintenance, whether and why writes
+// are refused, and PUT /admin/maintenance, which turns maintenance mode on
+// or off. Maintenance mode is not kept across restarts.
+func handleMaintenance(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(admission.status())
+
+		case http.MethodPut:
+			var m Maintenance
+			if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+				requestLogger(r).Warn("Invalid request body", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+				return
+			}
+			if m.RetryAfter < 0 {
+				writeError(w, http.StatusBadRequest, codeValidationFailed, "Validation failed: retry_after must not be negative")
+				return
+			}
+			m.Since = nil
+			if m.Enabled {
+				if m.RetryAfter == 0 {
+					m.RetryAfter = maintenanceRetryAfter
+				}
+				since := timeNow().UTC()
+				m.Since = &since
+			} else {
+				m.Reason, m.RetryAfter = "", 0
+			}
+			admission.mu.Lock()
+			admission.maintenance = m
+			admission.mu.Unlock()
+			requestLogger(r).Info("Maintenance mode set", "enabled", m.Enabled, "reason", m.Reason)
+			recordAudit(db, r, "admin", "maintenance", "", fmt.Sprintf("enabled=%t reason=%s", m.Enabled, m.Reason))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(admission.status())
+
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
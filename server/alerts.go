@@ -0,0 +1,403 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AlertRule fires when more than Threshold entries at or above MinLevel match
+// the filter within the trailing Window. SlackURL and TeamsURL are Slack and
+// Microsoft Teams incoming webhooks.
+type AlertRule struct {
+	ID            int64      `json:"id"`
+	Account       string     `json:"account"`
+	Name          string     `json:"name"`
+	System        string     `json:"system,omitempty"`
+	Module        string     `json:"module,omitempty"`
+	MinLevel      int        `json:"min_level"`
+	Threshold     int64      `json:"threshold"`
+	WindowSeconds int64      `json:"window_seconds"`
+	WebhookURL    string     `json:"webhook_url,omitempty"`
+	Email         string     `json:"email,omitempty"`
+	SlackURL      string     `json:"slack_url,omitempty"`
+	TeamsURL      string     `json:"teams_url,omitempty"`
+	Enabled       bool       `json:"enabled"`
+	LastFiredAt   *time.Time `json:"last_fired_at,omitempty"`
+}
+
+// Validate ensures the rule can be evaluated and has somewhere to notify.
+func (a AlertRule) Validate() error {
+	if a.Account == "" || a.Name == "" {
+		return fmt.Errorf("account and name are required")
+	}
+	if err := a.validateCondition(); err != nil {
+		return err
+	}
+	if a.WebhookURL == "" && a.Email == "" && a.SlackURL == "" && a.TeamsURL == "" {
+		return fmt.Errorf("webhook_url, email, slack_url or teams_url is required")
+	}
+	if err := validChatURL("slack_url", a.SlackURL); err != nil {
+		return err
+	}
+	return validChatURL("teams_url", a.TeamsURL)
+}
+
+// validateCondition ensures the rule can be evaluated.
+func (a AlertRule) validateCondition() error {
+	if a.Account == "" {
+		return fmt.Errorf("account is required")
+	}
+	if a.WindowSeconds <= 0 {
+		return fmt.Errorf("window_seconds must be positive")
+	}
+	if a.Threshold < 0 {
+		return fmt.Errorf("threshold must not be negative")
+	}
+	return nil
+}
+
+// AlertEvent is the payload delivered when a rule fires.
+type AlertEvent struct {
+	Rule        AlertRule `json:"rule"`
+	Count       int64     `json:"count"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+}
+
+const alertRulesSchema = `CREATE TABLE IF NOT EXISTS alert_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account TEXT NOT NULL,
+    name TEXT NOT NULL,
+    system TEXT NOT NULL DEFAULT '',
+    module TEXT NOT NULL DEFAULT '',
+    min_level INTEGER NOT NULL,
+    threshold INTEGER NOT NULL,
+    window_seconds INTEGER NOT NULL,
+    webhook_url TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
+    enabled INTEGER NOT NULL DEFAULT 1,
+    last_fired_at DATETIME,
+    slack_url TEXT NOT NULL DEFAULT '',
+    teams_url TEXT NOT NULL DEFAULT ''
+)`
+
+const alertRuleColumns = "id, account, name, system, module, min_level, threshold, window_seconds, webhook_url, email, enabled, last_fired_at, slack_url, teams_url"
+
+// alertSampleSize is how many of the matching entries Slack and Teams
+// notifications list.
+const alertSampleSize = 5
+
+// initializeAlertRules creates alert_rules, adding the columns introduced
+// after it.
+func initializeAlertRules(db *sql.DB) error {
+	if _, err := db.Exec(alertRulesSchema); err != nil {
+		return fmt.Errorf("failed to create alert_rules table: %v", err)
+	}
+	if err := ensureColumn(db, "alert_rules", "slack_url", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	return ensureColumn(db, "alert_rules", "teams_url", "TEXT NOT NULL DEFAULT ''")
+}
+
+func scanAlertRule(scan func(dest ...interface{}) error) (AlertRule, error) {
+	var rule AlertRule
+	var lastFired sql.NullTime
+	err := scan(&rule.ID, &rule.Account, &rule.Name, &rule.System, &rule.Module, &rule.MinLevel,
+		&rule.Threshold, &rule.WindowSeconds, &rule.WebhookURL, &rule.Email, &rule.Enabled, &lastFired, &rule.SlackURL, &rule.TeamsURL)
+	if lastFired.Valid {
+		rule.LastFiredAt = &lastFired.Time
+	}
+	return rule, err
+}
+
+// query returns the /getdata parameters selecting the entries the rule
+// counts between start and end.
+func (rule AlertRule) query(start, end time.Time) url.Values {
+	query := url.Values{
+		"account":    {rule.Account},
+		"min_level":  {strconv.Itoa(rule.MinLevel)},
+		"start_time": {start.UTC().Format(time.RFC3339Nano)},
+		"end_time":   {end.UTC().Format(time.RFC3339Nano)},
+	}
+	if rule.System != "" {
+		query.Set("system", rule.System)
+	}
+	if rule.Module != "" {
+		query.Set("module", rule.Module)
+	}
+	return query
+}
+
+// countAlertMatches counts entries matching the rule between start and end.
+func countAlertMatches(db *sql.DB, rule AlertRule, start, end time.Time) (int64, error) {
+	params, err := parseQueryParams(rule.query(start, end))
+	if err != nil {
+		return 0, err
+	}
+	params.Account = rule.Account
+	where, args := buildLogFilter(params)
+	from, to := params.partitionRange()
+	var count int64
+	err = db.QueryRow("SELECT COALESCE(SUM(repeat_count), 0) FROM "+logDataSource(from, to)+" WHERE "+where, args...).Scan(&count)
+	return count, err
+}
+
+// runAlerts evaluates every enabled rule each interval.
+func runAlerts(stop <-chan struct{}, db *sql.DB, cfg *Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for tick(stop, ticker) {
+		if err := evaluateAlerts(db, cfg, timeNow()); err != nil {
+			slog.Error("Error evaluating alerts", "err", err)
+		}
+	}
+}
+
+func evaluateAlerts(db *sql.DB, cfg *Config, now time.Time) error {
+	rows, err := db.Query("SELECT " + alertRuleColumns + " FROM alert_rules WHERE enabled = 1")
+	if err != nil {
+		return err
+	}
+	var rules []AlertRule
+	for rows.Next() {
+		rule, err := scanAlertRule(rows.Scan)
+		if err != nil {
+			slog.Error("Error scanning alert rule", "err", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	rows.Close()
+
+	for _, rule := range rules {
+		window := time.Duration(rule.WindowSeconds) * time.Second
+		// Fire at most once per window so a sustained burst doesn't page continuously
+		if rule.LastFiredAt != nil && now.Sub(*rule.LastFiredAt) < window {
+			continue
+		}
+		start := now.Add(-window)
+		count, err := countAlertMatches(db, rule, start, now)
+		if err != nil {
+			slog.Error("Error evaluating alert rule", "rule_id", rule.ID, "err", err)
+			continue
+		}
+		if count <= rule.Threshold {
+			continue
+		}
+
+		slog.Info("Alert rule fired", "rule_id", rule.ID, "rule", rule.Name, "account", rule.Account, "count", count)
+		fireAlert(db, cfg, AlertEvent{Rule: rule, Count: count, WindowStart: start.UTC(), WindowEnd: now.UTC()})
+		if _, err := db.Exec("UPDATE alert_rules SET last_fired_at = ? WHERE id = ?", now.UTC(), rule.ID); err != nil {
+			slog.Error("Error updating alert rule", "rule_id", rule.ID, "err", err)
+		}
+	}
+	return nil
+}
+
+// fireAlert delivers an event to the rule's webhook, email recipients and
+// chat channels.
+func fireAlert(db *sql.DB, cfg *Config, event AlertEvent) {
+	if event.Rule.WebhookURL != "" {
+		if err := postJSON(event.Rule.WebhookURL, event); err != nil {
+			slog.Error("Error sending alert webhook", "rule_id", event.Rule.ID, "err", err)
+		}
+	}
+	if event.Rule.Email != "" {
+		subject := fmt.Sprintf("[logdata] Alert %s fired for %s", event.Rule.Name, event.Rule.Account)
+		body := fmt.Sprintf("%d entries at level >= %d between %s and %s (threshold %d).",
+			event.Count, event.Rule.MinLevel, event.WindowStart.Format(time.RFC3339), event.WindowEnd.Format(time.RFC3339), event.Rule.Threshold)
+		if err := sendEmail(cfg, strings.Split(event.Rule.Email, ","), subject, body); err != nil {
+			slog.Error("Error sending alert email", "rule_id", event.Rule.ID, "err", err)
+		}
+	}
+	if event.Rule.SlackURL != "" || event.Rule.TeamsURL != "" {
+		if err := postChat(event.Rule.SlackURL, event.Rule.TeamsURL, alertChatMessage(db, cfg, event)); err != nil {
+			slog.Error("Error sending alert chat message", "rule_id", event.Rule.ID, "err", err)
+		}
+	}
+}
+
+// alertChatMessage describes event with the latest entries it counted and a
+// link to all of them.
+func alertChatMessage(db *sql.DB, cfg *Config, event AlertEvent) chatMessage {
+	rule := event.Rule
+	query := rule.query(event.WindowStart, event.WindowEnd)
+	msg := chatMessage{
+		Title: fmt.Sprintf("Alert %s fired for %s", rule.Name, rule.Account),
+		Summary: fmt.Sprintf("%d entries at level >= %d between %s and %s (threshold %d).",
+			event.Count, rule.MinLevel, event.WindowStart.Format(time.RFC3339), event.WindowEnd.Format(time.RFC3339), rule.Threshold),
+		Total: int(event.Count),
+		Link:  queryLink(cfg, query),
+	}
+	params, err := parseQueryParams(query)
+	if err == nil {
+		limit := int64(alertSampleSize)
+		params.Account, params.Limit, params.OmitStackTrace = rule.Account, &limit, true
+		err = queryEntries(context.Background(), db, params, func(logData LogData) error {
+			msg.Entries = append(msg.Entries, logData)
+			return nil
+		})
+	}
+	if err != nil {
+		slog.Error("Error fetching alert entries", "rule_id", rule.ID, "err", err)
+	}
+	return msg
+}
+
+// handleAlertRules serves the alert rule API:
+// GET/POST /alerts, GET/PUT/DELETE /alerts/{id} and POST /alerts/test.
+func handleAlertRules(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/alerts"), "/")
+		if rest == "test" {
+			handleAlertTest(db, cfg, w, r)
+			return
+		}
+		if rest == "" {
+			switch r.Method {
+			case http.MethodGet:
+				listAlertRules(db, w, r)
+			case http.MethodPost:
+				saveAlertRule(db, w, r, 0)
+			default:
+				requestLogger(r).Warn("Method not allowed", "method", r.Method)
+				writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			}
+			return
+		}
+
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid alert rule id")
+			return
+		}
+		account := r.URL.Query().Get("account")
+		if account == "" && r.Method != http.MethodPut {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			rule, err := scanAlertRule(db.QueryRow("SELECT "+alertRuleColumns+" FROM alert_rules WHERE id = ? AND account = ?", id, account).Scan)
+			if err == sql.ErrNoRows {
+				writeError(w, http.StatusNotFound, codeNotFound, "Alert rule not found")
+				return
+			} else if err != nil {
+				requestLogger(r).Error("Error loading alert rule", "rule_id", id, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch alert rule")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rule)
+		case http.MethodPut:
+			saveAlertRule(db, w, r, id)
+		case http.MethodDelete:
+			res, err := db.Exec("DELETE FROM alert_rules WHERE id = ? AND account = ?", id, account)
+			if err != nil {
+				requestLogger(r).Error("Error deleting alert rule", "rule_id", id, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete alert rule")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Alert rule not found")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Alert rule deleted"})
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func listAlertRules(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	account := r.URL.Query().Get("account")
+	if account == "" {
+		requestLogger(r).Warn("Missing account query parameter")
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+		return
+	}
+	rows, err := db.Query("SELECT "+alertRuleColumns+" FROM alert_rules WHERE account = ? ORDER BY id", account)
+	if err != nil {
+		requestLogger(r).Error("Error querying alert rules", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch alert rules")
+		return
+	}
+	defer rows.Close()
+
+	rules := []AlertRule{}
+	for rows.Next() {
+		rule, err := scanAlertRule(rows.Scan)
+		if err != nil {
+			requestLogger(r).Error("Error scanning row", "err", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// saveAlertRule creates a rule (id 0) or replaces an existing one.
+func saveAlertRule(db *sql.DB, w http.ResponseWriter, r *http.Request, id int64) {
+	rule := AlertRule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		requestLogger(r).Warn("Invalid request body", "err", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if err := rule.Validate(); err != nil {
+		requestLogger(r).Warn("Validation failed", "err", err)
+		writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+		return
+	}
+	if !accountAllowed(r, rule.Account) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+		return
+	}
+
+	if id == 0 {
+		res, err := db.Exec(`INSERT INTO alert_rules (account, name, system, module, min_level, threshold, window_seconds, webhook_url, email, slack_url, teams_url, enabled)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			rule.Account, rule.Name, rule.System, rule.Module, rule.MinLevel, rule.Threshold, rule.WindowSeconds,
+			rule.WebhookURL, rule.Email, rule.SlackURL, rule.TeamsURL, rule.Enabled)
+		if err != nil {
+			requestLogger(r).Error("Error saving alert rule", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save alert rule")
+			return
+		}
+		rule.ID, _ = res.LastInsertId()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+		return
+	}
+
+	res, err := db.Exec(`UPDATE alert_rules SET name = ?, system = ?, module = ?, min_level = ?, threshold = ?,
+		window_seconds = ?, webhook_url = ?, email = ?, slack_url = ?, teams_url = ?, enabled = ? WHERE id = ? AND account = ?`,
+		rule.Name, rule.System, rule.Module, rule.MinLevel, rule.Threshold, rule.WindowSeconds,
+		rule.WebhookURL, rule.Email, rule.SlackURL, rule.TeamsURL, rule.Enabled, id, rule.Account)
+	if err != nil {
+		requestLogger(r).Error("Error updating alert rule", "rule_id", id, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save alert rule")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Alert rule not found")
+		return
+	}
+	rule.ID = id
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// POST /alerts/test replays a past time range through a proposed rule, so
+// thresholds can be tuned before a noisy rule is enabled. The rule is
+// evaluated every step, ALERT_INTERVAL by default, as runAlerts would,
+// including firing at most once per window; nothing is stored or notified.
+
+// maxAlertTestEvaluations bounds the evaluations of one test, the range
+// divided by the step.
+const maxAlertTestEvaluations = 100000
+
+// AlertTestResult reports when a rule would have fired between StartTime and
+// EndTime.
+type AlertTestResult struct {
+	Rule        AlertRule `json:"rule"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time"`
+	StepSeconds float64   `json:"step_seconds"`
+	Evaluations int       `json:"evaluations"`
+	// Exceeded counts the evaluations over the threshold, including those
+	// within a window of a firing, which do not fire again.
+	Exceeded int           `json:"exceeded"`
+	Firings  []AlertFiring `json:"firings"`
+	// MaxCount is the highest count of an evaluation, at MaxCountAt, the
+	// threshold below which the rule would not have fired.
+	MaxCount   int64      `json:"max_count"`
+	MaxCountAt *time.Time `json:"max_count_at,omitempty"`
+}
+
+// AlertFiring is an evaluation of an AlertTestResult that would have fired.
+type AlertFiring struct {
+	Count       int64     `json:"count"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+}
+
+// handleAlertTest serves POST /alerts/test?start_time=&end_time=&step=, with
+// the rule as the body. Notification targets are not required.
+func handleAlertTest(db *sql.DB, cfg *Config, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		requestLogger(r).Warn("Method not allowed", "method", r.Method)
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var rule AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		requestLogger(r).Warn("Invalid request body", "err", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if err := rule.validateCondition(); err != nil {
+		writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+		return
+	}
+	if !accountAllowed(r, rule.Account) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("start_time") == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "start_time is required")
+		return
+	}
+	bounds, err := parseQueryParams(url.Values{"start_time": {query.Get("start_time")}, "end_time": {query.Get("end_time")}, "tz": {query.Get("tz")}})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	start, _ := time.Parse(time.RFC3339Nano, bounds.StartTime)
+	end, _ := time.Parse(time.RFC3339Nano, bounds.EndTime)
+	step := cfg.AlertInterval
+	if step <= 0 {
+		step = time.Minute
+	}
+	if text := query.Get("step"); text != "" {
+		if step, err = parseRelativeDuration(text); err != nil || step <= 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid step: must be a positive duration such as 1m")
+			return
+		}
+	}
+	if !start.Before(end) {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "start_time must be before end_time")
+		return
+	}
+	if end.Sub(start)/step >= maxAlertTestEvaluations {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest,
+			fmt.Sprintf("The range holds more than %d steps; narrow it or raise step", maxAlertTestEvaluations))
+		return
+	}
+
+	ctx, cancel := queryContext(r, cfg)
+	defer cancel()
+	result, err := replayAlertRule(ctx, db, rule, start, end, step)
+	if queryAborted(w, r, cfg, err) {
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error replaying alert rule", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to test alert rule")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// replayAlertRule evaluates rule every step from start to end over the
+// stored entries, reading the entries it counts once in timestamp order.
+func replayAlertRule(ctx context.Context, db *sql.DB, rule AlertRule, start, end time.Time, step time.Duration) (AlertTestResult, error) {
+	result := AlertTestResult{Rule: rule, StartTime: start.UTC(), EndTime: end.UTC(), StepSeconds: step.Seconds(), Firings: []AlertFiring{}}
+	window := time.Duration(rule.WindowSeconds) * time.Second
+	params, err := parseQueryParams(rule.query(start.Add(-window), end))
+	if err != nil {
+		return result, err
+	}
+	params.Account = rule.Account
+	where, args := buildLogFilter(params)
+	from, to := params.partitionRange()
+	rows, err := db.QueryContext(ctx, "SELECT timestamp, repeat_count FROM "+logDataSource(from, to)+" WHERE "+where+" ORDER BY timestamp", args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	type counted struct {
+		at    time.Time
+		count int64
+	}
+	var next counted
+	more := func() (bool, error) {
+		if !rows.Next() {
+			return false, rows.Err()
+		}
+		return true, rows.Scan(&next.at, &next.count)
+	}
+	ok, err := more()
+	if err != nil {
+		return result, err
+	}
+	// inWindow holds the entries counted by the evaluation at t
+	var inWindow []counted
+	var count int64
+	var lastFired *time.Time
+	for t := start; !t.After(end); t = t.Add(step) {
+		for ok && !next.at.After(t) {
+			inWindow = append(inWindow, next)
+			count += next.count
+			if ok, err = more(); err != nil {
+				return result, err
+			}
+		}
+		windowStart := t.Add(-window)
+		for len(inWindow) > 0 && inWindow[0].at.Before(windowStart) {
+			count -= inWindow[0].count
+			inWindow = inWindow[1:]
+		}
+
+		result.Evaluations++
+		if count > result.MaxCount {
+			at := t.UTC()
+			result.MaxCount, result.MaxCountAt = count, &at
+		}
+		if count <= rule.Threshold {
+			continue
+		}
+		result.Exceeded++
+		if lastFired != nil && t.Sub(*lastFired) < window {
+			continue
+		}
+		fired := t
+		lastFired = &fired
+		result.Firings = append(result.Firings, AlertFiring{Count: count, WindowStart: windowStart.UTC(), WindowEnd: t.UTC()})
+	}
+	return result, nil
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const accountNetworksSchema = `CREATE TABLE IF NOT EXISTS account_networks (
+    account TEXT PRIMARY KEY,
+    networks TEXT NOT NULL,
+    updated_at DATETIME NOT NULL
+)`
+
+// AccountNetworks restricts an account's tokens to clients in Networks, a
+// list of CIDRs or single addresses.
+type AccountNetworks struct {
+	Account   string    `json:"account"`
+	Networks  []string  `json:"networks"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// allowlists holds the account_networks rows in memory. It is set at startup.
+var allowlists *allowlistSet
+
+type allowlistSet struct {
+	db *sql.DB
+
+	mu        sync.RWMutex
+	byAccount map[string][]netip.Prefix
+}
+
+func newAllowlistSet(db *sql.DB) *allowlistSet {
+	return &allowlistSet{db: db, byAccount: map[string][]netip.Prefix{}}
+}
+
+// reload refreshes the in-memory allowlists from the database.
+func (a *allowlistSet) reload() error {
+	rows, err := a.db.Query("SELECT account, networks FROM account_networks")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byAccount := map[string][]netip.Prefix{}
+	for rows.Next() {
+		var account, networks string
+		if err := rows.Scan(&account, &networks); err != nil {
+			return err
+		}
+		var list []string
+		if err := json.Unmarshal([]byte(networks), &list); err != nil {
+			return fmt.Errorf("invalid networks for account %s: %v", account, err)
+		}
+		if byAccount[account], err = parseNetworks(list); err != nil {
+			return fmt.Errorf("invalid networks for account %s: %v", account, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.byAccount = byAccount
+	a.mu.Unlock()
+	return nil
+}
+
+// allowed reports whether addr may act on account. Accounts without an
+// allowlist accept every address.
+func (a *allowlistSet) allowed(account string, addr netip.Addr) bool {
+	if a == nil {
+		return true
+	}
+	a.mu.RLock()
+	networks, ok := a.byAccount[account]
+	a.mu.RUnlock()
+	if !ok {
+		return true
+	}
+	return addr.IsValid() && containsAddr(networks, addr)
+}
+
+// parseNetworks parses CIDRs, treating single addresses as one-address
+// networks.
+func parseNetworks(list []string) ([]netip.Prefix, error) {
+	var networks []netip.Prefix
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %s", s)
+			}
+			networks = append(networks, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %s", s)
+		}
+		networks = append(networks, prefix.Masked())
+	}
+	return networks, nil
+}
+
+func containsAddr(networks []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddr returns the address of a host:port or bare address.
+func parseAddr(hostport string) netip.Addr {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	addr, _ := netip.ParseAddr(strings.TrimSpace(host))
+	return addr.Unmap()
+}
+
+// clientAddr returns the address a request came from. Behind the proxies in
+// cfg.TrustedProxies it is the last X-Forwarded-For address that is not one
+// of them.
+func clientAddr(r *http.Request, cfg *Config) netip.Addr {
+	addr := parseAddr(r.RemoteAddr)
+	if !addr.IsValid() || !containsAddr(cfg.TrustedProxies, addr) {
+		return addr
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseAddr(hops[i])
+		if !hop.IsValid() {
+			break
+		}
+		addr = hop
+		if !containsAddr(cfg.TrustedProxies, hop) {
+			break
+		}
+	}
+	return addr
+}
+
+// checkNetwork rejects with 403 and audits a request on account from an
+// address outside the account's allowlist. It reports whether the request
+// may proceed.
+func checkNetwork(w http.ResponseWriter, r *http.Request, cfg *Config, account string) bool {
+	addr := clientAddr(r, cfg)
+	if allowlists.allowed(account, addr) {
+		return true
+	}
+	requestLogger(r).Warn("Access from address denied", "account", account, "addr", addr)
+	insertAudit(allowlists.db, requestLogger(r), AuditEntry{
+		Actor: tokenActor(cfg, bearerToken(r)), Action: "network_denied", Account: account,
+		Details: fmt.Sprintf("addr=%s %s %s", addr, r.Method, r.URL.Path), RemoteAddr: r.RemoteAddr,
+	})
+	writeError(w, http.StatusForbidden, codeForbidden, "Access from this address is not allowed for this account")
+	return false
+}
+
+// handleAllowlists serves the admin API for account allowlists:
+// GET /admin/allowlists, and PUT and DELETE /admin/allowlists/{account}.
+func handleAllowlists(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/allowlists"), "/")
+		switch {
+		case account == "" && r.Method == http.MethodGet:
+			rows, err := db.Query("SELECT account, networks, updated_at FROM account_networks ORDER BY account")
+			if err != nil {
+				requestLogger(r).Error("Error querying allowlists", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch allowlists")
+				return
+			}
+			defer rows.Close()
+			lists := []AccountNetworks{}
+			for rows.Next() {
+				var list AccountNetworks
+				var networks string
+				if err := rows.Scan(&list.Account, &networks, &list.UpdatedAt); err != nil {
+					requestLogger(r).Error("Error scanning row", "err", err)
+					continue
+				}
+				json.Unmarshal([]byte(networks), &list.Networks)
+				lists = append(lists, list)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(lists)
+
+		case account != "" && r.Method == http.MethodPut:
+			var body struct {
+				Networks []string `json:"networks"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				requestLogger(r).Warn("Invalid request body", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+				return
+			}
+			networks, err := parseNetworks(body.Networks)
+			if err == nil && len(networks) == 0 {
+				err = fmt.Errorf("at least one network is required; DELETE the allowlist to lift it")
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+				return
+			}
+			list := AccountNetworks{Account: account, UpdatedAt: timeNow().UTC()}
+			for _, network := range networks {
+				list.Networks = append(list.Networks, network.String())
+			}
+			encoded, _ := json.Marshal(list.Networks)
+			if _, err := db.Exec(`INSERT INTO account_networks (account, networks, updated_at) VALUES (?, ?, ?)
+				ON CONFLICT (account) DO UPDATE SET networks = excluded.networks, updated_at = excluded.updated_at`,
+				account, string(encoded), list.UpdatedAt); err != nil {
+				requestLogger(r).Error("Error saving allowlist", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save allowlist")
+				return
+			}
+			if err := allowlists.reload(); err != nil {
+				requestLogger(r).Error("Error reloading allowlists", "err", err)
+			}
+			recordAudit(db, r, "admin", "allowlist_update", account, string(encoded))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+
+		case account != "" && r.Method == http.MethodDelete:
+			res, err := db.Exec("DELETE FROM account_networks WHERE account = ?", account)
+			if err != nil {
+				requestLogger(r).Error("Error deleting allowlist", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete allowlist")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Allowlist not found")
+				return
+			}
+			if err := allowlists.reload(); err != nil {
+				requestLogger(r).Error("Error reloading allowlists", "err", err)
+			}
+			recordAudit(db, r, "admin", "allowlist_delete", account, "")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Allowlist deleted"})
+
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
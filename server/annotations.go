@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Annotation is a note attached to an existing log entry, e.g. "acknowledged by X".
+type Annotation struct {
+	ID        int64     `json:"id"`
+	LogID     int64     `json:"log_id"`
+	Author    string    `json:"author"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const annotationsSchema = `CREATE TABLE IF NOT EXISTS annotations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    log_id INTEGER NOT NULL,
+    account TEXT NOT NULL,
+    author TEXT NOT NULL,
+    note TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_annotations_log_id ON annotations(log_id)`
+
+// handlePatchLogData serves PATCH /logdata/{id}, appending an annotation to the entry.
+func handlePatchLogData(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/logdata/"), "/"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid log entry id")
+			return
+		}
+
+		account := r.Header.Get("X-Account")
+		if account == "" {
+			requestLogger(r).Warn("Missing X-Account header")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "X-Account header required")
+			return
+		}
+
+		var annotation Annotation
+		if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+			requestLogger(r).Warn("Invalid request body", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		if annotation.Author == "" || annotation.Note == "" {
+			writeError(w, http.StatusBadRequest, codeValidationFailed, "Validation failed: author and note are required")
+			return
+		}
+
+		var exists int
+		err = db.QueryRow("SELECT 1 FROM logData WHERE id = ? AND account = ?", id, account).Scan(&exists)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, codeNotFound, "Log entry not found")
+			return
+		} else if err != nil {
+			requestLogger(r).Error("Error loading log entry", "id", id, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save annotation")
+			return
+		}
+
+		annotation.LogID = id
+		annotation.CreatedAt = timeNow().UTC()
+		res, err := db.Exec("INSERT INTO annotations (log_id, account, author, note, created_at) VALUES (?, ?, ?, ?, ?)",
+			id, account, annotation.Author, annotation.Note, annotation.CreatedAt)
+		if err != nil {
+			requestLogger(r).Error("Error saving annotation", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save annotation")
+			return
+		}
+		annotation.ID, _ = res.LastInsertId()
+
+		queryResults.invalidateAccount(account)
+		requestLogger(r).Info("Annotated log entry", "id", id, "account", account)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(annotation)
+	}
+}
+
+// attachAnnotations loads the annotations of every entry in logs.
+func attachAnnotations(ctx context.Context, db *sql.DB, logs []LogData) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	byID := make(map[int64]*LogData, len(logs))
+	placeholders := make([]string, 0, len(logs))
+	args := make([]interface{}, 0, len(logs))
+	for i := range logs {
+		if logs[i].ID == nil {
+			continue
+		}
+		byID[*logs[i].ID] = &logs[i]
+		placeholders = append(placeholders, "?")
+		args = append(args, *logs[i].ID)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT id, log_id, author, note, created_at FROM annotations WHERE log_id IN ("+
+		strings.Join(placeholders, ", ")+") ORDER BY created_at ASC, id ASC", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a Annotation
+		if err := rows.Scan(&a.ID, &a.LogID, &a.Author, &a.Note, &a.CreatedAt); err != nil {
+			return err
+		}
+		if entry, ok := byID[a.LogID]; ok {
+			entry.Annotations = append(entry.Annotations, a)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,354 @@
+package server
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Anomaly is an hour in which an account's module logged far more or far
+// fewer entries than that hour of the day usually sees.
+type Anomaly struct {
+	ID      int64  `json:"id"`
+	Account string `json:"account"`
+	Module  string `json:"module"`
+	// Kind is spike or drop.
+	Kind     string    `json:"kind"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Count    int64     `json:"count"`
+	Expected float64   `json:"expected"`
+	// Score is how many deviations Count lies from Expected, negative for
+	// drops.
+	Score      float64   `json:"score"`
+	DetectedAt time.Time `json:"detected_at"`
+
+	// ongoing is set when the hour before was the same anomaly.
+	ongoing bool
+}
+
+const (
+	anomalySpike = "spike"
+	anomalyDrop  = "drop"
+	// anomalyMinSamples is how many days of an hour are learned before
+	// that hour is checked.
+	anomalyMinSamples = 3
+)
+
+var anomalySchema = []string{
+	// volume_baselines keeps the mean and variance of each account and
+	// module's hourly count, per UTC hour of the day
+	`CREATE TABLE IF NOT EXISTS volume_baselines (
+    account TEXT NOT NULL,
+    module TEXT NOT NULL,
+    hour INTEGER NOT NULL,
+    mean REAL NOT NULL,
+    variance REAL NOT NULL,
+    samples INTEGER NOT NULL,
+    PRIMARY KEY (account, module, hour)
+)`,
+	`CREATE TABLE IF NOT EXISTS anomalies (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account TEXT NOT NULL,
+    module TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    start_time DATETIME NOT NULL,
+    end_time DATETIME NOT NULL,
+    count INTEGER NOT NULL,
+    expected REAL NOT NULL,
+    score REAL NOT NULL,
+    detected_at DATETIME NOT NULL
+)`,
+	"CREATE INDEX IF NOT EXISTS idx_anomalies_account_start ON anomalies(account, start_time)",
+	// anomaly_state keeps the start of the last hour analyzed
+	`CREATE TABLE IF NOT EXISTS anomaly_state (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    last_bucket TEXT NOT NULL
+)`,
+}
+
+const anomalyColumns = "id, account, module, kind, start_time, end_time, count, expected, score, detected_at"
+
+// runAnomalies analyzes the hours completed since the previous run every
+// interval.
+func runAnomalies(stop <-chan struct{}, db *sql.DB, cfg *Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := detectAnomalies(db, cfg, timeNow()); err != nil {
+			slog.Error("Error detecting anomalies", "err", err)
+		}
+		if !tick(stop, ticker) {
+			return
+		}
+	}
+}
+
+// detectAnomalies checks each hour completed since the last one analyzed
+// against its baseline, then learns it. On the first run it goes back
+// ANOMALY_BASELINE_DAYS, learning from the hourly rollups already there.
+func detectAnomalies(db *sql.DB, cfg *Config, now time.Time) error {
+	// Hourly counts come from the rollups, so fold in the latest entries
+	if err := updateRollups(db); err != nil {
+		return err
+	}
+	current := now.UTC().Truncate(time.Hour)
+	next := current.Add(-time.Duration(cfg.AnomalyBaselineDays) * 24 * time.Hour)
+	var last string
+	err := db.QueryRow("SELECT last_bucket FROM anomaly_state WHERE id = 1").Scan(&last)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read anomaly state: %v", err)
+	}
+	if t, err := time.Parse(rollupBucketLayout, last); err == nil {
+		next = t.Add(time.Hour)
+	}
+
+	for ; next.Before(current); next = next.Add(time.Hour) {
+		anomalies, err := analyzeHour(db, cfg, next, now)
+		if err != nil {
+			return err
+		}
+		for _, anomaly := range anomalies {
+			slog.Info("Volume anomaly", "account", anomaly.Account, "module", anomaly.Module, "kind", anomaly.Kind,
+				"start", anomaly.Start, "count", anomaly.Count, "expected", anomaly.Expected)
+			// Hours caught up on after downtime, and anomalies carrying on
+			// from the hour before, are recorded without notifying
+			if cfg.AnomalyWebhookURL != "" && !anomaly.ongoing && now.Sub(anomaly.End) < time.Hour {
+				if err := postJSON(cfg.AnomalyWebhookURL, anomaly); err != nil {
+					slog.Error("Error sending anomaly webhook", "account", anomaly.Account, "err", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// volumeBaseline is what an hour of the day usually counts for a module.
+type volumeBaseline struct {
+	mean, variance float64
+	samples        int
+}
+
+// analyzeHour records the anomalies of the hour from start and folds its
+// counts into the baselines of that hour of the day. Modules absent that
+// hour count zero, which is how silences show.
+func analyzeHour(db *sql.DB, cfg *Config, start, now time.Time) ([]Anomaly, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	type key struct{ account, module string }
+	counts := map[key]int64{}
+	rows, err := tx.Query("SELECT account, module, SUM(count) FROM log_rollups_hourly WHERE bucket = ? GROUP BY account, module",
+		start.Format(rollupBucketLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count entries: %v", err)
+	}
+	for rows.Next() {
+		var k key
+		var count int64
+		if err := rows.Scan(&k.account, &k.module, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		counts[k] = count
+	}
+	rows.Close()
+
+	baselines := map[key]volumeBaseline{}
+	rows, err = tx.Query("SELECT account, module, mean, variance, samples FROM volume_baselines WHERE hour = ?", start.Hour())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baselines: %v", err)
+	}
+	for rows.Next() {
+		var k key
+		var b volumeBaseline
+		if err := rows.Scan(&k.account, &k.module, &b.mean, &b.variance, &b.samples); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		baselines[k] = b
+	}
+	rows.Close()
+
+	keys := make([]key, 0, len(baselines))
+	for k := range baselines {
+		keys = append(keys, k)
+	}
+	for k := range counts {
+		if _, ok := baselines[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b key) int {
+		return cmp.Or(cmp.Compare(a.account, b.account), cmp.Compare(a.module, b.module))
+	})
+
+	var anomalies []Anomaly
+	for _, k := range keys {
+		count, b := counts[k], baselines[k]
+		if b.samples >= anomalyMinSamples {
+			// Low counts vary like a Poisson process, by their square root
+			// at least
+			score := (float64(count) - b.mean) / max(math.Sqrt(b.variance), math.Sqrt(b.mean), 1)
+			kind := ""
+			if score >= cfg.AnomalyThreshold {
+				kind = anomalySpike
+			} else if score <= -cfg.AnomalyThreshold {
+				kind = anomalyDrop
+			}
+			if kind != "" {
+				anomaly := Anomaly{Account: k.account, Module: k.module, Kind: kind, Start: start, End: start.Add(time.Hour),
+					Count: count, Expected: b.mean, Score: score, DetectedAt: now.UTC()}
+				if anomaly.ID, anomaly.ongoing, err = recordAnomaly(tx, anomaly); err != nil {
+					return nil, err
+				}
+				anomalies = append(anomalies, anomaly)
+			}
+		}
+
+		// An exponentially weighted mean and variance, forgetting days older
+		// than the baseline period
+		alpha := 1 / float64(min(b.samples+1, cfg.AnomalyBaselineDays))
+		diff := float64(count) - b.mean
+		b.mean += alpha * diff
+		b.variance = (1 - alpha) * (b.variance + alpha*diff*diff)
+		b.samples++
+		if b.mean < 0.01 {
+			// The module has gone quiet for good
+			_, err = tx.Exec("DELETE FROM volume_baselines WHERE account = ? AND module = ? AND hour = ?", k.account, k.module, start.Hour())
+		} else {
+			_, err = tx.Exec(`INSERT INTO volume_baselines (account, module, hour, mean, variance, samples) VALUES (?, ?, ?, ?, ?, ?)
+				ON CONFLICT (account, module, hour) DO UPDATE SET mean = excluded.mean, variance = excluded.variance, samples = excluded.samples`,
+				k.account, k.module, start.Hour(), b.mean, b.variance, b.samples)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to update baseline: %v", err)
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO anomaly_state (id, last_bucket) VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET last_bucket = excluded.last_bucket`, start.Format(rollupBucketLayout)); err != nil {
+		return nil, fmt.Errorf("failed to save anomaly state: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return anomalies, nil
+}
+
+// recordAnomaly stores anomaly, returning its id and whether the hour
+// before was the same anomaly.
+func recordAnomaly(tx *sql.Tx, anomaly Anomaly) (int64, bool, error) {
+	var ongoing bool
+	err := tx.QueryRow("SELECT 1 FROM anomalies WHERE account = ? AND module = ? AND kind = ? AND end_time = ?",
+		anomaly.Account, anomaly.Module, anomaly.Kind, anomaly.Start).Scan(&ongoing)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, false, fmt.Errorf("failed to look up anomaly: %v", err)
+	}
+	res, err := tx.Exec("INSERT INTO anomalies (account, module, kind, start_time, end_time, count, expected, score, detected_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		anomaly.Account, anomaly.Module, anomaly.Kind, anomaly.Start, anomaly.End, anomaly.Count, anomaly.Expected, anomaly.Score, anomaly.DetectedAt)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to record anomaly: %v", err)
+	}
+	id, err := res.LastInsertId()
+	return id, ongoing, err
+}
+
+// handleGetAnomalies serves GET /anomalies, the anomalies of an account,
+// latest first.
+func handleGetAnomalies(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		sqlQuery := "SELECT " + anomalyColumns + " FROM anomalies WHERE account = ?"
+		args := []interface{}{account}
+		if module := query.Get("module"); module != "" {
+			sqlQuery += " AND module = ?"
+			args = append(args, module)
+		}
+		switch kind := query.Get("kind"); kind {
+		case "":
+		case anomalySpike, anomalyDrop:
+			sqlQuery += " AND kind = ?"
+			args = append(args, kind)
+		default:
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "kind must be spike or drop")
+			return
+		}
+		for _, bound := range []struct{ param, op string }{{"start_time", ">="}, {"end_time", "<="}} {
+			value := query.Get(bound.param)
+			if value == "" {
+				continue
+			}
+			t, err := parseTimeBound(value, time.UTC, timeNow())
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid %s: %v", bound.param, err))
+				return
+			}
+			sqlQuery += " AND start_time " + bound.op + " ?"
+			args = append(args, t.UTC())
+		}
+		limit := 100
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid limit")
+				return
+			}
+			limit = int(min(int64(n), cfg.Live().MaxQueryRows))
+		}
+		sqlQuery += " ORDER BY start_time DESC, id DESC LIMIT ?"
+		args = append(args, limit)
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		rows, err := db.QueryContext(ctx, sqlQuery, args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying anomalies", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch anomalies")
+			return
+		}
+		defer rows.Close()
+
+		anomalies := []Anomaly{}
+		for rows.Next() {
+			var a Anomaly
+			if err := rows.Scan(&a.ID, &a.Account, &a.Module, &a.Kind, &a.Start, &a.End, &a.Count, &a.Expected, &a.Score, &a.DetectedAt); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			anomalies = append(anomalies, a)
+		}
+		if err := rows.Err(); queryAborted(w, r, cfg, err) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(anomalies)
+	}
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// archiveDayLayout names the per-day partitions exported to object storage.
+const archiveDayLayout = "2006-01-02"
+
+const archivesSchema = `CREATE TABLE IF NOT EXISTS archives (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account TEXT NOT NULL,
+    day TEXT NOT NULL,
+    object_key TEXT NOT NULL,
+    rows INTEGER NOT NULL,
+    archived_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_archives_account_day ON archives(account, day)`
+
+// archiver moves day partitions older than cfg.ArchiveAfter into an
+// S3-compatible bucket as gzipped NDJSON and removes them from SQLite.
+type archiver struct {
+	db     *sql.DB
+	cfg    *Config
+	client *minio.Client
+}
+
+func newArchiver(db *sql.DB, cfg *Config) (*archiver, error) {
+	client, err := minio.New(cfg.ArchiveEndpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.ArchiveAccessKey, cfg.ArchiveSecretKey, ""),
+		Secure: cfg.ArchiveUseSSL,
+		Region: cfg.ArchiveRegion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive client: %v", err)
+	}
+	return &archiver{db: db, cfg: cfg, client: client}, nil
+}
+
+// run archives eligible partitions every interval.
+func (a *archiver) run(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := a.archiveEligible(context.Background()); err != nil {
+			slog.Error("Error archiving log data", "err", err)
+		}
+		if !tick(stop, ticker) {
+			return
+		}
+	}
+}
+
+// archiveEligible exports every (account, day) partition that ended before the cutoff.
+func (a *archiver) archiveEligible(ctx context.Context) error {
+	cutoff := timeNow().UTC().Add(-a.cfg.ArchiveAfter).Truncate(24 * time.Hour)
+	rows, err := a.db.QueryContext(ctx, `SELECT DISTINCT account, strftime('%Y-%m-%d', timestamp) FROM logData
+		WHERE timestamp < ? ORDER BY 2`, cutoff)
+	if err != nil {
+		return err
+	}
+	type accountDay struct{ account, day string }
+	var days []accountDay
+	for rows.Next() {
+		var p accountDay
+		if err := rows.Scan(&p.account, &p.day); err != nil {
+			rows.Close()
+			return err
+		}
+		days = append(days, p)
+	}
+	rows.Close()
+
+	for _, p := range days {
+		if err := a.archivePartition(ctx, p.account, p.day); err != nil {
+			return fmt.Errorf("failed to archive %s/%s: %v", p.account, p.day, err)
+		}
+	}
+	return nil
+}
+
+// archivePartition uploads one account's entries for one day, then deletes them.
+// Entries arriving for an already archived day go to an additional object.
+func (a *archiver) archivePartition(ctx context.Context, account, day string) error {
+	start, err := time.Parse(archiveDayLayout, day)
+	if err != nil {
+		return err
+	}
+	end := start.Add(24 * time.Hour)
+
+	var maxID int64
+	if err := a.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM logData WHERE account = ? AND timestamp >= ? AND timestamp < ?",
+		account, start, end).Scan(&maxID); err != nil {
+		return err
+	}
+	where := "account = ? AND timestamp >= ? AND timestamp < ? AND id <= ?"
+	args := []interface{}{account, start, end, maxID}
+
+	key := path.Join(a.cfg.ArchivePrefix, account, fmt.Sprintf("%s-%d.ndjson.gz", day, time.Now().UnixNano()))
+	pr, pw := io.Pipe()
+	count := make(chan int64, 1)
+	go func() {
+		n, err := a.writePartition(ctx, pw, where, args)
+		count <- n
+		pw.CloseWithError(err)
+	}()
+	if _, err := a.client.PutObject(ctx, a.cfg.ArchiveBucket, key, pr, -1, minio.PutObjectOptions{
+		ContentType:     "application/x-ndjson",
+		ContentEncoding: "gzip",
+	}); err != nil {
+		pr.CloseWithError(err)
+		return fmt.Errorf("failed to upload %s: %v", key, err)
+	}
+	archived := <-count
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := deleteEntryRows(tx, "SELECT id FROM logData WHERE "+where, args...); err != nil {
+		return err
+	}
+	deleted, err := deleteLogData(tx, where, args)
+	if err != nil {
+		return err
+	}
+	if err := recomputeUsage(tx, account); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO archives (account, day, object_key, rows, archived_at) VALUES (?, ?, ?, ?, ?)",
+		account, day, key, archived, timeNow().UTC()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	vacuums.deleted(deleted)
+	slog.Info("Archived entries", "count", archived, "account", account, "day", day, "key", key)
+	return nil
+}
+
+// writePartition streams matching rows as gzipped NDJSON into w.
+func (a *archiver) writePartition(ctx context.Context, w io.Writer, where string, args []interface{}) (int64, error) {
+	rows, err := a.db.QueryContext(ctx, "SELECT "+logDataColumns+" FROM logData WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	var n int64
+	for rows.Next() {
+		logData, err := scanLogData(rows)
+		if err != nil {
+			return n, err
+		}
+		if err := enc.Encode(logData); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, gz.Close()
+}
+
+// handleArchiveQuery serves GET /archive/query, reading archived partitions
+// overlapping start_time..end_time and applying the /getdata filters.
+func handleArchiveQuery(db *sql.DB, a *archiver) http.HandlerFunc {
+	cfg := a.cfg
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" || account == allAccounts {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+		params, err := parseQueryParams(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		params.Account = account
+		if !allowedBySettings(w, r, accountSettings.checkQuery(params)) {
+			return
+		}
+		if params.Limit == nil {
+			defaultLimit := int64(100)
+			params.Limit = &defaultLimit
+		}
+		if !limitQueryRows(w, r, cfg, &params) {
+			return
+		}
+
+		start, end := time.Time{}, timeNow().UTC()
+		if params.StartTime != "" {
+			if start, err = time.Parse(time.RFC3339, params.StartTime); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid start_time: must be RFC3339")
+				return
+			}
+		}
+		if params.EndTime != "" {
+			if end, err = time.Parse(time.RFC3339, params.EndTime); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid end_time: must be RFC3339")
+				return
+			}
+		}
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		rows, err := db.QueryContext(ctx, "SELECT object_key FROM archives WHERE account = ? AND day >= ? AND day <= ? ORDER BY day DESC, id DESC",
+			account, start.UTC().Format(archiveDayLayout), end.UTC().Format(archiveDayLayout))
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying archives", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch archives")
+			return
+		}
+		var keys []string
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err == nil {
+				keys = append(keys, key)
+			}
+		}
+		rows.Close()
+
+		var limit, offset int64 = 100, 0
+		if params.Limit != nil {
+			limit = *params.Limit
+		}
+		if params.Offset != nil {
+			offset = *params.Offset
+		}
+
+		logs := []LogData{}
+		var skipped int64
+		for _, key := range keys {
+			err := a.readObject(ctx, key, func(logData LogData) bool {
+				if logData.Timestamp.Before(start) || logData.Timestamp.After(end) || !params.matches(logData) {
+					return true
+				}
+				if skipped < offset {
+					skipped++
+					return true
+				}
+				logs = append(logs, logData)
+				return int64(len(logs)) < limit
+			})
+			if queryAborted(w, r, cfg, err) {
+				return
+			}
+			if err != nil {
+				requestLogger(r).Error("Error reading archive", "key", key, "err", err)
+				writeError(w, http.StatusBadGateway, codeUpstreamFailed, "Failed to read archive")
+				return
+			}
+			if int64(len(logs)) >= limit {
+				break
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logs)
+	}
+}
+
+// readObject decodes an archived NDJSON object, calling fn per entry until it returns false.
+func (a *archiver) readObject(ctx context.Context, key string, fn func(LogData) bool) error {
+	obj, err := a.client.GetObject(ctx, a.cfg.ArchiveBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	gz, err := gzip.NewReader(bufio.NewReader(obj))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(gz)
+	for {
+		var logData LogData
+		if err := dec.Decode(&logData); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !fn(logData) {
+			return nil
+		}
+	}
+}
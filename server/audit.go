@@ -0,0 +1,185 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const auditLogSchema = `CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    occurred_at DATETIME NOT NULL,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    account TEXT NOT NULL,
+    details TEXT,
+    remote_addr TEXT,
+    rows INTEGER
+)`
+
+// AuditEntry is a row of audit_log. Rows is the number of entries a read
+// returned.
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	Account    string    `json:"account"`
+	Details    string    `json:"details,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Rows       *int64    `json:"rows,omitempty"`
+}
+
+// initializeAuditLog creates audit_log, adding the columns introduced after it.
+func initializeAuditLog(db *sql.DB) error {
+	if _, err := db.Exec(auditLogSchema); err != nil {
+		return fmt.Errorf("failed to create audit_log table: %v", err)
+	}
+	if err := ensureColumn(db, "audit_log", "rows", "INTEGER"); err != nil {
+		return err
+	}
+	_, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_audit_log_account ON audit_log(account, occurred_at)")
+	return err
+}
+
+// insertAudit appends entry to audit_log and logs it.
+func insertAudit(db *sql.DB, logger *slog.Logger, entry AuditEntry) {
+	_, err := db.Exec(`INSERT INTO audit_log (occurred_at, actor, action, account, details, remote_addr, rows)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		timeNow().UTC(), entry.Actor, entry.Action, entry.Account, entry.Details, entry.RemoteAddr, entry.Rows)
+	if err != nil {
+		logger.Error("Error writing audit log", "err", err)
+	}
+	args := []any{"actor", entry.Actor, "action", entry.Action, "account", entry.Account, "details", entry.Details, "remote_addr", entry.RemoteAddr}
+	if entry.Rows != nil {
+		args = append(args, "rows", *entry.Rows)
+	}
+	logger.Info("Audit", args...)
+}
+
+// recordAudit appends an entry to audit_log. Failures are logged but never
+// block the audited request.
+func recordAudit(db *sql.DB, r *http.Request, actor, action, account, details string) {
+	insertAudit(db, requestLogger(r), AuditEntry{Actor: actor, Action: action, Account: account, Details: details, RemoteAddr: r.RemoteAddr})
+}
+
+// recordRead audits a read of account returning rows entries, naming the
+// caller by its token and the filter by the query string.
+func recordRead(db *sql.DB, r *http.Request, cfg *Config, action, account string, rows int) {
+	n := int64(rows)
+	insertAudit(db, requestLogger(r), AuditEntry{
+		Actor: tokenActor(cfg, bearerToken(r)), Action: action, Account: account,
+		Details: r.URL.RawQuery, RemoteAddr: r.RemoteAddr, Rows: &n,
+	})
+}
+
+// tokenActor names the holder of a bearer token in audit_log: "admin", the
+// token's name, "token:" and a hash prefix for unnamed tokens, "oidc:" and
+// the subject of a JWT, or "anonymous" without a known token.
+func tokenActor(cfg *Config, token string) string {
+	if isAdminToken(token, cfg) {
+		return "admin"
+	}
+	if token == "" {
+		return "anonymous"
+	}
+	key := tokenKey(token)
+	t, ok := cfg.AccountTokens[key]
+	if !ok && oidc != nil && looksLikeJWT(token) {
+		if p, err := oidc.verify(token); err == nil {
+			return "oidc:" + p.Subject
+		}
+	}
+	if !ok {
+		return "anonymous"
+	}
+	if t.Name != "" {
+		return t.Name
+	}
+	return "token:" + hex.EncodeToString(key[:4])
+}
+
+// handleAuditLog serves GET /admin/audit, audit_log newest first, filtered
+// by account, actor, action and an RFC 3339 start_time/end_time, paged with
+// limit (default 100) and offset.
+func handleAuditLog(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		sqlQuery := "SELECT id, occurred_at, actor, action, account, details, remote_addr, rows FROM audit_log WHERE 1=1"
+		var args []interface{}
+		for _, column := range []string{"account", "actor", "action"} {
+			if v := query.Get(column); v != "" {
+				sqlQuery += " AND " + column + " = ?"
+				args = append(args, v)
+			}
+		}
+		for _, bound := range []struct{ name, op string }{{"start_time", ">="}, {"end_time", "<="}} {
+			if v := query.Get(bound.name); v != "" {
+				t, err := parseTimeBound(v, time.UTC, timeNow())
+				if err != nil {
+					writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid %s: %v", bound.name, err))
+					return
+				}
+				sqlQuery += " AND occurred_at " + bound.op + " ?"
+				args = append(args, t.UTC())
+			}
+		}
+
+		var limit, offset int64 = 100, 0
+		if query.Get("limit") != "" {
+			fmt.Sscanf(query.Get("limit"), "%d", &limit)
+		}
+		if query.Get("offset") != "" {
+			fmt.Sscanf(query.Get("offset"), "%d", &offset)
+		}
+		sqlQuery += fmt.Sprintf(" ORDER BY id DESC LIMIT %d OFFSET %d", limit, offset)
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		rows, err := db.QueryContext(ctx, sqlQuery, args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying audit log", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch audit log")
+			return
+		}
+		defer rows.Close()
+
+		entries := []AuditEntry{}
+		for rows.Next() {
+			var entry AuditEntry
+			var details, remoteAddr sql.NullString
+			var n sql.NullInt64
+			if err := rows.Scan(&entry.ID, &entry.OccurredAt, &entry.Actor, &entry.Action, &entry.Account, &details, &remoteAddr, &n); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			entry.Details, entry.RemoteAddr = details.String, remoteAddr.String
+			if n.Valid {
+				entry.Rows = &n.Int64
+			}
+			entries = append(entries, entry)
+		}
+		if err := rows.Err(); err != nil {
+			if !queryAborted(w, r, cfg, err) {
+				requestLogger(r).Error("Error reading audit log", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch audit log")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Token scopes. ingest may push entries, read may query them and admin may
+// manage the account's alerts, webhooks and annotations.
+const (
+	scopeIngest = "ingest"
+	scopeRead   = "read"
+	scopeAdmin  = "admin"
+)
+
+// AccountToken grants scopes on a single account.
+type AccountToken struct {
+	// Name identifies the token's holder in audit_log.
+	Name    string   `json:"name,omitempty"`
+	Token   string   `json:"token"`
+	Account string   `json:"account"`
+	Scopes  []string `json:"scopes"`
+}
+
+// authError rejects a request; status is the HTTP status to answer with.
+type authError struct {
+	status int
+	msg    string
+}
+
+func (e *authError) Error() string { return e.msg }
+
+type principalKey struct{}
+
+// tokenKey indexes tokens by hash so lookups do not compare secrets byte by byte.
+func tokenKey(token string) [32]byte {
+	return sha256.Sum256([]byte(token))
+}
+
+// parseAccountTokens reads ACCOUNT_TOKENS, a JSON list of AccountToken, and
+// the older ACCOUNT_SECRET_KEYS map of account to secret, whose secrets get
+// the ingest and read scopes.
+func parseAccountTokens(tokens, secretKeys string) (map[[32]byte]AccountToken, error) {
+	out := map[[32]byte]AccountToken{}
+	if secretKeys != "" {
+		var secrets map[string]string
+		if err := json.Unmarshal([]byte(secretKeys), &secrets); err != nil {
+			return nil, fmt.Errorf("invalid ACCOUNT_SECRET_KEYS: %v", err)
+		}
+		for account, secret := range secrets {
+			out[tokenKey(secret)] = AccountToken{Token: secret, Account: account, Scopes: []string{scopeIngest, scopeRead}}
+		}
+	}
+	if tokens != "" {
+		var list []AccountToken
+		if err := json.Unmarshal([]byte(tokens), &list); err != nil {
+			return nil, fmt.Errorf("invalid ACCOUNT_TOKENS: %v", err)
+		}
+		for _, token := range list {
+			if token.Token == "" || token.Account == "" {
+				return nil, fmt.Errorf("invalid ACCOUNT_TOKENS: token and account are required")
+			}
+			for _, scope := range token.Scopes {
+				if scope != scopeIngest && scope != scopeRead && scope != scopeAdmin {
+					return nil, fmt.Errorf("invalid ACCOUNT_TOKENS: unknown scope %q", scope)
+				}
+			}
+			out[tokenKey(token.Token)] = token
+		}
+	}
+	return out, nil
+}
+
+// authorize checks that token may use scope on account, which is the
+// token's own account or one below it. The admin token may do anything;
+// with OIDC configured, JWTs from the issuer are accepted too. An empty
+// account resolves to the token's own account, which is returned.
+func authorize(cfg *Config, token, account, scope string) (string, error) {
+	if !cfg.AuthRequired || isAdminToken(token, cfg) {
+		return account, nil
+	}
+	if token == "" {
+		return "", &authError{http.StatusUnauthorized, "Bearer token required"}
+	}
+	t, ok := cfg.AccountTokens[tokenKey(token)]
+	if !ok && oidc != nil && looksLikeJWT(token) {
+		p, err := oidc.verify(token)
+		if err != nil {
+			return "", &authError{http.StatusUnauthorized, fmt.Sprintf("Invalid token: %v", err)}
+		}
+		t, ok = AccountToken{Account: p.Account, Scopes: p.Scopes}, true
+	}
+	if !ok {
+		return "", &authError{http.StatusUnauthorized, "Invalid token"}
+	}
+	if !slices.Contains(t.Scopes, scope) {
+		return "", &authError{http.StatusForbidden, fmt.Sprintf("Token lacks the %s scope", scope)}
+	}
+	if account != "" && !accountWithin(account, t.Account) {
+		return "", &authError{http.StatusForbidden, "Token not valid for this account"}
+	}
+	return t.Account, nil
+}
+
+// requestAccount returns the account a request names in X-Account, the
+// account or account_prefix query parameters or Loki's X-Scope-OrgID.
+func requestAccount(r *http.Request) string {
+	if account := r.Header.Get("X-Account"); account != "" {
+		return account
+	}
+	if account := r.URL.Query().Get("account"); account != "" {
+		return account
+	}
+	if account := r.URL.Query().Get("account_prefix"); account != "" {
+		return account
+	}
+	return r.Header.Get("X-Scope-OrgID")
+}
+
+// requestedAccounts returns the distinct accounts a request names in any
+// of the places requestAccount looks, each value of repeated ones included.
+func requestedAccounts(r *http.Request) []string {
+	query := r.URL.Query()
+	var accounts []string
+	for _, values := range [][]string{r.Header.Values("X-Account"), query["account"], query["account_prefix"], r.Header.Values("X-Scope-OrgID")} {
+		for _, account := range values {
+			if account != "" && !slices.Contains(accounts, account) {
+				accounts = append(accounts, account)
+			}
+		}
+	}
+	return accounts
+}
+
+// requireScope rejects requests whose bearer token lacks scope on the
+// requested account, or that come from outside the account's allowlist.
+// Handlers read the account from whichever of X-Account, the query and
+// X-Scope-OrgID suits them, so a request naming different accounts in them
+// is rejected rather than authorized for one and served for another.
+// Requests naming no account get the token's account.
+func requireScope(cfg *Config, scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.AuthRequired || isAdmin(r, cfg) {
+			next(w, r)
+			return
+		}
+		named := requestedAccounts(r)
+		if len(named) > 1 {
+			requestLogger(r).Warn("Access denied", "method", r.Method, "path", r.URL.Path, "accounts", named)
+			writeError(w, http.StatusForbidden, codeForbidden, "Request names more than one account")
+			return
+		}
+		requested := ""
+		if len(named) == 1 {
+			requested = named[0]
+		}
+		account, err := authorize(cfg, bearerToken(r), requested, scope)
+		if err != nil {
+			requestLogger(r).Warn("Access denied", "method", r.Method, "path", r.URL.Path, "err", err)
+			status := err.(*authError).status
+			writeError(w, status, statusErrorCode(status), err.Error())
+			return
+		}
+		if !checkNetwork(w, r, cfg, account) {
+			return
+		}
+		if requested == "" {
+			r.Header.Set("X-Account", account)
+			r.Header.Set("X-Scope-OrgID", account)
+			query := r.URL.Query()
+			query.Set("account", account)
+			r.URL.RawQuery = query.Encode()
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, account)))
+	}
+}
+
+// accountAllowed reports whether the request may act on account, for
+// endpoints taking the account from the body: the token's account or one
+// below it. Without a scoped token every account is allowed.
+func accountAllowed(r *http.Request, account string) bool {
+	principal, ok := r.Context().Value(principalKey{}).(string)
+	return !ok || accountWithin(account, principal)
+}
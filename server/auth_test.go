@@ -0,0 +1,58 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"log-server/server/testutil"
+)
+
+func TestRequireScopeRejectsMismatchedAccounts(t *testing.T) {
+	srv := testutil.NewServer(t, map[string]string{
+		"AUTH_REQUIRED":  "true",
+		"ACCOUNT_TOKENS": `[{"token": "token-a", "account": "a", "scopes": ["read"]}]`,
+	})
+	srv.Seed(srv.Entry("a", "entry of a"), srv.Entry("b", "entry of b"))
+
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+		want   int
+	}{
+		{"own account", "/getdata?account=a", map[string]string{"X-Account": "a"}, http.StatusOK},
+		{"own account from the token", "/getdata", nil, http.StatusOK},
+		{"other account", "/getdata?account=b", nil, http.StatusForbidden},
+		{"other account in the query", "/getdata?account=b", map[string]string{"X-Account": "a"}, http.StatusForbidden},
+		{"other account in the header", "/getdata?account=a", map[string]string{"X-Account": "b"}, http.StatusForbidden},
+		{"repeated account", "/getdata?account=a&account=b", nil, http.StatusForbidden},
+		{"other account prefix", "/getdata?account_prefix=b", map[string]string{"X-Account": "a"}, http.StatusForbidden},
+		{"other org id", "/getdata?account=a", map[string]string{"X-Scope-OrgID": "b"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := map[string]string{"Authorization": "Bearer token-a"}
+			for name, value := range tt.header {
+				header[name] = value
+			}
+			if rec := serve(srv, http.MethodGet, tt.path, "", header); rec.Code != tt.want {
+				t.Fatalf("GET %s: got %d %s, want %d", tt.path, rec.Code, rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+// serve sends a request to srv, with the admin token unless header sets
+// Authorization, and returns the response.
+func serve(srv *testutil.Server, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testutil.AdminToken)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	return rec
+}
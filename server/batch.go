@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	"log-server/proto/logdatapb"
+)
+
+// protobufBatchType is the Content-Type of binary batches: LogEntry
+// messages of proto/logdata.proto, each preceded by its size as a varint,
+// usually sent with Content-Encoding: zstd. Decoding skips the JSON
+// overhead and field names that dominate agents' bandwidth otherwise.
+const protobufBatchType = "application/x-protobuf"
+
+// BatchResult is the response of POST /logdata/batch.
+type BatchResult struct {
+	Entries int `json:"entries"`
+	Stored  int `json:"stored"`
+	// SampledOut counts entries dropped by sampling rules or level
+	// thresholds, which are answered as stored by POST /logdata.
+	SampledOut int `json:"sampled_out"`
+	Rejected   int `json:"rejected"`
+	// Errors lists why each rejected entry was, by its index in the batch.
+	Errors []BatchError `json:"errors,omitempty"`
+}
+
+// BatchError is a rejected entry of a batch.
+type BatchError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// readBatch decodes the entries of a /logdata/batch body of mediaType.
+func readBatch(body io.Reader, mediaType string, maxBytes int64) ([]LogData, error) {
+	var entries []LogData
+	switch mediaType {
+	case protobufBatchType:
+		reader := bufio.NewReader(body)
+		for {
+			entry := &logdatapb.LogEntry{}
+			err := protodelim.UnmarshalOptions{MaxSize: maxBytes}.UnmarshalFrom(reader, entry)
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: %w", len(entries), err)
+			}
+			entries = append(entries, logDataFromProto(entry))
+		}
+	case "application/json":
+		err := json.NewDecoder(body).Decode(&entries)
+		return entries, err
+	default:
+		dec := json.NewDecoder(body)
+		for {
+			var logData LogData
+			err := dec.Decode(&logData)
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: %w", len(entries), err)
+			}
+			entries = append(entries, logData)
+		}
+	}
+}
+
+// handleBatchLogData serves POST /logdata/batch, many entries for the
+// X-Account account in one request. The Content-Type selects the format:
+// application/x-ndjson (the default) for one JSON entry per line,
+// application/json for an array, or protobufBatchType. Unlike /import,
+// entries go through the checks and pipeline of POST /logdata, sampling
+// and insert hooks included. Invalid entries are rejected one by one, and
+// the others are stored together or, on an error, not at all.
+func handleBatchLogData(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		account := r.Header.Get("X-Account")
+		if account == "" {
+			requestLogger(r).Warn("Missing X-Account header")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "X-Account header required")
+			return
+		}
+		mediaType := "application/x-ndjson"
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			mediaType, _, _ = mime.ParseMediaType(contentType)
+		}
+		if mediaType != "application/x-ndjson" && mediaType != "application/json" && mediaType != protobufBatchType {
+			writeError(w, http.StatusUnsupportedMediaType, codeInvalidRequest,
+				"Content-Type must be application/x-ndjson, application/json or "+protobufBatchType)
+			return
+		}
+
+		entries, err := readBatch(r.Body, mediaType, cfg.Live().MaxBodyBytes)
+		if isBodyTooLarge(err) {
+			requestLogger(r).Warn("Request body too large", "err", err)
+			writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+				fmt.Sprintf("Request body exceeds %d bytes", cfg.Live().MaxBodyBytes), nil)
+			return
+		}
+		if err != nil {
+			requestLogger(r).Warn("Invalid request body", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		if limit := accountSettings.maxBatchSize(cfg, account); len(entries) > limit {
+			requestLogger(r).Warn("Batch too large", "entries", len(entries))
+			writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+				fmt.Sprintf("Batch exceeds %d entries", limit), nil)
+			return
+		}
+
+		// Entries are all prepared before any is stored, then stored in one
+		// transaction, so a batch failing part way stores nothing and can
+		// be retried whole
+		res := BatchResult{Entries: len(entries)}
+		type rejection struct {
+			payload []byte
+			reason  string
+		}
+		var rejections []rejection
+		reject := func(i int, payload []byte, reason string) {
+			rejections = append(rejections, rejection{payload, reason})
+			res.Rejected++
+			res.Errors = append(res.Errors, BatchError{Index: i, Error: reason})
+		}
+		type preparedEntry struct {
+			logData    LogData
+			redactions int
+		}
+		var prepared []preparedEntry
+		for i, logData := range entries {
+			if logData.Account == "" {
+				logData.Account = account
+			}
+			payload, _ := json.Marshal(logData)
+			if logData.Account != account {
+				reject(i, payload, "Account must match X-Account header")
+				continue
+			}
+			logData.splitStackTrace()
+			logData.defaultTTL(r)
+			if err := logData.Validate(); err != nil {
+				reject(i, payload, fmt.Sprintf("Validation failed: %v", err))
+				continue
+			}
+			if errs := logData.checkLimits(cfg); len(errs) > 0 {
+				reject(i, payload, fmt.Sprintf("Payload limits exceeded: %s %s", errs[0].Field, errs[0].Reason))
+				continue
+			}
+			if err := logData.checkClock(cfg); err != nil {
+				reject(i, payload, fmt.Sprintf("Validation failed: %v", err))
+				continue
+			}
+			logData.ULID = newULID(logData.Timestamp)
+			sources.enrich(&logData, clientAddr(r, cfg))
+			keep, redactions, err := prepareInsert(db, cfg, &logData)
+			if err != nil {
+				requestLogger(r).Error("Error saving log data", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save log data")
+				return
+			}
+			if !keep {
+				res.SampledOut++
+				continue
+			}
+			prepared = append(prepared, preparedEntry{logData, redactions})
+		}
+
+		var inserted []LogData
+		err = func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			for i := range prepared {
+				merged, err := storeLogDataTx(tx, cfg, &prepared[i].logData, prepared[i].redactions)
+				if err != nil {
+					return err
+				}
+				if !merged {
+					inserted = append(inserted, prepared[i].logData)
+				}
+			}
+			return tx.Commit()
+		}()
+		var quotaErr *quotaError
+		if errors.As(err, &quotaErr) {
+			requestLogger(r).Warn("Rejected batch", "account", account, "entries", res.Entries, "err", err)
+			writeError(w, quotaErr.status, codeQuotaExceeded, err.Error())
+			return
+		} else if err != nil {
+			requestLogger(r).Error("Error saving log data batch", "account", account, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save log data")
+			return
+		}
+		res.Stored = len(prepared)
+		for _, rejected := range rejections {
+			rejectLog(db, cfg, account, rejected.payload, rejected.reason)
+		}
+		// Repeats of a merged entry do not trigger alerts and webhooks again
+		for _, logData := range inserted {
+			runInsertHooks(logData)
+		}
+
+		requestLogger(r).Info("Stored log data batch", "account", account, "format", strings.TrimPrefix(mediaType, "application/"),
+			"entries", res.Entries, "stored", res.Stored, "rejected", res.Rejected)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
@@ -0,0 +1,76 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"log-server/server"
+	"log-server/server/testutil"
+)
+
+func TestBatchFailingPartWayStoresNothing(t *testing.T) {
+	// Entries with the message "collide" get the same ULID, so the second
+	// fails to insert after the entries before it
+	srv := testutil.NewServer(t, nil, server.WithEntryInterceptors(func(entry *server.LogData) {
+		if entry.Msg == "collide" {
+			entry.ULID = "01J00000000000000000000000"
+		}
+	}))
+	batch := func(msgs ...string) string {
+		var lines []string
+		for _, msg := range msgs {
+			line, _ := json.Marshal(srv.Entry("acme", msg))
+			lines = append(lines, string(line))
+		}
+		return strings.Join(lines, "\n")
+	}
+	header := map[string]string{"X-Account": "acme", "Content-Type": "application/x-ndjson"}
+
+	rec := serve(srv, http.MethodPost, "/logdata/batch", batch("first", "collide", "second", "collide"), header)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("POST /logdata/batch: %d %s, want 500", rec.Code, rec.Body.String())
+	}
+	if entries := srv.Entries("acme", nil); len(entries) != 0 {
+		t.Fatalf("%d entries stored by the failed batch, want none", len(entries))
+	}
+
+	// Retried without the failing entry, the batch is stored once
+	rec = serve(srv, http.MethodPost, "/logdata/batch", batch("first", "collide", "second"), header)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /logdata/batch: %d %s, want 200", rec.Code, rec.Body.String())
+	}
+	var res server.BatchResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || res.Stored != 3 {
+		t.Fatalf("got %+v (%v), want 3 entries stored", res, err)
+	}
+	if entries := srv.Entries("acme", nil); len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+}
+
+func TestBatchQuotaCountsBatch(t *testing.T) {
+	srv := testutil.NewServer(t, map[string]string{"QUOTA_MAX_ROWS": "3"})
+	batch := func(n int) string {
+		lines := make([]string, n)
+		for i := range lines {
+			line, _ := json.Marshal(srv.Entry("acme", fmt.Sprintf("entry %d", i)))
+			lines[i] = string(line)
+		}
+		return strings.Join(lines, "\n")
+	}
+	header := map[string]string{"X-Account": "acme", "Content-Type": "application/x-ndjson"}
+
+	// The account is under quota before the batch, not part-way through it
+	if rec := serve(srv, http.MethodPost, "/logdata/batch", batch(5), header); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("POST /logdata/batch of 5 entries with a quota of 3: %d %s, want 429", rec.Code, rec.Body.String())
+	}
+	if entries := srv.Entries("acme", nil); len(entries) != 0 {
+		t.Fatalf("%d entries stored by the batch over quota, want none", len(entries))
+	}
+	if rec := serve(srv, http.MethodPost, "/logdata/batch", batch(3), header); rec.Code != http.StatusOK {
+		t.Fatalf("POST /logdata/batch of 3 entries with a quota of 3: %d %s, want 200", rec.Code, rec.Body.String())
+	}
+}
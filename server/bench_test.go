@@ -0,0 +1,89 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"log-server/server"
+	"log-server/server/testutil"
+)
+
+// benchmarkLevels are the levels of benchmark entries, in proportion.
+var benchmarkLevels = []int{server.LevelDebug, server.LevelInfo, server.LevelInfo, server.LevelWarn, server.LevelError}
+
+// benchmarkServer starts a server for b with request logging discarded,
+// which would otherwise dominate the numbers.
+func benchmarkServer(b *testing.B) *testutil.Server {
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	b.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	return testutil.NewServer(b, nil)
+}
+
+// BenchmarkInsertLogData measures POST /logdata, one entry per request,
+// through validation, the ingest pipeline and the insert.
+func BenchmarkInsertLogData(b *testing.B) {
+	srv := benchmarkServer(b)
+	bodies := make([]string, 1000)
+	for i := range bodies {
+		entry := srv.Entry("acme", fmt.Sprintf("request %d handled in %dms", i, i%250))
+		entry.Module = fmt.Sprintf("module-%d", i%8)
+		entry.Level = benchmarkLevels[i%len(benchmarkLevels)]
+		entry.Fields = map[string]interface{}{"latency_ms": i % 250, "route": fmt.Sprintf("/api/%d", i%20)}
+		body, _ := json.Marshal(entry)
+		bodies[i] = string(body)
+	}
+	header := map[string]string{"X-Account": "acme"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if rec := serve(srv, http.MethodPost, "/logdata", bodies[i%len(bodies)], header); rec.Code != http.StatusOK {
+			b.Fatalf("POST /logdata: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// BenchmarkGetLogData measures /getdata queries of a page of entries from
+// an account of 10000, with the filters dashboards use most.
+func BenchmarkGetLogData(b *testing.B) {
+	srv := benchmarkServer(b)
+	entries := make([]server.LogData, 10000)
+	for i := range entries {
+		entries[i] = srv.Entry("acme", fmt.Sprintf("request %d handled in %dms", i, i%250))
+		entries[i].Timestamp = srv.Clock.Now().Add(-time.Duration(i) * time.Second)
+		entries[i].Module = fmt.Sprintf("module-%d", i%8)
+		entries[i].Level = benchmarkLevels[i%len(benchmarkLevels)]
+	}
+	srv.Seed(entries...)
+
+	queries := []struct {
+		name  string
+		query url.Values
+	}{
+		{"latest", url.Values{}},
+		{"level", url.Values{"level": {"error"}}},
+		{"module", url.Values{"module": {"module-3"}}},
+		{"time range", url.Values{"start_time": {"now-15m"}}},
+		{"message", url.Values{"query": {"handled"}}},
+	}
+	for _, q := range queries {
+		q.query.Set("account", "acme")
+		q.query.Set("limit", "100")
+		path := "/getdata?" + q.query.Encode()
+		b.Run(q.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if rec := serve(srv, http.MethodGet, path, "", nil); rec.Code != http.StatusOK {
+					b.Fatalf("GET %s: %d %s", path, rec.Code, rec.Body.String())
+				}
+			}
+		})
+	}
+}
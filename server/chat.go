@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// chatMaxEntries bounds the entries listed in a Slack or Teams message.
+const chatMaxEntries = 10
+
+// chatMessage is a notification posted to Slack or Microsoft Teams incoming
+// webhooks: a title, a summary, the entries that triggered it, of Total, and
+// a link to them in the web UI when UI_URL is set.
+type chatMessage struct {
+	Title   string
+	Summary string
+	Entries []LogData
+	Total   int
+	Link    string
+}
+
+// postChat posts msg to the Slack and Teams webhooks that are set, returning
+// the first error.
+func postChat(slackURL, teamsURL string, msg chatMessage) error {
+	var first error
+	if slackURL != "" {
+		if err := postJSON(slackURL, slackPayload(msg)); err != nil {
+			first = fmt.Errorf("slack: %v", err)
+		}
+	}
+	if teamsURL != "" {
+		if err := postJSON(teamsURL, teamsPayload(msg)); err != nil && first == nil {
+			first = fmt.Errorf("teams: %v", err)
+		}
+	}
+	return first
+}
+
+// slackPayload renders msg as Block Kit blocks, with the title as fallback
+// text for notifications.
+func slackPayload(msg chatMessage) map[string]any {
+	blocks := []map[string]any{
+		{"type": "header", "text": map[string]any{"type": "plain_text", "text": msg.Title}},
+		{"type": "section", "text": map[string]any{"type": "mrkdwn", "text": msg.Summary}},
+	}
+	if len(msg.Entries) > 0 {
+		blocks = append(blocks, map[string]any{"type": "section", "text": map[string]any{"type": "mrkdwn", "text": "```" + chatLines(msg) + "```"}})
+	}
+	if msg.Link != "" {
+		blocks = append(blocks, map[string]any{"type": "actions", "elements": []map[string]any{{
+			"type": "button", "text": map[string]any{"type": "plain_text", "text": "Open in logdata"}, "url": msg.Link,
+		}}})
+	}
+	return map[string]any{"text": msg.Title, "blocks": blocks}
+}
+
+// teamsPayload renders msg as an Adaptive Card, which Teams incoming
+// webhooks and workflows accept.
+func teamsPayload(msg chatMessage) map[string]any {
+	body := []map[string]any{
+		{"type": "TextBlock", "text": msg.Title, "size": "Large", "weight": "Bolder", "wrap": true},
+		{"type": "TextBlock", "text": msg.Summary, "wrap": true},
+	}
+	// Teams renders single newlines as spaces, so every line is a block
+	for _, line := range strings.Split(chatLines(msg), "\n") {
+		if line != "" {
+			body = append(body, map[string]any{"type": "TextBlock", "text": line, "fontType": "Monospace", "spacing": "None", "wrap": true})
+		}
+	}
+	card := map[string]any{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if msg.Link != "" {
+		card["actions"] = []map[string]any{{"type": "Action.OpenUrl", "title": "Open in logdata", "url": msg.Link}}
+	}
+	return map[string]any{
+		"type":        "message",
+		"attachments": []map[string]any{{"contentType": "application/vnd.microsoft.card.adaptive", "content": card}},
+	}
+}
+
+// chatLines lists the entries of msg one per line, noting those left out.
+func chatLines(msg chatMessage) string {
+	var b strings.Builder
+	for i, e := range msg.Entries {
+		if i == chatMaxEntries {
+			break
+		}
+		b.WriteString(entryLine(e) + "\n")
+	}
+	if more := msg.Total - min(len(msg.Entries), chatMaxEntries); more > 0 {
+		fmt.Fprintf(&b, "... and %d more\n", more)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// entryLine renders an entry on one line for notifications.
+func entryLine(e LogData) string {
+	return fmt.Sprintf("%s [%d] %s/%s: %s", e.Timestamp.UTC().Format(time.RFC3339), e.Level, e.System, e.Module, e.Msg)
+}
+
+// validChatURL checks that a Slack or Teams webhook is an http(s) URL.
+func validChatURL(name, u string) error {
+	if u != "" && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return fmt.Errorf("%s must be an http(s) URL", name)
+	}
+	return nil
+}
+
+// queryLink returns the web UI link to the entries the /getdata query
+// selects, or "" without UI_URL.
+func queryLink(cfg *Config, query url.Values) string {
+	if cfg.UIURL == "" {
+		return ""
+	}
+	sep := "?"
+	if strings.Contains(cfg.UIURL, "?") {
+		sep = "&"
+	}
+	return cfg.UIURL + sep + query.Encode()
+}
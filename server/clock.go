@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// clientTimestampField keeps the producer's timestamp of a clamped entry.
+const clientTimestampField = "client_timestamp"
+
+// clock replaces the system clock when set by SetClock.
+var clock atomic.Pointer[func() time.Time]
+
+// SetClock makes the server tell the time with now, which tests use to
+// control timestamps, receipts and retention; nil restores the system
+// clock. Query deadlines and latencies keep the system clock.
+func SetClock(now func() time.Time) {
+	if now == nil {
+		clock.Store(nil)
+		return
+	}
+	clock.Store(&now)
+}
+
+// timeNow returns the server's time.
+func timeNow() time.Time {
+	if now := clock.Load(); now != nil {
+		return (*now)()
+	}
+	return time.Now()
+}
+
+// checkClock records that logData is received now, resolving its ttl, and
+// holds its timestamp to the tolerated skew around that time. A timestamp outside is an error,
+// or with TIMESTAMP_SKEW_ACTION=clamp replaced by the time received, the
+// original going to the client_timestamp field.
+func (l *LogData) checkClock(cfg *Config) error {
+	now := timeNow().UTC()
+	l.ReceivedAt = &now
+	l.resolveExpiry(now)
+	live := cfg.Live()
+	var skew string
+	switch {
+	case live.MaxFutureSkew > 0 && l.Timestamp.Sub(now) > live.MaxFutureSkew:
+		skew = fmt.Sprintf("more than %s in the future", live.MaxFutureSkew)
+	case live.MaxPastSkew > 0 && now.Sub(l.Timestamp) > live.MaxPastSkew:
+		skew = fmt.Sprintf("more than %s in the past", live.MaxPastSkew)
+	default:
+		return nil
+	}
+	if live.SkewAction != "clamp" {
+		return fmt.Errorf("timestamp is %s", skew)
+	}
+	if l.Fields == nil {
+		l.Fields = map[string]any{}
+	}
+	l.Fields[clientTimestampField] = l.Timestamp.Format(time.RFC3339Nano)
+	l.Timestamp = now
+	return nil
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"database/sql"
+	"time"
+)
+
+// collapseKeys maps the collapse= values of /getdata to the expression
+// grouping entries. Entries stored without a fingerprint each form their own
+// group rather than one of all of them.
+var collapseKeys = map[string]string{
+	"fingerprint": "account, COALESCE(fingerprint, 'id:' || id)",
+	"msg":         "account, " + storedText("msg"),
+}
+
+// collapseColumns follow logDataColumns in a collapsed query, scanned by
+// scanCollapsedLogData.
+const collapseColumns = "collapse_count, collapse_first_seen, collapse_last_seen"
+
+// CollapsedGroup is the group an entry of a collapsed query stands for: the
+// entries counted with their repeats, and the first and last timestamps.
+type CollapsedGroup struct {
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// collapsedSource returns the rows of source matching where reduced to the
+// newest entry of each params.Collapse group, with collapseColumns, in
+// place of source in a query without where.
+func collapsedSource(params QueryParams, source, where string) string {
+	key := collapseKeys[params.Collapse]
+	return `(SELECT *,
+			ROW_NUMBER() OVER (PARTITION BY ` + key + ` ORDER BY timestamp DESC, id DESC) AS collapse_rank,
+			SUM(repeat_count) OVER collapse_group AS collapse_count,
+			MIN(timestamp) OVER collapse_group AS collapse_first_seen,
+			MAX(timestamp) OVER collapse_group AS collapse_last_seen
+		FROM ` + source + ` WHERE ` + where + `
+		WINDOW collapse_group AS (PARTITION BY ` + key + `)) AS logData`
+}
+
+// scanCollapsedLogData reads a row selected with logDataColumns and
+// collapseColumns.
+func scanCollapsedLogData(rows *sql.Rows) (LogData, error) {
+	var group CollapsedGroup
+	var first, last string
+	logData, err := scanLogData(rows, &group.Count, &first, &last)
+	group.FirstSeen, group.LastSeen = storedTime(first), storedTime(last)
+	logData.Collapsed = &group
+	return logData, err
+}
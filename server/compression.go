@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed msg and fields values start with the prefix of their algorithm
+// and are stored as BLOBs, so SQL can tell them from text (see
+// storedText). Inside an encrypted value the prefix follows decryption.
+const (
+	zstdPrefix   = "\x00zstd:"
+	snappyPrefix = "\x00snappy:"
+)
+
+// compressBatchSize is the number of rows POST /admin/compress rewrites per
+// transaction.
+const compressBatchSize = 500
+
+// compression is set when STORAGE_COMPRESSION is configured. Compressed
+// values are read back whether or not it is.
+var compression *compressor
+
+type compressor struct {
+	algorithm string
+	minBytes  int
+	zstd      *zstd.Encoder
+}
+
+var zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+	d, _ := zstd.NewReader(nil)
+	return d
+})
+
+func newCompressor(cfg *Config) (*compressor, error) {
+	c := &compressor{algorithm: cfg.StorageCompression, minBytes: cfg.StorageCompressionMinBytes}
+	if c.algorithm == "zstd" {
+		var err error
+		if c.zstd, err = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1)); err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %v", err)
+		}
+	}
+	return c, nil
+}
+
+// compress returns value compressed with its prefix when it is at least
+// minBytes long and shrinks, and value itself otherwise.
+func (c *compressor) compress(value string) (string, bool) {
+	if c == nil || len(value) < c.minBytes || strings.HasPrefix(value, "\x00") {
+		return value, false
+	}
+	var packed []byte
+	if c.algorithm == "zstd" {
+		packed = c.zstd.EncodeAll([]byte(value), []byte(zstdPrefix))
+	} else {
+		packed = snappy.Encode(nil, []byte(value))
+		packed = append([]byte(snappyPrefix), packed...)
+	}
+	if len(packed) >= len(value) {
+		return value, false
+	}
+	return string(packed), true
+}
+
+// decompress returns the text of a value stored by compress. Values without
+// a compression prefix are returned as is.
+func decompress(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, zstdPrefix):
+		plain, err := zstdDecoder().DecodeAll([]byte(value[len(zstdPrefix):]), nil)
+		if err != nil {
+			return value, err
+		}
+		return string(plain), nil
+	case strings.HasPrefix(value, snappyPrefix):
+		plain, err := snappy.Decode(nil, []byte(value[len(snappyPrefix):]))
+		if err != nil {
+			return value, err
+		}
+		return string(plain), nil
+	}
+	return value, nil
+}
+
+// packEntry returns the msg, stack trace and encoded fields of an entry of
+// account as stored: compressed, then encrypted when the account is.
+// Compressed values left unencrypted are BLOBs.
+func packEntry(account, msg, stackTrace string, fields sql.NullString) (any, any, any, error) {
+	msg, msgPacked := compression.compress(msg)
+	stackTrace, stackTracePacked := compression.compress(stackTrace)
+	var fieldsPacked bool
+	if fields.Valid {
+		fields.String, fieldsPacked = compression.compress(fields.String)
+	}
+	if encryption.encrypts(account) {
+		sealedMsg, sealedStackTrace, sealedFields, err := encryption.seal(account, msg, stackTrace, fields)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to encrypt entry: %v", err)
+		}
+		return sealedMsg, sealedStackTrace, sealedFields, nil
+	}
+	var storedMsg, storedStackTrace, storedFields any = msg, stackTrace, fields
+	if msgPacked {
+		storedMsg = []byte(msg)
+	}
+	if stackTracePacked {
+		storedStackTrace = []byte(stackTrace)
+	}
+	if fieldsPacked {
+		storedFields = []byte(fields.String)
+	}
+	return storedMsg, storedStackTrace, storedFields, nil
+}
+
+// unpackValue returns the text of a stored msg, stack trace or fields value of account,
+// decrypting and decompressing it as needed.
+func unpackValue(account, value string) (string, error) {
+	value, err := encryption.open(account, value)
+	if err != nil {
+		return value, err
+	}
+	return decompress(value)
+}
+
+// storedSize is the length of a value returned by packEntry, as SQLite's
+// length() counts it.
+func storedSize(value any) int {
+	switch v := value.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	case sql.NullString:
+		return len(v.String)
+	}
+	return 0
+}
+
+// sqliteUnpack implements unpack(value), the text of a compressed BLOB.
+func sqliteUnpack(value []byte) (string, error) {
+	return decompress(string(value))
+}
+
+// storedText returns an SQL expression for the text of column msg or fields,
+// calling unpack only on compressed rows.
+func storedText(column string) string {
+	return "(CASE WHEN typeof(" + column + ") = 'blob' THEN unpack(" + column + ") ELSE " + column + " END)"
+}
+
+// CompressResult is the response of POST /admin/compress.
+type CompressResult struct {
+	Rows       int64 `json:"rows"`
+	BytesSaved int64 `json:"bytes_saved"`
+}
+
+// handleCompress compresses stored entries written before STORAGE_COMPRESSION
+// was set, or below a since lowered threshold, in batches so ingestion is
+// not blocked meanwhile. Usage is recomputed for the accounts touched.
+func handleCompress(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if compression == nil {
+			writeError(w, http.StatusConflict, codeConflict, "STORAGE_COMPRESSION is not configured")
+			return
+		}
+
+		var result CompressResult
+		accounts := map[string]bool{}
+		for _, table := range logDataTables() {
+			var lastID int64
+			for {
+				n, next, err := compressBatch(r.Context(), db, table, lastID, accounts, &result)
+				if err != nil {
+					requestLogger(r).Error("Error compressing entries", "table", table, "err", err)
+					writeError(w, http.StatusInternalServerError, codeInternal, "Failed to compress entries")
+					return
+				}
+				if n < compressBatchSize {
+					break
+				}
+				lastID = next
+			}
+		}
+		for account := range accounts {
+			if err := recomputeUsage(db, account); err != nil {
+				requestLogger(r).Error("Error recomputing usage", "account", account, "err", err)
+			}
+		}
+		requestLogger(r).Info("Compressed stored entries", "rows", result.Rows, "bytes_saved", result.BytesSaved)
+		recordAudit(db, r, "admin", "compress", "", fmt.Sprintf("rows=%d bytes_saved=%d", result.Rows, result.BytesSaved))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// compressBatch rewrites the uncompressed rows among the next
+// compressBatchSize rows of table after id lastID, returning the number of
+// rows read and the last id.
+func compressBatch(ctx context.Context, db *sql.DB, table string, lastID int64, accounts map[string]bool, result *CompressResult) (int, int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, lastID, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, "SELECT id, account, msg, stack_trace, fields FROM "+table+" WHERE id > ? ORDER BY id LIMIT ?", lastID, compressBatchSize)
+	if err != nil {
+		return 0, lastID, err
+	}
+	type update struct {
+		id                      int64
+		msg, stackTrace, fields any
+		before, after           int
+	}
+	var updates []update
+	n := 0
+	for rows.Next() {
+		var account string
+		var msg string
+		var stackTrace, fields sql.NullString
+		if err := rows.Scan(&lastID, &account, &msg, &stackTrace, &fields); err != nil {
+			rows.Close()
+			return n, lastID, err
+		}
+		n++
+		before := len(msg) + len(stackTrace.String) + len(fields.String)
+		if msg, err = unpackValue(account, msg); err != nil {
+			continue
+		}
+		if stackTrace.String, err = unpackValue(account, stackTrace.String); err != nil {
+			continue
+		}
+		if fields.String, err = unpackValue(account, fields.String); err != nil {
+			continue
+		}
+		storedMsg, storedStackTrace, storedFields, err := packEntry(account, msg, stackTrace.String, fields)
+		if err != nil {
+			rows.Close()
+			return n, lastID, err
+		}
+		if after := storedSize(storedMsg) + storedSize(storedStackTrace) + storedSize(storedFields); after < before {
+			updates = append(updates, update{lastID, storedMsg, storedStackTrace, storedFields, before, after})
+			accounts[account] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return n, lastID, err
+	}
+	for _, u := range updates {
+		if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET msg = ?, stack_trace = ?, fields = ? WHERE id = ?", u.msg, u.stackTrace, u.fields, u.id); err != nil {
+			return n, lastID, err
+		}
+		result.Rows++
+		result.BytesSaved += int64(u.before - u.after)
+	}
+	return n, lastID, tx.Commit()
+}
@@ -0,0 +1,913 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Config holds server settings read from environment variables.
+type Config struct {
+	DatabasePath string
+	Port         string
+
+	// QueryAddr is the address of the HTTP listener, ":"+Port by default.
+	// When IngestAddr is set, ingestion endpoints are served only on it and
+	// the others only on QueryAddr, each listener with its own TLS settings.
+	QueryAddr  string
+	IngestAddr string
+	// DebugAddr moves /debug/pprof and /debug/vars to a plain HTTP listener
+	// of their own.
+	DebugAddr string
+	QueryTLS  ListenerTLS
+	IngestTLS ListenerTLS
+	// HTTP server limits of both listeners, guarding against clients that
+	// hold connections open by sending or reading slowly. Zero disables a
+	// timeout. /export, /import and /admin/snapshot extend their read and
+	// write deadlines to LongRequestTimeout.
+	ReadHeaderTimeout  time.Duration
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	MaxHeaderBytes     int
+	LongRequestTimeout time.Duration
+
+	// SQLite connection settings.
+	SQLiteJournalMode string
+	SQLiteSynchronous string
+	SQLiteBusyTimeout time.Duration
+	// SQLiteReadConns sizes the read pool, of which SQLiteReadIdleConns stay
+	// open when idle; writes always use one connection. Connections are
+	// closed after SQLiteConnMaxLifetime, or SQLiteConnMaxIdleTime unused,
+	// when set.
+	SQLiteReadConns       int
+	SQLiteReadIdleConns   int
+	SQLiteConnMaxLifetime time.Duration
+	SQLiteConnMaxIdleTime time.Duration
+	// VacuumSchedule is the cron expression of the incremental vacuum and
+	// ANALYZE runs, which stop vacuuming after VacuumWindow. Deletions of
+	// VacuumAfterDeletes rows start a run too; 0 disables that.
+	VacuumSchedule     string
+	VacuumWindow       time.Duration
+	VacuumAfterDeletes int
+
+	// AdminToken authorizes /admin endpoints via "Authorization: Bearer <token>".
+	// Admin endpoints are disabled when empty.
+	AdminToken string
+	// DeadLetter stores rejected ingestion payloads in rejected_logs.
+	DeadLetter bool
+	// DeadLetterTTL is how long rejected payloads are kept.
+	DeadLetterTTL time.Duration
+	// GRPCPort serves the gRPC LogService alongside HTTP. Disabled when empty.
+	GRPCPort string
+	// RollupInterval is how often new entries are folded into the rollup
+	// tables. Zero disables the rollup job.
+	RollupInterval time.Duration
+	// AlertInterval is how often alert rules are evaluated. Zero disables alerting.
+	AlertInterval time.Duration
+	// AnomalyInterval is how often completed hours are checked for volume
+	// anomalies. Zero disables anomaly detection.
+	AnomalyInterval time.Duration
+	// AnomalyBaselineDays is how many days the hourly baselines remember.
+	AnomalyBaselineDays int
+	// AnomalyThreshold is how many deviations from its baseline make an
+	// hour's count an anomaly.
+	AnomalyThreshold float64
+	// AnomalyWebhookURL receives each new anomaly as a JSON POST.
+	AnomalyWebhookURL string
+	// SilenceAfter is how long a source may go without logging before
+	// /silence lists it.
+	SilenceAfter time.Duration
+	// SilenceInterval is how often sources are checked for silence when
+	// SilenceWebhookURL is set.
+	SilenceInterval time.Duration
+	// SilenceWebhookURL receives each source going silent as a JSON POST.
+	SilenceWebhookURL string
+	// ReportInterval is how often due scheduled reports are run. Zero
+	// disables reports.
+	ReportInterval time.Duration
+	// WebhookMaxAttempts bounds delivery attempts per webhook message.
+	WebhookMaxAttempts int
+	// UIURL is the web UI page listing entries for a /getdata query string,
+	// linked from Slack and Teams notifications.
+	UIURL string
+	// LokiSystemLabel and LokiModuleLabel name the Loki stream labels mapped
+	// to LogData.System and LogData.Module.
+	LokiSystemLabel string
+	LokiModuleLabel string
+
+	// Archival to S3-compatible storage of day partitions older than
+	// ArchiveAfter. Disabled unless ArchiveEndpoint and ArchiveBucket are set.
+	ArchiveEndpoint  string
+	ArchiveBucket    string
+	ArchiveAccessKey string
+	ArchiveSecretKey string
+	ArchiveRegion    string
+	ArchivePrefix    string
+	ArchiveUseSSL    bool
+	ArchiveAfter     time.Duration
+	ArchiveInterval  time.Duration
+
+	// PartitionBy stores entries in one table per "day" or "week" when set.
+	PartitionBy string
+	// ExpiryInterval is how often entries past their own expires_at are
+	// removed.
+	ExpiryInterval time.Duration
+
+	// Fluentd forward protocol listener, disabled when FluentForwardPort is
+	// empty. FluentTagModules maps tags or tag globs to modules.
+	FluentForwardPort string
+	FluentAccount     string
+	FluentSystem      string
+	FluentTagModules  map[string]string
+
+	// GELF input over UDP, disabled when GELFUDPPort is empty. GELFAccount
+	// is the account of UDP messages, and of HTTP ones naming none.
+	GELFUDPPort string
+	GELFAccount string
+
+	// Broker consumers storing JSON entries from Kafka topics or a NATS
+	// JetStream stream, each disabled when KafkaBrokers or NATSURL is empty.
+	// ConsumerAccount is the account of entries that name none.
+	KafkaBrokers    []string
+	KafkaTopics     []string
+	KafkaGroupID    string
+	NATSURL         string
+	NATSStream      string
+	NATSSubjects    []string
+	NATSConsumer    string
+	ConsumerAccount string
+
+	// Mirrors republishing stored entries at or above MirrorMinLevel, of
+	// MirrorAccounts or all accounts when empty, to a Kafka topic or a NATS
+	// subject. Each is disabled when its brokers or URL are empty.
+	MirrorKafkaBrokers []string
+	MirrorKafkaTopic   string
+	MirrorNATSURL      string
+	MirrorNATSSubject  string
+	MirrorAccounts     []string
+	MirrorMinLevel     int
+
+	// AsyncAckQueueSize bounds the ack=async entries waiting to be stored;
+	// ReceiptTTL is how long their receipts are kept after completion.
+	AsyncAckQueueSize int
+	ReceiptTTL        time.Duration
+	// ShutdownTimeout bounds how long SIGTERM waits for requests in flight
+	// and queued ack=async entries before exiting.
+	ShutdownTimeout time.Duration
+
+	// Writes are refused with 503 while the ack=async queue is at least
+	// BackpressureQueueRatio full, or the disk holding the database has less
+	// than MinFreeDiskBytes free; zero disables either.
+	BackpressureQueueRatio float64
+	MinFreeDiskBytes       int64
+
+	// MaxConcurrentQueries bounds read queries running at once; more get
+	// 429. Zero disables the limit.
+	MaxConcurrentQueries int
+
+	// QueryCacheTTL is how long identical reads are answered from memory;
+	// zero disables the cache. QueryCacheMaxEntries bounds its size.
+	QueryCacheTTL        time.Duration
+	QueryCacheMaxEntries int
+
+	// SlowQueryThreshold is the duration past which read queries are logged
+	// and listed by GET /admin/queries; zero disables the slow query log.
+	SlowQueryThreshold time.Duration
+	// SlowQueryRetention is how long each slow run is kept in the
+	// slow_queries table; zero records none.
+	SlowQueryRetention time.Duration
+
+	// ReplicateFrom is the primary's base URL on a read replica, which then
+	// pulls entries from it and refuses writes. ReplicationToken is the
+	// primary's admin token.
+	ReplicateFrom        string
+	ReplicationToken     string
+	ReplicationInterval  time.Duration
+	ReplicationBatchSize int
+
+	// ForwardTo is the central server's base URL on an edge instance, which
+	// then forwards the entries it stores there. ForwardToken is the central
+	// server's admin token.
+	ForwardTo               string
+	ForwardToken            string
+	ForwardInterval         time.Duration
+	ForwardBatchSize        int
+	ForwardMaxRetryInterval time.Duration
+
+	// ReadReplicas are the databases of read replicas, opened read-only, to
+	// which /getdata, /export and the aggregate endpoints are routed while
+	// they lag the primary by at most ReadReplicaMaxStaleness.
+	ReadReplicas            []string
+	ReadReplicaMaxStaleness time.Duration
+
+	// CORS for browser clients on CORSAllowedOrigins ("*" for any origin).
+	// Disabled when no origin is configured.
+	CORSAllowedOrigins []string
+	CORSAllowedMethods []string
+	CORSAllowedHeaders []string
+	CORSMaxAge         time.Duration
+
+	// LogFormat is "text" or "json".
+	LogFormat string
+
+	// AuthRequired enforces AccountTokens, keyed by tokenKey, on every
+	// account endpoint. The admin token is always accepted.
+	AuthRequired  bool
+	AccountTokens map[[32]byte]AccountToken
+	// AccountSeparator, when set, makes account names paths such as
+	// acme.payments.api, each account covering those below it.
+	AccountSeparator string
+	// TrustedProxies are the proxies whose X-Forwarded-For is believed when
+	// checking account allowlists.
+	TrustedProxies []netip.Prefix
+
+	// EncryptionKey is the 32-byte master key wrapping the data keys of
+	// EncryptedAccounts ("*" for every account), whose msg and fields are
+	// stored encrypted. Encryption is disabled when it is nil.
+	EncryptionKey     []byte
+	EncryptedAccounts []string
+
+	// IntegrityChain chains the hashes of each account's new entries so GET
+	// /verify can prove they were not altered.
+	IntegrityChain bool
+
+	// IngestPlugins are the paths of the Go plugins transforming or dropping
+	// entries before they are stored; see ingestPlugin.
+	IngestPlugins []string
+
+	// RecordSourceIP stores the ingesting client's address in the source_ip
+	// field, and GeoIPDatabase and GeoIPASNDatabase are the MaxMind
+	// databases its geo and AS fields are looked up in; see sourceEnricher.
+	RecordSourceIP   bool
+	GeoIPDatabase    string
+	GeoIPASNDatabase string
+
+	// StorageCompression ("zstd" or "snappy", empty for none) compresses the
+	// msg and encoded fields of new entries once they reach
+	// StorageCompressionMinBytes.
+	StorageCompression         string
+	StorageCompressionMinBytes int
+
+	// OIDC validation of JWT bearer tokens from OIDCIssuer, whose keys come
+	// from OIDCJWKSURL or the issuer's discovery document. The account and
+	// scopes are read from the claims named by OIDCAccountClaim and
+	// OIDCScopesClaim (dotted paths); scopes may carry OIDCScopePrefix.
+	OIDCIssuer       string
+	OIDCJWKSURL      string
+	OIDCAudience     string
+	OIDCAccountClaim string
+	OIDCScopesClaim  string
+	OIDCScopePrefix  string
+
+	// IdempotencyTTL is how long Idempotency-Key responses are kept for replay.
+	IdempotencyTTL time.Duration
+
+	// SMTP settings for email notifications.
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
+
+	live atomic.Pointer[LiveConfig]
+}
+
+// LiveConfig holds the settings a reload may change while the server runs.
+type LiveConfig struct {
+	// Payload limits. Oversized bodies get 413, oversized fields 422.
+	MaxBodyBytes   int64
+	MaxMsgLength   int
+	MaxFieldLength int
+	MaxBatchSize   int
+	// ImportBatchSize is the number of entries POST /import inserts per
+	// transaction.
+	ImportBatchSize int
+
+	// DefaultQuota applies to accounts without an entry in AccountQuotas.
+	DefaultQuota  Quota
+	AccountQuotas map[string]Quota
+
+	// RetentionPeriod removes entries older than it; 0 keeps everything.
+	RetentionPeriod time.Duration
+
+	// SamplingRules drop a share of matching entries at ingest; the first
+	// matching rule applies.
+	SamplingRules []SamplingRule
+	// RedactionRules mask sensitive data in matching entries before they
+	// are stored; every matching rule applies.
+	RedactionRules []RedactionRule
+
+	// DedupWindow collapses identical entries timestamped within it into one
+	// row with a repeat_count. Zero disables deduplication.
+	DedupWindow time.Duration
+
+	// MaxFutureSkew and MaxPastSkew bound how far an entry's timestamp may
+	// be from the time it is received; zero disables each. Entries outside
+	// are rejected, or with SkewAction "clamp" stored at the time received.
+	MaxFutureSkew time.Duration
+	MaxPastSkew   time.Duration
+	SkewAction    string
+
+	// QueryTimeout bounds read queries; exceeding it returns 504. Zero
+	// disables the limit, though queries still stop when the client leaves.
+	QueryTimeout time.Duration
+	// MaxQueryRows caps and defaults the limit of /getdata and archive
+	// queries, and MaxQueryScanRows their offset + limit; larger ones get
+	// 413. Zero disables each.
+	MaxQueryRows     int64
+	MaxQueryScanRows int64
+
+	// LogLevel is a slog level name.
+	LogLevel string
+}
+
+// Live returns the current reloadable settings.
+func (c *Config) Live() *LiveConfig {
+	return c.live.Load()
+}
+
+// LoadConfig reads the configuration from the environment.
+func LoadConfig() (*Config, error) {
+	return loadConfig(os.Getenv("DATABASE_PATH"), false)
+}
+
+// LoadEmbeddedConfig reads the configuration from the environment for a
+// Server embedded in another process, which serves its handler itself: the
+// database is databasePath, and the listener settings (PORT, QUERY_ADDR,
+// INGEST_ADDR and their TLS) are ignored.
+func LoadEmbeddedConfig(databasePath string) (*Config, error) {
+	return loadConfig(databasePath, true)
+}
+
+func loadConfig(databasePath string, embedded bool) (*Config, error) {
+	cfg := &Config{
+		DatabasePath:       databasePath,
+		Port:               os.Getenv("PORT"),
+		QueryAddr:          os.Getenv("QUERY_ADDR"),
+		IngestAddr:         os.Getenv("INGEST_ADDR"),
+		DebugAddr:          os.Getenv("DEBUG_ADDR"),
+		AdminToken:         os.Getenv("ADMIN_TOKEN"),
+		GRPCPort:           os.Getenv("GRPC_PORT"),
+		SMTPAddr:           os.Getenv("SMTP_ADDR"),
+		SMTPFrom:           os.Getenv("SMTP_FROM"),
+		SMTPUsername:       os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:       os.Getenv("SMTP_PASSWORD"),
+		PartitionBy:        os.Getenv("PARTITION_BY"),
+		FluentForwardPort:  os.Getenv("FLUENT_FORWARD_PORT"),
+		FluentAccount:      os.Getenv("FLUENT_ACCOUNT"),
+		FluentSystem:       envString("FLUENT_SYSTEM", "fluent"),
+		GELFUDPPort:        os.Getenv("GELF_UDP_PORT"),
+		GELFAccount:        os.Getenv("GELF_ACCOUNT"),
+		KafkaBrokers:       envList("KAFKA_BROKERS", ""),
+		KafkaTopics:        envList("KAFKA_TOPICS", ""),
+		KafkaGroupID:       envString("KAFKA_GROUP_ID", "logdata"),
+		NATSURL:            os.Getenv("NATS_URL"),
+		NATSStream:         os.Getenv("NATS_STREAM"),
+		NATSSubjects:       envList("NATS_SUBJECTS", ""),
+		NATSConsumer:       envString("NATS_CONSUMER", "logdata"),
+		ConsumerAccount:    os.Getenv("CONSUMER_ACCOUNT"),
+		MirrorKafkaBrokers: envList("MIRROR_KAFKA_BROKERS", ""),
+		MirrorKafkaTopic:   os.Getenv("MIRROR_KAFKA_TOPIC"),
+		MirrorNATSURL:      os.Getenv("MIRROR_NATS_URL"),
+		MirrorNATSSubject:  os.Getenv("MIRROR_NATS_SUBJECT"),
+		MirrorAccounts:     envList("MIRROR_ACCOUNTS", ""),
+		MirrorMinLevel:     parseLevel(os.Getenv("MIRROR_MIN_LEVEL"), 0),
+		ReplicateFrom:      os.Getenv("REPLICATE_FROM"),
+		ForwardTo:          os.Getenv("FORWARD_TO"),
+		ForwardToken:       os.Getenv("FORWARD_TOKEN"),
+		ReadReplicas:       envList("READ_REPLICAS", ""),
+		IngestPlugins:      envList("INGEST_PLUGINS", ""),
+		GeoIPDatabase:      os.Getenv("GEOIP_DATABASE"),
+		GeoIPASNDatabase:   os.Getenv("GEOIP_ASN_DATABASE"),
+		AccountSeparator:   os.Getenv("ACCOUNT_SEPARATOR"),
+		OIDCIssuer:         os.Getenv("OIDC_ISSUER"),
+		OIDCJWKSURL:        os.Getenv("OIDC_JWKS_URL"),
+		OIDCAudience:       os.Getenv("OIDC_AUDIENCE"),
+		OIDCAccountClaim:   envString("OIDC_ACCOUNT_CLAIM", "account"),
+		OIDCScopesClaim:    envString("OIDC_SCOPES_CLAIM", "scope"),
+		OIDCScopePrefix:    os.Getenv("OIDC_SCOPE_PREFIX"),
+		ReplicationToken:   os.Getenv("REPLICATION_TOKEN"),
+		LogFormat:          envString("LOG_FORMAT", "text"),
+		ArchiveEndpoint:    os.Getenv("ARCHIVE_ENDPOINT"),
+		ArchiveBucket:      os.Getenv("ARCHIVE_BUCKET"),
+		ArchiveAccessKey:   os.Getenv("ARCHIVE_ACCESS_KEY"),
+		ArchiveSecretKey:   os.Getenv("ARCHIVE_SECRET_KEY"),
+		ArchiveRegion:      os.Getenv("ARCHIVE_REGION"),
+		ArchivePrefix:      envString("ARCHIVE_PREFIX", "logdata"),
+		SQLiteJournalMode:  envString("SQLITE_JOURNAL_MODE", "WAL"),
+		SQLiteSynchronous:  envString("SQLITE_SYNCHRONOUS", "NORMAL"),
+		LokiSystemLabel:    envString("LOKI_SYSTEM_LABEL", "job"),
+		LokiModuleLabel:    envString("LOKI_MODULE_LABEL", "app"),
+
+		CORSAllowedOrigins: envList("CORS_ALLOWED_ORIGINS", ""),
+		CORSAllowedMethods: envList("CORS_ALLOWED_METHODS", "GET, POST, PUT, PATCH, DELETE"),
+		CORSAllowedHeaders: envList("CORS_ALLOWED_HEADERS", "Authorization, Content-Type, Content-Encoding, X-Account, X-Scope-OrgID, Idempotency-Key, "+requestIDHeader),
+	}
+	live := &LiveConfig{LogLevel: envString("LOG_LEVEL", "info")}
+	cfg.live.Store(live)
+	var err error
+	if embedded {
+		if cfg.DatabasePath == "" {
+			return nil, fmt.Errorf("database path required")
+		}
+		cfg.Port, cfg.QueryAddr, cfg.IngestAddr, cfg.DebugAddr = "", "", "", ""
+	} else {
+		if cfg.DatabasePath == "" || (cfg.Port == "" && cfg.QueryAddr == "") {
+			return nil, fmt.Errorf("missing required environment variables: DATABASE_PATH or PORT")
+		}
+		if cfg.QueryAddr == "" {
+			cfg.QueryAddr = ":" + cfg.Port
+		}
+		if cfg.IngestAddr != "" && cfg.IngestAddr == cfg.QueryAddr {
+			return nil, fmt.Errorf("INGEST_ADDR must differ from the query listener's address")
+		}
+		if cfg.DebugAddr != "" && (cfg.DebugAddr == cfg.QueryAddr || cfg.DebugAddr == cfg.IngestAddr) {
+			return nil, fmt.Errorf("DEBUG_ADDR must differ from the other listeners' addresses")
+		}
+		if cfg.QueryTLS, err = envListenerTLS("QUERY"); err != nil {
+			return nil, err
+		}
+		if cfg.IngestTLS, err = envListenerTLS("INGEST"); err != nil {
+			return nil, err
+		}
+		if cfg.IngestAddr == "" && cfg.IngestTLS.CertFile != "" {
+			return nil, fmt.Errorf("INGEST_TLS_CERT requires INGEST_ADDR")
+		}
+	}
+	if cfg.ReadHeaderTimeout, err = envDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.ReadTimeout, err = envDuration("HTTP_READ_TIMEOUT", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.WriteTimeout, err = envDuration("HTTP_WRITE_TIMEOUT", 2*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.IdleTimeout, err = envDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.MaxHeaderBytes, err = envInt("HTTP_MAX_HEADER_BYTES", 64<<10); err != nil {
+		return nil, err
+	}
+	if cfg.MaxHeaderBytes < 1 {
+		return nil, fmt.Errorf("HTTP_MAX_HEADER_BYTES must be at least 1")
+	}
+	if cfg.LongRequestTimeout, err = envDuration("HTTP_LONG_REQUEST_TIMEOUT", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.SQLiteBusyTimeout, err = envDuration("SQLITE_BUSY_TIMEOUT", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.SQLiteReadConns, err = envInt("SQLITE_READ_CONNS", 4); err != nil {
+		return nil, err
+	}
+	if cfg.SQLiteReadConns < 1 {
+		return nil, fmt.Errorf("SQLITE_READ_CONNS must be at least 1")
+	}
+	if cfg.SQLiteReadIdleConns, err = envInt("SQLITE_READ_IDLE_CONNS", cfg.SQLiteReadConns); err != nil {
+		return nil, err
+	}
+	if cfg.SQLiteReadIdleConns < 0 || cfg.SQLiteReadIdleConns > cfg.SQLiteReadConns {
+		return nil, fmt.Errorf("SQLITE_READ_IDLE_CONNS must be between 0 and SQLITE_READ_CONNS")
+	}
+	if cfg.SQLiteConnMaxLifetime, err = envDuration("SQLITE_CONN_MAX_LIFETIME", 0); err != nil {
+		return nil, err
+	}
+	if cfg.SQLiteConnMaxIdleTime, err = envDuration("SQLITE_CONN_MAX_IDLE_TIME", 0); err != nil {
+		return nil, err
+	}
+	if cfg.VacuumSchedule = os.Getenv("VACUUM_SCHEDULE"); cfg.VacuumSchedule != "" {
+		if _, err := parseCron(cfg.VacuumSchedule); err != nil {
+			return nil, fmt.Errorf("invalid VACUUM_SCHEDULE: %v", err)
+		}
+	}
+	if cfg.VacuumWindow, err = envDuration("VACUUM_WINDOW", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.VacuumWindow <= 0 {
+		return nil, fmt.Errorf("VACUUM_WINDOW must be positive")
+	}
+	if cfg.VacuumAfterDeletes, err = envInt("VACUUM_AFTER_DELETES", 0); err != nil {
+		return nil, err
+	}
+	if cfg.DeadLetter, err = envBool("DEAD_LETTER_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if cfg.DeadLetterTTL, err = envDuration("DEAD_LETTER_TTL", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.DeadLetterTTL <= 0 {
+		return nil, fmt.Errorf("DEAD_LETTER_TTL must be positive")
+	}
+	if cfg.RecordSourceIP, err = envBool("RECORD_SOURCE_IP", false); err != nil {
+		return nil, err
+	}
+	if cfg.IntegrityChain, err = envBool("INTEGRITY_CHAIN", false); err != nil {
+		return nil, err
+	}
+	if cfg.RollupInterval, err = envDuration("ROLLUP_INTERVAL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.AlertInterval, err = envDuration("ALERT_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.AnomalyInterval, err = envDuration("ANOMALY_INTERVAL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.AnomalyBaselineDays, err = envInt("ANOMALY_BASELINE_DAYS", 14); err != nil {
+		return nil, err
+	}
+	if cfg.AnomalyBaselineDays < 1 {
+		return nil, fmt.Errorf("ANOMALY_BASELINE_DAYS must be at least 1")
+	}
+	if cfg.AnomalyThreshold, err = envFloat("ANOMALY_THRESHOLD", 4); err != nil {
+		return nil, err
+	}
+	if cfg.AnomalyThreshold <= 0 {
+		return nil, fmt.Errorf("ANOMALY_THRESHOLD must be positive")
+	}
+	cfg.AnomalyWebhookURL = envString("ANOMALY_WEBHOOK_URL", "")
+	if cfg.SilenceAfter, err = envDuration("SILENCE_AFTER", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.SilenceAfter <= 0 {
+		return nil, fmt.Errorf("SILENCE_AFTER must be positive")
+	}
+	if cfg.SilenceInterval, err = envDuration("SILENCE_INTERVAL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	cfg.SilenceWebhookURL = envString("SILENCE_WEBHOOK_URL", "")
+	cfg.UIURL = envString("UI_URL", "")
+	if cfg.ReportInterval, err = envDuration("REPORT_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.WebhookMaxAttempts, err = envInt("WEBHOOK_MAX_ATTEMPTS", 5); err != nil {
+		return nil, err
+	}
+	maxBody, err := envInt("MAX_BODY_BYTES", 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	live.MaxBodyBytes = int64(maxBody)
+	if live.MaxMsgLength, err = envInt("MAX_MSG_LENGTH", 64<<10); err != nil {
+		return nil, err
+	}
+	if live.MaxFieldLength, err = envInt("MAX_FIELD_LENGTH", 256); err != nil {
+		return nil, err
+	}
+	if live.MaxBatchSize, err = envInt("MAX_BATCH_SIZE", 1000); err != nil {
+		return nil, err
+	}
+	if live.ImportBatchSize, err = envInt("IMPORT_BATCH_SIZE", 1000); err != nil {
+		return nil, err
+	}
+	if live.ImportBatchSize <= 0 {
+		return nil, fmt.Errorf("IMPORT_BATCH_SIZE must be positive")
+	}
+	if cfg.EncryptionKey, err = loadEncryptionKey(); err != nil {
+		return nil, err
+	}
+	cfg.EncryptedAccounts = envList("ENCRYPTED_ACCOUNTS", "")
+	if len(cfg.EncryptedAccounts) > 0 && cfg.EncryptionKey == nil {
+		return nil, fmt.Errorf("ENCRYPTION_KEY or ENCRYPTION_KEY_FILE is required with ENCRYPTED_ACCOUNTS")
+	}
+	cfg.StorageCompression = envString("STORAGE_COMPRESSION", "")
+	if cfg.StorageCompression != "" && cfg.StorageCompression != "zstd" && cfg.StorageCompression != "snappy" {
+		return nil, fmt.Errorf("STORAGE_COMPRESSION must be zstd or snappy")
+	}
+	if cfg.StorageCompressionMinBytes, err = envInt("STORAGE_COMPRESSION_MIN_BYTES", 1024); err != nil {
+		return nil, err
+	}
+	if cfg.StorageCompressionMinBytes < 0 {
+		return nil, fmt.Errorf("STORAGE_COMPRESSION_MIN_BYTES must not be negative")
+	}
+	if cfg.IdempotencyTTL, err = envDuration("IDEMPOTENCY_TTL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		return nil, fmt.Errorf("IDEMPOTENCY_TTL must be positive")
+	}
+	if cfg.AuthRequired, err = envBool("AUTH_REQUIRED", false); err != nil {
+		return nil, err
+	}
+	if cfg.TrustedProxies, err = parseNetworks(envList("TRUSTED_PROXIES", "")); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
+	}
+	if s := cfg.AccountSeparator; s != "" && (len(s) != 1 || !strings.Contains(".:-_|~", s)) {
+		return nil, fmt.Errorf("ACCOUNT_SEPARATOR must be one of . : - _ | ~")
+	}
+	if cfg.OIDCIssuer != "" && !cfg.AuthRequired {
+		return nil, fmt.Errorf("OIDC_ISSUER requires AUTH_REQUIRED=true")
+	}
+	if cfg.AccountTokens, err = parseAccountTokens(os.Getenv("ACCOUNT_TOKENS"), os.Getenv("ACCOUNT_SECRET_KEYS")); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("FLUENT_TAG_MODULES"); v != "" {
+		if err := json.Unmarshal([]byte(v), &cfg.FluentTagModules); err != nil {
+			return nil, fmt.Errorf("invalid FLUENT_TAG_MODULES: %v", err)
+		}
+	}
+	if cfg.AuthRequired && cfg.FluentForwardPort != "" && cfg.FluentAccount == "" {
+		return nil, fmt.Errorf("FLUENT_ACCOUNT is required when AUTH_REQUIRED is set")
+	}
+	if cfg.AuthRequired && cfg.GELFUDPPort != "" && cfg.GELFAccount == "" {
+		return nil, fmt.Errorf("GELF_ACCOUNT is required when AUTH_REQUIRED is set")
+	}
+	if v := os.Getenv("SAMPLING_RULES"); v != "" {
+		if err := json.Unmarshal([]byte(v), &live.SamplingRules); err != nil {
+			return nil, fmt.Errorf("invalid SAMPLING_RULES: %v", err)
+		}
+		if err := validateSamplingRules(live.SamplingRules); err != nil {
+			return nil, fmt.Errorf("invalid SAMPLING_RULES: %v", err)
+		}
+	}
+	if v := os.Getenv("REDACTION_RULES"); v != "" {
+		if err := json.Unmarshal([]byte(v), &live.RedactionRules); err != nil {
+			return nil, fmt.Errorf("invalid REDACTION_RULES: %v", err)
+		}
+		if err := compileRedactionRules(live.RedactionRules); err != nil {
+			return nil, fmt.Errorf("invalid REDACTION_RULES: %v", err)
+		}
+	}
+	if live.DedupWindow, err = envDuration("DEDUP_WINDOW", 0); err != nil {
+		return nil, err
+	}
+	if live.MaxFutureSkew, err = envDuration("TIMESTAMP_MAX_FUTURE", 0); err != nil {
+		return nil, err
+	}
+	if live.MaxPastSkew, err = envDuration("TIMESTAMP_MAX_PAST", 0); err != nil {
+		return nil, err
+	}
+	if live.MaxFutureSkew < 0 || live.MaxPastSkew < 0 {
+		return nil, fmt.Errorf("TIMESTAMP_MAX_FUTURE and TIMESTAMP_MAX_PAST must not be negative")
+	}
+	live.SkewAction = envString("TIMESTAMP_SKEW_ACTION", "reject")
+	if live.SkewAction != "reject" && live.SkewAction != "clamp" {
+		return nil, fmt.Errorf("TIMESTAMP_SKEW_ACTION must be reject or clamp")
+	}
+	if live.QueryTimeout, err = envDuration("QUERY_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.WriteTimeout > 0 && live.QueryTimeout >= cfg.WriteTimeout {
+		// The 504 for a slow query would otherwise be cut off with the connection
+		return nil, fmt.Errorf("QUERY_TIMEOUT must be shorter than HTTP_WRITE_TIMEOUT")
+	}
+	if cfg.BackpressureQueueRatio, err = envFloat("BACKPRESSURE_QUEUE_RATIO", 0.9); err != nil {
+		return nil, err
+	}
+	if cfg.BackpressureQueueRatio < 0 || cfg.BackpressureQueueRatio > 1 {
+		return nil, fmt.Errorf("BACKPRESSURE_QUEUE_RATIO must be between 0 and 1")
+	}
+	minFreeDisk, err := envInt("MIN_FREE_DISK_BYTES", 100<<20)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MinFreeDiskBytes = int64(minFreeDisk)
+	if cfg.AsyncAckQueueSize, err = envInt("ASYNC_ACK_QUEUE_SIZE", 10000); err != nil {
+		return nil, err
+	}
+	if cfg.AsyncAckQueueSize < 1 {
+		return nil, fmt.Errorf("ASYNC_ACK_QUEUE_SIZE must be at least 1")
+	}
+	if cfg.ReceiptTTL, err = envDuration("RECEIPT_TTL", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.MaxConcurrentQueries, err = envInt("MAX_CONCURRENT_QUERIES", 16); err != nil {
+		return nil, err
+	}
+	if cfg.QueryCacheTTL, err = envDuration("QUERY_CACHE_TTL", 0); err != nil {
+		return nil, err
+	}
+	if cfg.QueryCacheMaxEntries, err = envInt("QUERY_CACHE_MAX_ENTRIES", 1000); err != nil {
+		return nil, err
+	}
+	if cfg.SlowQueryThreshold, err = envDuration("SLOW_QUERY_THRESHOLD", time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.SlowQueryRetention, err = envDuration("SLOW_QUERY_RETENTION", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.QueryCacheTTL > 0 && cfg.QueryCacheMaxEntries < 1 {
+		return nil, fmt.Errorf("QUERY_CACHE_MAX_ENTRIES must be at least 1")
+	}
+	maxQueryRows, err := envInt("MAX_QUERY_ROWS", 10000)
+	if err != nil {
+		return nil, err
+	}
+	maxQueryScanRows, err := envInt("MAX_QUERY_SCAN_ROWS", 100000)
+	if err != nil {
+		return nil, err
+	}
+	live.MaxQueryRows, live.MaxQueryScanRows = int64(maxQueryRows), int64(maxQueryScanRows)
+	if cfg.ReplicationInterval, err = envDuration("REPLICATION_INTERVAL", time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.ReplicationBatchSize, err = envInt("REPLICATION_BATCH_SIZE", 1000); err != nil {
+		return nil, err
+	}
+	if cfg.ReplicationBatchSize < 1 || cfg.ReplicationBatchSize > maxReplicationBatch {
+		return nil, fmt.Errorf("REPLICATION_BATCH_SIZE must be between 1 and %d", maxReplicationBatch)
+	}
+	if cfg.ForwardInterval, err = envDuration("FORWARD_INTERVAL", time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.ForwardMaxRetryInterval, err = envDuration("FORWARD_MAX_RETRY_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.ForwardBatchSize, err = envInt("FORWARD_BATCH_SIZE", 1000); err != nil {
+		return nil, err
+	}
+	if cfg.ForwardBatchSize < 1 || cfg.ForwardBatchSize > maxReplicationBatch {
+		return nil, fmt.Errorf("FORWARD_BATCH_SIZE must be between 1 and %d", maxReplicationBatch)
+	}
+	if cfg.ForwardTo != "" && cfg.ReplicateFrom != "" {
+		return nil, fmt.Errorf("FORWARD_TO cannot be used with REPLICATE_FROM")
+	}
+	if cfg.ReadReplicaMaxStaleness, err = envDuration("READ_REPLICA_MAX_STALENESS", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if len(cfg.ReadReplicas) > 0 && cfg.ReplicateFrom != "" {
+		return nil, fmt.Errorf("READ_REPLICAS cannot be used with REPLICATE_FROM")
+	}
+	if cfg.ReplicateFrom != "" && cfg.FluentForwardPort != "" {
+		return nil, fmt.Errorf("FLUENT_FORWARD_PORT cannot be used with REPLICATE_FROM")
+	}
+	if cfg.ReplicateFrom != "" && cfg.GELFUDPPort != "" {
+		return nil, fmt.Errorf("GELF_UDP_PORT cannot be used with REPLICATE_FROM")
+	}
+	if len(cfg.KafkaBrokers) > 0 && len(cfg.KafkaTopics) == 0 {
+		return nil, fmt.Errorf("KAFKA_TOPICS is required with KAFKA_BROKERS")
+	}
+	if cfg.NATSURL != "" && cfg.NATSStream == "" {
+		return nil, fmt.Errorf("NATS_STREAM is required with NATS_URL")
+	}
+	if cfg.ReplicateFrom != "" && (len(cfg.KafkaBrokers) > 0 || cfg.NATSURL != "") {
+		return nil, fmt.Errorf("KAFKA_BROKERS and NATS_URL cannot be used with REPLICATE_FROM")
+	}
+	if len(cfg.MirrorKafkaBrokers) > 0 && cfg.MirrorKafkaTopic == "" {
+		return nil, fmt.Errorf("MIRROR_KAFKA_TOPIC is required with MIRROR_KAFKA_BROKERS")
+	}
+	if cfg.MirrorNATSURL != "" && cfg.MirrorNATSSubject == "" {
+		return nil, fmt.Errorf("MIRROR_NATS_SUBJECT is required with MIRROR_NATS_URL")
+	}
+	if cfg.CORSMaxAge, err = envDuration("CORS_MAX_AGE", 10*time.Minute); err != nil {
+		return nil, err
+	}
+	if live.RetentionPeriod, err = envDuration("RETENTION_PERIOD", 0); err != nil {
+		return nil, err
+	}
+	if cfg.ArchiveUseSSL, err = envBool("ARCHIVE_USE_SSL", true); err != nil {
+		return nil, err
+	}
+	if cfg.ArchiveAfter, err = envDuration("ARCHIVE_AFTER", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.ArchiveInterval, err = envDuration("ARCHIVE_INTERVAL", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.ExpiryInterval, err = envDuration("EXPIRY_INTERVAL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.ExpiryInterval <= 0 {
+		return nil, fmt.Errorf("EXPIRY_INTERVAL must be positive")
+	}
+	maxRows, err := envInt("QUOTA_MAX_ROWS", 0)
+	if err != nil {
+		return nil, err
+	}
+	maxBytes, err := envInt("QUOTA_MAX_BYTES", 0)
+	if err != nil {
+		return nil, err
+	}
+	live.DefaultQuota = Quota{MaxRows: int64(maxRows), MaxBytes: int64(maxBytes)}
+	if v := os.Getenv("ACCOUNT_QUOTAS"); v != "" {
+		if err := json.Unmarshal([]byte(v), &live.AccountQuotas); err != nil {
+			return nil, fmt.Errorf("invalid ACCOUNT_QUOTAS: %v", err)
+		}
+	}
+	return cfg, nil
+}
+
+// loadEncryptionKey reads the base64 master key from ENCRYPTION_KEY or, for
+// keys provisioned by a secrets manager or KMS agent, from the file named by
+// ENCRYPTION_KEY_FILE. It returns nil when neither is set.
+func loadEncryptionKey() ([]byte, error) {
+	encoded := os.Getenv("ENCRYPTION_KEY")
+	if path := os.Getenv("ENCRYPTION_KEY_FILE"); path != "" {
+		if encoded != "" {
+			return nil, fmt.Errorf("ENCRYPTION_KEY and ENCRYPTION_KEY_FILE are mutually exclusive")
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ENCRYPTION_KEY_FILE: %v", err)
+		}
+		encoded = string(b)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, base64 encoded")
+	}
+	return key, nil
+}
+
+// envListenerTLS reads the <prefix>_TLS_CERT, <prefix>_TLS_KEY and
+// <prefix>_TLS_CLIENT_CA files of a listener.
+func envListenerTLS(prefix string) (ListenerTLS, error) {
+	t := ListenerTLS{
+		CertFile:     os.Getenv(prefix + "_TLS_CERT"),
+		KeyFile:      os.Getenv(prefix + "_TLS_KEY"),
+		ClientCAFile: os.Getenv(prefix + "_TLS_CLIENT_CA"),
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return t, fmt.Errorf("%s_TLS_CERT and %s_TLS_KEY must be set together", prefix, prefix)
+	}
+	if t.ClientCAFile != "" && t.CertFile == "" {
+		return t, fmt.Errorf("%s_TLS_CLIENT_CA requires %s_TLS_CERT", prefix, prefix)
+	}
+	return t, nil
+}
+
+// envString returns an environment variable, or def when unset.
+func envString(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+// envList splits a comma-separated environment variable, or def when unset,
+// dropping empty items.
+func envList(name, def string) []string {
+	var items []string
+	for _, item := range strings.Split(envString(name, def), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envBool parses a boolean environment variable, returning def when unset.
+func envBool(name string, def bool) (bool, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return b, nil
+}
+
+// envInt parses an integer environment variable, returning def when unset.
+func envInt(name string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return n, nil
+}
+
+// envFloat parses a number environment variable, returning def when unset.
+func envFloat(name string, def float64) (float64, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return f, nil
+}
+
+// envDuration parses a duration environment variable (e.g. "5m"), returning def when unset.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/segmentio/kafka-go"
+)
+
+// consumerRetryDelay is how long the consumers wait before retrying an entry
+// that could not be stored, or a broker connection that failed.
+const consumerRetryDelay = 5 * time.Second
+
+// consumeEntry stores one JSON entry read from a broker. key identifies the
+// message, so a redelivery after a crash stores nothing. Entries that can
+// never be stored go to the dead letter table; the error is non-nil only
+// when the message should be retried.
+func consumeEntry(db *sql.DB, cfg *Config, key string, payload []byte) error {
+	if refusal := admission.refusal(); refusal != nil {
+		return refusal
+	}
+	var logData LogData
+	if err := json.Unmarshal(payload, &logData); err != nil {
+		rejectLog(db, cfg, cfg.ConsumerAccount, payload, fmt.Sprintf("Invalid entry: %v", err))
+		return nil
+	}
+	if logData.Account == "" {
+		logData.Account = cfg.ConsumerAccount
+	}
+	logData.splitStackTrace()
+	if err := logData.Validate(); err != nil {
+		rejectLog(db, cfg, logData.Account, payload, fmt.Sprintf("Validation failed: %v", err))
+		return nil
+	}
+	if errs := logData.checkLimits(cfg); len(errs) > 0 {
+		rejectLog(db, cfg, logData.Account, payload, "Payload limits exceeded")
+		return nil
+	}
+	if err := logData.checkClock(cfg); err != nil {
+		rejectLog(db, cfg, logData.Account, payload, fmt.Sprintf("Validation failed: %v", err))
+		return nil
+	}
+	logData.ULID = newULID(logData.Timestamp)
+	_, err := insertLogDataIdempotent(db, cfg, logData.Account, key, &logData, http.StatusOK)
+	var quotaErr *quotaError
+	if errors.As(err, &quotaErr) {
+		// Retrying would stall the partition until the quota resets
+		rejectLog(db, cfg, logData.Account, payload, err.Error())
+		return nil
+	}
+	return err
+}
+
+// runKafkaConsumer reads entries from cfg.KafkaTopics as member of the
+// cfg.KafkaGroupID consumer group. Offsets are committed only after their
+// entries are stored, so delivery is at least once.
+func runKafkaConsumer(stop <-chan struct{}, db *sql.DB, cfg *Config) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:        cfg.KafkaBrokers,
+		GroupID:        cfg.KafkaGroupID,
+		GroupTopics:    cfg.KafkaTopics,
+		CommitInterval: time.Second,
+	})
+	defer reader.Close()
+	slog.Info("Consuming from Kafka", "brokers", cfg.KafkaBrokers, "topics", cfg.KafkaTopics, "group", cfg.KafkaGroupID)
+	ctx, cancel := stopContext(stop)
+	defer cancel()
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("Error reading from Kafka", "err", err)
+			if !sleep(stop, consumerRetryDelay) {
+				return
+			}
+			continue
+		}
+		key := fmt.Sprintf("kafka:%s:%d:%d", msg.Topic, msg.Partition, msg.Offset)
+		for {
+			err := consumeEntry(db, cfg, key, msg.Value)
+			if err == nil {
+				break
+			}
+			slog.Error("Error storing Kafka entry", "topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset, "err", err)
+			if !sleep(stop, consumerRetryDelay) {
+				return
+			}
+		}
+		if err := reader.CommitMessages(ctx, msg); err != nil {
+			slog.Error("Error committing Kafka offset", "topic", msg.Topic, "partition", msg.Partition, "err", err)
+		}
+	}
+}
+
+// runNATSConsumer reads entries from cfg.NATSStream through the durable
+// consumer cfg.NATSConsumer, created if missing. Messages are acked only
+// after their entries are stored, so delivery is at least once.
+func runNATSConsumer(stop <-chan struct{}, db *sql.DB, cfg *Config) {
+	ctx, cancel := stopContext(stop)
+	defer cancel()
+	for {
+		err := consumeNATS(ctx, db, cfg)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("Error consuming from NATS", "url", cfg.NATSURL, "stream", cfg.NATSStream, "err", err)
+		if !sleep(stop, consumerRetryDelay) {
+			return
+		}
+	}
+}
+
+// consumeNATS connects and consumes until the connection or consumer fails,
+// or ctx is done.
+func consumeNATS(ctx context.Context, db *sql.DB, cfg *Config) error {
+	nc, err := nats.Connect(cfg.NATSURL, nats.MaxReconnects(-1))
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return err
+	}
+	createCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	consumer, err := js.CreateOrUpdateConsumer(createCtx, cfg.NATSStream, jetstream.ConsumerConfig{
+		Durable:        cfg.NATSConsumer,
+		FilterSubjects: cfg.NATSSubjects,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+	})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to create consumer %s: %v", cfg.NATSConsumer, err)
+	}
+	msgs, err := consumer.Messages()
+	if err != nil {
+		return err
+	}
+	defer msgs.Stop()
+	defer context.AfterFunc(ctx, msgs.Stop)()
+	slog.Info("Consuming from NATS", "url", cfg.NATSURL, "stream", cfg.NATSStream, "consumer", cfg.NATSConsumer)
+
+	for {
+		msg, err := msgs.Next()
+		if err != nil {
+			return err
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			slog.Warn("Invalid NATS message", "subject", msg.Subject(), "err", err)
+			msg.Term()
+			continue
+		}
+		key := fmt.Sprintf("nats:%s:%d", meta.Stream, meta.Sequence.Stream)
+		if err := consumeEntry(db, cfg, key, msg.Data()); err != nil {
+			slog.Error("Error storing NATS entry", "subject", msg.Subject(), "sequence", meta.Sequence.Stream, "err", err)
+			msg.NakWithDelay(consumerRetryDelay)
+			continue
+		}
+		if err := msg.Ack(); err != nil {
+			slog.Error("Error acking NATS message", "sequence", meta.Sequence.Stream, "err", err)
+		}
+	}
+}
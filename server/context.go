@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultContextLines is the number of entries /logdata/{id}/context returns
+// on each side by default.
+const defaultContextLines = 20
+
+// LogContext is an entry with the entries of its account and system
+// immediately before and after it, oldest first.
+type LogContext struct {
+	Before []LogData `json:"before"`
+	Entry  LogData   `json:"entry"`
+	After  []LogData `json:"after"`
+}
+
+// handleGetLogContext implements GET /logdata/{id}/context?before=&after=,
+// where id is an entry's ULID or numeric id. Entries are ordered by
+// timestamp, then id, as /getdata sorts them.
+func handleGetLogContext(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/logdata/"), "/context"), "/")
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+		var lines [2]int64
+		for i, name := range []string{"before", "after"} {
+			lines[i] = defaultContextLines
+			if text := query.Get(name); text != "" {
+				n, err := strconv.ParseInt(text, 10, 64)
+				if err != nil || n < 0 {
+					writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("%s must be a non-negative integer", name))
+					return
+				}
+				lines[i] = n
+			}
+		}
+		if max := cfg.Live().MaxQueryRows; max > 0 && lines[0]+lines[1] > max {
+			requestLogger(r).Warn("Query too large", "before", lines[0], "after", lines[1])
+			writeError(w, http.StatusRequestEntityTooLarge, codeQueryTooLarge, fmt.Sprintf("before + after exceeds the maximum of %d rows", max))
+			return
+		}
+
+		var where string
+		var key interface{}
+		var start, end time.Time
+		if ulid := strings.ToUpper(id); ulidRe.MatchString(ulid) {
+			// The ULID encodes the entry timestamp, so only its partition is read
+			where, key = "ulid = ?", ulid
+			start = ulidTime(ulid)
+			end = start.Add(time.Millisecond)
+		} else if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+			where, key = "id = ?", n
+		} else {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid log entry id or ULID")
+			return
+		}
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		rows, err := db.QueryContext(ctx, "SELECT "+logDataColumns+" FROM "+logDataSource(start, end)+" WHERE account = ? AND "+where,
+			account, key)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying log entry", "id", id, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch log entry")
+			return
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			if err := rows.Err(); queryAborted(w, r, cfg, err) {
+				return
+			}
+			writeError(w, http.StatusNotFound, codeNotFound, "Log entry not found")
+			return
+		}
+		entry, err := scanLogData(rows)
+		if err != nil {
+			requestLogger(r).Error("Error scanning log entry", "id", id, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch log entry")
+			return
+		}
+		rows.Close()
+
+		lc := LogContext{Entry: entry}
+		if lc.Before, err = surroundingEntries(ctx, db, entry, "<", "DESC", lines[0]); err == nil {
+			slices.Reverse(lc.Before)
+			lc.After, err = surroundingEntries(ctx, db, entry, ">", "ASC", lines[1])
+		}
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying log context", "id", id, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch log context")
+			return
+		}
+
+		recordRead(db, r, cfg, "query", account, len(lc.Before)+1+len(lc.After))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lc)
+	}
+}
+
+// surroundingEntries returns up to n entries of entry's account and system
+// sorting before ("<", "DESC") or after (">", "ASC") it, nearest first.
+func surroundingEntries(ctx context.Context, db *sql.DB, entry LogData, op, direction string, n int64) ([]LogData, error) {
+	logs := []LogData{}
+	if n == 0 {
+		return logs, nil
+	}
+	sqlQuery := fmt.Sprintf(`SELECT %s FROM %s WHERE account = ? AND system = ? AND (timestamp %s ? OR (timestamp = ? AND id %s ?))
+		ORDER BY timestamp %s, id %s LIMIT %d`, logDataColumns, logDataSource(time.Time{}, time.Time{}), op, op, direction, direction, n)
+	ts := entry.Timestamp.UTC()
+	args := []interface{}{entry.Account, entry.System, ts, ts, *entry.ID}
+	var rowsRead int64
+	defer slowQueries.observe(sqlQuery, args, time.Now(), &rowsRead)
+	rows, err := db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rowsRead++
+		logData, err := scanLogData(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, logData)
+	}
+	return logs, rows.Err()
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Count approximations reported by /count.
+const (
+	// countFromRollups sums the hourly rollups over the filters.
+	countFromRollups = "rollups"
+	// countAtLeast is the scan budget, which the matching entries exceed.
+	countAtLeast = "at_least"
+)
+
+// CountResponse is the number of entries matching the /getdata filters.
+// Approximation tells how Count was estimated when Exact is false.
+type CountResponse struct {
+	Count         int64  `json:"count"`
+	Exact         bool   `json:"exact"`
+	Approximation string `json:"approximation,omitempty"`
+}
+
+// handleGetCount implements GET /count. Entries are counted exactly up to a
+// scan budget, cfg.Live().MaxQueryScanRows or a lower max_scan. Past it,
+// mode=auto (the default) estimates the count from the hourly rollups when
+// the filters are ones the rollups keep, and otherwise reports the budget
+// as a lower bound. mode=exact answers 413 instead, and mode=approximate
+// reads the rollups without counting.
+func handleGetCount(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+		crossAccount := account == allAccounts
+		if crossAccount && !isAdmin(r, cfg) {
+			requestLogger(r).Warn("Cross-account query denied")
+			writeError(w, http.StatusForbidden, codeForbidden, "Admin token required for cross-account queries")
+			return
+		}
+
+		mode := query.Get("mode")
+		if mode == "" {
+			mode = "auto"
+		}
+		if mode != "auto" && mode != "exact" && mode != "approximate" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "mode must be auto, exact or approximate")
+			return
+		}
+		budget := cfg.Live().MaxQueryScanRows
+		if text := query.Get("max_scan"); text != "" {
+			n, err := strconv.ParseInt(text, 10, 64)
+			if err != nil || n < 1 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "max_scan must be a positive integer")
+				return
+			}
+			if budget <= 0 || n < budget {
+				budget = n
+			}
+		}
+
+		params, err := parseQueryParams(query)
+		if err != nil {
+			requestLogger(r).Warn("Invalid query parameters", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		params.Account = account
+		if !allowedBySettings(w, r, accountSettings.checkQuery(params)) {
+			return
+		}
+		// Paging does not apply to totals
+		params.Limit, params.Offset = nil, nil
+		rollups := cfg.RollupInterval > 0 && rollupsCover(params)
+		if mode == "approximate" && !rollups {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest,
+				"Approximate counts need ROLLUP_INTERVAL and filters limited to system, module, level, min_level, start_time and end_time")
+			return
+		}
+		action := "count"
+		if crossAccount {
+			action = "cross_account_count"
+		}
+		key := queryCacheKey(r)
+		if _, ok := queryResults.serve(w, key); ok {
+			recordRead(db, r, cfg, action, account, 0)
+			return
+		}
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		var resp CountResponse
+		if mode == "approximate" {
+			var n int64
+			n, err = countRollups(ctx, db, params)
+			resp = CountResponse{Count: n, Approximation: countFromRollups}
+		} else {
+			resp, err = estimateCount(ctx, db, params, budget, rollups && mode == "auto")
+		}
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error counting log data", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to count log data")
+			return
+		}
+		if mode == "exact" && !resp.Exact {
+			requestLogger(r).Warn("Count exceeds scan budget", "budget", budget)
+			writeError(w, http.StatusRequestEntityTooLarge, codeQueryTooLarge,
+				fmt.Sprintf("More than %d entries match; narrow the filters or use mode=auto", budget))
+			return
+		}
+
+		recordRead(db, r, cfg, action, account, 0)
+		queryResults.write(w, key, params, 0, resp)
+	}
+}
+
+// estimateCount counts the rows matching params up to budget. Past it, the
+// count is read from the rollups when rollups is set, and otherwise is the
+// budget as a lower bound.
+func estimateCount(ctx context.Context, db *sql.DB, params QueryParams, budget int64, rollups bool) (CountResponse, error) {
+	n, err := countEntries(ctx, db, params, budget)
+	if err != nil {
+		return CountResponse{}, err
+	}
+	if budget <= 0 || n <= budget {
+		return CountResponse{Count: n, Exact: true}, nil
+	}
+	if !rollups {
+		return CountResponse{Count: budget, Approximation: countAtLeast}, nil
+	}
+	if n, err = countRollups(ctx, db, params); err != nil {
+		return CountResponse{}, err
+	}
+	return CountResponse{Count: n, Approximation: countFromRollups}, nil
+}
+
+// countEntries counts the rows matching params, stopping after budget + 1
+// when budget is positive.
+func countEntries(ctx context.Context, db *sql.DB, params QueryParams, budget int64) (int64, error) {
+	where, args := buildLogFilter(params)
+	start, end := params.partitionRange()
+	sqlQuery := "SELECT COUNT(*) FROM " + logDataSource(start, end) + " WHERE " + where
+	if budget > 0 {
+		sqlQuery = fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 FROM %s WHERE %s LIMIT %d)", logDataSource(start, end), where, budget+1)
+	}
+	defer slowQueries.observe(sqlQuery, args, time.Now(), nil)
+	var n int64
+	err := db.QueryRowContext(ctx, sqlQuery, args...).Scan(&n)
+	return n, err
+}
+
+// rollupsCover reports whether the rollups keep every filter of params.
+func rollupsCover(params QueryParams) bool {
+	for _, bound := range []string{params.StartTime, params.EndTime} {
+		if _, err := time.Parse(time.RFC3339, bound); bound != "" && err != nil {
+			return false
+		}
+	}
+	return params.TimeField != "received_at" && params.AsOf == nil && params.User == "" && params.Task == "" && params.TraceID == "" && params.SessionID == "" && params.Fingerprint == "" &&
+		params.MsgRegex == "" && len(params.Matches) == 0 && len(params.Fields) == 0 && len(params.FieldBounds) == 0 &&
+		!slices.ContainsFunc(params.AnyOf, func(f MetaAnyOf) bool { return f.Column == "user" || f.Column == "task" })
+}
+
+// countRollups sums the hourly rollups matching params. Hours overlapping
+// the time bounds count whole, repeats merged by deduplication count
+// individually, and entries since the last rollup run are missing.
+func countRollups(ctx context.Context, db *sql.DB, params QueryParams) (int64, error) {
+	sqlQuery := "SELECT COALESCE(SUM(count), 0) FROM log_rollups_hourly WHERE 1 = 1"
+	var args []interface{}
+	if params.Account != allAccounts {
+		sqlQuery += " AND account = ?"
+		args = append(args, params.Account)
+	}
+	if params.System != "" {
+		sqlQuery += " AND system = ?"
+		args = append(args, params.System)
+	}
+	if params.Module != "" {
+		sqlQuery += " AND module = ?"
+		args = append(args, params.Module)
+	}
+	if params.Level != nil {
+		sqlQuery += " AND level = ?"
+		args = append(args, *params.Level)
+	}
+	for _, f := range params.AnyOf {
+		sqlQuery += " AND " + f.Column + " IN " + inList(len(f.Values))
+		for _, value := range f.Values {
+			args = append(args, value)
+		}
+	}
+	if len(params.Levels) > 0 {
+		sqlQuery += " AND level IN " + inList(len(params.Levels))
+		for _, level := range params.Levels {
+			args = append(args, level)
+		}
+	}
+	if params.MinLevel != nil {
+		sqlQuery += " AND level >= ?"
+		args = append(args, *params.MinLevel)
+	}
+	if t, err := time.Parse(time.RFC3339, params.StartTime); err == nil {
+		sqlQuery += " AND bucket >= ?"
+		args = append(args, t.UTC().Truncate(time.Hour).Format(rollupBucketLayout))
+	}
+	if t, err := time.Parse(time.RFC3339, params.EndTime); err == nil {
+		sqlQuery += " AND bucket <= ?"
+		args = append(args, t.UTC().Format(rollupBucketLayout))
+	}
+	defer slowQueries.observe(sqlQuery, args, time.Now(), nil)
+	var n int64
+	err := db.QueryRowContext(ctx, sqlQuery, args...).Scan(&n)
+	return n, err
+}
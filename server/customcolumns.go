@@ -0,0 +1,453 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// customColumnsSchema holds the column definitions and, in custom_values,
+// the values of the entries stored after them, one row per entry and column
+// keyed like annotations by log_id. value takes the column's type natively,
+// so the index compares integers and reals as numbers.
+const customColumnsSchema = `CREATE TABLE IF NOT EXISTS custom_columns (
+    account TEXT NOT NULL,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (account, name)
+);
+CREATE TABLE IF NOT EXISTS custom_values (
+    log_id INTEGER NOT NULL,
+    account TEXT NOT NULL,
+    name TEXT NOT NULL,
+    value NOT NULL,
+    PRIMARY KEY (log_id, name)
+);
+CREATE INDEX IF NOT EXISTS idx_custom_values ON custom_values(account, name, value)`
+
+// insertCustomValueSQL stores one value of a new entry.
+const insertCustomValueSQL = "INSERT OR REPLACE INTO custom_values (log_id, account, name, value) VALUES (?, ?, ?, ?)"
+
+// customBackfillBatchSize is how many entries a new column's backfill reads
+// per transaction.
+const customBackfillBatchSize = 1000
+
+// CustomColumn is a typed column an admin defines for an account's
+// structured field Name. The field is validated at ingest, where a value not
+// of Type rejects the entry, and stored natively besides fields, so
+// field.<name>, field_gte.<name> and field_lte.<name> filters use an index
+// and compare as Type does.
+type CustomColumn struct {
+	Account string `json:"account"`
+	Name    string `json:"name"`
+	// Type is text, integer or real.
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// customColumnTypes are the types a CustomColumn may have.
+var customColumnTypes = map[string]bool{"text": true, "integer": true, "real": true}
+
+// Validate checks that the column is complete and of a known type.
+func (c *CustomColumn) Validate() error {
+	c.Type = strings.ToLower(c.Type)
+	switch {
+	case c.Account == "":
+		return fmt.Errorf("account is required")
+	case !fieldNameRe.MatchString(c.Name):
+		return fmt.Errorf("name must contain only letters, digits, '_' and '-'")
+	case !customColumnTypes[c.Type]:
+		return fmt.Errorf("type must be text, integer or real")
+	}
+	return nil
+}
+
+// convert returns a field value as the column's type. Numbers and numeric
+// strings convert to integer (when whole) and real, and numbers to text; any
+// other value does not convert.
+func (c CustomColumn) convert(v any) (any, bool) {
+	switch c.Type {
+	case "integer":
+		switch v := v.(type) {
+		case int64:
+			return v, true
+		case int:
+			return int64(v), true
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+				return int64(v), true
+			}
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return n, true
+			}
+		}
+	case "real":
+		switch v := v.(type) {
+		case float64:
+			return v, true
+		case int64:
+			return float64(v), true
+		case int:
+			return float64(v), true
+		case string:
+			if n, err := strconv.ParseFloat(v, 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+				return n, true
+			}
+		}
+	case "text":
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case int64:
+			return strconv.FormatInt(v, 10), true
+		case int:
+			return strconv.Itoa(v), true
+		}
+	}
+	return nil, false
+}
+
+// filterValue returns a filter's value as the column's type, or as given
+// when it does not convert, which then matches no stored value of the type.
+func (c CustomColumn) filterValue(value string) any {
+	if v, ok := c.convert(value); ok {
+		return v
+	}
+	return value
+}
+
+// customColumns holds the custom_columns rows in memory. It is set at
+// startup.
+var customColumns *customColumnSet
+
+type customColumnSet struct {
+	db        *sql.DB
+	mu        sync.RWMutex
+	byAccount map[string]map[string]CustomColumn
+}
+
+func newCustomColumnSet(db *sql.DB) *customColumnSet {
+	return &customColumnSet{db: db, byAccount: map[string]map[string]CustomColumn{}}
+}
+
+// reload refreshes the in-memory columns from the database.
+func (s *customColumnSet) reload() error {
+	rows, err := s.db.Query("SELECT account, name, type, created_at FROM custom_columns")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byAccount := map[string]map[string]CustomColumn{}
+	for rows.Next() {
+		var c CustomColumn
+		if err := rows.Scan(&c.Account, &c.Name, &c.Type, &c.CreatedAt); err != nil {
+			return err
+		}
+		if byAccount[c.Account] == nil {
+			byAccount[c.Account] = map[string]CustomColumn{}
+		}
+		byAccount[c.Account][c.Name] = c
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.byAccount = byAccount
+	s.mu.Unlock()
+	return nil
+}
+
+// column returns the custom column of account named name.
+func (s *customColumnSet) column(account, name string) (CustomColumn, bool) {
+	if s == nil {
+		return CustomColumn{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.byAccount[account][name]
+	return c, ok
+}
+
+func (s *customColumnSet) columns(account string) map[string]CustomColumn {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.byAccount[account]
+}
+
+// check reports the fields of logData that are not of the type of their
+// column.
+func (s *customColumnSet) check(logData LogData) []FieldError {
+	var errs []FieldError
+	for name, c := range s.columns(logData.Account) {
+		v, ok := logData.Fields[name]
+		if !ok || v == nil {
+			continue
+		}
+		if _, ok := c.convert(v); !ok {
+			errs = append(errs, FieldError{Field: "fields." + name, Reason: "must be " + c.Type})
+		}
+	}
+	return errs
+}
+
+// normalize converts the fields of logData with a column to its type, so
+// stored fields read back typed. Values that do not convert, e.g. from an
+// extraction rule, are kept as they are but not stored natively.
+func (s *customColumnSet) normalize(logData *LogData) {
+	for name, c := range s.columns(logData.Account) {
+		if v, ok := c.convert(logData.Fields[name]); ok {
+			logData.Fields[name] = v
+		}
+	}
+}
+
+// store writes the custom values of the entry stored with id.
+func (s *customColumnSet) store(ex execer, id int64, logData LogData) error {
+	for name, c := range s.columns(logData.Account) {
+		v, ok := c.convert(logData.Fields[name])
+		if !ok {
+			continue
+		}
+		if _, err := writeStatements.exec(ex, insertCustomValueSQL, id, logData.Account, name, v); err != nil {
+			return fmt.Errorf("failed to store custom column %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// customFilter returns the condition selecting entries of account whose
+// field name compares to value with op (=, >= or <=), or false when name
+// has no custom column.
+func customFilter(account, name, op, value string) (string, []interface{}, bool) {
+	if account == allAccounts {
+		return "", nil, false
+	}
+	c, ok := customColumns.column(account, name)
+	if !ok {
+		return "", nil, false
+	}
+	return "id IN (SELECT log_id FROM custom_values WHERE account = ? AND name = ? AND value " + op + " ?)",
+		[]interface{}{account, name, c.filterValue(value)}, true
+}
+
+// handleCustomColumns serves GET /columns, the custom columns of an account,
+// PUT /columns/{name}, which defines a column and fills it from the entries
+// already stored, and DELETE /columns/{name}.
+func handleCustomColumns(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/columns"), "/")
+		account := r.URL.Query().Get("account")
+		if account == "" && r.Method != http.MethodPut {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && name == "":
+			rows, err := db.Query("SELECT account, name, type, created_at FROM custom_columns WHERE account = ? ORDER BY name", account)
+			if err != nil {
+				requestLogger(r).Error("Error querying custom columns", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch custom columns")
+				return
+			}
+			defer rows.Close()
+			columns := []CustomColumn{}
+			for rows.Next() {
+				var c CustomColumn
+				if err := rows.Scan(&c.Account, &c.Name, &c.Type, &c.CreatedAt); err != nil {
+					requestLogger(r).Error("Error scanning row", "err", err)
+					continue
+				}
+				columns = append(columns, c)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(columns)
+
+		case r.Method == http.MethodPut && name != "":
+			var c CustomColumn
+			if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+				requestLogger(r).Warn("Invalid request body", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+				return
+			}
+			if c.Account == "" {
+				c.Account = account
+			}
+			c.Name = name
+			if err := c.Validate(); err != nil {
+				requestLogger(r).Warn("Validation failed", "err", err)
+				writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+				return
+			}
+			if !accountAllowed(r, c.Account) {
+				writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+				return
+			}
+			if existing, ok := customColumns.column(c.Account, c.Name); ok {
+				if existing.Type != c.Type {
+					writeError(w, http.StatusConflict, codeConflict, "Custom column exists with type "+existing.Type+"; delete it first")
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(existing)
+				return
+			}
+			c.CreatedAt = timeNow().UTC()
+			if _, err := db.Exec("INSERT INTO custom_columns (account, name, type, created_at) VALUES (?, ?, ?, ?)",
+				c.Account, c.Name, c.Type, c.CreatedAt); err != nil {
+				requestLogger(r).Error("Error saving custom column", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save custom column")
+				return
+			}
+			if err := customColumns.reload(); err != nil {
+				requestLogger(r).Error("Error reloading custom columns", "err", err)
+			}
+			// Entries stored from here on get their value at ingest
+			filled, err := backfillCustomColumn(r.Context(), db, c)
+			if err != nil {
+				requestLogger(r).Error("Error filling custom column", "name", c.Name, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fill custom column")
+				return
+			}
+			requestLogger(r).Info("Defined custom column", "account", c.Account, "name", c.Name, "type", c.Type, "filled", filled)
+			recordAudit(db, r, "admin", "custom_column_define", c.Account, fmt.Sprintf("name=%s type=%s filled=%d", c.Name, c.Type, filled))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(c)
+
+		case r.Method == http.MethodDelete && name != "":
+			tx, err := db.Begin()
+			if err != nil {
+				requestLogger(r).Error("Error deleting custom column", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete custom column")
+				return
+			}
+			defer tx.Rollback()
+			res, err := tx.Exec("DELETE FROM custom_columns WHERE account = ? AND name = ?", account, name)
+			if err == nil {
+				_, err = tx.Exec("DELETE FROM custom_values WHERE account = ? AND name = ?", account, name)
+			}
+			if err == nil {
+				err = tx.Commit()
+			}
+			if err != nil {
+				requestLogger(r).Error("Error deleting custom column", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete custom column")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Custom column not found")
+				return
+			}
+			if err := customColumns.reload(); err != nil {
+				requestLogger(r).Error("Error reloading custom columns", "err", err)
+			}
+			recordAudit(db, r, "admin", "custom_column_delete", account, "name="+name)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Custom column deleted"})
+
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// backfillCustomColumn stores the values of c in the entries of its account
+// stored before it was defined, in batches so ingestion is not blocked
+// meanwhile, and returns how many entries had one.
+func backfillCustomColumn(ctx context.Context, db *sql.DB, c CustomColumn) (int64, error) {
+	var filled int64
+	for _, table := range logDataTables() {
+		var lastID int64
+		for {
+			n, next, err := backfillBatch(ctx, db, table, lastID, c, &filled)
+			if err != nil {
+				return filled, err
+			}
+			if n < customBackfillBatchSize {
+				break
+			}
+			lastID = next
+		}
+	}
+	return filled, nil
+}
+
+// backfillBatch fills c in the next customBackfillBatchSize entries of its
+// account in table after id lastID, returning the number of entries read
+// and the last id.
+func backfillBatch(ctx context.Context, db *sql.DB, table string, lastID int64, c CustomColumn, filled *int64) (int, int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, lastID, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, "SELECT id, fields FROM "+table+" WHERE account = ? AND id > ? AND fields IS NOT NULL ORDER BY id LIMIT ?",
+		c.Account, lastID, customBackfillBatchSize)
+	if err != nil {
+		return 0, lastID, err
+	}
+	type value struct {
+		id    int64
+		value any
+	}
+	var values []value
+	n := 0
+	for rows.Next() {
+		var raw sql.NullString
+		if err := rows.Scan(&lastID, &raw); err != nil {
+			rows.Close()
+			return n, lastID, err
+		}
+		n++
+		if raw.String, err = unpackValue(c.Account, raw.String); err != nil {
+			continue
+		}
+		fields, err := decodeFields(raw)
+		if err != nil {
+			continue
+		}
+		if v, ok := c.convert(fields[c.Name]); ok {
+			values = append(values, value{lastID, v})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return n, lastID, err
+	}
+	for _, v := range values {
+		if _, err := tx.ExecContext(ctx, insertCustomValueSQL, v.id, c.Account, c.Name, v.value); err != nil {
+			return n, lastID, err
+		}
+	}
+	*filled += int64(len(values))
+	return n, lastID, tx.Commit()
+}
+
+// deleteEntryRows deletes the rows attached to the entries whose ids the
+// subquery ids selects: their annotations, custom values and hashes.
+func deleteEntryRows(ex execer, ids string, args ...interface{}) error {
+	for _, table := range []string{"annotations", "custom_values"} {
+		if _, err := ex.Exec("DELETE FROM "+table+" WHERE log_id IN ("+ids+")", args...); err != nil {
+			return err
+		}
+	}
+	_, err := ex.Exec("DELETE FROM entry_hashes WHERE id IN ("+ids+")", args...)
+	return err
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// openDatabase opens the SQLite database as a single-connection writer and a
+// pool of readers. SQLite allows one writer at a time, so funnelling writes
+// through one connection avoids "database is locked" errors, while WAL mode
+// lets the readers run alongside it.
+func openDatabase(cfg *Config) (writeDB, readDB *sql.DB, err error) {
+	writeDB, err = sql.Open(sqliteDriver, sqliteDSN(cfg, "immediate"))
+	if err != nil {
+		return nil, nil, err
+	}
+	writeDB.SetMaxOpenConns(1)
+
+	// In-memory databases exist per connection and cannot be shared by a pool
+	// or reopened
+	if isMemoryDatabase(cfg.DatabasePath) {
+		return writeDB, writeDB, nil
+	}
+	writeDB.SetConnMaxLifetime(cfg.SQLiteConnMaxLifetime)
+	writeDB.SetConnMaxIdleTime(cfg.SQLiteConnMaxIdleTime)
+
+	readDB, err = sql.Open(sqliteDriver, sqliteDSN(cfg, "deferred"))
+	if err != nil {
+		writeDB.Close()
+		return nil, nil, err
+	}
+	readDB.SetMaxOpenConns(cfg.SQLiteReadConns)
+	readDB.SetMaxIdleConns(cfg.SQLiteReadIdleConns)
+	readDB.SetConnMaxLifetime(cfg.SQLiteConnMaxLifetime)
+	readDB.SetConnMaxIdleTime(cfg.SQLiteConnMaxIdleTime)
+	return writeDB, readDB, nil
+}
+
+// sqliteDSN appends the connection pragmas from cfg to the database path.
+func sqliteDSN(cfg *Config, txlock string) string {
+	sep := "?"
+	if strings.Contains(cfg.DatabasePath, "?") {
+		sep = "&"
+	}
+	dsn := fmt.Sprintf("%s%s_journal_mode=%s&_busy_timeout=%d&_synchronous=%s&_txlock=%s",
+		cfg.DatabasePath, sep, cfg.SQLiteJournalMode, cfg.SQLiteBusyTimeout.Milliseconds(), cfg.SQLiteSynchronous, txlock)
+	if cfg.VacuumSchedule != "" {
+		// The driver sets it before the journal mode, which would otherwise
+		// initialize new databases without it
+		dsn += "&_auto_vacuum=incremental"
+	}
+	return dsn
+}
+
+func isMemoryDatabase(path string) bool {
+	return path == ":memory:" || strings.Contains(path, "mode=memory")
+}
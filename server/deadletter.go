@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RejectedLog is an ingestion payload that failed validation or insertion.
+type RejectedLog struct {
+	ID         int64     `json:"id"`
+	ReceivedAt time.Time `json:"received_at"`
+	Account    string    `json:"account"`
+	Payload    string    `json:"payload"`
+	Reason     string    `json:"reason"`
+}
+
+const rejectedLogsSchema = `CREATE TABLE IF NOT EXISTS rejected_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    received_at DATETIME NOT NULL,
+    account TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    reason TEXT NOT NULL
+)`
+
+// rejectLog stores a failed payload in rejected_logs when the dead-letter table is enabled.
+// The payload is redacted, and encrypted when the account's entries are.
+// Errors are only logged, the client already gets the original failure.
+func rejectLog(db *sql.DB, cfg *Config, account string, payload []byte, reason string) {
+	if !cfg.DeadLetter {
+		return
+	}
+	if err := encryption.ensure(db, account); err != nil {
+		slog.Error("Error saving rejected log", "err", err)
+		return
+	}
+	sealed, _, _, err := encryption.seal(account, redactPayload(cfg, account, string(payload)), "", sql.NullString{})
+	if err != nil {
+		slog.Error("Error saving rejected log", "err", err)
+		return
+	}
+	_, err = db.Exec("INSERT INTO rejected_logs (received_at, account, payload, reason) VALUES (?, ?, ?, ?)",
+		timeNow().UTC(), account, sealed, reason)
+	if err != nil {
+		slog.Error("Error saving rejected log", "err", err)
+	}
+}
+
+// runDeadLetterCleanup periodically deletes rejected payloads older than ttl.
+func runDeadLetterCleanup(stop <-chan struct{}, db *sql.DB, ttl time.Duration) {
+	ticker := time.NewTicker(min(ttl, time.Hour))
+	defer ticker.Stop()
+	for tick(stop, ticker) {
+		res, err := db.Exec("DELETE FROM rejected_logs WHERE received_at < ?", timeNow().UTC().Add(-ttl))
+		if err != nil {
+			slog.Error("Error cleaning up rejected logs", "err", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			slog.Info("Removed expired rejected logs", "count", n)
+		}
+	}
+}
+
+// handleRejectedLogs serves GET /admin/rejected (list) and
+// POST /admin/rejected/{id}/replay (re-ingest a payload).
+func handleRejectedLogs(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/rejected"), "/")
+		switch {
+		case rest == "" && r.Method == http.MethodGet:
+			listRejectedLogs(db, cfg, w, r)
+		case strings.HasSuffix(rest, "/replay") && r.Method == http.MethodPost:
+			id, err := strconv.ParseInt(strings.TrimSuffix(rest, "/replay"), 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid rejected log id")
+				return
+			}
+			replayRejectedLog(db, cfg, w, r, id)
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func listRejectedLogs(db *sql.DB, cfg *Config, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sqlQuery := "SELECT id, received_at, account, payload, reason FROM rejected_logs"
+	var args []interface{}
+	if account := query.Get("account"); account != "" {
+		sqlQuery += " WHERE account = ?"
+		args = append(args, account)
+	}
+
+	var limit, offset int64 = 100, 0
+	if query.Get("limit") != "" {
+		fmt.Sscanf(query.Get("limit"), "%d", &limit)
+	}
+	if query.Get("offset") != "" {
+		fmt.Sscanf(query.Get("offset"), "%d", &offset)
+	}
+	sqlQuery += fmt.Sprintf(" ORDER BY id DESC LIMIT %d OFFSET %d", limit, offset)
+
+	ctx, cancel := queryContext(r, cfg)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, sqlQuery, args...)
+	if queryAborted(w, r, cfg, err) {
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error querying rejected logs", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch rejected logs")
+		return
+	}
+	defer rows.Close()
+
+	rejected := []RejectedLog{}
+	for rows.Next() {
+		var rl RejectedLog
+		if err := rows.Scan(&rl.ID, &rl.ReceivedAt, &rl.Account, &rl.Payload, &rl.Reason); err != nil {
+			requestLogger(r).Error("Error scanning row", "err", err)
+			continue
+		}
+		if rl.Payload, err = encryption.open(rl.Account, rl.Payload); err != nil {
+			requestLogger(r).Error("Error reading rejected log", "id", rl.ID, "err", err)
+		}
+		rejected = append(rejected, rl)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rejected)
+}
+
+// replayRejectedLog runs a stored payload through validation and insertion again,
+// removing it from rejected_logs on success. A non-empty request body replaces
+// the stored payload, so admins can replay a corrected entry.
+func replayRejectedLog(db *sql.DB, cfg *Config, w http.ResponseWriter, r *http.Request, id int64) {
+	var rl RejectedLog
+	err := db.QueryRow("SELECT id, account, payload FROM rejected_logs WHERE id = ?", id).
+		Scan(&rl.ID, &rl.Account, &rl.Payload)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, codeNotFound, "Rejected log not found")
+		return
+	} else if err != nil {
+		requestLogger(r).Error("Error loading rejected log", "id", id, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load rejected log")
+		return
+	}
+
+	stored, err := encryption.open(rl.Account, rl.Payload)
+	if err != nil {
+		requestLogger(r).Error("Error reading rejected log", "id", id, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to load rejected log")
+		return
+	}
+	payload := []byte(stored)
+	if body, err := io.ReadAll(r.Body); err == nil && len(bytes.TrimSpace(body)) > 0 {
+		payload = body
+	}
+
+	var logData LogData
+	if err := json.NewDecoder(bytes.NewReader(payload)).Decode(&logData); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	logData.splitStackTrace()
+	if err := logData.Validate(); err != nil {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err), fieldErrors(err, ""))
+		return
+	}
+	if errs := logData.checkLimits(cfg); len(errs) > 0 {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, codePayloadTooLarge, "Payload limits exceeded", errs)
+		return
+	}
+	if err := logData.checkClock(cfg); err != nil {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err), fieldErrors(err, ""))
+		return
+	}
+	if logData.Account != rl.Account {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidRequest, "Account in body must match X-Account header")
+		return
+	}
+	var quotaErr *quotaError
+	if err := insertLogData(db, cfg, &logData); errors.As(err, &quotaErr) {
+		writeError(w, quotaErr.status, codeQuotaExceeded, err.Error())
+		return
+	} else if err != nil {
+		requestLogger(r).Error("Error replaying rejected log", "id", id, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save log data")
+		return
+	}
+	if _, err := db.Exec("DELETE FROM rejected_logs WHERE id = ?", id); err != nil {
+		requestLogger(r).Error("Error removing replayed rejected log", "id", id, "err", err)
+	}
+
+	requestLogger(r).Info("Replayed rejected log", "id", id, "account", rl.Account)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Log data saved successfully"})
+}
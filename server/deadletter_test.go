@@ -0,0 +1,54 @@
+package server_test
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"log-server/server"
+	"log-server/server/testutil"
+)
+
+func TestRejectedPayloadRedactedAndEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.db")
+	srv := testutil.NewServer(t, map[string]string{
+		"DATABASE_PATH":       path,
+		"DEAD_LETTER_ENABLED": "true",
+		"ENCRYPTION_KEY":      base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		"ENCRYPTED_ACCOUNTS":  "acme",
+		"REDACTION_RULES":     `[{"module":"billing","patterns":["cust-[0-9]+"]}]`,
+	})
+	entry := srv.Entry("acme", "charge failed for cust-1234")
+	entry.Module = "billing"
+	entry.System = ""
+	body, _ := json.Marshal(entry)
+	if rec := serve(srv, http.MethodPost, "/logdata", string(body), map[string]string{"X-Account": "acme"}); rec.Code != http.StatusBadRequest && rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("POST /logdata without a system: %d %s, want it rejected", rec.Code, rec.Body.String())
+	}
+
+	var rejected []server.RejectedLog
+	rec := serve(srv, http.MethodGet, "/admin/rejected?account=acme", "", nil)
+	if err := json.NewDecoder(rec.Body).Decode(&rejected); err != nil || len(rejected) != 1 {
+		t.Fatalf("GET /admin/rejected: %d %v %+v, want one payload", rec.Code, err, rejected)
+	}
+	if payload := rejected[0].Payload; !strings.Contains(payload, "charge failed for [REDACTED]") {
+		t.Fatalf("rejected payload = %s, want it redacted and decrypted", payload)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var stored string
+	if err := db.QueryRow("SELECT payload FROM rejected_logs").Scan(&stored); err != nil {
+		t.Fatalf("reading stored payload: %v", err)
+	}
+	if strings.Contains(stored, "charge failed") {
+		t.Fatalf("rejected payload stored in plaintext: %q", stored)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const addRepeatSQL = "UPDATE logData SET repeat_count = repeat_count + 1 WHERE id = ?"
+
+// mergeDuplicate collapses logData into an identical entry (same account,
+// system, module, msg and level) timestamped within cfg.Live().DedupWindow of it by
+// incrementing that entry's repeat_count. On a merge it reports true and sets
+// logData's ID and ULID to the existing entry's. Encrypted entries are never
+// merged, since their stored msg cannot be compared.
+//
+// An entry whose source_seq is already stored is a replay: mergeDuplicate
+// reports true the same way, but counts no repeat.
+func mergeDuplicate(tx *sql.Tx, cfg *Config, logData *LogData) (bool, error) {
+	if logData.SourceSeq != "" {
+		if replayed, err := findReplay(tx, logData); replayed || err != nil {
+			return replayed, err
+		}
+	}
+	if cfg.Live().DedupWindow <= 0 || encryption.encrypts(logData.Account) {
+		return false, nil
+	}
+	ts := logData.Timestamp.UTC()
+	start, end := ts.Add(-cfg.Live().DedupWindow), ts.Add(cfg.Live().DedupWindow)
+
+	var id int64
+	var ulid sql.NullString
+	var hour string
+	err := tx.QueryRow(`SELECT id, ulid, strftime('`+rollupResolutions["hour"].format+`', timestamp) FROM `+logDataSource(start, end)+`
+		WHERE account = ? AND system = ? AND module = ? AND `+storedText("msg")+` = ? AND level = ? AND timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp DESC LIMIT 1`,
+		logData.Account, logData.System, logData.Module, logData.Msg, logData.Level, start, end).Scan(&id, &ulid, &hour)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up duplicate: %v", err)
+	}
+	if _, err := writeStatements.exec(tx, addRepeatSQL, id); err != nil {
+		return false, fmt.Errorf("failed to merge duplicate: %v", err)
+	}
+	if err := addRollupRepeat(tx, *logData, id, hour); err != nil {
+		return false, fmt.Errorf("failed to update rollups: %v", err)
+	}
+	if err := recordFingerprint(tx, *logData); err != nil {
+		return false, fmt.Errorf("failed to record fingerprint: %v", err)
+	}
+	if err := recordHeartbeat(tx, *logData, timeNow()); err != nil {
+		return false, fmt.Errorf("failed to record heartbeat: %v", err)
+	}
+	logData.ID = &id
+	logData.ULID = ulid.String
+	return true, nil
+}
+
+// findReplay reports whether an entry with logData's account and source_seq
+// is stored, and sets logData's ID and ULID to that entry's. A replay keeps
+// its timestamp, so it lands in the same partition as the original.
+func findReplay(tx *sql.Tx, logData *LogData) (bool, error) {
+	var id int64
+	var ulid sql.NullString
+	err := tx.QueryRow("SELECT id, ulid FROM "+tableFor(logData.Timestamp)+" WHERE account = ? AND source_seq = ?",
+		logData.Account, logData.SourceSeq).Scan(&id, &ulid)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up replay: %v", err)
+	}
+	logData.ID = &id
+	logData.ULID = ulid.String
+	return true, nil
+}
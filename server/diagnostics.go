@@ -0,0 +1,182 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// /debug/pprof/ serves the net/http/pprof profiles and /debug/vars the
+// expvar variables (cmdline and memstats) with a "logdata" variable holding
+// the server's Diagnostics, for profiling production instances. Both need
+// the admin token, and are served on DEBUG_ADDR instead of the query
+// listener when it is set.
+
+// Diagnostics is the "logdata" variable of /debug/vars.
+type Diagnostics struct {
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+	Goroutines    int       `json:"goroutines"`
+	// Queues are the in-memory queues, by name: async_writes, webhooks and
+	// the mirrors.
+	Queues         map[string]QueueDepth `json:"queues"`
+	QueriesRunning int                   `json:"queries_running"`
+	// Databases are the connection pool statistics of the write and read
+	// pools.
+	Databases map[string]sql.DBStats `json:"databases"`
+	// Endpoints are the counters of the HTTP routes since startup, by route
+	// pattern.
+	Endpoints map[string]EndpointStats `json:"endpoints"`
+}
+
+// QueueDepth is the number of items queued and the queue's capacity.
+type QueueDepth struct {
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+}
+
+// EndpointStats counts the requests to a route, those in flight and those
+// that failed, and sums their latency.
+type EndpointStats struct {
+	Requests          int64   `json:"requests"`
+	InFlight          int64   `json:"in_flight"`
+	ClientErrors      int64   `json:"client_errors"`
+	ServerErrors      int64   `json:"server_errors"`
+	LatencySeconds    float64 `json:"latency_seconds"`
+	MaxLatencySeconds float64 `json:"max_latency_seconds"`
+}
+
+// endpointCounters holds the EndpointStats of the routes of both listeners.
+type endpointCounters struct {
+	started time.Time
+
+	mu    sync.Mutex
+	stats map[string]*EndpointStats
+}
+
+func newEndpointCounters() *endpointCounters {
+	return &endpointCounters{started: time.Now(), stats: map[string]*EndpointStats{}}
+}
+
+func (c *endpointCounters) endpoint(pattern string) *EndpointStats {
+	stats, ok := c.stats[pattern]
+	if !ok {
+		stats = &EndpointStats{}
+		c.stats[pattern] = stats
+	}
+	return stats
+}
+
+func (c *endpointCounters) snapshot() map[string]EndpointStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]EndpointStats, len(c.stats))
+	for pattern, stats := range c.stats {
+		out[pattern] = *stats
+	}
+	return out
+}
+
+// withEndpointCounters counts the requests mux serves by the pattern they
+// match, with or without the /v1 prefix. Place it outside withAPIVersions.
+func withEndpointCounters(c *endpointCounters, mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := *r
+		url := *r.URL
+		url.Path = strings.TrimPrefix(url.Path, apiVersion)
+		route.URL = &url
+		_, pattern := mux.Handler(&route)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		start := time.Now()
+		c.mu.Lock()
+		stats := c.endpoint(pattern)
+		stats.InFlight++
+		c.mu.Unlock()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			latency := time.Since(start).Seconds()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			stats.InFlight--
+			stats.Requests++
+			stats.LatencySeconds += latency
+			stats.MaxLatencySeconds = max(stats.MaxLatencySeconds, latency)
+			switch {
+			case rec.status >= 500:
+				stats.ServerErrors++
+			case rec.status >= 400:
+				stats.ClientErrors++
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// diagnostics returns the Diagnostics of s.
+func (s *Server) diagnostics() Diagnostics {
+	d := Diagnostics{
+		StartedAt:     s.endpoints.started.UTC(),
+		UptimeSeconds: time.Since(s.endpoints.started).Seconds(),
+		Goroutines:    runtime.NumGoroutine(),
+		Queues: map[string]QueueDepth{
+			"async_writes": {len(s.writes.queue), cap(s.writes.queue)},
+			"webhooks":     {len(s.webhooks.queue), cap(s.webhooks.queue)},
+		},
+		QueriesRunning: len(s.queries.slots),
+		Databases:      map[string]sql.DBStats{"write": s.db.Stats()},
+		Endpoints:      s.endpoints.snapshot(),
+	}
+	for _, m := range s.mirrors {
+		d.Queues["mirror_"+m.name] = QueueDepth{len(m.queue), cap(m.queue)}
+	}
+	if s.readDB != s.db {
+		d.Databases["read"] = s.readDB.Stats()
+	}
+	return d
+}
+
+// handleDebugVars serves GET /debug/vars: the published expvar variables
+// and the server's Diagnostics as "logdata".
+func handleDebugVars(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		diagnostics, err := json.Marshal(s.diagnostics())
+		if err != nil {
+			requestLogger(r).Error("Error encoding diagnostics", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to encode diagnostics")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprintf(w, "{\n")
+		expvar.Do(func(kv expvar.KeyValue) {
+			fmt.Fprintf(w, "%q: %s,\n", kv.Key, kv.Value)
+		})
+		fmt.Fprintf(w, "%q: %s\n}\n", "logdata", diagnostics)
+	}
+}
+
+// debugRoutes registers the /debug endpoints on mux.
+func (s *Server) debugRoutes(mux *http.ServeMux) {
+	cfg := s.cfg
+	mux.HandleFunc("/debug/vars", requireAdmin(cfg, handleDebugVars(s)))
+	mux.HandleFunc("/debug/pprof/", requireAdmin(cfg, pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", requireAdmin(cfg, pprof.Cmdline))
+	// Profiles and traces last their seconds parameter
+	mux.HandleFunc("/debug/pprof/profile", withLongRequest(cfg, requireAdmin(cfg, pprof.Profile)))
+	mux.HandleFunc("/debug/pprof/symbol", requireAdmin(cfg, pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", withLongRequest(cfg, requireAdmin(cfg, pprof.Trace)))
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package server
+
+// freeDiskSpace is not measured on this platform, so MIN_FREE_DISK_BYTES
+// never applies.
+func freeDiskSpace(dir string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const emailNotifiersSchema = `CREATE TABLE IF NOT EXISTS email_notifiers (
+    account TEXT PRIMARY KEY,
+    recipients TEXT NOT NULL,
+    min_level INTEGER NOT NULL,
+    digest_seconds INTEGER NOT NULL,
+    max_per_hour INTEGER NOT NULL,
+    enabled INTEGER NOT NULL DEFAULT 1,
+    updated_at DATETIME NOT NULL,
+    slack_url TEXT NOT NULL DEFAULT '',
+    teams_url TEXT NOT NULL DEFAULT ''
+)`
+
+const emailNotifierColumns = "account, recipients, min_level, digest_seconds, max_per_hour, enabled, updated_at, slack_url, teams_url"
+
+// initializeEmailNotifiers creates email_notifiers, adding the columns
+// introduced after it.
+func initializeEmailNotifiers(db *sql.DB) error {
+	if _, err := db.Exec(emailNotifiersSchema); err != nil {
+		return fmt.Errorf("failed to create email_notifiers table: %v", err)
+	}
+	if err := ensureColumn(db, "email_notifiers", "slack_url", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	return ensureColumn(db, "email_notifiers", "teams_url", "TEXT NOT NULL DEFAULT ''")
+}
+
+// Defaults of an EmailNotifier, and how many entries a digest lists; the
+// others are only counted.
+const (
+	emailDigestSeconds    = 60
+	emailMaxPerHour       = 6
+	emailDigestMaxEntries = 50
+)
+
+// EmailNotifier emails Recipients the entries of Account at or above
+// MinLevel, and posts them to the Slack and Teams incoming webhooks SlackURL
+// and TeamsURL. The entries arriving within DigestSeconds of the first are
+// sent together in one digest, and at most MaxPerHour digests are sent an
+// hour; entries arriving beyond that wait for the next digest.
+type EmailNotifier struct {
+	Account       string    `json:"account"`
+	Recipients    []string  `json:"recipients"`
+	SlackURL      string    `json:"slack_url,omitempty"`
+	TeamsURL      string    `json:"teams_url,omitempty"`
+	MinLevel      int       `json:"min_level"`
+	DigestSeconds int       `json:"digest_seconds"`
+	MaxPerHour    int       `json:"max_per_hour"`
+	Enabled       bool      `json:"enabled"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Validate checks that the notifier has somewhere to notify and sane limits.
+func (n EmailNotifier) Validate() error {
+	if n.Account == "" {
+		return fmt.Errorf("account is required")
+	}
+	if len(n.Recipients) == 0 && n.SlackURL == "" && n.TeamsURL == "" {
+		return fmt.Errorf("recipients, slack_url or teams_url is required")
+	}
+	if err := validChatURL("slack_url", n.SlackURL); err != nil {
+		return err
+	}
+	if err := validChatURL("teams_url", n.TeamsURL); err != nil {
+		return err
+	}
+	for _, to := range n.Recipients {
+		if !strings.Contains(to, "@") || strings.ContainsAny(to, ", \r\n") {
+			return fmt.Errorf("recipient %q is not an email address", to)
+		}
+	}
+	if n.MinLevel <= 0 {
+		return fmt.Errorf("min_level must be positive")
+	}
+	if n.DigestSeconds < 0 {
+		return fmt.Errorf("digest_seconds must not be negative")
+	}
+	if n.MaxPerHour <= 0 {
+		return fmt.Errorf("max_per_hour must be positive")
+	}
+	return nil
+}
+
+func scanEmailNotifier(scan func(dest ...interface{}) error) (EmailNotifier, error) {
+	var n EmailNotifier
+	var recipients string
+	err := scan(&n.Account, &recipients, &n.MinLevel, &n.DigestSeconds, &n.MaxPerHour, &n.Enabled, &n.UpdatedAt, &n.SlackURL, &n.TeamsURL)
+	n.Recipients = []string{}
+	if recipients != "" {
+		n.Recipients = strings.Split(recipients, ",")
+	}
+	return n, err
+}
+
+// emailNotifiers holds the email_notifiers rows in memory and the digests
+// being collected. It is set at startup.
+var emailNotifiers *emailNotifierSet
+
+// emailDigest collects the entries of an account until its digest is sent.
+// sent holds when the digests of the last hour went out.
+type emailDigest struct {
+	entries []LogData
+	total   int
+	first   time.Time
+	timer   *time.Timer
+	sent    []time.Time
+}
+
+type emailNotifierSet struct {
+	db  *sql.DB
+	cfg *Config
+
+	mu        sync.Mutex
+	byAccount map[string]EmailNotifier
+	digests   map[string]*emailDigest
+}
+
+func newEmailNotifierSet(db *sql.DB, cfg *Config) *emailNotifierSet {
+	return &emailNotifierSet{db: db, cfg: cfg, byAccount: map[string]EmailNotifier{}, digests: map[string]*emailDigest{}}
+}
+
+// reload refreshes the in-memory notifiers from the database. Digests being
+// collected for an account without an enabled notifier are dropped when due.
+func (s *emailNotifierSet) reload() error {
+	rows, err := s.db.Query("SELECT " + emailNotifierColumns + " FROM email_notifiers WHERE enabled = 1")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byAccount := map[string]EmailNotifier{}
+	for rows.Next() {
+		n, err := scanEmailNotifier(rows.Scan)
+		if err != nil {
+			return err
+		}
+		byAccount[n.Account] = n
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.byAccount = byAccount
+	s.mu.Unlock()
+	return nil
+}
+
+// notify adds logData to the digest of its account when it reaches the
+// notifier's level, starting the digest unless one is pending. It is an
+// insert hook.
+func (s *emailNotifierSet) notify(logData LogData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.byAccount[logData.Account]
+	if !ok || logData.Level < n.MinLevel {
+		return
+	}
+	d := s.digests[logData.Account]
+	if d == nil {
+		d = &emailDigest{}
+		s.digests[logData.Account] = d
+	}
+	if d.total == 0 {
+		d.first = timeNow()
+	}
+	d.total++
+	if len(d.entries) < emailDigestMaxEntries {
+		d.entries = append(d.entries, logData)
+	}
+	if d.timer == nil {
+		account := logData.Account
+		d.timer = time.AfterFunc(time.Duration(n.DigestSeconds)*time.Second, func() { s.flush(account) })
+	}
+}
+
+// flush sends the digest of account, or postpones it until the oldest digest
+// of the last hour is an hour old when MaxPerHour were sent.
+func (s *emailNotifierSet) flush(account string) {
+	s.mu.Lock()
+	d := s.digests[account]
+	n, ok := s.byAccount[account]
+	if !ok {
+		delete(s.digests, account)
+		s.mu.Unlock()
+		return
+	}
+	now := timeNow()
+	for len(d.sent) > 0 && now.Sub(d.sent[0]) >= time.Hour {
+		d.sent = d.sent[1:]
+	}
+	if len(d.sent) >= n.MaxPerHour {
+		wait := d.sent[len(d.sent)-n.MaxPerHour].Add(time.Hour).Sub(now)
+		d.timer = time.AfterFunc(wait, func() { s.flush(account) })
+		total := d.total
+		s.mu.Unlock()
+		slog.Info("Email digest rate limited", "account", account, "entries", total, "delay", wait)
+		return
+	}
+	entries, total, first := d.entries, d.total, d.first
+	d.entries, d.total, d.timer = nil, 0, nil
+	d.sent = append(d.sent, now)
+	s.mu.Unlock()
+
+	if len(n.Recipients) > 0 {
+		subject := fmt.Sprintf("[logdata] %d entries at level >= %d for %s", total, n.MinLevel, account)
+		if err := sendEmail(s.cfg, n.Recipients, subject, emailDigestBody(n, entries, total, first, now)); err != nil {
+			slog.Error("Error sending email digest", "account", account, "entries", total, "err", err)
+		} else {
+			slog.Info("Sent email digest", "account", account, "entries", total)
+		}
+	}
+	if n.SlackURL != "" || n.TeamsURL != "" {
+		if err := postChat(n.SlackURL, n.TeamsURL, digestChatMessage(s.cfg, n, entries, total, first, now)); err != nil {
+			slog.Error("Error sending chat digest", "account", account, "entries", total, "err", err)
+		}
+	}
+}
+
+// digestChatMessage describes a digest, linking to the entries received
+// while it was collected.
+func digestChatMessage(cfg *Config, n EmailNotifier, entries []LogData, total int, first, last time.Time) chatMessage {
+	query := url.Values{
+		"account":    {n.Account},
+		"min_level":  {strconv.Itoa(n.MinLevel)},
+		"time_field": {"received_at"},
+		"start_time": {first.UTC().Format(time.RFC3339Nano)},
+		"end_time":   {last.UTC().Format(time.RFC3339Nano)},
+	}
+	return chatMessage{
+		Title: fmt.Sprintf("%d entries at level >= %d for %s", total, n.MinLevel, n.Account),
+		Summary: fmt.Sprintf("Received between %s and %s.",
+			first.UTC().Format(time.RFC3339), last.UTC().Format(time.RFC3339)),
+		Entries: entries,
+		Total:   total,
+		Link:    queryLink(cfg, query),
+	}
+}
+
+// emailDigestBody lists the entries of a digest, oldest first.
+func emailDigestBody(n EmailNotifier, entries []LogData, total int, first, last time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d entries at level >= %d arrived for %s between %s and %s.\n\n",
+		total, n.MinLevel, n.Account, first.UTC().Format(time.RFC3339), last.UTC().Format(time.RFC3339))
+	for _, e := range entries {
+		b.WriteString(entryLine(e) + "\n")
+		if e.StackTrace != "" {
+			for _, line := range strings.Split(strings.TrimRight(e.StackTrace, "\n"), "\n") {
+				b.WriteString("    " + line + "\n")
+			}
+		}
+	}
+	if more := total - len(entries); more > 0 {
+		fmt.Fprintf(&b, "... and %d more.\n", more)
+	}
+	return b.String()
+}
+
+// handleEmailNotifiers serves GET, PUT and DELETE /notifiers, an account's
+// email notifier.
+func handleEmailNotifiers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := r.URL.Query().Get("account")
+		if account == "" && r.Method != http.MethodPut {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			n, err := scanEmailNotifier(db.QueryRow("SELECT "+emailNotifierColumns+" FROM email_notifiers WHERE account = ?", account).Scan)
+			if err == sql.ErrNoRows {
+				writeError(w, http.StatusNotFound, codeNotFound, "Email notifier not found")
+				return
+			} else if err != nil {
+				requestLogger(r).Error("Error querying email notifier", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch email notifier")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(n)
+
+		case http.MethodPut:
+			n := EmailNotifier{MinLevel: LevelFatal, DigestSeconds: emailDigestSeconds, MaxPerHour: emailMaxPerHour, Enabled: true}
+			if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+				requestLogger(r).Warn("Invalid request body", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+				return
+			}
+			if n.Account == "" {
+				n.Account = account
+			}
+			if n.Recipients == nil {
+				n.Recipients = []string{}
+			}
+			if err := n.Validate(); err != nil {
+				requestLogger(r).Warn("Validation failed", "err", err)
+				writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+				return
+			}
+			if !accountAllowed(r, n.Account) {
+				writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+				return
+			}
+			n.UpdatedAt = timeNow().UTC()
+			if _, err := db.Exec(`INSERT INTO email_notifiers (`+emailNotifierColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (account) DO UPDATE SET recipients = excluded.recipients, min_level = excluded.min_level,
+				digest_seconds = excluded.digest_seconds, max_per_hour = excluded.max_per_hour,
+				enabled = excluded.enabled, updated_at = excluded.updated_at,
+				slack_url = excluded.slack_url, teams_url = excluded.teams_url`,
+				n.Account, strings.Join(n.Recipients, ","), n.MinLevel, n.DigestSeconds, n.MaxPerHour, n.Enabled, n.UpdatedAt,
+				n.SlackURL, n.TeamsURL); err != nil {
+				requestLogger(r).Error("Error saving email notifier", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save email notifier")
+				return
+			}
+			if err := emailNotifiers.reload(); err != nil {
+				requestLogger(r).Error("Error reloading email notifiers", "err", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(n)
+
+		case http.MethodDelete:
+			res, err := db.Exec("DELETE FROM email_notifiers WHERE account = ?", account)
+			if err != nil {
+				requestLogger(r).Error("Error deleting email notifier", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete email notifier")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Email notifier not found")
+				return
+			}
+			if err := emailNotifiers.reload(); err != nil {
+				requestLogger(r).Error("Error reloading email notifiers", "err", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Email notifier deleted"})
+
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
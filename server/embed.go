@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Start starts the background jobs of s (retention, rollups, alerts and the
+// like) and the listeners other than HTTP ones configured, such as gRPC or
+// Fluentd forward, as Main does. Both run until Close.
+func (s *Server) Start() {
+	s.runJobs()
+}
+
+// Write stores entry as POST /logdata does with ack=sync, without going
+// through the HTTP routes and their interceptors, and returns its ULID. The
+// ULID is empty when the entry was sampled out or dropped by its level
+// threshold. Maintenance mode and backpressure refuse entries as they refuse
+// requests.
+func (s *Server) Write(entry LogData) (string, error) {
+	entry.splitStackTrace()
+	if err := entry.Validate(); err != nil {
+		return "", fmt.Errorf("validation failed: %v", err)
+	}
+	if errs := entry.checkLimits(s.cfg); len(errs) > 0 {
+		reasons := make([]string, len(errs))
+		for i, e := range errs {
+			reasons[i] = e.Field + " " + e.Reason
+		}
+		return "", fmt.Errorf("payload limits exceeded: %s", strings.Join(reasons, ", "))
+	}
+	if err := entry.checkClock(s.cfg); err != nil {
+		return "", fmt.Errorf("validation failed: %v", err)
+	}
+	if refusal := admission.refusal(); refusal != nil {
+		return "", refusal
+	}
+	entry.ULID = newULID(entry.Timestamp)
+	if err := insertLogData(s.db, s.cfg, &entry); err != nil {
+		return "", err
+	}
+	return entry.ULID, nil
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// encryptedPrefix marks msg and fields values sealed with an account's data
+// key. The rest of the value is base64 of the nonce followed by the
+// ciphertext.
+const encryptedPrefix = "enc1:"
+
+const accountKeysSchema = `CREATE TABLE IF NOT EXISTS account_keys (
+    account TEXT PRIMARY KEY,
+    wrapped_key BLOB NOT NULL,
+    master_key_id TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+)`
+
+// encryption is set when ENCRYPTION_KEY is configured. The msg and fields of
+// entries in cfg.EncryptedAccounts are then stored encrypted with a per-account
+// data key, itself stored wrapped by the master key.
+var encryption *encryptor
+
+type encryptor struct {
+	master   cipher.AEAD
+	masterID string
+	all      bool
+	accounts map[string]bool
+
+	mu   sync.Mutex
+	keys map[string]cipher.AEAD
+}
+
+func newEncryptor(cfg *Config) (*encryptor, error) {
+	master, err := newAEAD(cfg.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ENCRYPTION_KEY: %v", err)
+	}
+	sum := sha256.Sum256(cfg.EncryptionKey)
+	e := &encryptor{master: master, masterID: hex.EncodeToString(sum[:8]), accounts: map[string]bool{}, keys: map[string]cipher.AEAD{}}
+	for _, account := range cfg.EncryptedAccounts {
+		if account == "*" {
+			e.all = true
+		}
+		e.accounts[account] = true
+	}
+	return e, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// init loads the stored data keys, failing when they were wrapped with a
+// different master key.
+func (e *encryptor) init(db *sql.DB) error {
+	rows, err := db.Query("SELECT account, wrapped_key, master_key_id FROM account_keys")
+	if err != nil {
+		return fmt.Errorf("failed to load account keys: %v", err)
+	}
+	defer rows.Close()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for rows.Next() {
+		var account, masterID string
+		var wrapped []byte
+		if err := rows.Scan(&account, &wrapped, &masterID); err != nil {
+			return err
+		}
+		if masterID != e.masterID {
+			return fmt.Errorf("the data key of account %s was wrapped with a different ENCRYPTION_KEY", account)
+		}
+		key, err := e.unwrap(account, wrapped)
+		if err != nil {
+			return fmt.Errorf("failed to unwrap the data key of account %s: %v", account, err)
+		}
+		e.keys[account] = key
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(e.keys) > 0 {
+		slog.Info("Loaded account data keys", "accounts", len(e.keys))
+	}
+	return nil
+}
+
+func (e *encryptor) unwrap(account string, wrapped []byte) (cipher.AEAD, error) {
+	size := e.master.NonceSize()
+	if len(wrapped) < size {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	key, err := e.master.Open(nil, wrapped[:size], wrapped[size:], []byte(account))
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
+}
+
+// encrypts reports whether new entries of account are stored encrypted.
+func (e *encryptor) encrypts(account string) bool {
+	return e != nil && (e.all || e.accounts[account])
+}
+
+// ensure creates the data key of account if its entries are encrypted and it
+// has none yet. Like partitionSet.ensure it runs outside the insert's
+// transaction, so a rolled back insert cannot lose a key that later entries
+// were encrypted with.
+func (e *encryptor) ensure(db *sql.DB, account string) error {
+	if !e.encrypts(account) {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.keys[account]; ok {
+		return nil
+	}
+
+	dataKey := make([]byte, 32)
+	nonce := make([]byte, e.master.NonceSize())
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	wrapped := e.master.Seal(nonce, nonce, dataKey, []byte(account))
+	if _, err := db.Exec("INSERT INTO account_keys (account, wrapped_key, master_key_id, created_at) VALUES (?, ?, ?, ?)",
+		account, wrapped, e.masterID, timeNow().UTC()); err != nil {
+		return fmt.Errorf("failed to store the data key of account %s: %v", account, err)
+	}
+	key, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	e.keys[account] = key
+	slog.Info("Created account data key", "account", account)
+	return nil
+}
+
+// seal encrypts msg, the stack trace and the encoded fields of an entry of
+// account when its entries are encrypted, and returns them unchanged
+// otherwise. An empty stack trace stays empty. The data key must already
+// exist (see ensure).
+func (e *encryptor) seal(account, msg, stackTrace string, fields sql.NullString) (string, string, sql.NullString, error) {
+	if !e.encrypts(account) {
+		return msg, stackTrace, fields, nil
+	}
+	e.mu.Lock()
+	key, ok := e.keys[account]
+	e.mu.Unlock()
+	if !ok {
+		return "", "", fields, fmt.Errorf("no data key for account %s", account)
+	}
+	sealed, err := sealValue(key, account, msg)
+	if err != nil {
+		return "", "", fields, err
+	}
+	if stackTrace != "" {
+		if stackTrace, err = sealValue(key, account, stackTrace); err != nil {
+			return "", "", fields, err
+		}
+	}
+	if fields.Valid {
+		if fields.String, err = sealValue(key, account, fields.String); err != nil {
+			return "", "", fields, err
+		}
+	}
+	return sealed, stackTrace, fields, nil
+}
+
+func sealValue(key cipher.AEAD, account, value string) (string, error) {
+	nonce := make([]byte, key.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(key.Seal(nonce, nonce, []byte(value), []byte(account))), nil
+}
+
+// open decrypts a stored msg, stack trace or fields value of account. Values that were
+// not encrypted, including everything in accounts without a data key, are
+// returned as is.
+func (e *encryptor) open(account, value string) (string, error) {
+	if e == nil || !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	e.mu.Lock()
+	key, ok := e.keys[account]
+	e.mu.Unlock()
+	if !ok {
+		return value, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(value[len(encryptedPrefix):])
+	if err != nil || len(raw) < key.NonceSize() {
+		return value, fmt.Errorf("malformed encrypted value")
+	}
+	plain, err := key.Open(nil, raw[:key.NonceSize()], raw[key.NonceSize():], []byte(account))
+	if err != nil {
+		return value, err
+	}
+	return string(plain), nil
+}
+
+// forget drops the data key of account after its account_keys row was
+// deleted. Copies of its entries elsewhere, such as in backups, can then no
+// longer be decrypted.
+func (e *encryptor) forget(account string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	delete(e.keys, account)
+	e.mu.Unlock()
+}
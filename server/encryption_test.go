@@ -0,0 +1,49 @@
+package server_test
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"log-server/server/testutil"
+)
+
+func TestStackTraceRedactedAndEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.db")
+	srv := testutil.NewServer(t, map[string]string{
+		"DATABASE_PATH":      path,
+		"ENCRYPTION_KEY":     base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		"ENCRYPTED_ACCOUNTS": "acme",
+		"REDACTION_RULES":    `[{"patterns":["cust-[0-9]+"]}]`,
+	})
+	entry := srv.Entry("acme", "payment failed")
+	entry.StackTrace = "charge(cust-1234)\n\tbilling.go:42"
+	body, _ := json.Marshal(entry)
+	if rec := serve(srv, http.MethodPost, "/logdata", string(body), map[string]string{"X-Account": "acme"}); rec.Code != http.StatusOK {
+		t.Fatalf("POST /logdata: %d %s", rec.Code, rec.Body.String())
+	}
+
+	entries := srv.Entries("acme", nil)
+	if len(entries) != 1 || entries[0].StackTrace != "charge([REDACTED])\n\tbilling.go:42" {
+		t.Fatalf("got %+v, want the stack trace redacted and decrypted", entries)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var stored string
+	if err := db.QueryRow("SELECT stack_trace FROM logdata").Scan(&stored); err != nil {
+		t.Fatalf("reading stored stack_trace: %v", err)
+	}
+	if strings.Contains(stored, "billing.go") {
+		t.Fatalf("stack_trace stored in plaintext: %q", stored)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// APIError is the body of error responses. Code is stable and meant for
+// clients to branch on; Message, under the "error" key clients read before
+// codes existed, is for people and may change. RequestID repeats the
+// X-Request-ID response header so a logged body can be matched to the
+// server's log.
+type APIError struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"error"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// Error codes of APIError.
+const (
+	codeInvalidRequest   = "INVALID_REQUEST"
+	codeValidationFailed = "VALIDATION_FAILED"
+	codeUnauthorized     = "UNAUTHORIZED"
+	codeForbidden        = "FORBIDDEN"
+	codeNotFound         = "NOT_FOUND"
+	codeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	codeConflict         = "CONFLICT"
+	codePayloadTooLarge  = "PAYLOAD_TOO_LARGE"
+	codeQueryTooLarge    = "QUERY_TOO_LARGE"
+	codeQuotaExceeded    = "QUOTA_EXCEEDED"
+	codeTooManyQueries   = "TOO_MANY_QUERIES"
+	codeQueryTimeout     = "QUERY_TIMEOUT"
+	codeReadOnlyReplica  = "READ_ONLY_REPLICA"
+	codeUnavailable      = "UNAVAILABLE"
+	codeMaintenance      = "MAINTENANCE"
+	codeUpstreamFailed   = "UPSTREAM_FAILED"
+	codeInternal         = "INTERNAL_ERROR"
+)
+
+// statusErrorCode is the code of an error whose status is only known at run
+// time, such as an authError's.
+func statusErrorCode(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return codeUnauthorized
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusConflict:
+		return codeConflict
+	case http.StatusServiceUnavailable:
+		return codeUnavailable
+	case http.StatusBadGateway:
+		return codeUpstreamFailed
+	}
+	if status >= 500 {
+		return codeInternal
+	}
+	return codeInvalidRequest
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+// writeErrorDetails writes a JSON error response with per-field errors.
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, errs []FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIError{Code: code, Message: message, Errors: errs, RequestID: w.Header().Get(requestIDHeader)})
+}
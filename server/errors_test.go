@@ -0,0 +1,31 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"log-server/server"
+	"log-server/server/testutil"
+)
+
+func TestValidationErrorsListFields(t *testing.T) {
+	srv := testutil.NewServer(t, nil)
+	entry := srv.Entry("acme", "missing user")
+	entry.User = ""
+	body, _ := json.Marshal(entry)
+	rec := serve(srv, http.MethodPost, "/logdata", string(body), map[string]string{"X-Account": "acme"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("POST /logdata: %d %s, want 400", rec.Code, rec.Body.String())
+	}
+	var res struct {
+		Code   string              `json:"code"`
+		Errors []server.FieldError `json:"errors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if res.Code != "VALIDATION_FAILED" || len(res.Errors) != 1 || res.Errors[0].Field != "user" || res.Errors[0].Reason != "required" {
+		t.Fatalf("got %+v, want a VALIDATION_FAILED error listing user as required", res)
+	}
+}
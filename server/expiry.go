@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// Entries may carry their own expiry, as expires_at or as a ttl from the
+// time they are received, so short-lived debug dumps are removed before
+// their account's retention would. The retention job removes them every
+// EXPIRY_INTERVAL once expired. An expiry only shortens retention: entries
+// never outlive RETENTION_PERIOD or their level's rule.
+
+// defaultTTL gives logData the ttl of r, its ttl parameter or X-Logdata-TTL
+// header, unless the entry has an expiry of its own.
+func (l *LogData) defaultTTL(r *http.Request) {
+	if l.TTL == "" && l.ExpiresAt == nil {
+		l.TTL = rawParam(r, "ttl")
+	}
+}
+
+// resolveExpiry turns the ttl of an entry received at now into its
+// expires_at. Validate rejects invalid ttls; paths not validating entries
+// drop them.
+func (l *LogData) resolveExpiry(now time.Time) {
+	if l.TTL == "" {
+		return
+	}
+	if ttl, err := parseRelativeDuration(l.TTL); err == nil && ttl > 0 && l.ExpiresAt == nil {
+		expiresAt := now.Add(ttl).UTC()
+		l.ExpiresAt = &expiresAt
+	}
+	l.TTL = ""
+}
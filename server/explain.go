@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"slices"
+)
+
+// QueryExplanation is the /getdata?explain=true response: the statement the
+// query runs, with its arguments, and how SQLite would run it. EstimatedRows
+// counts the matching entries like /count, before limit and offset.
+type QueryExplanation struct {
+	SQL           string        `json:"sql"`
+	Args          []any         `json:"args"`
+	Plan          []string      `json:"plan"`
+	Indexes       []string      `json:"indexes"`
+	Suggestion    string        `json:"suggestion,omitempty"`
+	EstimatedRows CountResponse `json:"estimated_rows"`
+}
+
+// planIndexRe finds the indexes named in EXPLAIN QUERY PLAN steps.
+var planIndexRe = regexp.MustCompile(`USING (?:COVERING )?INDEX (\w+)`)
+
+// explainLogQuery describes the /getdata query of params without running
+// it. Only the estimate reads entries, at most budget of them.
+func explainLogQuery(ctx context.Context, db *sql.DB, cfg *Config, params QueryParams, budget int64) (QueryExplanation, error) {
+	sqlQuery, args := buildLogQuery(params)
+	explanation := QueryExplanation{SQL: sqlQuery, Args: args, Indexes: []string{}}
+	if explanation.Args == nil {
+		explanation.Args = []any{}
+	}
+	var err error
+	if explanation.Plan, err = explainQuery(ctx, db, sqlQuery, args); err != nil {
+		return explanation, err
+	}
+	for _, step := range explanation.Plan {
+		for _, m := range planIndexRe.FindAllStringSubmatch(step, -1) {
+			if !slices.Contains(explanation.Indexes, m[1]) {
+				explanation.Indexes = append(explanation.Indexes, m[1])
+			}
+		}
+	}
+	if explanation.Suggestion, err = suggestIndex(ctx, db, sqlQuery, explanation.Plan); err != nil {
+		return explanation, err
+	}
+	params.Limit, params.Offset = nil, nil
+	explanation.EstimatedRows, err = estimateCount(ctx, db, params, budget, cfg.RollupInterval > 0 && rollupsCover(params))
+	return explanation, err
+}
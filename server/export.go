@@ -0,0 +1,174 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// exportFlushRows is how many entries /export writes between flushes.
+const exportFlushRows = 1000
+
+// handleExport serves GET /export?account=&start_time=&end_time=, streaming
+// the account's entries in the range (both optional, end exclusive) and
+// matching the other /getdata filters oldest first as gzip compressed
+// NDJSON, the format POST /import reads. Exports are not bound by QUERY_TIMEOUT. A failure mid-stream ends
+// the response without the gzip trailer, so clients see it as truncated.
+// Every export is audited with the entries written, including failed ones.
+func handleExport(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" || account == allAccounts {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "A single account query parameter is required")
+			return
+		}
+		params, err := parseQueryParams(query)
+		if err != nil {
+			requestLogger(r).Warn("Invalid query parameters", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		params.Account, params.Limit, params.Offset = account, nil, nil
+		params.OrderBy, params.Direction = "timestamp", "asc"
+		params.endExclusive, params.withHashes = true, true
+		if query.Get("end_time") == "" {
+			// Exports are open-ended, unlike queries ending now
+			params.EndTime = ""
+		}
+		if !allowedBySettings(w, r, accountSettings.checkStreaming(account)) || !allowedBySettings(w, r, accountSettings.checkQuery(params)) {
+			return
+		}
+
+		// Headers wait for the first entry, so a failing query can still be
+		// answered with an error
+		attachment := func() {
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.ndjson.gz"`, account, timeNow().UTC().Format("20060102T150405Z")))
+		}
+		gz := gzip.NewWriter(w)
+		enc := json.NewEncoder(gz)
+		flusher, _ := w.(http.Flusher)
+		n := 0
+		defer func() { recordRead(db, r, cfg, "export", account, n) }()
+		var writeErr error
+		err = queryEntries(r.Context(), db, params, func(logData LogData) error {
+			if n == 0 {
+				attachment()
+			}
+			if writeErr = enc.Encode(logData); writeErr != nil {
+				return writeErr
+			}
+			if n++; n%exportFlushRows == 0 && flusher != nil {
+				gz.Flush()
+				flusher.Flush()
+			}
+			return nil
+		})
+		switch {
+		case err != nil && (n > 0 || err == writeErr):
+			requestLogger(r).Warn("Export aborted", "account", account, "entries", n, "err", err)
+			return
+		case err != nil:
+			requestLogger(r).Error("Error querying export", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to export log data")
+			return
+		}
+		if n == 0 {
+			attachment()
+		}
+		gz.Close()
+		requestLogger(r).Info("Exported log data", "account", account, "entries", n)
+	}
+}
+
+// handleSnapshot serves GET /admin/snapshot, a consistent copy of the whole
+// database made with the SQLite online backup API while the server keeps
+// running.
+func handleSnapshot(db, readDB *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		f, err := os.CreateTemp("", "logdata-snapshot-*.db")
+		if err != nil {
+			requestLogger(r).Error("Error creating snapshot file", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to create snapshot")
+			return
+		}
+		path := f.Name()
+		f.Close()
+		defer os.Remove(path)
+
+		started := time.Now()
+		if err := backupDatabase(r.Context(), readDB, path); err != nil {
+			requestLogger(r).Error("Error creating snapshot", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to create snapshot")
+			return
+		}
+		f, err = os.Open(path)
+		if err != nil {
+			requestLogger(r).Error("Error opening snapshot", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to create snapshot")
+			return
+		}
+		defer f.Close()
+		info, _ := f.Stat()
+		recordAudit(db, r, "admin", "snapshot", "", fmt.Sprintf("bytes=%d", info.Size()))
+		requestLogger(r).Info("Created snapshot", "bytes", info.Size(), "duration", time.Since(started))
+
+		w.Header().Set("Content-Type", "application/vnd.sqlite3")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="logdata-%s.db"`, timeNow().UTC().Format("20060102T150405Z")))
+		io.Copy(w, f)
+	}
+}
+
+// backupDatabase copies the database behind src to a new SQLite file at path
+// in a single backup step, so the copy reflects one point in time.
+func backupDatabase(ctx context.Context, src *sql.DB, path string) error {
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver any) error {
+		return srcConn.Raw(func(srcDriver any) error {
+			backup, err := destDriver.(*sqlite3.SQLiteConn).Backup("main", srcDriver.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
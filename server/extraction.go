@@ -0,0 +1,469 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const extractionRulesSchema = `CREATE TABLE IF NOT EXISTS extraction_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account TEXT NOT NULL,
+    name TEXT NOT NULL,
+    module TEXT NOT NULL DEFAULT '',
+    pattern TEXT NOT NULL DEFAULT '',
+    grok TEXT NOT NULL DEFAULT '',
+    types TEXT NOT NULL DEFAULT '{}',
+    enabled INTEGER NOT NULL DEFAULT 1
+)`
+
+// ExtractionRule parses the msg of new entries of Account, and of Module
+// when set, into structured fields. Pattern is an RE2 expression whose named
+// groups name the fields; Grok is the same written with %{NAME:field} or
+// %{NAME:field:type} references to grokPatterns. Types converts fields to
+// int, float or duration (milliseconds); fields are strings otherwise.
+type ExtractionRule struct {
+	ID      int64             `json:"id"`
+	Account string            `json:"account"`
+	Name    string            `json:"name"`
+	Module  string            `json:"module,omitempty"`
+	Pattern string            `json:"pattern,omitempty"`
+	Grok    string            `json:"grok,omitempty"`
+	Types   map[string]string `json:"types,omitempty"`
+	Enabled bool              `json:"enabled"`
+
+	re     *regexp.Regexp
+	fields []extractedField
+}
+
+// extractedField is the capture group of re holding field name.
+type extractedField struct {
+	group int
+	name  string
+	kind  string
+}
+
+// grokPatterns are the names %{NAME} may reference in a grok pattern.
+var grokPatterns = map[string]string{
+	"INT":               `[+-]?\d+`,
+	"NUMBER":            `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"WORD":              `\w+`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"`,
+	"UUID":              `[0-9A-Fa-f]{8}-(?:[0-9A-Fa-f]{4}-){3}[0-9A-Fa-f]{12}`,
+	"IP":                `(?:\d{1,3}\.){3}\d{1,3}|[0-9A-Fa-f]*:[0-9A-Fa-f:.]+`,
+	"HOSTNAME":          `[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?)*`,
+	"URIPATH":           `/[^\s?#]*`,
+	"HTTPMETHOD":        `GET|HEAD|POST|PUT|PATCH|DELETE|OPTIONS|CONNECT|TRACE`,
+	"DURATION":          `\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h)`,
+	"TIMESTAMP_ISO8601": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(?::\d{2}(?:\.\d+)?)?(?:Z|[+-]\d{2}:?\d{2})?`,
+}
+
+var grokRefRe = regexp.MustCompile(`%\{(\w+)(?::([A-Za-z0-9_\-]+))?(?::(\w+))?\}`)
+
+// extractionTypes are the conversions Types and grok references may name.
+var extractionTypes = map[string]bool{"string": true, "int": true, "float": true, "duration": true}
+
+// compile validates the rule and builds its expression.
+func (e *ExtractionRule) compile() error {
+	if e.Account == "" || e.Name == "" {
+		return fmt.Errorf("account and name are required")
+	}
+	if (e.Pattern == "") == (e.Grok == "") {
+		return fmt.Errorf("exactly one of pattern and grok is required")
+	}
+	pattern := e.Pattern
+	kinds := map[string]string{}
+	var groups map[string]string
+	if e.Grok != "" {
+		var err error
+		if pattern, groups, err = expandGrok(e.Grok, kinds); err != nil {
+			return err
+		}
+	}
+	re, err := compileMsgRegex(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %v", strings.TrimPrefix(err.Error(), "Invalid msg_regex: "))
+	}
+	for name, kind := range e.Types {
+		kinds[name] = kind
+	}
+
+	e.re, e.fields = re, nil
+	seen := map[string]bool{}
+	for group, name := range re.SubexpNames() {
+		// Only %{NAME:field} references are fields of grok patterns
+		if groups != nil {
+			name = groups[name]
+		}
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return fmt.Errorf("field %s is captured twice", name)
+		}
+		seen[name] = true
+		e.fields = append(e.fields, extractedField{group: group, name: name, kind: kinds[name]})
+	}
+	if len(e.fields) == 0 {
+		return fmt.Errorf("the pattern must capture at least one named field")
+	}
+	for name, kind := range kinds {
+		if !seen[name] {
+			return fmt.Errorf("type given for %s, which the pattern does not capture", name)
+		}
+		if !extractionTypes[kind] {
+			return fmt.Errorf("type of %s must be string, int, float or duration", name)
+		}
+	}
+	return nil
+}
+
+// expandGrok rewrites %{NAME:field:type} references as RE2 groups named
+// g1, g2 and so on. It returns the expression and the field of each group
+// name, and records the types in kinds.
+func expandGrok(grok string, kinds map[string]string) (string, map[string]string, error) {
+	groups := map[string]string{}
+	var expandErr error
+	pattern := grokRefRe.ReplaceAllStringFunc(grok, func(ref string) string {
+		m := grokRefRe.FindStringSubmatch(ref)
+		sub, ok := grokPatterns[m[1]]
+		if !ok {
+			if expandErr == nil {
+				expandErr = fmt.Errorf("unknown grok pattern %s", m[1])
+			}
+			return ref
+		}
+		if m[2] == "" {
+			return "(?:" + sub + ")"
+		}
+		if m[3] != "" {
+			kinds[m[2]] = m[3]
+		}
+		group := fmt.Sprintf("g%d", len(groups)+1)
+		groups[group] = m[2]
+		return "(?P<" + group + ">" + sub + ")"
+	})
+	return pattern, groups, expandErr
+}
+
+// extract returns the fields the rule captures from msg, or nil when it
+// does not match. Values failing their type's conversion are left out.
+func (e *ExtractionRule) extract(msg string) map[string]any {
+	m := e.re.FindStringSubmatch(msg)
+	if m == nil {
+		return nil
+	}
+	fields := map[string]any{}
+	for _, f := range e.fields {
+		text := m[f.group]
+		if text == "" {
+			continue
+		}
+		switch f.kind {
+		case "int":
+			if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+				fields[f.name] = n
+			}
+		case "float":
+			if n, err := strconv.ParseFloat(text, 64); err == nil {
+				fields[f.name] = n
+			}
+		case "duration":
+			if d, err := time.ParseDuration(text); err == nil {
+				fields[f.name] = float64(d) / float64(time.Millisecond)
+			}
+		default:
+			fields[f.name] = text
+		}
+	}
+	return fields
+}
+
+// extractionRules holds the enabled extraction_rules rows in memory. It is
+// set at startup.
+var extractionRules *extractionRuleSet
+
+type extractionRuleSet struct {
+	db *sql.DB
+
+	mu        sync.RWMutex
+	byAccount map[string][]ExtractionRule
+}
+
+func newExtractionRuleSet(db *sql.DB) *extractionRuleSet {
+	return &extractionRuleSet{db: db, byAccount: map[string][]ExtractionRule{}}
+}
+
+// reload refreshes the in-memory rules from the database.
+func (s *extractionRuleSet) reload() error {
+	rows, err := s.db.Query("SELECT " + extractionRuleColumns + " FROM extraction_rules WHERE enabled = 1 ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byAccount := map[string][]ExtractionRule{}
+	for rows.Next() {
+		rule, err := scanExtractionRule(rows.Scan)
+		if err != nil {
+			return err
+		}
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("invalid extraction rule %d: %v", rule.ID, err)
+		}
+		byAccount[rule.Account] = append(byAccount[rule.Account], rule)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.byAccount = byAccount
+	s.mu.Unlock()
+	return nil
+}
+
+// apply adds the fields extracted by the rules of its account to logData, in
+// rule order. Fields sent with the entry, or extracted by an earlier rule,
+// are kept.
+func (s *extractionRuleSet) apply(logData *LogData) {
+	if s == nil {
+		return
+	}
+	s.mu.RLock()
+	rules := s.byAccount[logData.Account]
+	s.mu.RUnlock()
+	for i := range rules {
+		if rules[i].Module != "" && rules[i].Module != logData.Module {
+			continue
+		}
+		for name, value := range rules[i].extract(logData.Msg) {
+			if _, ok := logData.Fields[name]; ok {
+				continue
+			}
+			if logData.Fields == nil {
+				logData.Fields = map[string]any{}
+			}
+			logData.Fields[name] = value
+		}
+	}
+}
+
+const extractionRuleColumns = "id, account, name, module, pattern, grok, types, enabled"
+
+func scanExtractionRule(scan func(dest ...interface{}) error) (ExtractionRule, error) {
+	var rule ExtractionRule
+	var types string
+	if err := scan(&rule.ID, &rule.Account, &rule.Name, &rule.Module, &rule.Pattern, &rule.Grok, &types, &rule.Enabled); err != nil {
+		return rule, err
+	}
+	if err := json.Unmarshal([]byte(types), &rule.Types); err != nil {
+		return rule, fmt.Errorf("invalid types for extraction rule %d", rule.ID)
+	}
+	if len(rule.Types) == 0 {
+		rule.Types = nil
+	}
+	return rule, nil
+}
+
+// ExtractionTest is the body of POST /extractions/test: the pattern of a rule
+// and a msg to try it on.
+type ExtractionTest struct {
+	Pattern string            `json:"pattern,omitempty"`
+	Grok    string            `json:"grok,omitempty"`
+	Types   map[string]string `json:"types,omitempty"`
+	Msg     string            `json:"msg"`
+}
+
+// ExtractionTestResult holds the fields a rule extracted from the test msg.
+type ExtractionTestResult struct {
+	Matched bool           `json:"matched"`
+	Fields  map[string]any `json:"fields"`
+}
+
+// handleExtractionRules serves the extraction rule API: GET/POST
+// /extractions, GET/PUT/DELETE /extractions/{id} and POST /extractions/test,
+// which tries an unsaved rule on a msg.
+func handleExtractionRules(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/extractions"), "/")
+		if rest == "" || rest == "test" {
+			switch {
+			case rest == "" && r.Method == http.MethodGet:
+				listExtractionRules(db, w, r)
+			case rest == "" && r.Method == http.MethodPost:
+				saveExtractionRule(db, w, r, 0)
+			case rest == "test" && r.Method == http.MethodPost:
+				testExtractionRule(w, r)
+			default:
+				requestLogger(r).Warn("Method not allowed", "method", r.Method)
+				writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			}
+			return
+		}
+
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid extraction rule id")
+			return
+		}
+		account := r.URL.Query().Get("account")
+		if account == "" && r.Method != http.MethodPut {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			rule, err := scanExtractionRule(db.QueryRow("SELECT "+extractionRuleColumns+" FROM extraction_rules WHERE id = ? AND account = ?", id, account).Scan)
+			if err == sql.ErrNoRows {
+				writeError(w, http.StatusNotFound, codeNotFound, "Extraction rule not found")
+				return
+			} else if err != nil {
+				requestLogger(r).Error("Error loading extraction rule", "rule_id", id, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch extraction rule")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rule)
+		case http.MethodPut:
+			saveExtractionRule(db, w, r, id)
+		case http.MethodDelete:
+			res, err := db.Exec("DELETE FROM extraction_rules WHERE id = ? AND account = ?", id, account)
+			if err != nil {
+				requestLogger(r).Error("Error deleting extraction rule", "rule_id", id, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete extraction rule")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Extraction rule not found")
+				return
+			}
+			if err := extractionRules.reload(); err != nil {
+				requestLogger(r).Error("Error reloading extraction rules", "err", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Extraction rule deleted"})
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func listExtractionRules(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	account := r.URL.Query().Get("account")
+	if account == "" {
+		requestLogger(r).Warn("Missing account query parameter")
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+		return
+	}
+	rows, err := db.Query("SELECT "+extractionRuleColumns+" FROM extraction_rules WHERE account = ? ORDER BY id", account)
+	if err != nil {
+		requestLogger(r).Error("Error querying extraction rules", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch extraction rules")
+		return
+	}
+	defer rows.Close()
+
+	rules := []ExtractionRule{}
+	for rows.Next() {
+		rule, err := scanExtractionRule(rows.Scan)
+		if err != nil {
+			requestLogger(r).Error("Error scanning row", "err", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// saveExtractionRule creates a rule (id 0) or replaces an existing one. Rules
+// apply to entries stored after they are saved.
+func saveExtractionRule(db *sql.DB, w http.ResponseWriter, r *http.Request, id int64) {
+	rule := ExtractionRule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		requestLogger(r).Warn("Invalid request body", "err", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if err := rule.compile(); err != nil {
+		requestLogger(r).Warn("Validation failed", "err", err)
+		writeErrorDetails(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err), nil)
+		return
+	}
+	if !accountAllowed(r, rule.Account) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+		return
+	}
+	types, _ := json.Marshal(rule.Types)
+	if rule.Types == nil {
+		types = []byte("{}")
+	}
+
+	status := http.StatusOK
+	if id == 0 {
+		res, err := db.Exec(`INSERT INTO extraction_rules (account, name, module, pattern, grok, types, enabled)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			rule.Account, rule.Name, rule.Module, rule.Pattern, rule.Grok, string(types), rule.Enabled)
+		if err != nil {
+			requestLogger(r).Error("Error saving extraction rule", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save extraction rule")
+			return
+		}
+		rule.ID, _ = res.LastInsertId()
+		status = http.StatusCreated
+	} else {
+		res, err := db.Exec(`UPDATE extraction_rules SET name = ?, module = ?, pattern = ?, grok = ?, types = ?, enabled = ?
+			WHERE id = ? AND account = ?`,
+			rule.Name, rule.Module, rule.Pattern, rule.Grok, string(types), rule.Enabled, id, rule.Account)
+		if err != nil {
+			requestLogger(r).Error("Error updating extraction rule", "rule_id", id, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save extraction rule")
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, http.StatusNotFound, codeNotFound, "Extraction rule not found")
+			return
+		}
+		rule.ID = id
+	}
+	if err := extractionRules.reload(); err != nil {
+		requestLogger(r).Error("Error reloading extraction rules", "err", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func testExtractionRule(w http.ResponseWriter, r *http.Request) {
+	var test ExtractionTest
+	if err := json.NewDecoder(r.Body).Decode(&test); err != nil {
+		requestLogger(r).Warn("Invalid request body", "err", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	// The rule is not stored, so it needs no account or name
+	rule := ExtractionRule{Account: "test", Name: "test", Pattern: test.Pattern, Grok: test.Grok, Types: test.Types}
+	if err := rule.compile(); err != nil {
+		writeErrorDetails(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err), nil)
+		return
+	}
+	fields := rule.extract(test.Msg)
+	result := ExtractionTestResult{Matched: fields != nil, Fields: fields}
+	if fields == nil {
+		result.Fields = map[string]any{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
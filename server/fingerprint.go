@@ -0,0 +1,189 @@
+package server
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// fingerprintMinLevel is the lowest level whose entries are grouped in
+// fingerprints. Entries below it still get a fingerprint.
+const fingerprintMinLevel = LevelWarn
+
+const fingerprintsSchema = `CREATE TABLE IF NOT EXISTS fingerprints (
+    account TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    module TEXT NOT NULL,
+    level INTEGER NOT NULL,
+    sample_msg TEXT NOT NULL,
+    first_seen DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    count INTEGER NOT NULL,
+    PRIMARY KEY (account, fingerprint)
+)`
+
+// Fingerprint is a group of entries whose messages differ only in numbers
+// and identifiers. Level is the highest level seen, Message the latest
+// message and Count the entries, repeats included.
+type Fingerprint struct {
+	Fingerprint string    `json:"fingerprint"`
+	Module      string    `json:"module"`
+	Level       int       `json:"level"`
+	Message     string    `json:"message"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	Count       int64     `json:"count"`
+}
+
+// fingerprintPlaceholders replace the variable parts of messages, in order.
+var fingerprintPlaceholders = []struct {
+	re          *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`\b[0-9A-HJKMNP-TV-Z]{26}\b`), "<ulid>"},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b|\b[0-9a-f]*\d[0-9a-f]*[a-f][0-9a-f]*\b`), "<hex>"},
+	{regexp.MustCompile(`\d+(?:\.\d+)*`), "<n>"},
+}
+
+// normalizeMessage strips numbers and identifiers from a message and
+// collapses its whitespace.
+func normalizeMessage(msg string) string {
+	for _, p := range fingerprintPlaceholders {
+		msg = p.re.ReplaceAllString(msg, p.placeholder)
+	}
+	return strings.Join(strings.Fields(msg), " ")
+}
+
+// fingerprint hashes an entry's module, normalized message and normalized
+// stack trace.
+func fingerprint(logData LogData) string {
+	sum := sha256.Sum256([]byte(logData.Module + "\x00" + normalizeMessage(logData.Msg) + "\x00" + normalizeMessage(logData.StackTrace)))
+	return hex.EncodeToString(sum[:8])
+}
+
+const recordFingerprintSQL = `INSERT INTO fingerprints (account, fingerprint, module, level, sample_msg, first_seen, last_seen, count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (account, fingerprint) DO UPDATE SET
+			level = MAX(level, excluded.level),
+			sample_msg = CASE WHEN excluded.last_seen >= last_seen THEN excluded.sample_msg ELSE sample_msg END,
+			first_seen = MIN(first_seen, excluded.first_seen),
+			last_seen = MAX(last_seen, excluded.last_seen),
+			count = count + excluded.count`
+
+// recordFingerprint counts logData in its fingerprint group when its level
+// is grouped. The sample message is encrypted like the entry's.
+func recordFingerprint(ex execer, logData LogData) error {
+	if logData.Level < fingerprintMinLevel {
+		return nil
+	}
+	if logData.Fingerprint == "" {
+		logData.Fingerprint = fingerprint(logData)
+	}
+	msg, _, _, err := encryption.seal(logData.Account, logData.Msg, "", sql.NullString{})
+	if err != nil {
+		return err
+	}
+	ts := logData.Timestamp.UTC()
+	_, err = writeStatements.exec(ex, recordFingerprintSQL,
+		logData.Account, logData.Fingerprint, logData.Module, logData.Level, msg, ts, ts, max(logData.RepeatCount, 1))
+	return err
+}
+
+// handleGetFingerprints serves GET /fingerprints, an account's fingerprint
+// groups at or above min_level (default error), most recently seen first.
+// new_since lists only groups first seen within that duration, e.g. 24h.
+func handleGetFingerprints(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		minLevel := LevelError
+		if text := query.Get("min_level"); text != "" {
+			level, ok := levelSchemes.parse(account, text)
+			if !ok {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid min_level: %s", text))
+				return
+			}
+			minLevel = level
+		}
+		sqlQuery := `SELECT fingerprint, module, level, sample_msg, first_seen, last_seen, count FROM fingerprints
+			WHERE account = ? AND level >= ?`
+		args := []interface{}{account, minLevel}
+		if module := query.Get("module"); module != "" {
+			sqlQuery += " AND module = ?"
+			args = append(args, module)
+		}
+		if text := query.Get("new_since"); text != "" {
+			d, err := time.ParseDuration(text)
+			if err != nil || d <= 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid new_since: must be a duration such as 24h")
+				return
+			}
+			sqlQuery += " AND first_seen >= ?"
+			args = append(args, timeNow().UTC().Add(-d))
+		}
+
+		var limit, offset int64 = 100, 0
+		if query.Get("limit") != "" {
+			fmt.Sscanf(query.Get("limit"), "%d", &limit)
+		}
+		if query.Get("offset") != "" {
+			fmt.Sscanf(query.Get("offset"), "%d", &offset)
+		}
+		sqlQuery += fmt.Sprintf(" ORDER BY last_seen DESC LIMIT %d OFFSET %d", limit, offset)
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		rows, err := db.QueryContext(ctx, sqlQuery, args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying fingerprints", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch fingerprints")
+			return
+		}
+		defer rows.Close()
+
+		groups := []Fingerprint{}
+		for rows.Next() {
+			var group Fingerprint
+			if err := rows.Scan(&group.Fingerprint, &group.Module, &group.Level, &group.Message,
+				&group.FirstSeen, &group.LastSeen, &group.Count); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			if group.Message, err = encryption.open(account, group.Message); err != nil {
+				requestLogger(r).Error("Error decrypting fingerprint message", "err", err)
+			}
+			groups = append(groups, group)
+		}
+		if err := rows.Err(); err != nil {
+			if !queryAborted(w, r, cfg, err) {
+				requestLogger(r).Error("Error reading fingerprints", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch fingerprints")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+	}
+}
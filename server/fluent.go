@@ -0,0 +1,390 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// eventTime is the Fluentd EventTime extension (type 0): seconds and
+// nanoseconds as big-endian uint32s.
+type eventTime struct {
+	time.Time
+}
+
+func (t *eventTime) MarshalMsgpack() ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	return b, nil
+}
+
+func (t *eventTime) UnmarshalMsgpack(b []byte) error {
+	if len(b) != 8 {
+		return fmt.Errorf("invalid EventTime length %d", len(b))
+	}
+	t.Time = time.Unix(int64(binary.BigEndian.Uint32(b)), int64(binary.BigEndian.Uint32(b[4:]))).UTC()
+	return nil
+}
+
+func init() {
+	msgpack.RegisterExt(0, (*eventTime)(nil))
+}
+
+// serve accepts Fluentd forward protocol connections (Message, Forward and
+// PackedForward modes, optionally gzip compressed) until f is closed or the
+// listener fails.
+func (f *fluentListener) serve(db *sql.DB, cfg *Config) error {
+	slog.Info("Starting Fluentd forward listener", "port", cfg.FluentForwardPort)
+	for {
+		conn, err := f.lis.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !f.track(conn) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer f.untrack(conn)
+			handleFluentConn(db, cfg, conn)
+		}()
+	}
+}
+
+// fluentListener is the Fluentd forward listener and its open connections,
+// so that shutdown can stop them.
+type fluentListener struct {
+	lis     net.Listener
+	handled sync.WaitGroup
+
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+// listenFluentForward listens on cfg.FluentForwardPort.
+func listenFluentForward(cfg *Config) (*fluentListener, error) {
+	lis, err := net.Listen("tcp", ":"+cfg.FluentForwardPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on forward port %s: %v", cfg.FluentForwardPort, err)
+	}
+	return &fluentListener{lis: lis, conns: map[net.Conn]bool{}}, nil
+}
+
+// track records a new connection, reporting false when f is closed.
+func (f *fluentListener) track(conn net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false
+	}
+	f.conns[conn] = true
+	f.handled.Add(1)
+	return true
+}
+
+func (f *fluentListener) untrack(conn net.Conn) {
+	f.mu.Lock()
+	delete(f.conns, conn)
+	f.mu.Unlock()
+	f.handled.Done()
+}
+
+// close stops accepting connections and ends the open ones once the
+// message they are reading, if any, is stored and acked. Connections still
+// open when ctx is done are closed.
+func (f *fluentListener) close(ctx context.Context) {
+	f.mu.Lock()
+	f.closed = true
+	f.lis.Close()
+	for conn := range f.conns {
+		// Fails the next read of an idle connection
+		conn.SetReadDeadline(time.Now())
+	}
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		f.handled.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("Fluentd forward connections still open at shutdown", "err", ctx.Err())
+		f.mu.Lock()
+		for conn := range f.conns {
+			conn.Close()
+		}
+		f.mu.Unlock()
+		<-done
+	}
+}
+
+func handleFluentConn(db *sql.DB, cfg *Config, conn net.Conn) {
+	defer conn.Close()
+	logger := slog.Default().With("remote_addr", conn.RemoteAddr().String())
+	dec := msgpack.NewDecoder(bufio.NewReader(conn))
+	enc := msgpack.NewEncoder(conn)
+	for {
+		msg, err := dec.DecodeInterface()
+		if errors.Is(err, io.EOF) || errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+		if err != nil {
+			logger.Warn("Invalid forward message", "err", err)
+			return
+		}
+		arr, ok := msg.([]interface{})
+		if !ok || len(arr) < 2 {
+			logger.Warn("Invalid forward message", "err", "expected [tag, ...]")
+			return
+		}
+		tag := fluentString(arr[0])
+		entries, option, err := fluentEntries(arr, cfg.Live().MaxBodyBytes)
+		if err != nil {
+			logger.Warn("Invalid forward message", "tag", tag, "err", err)
+			return
+		}
+
+		stored := 0
+		var retry bool
+		if refusal := admission.refusal(); refusal != nil {
+			logger.Warn("Refused forward entries", "tag", tag, "reason", refusal.message)
+			// Leaves the chunk unacknowledged for Fluent Bit to resend
+			entries, retry = nil, true
+		}
+		for _, entry := range entries {
+			logData := fluentEntry(cfg, tag, entry.ts, entry.record)
+			payload, _ := json.Marshal(logData)
+			logData.splitStackTrace()
+			if err := logData.Validate(); err != nil {
+				rejectLog(db, cfg, logData.Account, payload, fmt.Sprintf("Validation failed: %v", err))
+				continue
+			}
+			if errs := logData.checkLimits(cfg); len(errs) > 0 {
+				rejectLog(db, cfg, logData.Account, payload, "Payload limits exceeded")
+				continue
+			}
+			if err := logData.checkClock(cfg); err != nil {
+				rejectLog(db, cfg, logData.Account, payload, fmt.Sprintf("Validation failed: %v", err))
+				continue
+			}
+			sources.enrich(&logData, parseAddr(conn.RemoteAddr().String()))
+			var quotaErr *quotaError
+			if err := insertLogData(db, cfg, &logData); errors.As(err, &quotaErr) {
+				logger.Warn("Rejected forward entries", "account", logData.Account, "err", err)
+				retry = true
+				break
+			} else if err != nil {
+				logger.Error("Error saving log data", "err", err)
+				retry = true
+				break
+			}
+			stored++
+		}
+		logger.Info("Stored forward entries", "tag", tag, "stored", stored, "entries", len(entries))
+
+		// Without an ack Fluent Bit resends the chunk, so only ack stored chunks
+		if chunk, ok := option["chunk"]; ok && !retry {
+			if err := enc.Encode(map[string]interface{}{"ack": chunk}); err != nil {
+				return
+			}
+		}
+		if retry {
+			return
+		}
+	}
+}
+
+type fluentRecord struct {
+	ts     time.Time
+	record map[string]interface{}
+}
+
+// fluentEntries returns the entries and options of a decoded forward message.
+// Compressed PackedForward chunks may hold up to limit bytes uncompressed.
+func fluentEntries(arr []interface{}, limit int64) ([]fluentRecord, map[string]interface{}, error) {
+	option := map[string]interface{}{}
+	switch v := arr[1].(type) {
+	case []interface{}:
+		// Forward mode: [tag, [[time, record], ...], option?]
+		if len(arr) > 2 {
+			option, _ = arr[2].(map[string]interface{})
+		}
+		entries, err := fluentEntryList(v)
+		return entries, option, err
+	case string, []byte:
+		// PackedForward mode: [tag, msgpack stream of [time, record], option?]
+		if len(arr) > 2 {
+			option, _ = arr[2].(map[string]interface{})
+		}
+		var r io.Reader = bytes.NewReader([]byte(fluentString(v)))
+		if option["compressed"] == "gzip" {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, option, err
+			}
+			defer gz.Close()
+			data, err := io.ReadAll(io.LimitReader(gz, limit+1))
+			if err != nil {
+				return nil, option, err
+			}
+			if int64(len(data)) > limit {
+				return nil, option, fmt.Errorf("chunk exceeds %d bytes uncompressed", limit)
+			}
+			r = bytes.NewReader(data)
+		}
+		dec := msgpack.NewDecoder(r)
+		var list []interface{}
+		for {
+			entry, err := dec.DecodeInterface()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, option, err
+			}
+			list = append(list, entry)
+		}
+		entries, err := fluentEntryList(list)
+		return entries, option, err
+	default:
+		// Message mode: [tag, time, record, option?]
+		if len(arr) < 3 {
+			return nil, option, fmt.Errorf("message mode requires time and record")
+		}
+		if len(arr) > 3 {
+			option, _ = arr[3].(map[string]interface{})
+		}
+		entries, err := fluentEntryList([]interface{}{[]interface{}{arr[1], arr[2]}})
+		return entries, option, err
+	}
+}
+
+func fluentEntryList(list []interface{}) ([]fluentRecord, error) {
+	entries := make([]fluentRecord, 0, len(list))
+	for _, item := range list {
+		pair, ok := item.([]interface{})
+		if !ok || len(pair) < 2 {
+			return nil, fmt.Errorf("entry must be [time, record]")
+		}
+		record, ok := pair[1].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record must be a map")
+		}
+		entries = append(entries, fluentRecord{ts: fluentTime(pair[0]), record: record})
+	}
+	return entries, nil
+}
+
+// fluentTime converts an EventTime or integer seconds, defaulting to now.
+func fluentTime(v interface{}) time.Time {
+	switch t := v.(type) {
+	case *eventTime:
+		return t.Time
+	case eventTime:
+		return t.Time
+	case float64:
+		return time.Unix(0, int64(t*float64(time.Second))).UTC()
+	}
+	// Integers decode to the smallest fitting Go type
+	if rv := reflect.ValueOf(v); rv.CanInt() {
+		return time.Unix(rv.Int(), 0).UTC()
+	} else if rv.CanUint() {
+		return time.Unix(int64(rv.Uint()), 0).UTC()
+	}
+	return timeNow().UTC()
+}
+
+// fluentString returns msgpack str and bin values as a string.
+func fluentString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return fmt.Sprint(v)
+}
+
+// fluentModule maps a tag to a module through cfg.FluentTagModules, matching
+// exact tags first and then glob patterns; unmapped tags are used as is.
+func fluentModule(cfg *Config, tag string) string {
+	if module, ok := cfg.FluentTagModules[tag]; ok {
+		return module
+	}
+	patterns := make([]string, 0, len(cfg.FluentTagModules))
+	for pattern := range cfg.FluentTagModules {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return cfg.FluentTagModules[pattern]
+		}
+	}
+	return tag
+}
+
+// fluentEntry maps a forward record onto LogData. Keys not mapped to a
+// column are kept in Fields.
+func fluentEntry(cfg *Config, tag string, ts time.Time, record map[string]interface{}) LogData {
+	fields := map[string]any{}
+	for k, v := range record {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		fields[k] = v
+	}
+	take := func(def string, keys ...string) string {
+		for _, key := range keys {
+			if v, ok := fields[key]; ok && v != "" {
+				delete(fields, key)
+				return fluentString(v)
+			}
+		}
+		return def
+	}
+
+	logData := LogData{
+		System:    take(cfg.FluentSystem, "system"),
+		Module:    fluentModule(cfg, tag),
+		User:      take("fluent", "user"),
+		Task:      take("fluent", "task"),
+		Timestamp: ts,
+		Msg:       take("", "log", "message", "msg"),
+	}
+	level := take("", "level", "severity")
+	logData.Level, logData.levelName = parseLevel(level, LevelInfo), levelText(level)
+	// A configured account wins over the record's
+	logData.Account = take(cfg.FluentAccount, "account")
+	if cfg.FluentAccount != "" {
+		logData.Account = cfg.FluentAccount
+	}
+	if len(fields) > 0 {
+		logData.Fields = fields
+	}
+	return logData
+}
@@ -0,0 +1,64 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"log-server/server/testutil"
+)
+
+func TestFluentGzipChunkLimited(t *testing.T) {
+	port := freePort(t)
+	srv := testutil.NewServer(t, map[string]string{
+		"FLUENT_FORWARD_PORT": port,
+		"FLUENT_ACCOUNT":      "acme",
+		"MAX_BODY_BYTES":      "65536",
+	})
+	srv.Start()
+	var conn net.Conn
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var err error
+		if conn, err = net.Dial("tcp", "127.0.0.1:"+port); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Fluentd forward listener not started: %v", err)
+		}
+	}
+	defer conn.Close()
+
+	// send writes a gzip PackedForward chunk of n entries and returns its ack
+	send := func(chunk string, n int) (map[string]any, error) {
+		var packed bytes.Buffer
+		gz := gzip.NewWriter(&packed)
+		enc := msgpack.NewEncoder(gz)
+		for i := 0; i < n; i++ {
+			enc.Encode([]any{srv.Clock.Now().Unix(), map[string]any{"msg": strings.Repeat("x", 1000)}})
+		}
+		gz.Close()
+		msg, _ := msgpack.Marshal([]any{"app", packed.Bytes(), map[string]any{"compressed": "gzip", "chunk": chunk}})
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var ack map[string]any
+		err := msgpack.NewDecoder(conn).Decode(&ack)
+		return ack, err
+	}
+
+	if ack, err := send("small", 10); err != nil || ack["ack"] != "small" {
+		t.Fatalf("chunk of 10 entries: ack %v, %v", ack, err)
+	}
+	// About 100 KB uncompressed: the chunk is refused unacked
+	if ack, err := send("large", 100); err == nil {
+		t.Fatalf("chunk of 100 entries over MAX_BODY_BYTES acked: %v", ack)
+	}
+	if entries := srv.Entries("acme", nil); len(entries) != 10 {
+		t.Fatalf("got %d entries, want the 10 of the first chunk", len(entries))
+	}
+}
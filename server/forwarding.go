@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Forwarding runs an edge instance: entries are accepted and stored locally
+// as usual, and a forwarder pushes them in id order to the central server's
+// POST /replication/entries. The local database is the spool, so entries
+// survive outages of the uplink and restarts, and the central server skips
+// the ULIDs it already stored, so retried batches are not duplicated.
+
+const forwardStateSchema = `CREATE TABLE IF NOT EXISTS forward_state (
+    target_url TEXT PRIMARY KEY,
+    last_id INTEGER NOT NULL,
+    updated_at DATETIME NOT NULL
+)`
+
+// forwarding is the running forwarder, nil unless FORWARD_TO is set. It is
+// set by runJobs.
+var forwarding *forwarder
+
+// forwarder pushes new entries to cfg.ForwardTo.
+type forwarder struct {
+	db     *sql.DB
+	cfg    *Config
+	client *http.Client
+	// lastID is the id of the last entry the central server accepted.
+	lastID atomic.Int64
+}
+
+func newForwarder(db *sql.DB, cfg *Config) (*forwarder, error) {
+	if _, err := db.Exec(forwardStateSchema); err != nil {
+		return nil, fmt.Errorf("failed to create forward_state table: %v", err)
+	}
+	fwd := &forwarder{db: db, cfg: cfg, client: &http.Client{Timeout: time.Minute}}
+	var lastID int64
+	err := db.QueryRow("SELECT last_id FROM forward_state WHERE target_url = ?", cfg.ForwardTo).Scan(&lastID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to load forward state: %v", err)
+	}
+	fwd.lastID.Store(lastID)
+	return fwd, nil
+}
+
+// run pushes batches until caught up, then waits interval before checking
+// again. Failed pushes are retried with backoff from interval up to
+// ForwardMaxRetryInterval.
+func (fwd *forwarder) run(stop <-chan struct{}, interval time.Duration) {
+	slog.Info("Forwarding to central server", "target", fwd.cfg.ForwardTo, "after_id", fwd.lastID.Load())
+	backoff := interval
+	failing := false
+	for {
+		n, err := fwd.push()
+		switch {
+		case err != nil:
+			backoff = min(backoff*2, fwd.cfg.ForwardMaxRetryInterval)
+			slog.Warn("Failed to forward entries", "target", fwd.cfg.ForwardTo, "retry_in", backoff, "err", err)
+			failing = true
+			if !sleep(stop, backoff) {
+				return
+			}
+			continue
+		case failing:
+			slog.Info("Forwarding resumed", "target", fwd.cfg.ForwardTo)
+			failing = false
+		}
+		backoff = interval
+		if n < fwd.cfg.ForwardBatchSize && !sleep(stop, interval) {
+			return
+		}
+	}
+}
+
+// push sends the next batch, gzip compressed for slow links, and advances
+// the cursor once the central server stored it. It returns the number of
+// entries sent.
+func (fwd *forwarder) push() (int, error) {
+	rows, err := fwd.db.Query("SELECT "+logDataColumns+" FROM logData WHERE id > ? ORDER BY id LIMIT ?", fwd.lastID.Load(), fwd.cfg.ForwardBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	entries := []LogData{}
+	for rows.Next() {
+		logData, err := scanLogData(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, logData)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(entries); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(fwd.cfg.ForwardTo, "/")+"/replication/entries", &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	if fwd.cfg.ForwardToken != "" {
+		req.Header.Set("Authorization", "Bearer "+fwd.cfg.ForwardToken)
+	}
+	resp, err := fwd.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e APIError
+		json.NewDecoder(resp.Body).Decode(&e)
+		return 0, fmt.Errorf("central server returned %s: %s", resp.Status, e.Message)
+	}
+
+	lastID := *entries[len(entries)-1].ID
+	if _, err := fwd.db.Exec(`INSERT INTO forward_state (target_url, last_id, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(target_url) DO UPDATE SET last_id = excluded.last_id, updated_at = excluded.updated_at`,
+		fwd.cfg.ForwardTo, lastID, timeNow().UTC()); err != nil {
+		return 0, err
+	}
+	fwd.lastID.Store(lastID)
+	return len(entries), nil
+}
+
+// holdBack moves a retention cutoff before the oldest entry not forwarded
+// yet, so an outage longer than RETENTION_PERIOD does not lose entries. A nil
+// forwarder keeps cutoff.
+func (fwd *forwarder) holdBack(cutoff time.Time) time.Time {
+	if fwd == nil {
+		return cutoff
+	}
+	var oldest sql.NullString
+	if err := fwd.db.QueryRow("SELECT MIN(timestamp) FROM logData WHERE id > ?", fwd.lastID.Load()).Scan(&oldest); err != nil {
+		slog.Error("Error finding entries to forward", "err", err)
+		return time.Time{}
+	}
+	if oldest.Valid {
+		if t := storedTime(oldest.String); t.Before(cutoff) {
+			slog.Warn("Keeping expired entries until they are forwarded", "oldest", t)
+			return t
+		}
+	}
+	return cutoff
+}
@@ -0,0 +1,332 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// gelfChunkTimeout is how long the chunks of a message are kept waiting
+	// for the others, as the GELF specification sets it.
+	gelfChunkTimeout = 5 * time.Second
+	// gelfMaxChunks is the most chunks a GELF message may be split into.
+	gelfMaxChunks = 128
+)
+
+// gelfChunks collects the chunks of a chunked GELF message.
+type gelfChunks struct {
+	parts    [][]byte
+	received int
+	first    time.Time
+}
+
+// gelfListener is the GELF UDP listener. done is closed once serve returns.
+type gelfListener struct {
+	conn net.PacketConn
+	done chan struct{}
+}
+
+// listenGELF listens on UDP port cfg.GELFUDPPort.
+func listenGELF(cfg *Config) (*gelfListener, error) {
+	conn, err := net.ListenPacket("udp", ":"+cfg.GELFUDPPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on GELF port %s: %v", cfg.GELFUDPPort, err)
+	}
+	return &gelfListener{conn: conn, done: make(chan struct{})}, nil
+}
+
+// close stops g once the message being stored, if any, is, or when ctx is
+// done.
+func (g *gelfListener) close(ctx context.Context) {
+	g.conn.Close()
+	select {
+	case <-g.done:
+	case <-ctx.Done():
+		slog.Warn("GELF message still being stored at shutdown", "err", ctx.Err())
+	}
+}
+
+// serve accepts GELF messages, optionally chunked and gzip or zlib
+// compressed, until g is closed or the listener fails.
+func (g *gelfListener) serve(db *sql.DB, cfg *Config) error {
+	defer close(g.done)
+	slog.Info("Starting GELF UDP listener", "port", cfg.GELFUDPPort)
+	pending := map[string]*gelfChunks{}
+	lastExpiry := time.Now()
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := g.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		logger := slog.Default().With("remote_addr", addr.String())
+		packet := buf[:n]
+
+		if time.Since(lastExpiry) > time.Second {
+			for id, chunks := range pending {
+				if time.Since(chunks.first) > gelfChunkTimeout {
+					logger.Warn("Dropping incomplete GELF message", "received", chunks.received, "chunks", len(chunks.parts))
+					delete(pending, id)
+				}
+			}
+			lastExpiry = time.Now()
+		}
+
+		// Chunks start with 0x1e 0x0f, an 8 byte message id, the sequence
+		// number and the sequence count
+		if len(packet) >= 2 && packet[0] == 0x1e && packet[1] == 0x0f {
+			if len(packet) < 12 {
+				logger.Warn("Invalid GELF chunk", "err", "short header")
+				continue
+			}
+			id, seq, count := string(packet[2:10]), int(packet[10]), int(packet[11])
+			if count == 0 || count > gelfMaxChunks || seq >= count {
+				logger.Warn("Invalid GELF chunk", "seq", seq, "count", count)
+				continue
+			}
+			chunks := pending[id]
+			if chunks == nil {
+				chunks = &gelfChunks{parts: make([][]byte, count), first: time.Now()}
+				pending[id] = chunks
+			}
+			if len(chunks.parts) != count || chunks.parts[seq] != nil {
+				continue
+			}
+			chunks.parts[seq] = bytes.Clone(packet[12:])
+			if chunks.received++; chunks.received < count {
+				continue
+			}
+			delete(pending, id)
+			packet = bytes.Join(chunks.parts, nil)
+		}
+
+		data, err := gelfDecompress(packet, cfg.Live().MaxBodyBytes)
+		if err != nil {
+			logger.Warn("Invalid GELF message", "err", err)
+			continue
+		}
+		logData, err := gelfEntry(data)
+		if err != nil {
+			logger.Warn("Invalid GELF message", "err", err)
+			continue
+		}
+		// A configured account wins over the message's
+		if cfg.GELFAccount != "" {
+			logData.Account = cfg.GELFAccount
+		}
+		sources.enrich(&logData, parseAddr(addr.String()))
+		if reason, err := storeGELF(db, cfg, &logData); err != nil {
+			logger.Error("Error saving GELF message", "account", logData.Account, "err", err)
+		} else if reason != "" {
+			logger.Warn("Rejected GELF message", "account", logData.Account, "reason", reason)
+		}
+	}
+}
+
+// handleGELF serves POST /gelf, the Graylog GELF HTTP input: one message per
+// request, answered with 202. The account is X-Account, or else the
+// message's _account or GELF_ACCOUNT.
+func handleGELF(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if isBodyTooLarge(err) {
+			requestLogger(r).Warn("Request body too large", "err", err)
+			writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+				fmt.Sprintf("Request body exceeds %d bytes", cfg.Live().MaxBodyBytes), nil)
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error reading request body", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to read request body")
+			return
+		}
+		data, err := gelfDecompress(body, cfg.Live().MaxBodyBytes)
+		var logData LogData
+		if err == nil {
+			logData, err = gelfEntry(data)
+		}
+		if err != nil {
+			requestLogger(r).Warn("Invalid GELF message", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid GELF message: %v", err))
+			return
+		}
+		if account := requestAccount(r); account != "" {
+			logData.Account = account
+		} else if logData.Account == "" {
+			logData.Account = cfg.GELFAccount
+		}
+		if !accountAllowed(r, logData.Account) {
+			requestLogger(r).Warn("Access denied", "account", logData.Account)
+			writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+			return
+		}
+
+		sources.enrich(&logData, clientAddr(r, cfg))
+		reason, err := storeGELF(db, cfg, &logData)
+		var quotaErr *quotaError
+		if errors.As(err, &quotaErr) {
+			requestLogger(r).Warn("Rejected GELF message", "account", logData.Account, "err", err)
+			writeError(w, quotaErr.status, codeQuotaExceeded, err.Error())
+			return
+		} else if err != nil {
+			requestLogger(r).Error("Error saving log data", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save log data")
+			return
+		}
+		if reason != "" {
+			requestLogger(r).Warn("Rejected GELF message", "account", logData.Account, "reason", reason)
+			writeError(w, http.StatusBadRequest, codeValidationFailed, reason)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// storeGELF stores logData, or moves it to the dead-letter table and returns
+// why when it is invalid. It returns a *refusalError while writes are
+// refused.
+func storeGELF(db *sql.DB, cfg *Config, logData *LogData) (string, error) {
+	payload, _ := json.Marshal(logData)
+	logData.splitStackTrace()
+	reason := ""
+	if err := logData.Validate(); err != nil {
+		reason = fmt.Sprintf("Validation failed: %v", err)
+	} else if errs := logData.checkLimits(cfg); len(errs) > 0 {
+		reason = "Payload limits exceeded"
+	} else if err := logData.checkClock(cfg); err != nil {
+		reason = fmt.Sprintf("Validation failed: %v", err)
+	}
+	if reason != "" {
+		rejectLog(db, cfg, logData.Account, payload, reason)
+		return reason, nil
+	}
+	if refusal := admission.refusal(); refusal != nil {
+		return "", refusal
+	}
+	return "", insertLogData(db, cfg, logData)
+}
+
+// gelfDecompress returns a GELF payload uncompressed, detecting gzip and zlib
+// by their magic bytes, and fails if it exceeds limit bytes.
+func gelfDecompress(b []byte, limit int64) ([]byte, error) {
+	var r io.Reader
+	switch {
+	case len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b:
+		gz, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	case len(b) >= 2 && b[0] == 0x78 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0:
+		zr, err := zlib.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return b, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("message exceeds %d bytes uncompressed", limit)
+	}
+	return data, nil
+}
+
+// gelfEntry maps a GELF message onto LogData: host is the system,
+// short_message the msg, full_message the stack trace and the syslog level
+// the level. Additional fields, stripped of their underscore, are kept in
+// Fields, except _account, _user, _module, _task, _trace_id and _span_id,
+// which fill the columns. The module defaults to the facility, or the
+// container name the Docker gelf driver sends.
+func gelfEntry(data []byte) (LogData, error) {
+	var msg map[string]any
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return LogData{}, err
+	}
+	fields := map[string]any{}
+	for k, v := range msg {
+		if name, ok := strings.CutPrefix(k, "_"); ok && name != "id" {
+			fields[name] = v
+		}
+	}
+	str := func(v any) string {
+		if s, ok := v.(string); ok {
+			return s
+		}
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+	take := func(def string, keys ...string) string {
+		for _, key := range keys {
+			if v := str(fields[key]); v != "" {
+				delete(fields, key)
+				return v
+			}
+		}
+		return def
+	}
+
+	logData := LogData{
+		Account:    take("", "account"),
+		System:     str(msg["host"]),
+		User:       take("gelf", "user"),
+		Task:       take("gelf", "task"),
+		Msg:        str(msg["short_message"]),
+		StackTrace: str(msg["full_message"]),
+		TraceID:    take("", "trace_id"),
+		SpanID:     take("", "span_id"),
+		Level:      LevelInfo,
+	}
+	logData.Module = take(str(msg["facility"]), "module")
+	if logData.Module == "" {
+		logData.Module = cmp.Or(str(fields["container_name"]), "gelf")
+	}
+	ts, ok := msg["timestamp"].(float64)
+	if ok && ts > 0 {
+		sec, frac := math.Modf(ts)
+		logData.Timestamp = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+	} else {
+		logData.Timestamp = timeNow().UTC()
+	}
+	if level, ok := msg["level"].(float64); ok {
+		for _, l := range levelPresets["syslog"] {
+			if l.Value == int(level) {
+				logData.Level, logData.levelName = l.Canonical, l.Name
+			}
+		}
+	}
+	if len(fields) > 0 {
+		logData.Fields = fields
+	}
+	return logData, nil
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// Source enrichment records where entries came from in their fields: the
+// address of the ingesting client as source_ip with RECORD_SOURCE_IP, and
+// with GEOIP_DATABASE and GEOIP_ASN_DATABASE its geo_country, geo_region,
+// geo_city, asn and as_org, looked up in local MaxMind (GeoIP2/GeoLite2
+// City or Country, and ASN) databases. The values replace any the client
+// sent, so they can be trusted in filters such as field.geo_country=.
+
+// sources is the running enricher, nil unless configured. It is set by load.
+var sources *sourceEnricher
+
+type sourceEnricher struct {
+	recordIP bool
+	// city and asn are nil without their database.
+	city, asn *mmdb
+}
+
+func newSourceEnricher(cfg *Config) (*sourceEnricher, error) {
+	if !cfg.RecordSourceIP && cfg.GeoIPDatabase == "" && cfg.GeoIPASNDatabase == "" {
+		return nil, nil
+	}
+	s := &sourceEnricher{recordIP: cfg.RecordSourceIP}
+	var err error
+	if cfg.GeoIPDatabase != "" {
+		if s.city, err = openMMDB(cfg.GeoIPDatabase); err != nil {
+			return nil, fmt.Errorf("failed to open GEOIP_DATABASE: %v", err)
+		}
+		slog.Info("Loaded GeoIP database", "path", cfg.GeoIPDatabase, "type", s.city.databaseType)
+	}
+	if cfg.GeoIPASNDatabase != "" {
+		if s.asn, err = openMMDB(cfg.GeoIPASNDatabase); err != nil {
+			return nil, fmt.Errorf("failed to open GEOIP_ASN_DATABASE: %v", err)
+		}
+		slog.Info("Loaded GeoIP ASN database", "path", cfg.GeoIPASNDatabase, "type", s.asn.databaseType)
+	}
+	return s, nil
+}
+
+// enrich adds the fields of the source addr to logData. Unknown addresses,
+// such as those of entries read from Kafka or NATS, add nothing.
+func (s *sourceEnricher) enrich(logData *LogData, addr netip.Addr) {
+	if s == nil || !addr.IsValid() {
+		return
+	}
+	addr = addr.Unmap()
+	fields := map[string]any{}
+	if s.recordIP {
+		fields["source_ip"] = addr.String()
+	}
+	if record, ok := s.city.lookup(addr); ok {
+		if v, ok := mmdbPath(record, "country", "iso_code").(string); ok {
+			fields["geo_country"] = v
+		}
+		if subdivisions, ok := record["subdivisions"].([]any); ok && len(subdivisions) > 0 {
+			if v, ok := mmdbPath(subdivisions[0], "iso_code").(string); ok {
+				fields["geo_region"] = v
+			}
+		}
+		if v, ok := mmdbPath(record, "city", "names", "en").(string); ok {
+			fields["geo_city"] = v
+		}
+	}
+	if record, ok := s.asn.lookup(addr); ok {
+		if v, ok := record["autonomous_system_number"].(uint64); ok {
+			fields["asn"] = v
+		}
+		if v, ok := record["autonomous_system_organization"].(string); ok {
+			fields["as_org"] = v
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	if logData.Fields == nil {
+		logData.Fields = map[string]any{}
+	}
+	for k, v := range fields {
+		logData.Fields[k] = v
+	}
+}
+
+// mmdbPath returns the value under keys in nested maps, nil when missing.
+func mmdbPath(v any, keys ...string) any {
+	for _, key := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// mmdbMetadataMarker precedes the metadata at the end of a MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// maxMMDBDepth bounds the nesting of decoded values, so a corrupt file
+// cannot recurse without end.
+const maxMMDBDepth = 32
+
+var errMMDBCorrupt = errors.New("invalid MaxMind DB data")
+
+// mmdb is a MaxMind DB file held in memory: a binary search tree over the
+// bits of addresses whose leaves point into a data section of typed values.
+// See https://maxmind.github.io/MaxMind-DB/.
+type mmdb struct {
+	databaseType string
+	nodeCount    int
+	recordSize   int
+	ipVersion    int
+	tree         []byte
+	data         mmdbDecoder
+	// ipv4Start is the node of ::/96, where IPv4 addresses start in an IPv6
+	// tree.
+	ipv4Start int
+}
+
+func openMMDB(path string) (*mmdb, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(file, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind DB file", path)
+	}
+	meta, _, err := mmdbDecoder(file[i+len(mmdbMetadataMarker):]).decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := meta.(map[string]any)
+	db := &mmdb{}
+	db.databaseType, _ = m["database_type"].(string)
+	for key, dest := range map[string]*int{"node_count": &db.nodeCount, "record_size": &db.recordSize, "ip_version": &db.ipVersion} {
+		v, ok := m[key].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("%s has no %s", path, key)
+		}
+		*dest = int(v)
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("%s has an unsupported record size %d", path, db.recordSize)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > i {
+		return nil, errMMDBCorrupt
+	}
+	db.tree = file[:treeSize]
+	db.data = mmdbDecoder(file[treeSize+16 : i])
+	if db.ipVersion == 6 {
+		for bit := 0; bit < 96 && db.ipv4Start < db.nodeCount; bit++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right child of node.
+func (db *mmdb) record(node int, bit byte) int {
+	b := db.tree[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		if bit == 1 {
+			b = b[3:]
+		}
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	case 28:
+		if bit == 0 {
+			return int(b[3]&0xf0)<<20 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		}
+		return int(b[3]&0x0f)<<24 | int(b[4])<<16 | int(b[5])<<8 | int(b[6])
+	}
+	return int(binary.BigEndian.Uint32(b[bit*4:]))
+}
+
+// lookup returns the record of the network containing addr. A nil db finds
+// nothing.
+func (db *mmdb) lookup(addr netip.Addr) (map[string]any, bool) {
+	if db == nil {
+		return nil, false
+	}
+	node := 0
+	var ip []byte
+	if addr.Is4() {
+		b := addr.As4()
+		ip, node = b[:], db.ipv4Start
+	} else {
+		if db.ipVersion == 4 {
+			return nil, false
+		}
+		b := addr.As16()
+		ip = b[:]
+	}
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, ip[i/8]>>(7-i%8)&1)
+	}
+	if node <= db.nodeCount {
+		return nil, false
+	}
+	v, _, err := db.data.decode(node-db.nodeCount-16, 0)
+	if err != nil {
+		slog.Warn("Error reading MaxMind DB record", "type", db.databaseType, "addr", addr, "err", err)
+		return nil, false
+	}
+	record, ok := v.(map[string]any)
+	return record, ok
+}
+
+// mmdbDecoder decodes the values of a data section, pointers being offsets
+// into it. Unsigned integers decode as uint64 and maps as map[string]any.
+type mmdbDecoder []byte
+
+// decode returns the value at off and the offset following it.
+func (d mmdbDecoder) decode(off, depth int) (any, int, error) {
+	if off < 0 || off >= len(d) || depth > maxMMDBDepth {
+		return nil, 0, errMMDBCorrupt
+	}
+	ctrl := d[off]
+	off++
+	typ := int(ctrl >> 5)
+	if typ == 1 {
+		n := int(ctrl>>3&3) + 1
+		if off+n > len(d) {
+			return nil, 0, errMMDBCorrupt
+		}
+		b, v := d[off:off+n], int(ctrl&7)
+		var target int
+		switch n {
+		case 1:
+			target = v<<8 | int(b[0])
+		case 2:
+			target = (v<<16 | int(b[0])<<8 | int(b[1])) + 2048
+		case 3:
+			target = (v<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])) + 526336
+		default:
+			target = int(binary.BigEndian.Uint32(b))
+		}
+		value, _, err := d.decode(target, depth+1)
+		return value, off + n, err
+	}
+	if typ == 0 {
+		if off >= len(d) {
+			return nil, 0, errMMDBCorrupt
+		}
+		typ = 7 + int(d[off])
+		off++
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if off+n > len(d) {
+			return nil, 0, errMMDBCorrupt
+		}
+		extra := 0
+		for _, b := range d[off : off+n] {
+			extra = extra<<8 | int(b)
+		}
+		size = []int{29, 285, 65821}[n-1] + extra
+		off += n
+	}
+
+	switch typ {
+	case 7:
+		m := make(map[string]any, size)
+		for range size {
+			key, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errMMDBCorrupt
+			}
+			m[k], off = value, next
+		}
+		return m, off, nil
+	case 11:
+		a := make([]any, 0, size)
+		for range size {
+			value, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, off = append(a, value), next
+		}
+		return a, off, nil
+	case 14:
+		return size != 0, off, nil
+	}
+
+	if off+size > len(d) {
+		return nil, 0, errMMDBCorrupt
+	}
+	b := d[off : off+size]
+	off += size
+	switch typ {
+	case 2:
+		return string(b), off, nil
+	case 4:
+		return bytes.Clone(b), off, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, errMMDBCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, errMMDBCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	case 5, 6, 9, 10:
+		if size > 8 {
+			// uint128 values past 64 bits are kept as bytes
+			return bytes.Clone(b), off, nil
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, off, nil
+	case 8:
+		if size > 4 {
+			return nil, 0, errMMDBCorrupt
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		if size == 4 {
+			return int64(int32(n)), off, nil
+		}
+		return int64(n), off, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported MaxMind DB type %d", typ)
+}
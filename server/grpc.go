@@ -0,0 +1,394 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"log-server/proto/logdatapb"
+)
+
+// maxStreamRejections bounds the rejections listed by PushLogStream.
+const maxStreamRejections = 100
+
+// grpcLogService implements logdatapb.LogServiceServer on top of the same
+// validation, storage and query code as the HTTP handlers.
+type grpcLogService struct {
+	logdatapb.UnimplementedLogServiceServer
+	db      *sql.DB
+	readDB  *sql.DB
+	cfg     *Config
+	queries *queryLimiter
+}
+
+// newGRPCServer returns a gRPC server of LogService.
+func newGRPCServer(db, readDB *sql.DB, cfg *Config, queries *queryLimiter) *grpc.Server {
+	srv := grpc.NewServer(grpc.MaxRecvMsgSize(int(cfg.Live().MaxBodyBytes)),
+		grpc.ChainUnaryInterceptor(logUnary), grpc.ChainStreamInterceptor(logStream))
+	logdatapb.RegisterLogServiceServer(srv, &grpcLogService{db: db, readDB: readDB, cfg: cfg, queries: queries})
+	return srv
+}
+
+// serveGRPC listens on cfg.GRPCPort and serves srv until it is stopped or
+// the listener fails.
+func serveGRPC(srv *grpc.Server, cfg *Config) error {
+	lis, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+	if err != nil {
+		return fmt.Errorf("failed to listen on gRPC port %s: %v", cfg.GRPCPort, err)
+	}
+	slog.Info("Starting gRPC server", "port", cfg.GRPCPort)
+	return srv.Serve(lis)
+}
+
+// stopGRPC stops srv, letting calls in flight finish until ctx is done.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Warn("gRPC calls still in flight at shutdown", "err", ctx.Err())
+		srv.Stop()
+		<-stopped
+	}
+}
+
+// metadataValue returns the first value of a metadata key, or "".
+func metadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// authorize applies the HTTP token and allowlist rules to a call's
+// authorization metadata and peer address, returning the account to act on.
+func (s *grpcLogService) authorize(ctx context.Context, account, scope string) (string, error) {
+	token := strings.TrimSpace(strings.TrimPrefix(metadataValue(ctx, "authorization"), "Bearer "))
+	account, err := authorize(s.cfg, token, account, scope)
+	var authErr *authError
+	if errors.As(err, &authErr) {
+		if authErr.status == http.StatusUnauthorized {
+			return "", status.Error(codes.Unauthenticated, authErr.msg)
+		}
+		return "", status.Error(codes.PermissionDenied, authErr.msg)
+	}
+	if err != nil || !s.cfg.AuthRequired || isAdminToken(token, s.cfg) {
+		return account, err
+	}
+	var addr netip.Addr
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+		addr = parseAddr(remoteAddr)
+	}
+	if !allowlists.allowed(account, addr) {
+		slog.Warn("gRPC access from address denied", "account", account, "addr", addr)
+		insertAudit(s.db, slog.Default(), AuditEntry{
+			Actor: tokenActor(s.cfg, token), Action: "network_denied", Account: account,
+			Details: fmt.Sprintf("addr=%s grpc", addr), RemoteAddr: remoteAddr,
+		})
+		return "", status.Error(codes.PermissionDenied, "Access from this address is not allowed for this account")
+	}
+	return account, nil
+}
+
+func (s *grpcLogService) PushLog(ctx context.Context, req *logdatapb.PushLogRequest) (*logdatapb.PushLogResponse, error) {
+	account, err := s.authorize(ctx, metadataValue(ctx, "x-account"), scopeIngest)
+	if err != nil {
+		return nil, err
+	}
+	ulid, err := s.store(ctx, account, req.GetEntry())
+	if err != nil {
+		return nil, err
+	}
+	if ulid == "" {
+		return &logdatapb.PushLogResponse{Message: "Log data sampled out"}, nil
+	}
+	loggerFrom(ctx).Info("Log data saved over gRPC", "account", account)
+	return &logdatapb.PushLogResponse{Message: "Log data saved successfully", Ulid: ulid}, nil
+}
+
+func (s *grpcLogService) PushLogStream(stream grpc.ClientStreamingServer[logdatapb.PushLogRequest, logdatapb.PushLogStreamResponse]) error {
+	account, err := s.authorize(stream.Context(), metadataValue(stream.Context(), "x-account"), scopeIngest)
+	if err != nil {
+		return err
+	}
+	if account == "" {
+		return status.Error(codes.InvalidArgument, "x-account metadata required")
+	}
+
+	resp := &logdatapb.PushLogStreamResponse{}
+	for index := int64(0); ; index++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			loggerFrom(stream.Context()).Info("gRPC stream closed", "account", account, "accepted", resp.Accepted, "rejected", resp.Rejected)
+			return stream.SendAndClose(resp)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := s.store(stream.Context(), account, req.GetEntry()); err != nil {
+			resp.Rejected++
+			if len(resp.Rejections) < maxStreamRejections {
+				message := status.Convert(err).Message()
+				resp.Rejections = append(resp.Rejections, &logdatapb.PushRejection{Index: index, Error: message})
+				resp.Errors = append(resp.Errors, message)
+			}
+			continue
+		}
+		resp.Accepted++
+	}
+}
+
+func (s *grpcLogService) QueryLogs(req *logdatapb.QueryLogsRequest, stream grpc.ServerStreamingServer[logdatapb.LogEntry]) error {
+	ctx := stream.Context()
+	admin := isAdminToken(strings.TrimPrefix(metadataValue(ctx, "authorization"), "Bearer "), s.cfg)
+	account, err := s.authorize(ctx, req.GetAccount(), scopeRead)
+	if err != nil {
+		return err
+	}
+	if account == "" {
+		if !admin {
+			return status.Error(codes.InvalidArgument, "Account required")
+		}
+		account = allAccounts
+	}
+	if account == allAccounts && !admin {
+		return status.Error(codes.PermissionDenied, "Admin token required for cross-account queries")
+	}
+
+	// Filters go through the /getdata parameters, so they read the same
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"system": req.GetSystem(), "user": req.GetUser(), "module": req.GetModule(), "task": req.GetTask(),
+		"trace_id": req.GetTraceId(), "start_time": req.GetStartTime(), "end_time": req.GetEndTime(),
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if req.Level != nil {
+		query.Set("level", strconv.Itoa(int(req.GetLevel())))
+	}
+	if req.Limit != nil {
+		query.Set("limit", strconv.FormatInt(req.GetLimit(), 10))
+	}
+	if req.Offset != nil {
+		query.Set("offset", strconv.FormatInt(req.GetOffset(), 10))
+	}
+	for name, value := range req.GetFields() {
+		query.Set("field."+name, value)
+	}
+	query.Set("account", account)
+	params, err := parseQueryParams(query)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	params.Account = account
+
+	if err := checkQueryRows(s.cfg, &params); err != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err := accountSettings.checkQuery(params); err != nil {
+		code := codes.ResourceExhausted
+		if err.(*settingError).status == http.StatusForbidden {
+			code = codes.PermissionDenied
+		}
+		return status.Error(code, err.Error())
+	}
+	if !s.queries.acquire() {
+		return status.Error(codes.ResourceExhausted, "Too many concurrent queries; retry later")
+	}
+	defer s.queries.release()
+
+	if s.cfg.Live().QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.Live().QueryTimeout)
+		defer cancel()
+	}
+	n := 0
+	defer func() { s.recordRead(ctx, req, account, n) }()
+	var sendErr error
+	err = queryEntries(ctx, s.readDB, params, func(logData LogData) error {
+		entry, err := logDataToProto(logData)
+		if err != nil {
+			slog.Error("Error converting row", "id", *logData.ID, "err", err)
+			return nil
+		}
+		if sendErr = stream.Send(entry); sendErr != nil {
+			return sendErr
+		}
+		n++
+		return nil
+	})
+	if err == nil || err == sendErr {
+		return err
+	}
+	if aborted := queryStatus(err); aborted != nil {
+		return aborted
+	}
+	slog.Error("Error querying log data over gRPC", "err", err)
+	return status.Error(codes.Internal, "Failed to fetch log data")
+}
+
+// recordRead audits a QueryLogs call that sent rows entries.
+func (s *grpcLogService) recordRead(ctx context.Context, req *logdatapb.QueryLogsRequest, account string, rows int) {
+	token := strings.TrimSpace(strings.TrimPrefix(metadataValue(ctx, "authorization"), "Bearer "))
+	action := "grpc_query"
+	if account == allAccounts {
+		action = "grpc_cross_account_query"
+	}
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+	n := int64(rows)
+	insertAudit(s.db, slog.Default(), AuditEntry{
+		Actor: tokenActor(s.cfg, token), Action: action, Account: account,
+		Details: req.String(), RemoteAddr: remoteAddr, Rows: &n,
+	})
+}
+
+// queryStatus maps a query stopped by its context to the matching gRPC
+// status, or returns nil.
+func queryStatus(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "Query exceeded the maximum duration")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "Query canceled")
+	}
+	return nil
+}
+
+// store validates and inserts one entry, mirroring handlePostLogData, and
+// returns its ULID.
+func (s *grpcLogService) store(ctx context.Context, account string, entry *logdatapb.LogEntry) (string, error) {
+	if s.cfg.ReplicateFrom != "" {
+		return "", status.Errorf(codes.FailedPrecondition, "Read-only replica, write to %s", s.cfg.ReplicateFrom)
+	}
+	if refusal := admission.refusal(); refusal != nil {
+		return "", status.Error(codes.Unavailable, refusal.message)
+	}
+	if account == "" {
+		return "", status.Error(codes.InvalidArgument, "x-account metadata required")
+	}
+	if entry == nil {
+		return "", status.Error(codes.InvalidArgument, "entry required")
+	}
+
+	logData := logDataFromProto(entry)
+	payload, _ := json.Marshal(logData)
+	logData.splitStackTrace()
+	if err := logData.Validate(); err != nil {
+		rejectLog(s.db, s.cfg, account, payload, fmt.Sprintf("Validation failed: %v", err))
+		return "", status.Errorf(codes.InvalidArgument, "Validation failed: %v", err)
+	}
+	if errs := logData.checkLimits(s.cfg); len(errs) > 0 {
+		rejectLog(s.db, s.cfg, account, payload, "Payload limits exceeded")
+		return "", status.Errorf(codes.InvalidArgument, "Payload limits exceeded: %s %s", errs[0].Field, errs[0].Reason)
+	}
+	if err := logData.checkClock(s.cfg); err != nil {
+		rejectLog(s.db, s.cfg, account, payload, fmt.Sprintf("Validation failed: %v", err))
+		return "", status.Errorf(codes.InvalidArgument, "Validation failed: %v", err)
+	}
+	logData.ULID = newULID(logData.Timestamp)
+	if logData.Account != account {
+		rejectLog(s.db, s.cfg, account, payload, "Account in entry must match x-account metadata")
+		return "", status.Error(codes.InvalidArgument, "Account in entry must match x-account metadata")
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		sources.enrich(&logData, parseAddr(p.Addr.String()))
+	}
+	var quotaErr *quotaError
+	if err := insertLogData(s.db, s.cfg, &logData); errors.As(err, &quotaErr) {
+		return "", status.Error(codes.ResourceExhausted, err.Error())
+	} else if err != nil {
+		slog.Error("Error saving log data over gRPC", "err", err)
+		rejectLog(s.db, s.cfg, account, payload, fmt.Sprintf("Failed to save log data: %v", err))
+		return "", status.Error(codes.Internal, "Failed to save log data")
+	}
+	return logData.ULID, nil
+}
+
+func logDataFromProto(entry *logdatapb.LogEntry) LogData {
+	logData := LogData{
+		Account:    entry.GetAccount(),
+		System:     entry.GetSystem(),
+		User:       entry.GetUser(),
+		Module:     entry.GetModule(),
+		Task:       entry.GetTask(),
+		Msg:        entry.GetMsg(),
+		Level:      int(entry.GetLevel()),
+		StackTrace: entry.GetStackTrace(),
+		TraceID:    entry.GetTraceId(),
+		SpanID:     entry.GetSpanId(),
+		SessionID:  entry.GetSessionId(),
+		SourceSeq:  entry.GetSourceSeq(),
+	}
+	if entry.GetTimestamp() != nil {
+		logData.Timestamp = entry.GetTimestamp().AsTime()
+	}
+	if entry.GetFields() != nil {
+		logData.Fields = entry.GetFields().AsMap()
+	}
+	return logData
+}
+
+func logDataToProto(logData LogData) (*logdatapb.LogEntry, error) {
+	entry := &logdatapb.LogEntry{
+		Account:     logData.Account,
+		System:      logData.System,
+		User:        logData.User,
+		Module:      logData.Module,
+		Task:        logData.Task,
+		Timestamp:   timestamppb.New(logData.Timestamp),
+		Msg:         logData.Msg,
+		Level:       int32(logData.Level),
+		StackTrace:  logData.StackTrace,
+		TraceId:     logData.TraceID,
+		SpanId:      logData.SpanID,
+		Ulid:        logData.ULID,
+		RepeatCount: int64(logData.RepeatCount),
+		SampledRate: logData.SampledRate,
+		SourceSeq:   logData.SourceSeq,
+		SessionId:   logData.SessionID,
+	}
+	if logData.ID != nil {
+		entry.Id = *logData.ID
+	}
+	if len(logData.Fields) > 0 {
+		fields, err := structpb.NewStruct(logData.Fields)
+		if err != nil {
+			return nil, err
+		}
+		entry.Fields = fields
+	}
+	return entry, nil
+}
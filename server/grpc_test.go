@@ -0,0 +1,60 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"log-server/proto/logdatapb"
+	"log-server/server/testutil"
+)
+
+func TestPushLogStreamRejections(t *testing.T) {
+	port := freePort(t)
+	srv := testutil.NewServer(t, map[string]string{"GRPC_PORT": port})
+	srv.Start()
+	conn, err := grpc.NewClient("127.0.0.1:"+port, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(metadata.AppendToOutgoingContext(context.Background(), "x-account", "acme"), 10*time.Second)
+	defer cancel()
+	stream, err := logdatapb.NewLogServiceClient(conn).PushLogStream(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatalf("PushLogStream: %v", err)
+	}
+	// Entries at odd indexes have no system
+	for i := 0; i < 210; i++ {
+		entry := &logdatapb.LogEntry{Account: "acme", System: "test", User: "test", Module: "test", Task: "test",
+			Timestamp: timestamppb.New(srv.Clock.Now()), Msg: fmt.Sprintf("entry %d", i), Level: 30}
+		if i%2 == 1 {
+			entry.System = ""
+		}
+		if err := stream.Send(&logdatapb.PushLogRequest{Entry: entry}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv: %v", err)
+	}
+	if resp.GetAccepted() != 105 || resp.GetRejected() != 105 {
+		t.Fatalf("accepted %d, rejected %d, want 105 each", resp.GetAccepted(), resp.GetRejected())
+	}
+	if n := len(resp.GetRejections()); n != 100 {
+		t.Fatalf("%d rejections listed, want 100", n)
+	}
+	for i, rejection := range resp.GetRejections() {
+		if rejection.GetIndex() != int64(2*i+1) || rejection.GetError() == "" {
+			t.Fatalf("rejection %d = %v, want index %d", i, rejection, 2*i+1)
+		}
+	}
+}
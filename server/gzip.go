@@ -0,0 +1,91 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// gzipResponseWriter compresses everything written through it.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	// The compressed length differs from anything a handler may have set
+	g.Header().Del("Content-Length")
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	return g.gz.Write(b)
+}
+
+// Flush lets streaming handlers push compressed data to the client.
+func (g *gzipResponseWriter) Flush() {
+	g.gz.Flush()
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// withGzip transparently decompresses gzip and zstd request bodies
+// (Content-Encoding: gzip or zstd) and compresses responses for clients
+// sending Accept-Encoding: gzip. The zstd window, which the decoder
+// allocates as the frame declares, is limited to MAX_BODY_BYTES, so a few
+// bytes of header cannot claim more memory than a body may take.
+func withGzip(cfg *Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+			gr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				requestLogger(r).Warn("Invalid gzip request body", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid gzip request body")
+				return
+			}
+			defer gr.Close()
+			r.Body = io.NopCloser(gr)
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		} else if strings.EqualFold(r.Header.Get("Content-Encoding"), "zstd") {
+			window := uint64(min(max(cfg.Live().MaxBodyBytes, zstd.MinWindowSize), zstd.MaxWindowSize))
+			zr, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true),
+				zstd.WithDecoderMaxWindow(window), zstd.WithDecoderMaxMemory(window))
+			if err != nil {
+				requestLogger(r).Warn("Invalid zstd request body", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid zstd request body")
+				return
+			}
+			defer zr.Close()
+			r.Body = io.NopCloser(zr)
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		}
+
+		if !acceptsGzip(r) {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Add("Vary", "Accept-Encoding")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		next(&gzipResponseWriter{ResponseWriter: w, gz: gz}, r)
+	}
+}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, q, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(strings.TrimSpace(enc), "gzip") && strings.TrimSpace(q) != "q=0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"log-server/client"
+	"log-server/server/testutil"
+)
+
+func TestZstdWindowLimited(t *testing.T) {
+	srv := testutil.NewServer(t, nil)
+
+	// A frame declaring a 256 MiB window (exponent 18), under the
+	// library's default limit, holding a valid entry in one raw block
+	entry, _ := json.Marshal(srv.Entry("acme", "in a large window"))
+	size := len(entry)<<3 | 1
+	frame := string(append([]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 18 << 3, byte(size), byte(size >> 8), byte(size >> 16)}, entry...))
+	rec := serve(srv, http.MethodPost, "/logdata/batch", frame, map[string]string{
+		"X-Account": "acme", "Content-Type": "application/x-ndjson", "Content-Encoding": "zstd",
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("POST /logdata/batch with a 256 MiB window: %d %s, want 400", rec.Code, rec.Body.String())
+	}
+
+	// Clients' batches fit the limit, up to the body limit itself
+	c := client.New(client.Config{Server: srv.URL, Account: "acme", Token: testutil.AdminToken})
+	entries := make([]client.Entry, 600)
+	for i := range entries {
+		entries[i] = client.Entry{System: "test", User: "test", Module: "test", Task: "test", Msg: fmt.Sprintf("%d %s", i, strings.Repeat("x", 1400)), Level: 30}
+	}
+	if res, err := c.PushBatch(context.Background(), entries); err != nil || res.Stored != len(entries) {
+		t.Fatalf("PushBatch: %+v, %v, want %d entries stored", res, err, len(entries))
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds the database checks done by /readyz.
+const readinessTimeout = 2 * time.Second
+
+// handleHealthz reports liveness: the process is up and serving HTTP.
+func handleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}
+}
+
+// handleReadyz reports readiness: the database answers, its schema is up to
+// date and writes are not refused by backpressure. Maintenance mode is
+// reported but leaves the server ready, since reads are still served.
+func handleReadyz(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		checks := map[string]string{}
+		ready := true
+
+		if err := db.PingContext(ctx); err != nil {
+			requestLogger(r).Warn("Readiness check: database unreachable", "err", err)
+			checks["database"] = err.Error()
+			ready = false
+		} else {
+			checks["database"] = "ok"
+		}
+
+		pending, err := pendingMigrations(ctx, db)
+		switch {
+		case err != nil:
+			requestLogger(r).Warn("Readiness check: failed to inspect schema", "err", err)
+			checks["migrations"] = err.Error()
+			ready = false
+		case len(pending) > 0:
+			checks["migrations"] = "pending"
+			ready = false
+		default:
+			checks["migrations"] = "ok"
+		}
+
+		admitted := admission.status()
+		if admitted.Backpressure != "" {
+			checks["backpressure"] = admitted.Backpressure
+			ready = false
+		} else {
+			checks["backpressure"] = "ok"
+		}
+		if admitted.Maintenance.Enabled {
+			checks["maintenance"] = "enabled"
+			if admitted.Maintenance.Reason != "" {
+				checks["maintenance"] += ": " + admitted.Maintenance.Reason
+			}
+		} else {
+			checks["maintenance"] = "off"
+		}
+
+		status := http.StatusOK
+		state := "ready"
+		if !ready {
+			status = http.StatusServiceUnavailable
+			state = "not ready"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": state, "checks": checks, "pending_migrations": pending})
+	}
+}
+
+// pendingMigrations lists logDataMigrations columns missing from the database.
+func pendingMigrations(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info('logData')")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := []string{}
+	for _, column := range logDataMigrations {
+		if !existing[column.name] {
+			pending = append(pending, column.name)
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,52 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"log-server/server/testutil"
+)
+
+type readiness struct {
+	Status string
+	Checks map[string]string
+}
+
+func readyz(t *testing.T, srv *testutil.Server) (int, readiness) {
+	t.Helper()
+	rec := serve(srv, http.MethodGet, "/readyz", "", nil)
+	var res readiness
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("GET /readyz: %d %v", rec.Code, err)
+	}
+	return rec.Code, res
+}
+
+func TestReadyzBackpressure(t *testing.T) {
+	// No disk has this much free space
+	srv := testutil.NewServer(t, map[string]string{
+		"DATABASE_PATH":       filepath.Join(t.TempDir(), "logs.db"),
+		"MIN_FREE_DISK_BYTES": "4611686018427387904",
+	})
+	code, res := readyz(t, srv)
+	if code != http.StatusServiceUnavailable || res.Checks["backpressure"] == "ok" {
+		t.Fatalf("GET /readyz under backpressure: %d %+v, want 503", code, res)
+	}
+}
+
+func TestReadyzMaintenance(t *testing.T) {
+	srv := testutil.NewServer(t, nil)
+	if code, res := readyz(t, srv); code != http.StatusOK || res.Checks["backpressure"] != "ok" || res.Checks["maintenance"] != "off" {
+		t.Fatalf("GET /readyz: %d %+v, want 200", code, res)
+	}
+
+	if rec := serve(srv, http.MethodPut, "/admin/maintenance", `{"enabled":true,"reason":"upgrade"}`, nil); rec.Code != http.StatusOK {
+		t.Fatalf("PUT /admin/maintenance: %d %s", rec.Code, rec.Body.String())
+	}
+	// Reads are still served, so the server stays ready
+	if code, res := readyz(t, srv); code != http.StatusOK || res.Checks["maintenance"] != "enabled: upgrade" {
+		t.Fatalf("GET /readyz in maintenance mode: %d %+v, want 200 reporting it", code, res)
+	}
+}
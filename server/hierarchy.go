@@ -0,0 +1,27 @@
+package server
+
+import "strings"
+
+// With ACCOUNT_SEPARATOR set, say to ".", account names are paths: acme is
+// the parent of acme.payments, itself the parent of acme.payments.api.
+// Tokens of an account may use their scopes on the accounts below it, and
+// /getdata?account_prefix=acme.payments reads the entries of acme.payments
+// and of every account below it.
+
+// accountSeparator is ACCOUNT_SEPARATOR, empty when accounts are flat. It is
+// set by load.
+var accountSeparator string
+
+// accountWithin reports whether account is root or an account below it.
+func accountWithin(account, root string) bool {
+	return account == root || accountSeparator != "" && strings.HasPrefix(account, root+accountSeparator)
+}
+
+// subtreeFilter returns the condition selecting the entries of root and of
+// the accounts below it.
+func subtreeFilter(root string) (string, []interface{}) {
+	// The accounts below sort between root and the separator, and root and
+	// the byte after it, a range the account index serves
+	return "(account = ? OR (account > ? AND account < ?))",
+		[]interface{}{root, root + accountSeparator, root + string(accountSeparator[0]+1)}
+}
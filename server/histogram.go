@@ -0,0 +1,175 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// histogramBuckets maps the bucket sizes accepted by /histogram to seconds.
+var histogramBuckets = map[string]int64{
+	"1m": 60,
+	"5m": 5 * 60,
+	"1h": 60 * 60,
+	"1d": 24 * 60 * 60,
+}
+
+// maxHistogramBuckets bounds the buckets a single /histogram response holds.
+const maxHistogramBuckets = 10000
+
+// HistogramBucket is the number of entries timestamped in [Start, Start+bucket).
+type HistogramBucket struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// handleGetHistogram implements GET /histogram: entry counts per time bucket
+// for the /getdata filters, oldest first. Buckets without entries are
+// included with a zero count, from start_time (or the first entry) to
+// end_time (or the last entry). Buckets start on the hour, or at midnight,
+// of tz when given, and are UTC otherwise.
+func handleGetHistogram(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+		if account == allAccounts && !isAdmin(r, cfg) {
+			requestLogger(r).Warn("Cross-account query denied")
+			writeError(w, http.StatusForbidden, codeForbidden, "Admin token required for cross-account queries")
+			return
+		}
+
+		bucket := query.Get("bucket")
+		if bucket == "" {
+			bucket = "1h"
+		}
+		seconds, ok := histogramBuckets[bucket]
+		if !ok {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "bucket must be 1m, 5m, 1h or 1d")
+			return
+		}
+
+		params, err := parseQueryParams(query)
+		if err != nil {
+			requestLogger(r).Warn("Invalid query parameters", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		params.Account = account
+		if !allowedBySettings(w, r, accountSettings.checkQuery(params)) {
+			return
+		}
+		var start, end time.Time
+		if params.StartTime != "" {
+			if start, err = time.Parse(time.RFC3339, params.StartTime); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid start_time: must be RFC3339")
+				return
+			}
+		}
+		if params.EndTime != "" {
+			if end, err = time.Parse(time.RFC3339, params.EndTime); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid end_time: must be RFC3339")
+				return
+			}
+		}
+		if !start.IsZero() && !end.IsZero() && (end.Unix()-start.Unix())/seconds >= maxHistogramBuckets {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest,
+				fmt.Sprintf("Range exceeds %d buckets; use a larger bucket or a shorter range", maxHistogramBuckets))
+			return
+		}
+		key := queryCacheKey(r)
+		if _, ok := queryResults.serve(w, key); ok {
+			return
+		}
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		// Buckets are numbered by their start's wall clock in loc, read as UTC
+		loc := params.location()
+		from, to := start, end
+		if from.IsZero() {
+			from = time.Unix(0, 0)
+		}
+		if to.IsZero() {
+			to = timeNow().Add(24 * time.Hour)
+		}
+		where, args := buildLogFilter(params)
+		sqlQuery := fmt.Sprintf(`SELECT (unix + %s) / %d * %d AS bucket, SUM(repeat_count)
+			FROM (SELECT CAST(strftime('%%s', %s) AS INTEGER) AS unix, repeat_count FROM %s WHERE %s)
+			GROUP BY bucket ORDER BY bucket`, offsetExpr("unix", loc, from, to), seconds, seconds,
+			params.timeColumn(), logDataSource(params.partitionRange()), where)
+		var rowsRead int64
+		defer slowQueries.observe(sqlQuery, args, time.Now(), &rowsRead)
+		rows, err := db.QueryContext(ctx, sqlQuery, args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying histogram", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch histogram")
+			return
+		}
+		defer rows.Close()
+
+		counts := map[int64]int64{}
+		first, last := int64(-1), int64(-1)
+		for rows.Next() {
+			rowsRead++
+			var b, count int64
+			if err := rows.Scan(&b, &count); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			counts[b] = count
+			if first < 0 {
+				first = b
+			}
+			last = b
+		}
+		if err := rows.Err(); err != nil {
+			if !queryAborted(w, r, cfg, err) {
+				requestLogger(r).Error("Error reading histogram", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch histogram")
+			}
+			return
+		}
+
+		wall := func(t time.Time) int64 {
+			_, offset := t.In(loc).Zone()
+			return (t.Unix() + int64(offset)) / seconds * seconds
+		}
+		if !start.IsZero() {
+			first = wall(start)
+		}
+		if !end.IsZero() {
+			last = wall(end)
+		}
+		buckets := []HistogramBucket{}
+		if first >= 0 && last >= first {
+			if (last-first)/seconds >= maxHistogramBuckets {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest,
+					fmt.Sprintf("Range exceeds %d buckets; use a larger bucket or a shorter range", maxHistogramBuckets))
+				return
+			}
+			for b := first; b <= last; b += seconds {
+				// Skip the wall clock times a DST change leaves out
+				if start := wallTime(b, loc); wall(start) == b {
+					buckets = append(buckets, HistogramBucket{Start: start, Count: counts[b]})
+				}
+			}
+		}
+
+		queryResults.write(w, key, params, len(buckets), buckets)
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import "sync"
+
+var (
+	insertHooksMu sync.RWMutex
+	insertHooks   []func(LogData)
+)
+
+// registerInsertHook adds a function called with every entry after it is
+// stored. Hooks run on the ingesting goroutine and must not block.
+func registerInsertHook(hook func(LogData)) {
+	insertHooksMu.Lock()
+	defer insertHooksMu.Unlock()
+	insertHooks = append(insertHooks, hook)
+}
+
+// resetInsertHooks removes the hooks of a previous Server.
+func resetInsertHooks() {
+	insertHooksMu.Lock()
+	defer insertHooksMu.Unlock()
+	insertHooks = nil
+}
+
+func runInsertHooks(logData LogData) {
+	insertHooksMu.RLock()
+	defer insertHooksMu.RUnlock()
+	for _, hook := range insertHooks {
+		hook(logData)
+	}
+}
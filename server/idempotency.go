@@ -0,0 +1,122 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const idempotencyKeysSchema = `CREATE TABLE IF NOT EXISTS idempotency_keys (
+    account TEXT NOT NULL,
+    key TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    status INTEGER NOT NULL,
+    response TEXT NOT NULL,
+    PRIMARY KEY (account, key)
+)`
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// idempotencyKey returns the client-supplied key for a request: the
+// Idempotency-Key header, or the entry's client_id.
+func idempotencyKey(r *http.Request, logData LogData) string {
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		return key
+	}
+	return logData.ClientID
+}
+
+// lookupIdempotentResponse returns the stored response for a key still within its TTL.
+func lookupIdempotentResponse(db *sql.DB, cfg *Config, account, key string) (int, string, bool) {
+	var status int
+	var response string
+	err := db.QueryRow("SELECT status, response FROM idempotency_keys WHERE account = ? AND key = ? AND created_at >= ?",
+		account, key, timeNow().UTC().Add(-cfg.IdempotencyTTL)).Scan(&status, &response)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("Error looking up idempotency key", "err", err)
+		}
+		return 0, "", false
+	}
+	return status, response, true
+}
+
+// insertLogDataIdempotent stores logData and records the key with its response
+// in one transaction. It returns false when another request already claimed the key.
+func insertLogDataIdempotent(db *sql.DB, cfg *Config, account, key string, logData *LogData, status int) (bool, error) {
+	keep, redactions, err := prepareInsert(db, cfg, logData)
+	if !keep || err != nil {
+		return err == nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// An expired key may still be present until the next cleanup
+	if _, err := tx.Exec("DELETE FROM idempotency_keys WHERE account = ? AND key = ? AND created_at < ?",
+		account, key, timeNow().UTC().Add(-cfg.IdempotencyTTL)); err != nil {
+		return false, err
+	}
+	// The response is stored once the entry's final ULID is known
+	res, err := tx.Exec(`INSERT OR IGNORE INTO idempotency_keys (account, key, created_at, status, response)
+		VALUES (?, ?, ?, ?, '')`, account, key, timeNow().UTC(), status)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	merged, err := storeLogDataTx(tx, cfg, logData, redactions)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE idempotency_keys SET response = ? WHERE account = ? AND key = ?",
+		savedResponse(logData.ULID), account, key); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	if !merged {
+		runInsertHooks(*logData)
+	}
+	return true, nil
+}
+
+// runIdempotencyCleanup periodically deletes keys older than the TTL.
+func runIdempotencyCleanup(stop <-chan struct{}, db *sql.DB, ttl time.Duration) {
+	interval := min(ttl, time.Hour)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for tick(stop, ticker) {
+		res, err := db.Exec("DELETE FROM idempotency_keys WHERE created_at < ?", timeNow().UTC().Add(-ttl))
+		if err != nil {
+			slog.Error("Error cleaning up idempotency keys", "err", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			slog.Info("Removed expired idempotency keys", "count", n)
+		}
+	}
+}
+
+// writeIdempotentReplay returns a previously stored response.
+func writeIdempotentReplay(w http.ResponseWriter, status int, response string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, response)
+}
+
+// savedResponse is the POST /logdata response body for a stored entry.
+func savedResponse(ulid string) string {
+	return fmt.Sprintf(`{"message":"Log data saved successfully","ulid":"%s"}`, ulid)
+}
@@ -0,0 +1,65 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"log-server/server"
+	"log-server/server/testutil"
+)
+
+func TestIdempotentInsertRunsPipeline(t *testing.T) {
+	srv := testutil.NewServer(t, nil, server.WithEntryInterceptors(func(entry *server.LogData) {
+		entry.Module = "intercepted"
+	}))
+	if rec := serve(srv, http.MethodPut, "/columns/latency_ms", `{"account":"acme","type":"integer"}`, nil); rec.Code >= 300 {
+		t.Fatalf("PUT /columns/latency_ms: %d %s", rec.Code, rec.Body.String())
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		key      string
+		clientID string
+	}{
+		{"idempotency key", "/logdata", "key-1", ""},
+		{"client id", "/logdata", "", "client-1"},
+		{"async with key", "/logdata?ack=async", "key-2", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := srv.Entry("acme", tt.name)
+			entry.ClientID = tt.clientID
+			entry.Fields = map[string]interface{}{"latency_ms": "42"}
+			body, _ := json.Marshal(entry)
+			header := map[string]string{"X-Account": "acme"}
+			if tt.key != "" {
+				header["Idempotency-Key"] = tt.key
+			}
+			if rec := serve(srv, http.MethodPost, tt.path, string(body), header); rec.Code >= 300 {
+				t.Fatalf("POST %s: %d %s", tt.path, rec.Code, rec.Body.String())
+			}
+
+			var entries []server.LogData
+			for deadline := time.Now().Add(5 * time.Second); len(entries) == 0 && time.Now().Before(deadline); {
+				entries = srv.Entries("acme", url.Values{"msg_regex": {"^" + regexp.QuoteMeta(tt.name) + "$"}})
+				if len(entries) == 0 {
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			if entries[0].Module != "intercepted" {
+				t.Errorf("module = %q, want the entry interceptor's", entries[0].Module)
+			}
+			if v, ok := entries[0].Fields["latency_ms"].(float64); !ok || v != 42 {
+				t.Errorf("latency_ms = %#v, want the custom column's integer 42", entries[0].Fields["latency_ms"])
+			}
+		})
+	}
+}
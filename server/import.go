@@ -0,0 +1,439 @@
+package server
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxImportSamples bounds the rejected lines listed in an ImportResult.
+const maxImportSamples = 100
+
+// ImportResult summarizes a POST /import request.
+type ImportResult struct {
+	Lines    int `json:"lines"`
+	Imported int `json:"imported"`
+	// Skipped counts entries whose ulid was already stored.
+	Skipped  int `json:"skipped"`
+	Rejected int `json:"rejected"`
+	// Errors counts rejected lines by reason.
+	Errors map[string]int `json:"errors,omitempty"`
+	// Samples lists the first rejected lines.
+	Samples []ImportError `json:"samples,omitempty"`
+	// Error is set when the import stopped early.
+	Error string `json:"error,omitempty"`
+}
+
+// ImportError is one rejected line of an import.
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+func (res *ImportResult) reject(line int, reason string) {
+	res.Rejected++
+	if res.Errors == nil {
+		res.Errors = map[string]int{}
+	}
+	res.Errors[reason]++
+	if len(res.Samples) < maxImportSamples {
+		res.Samples = append(res.Samples, ImportError{Line: line, Error: reason})
+	}
+}
+
+// importLine is a parsed import line, or the reason it was rejected.
+type importLine struct {
+	line    int
+	logData LogData
+	err     error
+}
+
+// handleImport serves POST /import, bulk loading historical entries for the
+// X-Account account from an NDJSON (default) or CSV body, selected with
+// format=ndjson|csv or the Content-Type. Entries are inserted in
+// transactions of cfg.Live().ImportBatchSize without sampling, deduplication or
+// insert hooks. Invalid lines are counted and skipped.
+func handleImport(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		account := r.Header.Get("X-Account")
+		if account == "" {
+			requestLogger(r).Warn("Missing X-Account header")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "X-Account header required")
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "ndjson"
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+				format = "csv"
+			}
+		}
+		// Exports hold canonical levels, which must not be mapped again
+		canonicalLevels := r.URL.Query().Get("levels") == "canonical"
+		var lines <-chan importLine
+		switch format {
+		case "ndjson":
+			lines = readNDJSON(r.Body, cfg.Live().MaxBodyBytes)
+		case "csv":
+			lines = readCSV(r.Body)
+		default:
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "format must be ndjson or csv")
+			return
+		}
+
+		res := ImportResult{}
+		status := http.StatusOK
+		batch := make([]LogData, 0, cfg.Live().ImportBatchSize)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			imported, skipped, err := importBatch(db, cfg, account, batch)
+			queryResults.invalidateAccount(account)
+			res.Imported += imported
+			res.Skipped += skipped
+			batch = batch[:0]
+			return err
+		}
+		for line := range lines {
+			if line.line == 0 {
+				// A body that cannot be read any further
+				res.Error = line.err.Error()
+				status = http.StatusBadRequest
+				break
+			}
+			res.Lines++
+			if line.err != nil {
+				res.reject(line.line, line.err.Error())
+				continue
+			}
+			logData := line.logData
+			if logData.Account == "" {
+				logData.Account = account
+			}
+			if logData.Account != account {
+				res.reject(line.line, "Account must match X-Account header")
+				continue
+			}
+			if !canonicalLevels {
+				levelSchemes.normalize(&logData)
+			}
+			logData.splitStackTrace()
+			logData.defaultTTL(r)
+			if err := logData.Validate(); err != nil {
+				res.reject(line.line, fmt.Sprintf("Validation failed: %v", err))
+				continue
+			}
+			logData.resolveExpiry(timeNow())
+			if errs := logData.checkLimits(cfg); len(errs) > 0 {
+				res.reject(line.line, fmt.Sprintf("Payload limits exceeded: %s %s", errs[0].Field, errs[0].Reason))
+				continue
+			}
+			if batch = append(batch, logData); len(batch) < cfg.Live().ImportBatchSize {
+				continue
+			}
+			if err := flush(); err != nil {
+				res.Error, status = importFailure(r, err)
+				break
+			}
+		}
+		if res.Error == "" {
+			if err := flush(); err != nil {
+				res.Error, status = importFailure(r, err)
+			}
+		}
+		// Drain the reader so its goroutine exits
+		for range lines {
+		}
+
+		requestLogger(r).Info("Imported log data", "account", account, "lines", res.Lines,
+			"imported", res.Imported, "skipped", res.Skipped, "rejected", res.Rejected)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// importFailure maps an error that stopped an import to its message and status.
+func importFailure(r *http.Request, err error) (string, int) {
+	var quotaErr *quotaError
+	if errors.As(err, &quotaErr) {
+		requestLogger(r).Warn("Import stopped", "err", err)
+		return err.Error(), quotaErr.status
+	}
+	requestLogger(r).Error("Error importing log data", "err", err)
+	return "Failed to save log data", http.StatusInternalServerError
+}
+
+// importBatch inserts entries in one transaction after checking the quota.
+func importBatch(db *sql.DB, cfg *Config, account string, entries []LogData) (imported, skipped int, err error) {
+	if err := checkQuota(db, cfg, account); err != nil {
+		return 0, 0, err
+	}
+	if partitions != nil {
+		for _, logData := range entries {
+			if err := partitions.ensure(db, logData.Timestamp); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if err := encryption.ensure(db, account); err != nil {
+		return 0, 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	redactions := 0
+	for _, logData := range entries {
+		extractionRules.apply(&logData)
+		redactions += redact(cfg, &logData)
+		inserted, err := importLogDataTx(tx, logData)
+		if err != nil {
+			return 0, 0, err
+		}
+		if inserted {
+			imported++
+		} else {
+			skipped++
+		}
+	}
+	if redactions > 0 {
+		if err := addRedactions(tx, account, redactions); err != nil {
+			return 0, 0, fmt.Errorf("failed to count redactions: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return imported, skipped, nil
+}
+
+// importLogDataTx inserts an entry keeping its ULID and repeat count, or
+// reports false when an entry with that ULID or source_seq is already stored.
+func importLogDataTx(tx *sql.Tx, logData LogData) (bool, error) {
+	// Imported entries join this node's chain
+	logData.Hash, logData.PrevHash = "", ""
+	if logData.ULID != "" {
+		var exists bool
+		tx.QueryRow("SELECT 1 FROM logData WHERE ulid = ?", logData.ULID).Scan(&exists)
+		if exists {
+			return false, nil
+		}
+	}
+	if logData.SourceSeq != "" {
+		if replayed, err := findReplay(tx, &logData); replayed || err != nil {
+			return false, err
+		}
+	}
+	id, err := insertLogDataTx(tx, logData)
+	if err == nil && logData.RepeatCount > 1 {
+		_, err = tx.Exec("UPDATE "+tableFor(logData.Timestamp)+" SET repeat_count = ? WHERE id = ?", logData.RepeatCount, id)
+	}
+	return err == nil, err
+}
+
+// importRecord decodes an NDJSON line, accepting level names as well as numbers.
+type importRecord struct {
+	LogData
+	Level json.RawMessage `json:"level"`
+}
+
+// readNDJSON parses one entry per line, skipping blank lines. A read error
+// is sent with line 0 and ends the stream.
+func readNDJSON(r io.Reader, maxLine int64) <-chan importLine {
+	out := make(chan importLine, 64)
+	go func() {
+		defer close(out)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64<<10), int(maxLine))
+		n := 0
+		for scanner.Scan() {
+			n++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var rec importRecord
+			if err := json.Unmarshal([]byte(text), &rec); err != nil {
+				out <- importLine{line: n, err: fmt.Errorf("Invalid JSON: %v", err)}
+				continue
+			}
+			logData := rec.LogData
+			logData.ID = nil
+			var err error
+			if len(rec.Level) > 0 && string(rec.Level) != "null" {
+				logData.Level, logData.levelName, err = importLevel(strings.Trim(string(rec.Level), `"`))
+			}
+			out <- importLine{line: n, logData: logData, err: err}
+		}
+		if err := scanner.Err(); err != nil {
+			out <- importLine{err: fmt.Errorf("Failed to read line %d: %v", n+1, err)}
+		}
+	}()
+	return out
+}
+
+// csvColumns lists the CSV header names mapped to LogData columns. Other
+// columns are stored in fields; a "fields" column holds a JSON object.
+var csvColumns = map[string]bool{
+	"ulid": true, "account": true, "system": true, "user": true, "module": true, "task": true,
+	"timestamp": true, "msg": true, "level": true, "stack_trace": true, "trace_id": true,
+	"span_id": true, "session_id": true, "expires_at": true, "ttl": true, "fields": true,
+}
+
+// readCSV parses a CSV body whose first row names the columns. A read error
+// is sent with line 0 and ends the stream.
+func readCSV(r io.Reader) <-chan importLine {
+	out := make(chan importLine, 64)
+	go func() {
+		defer close(out)
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		header, err := reader.Read()
+		if err != nil {
+			out <- importLine{err: fmt.Errorf("Failed to read CSV header: %v", err)}
+			return
+		}
+		for i := range header {
+			header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+		}
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) && parseErr.Err != csv.ErrFieldCount {
+				out <- importLine{err: fmt.Errorf("Invalid CSV at line %d: %v", parseErr.StartLine, parseErr.Err)}
+				return
+			} else if err != nil && parseErr == nil {
+				out <- importLine{err: fmt.Errorf("Failed to read CSV: %v", err)}
+				return
+			}
+			line, _ := reader.FieldPos(0)
+			logData, err := csvEntry(header, record)
+			out <- importLine{line: line, logData: logData, err: err}
+		}
+	}()
+	return out
+}
+
+func csvEntry(header, record []string) (LogData, error) {
+	var logData LogData
+	if len(record) != len(header) {
+		return logData, fmt.Errorf("Expected %d columns, got %d", len(header), len(record))
+	}
+	var err error
+	for i, name := range header {
+		value := record[i]
+		switch name {
+		case "ulid":
+			logData.ULID = value
+		case "account":
+			logData.Account = value
+		case "system":
+			logData.System = value
+		case "user":
+			logData.User = value
+		case "module":
+			logData.Module = value
+		case "task":
+			logData.Task = value
+		case "msg":
+			logData.Msg = value
+		case "stack_trace":
+			logData.StackTrace = value
+		case "trace_id":
+			logData.TraceID = value
+		case "span_id":
+			logData.SpanID = value
+		case "session_id":
+			logData.SessionID = value
+		case "ttl":
+			logData.TTL = value
+		case "expires_at":
+			if value != "" {
+				expiresAt, err := importTime(value)
+				if err != nil {
+					return logData, err
+				}
+				logData.ExpiresAt = &expiresAt
+			}
+		case "timestamp":
+			if logData.Timestamp, err = importTime(value); err != nil {
+				return logData, err
+			}
+		case "level":
+			if logData.Level, logData.levelName, err = importLevel(value); err != nil {
+				return logData, err
+			}
+		case "fields":
+			if value == "" {
+				continue
+			}
+			var fields map[string]any
+			if err := json.Unmarshal([]byte(value), &fields); err != nil {
+				return logData, fmt.Errorf("Invalid fields JSON: %v", err)
+			}
+			for k, v := range fields {
+				if logData.Fields == nil {
+					logData.Fields = map[string]any{}
+				}
+				logData.Fields[k] = v
+			}
+		default:
+			if value == "" {
+				continue
+			}
+			if logData.Fields == nil {
+				logData.Fields = map[string]any{}
+			}
+			logData.Fields[name] = value
+		}
+	}
+	return logData, nil
+}
+
+// importTime parses RFC 3339 or "2006-01-02 15:04:05" (UTC) timestamps.
+func importTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateTime, s, time.UTC); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid timestamp: %q", s)
+}
+
+// importLevel parses a level number or name, returning the name with the
+// level for LogData.levelName; unlike parseLevel it rejects names unknown to
+// it and to every level scheme instead of defaulting.
+func importLevel(s string) (int, string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return LevelInfo, "", nil
+	}
+	if level := parseLevel(s, -1); level != -1 {
+		return level, levelText(s), nil
+	}
+	if name := levelText(s); levelSchemes.knownName(name) {
+		return LevelInfo, name, nil
+	}
+	return 0, "", fmt.Errorf("Invalid level: %q", s)
+}
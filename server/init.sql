@@ -0,0 +1,30 @@
+CREATE TABLE logData (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account TEXT NOT NULL,
+    system TEXT NOT NULL,
+    user TEXT NOT NULL,
+    module TEXT NOT NULL,
+    task TEXT NOT NULL,
+    timestamp DATETIME NOT NULL,
+    msg TEXT NOT NULL,
+    level INTEGER NOT NULL,
+    stack_trace TEXT,
+    fields TEXT,
+    trace_id TEXT,
+    span_id TEXT,
+    ulid TEXT,
+    repeat_count INTEGER NOT NULL DEFAULT 1,
+    sampled_rate REAL,
+    fingerprint TEXT,
+    received_at DATETIME,
+    source_seq TEXT,
+    session_id TEXT,
+    expires_at DATETIME
+);
+
+
+CREATE INDEX IF NOT EXISTS idx_account ON logData(account);
+CREATE INDEX IF NOT EXISTS idx_system ON logData(system);
+CREATE INDEX IF NOT EXISTS idx_user ON logData(user);
+CREATE INDEX IF NOT EXISTS idx_trace_id ON logData(trace_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ulid ON logData(ulid);
\ No newline at end of file
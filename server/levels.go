@@ -0,0 +1,63 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Named levels on the numeric scale used by LogData.Level (pino/bunyan style).
+const (
+	LevelTrace = 10
+	LevelDebug = 20
+	LevelInfo  = 30
+	LevelWarn  = 40
+	LevelError = 50
+	LevelFatal = 60
+)
+
+// levelNames maps common level spellings to the numeric scale.
+var levelNames = map[string]int{
+	"trace":    LevelTrace,
+	"debug":    LevelDebug,
+	"dbg":      LevelDebug,
+	"info":     LevelInfo,
+	"notice":   LevelInfo,
+	"warn":     LevelWarn,
+	"warning":  LevelWarn,
+	"error":    LevelError,
+	"err":      LevelError,
+	"critical": LevelFatal,
+	"crit":     LevelFatal,
+	"fatal":    LevelFatal,
+	"panic":    LevelFatal,
+	// syslog spellings
+	"informational": LevelInfo,
+	"alert":         LevelFatal,
+	"emerg":         LevelFatal,
+	"emergency":     LevelFatal,
+}
+
+// parseLevel accepts a level name or number, falling back to def when empty or unknown.
+func parseLevel(s string, def int) int {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return def
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	if level, ok := levelNames[s]; ok {
+		return level
+	}
+	return def
+}
+
+// levelText returns s when it names a level rather than numbering it, for
+// LogData.levelName.
+func levelText(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if _, err := strconv.Atoi(s); err == nil {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const levelSchemesSchema = `CREATE TABLE IF NOT EXISTS level_schemes (
+    account TEXT PRIMARY KEY,
+    preset TEXT,
+    levels TEXT NOT NULL,
+    updated_at DATETIME NOT NULL
+)`
+
+// LevelScheme is an account's own level convention. Levels its producers
+// send are normalized to the canonical scale of LevelTrace..LevelFatal
+// before they are stored, so filters, sampling and alerts compare like with
+// like. Preset names a built-in scheme (see levelPresets) used instead of
+// Levels.
+type LevelScheme struct {
+	Account   string        `json:"account"`
+	Preset    string        `json:"preset,omitempty"`
+	Levels    []SchemeLevel `json:"levels"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// SchemeLevel maps a producer level to Canonical. A numeric level maps
+// through the SchemeLevel with the greatest Value not above it, so a level
+// may stand for a range such as OTel's 9-12 for INFO.
+type SchemeLevel struct {
+	Name      string `json:"name"`
+	Value     int    `json:"value"`
+	Canonical int    `json:"canonical"`
+}
+
+// levelPresets are the schemes PUT /levels accepts by name.
+var levelPresets = map[string][]SchemeLevel{
+	"syslog": {
+		{"emerg", 0, LevelFatal}, {"alert", 1, LevelFatal}, {"crit", 2, LevelFatal}, {"err", 3, LevelError},
+		{"warning", 4, LevelWarn}, {"notice", 5, LevelInfo}, {"info", 6, LevelInfo}, {"debug", 7, LevelDebug},
+	},
+	"otel": {
+		{"trace", 1, LevelTrace}, {"debug", 5, LevelDebug}, {"info", 9, LevelInfo},
+		{"warn", 13, LevelWarn}, {"error", 17, LevelError}, {"fatal", 21, LevelFatal},
+	},
+}
+
+// Validate expands Preset and checks that names and values are unique.
+func (s *LevelScheme) Validate() error {
+	if s.Account == "" {
+		return fmt.Errorf("account is required")
+	}
+	if s.Preset != "" {
+		if len(s.Levels) > 0 {
+			return fmt.Errorf("preset and levels are exclusive")
+		}
+		preset, ok := levelPresets[s.Preset]
+		if !ok {
+			return fmt.Errorf("unknown preset %s", s.Preset)
+		}
+		s.Levels = slices.Clone(preset)
+	}
+	if len(s.Levels) == 0 {
+		return fmt.Errorf("levels or preset required")
+	}
+	names, values := map[string]bool{}, map[int]bool{}
+	for i := range s.Levels {
+		level := &s.Levels[i]
+		level.Name = strings.ToLower(strings.TrimSpace(level.Name))
+		if level.Name == "" || levelText(level.Name) == "" {
+			return fmt.Errorf("level %d: name must not be empty or a number", i)
+		}
+		if names[level.Name] || values[level.Value] {
+			return fmt.Errorf("level %d: duplicate name or value", i)
+		}
+		if level.Canonical < 0 {
+			return fmt.Errorf("level %d: canonical must not be negative", i)
+		}
+		names[level.Name], values[level.Value] = true, true
+	}
+	slices.SortFunc(s.Levels, func(a, b SchemeLevel) int { return a.Value - b.Value })
+	return nil
+}
+
+// canonical maps a numeric level of the scheme, below the lowest Value
+// to the lowest level.
+func (s LevelScheme) canonical(value int) int {
+	level := s.Levels[0]
+	for _, l := range s.Levels {
+		if l.Value > value {
+			break
+		}
+		level = l
+	}
+	return level.Canonical
+}
+
+// levelSchemes holds the level_schemes rows in memory. It is set at startup.
+var levelSchemes *levelSchemeSet
+
+type levelSchemeSet struct {
+	db *sql.DB
+
+	mu        sync.RWMutex
+	byAccount map[string]LevelScheme
+}
+
+func newLevelSchemeSet(db *sql.DB) *levelSchemeSet {
+	return &levelSchemeSet{db: db, byAccount: map[string]LevelScheme{}}
+}
+
+// reload refreshes the in-memory schemes from the database.
+func (s *levelSchemeSet) reload() error {
+	rows, err := s.db.Query("SELECT account, preset, levels, updated_at FROM level_schemes")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byAccount := map[string]LevelScheme{}
+	for rows.Next() {
+		scheme, err := scanLevelScheme(rows.Scan)
+		if err != nil {
+			return err
+		}
+		byAccount[scheme.Account] = scheme
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.byAccount = byAccount
+	s.mu.Unlock()
+	return nil
+}
+
+func scanLevelScheme(scan func(dest ...any) error) (LevelScheme, error) {
+	var scheme LevelScheme
+	var preset sql.NullString
+	var levels string
+	if err := scan(&scheme.Account, &preset, &levels, &scheme.UpdatedAt); err != nil {
+		return scheme, err
+	}
+	scheme.Preset = preset.String
+	if err := json.Unmarshal([]byte(levels), &scheme.Levels); err != nil || len(scheme.Levels) == 0 {
+		return scheme, fmt.Errorf("invalid level scheme for account %s", scheme.Account)
+	}
+	return scheme, nil
+}
+
+func (s *levelSchemeSet) scheme(account string) (LevelScheme, bool) {
+	if s == nil {
+		return LevelScheme{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	scheme, ok := s.byAccount[account]
+	return scheme, ok
+}
+
+// normalize maps logData.Level from its account's scheme to the canonical
+// scale. Levels sent by name were already mapped by parseLevel unless the
+// scheme has the name. Accounts without a scheme are left alone.
+func (s *levelSchemeSet) normalize(logData *LogData) {
+	scheme, ok := s.scheme(logData.Account)
+	if !ok {
+		return
+	}
+	if logData.levelName == "" {
+		logData.Level = scheme.canonical(logData.Level)
+		return
+	}
+	for _, level := range scheme.Levels {
+		if level.Name == logData.levelName {
+			logData.Level = level.Canonical
+			return
+		}
+	}
+}
+
+// parse resolves a level filter: a canonical number, a name from account's
+// scheme or a name known to parseLevel. It reports false for unknown names.
+func (s *levelSchemeSet) parse(account, text string) (int, bool) {
+	name := levelText(text)
+	if name == "" {
+		return parseLevel(text, 0), true
+	}
+	if scheme, ok := s.scheme(account); ok {
+		for _, level := range scheme.Levels {
+			if level.Name == name {
+				return level.Canonical, true
+			}
+		}
+	}
+	level, ok := levelNames[name]
+	return level, ok
+}
+
+// knownName reports whether some account's scheme has the level name.
+func (s *levelSchemeSet) knownName(name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, scheme := range s.byAccount {
+		for _, level := range scheme.Levels {
+			if level.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleLevelSchemes serves GET, PUT and DELETE /levels, an account's level
+// scheme.
+func handleLevelSchemes(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := r.URL.Query().Get("account")
+		if account == "" && r.Method != http.MethodPut {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			scheme, err := scanLevelScheme(db.QueryRow("SELECT account, preset, levels, updated_at FROM level_schemes WHERE account = ?", account).Scan)
+			if err == sql.ErrNoRows {
+				writeError(w, http.StatusNotFound, codeNotFound, "Level scheme not found")
+				return
+			} else if err != nil {
+				requestLogger(r).Error("Error loading level scheme", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch level scheme")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(scheme)
+
+		case http.MethodPut:
+			var scheme LevelScheme
+			if err := json.NewDecoder(r.Body).Decode(&scheme); err != nil {
+				requestLogger(r).Warn("Invalid request body", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+				return
+			}
+			if scheme.Account == "" {
+				scheme.Account = account
+			}
+			if err := scheme.Validate(); err != nil {
+				requestLogger(r).Warn("Validation failed", "err", err)
+				writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+				return
+			}
+			if !accountAllowed(r, scheme.Account) {
+				writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+				return
+			}
+			scheme.UpdatedAt = timeNow().UTC()
+			levels, _ := json.Marshal(scheme.Levels)
+			if _, err := db.Exec(`INSERT INTO level_schemes (account, preset, levels, updated_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT (account) DO UPDATE SET preset = excluded.preset, levels = excluded.levels, updated_at = excluded.updated_at`,
+				scheme.Account, sql.NullString{String: scheme.Preset, Valid: scheme.Preset != ""}, string(levels), scheme.UpdatedAt); err != nil {
+				requestLogger(r).Error("Error saving level scheme", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save level scheme")
+				return
+			}
+			if err := levelSchemes.reload(); err != nil {
+				requestLogger(r).Error("Error reloading level schemes", "err", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(scheme)
+
+		case http.MethodDelete:
+			res, err := db.Exec("DELETE FROM level_schemes WHERE account = ?", account)
+			if err != nil {
+				requestLogger(r).Error("Error deleting level scheme", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete level scheme")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Level scheme not found")
+				return
+			}
+			if err := levelSchemes.reload(); err != nil {
+				requestLogger(r).Error("Error reloading level schemes", "err", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Level scheme deleted"})
+
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
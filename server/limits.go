@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FieldError describes one field that failed validation or a payload limit.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// ValidationError lists the fields of an entry that failed validation.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	reasons := make([]string, len(e))
+	for i, f := range e {
+		reasons[i] = f.Field + " " + f.Reason
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// fieldErrors returns the fields of a ValidationError, their names prefixed
+// with prefix, or nil for other errors.
+func fieldErrors(err error, prefix string) []FieldError {
+	var e ValidationError
+	if !errors.As(err, &e) {
+		return nil
+	}
+	details := make([]FieldError, len(e))
+	for i, f := range e {
+		details[i] = FieldError{Field: prefix + f.Field, Reason: f.Reason}
+	}
+	return details
+}
+
+// checkLimits reports fields of logData exceeding the configured lengths,
+// and fields not of the type of their account's custom column.
+func (l LogData) checkLimits(cfg *Config) []FieldError {
+	var errs []FieldError
+	for _, f := range []struct {
+		name  string
+		value string
+	}{
+		{"account", l.Account}, {"system", l.System}, {"user", l.User}, {"module", l.Module},
+		{"task", l.Task}, {"trace_id", l.TraceID}, {"span_id", l.SpanID}, {"session_id", l.SessionID}, {"source_seq", l.SourceSeq},
+	} {
+		if len(f.value) > cfg.Live().MaxFieldLength {
+			errs = append(errs, FieldError{Field: f.name, Reason: fmt.Sprintf("exceeds %d bytes", cfg.Live().MaxFieldLength)})
+		}
+	}
+	if len(l.Msg) > cfg.Live().MaxMsgLength {
+		errs = append(errs, FieldError{Field: "msg", Reason: fmt.Sprintf("exceeds %d bytes", cfg.Live().MaxMsgLength)})
+	}
+	if len(l.StackTrace) > cfg.Live().MaxMsgLength {
+		errs = append(errs, FieldError{Field: "stack_trace", Reason: fmt.Sprintf("exceeds %d bytes", cfg.Live().MaxMsgLength)})
+	}
+	if len(l.Fields) > 0 {
+		if b, err := json.Marshal(l.Fields); err == nil && len(b) > cfg.Live().MaxMsgLength {
+			errs = append(errs, FieldError{Field: "fields", Reason: fmt.Sprintf("exceeds %d bytes when encoded", cfg.Live().MaxMsgLength)})
+		}
+	}
+	return append(errs, customColumns.check(l)...)
+}
+
+// withBodyLimit caps the request body at cfg.Live().MaxBodyBytes. Wrap it inside
+// withGzip so the limit applies to the decompressed body.
+func withBodyLimit(cfg *Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > cfg.Live().MaxBodyBytes {
+			requestLogger(r).Warn("Request body too large", "bytes", r.ContentLength)
+			writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+				fmt.Sprintf("Request body exceeds %d bytes", cfg.Live().MaxBodyBytes), nil)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.Live().MaxBodyBytes)
+		next(w, r)
+	}
+}
+
+// isBodyTooLarge reports whether err came from the withBodyLimit reader.
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
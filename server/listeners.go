@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+)
+
+// ListenerTLS names the files securing one HTTP listener. Connections are
+// plain HTTP when CertFile is empty; with ClientCAFile, clients must present
+// a certificate it signed.
+type ListenerTLS struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// httpListener is one HTTP server: the query listener on QUERY_ADDR and,
+// when INGEST_ADDR is set, the ingest listener.
+type httpListener struct {
+	name string
+	lis  net.Listener
+	srv  *http.Server
+	tls  bool
+}
+
+func newHTTPListener(cfg *Config, name, addr string, t ListenerTLS, handler http.Handler) (*httpListener, error) {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	if t.CertFile != "" {
+		tlsConfig, err := loadListenerTLS(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s listener: %v", name, err)
+		}
+		srv.TLSConfig = tlsConfig
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	return &httpListener{name: name, lis: lis, srv: srv, tls: srv.TLSConfig != nil}, nil
+}
+
+func loadListenerTLS(t ListenerTLS) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if t.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in TLS client CA %s", t.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// serve returns http.ErrServerClosed once the listener is shut down.
+func (l *httpListener) serve() error {
+	slog.Info("Starting server", "listener", l.name, "addr", l.lis.Addr().String(), "tls", l.tls)
+	if l.tls {
+		return l.srv.ServeTLS(l.lis, "", "")
+	}
+	return l.srv.Serve(l.lis)
+}
+
+// url is the listener's base URL on localhost.
+func (l *httpListener) url() string {
+	scheme := "http"
+	if l.tls {
+		scheme = "https"
+	}
+	if tcp, ok := l.lis.Addr().(*net.TCPAddr); ok {
+		return fmt.Sprintf("%s://localhost:%d", scheme, tcp.Port)
+	}
+	return scheme + "://" + l.lis.Addr().String()
+}
+
+// handleNotOnListener answers requests for endpoints served by the other
+// listener.
+func handleNotOnListener() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Not found")
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDHeader carries the request id in both directions; gRPC uses the
+// lower-cased metadata key.
+const requestIDHeader = "X-Request-ID"
+
+type loggerKey struct{}
+
+// logLevel is the process logger's level, which a reload may change.
+var logLevel slog.LevelVar
+
+// newLogger builds the process logger from LOG_FORMAT and LOG_LEVEL.
+func newLogger(cfg *Config) (*slog.Logger, error) {
+	if err := logLevel.UnmarshalText([]byte(cfg.Live().LogLevel)); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q", cfg.Live().LogLevel)
+	}
+	opts := &slog.HandlerOptions{Level: &logLevel}
+	switch cfg.LogFormat {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("LOG_FORMAT must be text or json, got %q", cfg.LogFormat)
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// loggerFrom returns the request-scoped logger stored in ctx, or the default.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// requestLogger returns the logger of r, tagged with its request id.
+func requestLogger(r *http.Request) *slog.Logger {
+	return loggerFrom(r.Context())
+}
+
+// requestID returns a client-supplied id when it is reasonable, else a new one.
+func requestID(supplied string) string {
+	if supplied != "" && len(supplied) <= 128 && !strings.ContainsFunc(supplied, func(c rune) bool { return c < ' ' || c > '~' }) {
+		return supplied
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// statusRecorder captures the response status for the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// withRequestLogging assigns every request an X-Request-ID, propagating the
+// client's when present, and logs method, path, account, status and latency.
+func withRequestLogging(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r.Header.Get(requestIDHeader))
+		w.Header().Set(requestIDHeader, id)
+		logger := slog.Default().With("request_id", id)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), loggerKey{}, logger)))
+
+		account := requestAccount(r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		logger.Info("Request handled", "method", r.Method, "path", r.URL.Path, "account", account,
+			"status", rec.status, "latency", time.Since(start))
+	})
+}
+
+// grpcRequestLogger returns ctx with a request-scoped logger, echoing the id
+// in the response header metadata.
+func grpcRequestLogger(ctx context.Context) (context.Context, *slog.Logger) {
+	id := requestID(metadataValue(ctx, strings.ToLower(requestIDHeader)))
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(requestIDHeader), id))
+	logger := slog.Default().With("request_id", id)
+	return context.WithValue(ctx, loggerKey{}, logger), logger
+}
+
+// logUnary is the gRPC counterpart of withRequestLogging for unary calls.
+func logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, logger := grpcRequestLogger(ctx)
+	resp, err := handler(ctx, req)
+	logger.Info("Request handled", "method", info.FullMethod, "account", metadataValue(ctx, "x-account"),
+		"status", status.Code(err).String(), "latency", time.Since(start))
+	return resp, err
+}
+
+// loggedStream overrides the context of a server stream.
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggedStream) Context() context.Context {
+	return s.ctx
+}
+
+// logStream is the gRPC counterpart of withRequestLogging for streaming calls.
+func logStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, logger := grpcRequestLogger(ss.Context())
+	err := handler(srv, &loggedStream{ServerStream: ss, ctx: ctx})
+	logger.Info("Request handled", "method", info.FullMethod, "account", metadataValue(ctx, "x-account"),
+		"status", status.Code(err).String(), "latency", time.Since(start))
+	return err
+}
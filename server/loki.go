@@ -0,0 +1,271 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/proto"
+
+	"log-server/proto/lokipb"
+)
+
+// lokiStream is one stream of the Loki JSON push format.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	// Values are [unix_nanos_string, line] or [unix_nanos_string, line, {structured metadata}]
+	Values [][]json.RawMessage `json:"values"`
+}
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+// handleLokiPush implements POST /loki/api/v1/push for promtail, Grafana Agent
+// and other Loki clients. The account comes from X-Scope-OrgID (Loki's tenant
+// header) or the "account" label; other labels map to LogData per cfg.
+func handleLokiPush(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if isBodyTooLarge(err) {
+			requestLogger(r).Warn("Request body too large", "err", err)
+			writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+				fmt.Sprintf("Request body exceeds %d bytes", cfg.Live().MaxBodyBytes), nil)
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error reading request body", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to read request body")
+			return
+		}
+
+		var entries []LogData
+		contentType := r.Header.Get("Content-Type")
+		if strings.HasPrefix(contentType, "application/json") {
+			entries, err = decodeLokiJSON(body, cfg)
+		} else {
+			// The snappy header declares the size Decode allocates
+			if n, err := snappy.DecodedLen(body); err == nil && int64(n) > cfg.Live().MaxBodyBytes {
+				requestLogger(r).Warn("Decompressed request body too large", "bytes", n)
+				writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+					fmt.Sprintf("Decompressed request body exceeds %d bytes", cfg.Live().MaxBodyBytes), nil)
+				return
+			}
+			entries, err = decodeLokiProto(body, cfg)
+		}
+		if err != nil {
+			requestLogger(r).Warn("Invalid Loki push request", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+
+		tenant := r.Header.Get("X-Scope-OrgID")
+		accounts := []string{tenant}
+		if tenant == "" {
+			accounts = batchAccounts(entries)
+		}
+		if limit := accountSettings.maxBatchSize(cfg, accounts...); len(entries) > limit {
+			requestLogger(r).Warn("Loki push batch too large", "entries", len(entries))
+			writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+				fmt.Sprintf("Batch exceeds %d entries", limit), nil)
+			return
+		}
+
+		stored := 0
+		for _, logData := range entries {
+			if tenant != "" {
+				logData.Account = tenant
+			}
+			logData.splitStackTrace()
+			if err := logData.Validate(); err != nil {
+				payload, _ := json.Marshal(logData)
+				rejectLog(db, cfg, logData.Account, payload, fmt.Sprintf("Validation failed: %v", err))
+				requestLogger(r).Warn("Skipping invalid Loki entry", "err", err)
+				continue
+			}
+			if errs := logData.checkLimits(cfg); len(errs) > 0 {
+				payload, _ := json.Marshal(logData)
+				rejectLog(db, cfg, logData.Account, payload, "Payload limits exceeded")
+				requestLogger(r).Warn("Skipping oversized Loki entry", "errors", errs)
+				continue
+			}
+			if err := logData.checkClock(cfg); err != nil {
+				payload, _ := json.Marshal(logData)
+				rejectLog(db, cfg, logData.Account, payload, fmt.Sprintf("Validation failed: %v", err))
+				requestLogger(r).Warn("Skipping Loki entry out of time range", "err", err)
+				continue
+			}
+			sources.enrich(&logData, clientAddr(r, cfg))
+			var quotaErr *quotaError
+			if err := insertLogData(db, cfg, &logData); errors.As(err, &quotaErr) {
+				requestLogger(r).Warn("Rejected Loki push", "account", logData.Account, "err", err)
+				writeError(w, quotaErr.status, codeQuotaExceeded, err.Error())
+				return
+			} else if err != nil {
+				requestLogger(r).Error("Error saving log data", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save log data")
+				return
+			}
+			stored++
+		}
+
+		requestLogger(r).Info("Stored Loki entries", "stored", stored, "entries", len(entries))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func decodeLokiJSON(body []byte, cfg *Config) ([]LogData, error) {
+	var req lokiPushRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	var entries []LogData
+	for _, stream := range req.Streams {
+		for _, value := range stream.Values {
+			if len(value) < 2 {
+				return nil, fmt.Errorf("stream value must be [timestamp, line]")
+			}
+			var tsStr, line string
+			if err := json.Unmarshal(value[0], &tsStr); err != nil {
+				return nil, fmt.Errorf("invalid timestamp: %v", err)
+			}
+			if err := json.Unmarshal(value[1], &line); err != nil {
+				return nil, fmt.Errorf("invalid line: %v", err)
+			}
+			ns, err := strconv.ParseInt(tsStr, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %q: %v", tsStr, err)
+			}
+			var metadata map[string]string
+			if len(value) > 2 {
+				if err := json.Unmarshal(value[2], &metadata); err != nil {
+					return nil, fmt.Errorf("invalid structured metadata: %v", err)
+				}
+			}
+			entries = append(entries, lokiEntry(cfg, stream.Stream, metadata, time.Unix(0, ns), line))
+		}
+	}
+	return entries, nil
+}
+
+func decodeLokiProto(body []byte, cfg *Config) ([]LogData, error) {
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy payload: %v", err)
+	}
+	var req lokipb.PushRequest
+	if err := proto.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	var entries []LogData
+	for _, stream := range req.GetStreams() {
+		labels, err := parseLokiLabels(stream.GetLabels())
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range stream.GetEntries() {
+			var metadata map[string]string
+			for _, pair := range entry.GetStructuredMetadata() {
+				if metadata == nil {
+					metadata = map[string]string{}
+				}
+				metadata[pair.GetName()] = pair.GetValue()
+			}
+			entries = append(entries, lokiEntry(cfg, labels, metadata, entry.GetTimestamp().AsTime(), entry.GetLine()))
+		}
+	}
+	return entries, nil
+}
+
+// lokiEntry maps stream labels onto LogData. Labels not mapped to a column,
+// plus structured metadata, are kept in Fields.
+func lokiEntry(cfg *Config, labels, metadata map[string]string, ts time.Time, line string) LogData {
+	fields := map[string]any{}
+	for k, v := range labels {
+		fields[k] = v
+	}
+	for k, v := range metadata {
+		fields[k] = v
+	}
+	take := func(label, def string) string {
+		if v, ok := labels[label]; ok && v != "" {
+			delete(fields, label)
+			return v
+		}
+		return def
+	}
+
+	logData := LogData{
+		Account:   take("account", ""),
+		System:    take(cfg.LokiSystemLabel, "loki"),
+		Module:    take(cfg.LokiModuleLabel, "loki"),
+		User:      take("user", "loki"),
+		Task:      take("task", "loki"),
+		Timestamp: ts,
+		Msg:       line,
+		Level:     LevelInfo,
+	}
+	if level, ok := labels["level"]; ok {
+		logData.Level, logData.levelName = parseLevel(level, LevelInfo), levelText(level)
+		delete(fields, "level")
+	} else if level, ok := metadata["level"]; ok {
+		logData.Level, logData.levelName = parseLevel(level, LevelInfo), levelText(level)
+	}
+	if len(fields) > 0 {
+		logData.Fields = fields
+	}
+	return logData
+}
+
+// parseLokiLabels parses a Prometheus label set such as {job="api", app="a\"b"}.
+func parseLokiLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("invalid labels %q", s)
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	for s != "" {
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid labels: missing '='")
+		}
+		name = strings.TrimSpace(name)
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, `"`) {
+			return nil, fmt.Errorf("invalid labels: value of %s must be quoted", name)
+		}
+		// Find the closing quote, skipping escaped characters
+		end := 1
+		for end < len(rest) && rest[end] != '"' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return nil, fmt.Errorf("invalid labels: unterminated value of %s", name)
+		}
+		value, err := strconv.Unquote(rest[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels: %v", err)
+		}
+		labels[name] = value
+		s = strings.TrimPrefix(strings.TrimSpace(rest[end+1:]), ",")
+		s = strings.TrimSpace(s)
+	}
+	return labels, nil
+}
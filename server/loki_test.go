@@ -0,0 +1,20 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"log-server/server/testutil"
+)
+
+func TestLokiPushSnappyLengthLimited(t *testing.T) {
+	srv := testutil.NewServer(t, nil)
+	// A snappy header declaring 4 GiB - 1 bytes and nothing else
+	body := string([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+	rec := serve(srv, http.MethodPost, "/loki/api/v1/push", body, map[string]string{
+		"X-Scope-OrgID": "acme", "Content-Type": "application/x-protobuf",
+	})
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("POST /loki/api/v1/push: %d %s, want 413", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,860 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxLokiPoints bounds the steps of a metric query, as Loki does.
+	maxLokiPoints = 11000
+	// maxLokiBuckets bounds the time buckets a metric query counts entries in.
+	maxLokiBuckets = 100000
+	// maxLokiLabelValues bounds the values listed for a label.
+	maxLokiLabelValues = 1000
+)
+
+// logQL is a query in the subset of LogQL served by the Loki query API: a
+// stream selector with an optional line filter, e.g. {app="api"} |= "timeout",
+// alone or counted by count_over_time or rate over a window and optionally
+// summed by labels. Constant sums of vector(n), which Grafana sends as a
+// health check, are also accepted.
+type logQL struct {
+	params QueryParams
+	// metric is count_over_time or rate, empty for log queries.
+	metric string
+	window time.Duration
+	// by lists the labels a sum groups by; without a sum every stream
+	// label is kept.
+	sum bool
+	by  []string
+	// constant is set for vector(n) sums.
+	constant *float64
+}
+
+// lokiLabels maps the stream labels of entries to their logData columns.
+// The system and module labels follow the push API's configuration.
+func lokiLabels(cfg *Config) map[string]string {
+	return map[string]string{
+		cfg.LokiSystemLabel: "system",
+		cfg.LokiModuleLabel: "module",
+		"user":              "user",
+		"task":              "task",
+		"level":             "level",
+	}
+}
+
+// lokiLevelLabel names a canonical level for the level label, as Grafana
+// colors log lines by it.
+func lokiLevelLabel(level int) string {
+	switch level {
+	case LevelTrace:
+		return "trace"
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	case LevelFatal:
+		return "critical"
+	}
+	return strconv.Itoa(level)
+}
+
+// streamLabels returns the stream labels of an entry.
+func streamLabels(cfg *Config, logData LogData) map[string]string {
+	return map[string]string{
+		cfg.LokiSystemLabel: logData.System,
+		cfg.LokiModuleLabel: logData.Module,
+		"user":              logData.User,
+		"task":              logData.Task,
+		"level":             lokiLevelLabel(logData.Level),
+	}
+}
+
+type logQLParser struct {
+	s   string
+	pos int
+}
+
+func (p *logQLParser) skipSpace() {
+	for p.pos < len(p.s) && strings.ContainsRune(" \t\r\n", rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips token and reports whether it was next.
+func (p *logQLParser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.s[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *logQLParser) expect(token string) error {
+	if !p.consume(token) {
+		return fmt.Errorf("expected %s at position %d", token, p.pos)
+	}
+	return nil
+}
+
+var logQLIdentRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+
+func (p *logQLParser) ident() string {
+	p.skipSpace()
+	name := logQLIdentRe.FindString(p.s[p.pos:])
+	p.pos += len(name)
+	return name
+}
+
+// str reads a double-quoted or backtick string literal.
+func (p *logQLParser) str() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) || (p.s[p.pos] != '"' && p.s[p.pos] != '`') {
+		return "", fmt.Errorf("expected a string at position %d", p.pos)
+	}
+	quote, end := p.s[p.pos], p.pos+1
+	for end < len(p.s) && p.s[end] != quote {
+		if p.s[end] == '\\' && quote == '"' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.s) {
+		return "", fmt.Errorf("unterminated string at position %d", p.pos)
+	}
+	value, err := strconv.Unquote(p.s[p.pos : end+1])
+	if err != nil {
+		return "", fmt.Errorf("invalid string at position %d", p.pos)
+	}
+	p.pos = end + 1
+	return value, nil
+}
+
+// labelList reads a parenthesized list of label names.
+func (p *logQLParser) labelList() ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var labels []string
+	for !p.consume(")") {
+		if len(labels) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		name := p.ident()
+		if name == "" {
+			return nil, fmt.Errorf("expected a label name at position %d", p.pos)
+		}
+		labels = append(labels, name)
+	}
+	return labels, nil
+}
+
+// parseLogQL parses query for account, resolving level names with the
+// account's level scheme.
+func parseLogQL(cfg *Config, account, query string) (logQL, error) {
+	p := &logQLParser{s: query}
+	q := logQL{params: QueryParams{Account: account, Fields: map[string]string{}}}
+	var err error
+	switch {
+	case strings.HasPrefix(strings.TrimSpace(query), "vector"):
+		err = p.constant(&q)
+	case p.consume("sum"):
+		q.sum = true
+		if p.consume("by") {
+			if q.by, err = p.labelList(); err != nil {
+				return q, err
+			}
+		}
+		if err := p.expect("("); err != nil {
+			return q, err
+		}
+		if err := p.rangeAggregation(cfg, &q); err != nil {
+			return q, err
+		}
+		if err := p.expect(")"); err != nil {
+			return q, err
+		}
+		if q.by == nil && p.consume("by") {
+			q.by, err = p.labelList()
+		}
+	default:
+		err = p.rangeAggregation(cfg, &q)
+	}
+	if err != nil {
+		return q, err
+	}
+	if p.skipSpace(); p.pos < len(p.s) {
+		return q, fmt.Errorf("unexpected %s at position %d", p.s[p.pos:], p.pos)
+	}
+	labels := lokiLabels(cfg)
+	for _, label := range q.by {
+		if _, ok := labels[label]; !ok {
+			return q, fmt.Errorf("cannot group by %s; stream labels are %s", label, strings.Join(sortedKeys(labels), ", "))
+		}
+	}
+	return q, nil
+}
+
+// constant reads vector(n) (+ vector(n))*.
+func (p *logQLParser) constant(q *logQL) error {
+	var sum float64
+	for {
+		if err := p.expect("vector"); err != nil {
+			return err
+		}
+		if err := p.expect("("); err != nil {
+			return err
+		}
+		p.skipSpace()
+		end := strings.IndexByte(p.s[p.pos:], ')')
+		if end < 0 {
+			return fmt.Errorf("expected ) at position %d", p.pos)
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(p.s[p.pos:p.pos+end]), 64)
+		if err != nil {
+			return fmt.Errorf("vector() takes a number")
+		}
+		p.pos += end + 1
+		sum += n
+		if !p.consume("+") {
+			q.constant = &sum
+			return nil
+		}
+	}
+}
+
+// rangeAggregation reads count_over_time(selector [window]), rate(...) or
+// a bare selector.
+func (p *logQLParser) rangeAggregation(cfg *Config, q *logQL) error {
+	start := p.pos
+	name := p.ident()
+	if name != "count_over_time" && name != "rate" {
+		if name != "" {
+			return fmt.Errorf("unsupported function %s; use count_over_time or rate", name)
+		}
+		p.pos = start
+		return p.selector(cfg, q)
+	}
+	q.metric = name
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	if err := p.selector(cfg, q); err != nil {
+		return err
+	}
+	if err := p.expect("["); err != nil {
+		return err
+	}
+	end := strings.IndexByte(p.s[p.pos:], ']')
+	if end < 0 {
+		return fmt.Errorf("expected ] at position %d", p.pos)
+	}
+	window, err := parseLogQLDuration(strings.TrimSpace(p.s[p.pos : p.pos+end]))
+	if err != nil || window < time.Second {
+		return fmt.Errorf("invalid range %s: must be a duration of at least 1s", p.s[p.pos:p.pos+end])
+	}
+	q.window = window
+	p.pos += end + 1
+	return p.expect(")")
+}
+
+// selector reads {matchers} and line filters into q.params.
+func (p *logQLParser) selector(cfg *Config, q *logQL) error {
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for first := true; !p.consume("}"); first = false {
+		if !first {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+		label := p.ident()
+		if label == "" {
+			return fmt.Errorf("expected a label name at position %d", p.pos)
+		}
+		var op string
+		for _, candidate := range []string{"=~", "!~", "!=", "="} {
+			if p.consume(candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return fmt.Errorf("expected a matcher after %s", label)
+		}
+		value, err := p.str()
+		if err != nil {
+			return err
+		}
+		if err := q.match(cfg, label, op, value); err != nil {
+			return err
+		}
+	}
+	for {
+		var op string
+		for _, candidate := range []string{"|=", "|~", "!=", "!~"} {
+			if p.consume(candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return nil
+		}
+		value, err := p.str()
+		if err != nil {
+			return err
+		}
+		if op == "!=" || op == "!~" {
+			return fmt.Errorf("unsupported line filter %s; use |= or |~", op)
+		}
+		if q.params.MsgRegex != "" {
+			return fmt.Errorf("only one line filter is supported")
+		}
+		if op == "|=" {
+			value = regexp.QuoteMeta(value)
+		}
+		if _, err := compileMsgRegex(value); err != nil {
+			return err
+		}
+		q.params.MsgRegex = value
+	}
+}
+
+// match applies one label matcher. Stream labels filter their column and
+// other labels structured fields; only equality is supported, besides the
+// =~".*" and =~".+" matchers Grafana uses for any value.
+func (q *logQL) match(cfg *Config, label, op, value string) error {
+	if op == "=~" && (value == ".*" || value == ".+") {
+		return nil
+	}
+	if op != "=" {
+		return fmt.Errorf("unsupported matcher %s%s; use =", label, op)
+	}
+	switch lokiLabels(cfg)[label] {
+	case "system":
+		q.params.System = value
+	case "module":
+		q.params.Module = value
+	case "user":
+		q.params.User = value
+	case "task":
+		q.params.Task = value
+	case "level":
+		level, ok := levelSchemes.parse(q.params.Account, value)
+		if !ok {
+			return fmt.Errorf("invalid level: %s", value)
+		}
+		q.params.Level = &level
+	default:
+		if !fieldNameRe.MatchString(label) {
+			return fmt.Errorf("invalid label name: %s", label)
+		}
+		q.params.Fields[label] = value
+	}
+	return nil
+}
+
+// parseLogQLDuration accepts Go durations and the d and w suffixes of
+// Prometheus durations.
+func parseLogQLDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// parseLokiTime parses a Loki time parameter: unix nanoseconds, unix
+// seconds with up to 10 digits or a fraction, or RFC 3339.
+func parseLokiTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if strings.Contains(s, ".") {
+		if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+			whole, frac := math.Modf(seconds)
+			return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+		}
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if len(s) <= 10 {
+			return time.Unix(n, 0).UTC(), nil
+		}
+		return time.Unix(0, n).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %s: use unix nanoseconds, seconds or RFC 3339", s)
+	}
+	return t.UTC(), nil
+}
+
+// lokiSeries is one metric series of a matrix or vector result.
+type lokiSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]any          `json:"values,omitempty"`
+	Value  *[2]any           `json:"value,omitempty"`
+}
+
+// lokiResult is a Loki query response: streams of [ns, line] values, or
+// metric series of [seconds, "value"] points.
+type lokiResult struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     any    `json:"result"`
+	} `json:"data"`
+}
+
+func writeLoki(w http.ResponseWriter, resultType string, result any) {
+	var res lokiResult
+	res.Status = "success"
+	res.Data.ResultType, res.Data.Result = resultType, result
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// lokiAccount returns the account of a Loki query, Loki's X-Scope-OrgID
+// tenant when no other account is given, rejecting requests without one
+// and cross-account requests without the admin token.
+func lokiAccount(w http.ResponseWriter, r *http.Request, cfg *Config) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		requestLogger(r).Warn("Method not allowed", "method", r.Method)
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return "", false
+	}
+	account := requestAccount(r)
+	if account == "" {
+		requestLogger(r).Warn("Missing account")
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "X-Scope-OrgID header or account query parameter required")
+		return "", false
+	}
+	if account == allAccounts && !isAdmin(r, cfg) {
+		requestLogger(r).Warn("Cross-account query denied")
+		writeError(w, http.StatusForbidden, codeForbidden, "Admin token required for cross-account queries")
+		return "", false
+	}
+	return account, true
+}
+
+// handleLokiQueryRange implements GET /loki/api/v1/query_range, so Grafana's
+// Loki datasource can show log panels and chart log volumes.
+func handleLokiQueryRange(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := lokiAccount(w, r, cfg)
+		if !ok {
+			return
+		}
+		r.ParseForm()
+		form := r.Form
+		q, err := parseLogQL(cfg, account, form.Get("query"))
+		if err != nil {
+			// Queries quote strings, so the error is encoded rather than formatted
+			writeErrorDetails(w, http.StatusBadRequest, codeInvalidRequest, "Invalid query: "+err.Error(), nil)
+			return
+		}
+		now := timeNow().UTC()
+		end, err := parseLokiTime(form.Get("end"), now)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid end: %v", err))
+			return
+		}
+		start, err := parseLokiTime(form.Get("start"), end.Add(-time.Hour))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid start: %v", err))
+			return
+		}
+		if end.Before(start) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "end must not be before start")
+			return
+		}
+
+		if q.metric == "" && q.constant == nil {
+			q.params.StartTime, q.params.EndTime = start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano)
+			queryLokiStreams(w, r, db, cfg, q, form.Get("limit"), form.Get("direction"))
+			return
+		}
+
+		step := time.Duration(max(int64(end.Sub(start).Seconds())/250, 1)) * time.Second
+		if text := form.Get("step"); text != "" {
+			if step, err = parseLogQLDuration(text); err != nil {
+				seconds, ferr := strconv.ParseFloat(text, 64)
+				if ferr != nil {
+					writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid step: must be a duration or seconds")
+					return
+				}
+				step = time.Duration(seconds * float64(time.Second))
+			}
+		}
+		step = step.Truncate(time.Second)
+		if step < time.Second {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "step must be at least 1s")
+			return
+		}
+		if end.Sub(start)/step >= maxLokiPoints {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Range exceeds %d points; use a larger step or a shorter range", maxLokiPoints))
+			return
+		}
+		series, ok := evalLokiMetric(w, r, db, cfg, q, start, end, step)
+		if !ok {
+			return
+		}
+		writeLoki(w, "matrix", series)
+	}
+}
+
+// handleLokiQuery implements GET /loki/api/v1/query for metric queries,
+// evaluated at time (default now).
+func handleLokiQuery(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := lokiAccount(w, r, cfg)
+		if !ok {
+			return
+		}
+		r.ParseForm()
+		q, err := parseLogQL(cfg, account, r.Form.Get("query"))
+		if err != nil {
+			// Queries quote strings, so the error is encoded rather than formatted
+			writeErrorDetails(w, http.StatusBadRequest, codeInvalidRequest, "Invalid query: "+err.Error(), nil)
+			return
+		}
+		if q.metric == "" && q.constant == nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Log queries are not supported as instant queries; use query_range")
+			return
+		}
+		at, err := parseLokiTime(r.Form.Get("time"), timeNow().UTC())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid time: %v", err))
+			return
+		}
+		series, ok := evalLokiMetric(w, r, db, cfg, q, at, at, max(q.window, time.Second))
+		if !ok {
+			return
+		}
+		for i := range series {
+			series[i].Value, series[i].Values = &series[i].Values[0], nil
+		}
+		writeLoki(w, "vector", series)
+	}
+}
+
+// queryLokiStreams answers a log query with its entries grouped in streams
+// by their labels, newest first unless direction is forward.
+func queryLokiStreams(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *Config, q logQL, limit, direction string) {
+	if limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid limit: must be a positive integer")
+			return
+		}
+		q.params.Limit = &n
+	} else {
+		n := int64(100)
+		q.params.Limit = &n
+	}
+	if !limitQueryRows(w, r, cfg, &q.params) || !allowedBySettings(w, r, accountSettings.checkQuery(q.params)) {
+		return
+	}
+	q.params.Direction = "desc"
+	if strings.EqualFold(direction, "forward") {
+		q.params.Direction = "asc"
+	}
+
+	ctx, cancel := queryContext(r, cfg)
+	defer cancel()
+	type lokiQueryStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	streams := []*lokiQueryStream{}
+	byLabels := map[string]*lokiQueryStream{}
+	count := 0
+	err := queryEntries(ctx, db, q.params, func(logData LogData) error {
+		labels := streamLabels(cfg, logData)
+		key := labelsKey(labels)
+		stream, ok := byLabels[key]
+		if !ok {
+			stream = &lokiQueryStream{Stream: labels}
+			byLabels[key] = stream
+			streams = append(streams, stream)
+		}
+		line := logData.Msg
+		if logData.StackTrace != "" {
+			line += "\n" + logData.StackTrace
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(logData.Timestamp.UnixNano(), 10), line})
+		count++
+		return nil
+	})
+	if queryAborted(w, r, cfg, err) {
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error querying log data", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch log data")
+		return
+	}
+	recordRead(db, r, cfg, "query", q.params.Account, count)
+	writeLoki(w, "streams", streams)
+}
+
+// evalLokiMetric evaluates a metric query at each step from start to end,
+// each point counting the entries in (t-window, t]. Entries are counted in
+// buckets of the greatest common divisor of step and window, aligned to
+// start, so each point sums the buckets of its window.
+// Points without entries are omitted, as in Loki.
+func evalLokiMetric(w http.ResponseWriter, r *http.Request, db *sql.DB, cfg *Config, q logQL, start, end time.Time, step time.Duration) ([]lokiSeries, bool) {
+	series := []lokiSeries{}
+	if q.constant != nil {
+		s := lokiSeries{Metric: map[string]string{}}
+		for t := start; !t.After(end); t = t.Add(step) {
+			s.Values = append(s.Values, lokiPoint(t, *q.constant))
+		}
+		return append(series, s), true
+	}
+
+	stepSecs, windowSecs := int64(step.Seconds()), int64(q.window.Seconds())
+	bucket := gcd(stepSecs, windowSecs)
+	if (end.Unix()-start.Unix()+windowSecs)/bucket >= maxLokiBuckets {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Range too fine for the step and window; align them or use a shorter range")
+		return nil, false
+	}
+	labels := q.by
+	if !q.sum {
+		labels = sortedKeys(lokiLabels(cfg))
+	}
+	columns := make([]string, len(labels))
+	for i, label := range labels {
+		columns[i] = lokiLabels(cfg)[label]
+	}
+
+	from := start.Add(-q.window)
+	q.params.StartTime, q.params.EndTime = from.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano)
+	where, args := buildLogFilter(q.params)
+	offset := start.Unix() % bucket
+	groupBy := "bucket"
+	selectColumns := ""
+	for _, column := range columns {
+		selectColumns += column + ", "
+		groupBy += ", " + column
+	}
+	// Bucket b holds the entries in (b-bucket, b], as windows end inclusively
+	sqlQuery := fmt.Sprintf(`SELECT %s(CAST(strftime('%%s', timestamp) AS INTEGER) - %d + %d) / %d AS bucket, SUM(repeat_count)
+		FROM %s WHERE %s GROUP BY %s`, selectColumns, offset, bucket-1, bucket, logDataSource(from, end), where, groupBy)
+
+	ctx, cancel := queryContext(r, cfg)
+	defer cancel()
+	var rowsRead int64
+	defer slowQueries.observe(sqlQuery, args, time.Now(), &rowsRead)
+	rows, err := db.QueryContext(ctx, sqlQuery, args...)
+	if queryAborted(w, r, cfg, err) {
+		return nil, false
+	}
+	if err != nil {
+		requestLogger(r).Error("Error querying log volume", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch log volume")
+		return nil, false
+	}
+	defer rows.Close()
+
+	type group struct {
+		labels map[string]string
+		counts map[int64]int64
+	}
+	var groups []*group
+	byKey := map[string]*group{}
+	for rows.Next() {
+		rowsRead++
+		values := make([]any, len(columns))
+		dest := make([]any, len(columns)+2)
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		var b, count int64
+		dest[len(columns)], dest[len(columns)+1] = &b, &count
+		if err := rows.Scan(dest...); err != nil {
+			requestLogger(r).Error("Error scanning row", "err", err)
+			continue
+		}
+		metric := map[string]string{}
+		for i, label := range labels {
+			if columns[i] == "level" {
+				level, _ := values[i].(int64)
+				metric[label] = lokiLevelLabel(int(level))
+			} else {
+				metric[label] = fmt.Sprint(values[i])
+			}
+		}
+		key := labelsKey(metric)
+		g, ok := byKey[key]
+		if !ok {
+			g = &group{labels: metric, counts: map[int64]int64{}}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		// Levels sharing a label name, e.g. custom levels, add up
+		g.counts[offset+b*bucket] += count
+	}
+	if err := rows.Err(); err != nil {
+		if !queryAborted(w, r, cfg, err) {
+			requestLogger(r).Error("Error reading log volume", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch log volume")
+		}
+		return nil, false
+	}
+
+	for _, g := range groups {
+		s := lokiSeries{Metric: g.labels}
+		for t := start.Unix(); t <= end.Unix(); t += stepSecs {
+			var count int64
+			for b := t - windowSecs + bucket; b <= t; b += bucket {
+				count += g.counts[b]
+			}
+			if count == 0 {
+				continue
+			}
+			value := float64(count)
+			if q.metric == "rate" {
+				value /= q.window.Seconds()
+			}
+			s.Values = append(s.Values, lokiPoint(time.Unix(t, 0), value))
+		}
+		if len(s.Values) > 0 {
+			series = append(series, s)
+		}
+	}
+	sort.Slice(series, func(i, j int) bool { return labelsKey(series[i].Metric) < labelsKey(series[j].Metric) })
+	return series, true
+}
+
+func lokiPoint(t time.Time, value float64) [2]any {
+	return [2]any{float64(t.UnixNano()) / 1e9, strconv.FormatFloat(value, 'f', -1, 64)}
+}
+
+// handleLokiLabels implements GET /loki/api/v1/labels, the stream label
+// names.
+func handleLokiLabels(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := lokiAccount(w, r, cfg); !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"status": "success", "data": sortedKeys(lokiLabels(cfg))})
+	}
+}
+
+// handleLokiLabelValues implements GET /loki/api/v1/label/{name}/values,
+// the values of a stream label between start and end (default the last
+// hour).
+func handleLokiLabelValues(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := lokiAccount(w, r, cfg)
+		if !ok {
+			return
+		}
+		name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/loki/api/v1/label/"), "/values")
+		column, known := lokiLabels(cfg)[name]
+		if !ok || !known {
+			writeError(w, http.StatusNotFound, codeNotFound, "Unknown label")
+			return
+		}
+		r.ParseForm()
+		end, err := parseLokiTime(r.Form.Get("end"), timeNow().UTC())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid end: %v", err))
+			return
+		}
+		start, err := parseLokiTime(r.Form.Get("start"), end.Add(-time.Hour))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid start: %v", err))
+			return
+		}
+
+		params := QueryParams{Account: account, StartTime: start.Format(time.RFC3339Nano), EndTime: end.Format(time.RFC3339Nano)}
+		where, args := buildLogFilter(params)
+		sqlQuery := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s ORDER BY 1 LIMIT %d", column, logDataSource(start, end), where, maxLokiLabelValues)
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		var rowsRead int64
+		defer slowQueries.observe(sqlQuery, args, time.Now(), &rowsRead)
+		rows, err := db.QueryContext(ctx, sqlQuery, args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying label values", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch label values")
+			return
+		}
+		defer rows.Close()
+		values := []string{}
+		for rows.Next() {
+			rowsRead++
+			var value any
+			if err := rows.Scan(&value); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			if level, isLevel := value.(int64); isLevel && column == "level" {
+				value = lokiLevelLabel(int(level))
+			}
+			if text := fmt.Sprint(value); !slices.Contains(values, text) {
+				values = append(values, text)
+			}
+		}
+		if queryAborted(w, r, cfg, rows.Err()) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"status": "success", "data": values})
+	}
+}
+
+// labelsKey identifies a label set.
+func labelsKey(labels map[string]string) string {
+	var key strings.Builder
+	for _, name := range sortedKeys(labels) {
+		fmt.Fprintf(&key, "%s=%q,", name, labels[name])
+	}
+	return key.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
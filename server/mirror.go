@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// mirrorQueueSize bounds the entries waiting to be published; entries
+	// beyond it are dropped.
+	mirrorQueueSize    = 10000
+	mirrorBatchSize    = 500
+	mirrorMaxAttempts  = 5
+	mirrorPublishLimit = 10 * time.Second
+)
+
+// mirror republishes stored entries to a broker for downstream consumers,
+// in batches from a bounded queue.
+type mirror struct {
+	name    string
+	cfg     *Config
+	queue   chan LogData
+	publish func(ctx context.Context, entries []LogData) error
+}
+
+func newMirror(name string, cfg *Config, publish func(context.Context, []LogData) error) *mirror {
+	m := &mirror{name: name, cfg: cfg, queue: make(chan LogData, mirrorQueueSize), publish: publish}
+	go m.run()
+	return m
+}
+
+// newKafkaMirror publishes to cfg.MirrorKafkaTopic, keyed by account so an
+// account's entries keep their order within a partition.
+func newKafkaMirror(cfg *Config) *mirror {
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(cfg.MirrorKafkaBrokers...),
+		Topic:        cfg.MirrorKafkaTopic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+		BatchTimeout: 100 * time.Millisecond,
+	}
+	return newMirror("kafka", cfg, func(ctx context.Context, entries []LogData) error {
+		msgs := make([]kafka.Message, 0, len(entries))
+		for _, logData := range entries {
+			value, err := json.Marshal(logData)
+			if err != nil {
+				return err
+			}
+			msgs = append(msgs, kafka.Message{Key: []byte(logData.Account), Value: value})
+		}
+		return writer.WriteMessages(ctx, msgs...)
+	})
+}
+
+// newNATSMirror publishes to cfg.MirrorNATSSubject. Streams capturing the
+// subject store the entries for JetStream consumers.
+func newNATSMirror(cfg *Config) (*mirror, error) {
+	nc, err := nats.Connect(cfg.MirrorNATSURL, nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true))
+	if err != nil {
+		return nil, err
+	}
+	return newMirror("nats", cfg, func(ctx context.Context, entries []LogData) error {
+		for _, logData := range entries {
+			value, err := json.Marshal(logData)
+			if err != nil {
+				return err
+			}
+			if err := nc.Publish(cfg.MirrorNATSSubject, value); err != nil {
+				return err
+			}
+		}
+		return nc.FlushWithContext(ctx)
+	}), nil
+}
+
+// mirrored reports whether logData passes MIRROR_ACCOUNTS and
+// MIRROR_MIN_LEVEL.
+func mirrored(cfg *Config, logData LogData) bool {
+	return logData.Level >= cfg.MirrorMinLevel &&
+		(len(cfg.MirrorAccounts) == 0 || slices.Contains(cfg.MirrorAccounts, logData.Account))
+}
+
+// enqueue queues logData when it passes the mirror filter. It is an insert
+// hook.
+func (m *mirror) enqueue(logData LogData) {
+	if !mirrored(m.cfg, logData) {
+		return
+	}
+	select {
+	case m.queue <- logData:
+	default:
+		slog.Warn("Mirror queue full, dropping entry", "mirror", m.name, "account", logData.Account)
+	}
+}
+
+// run publishes queued entries in batches, retrying a failed batch with
+// exponential backoff before dropping it.
+func (m *mirror) run() {
+	for logData := range m.queue {
+		batch := []LogData{logData}
+	fill:
+		for len(batch) < mirrorBatchSize {
+			select {
+			case next := <-m.queue:
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+
+		delay := time.Second
+		for attempt := 1; ; attempt++ {
+			ctx, cancel := context.WithTimeout(context.Background(), mirrorPublishLimit)
+			err := m.publish(ctx, batch)
+			cancel()
+			if err == nil {
+				break
+			}
+			if attempt >= mirrorMaxAttempts {
+				slog.Error("Giving up mirroring entries", "mirror", m.name, "entries", len(batch), "attempts", attempt, "err", err)
+				break
+			}
+			slog.Warn("Mirroring entries failed, retrying", "mirror", m.name, "attempt", attempt, "delay", delay, "err", err)
+			time.Sleep(delay)
+			delay = min(delay*2, webhookMaxDelay)
+		}
+	}
+}
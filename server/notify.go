@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// notifyClient is used for all outgoing notification requests.
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON sends payload as a JSON POST and fails on non-2xx responses.
+func postJSON(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// sendEmail delivers a plain-text message through the configured SMTP server.
+func sendEmail(cfg *Config, to []string, subject, body string) error {
+	return sendEmailMessage(cfg, to, subject, "text/plain; charset=utf-8", body)
+}
+
+// emailAttachment is a file attached to an email.
+type emailAttachment struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// sendEmailMessage delivers a body of contentType through the configured
+// SMTP server, as a multipart message when there are attachments.
+func sendEmailMessage(cfg *Config, to []string, subject, contentType, body string, attachments ...emailAttachment) error {
+	if cfg.SMTPAddr == "" {
+		return fmt.Errorf("SMTP_ADDR not configured")
+	}
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		host, _, _ := strings.Cut(cfg.SMTPAddr, ":")
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n",
+		cfg.SMTPFrom, strings.Join(to, ", "), mime.QEncoding.Encode("utf-8", subject))
+	if len(attachments) == 0 {
+		fmt.Fprintf(&msg, "Content-Type: %s\r\n\r\n%s", contentType, body)
+		return smtp.SendMail(cfg.SMTPAddr, auth, cfg.SMTPFrom, to, msg.Bytes())
+	}
+
+	parts := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", parts.Boundary())
+	part, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+	if err != nil {
+		return err
+	}
+	io.WriteString(part, body)
+	for _, a := range attachments {
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.name})},
+		})
+		if err != nil {
+			return err
+		}
+		encoded := base64.StdEncoding.EncodeToString(a.data)
+		// RFC 2045 limits encoded lines to 76 characters
+		for len(encoded) > 76 {
+			io.WriteString(part, encoded[:76]+"\r\n")
+			encoded = encoded[76:]
+		}
+		io.WriteString(part, encoded+"\r\n")
+	}
+	if err := parts.Close(); err != nil {
+		return err
+	}
+	return smtp.SendMail(cfg.SMTPAddr, auth, cfg.SMTPFrom, to, msg.Bytes())
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// jwksRefreshInterval is how often the signing keys are refetched.
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefetch bounds refetches triggered by tokens with unknown key ids.
+	jwksMinRefetch = time.Minute
+)
+
+// oidc is set when OIDC_ISSUER is configured. Bearer tokens that are not
+// static account tokens are then validated as JWTs from the issuer.
+var oidc *oidcVerifier
+
+// oidcPrincipal is what a validated JWT grants.
+type oidcPrincipal struct {
+	Subject string
+	Account string
+	Scopes  []string
+}
+
+type oidcVerifier struct {
+	cfg    *Config
+	client *http.Client
+
+	mu      sync.Mutex
+	jwksURL string
+	keys    map[string]any
+	fetched time.Time
+}
+
+func newOIDCVerifier(cfg *Config) *oidcVerifier {
+	return &oidcVerifier{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}, jwksURL: cfg.OIDCJWKSURL}
+}
+
+// looksLikeJWT reports whether token has the three dot-separated parts of a
+// compact JWT.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// verify validates token's signature, issuer, audience and expiry, and maps
+// its claims to an account and scopes.
+func (v *oidcVerifier) verify(token string) (oidcPrincipal, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithIssuer(v.cfg.OIDCIssuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+	}
+	if v.cfg.OIDCAudience != "" {
+		opts = append(opts, jwt.WithAudience(v.cfg.OIDCAudience))
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, v.key, opts...); err != nil {
+		return oidcPrincipal{}, err
+	}
+
+	var p oidcPrincipal
+	p.Subject, _ = claims["sub"].(string)
+	p.Account, _ = claimValue(claims, v.cfg.OIDCAccountClaim).(string)
+	if p.Account == "" {
+		return oidcPrincipal{}, fmt.Errorf("token has no %s claim", v.cfg.OIDCAccountClaim)
+	}
+	var values []string
+	switch scopes := claimValue(claims, v.cfg.OIDCScopesClaim).(type) {
+	case string:
+		values = strings.Fields(scopes)
+	case []any:
+		for _, scope := range scopes {
+			if s, ok := scope.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	for _, value := range values {
+		scope, ok := strings.CutPrefix(value, v.cfg.OIDCScopePrefix)
+		if ok && (scope == scopeIngest || scope == scopeRead || scope == scopeAdmin) {
+			p.Scopes = append(p.Scopes, scope)
+		}
+	}
+	return p, nil
+}
+
+// claimValue looks up a claim by a dotted path such as
+// "realm_access.roles".
+func claimValue(claims jwt.MapClaims, path string) any {
+	var value any = map[string]any(claims)
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// key returns the issuer's key that signed token, refetching the key set
+// when it is stale or does not have the token's key id.
+func (v *oidcVerifier) key(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok := v.keys[kid]
+	if ok && time.Since(v.fetched) < jwksRefreshInterval {
+		return key, nil
+	}
+	if !ok && time.Since(v.fetched) < jwksMinRefetch {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	if err := v.fetchKeys(); err != nil {
+		slog.Error("Error fetching OIDC signing keys", "err", err)
+		if ok {
+			// Keep accepting known keys while the issuer is unreachable
+			return key, nil
+		}
+		return nil, errors.New("signing keys unavailable")
+	}
+	if key, ok = v.keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	return key, nil
+}
+
+// fetchKeys loads the issuer's JSON Web Key Set, discovering its URL from
+// the issuer's OpenID configuration unless OIDC_JWKS_URL is set. Callers
+// hold v.mu.
+func (v *oidcVerifier) fetchKeys() error {
+	v.fetched = time.Now()
+	if v.jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(strings.TrimSuffix(v.cfg.OIDCIssuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return fmt.Errorf("discovery failed: %v", err)
+		}
+		if discovery.JWKSURI == "" {
+			return fmt.Errorf("discovery document has no jwks_uri")
+		}
+		v.jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(v.jwksURL, &set); err != nil {
+		return err
+	}
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			curve := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}[k.Crv]
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if curve == nil || errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no usable signing keys at %s", v.jwksURL)
+	}
+	v.keys = keys
+	return nil
+}
+
+func (v *oidcVerifier) getJSON(url string, out any) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,492 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiOperation describes one endpoint for /openapi.json. Request and
+// response schemas are derived from the Go values in body and response, so
+// they follow the types the handlers encode.
+type apiOperation struct {
+	method, path, summary string
+	// scope is the token scope required, empty for public endpoints; admin
+	// endpoints accept only the admin token.
+	scope  string
+	admin  bool
+	params []apiParam
+	// body is a value of the JSON request body type; bodyTypes lists other
+	// accepted media types with a string body.
+	body      any
+	bodyTypes []string
+	// response is a value of the JSON response type, when responseType is
+	// empty; status defaults to 200.
+	response     any
+	responseType string
+	status       int
+}
+
+type apiParam struct {
+	name, in, kind, description string
+	required                    bool
+}
+
+func queryParam(name, kind, description string) apiParam {
+	return apiParam{name: name, in: "query", kind: kind, description: description}
+}
+
+var (
+	accountParam     = apiParam{name: "account", in: "query", kind: "string", description: "Account to read", required: true}
+	xAccountHeader   = apiParam{name: "X-Account", in: "header", kind: "string", description: "Account the entries belong to", required: true}
+	startTimeParam   = queryParam("start_time", "string", "Earliest timestamp: RFC 3339, unix seconds or milliseconds, or now-15m")
+	endTimeParam     = queryParam("end_time", "string", "Latest timestamp, as start_time; now when start_time is given")
+	idPathParam      = apiParam{name: "id", in: "path", kind: "integer", required: true}
+	namePathParam    = apiParam{name: "name", in: "path", kind: "string", required: true}
+	lokiTenantHeader = apiParam{name: "X-Scope-OrgID", in: "header", kind: "string", description: "Account, unless given as account"}
+	accountPathParam = apiParam{name: "account", in: "path", kind: "string", required: true}
+	logFilterParams  = []apiParam{
+		queryParam("system", "string", "Comma-separated or repeated to match any of several values, like user, module and task"),
+		queryParam("user", "string", ""),
+		queryParam("module", "string", ""),
+		queryParam("task", "string", ""),
+		queryParam("trace_id", "string", ""),
+		queryParam("session_id", "string", ""),
+		queryParam("fingerprint", "string", "Entries of one /fingerprints group"),
+		queryParam("<column>_prefix", "string", "system, user, module or task starting with the value; also <column>_contains and <column>_ilike (equal ignoring case)"),
+		queryParam("msg_regex", "string", "RE2 pattern matched against msg, e.g. timeout after \\d+ms"),
+		queryParam("query", "string", "Search string, e.g. level>=error AND (module=api OR module=worker) \"connection reset\""),
+		queryParam("level", "string", "Exact canonical level, or a level name; comma-separated or repeated to match any of several"),
+		queryParam("min_level", "string", "Lowest canonical level, or a level name"),
+		startTimeParam,
+		endTimeParam,
+		queryParam("time_field", "string", "timestamp (default) or received_at, the column start_time and end_time apply to"),
+		queryParam("tz", "string", "IANA zone of start_time and end_time without an offset, and of /histogram buckets"),
+		queryParam("field.<name>", "string", "Match a structured field, e.g. field.request_id=abc"),
+		queryParam("field_gte.<name>", "string", "Lowest value of a structured field, compared as its custom column's type or as a number; also field_lte.<name>"),
+	}
+	logQueryParams = append(append([]apiParam{}, logFilterParams...),
+		queryParam("limit", "integer", "Maximum entries, default 100"),
+		queryParam("offset", "integer", ""),
+		queryParam("as_of", "integer", "Leave out entries stored after this id, the X-As-Of header of the first page"),
+		queryParam("fields", "string", "Comma-separated entry keys to return"),
+		queryParam("order_by", "string", "timestamp, received_at, level or id"),
+		queryParam("direction", "string", "asc or desc"),
+	)
+)
+
+// MessageResponse is the body of simple success responses.
+type MessageResponse struct {
+	Message string `json:"message"`
+	ULID    string `json:"ulid,omitempty"`
+}
+
+// apiOperations lists the documented endpoints; keep it in step with the
+// routes registered in main.
+var apiOperations = []apiOperation{
+	{method: "POST", path: "/logdata", summary: "Store a log entry", scope: scopeIngest,
+		params: []apiParam{xAccountHeader, {name: "Idempotency-Key", in: "header", kind: "string", description: "Makes retries within IDEMPOTENCY_TTL safe"},
+			queryParam("ack", "string", "sync (default), or async to return 202 with a receipt before the entry is stored"),
+			queryParam("ttl", "string", "Expiry of entries without their own expires_at or ttl, e.g. 30m or 2d; or the X-Logdata-TTL header")},
+		body: LogData{}, response: MessageResponse{}},
+	{method: "POST", path: "/gelf", summary: "Store a GELF message, as the Graylog GELF HTTP input", scope: scopeIngest,
+		params:    []apiParam{{name: "X-Account", in: "header", kind: "string", description: "Account of the message, else its _account or GELF_ACCOUNT"}},
+		bodyTypes: []string{"application/json"}, status: http.StatusAccepted},
+	{method: "POST", path: "/logdata/raw", summary: "Store plain text lines as entries, one per line", scope: scopeIngest,
+		params: []apiParam{xAccountHeader, queryParam("system", "string", "Required, or the X-Logdata-System header"),
+			queryParam("module", "string", "Required, or the X-Logdata-Module header"),
+			queryParam("user", "string", "Defaults to system"), queryParam("task", "string", "Defaults to raw"),
+			queryParam("level", "string", "Level of lines not starting with one, default info"),
+			queryParam("ttl", "string", "Expiry of the entries from receipt, e.g. 30m or 2d")},
+		bodyTypes: []string{"text/plain"}, response: RawIngestResult{}},
+	{method: "POST", path: "/logdata/batch", summary: "Store many entries as POST /logdata does, as NDJSON, a JSON array or size-delimited protobuf LogEntry messages", scope: scopeIngest,
+		params: []apiParam{xAccountHeader}, bodyTypes: []string{"application/x-ndjson", "application/json", protobufBatchType}, response: BatchResult{}},
+	{method: "GET", path: "/receipts/{id}", summary: "Status of an entry accepted with ack=async", scope: scopeIngest,
+		params: []apiParam{{name: "id", in: "path", kind: "string", required: true}, accountParam}, response: Receipt{}},
+	{method: "DELETE", path: "/logdata", summary: "Purge an account's entries matching the filters", admin: true,
+		params: append([]apiParam{accountParam, queryParam("dry_run", "boolean", "Only count the entries")}, logFilterParams...),
+		response: struct {
+			Deleted int64 `json:"deleted"`
+		}{}},
+	{method: "GET", path: "/logdata/{ulid}", summary: "Get an entry by ULID", scope: scopeRead,
+		params: []apiParam{{name: "ulid", in: "path", kind: "string", required: true}, accountParam}, response: LogData{}},
+	{method: "GET", path: "/logdata/{id}/context", summary: "Get an entry with the entries around it in its system", scope: scopeRead,
+		params: []apiParam{{name: "id", in: "path", kind: "string", description: "ULID or numeric id", required: true}, accountParam,
+			queryParam("before", "integer", "Entries before, default 20"), queryParam("after", "integer", "Entries after, default 20")},
+		response: LogContext{}},
+	{method: "PATCH", path: "/logdata/{id}", summary: "Annotate an entry", scope: scopeAdmin,
+		params: []apiParam{idPathParam, xAccountHeader}, body: Annotation{}, response: Annotation{}},
+	{method: "POST", path: "/import", summary: "Bulk import NDJSON or CSV entries", scope: scopeIngest,
+		params: []apiParam{xAccountHeader, queryParam("format", "string", "ndjson (default) or csv"),
+			queryParam("levels", "string", "canonical to store levels without the account's level scheme, e.g. for exports"),
+			queryParam("ttl", "string", "Expiry of entries without their own expires_at or ttl, from the import")},
+		bodyTypes: []string{"application/x-ndjson", "text/csv"}, response: ImportResult{}},
+	{method: "GET", path: "/getdata", summary: "Query entries", scope: scopeRead,
+		params: append([]apiParam{accountParam, queryParam("account_prefix", "string", "Instead of account, an account and those below it, with ACCOUNT_SEPARATOR set"),
+			queryParam("include_annotations", "boolean", ""),
+			queryParam("include_stack_trace", "boolean", "false to leave stack_trace empty"),
+			queryParam("collapse", "string", "fingerprint or msg, to return the newest entry of each group with the group's count, first_seen and last_seen"),
+			queryParam("explain", "boolean", "Return the SQL, query plan and estimated row count instead of entries"),
+			queryParam("format", "string", "json (default), or ndjson for one entry per line"),
+			queryParam("envelope", "boolean", "Return an EntryPage with pagination metadata instead of an array"),
+			queryParam("cursor", "string", "next_cursor of the previous EntryPage, instead of offset and as_of")}, logQueryParams...),
+		response: []LogData{}},
+	{method: "GET", path: "/export", summary: "Export an account's entries as gzip compressed NDJSON", scope: scopeRead,
+		params: append([]apiParam{accountParam}, logFilterParams...), responseType: "application/gzip"},
+	{method: "GET", path: "/trace/{trace_id}", summary: "Get the entries of a trace", scope: scopeRead,
+		params: []apiParam{{name: "trace_id", in: "path", kind: "string", required: true}, accountParam}, response: []LogData{}},
+	{method: "GET", path: "/sessions", summary: "List the sessions of matching entries, most recently active first", scope: scopeRead,
+		params: append([]apiParam{accountParam, queryParam("limit", "integer", "Sessions to return, default 100")}, logFilterParams...), response: []Session{}},
+	{method: "GET", path: "/sessions/{session_id}", summary: "Get the entries of a session", scope: scopeRead,
+		params: []apiParam{{name: "session_id", in: "path", kind: "string", required: true}, accountParam}, response: []LogData{}},
+	{method: "GET", path: "/verify", summary: "Recompute an account's hash chain and report its breaks", scope: scopeRead,
+		params: []apiParam{accountParam, startTimeParam, {name: "end_time", in: "query", kind: "string", description: "End of the range, exclusive, as start_time"}}, response: ChainVerification{}},
+	{method: "GET", path: "/users/activity", summary: "Per-user entry counts by level and last-seen times of matching entries", scope: scopeRead,
+		params: append([]apiParam{accountParam, queryParam("limit", "integer", "Users to return, default 100"),
+			queryParam("sort", "string", "errors (default), entries, or last_seen for the longest silent first")}, logFilterParams...), response: []UserActivity{}},
+	{method: "GET", path: "/usage", summary: "Get an account's usage; admins may omit account to list all", scope: scopeRead,
+		params: []apiParam{queryParam("account", "string", "")}, response: Usage{}},
+	{method: "GET", path: "/fingerprints", summary: "Error groups by fingerprint, most recently seen first", scope: scopeRead,
+		params: []apiParam{accountParam, queryParam("min_level", "string", "Lowest level, default error"), queryParam("module", "string", ""),
+			queryParam("new_since", "string", "Only groups first seen within this duration, e.g. 24h"),
+			queryParam("limit", "integer", ""), queryParam("offset", "integer", "")}, response: []Fingerprint{}},
+	{method: "GET", path: "/count", summary: "Count entries, exactly or estimated from rollups", scope: scopeRead,
+		params: append([]apiParam{accountParam, queryParam("mode", "string", "auto (default), exact or approximate"),
+			queryParam("max_scan", "integer", "Entries to count at most before estimating")}, logFilterParams...),
+		response: CountResponse{}},
+	{method: "GET", path: "/histogram", summary: "Count entries per time bucket", scope: scopeRead,
+		params:   append([]apiParam{accountParam, queryParam("bucket", "string", "1m, 5m, 1h (default) or 1d")}, logFilterParams...),
+		response: []HistogramBucket{}},
+	{method: "GET", path: "/topn", summary: "Most frequent values of a field", scope: scopeRead,
+		params: append([]apiParam{accountParam,
+			{name: "field", in: "query", kind: "string", description: "system, user, module, task, level, trace_id or field.<name>", required: true},
+			queryParam("metric", "string", "count (default), distinct_users or errors"),
+			queryParam("n", "integer", "Values to return, default 10")}, logFilterParams...),
+		response: []TopNValue{}},
+	{method: "GET", path: "/values", summary: "Distinct values of a metadata column with their counts", scope: scopeRead,
+		params: append([]apiParam{accountParam,
+			{name: "field", in: "query", kind: "string", description: "system, user, module or task", required: true},
+			queryParam("limit", "integer", "Values to return, default 1000")}, logFilterParams...),
+		response: []TopNValue{}},
+	{method: "GET", path: "/rollups", summary: "Hourly or daily entry counts", scope: scopeRead,
+		params: []apiParam{accountParam, queryParam("resolution", "string", "hour or day"), startTimeParam, endTimeParam,
+			queryParam("system", "string", ""), queryParam("module", "string", ""), queryParam("level", "string", "Canonical level or a level name")},
+		response: []Rollup{}},
+	{method: "GET", path: "/anomalies", summary: "Detected volume spikes and drops, latest first", scope: scopeRead,
+		params: []apiParam{accountParam, queryParam("module", "string", ""), queryParam("kind", "string", "spike or drop"),
+			startTimeParam, endTimeParam, queryParam("limit", "integer", "Default 100")},
+		response: []Anomaly{}},
+	{method: "GET", path: "/silence", summary: "Sources that have not logged within an interval, longest silent first", scope: scopeRead,
+		params: []apiParam{accountParam, queryParam("system", "string", ""), queryParam("module", "string", ""),
+			queryParam("after", "string", "Silence that lists a source, such as 30m or 1d; default SILENCE_AFTER"), queryParam("limit", "integer", "Default 100")},
+		response: []SilentSource{}},
+	{method: "DELETE", path: "/silence", summary: "Forget a retired source", scope: scopeRead,
+		params:   []apiParam{accountParam, {name: "system", in: "query", kind: "string", required: true}, {name: "module", in: "query", kind: "string", required: true}},
+		response: MessageResponse{}},
+	{method: "GET", path: "/searches", summary: "List saved searches", scope: scopeRead, params: []apiParam{accountParam}, response: []SavedSearch{}},
+	{method: "POST", path: "/searches", summary: "Save a search", scope: scopeRead, body: SavedSearch{}, response: SavedSearch{}, status: http.StatusCreated},
+	{method: "GET", path: "/searches/{name}", summary: "Get a saved search", scope: scopeRead, params: []apiParam{namePathParam, accountParam}, response: SavedSearch{}},
+	{method: "PUT", path: "/searches/{name}", summary: "Replace a saved search's query", scope: scopeRead, params: []apiParam{namePathParam}, body: SavedSearch{}, response: SavedSearch{}},
+	{method: "DELETE", path: "/searches/{name}", summary: "Delete a saved search", scope: scopeRead, params: []apiParam{namePathParam, accountParam}, response: MessageResponse{}},
+	{method: "GET", path: "/searches/{name}/run", summary: "Run a saved search; other parameters override the saved ones", scope: scopeRead,
+		params: append([]apiParam{namePathParam, accountParam}, logQueryParams...), response: []LogData{}},
+	{method: "GET", path: "/extractions", summary: "List field extraction rules", scope: scopeAdmin, params: []apiParam{accountParam}, response: []ExtractionRule{}},
+	{method: "POST", path: "/extractions", summary: "Create a field extraction rule", scope: scopeAdmin, body: ExtractionRule{}, response: ExtractionRule{}, status: http.StatusCreated},
+	{method: "POST", path: "/extractions/test", summary: "Try an extraction pattern on a msg", scope: scopeAdmin, body: ExtractionTest{}, response: ExtractionTestResult{}},
+	{method: "GET", path: "/extractions/{id}", summary: "Get a field extraction rule", scope: scopeAdmin, params: []apiParam{idPathParam, accountParam}, response: ExtractionRule{}},
+	{method: "PUT", path: "/extractions/{id}", summary: "Replace a field extraction rule", scope: scopeAdmin, params: []apiParam{idPathParam}, body: ExtractionRule{}, response: ExtractionRule{}},
+	{method: "DELETE", path: "/extractions/{id}", summary: "Delete a field extraction rule", scope: scopeAdmin, params: []apiParam{idPathParam, accountParam}, response: MessageResponse{}},
+	{method: "GET", path: "/columns", summary: "List the account's custom columns", scope: scopeAdmin, params: []apiParam{accountParam}, response: []CustomColumn{}},
+	{method: "PUT", path: "/columns/{name}", summary: "Define a typed custom column for a structured field, filling it from stored entries", scope: scopeAdmin,
+		params: []apiParam{namePathParam}, body: CustomColumn{}, response: CustomColumn{}, status: http.StatusCreated},
+	{method: "DELETE", path: "/columns/{name}", summary: "Delete a custom column and its stored values", scope: scopeAdmin, params: []apiParam{namePathParam, accountParam}, response: MessageResponse{}},
+	{method: "GET", path: "/alerts", summary: "List alert rules", scope: scopeAdmin, params: []apiParam{accountParam}, response: []AlertRule{}},
+	{method: "POST", path: "/alerts", summary: "Create an alert rule", scope: scopeAdmin, body: AlertRule{}, response: AlertRule{}, status: http.StatusCreated},
+	{method: "POST", path: "/alerts/test", summary: "Replay a past range through a proposed rule and report when it would have fired", scope: scopeAdmin,
+		params: []apiParam{{name: "start_time", in: "query", kind: "string", required: true}, endTimeParam,
+			queryParam("step", "string", "Time between evaluations, default ALERT_INTERVAL")},
+		body: AlertRule{}, response: AlertTestResult{}},
+	{method: "GET", path: "/levels", summary: "Get the account's level scheme", scope: scopeAdmin, params: []apiParam{accountParam}, response: LevelScheme{}},
+	{method: "PUT", path: "/levels", summary: "Set the account's level scheme, normalizing the levels of new entries", scope: scopeAdmin, body: LevelScheme{}, response: LevelScheme{}},
+	{method: "DELETE", path: "/levels", summary: "Delete the account's level scheme", scope: scopeAdmin, params: []apiParam{accountParam}, response: MessageResponse{}},
+	{method: "GET", path: "/thresholds", summary: "List the account's per-module level thresholds", scope: scopeAdmin, params: []apiParam{accountParam}, response: []LevelThreshold{}},
+	{method: "PUT", path: "/thresholds", summary: "Set the lowest level stored for a module, or the account without module", scope: scopeAdmin, body: LevelThreshold{}, response: LevelThreshold{}},
+	{method: "DELETE", path: "/thresholds", summary: "Delete a level threshold", scope: scopeAdmin, params: []apiParam{accountParam, queryParam("module", "string", "Module of the threshold, empty for the account's")}, response: MessageResponse{}},
+	{method: "GET", path: "/retention", summary: "List the account's per-level retention rules", scope: scopeAdmin, params: []apiParam{accountParam}, response: []RetentionRule{}},
+	{method: "PUT", path: "/retention", summary: "Set how long entries from a level up are kept", scope: scopeAdmin, body: RetentionRule{}, response: RetentionRule{}},
+	{method: "DELETE", path: "/retention", summary: "Delete a retention rule", scope: scopeAdmin, params: []apiParam{accountParam, {name: "min_level", in: "query", kind: "integer", description: "Level of the rule", required: true}}, response: MessageResponse{}},
+	{method: "GET", path: "/alerts/{id}", summary: "Get an alert rule", scope: scopeAdmin, params: []apiParam{idPathParam, accountParam}, response: AlertRule{}},
+	{method: "PUT", path: "/alerts/{id}", summary: "Replace an alert rule", scope: scopeAdmin, params: []apiParam{idPathParam}, body: AlertRule{}, response: AlertRule{}},
+	{method: "DELETE", path: "/alerts/{id}", summary: "Delete an alert rule", scope: scopeAdmin, params: []apiParam{idPathParam, accountParam}, response: MessageResponse{}},
+	{method: "GET", path: "/reports", summary: "List scheduled reports", scope: scopeAdmin, params: []apiParam{accountParam}, response: []Report{}},
+	{method: "POST", path: "/reports", summary: "Create a scheduled report", scope: scopeAdmin, body: Report{}, response: Report{}, status: http.StatusCreated},
+	{method: "GET", path: "/reports/{id}", summary: "Get a scheduled report", scope: scopeAdmin, params: []apiParam{idPathParam, accountParam}, response: Report{}},
+	{method: "PUT", path: "/reports/{id}", summary: "Replace a scheduled report", scope: scopeAdmin, params: []apiParam{idPathParam}, body: Report{}, response: Report{}},
+	{method: "DELETE", path: "/reports/{id}", summary: "Delete a scheduled report", scope: scopeAdmin, params: []apiParam{idPathParam, accountParam}, response: MessageResponse{}},
+	{method: "GET", path: "/reports/{id}/preview", summary: "Render a report for the window ending now", scope: scopeAdmin,
+		params: []apiParam{idPathParam, accountParam}, responseType: "text/csv"},
+	{method: "POST", path: "/reports/{id}/run", summary: "Render and deliver a report now", scope: scopeAdmin,
+		params: []apiParam{idPathParam, accountParam}, response: MessageResponse{}},
+	{method: "GET", path: "/webhooks", summary: "List webhook subscriptions", scope: scopeAdmin, params: []apiParam{accountParam}, response: []WebhookSubscription{}},
+	{method: "POST", path: "/webhooks", summary: "Create a webhook subscription", scope: scopeAdmin, body: WebhookSubscription{}, response: WebhookSubscription{}, status: http.StatusCreated},
+	{method: "DELETE", path: "/webhooks/{id}", summary: "Delete a webhook subscription", scope: scopeAdmin, params: []apiParam{idPathParam, accountParam}, response: MessageResponse{}},
+	{method: "GET", path: "/notifiers", summary: "Get an account's email notifier", scope: scopeAdmin, params: []apiParam{accountParam}, response: EmailNotifier{}},
+	{method: "PUT", path: "/notifiers", summary: "Create or replace an account's email notifier", scope: scopeAdmin, body: EmailNotifier{}, response: EmailNotifier{}},
+	{method: "DELETE", path: "/notifiers", summary: "Delete an account's email notifier", scope: scopeAdmin, params: []apiParam{accountParam}, response: MessageResponse{}},
+	{method: "POST", path: "/services/collector/event", summary: "Splunk HTTP Event Collector API, for the Docker splunk log driver", scope: scopeIngest,
+		params:    []apiParam{{name: "X-Account", in: "header", kind: "string", description: "Account of the events, else their index"}},
+		bodyTypes: []string{"application/json"}, response: SplunkResponse{}},
+	{method: "POST", path: "/loki/api/v1/push", summary: "Loki push API (JSON or snappy protobuf)", scope: scopeIngest,
+		params:    []apiParam{{name: "X-Scope-OrgID", in: "header", kind: "string", description: "Account, unless set by an account label"}},
+		bodyTypes: []string{"application/json", "application/x-protobuf"}, status: http.StatusNoContent},
+	{method: "GET", path: "/loki/api/v1/query_range", summary: "LogQL subset: log queries as streams, count_over_time and rate as matrices", scope: scopeRead,
+		params: []apiParam{lokiTenantHeader, {name: "query", in: "query", kind: "string", required: true},
+			queryParam("start", "string", "Unix nanoseconds, seconds or RFC 3339; default end-1h"), queryParam("end", "string", "Default now"),
+			queryParam("step", "string", "Duration or seconds between metric points"), queryParam("limit", "integer", "Maximum entries, default 100"),
+			queryParam("direction", "string", "backward (default) or forward")}, response: lokiResult{}},
+	{method: "GET", path: "/loki/api/v1/query", summary: "LogQL metric query at one time", scope: scopeRead,
+		params:   []apiParam{lokiTenantHeader, {name: "query", in: "query", kind: "string", required: true}, queryParam("time", "string", "Default now")},
+		response: lokiResult{}},
+	{method: "GET", path: "/loki/api/v1/labels", summary: "Stream label names", scope: scopeRead, params: []apiParam{lokiTenantHeader}, response: map[string]any{}},
+	{method: "GET", path: "/loki/api/v1/label/{name}/values", summary: "Values of a stream label", scope: scopeRead,
+		params:   []apiParam{namePathParam, lokiTenantHeader, queryParam("start", "string", "Default end-1h"), queryParam("end", "string", "Default now")},
+		response: map[string]any{}},
+	{method: "GET", path: "/archive/query", summary: "Query archived partitions", scope: scopeRead,
+		params: append([]apiParam{accountParam}, logQueryParams...), response: []LogData{}},
+	{method: "GET", path: "/replication/entries", summary: "Entries after an id, for replicas and shard moves", admin: true,
+		params:   []apiParam{{name: "after_id", in: "query", kind: "integer", required: true}, queryParam("limit", "integer", "Default 1000"), queryParam("account", "string", "")},
+		response: []LogData{}},
+	{method: "POST", path: "/replication/entries", summary: "Import entries from another node, keeping ULIDs", admin: true,
+		body: []LogData{}, response: struct {
+			Stored  int `json:"stored"`
+			Skipped int `json:"skipped"`
+		}{}},
+	{method: "GET", path: "/admin/rejected", summary: "List rejected payloads", admin: true,
+		params: []apiParam{queryParam("account", "string", ""), queryParam("limit", "integer", ""), queryParam("offset", "integer", "")}, response: []RejectedLog{}},
+	{method: "POST", path: "/admin/rejected/{id}/replay", summary: "Re-ingest a rejected payload", admin: true, params: []apiParam{idPathParam}, response: MessageResponse{}},
+	{method: "GET", path: "/admin/allowlists", summary: "List account IP allowlists", admin: true, response: []AccountNetworks{}},
+	{method: "PUT", path: "/admin/allowlists/{account}", summary: "Restrict an account's tokens to the given CIDRs", admin: true,
+		params: []apiParam{accountPathParam}, body: AccountNetworks{}, response: AccountNetworks{}},
+	{method: "DELETE", path: "/admin/allowlists/{account}", summary: "Lift an account's IP allowlist", admin: true,
+		params: []apiParam{accountPathParam}, response: MessageResponse{}},
+	{method: "GET", path: "/admin/accounts/{account}/export", summary: "Zip of everything stored for an account", admin: true,
+		params: []apiParam{accountPathParam}, responseType: "application/zip"},
+	{method: "POST", path: "/admin/accounts/{account}/deletion", summary: "Count an account's rows and issue the token confirming its deletion", admin: true,
+		params: []apiParam{accountPathParam}, response: AccountDeletion{}},
+	{method: "DELETE", path: "/admin/accounts/{account}", summary: "Irreversibly delete an account's data", admin: true,
+		params: []apiParam{accountPathParam, queryParam("confirm", "string", "Token from POST /admin/accounts/{account}/deletion")}, response: AccountDeleted{}},
+	{method: "GET", path: "/admin/accounts/{account}/settings", summary: "An account's feature flags and limits", admin: true,
+		params: []apiParam{accountPathParam}, response: AccountSettings{}},
+	{method: "PUT", path: "/admin/accounts/{account}/settings", summary: "Replace an account's feature flags and limits", admin: true,
+		params: []apiParam{accountPathParam}, body: AccountSettings{}, response: AccountSettings{}},
+	{method: "DELETE", path: "/admin/accounts/{account}/settings", summary: "Restore the server's defaults for an account", admin: true,
+		params: []apiParam{accountPathParam}, response: MessageResponse{}},
+	{method: "GET", path: "/admin/audit", summary: "Audit log of queries, exports and admin actions, newest first", admin: true,
+		params: []apiParam{queryParam("account", "string", ""), queryParam("actor", "string", ""), queryParam("action", "string", ""),
+			startTimeParam, queryParam("end_time", "string", "Latest time, as start_time"),
+			queryParam("limit", "integer", ""), queryParam("offset", "integer", "")}, response: []AuditEntry{}},
+	{method: "GET", path: "/admin/queries", summary: "Slow read queries with their plans and missing-index suggestions", admin: true, response: []SlowQuery{}},
+	{method: "DELETE", path: "/admin/queries", summary: "Clear the slow query log", admin: true, response: MessageResponse{}},
+	{method: "GET", path: "/admin/queries/history", summary: "Runs of slow read queries, newest first", admin: true,
+		params: []apiParam{queryParam("statement", "string", "Normalized statement, as listed by /admin/queries"),
+			queryParam("min_duration", "string", "Shortest run to list, e.g. 5s"),
+			startTimeParam, queryParam("end_time", "string", "Latest time, as start_time"),
+			queryParam("limit", "integer", ""), queryParam("offset", "integer", "")}, response: []SlowQueryRun{}},
+	{method: "POST", path: "/admin/reload", summary: "Re-read .env and apply reloadable settings", admin: true, response: ReloadResponse{}},
+	{method: "GET", path: "/debug/vars", summary: "Runtime statistics, queue depths and per-route counters, unless on DEBUG_ADDR", admin: true, response: map[string]any{}},
+	{method: "GET", path: "/admin/maintenance", summary: "Report maintenance mode and backpressure", admin: true, response: AdmissionStatus{}},
+	{method: "PUT", path: "/admin/maintenance", summary: "Turn maintenance mode on or off", admin: true, body: Maintenance{}, response: AdmissionStatus{}},
+	{method: "POST", path: "/admin/compress", summary: "Compress stored entries with STORAGE_COMPRESSION", admin: true, response: CompressResult{}},
+	{method: "GET", path: "/admin/vacuum", summary: "Vacuum schedule, running and recent runs", admin: true, response: VacuumStatus{}},
+	{method: "POST", path: "/admin/vacuum", summary: "Start a vacuum and ANALYZE run", admin: true,
+		params: []apiParam{queryParam("full", "boolean", "Rewrite the database with VACUUM, blocking writes until done")}, response: VacuumRun{}},
+	{method: "GET", path: "/admin/snapshot", summary: "Consistent SQLite backup of the database", admin: true, responseType: "application/vnd.sqlite3"},
+	{method: "GET", path: "/healthz", summary: "Liveness", response: map[string]string{}},
+	{method: "GET", path: "/readyz", summary: "Readiness: database reachable and migrated, writes not under backpressure", response: map[string]any{}},
+}
+
+// openAPIDocument builds the OpenAPI 3 document from apiOperations.
+func openAPIDocument() map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+	for _, op := range apiOperations {
+		operation := map[string]any{"summary": op.summary}
+		switch {
+		case op.admin:
+			operation["security"] = []map[string][]string{{"bearer": {}}}
+			operation["description"] = "Requires the admin token."
+		case op.scope != "":
+			operation["security"] = []map[string][]string{{"bearer": {}}}
+			operation["description"] = "Requires a token with the " + op.scope + " scope when AUTH_REQUIRED is set."
+		}
+		params := []map[string]any{}
+		for _, p := range op.params {
+			param := map[string]any{"name": p.name, "in": p.in, "schema": map[string]any{"type": p.kind}}
+			if p.description != "" {
+				param["description"] = p.description
+			}
+			if p.required {
+				param["required"] = true
+			}
+			params = append(params, param)
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		content := map[string]any{}
+		if op.body != nil {
+			content["application/json"] = map[string]any{"schema": jsonSchema(reflect.TypeOf(op.body), schemas)}
+		}
+		for _, mediaType := range op.bodyTypes {
+			if _, ok := content[mediaType]; !ok {
+				content[mediaType] = map[string]any{"schema": map[string]any{"type": "string"}}
+			}
+		}
+		if len(content) > 0 {
+			operation["requestBody"] = map[string]any{"required": true, "content": content}
+		}
+
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		response := map[string]any{"description": http.StatusText(status)}
+		switch {
+		case op.responseType != "":
+			response["content"] = map[string]any{op.responseType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+		case op.response != nil:
+			response["content"] = map[string]any{"application/json": map[string]any{"schema": jsonSchema(reflect.TypeOf(op.response), schemas)}}
+		}
+		operation["responses"] = map[string]any{
+			strconv.Itoa(status): response,
+			"default": map[string]any{"description": "Error", "content": map[string]any{
+				"application/json": map[string]any{"schema": jsonSchema(reflect.TypeOf(APIError{}), schemas)}}},
+		}
+
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]any{}
+		}
+		paths[op.path][strings.ToLower(op.method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "logdata",
+			"version":     "1.0",
+			"description": "Multi-tenant log ingestion and query API.",
+		},
+		"servers": []map[string]any{{"url": apiVersion}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas":         schemas,
+			"securitySchemes": map[string]any{"bearer": map[string]any{"type": "http", "scheme": "bearer"}},
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema returns the schema of t as encoding/json writes it. Named
+// structs are added to schemas and referenced.
+func jsonSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		return jsonSchema(t.Elem(), schemas)
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			// Reserve the name first so recursive types terminate
+			schemas[t.Name()] = map[string]any{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = jsonSchema(field.Type, schemas)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// handleOpenAPI serves the OpenAPI document, built on first use.
+func handleOpenAPI() http.HandlerFunc {
+	var once sync.Once
+	var doc []byte
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { doc, _ = json.MarshalIndent(openAPIDocument(), "", "  ") })
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at /openapi.json.
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>logdata API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// handleDocs serves Swagger UI for /openapi.json.
+func handleDocs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(swaggerUIPage))
+	}
+}
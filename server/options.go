@@ -0,0 +1,69 @@
+package server
+
+import "net/http"
+
+// Option customizes the Server built by New, or by Main for the server
+// binary.
+type Option func(*Server)
+
+// Interceptor wraps the handler of a route. It runs before the route
+// authenticates the request, with gzip bodies already decoded, and may
+// enrich the request, reject it by answering itself, or observe the
+// response by wrapping the ResponseWriter.
+type Interceptor func(next http.Handler) http.Handler
+
+// EntryInterceptor is called with every entry before it is stored, from any
+// listener, and may change anything but its account. POST /import and
+// replication store entries as they are, without interceptors.
+type EntryInterceptor func(entry *LogData)
+
+// WithIngestInterceptors wraps the HTTP routes that store entries: POST
+// /logdata and /logdata/raw, /import, /receipts, /gelf, the Splunk HEC and
+// Loki push routes. The first interceptor is the outermost.
+func WithIngestInterceptors(interceptors ...Interceptor) Option {
+	return func(s *Server) {
+		s.ingestInterceptors = append(s.ingestInterceptors, interceptors...)
+	}
+}
+
+// WithQueryInterceptors wraps the HTTP routes that read entries: GET
+// /logdata/<id> and its context, /getdata, /export, /trace, /sessions,
+// /users/activity, /verify, /usage, /fingerprints, /count, /histogram, /topn, /values, /rollups,
+// /anomalies, /searches, the Loki query routes and /archive/query. The
+// first interceptor is the outermost.
+func WithQueryInterceptors(interceptors ...Interceptor) Option {
+	return func(s *Server) {
+		s.queryInterceptors = append(s.queryInterceptors, interceptors...)
+	}
+}
+
+// WithEntryInterceptors runs interceptors, in order, on every entry before
+// it is stored.
+func WithEntryInterceptors(interceptors ...EntryInterceptor) Option {
+	return func(s *Server) {
+		s.entryInterceptors = append(s.entryInterceptors, interceptors...)
+	}
+}
+
+// entryInterceptors are those of the running Server. It is set by New.
+var entryInterceptors []EntryInterceptor
+
+func interceptEntry(logData *LogData) {
+	account := logData.Account
+	for _, intercept := range entryInterceptors {
+		intercept(logData)
+	}
+	logData.Account = account
+}
+
+// intercept wraps h in interceptors, the first outermost.
+func intercept(interceptors []Interceptor, h http.HandlerFunc) http.HandlerFunc {
+	if len(interceptors) == 0 {
+		return h
+	}
+	var next http.Handler = h
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		next = interceptors[i](next)
+	}
+	return next.ServeHTTP
+}
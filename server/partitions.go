@@ -0,0 +1,370 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// baseTable holds entries stored before partitioning was enabled. It is
+// always part of the logData view and is never dropped.
+const baseTable = "logData_base"
+
+const partitionsSchema = `CREATE TABLE IF NOT EXISTS log_partitions (
+    name TEXT PRIMARY KEY,
+    start_time DATETIME NOT NULL,
+    end_time DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS log_sequence (
+    id INTEGER NOT NULL
+)`
+
+// partitions is set when PARTITION_BY is configured. Entries then live in one
+// table per period and logData is a view over all of them.
+var partitions *partitionSet
+
+type partition struct {
+	name       string
+	start, end time.Time
+}
+
+type partitionSet struct {
+	mu     sync.Mutex
+	period string
+	byName map[string]partition
+}
+
+var indexStmtRe = regexp.MustCompile(`(?i)^CREATE (UNIQUE )?INDEX (?:IF NOT EXISTS )?"?(\w+)"? ON "?` + baseTable + `"?`)
+
+// newPartitionSet returns a partitionSet for period "day" or "week".
+func newPartitionSet(period string) (*partitionSet, error) {
+	if period != "day" && period != "week" {
+		return nil, fmt.Errorf("PARTITION_BY must be day or week, got %q", period)
+	}
+	return &partitionSet{period: period, byName: map[string]partition{}}, nil
+}
+
+// bounds returns the partition covering t.
+func (p *partitionSet) bounds(t time.Time) partition {
+	start := t.UTC().Truncate(24 * time.Hour)
+	if p.period == "week" {
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		return partition{name: "logData_w" + start.Format("20060102"), start: start, end: start.AddDate(0, 0, 7)}
+	}
+	return partition{name: "logData_d" + start.Format("20060102"), start: start, end: start.AddDate(0, 0, 1)}
+}
+
+// tableFor returns the table an entry with timestamp t is inserted into.
+func tableFor(t time.Time) string {
+	if partitions == nil {
+		return "logData"
+	}
+	return partitions.bounds(t).name
+}
+
+// logDataTables lists the physical tables holding log entries.
+func logDataTables() []string {
+	if partitions == nil {
+		return []string{"logData"}
+	}
+	partitions.mu.Lock()
+	defer partitions.mu.Unlock()
+	tables := []string{baseTable}
+	for _, part := range partitions.sorted() {
+		tables = append(tables, part.name)
+	}
+	return tables
+}
+
+// logDataSource returns the FROM clause for reading entries between start and
+// end. With partitioning only overlapping partitions are read; a zero bound is
+// open.
+func logDataSource(start, end time.Time) string {
+	if partitions == nil || (start.IsZero() && end.IsZero()) {
+		return "logData"
+	}
+	partitions.mu.Lock()
+	defer partitions.mu.Unlock()
+	tables := []string{baseTable}
+	for _, part := range partitions.sorted() {
+		if (end.IsZero() || part.start.Before(end) || part.start.Equal(end)) && (start.IsZero() || part.end.After(start)) {
+			tables = append(tables, part.name)
+		}
+	}
+	return "(" + unionAll(tables) + ") AS logData"
+}
+
+func (p *partitionSet) sorted() []partition {
+	parts := make([]partition, 0, len(p.byName))
+	for _, part := range p.byName {
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].start.Before(parts[j].start) })
+	return parts
+}
+
+func unionAll(tables []string) string {
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = "SELECT * FROM " + table
+	}
+	return strings.Join(selects, " UNION ALL ")
+}
+
+// init converts logData into the base partition on first use and loads the
+// known partitions. It must run before logData migrations.
+func (p *partitionSet) init(db *sql.DB) error {
+	var kind string
+	err := db.QueryRow("SELECT type FROM sqlite_master WHERE name='logData'").Scan(&kind)
+	if err != nil {
+		return fmt.Errorf("failed to check logData: %v", err)
+	}
+	if _, err := db.Exec(partitionsSchema); err != nil {
+		return fmt.Errorf("failed to create partition tables: %v", err)
+	}
+	if kind == "table" {
+		if _, err := db.Exec("ALTER TABLE logData RENAME TO " + baseTable); err != nil {
+			return fmt.Errorf("failed to convert logData to partitions: %v", err)
+		}
+		slog.Info("Moved existing logData table", "table", baseTable)
+	}
+	if _, err := db.Exec(`INSERT INTO log_sequence (id)
+		SELECT COALESCE((SELECT MAX(id) FROM ` + baseTable + `), 0) WHERE NOT EXISTS (SELECT 1 FROM log_sequence)`); err != nil {
+		return fmt.Errorf("failed to initialize log_sequence: %v", err)
+	}
+
+	rows, err := db.Query("SELECT name, start_time, end_time FROM log_partitions")
+	if err != nil {
+		return fmt.Errorf("failed to load partitions: %v", err)
+	}
+	defer rows.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for rows.Next() {
+		var part partition
+		if err := rows.Scan(&part.name, &part.start, &part.end); err != nil {
+			return err
+		}
+		p.byName[part.name] = part
+	}
+	return rows.Err()
+}
+
+// finishInit copies indexes of the base table to every partition and
+// recreates the logData view, after migrations ran on all tables.
+func (p *partitionSet) finishInit(db *sql.DB) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, part := range p.sorted() {
+		if err := copyIndexes(tx, part.name); err != nil {
+			return err
+		}
+	}
+	if err := rebuildView(tx, p.sorted()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensure creates the partition covering t if it does not exist yet. It runs
+// in its own transaction so a rolled back insert cannot lose a partition.
+func (p *partitionSet) ensure(db *sql.DB, t time.Time) error {
+	part := p.bounds(t)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.byName[part.name]; ok {
+		return nil
+	}
+
+	var schema string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type='table' AND name=?", baseTable).Scan(&schema); err != nil {
+		return fmt.Errorf("failed to read %s schema: %v", baseTable, err)
+	}
+	schema = regexp.MustCompile(`^CREATE TABLE "?`+baseTable+`"?`).ReplaceAllString(schema, "CREATE TABLE IF NOT EXISTS "+part.name)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(schema); err != nil {
+		return fmt.Errorf("failed to create partition %s: %v", part.name, err)
+	}
+	if err := copyIndexes(tx, part.name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT OR IGNORE INTO log_partitions (name, start_time, end_time) VALUES (?, ?, ?)",
+		part.name, part.start, part.end); err != nil {
+		return err
+	}
+	if err := rebuildView(tx, append(p.sorted(), part)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	p.byName[part.name] = part
+	slog.Info("Created partition", "partition", part.name)
+	return nil
+}
+
+// copyIndexes creates the indexes of the base table on table.
+func copyIndexes(tx *sql.Tx, table string) error {
+	rows, err := tx.Query("SELECT sql FROM sqlite_master WHERE type='index' AND tbl_name=? AND sql IS NOT NULL", baseTable)
+	if err != nil {
+		return err
+	}
+	var stmts []string
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			rows.Close()
+			return err
+		}
+		stmts = append(stmts, indexStmtRe.ReplaceAllString(stmt, "CREATE ${1}INDEX IF NOT EXISTS ${2}_"+strings.TrimPrefix(table, "logData_")+" ON "+table))
+	}
+	rows.Close()
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create index on %s: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// rebuildView recreates the logData view and its delete and update triggers
+// over the base table and parts.
+func rebuildView(tx *sql.Tx, parts []partition) error {
+	tables := []string{baseTable}
+	for _, part := range parts {
+		tables = append(tables, part.name)
+	}
+
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?) WHERE name <> 'id'", baseTable)
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns = append(columns, name)
+	}
+	rows.Close()
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = column + " = NEW." + column
+	}
+
+	var deletes, updates strings.Builder
+	for _, table := range tables {
+		fmt.Fprintf(&deletes, "DELETE FROM %s WHERE id = OLD.id; ", table)
+		fmt.Fprintf(&updates, "UPDATE %s SET %s WHERE id = OLD.id; ", table, strings.Join(assignments, ", "))
+	}
+	for _, stmt := range []string{
+		"DROP VIEW IF EXISTS logData",
+		"CREATE VIEW logData AS " + unionAll(tables),
+		"CREATE TRIGGER logData_delete INSTEAD OF DELETE ON logData BEGIN " + deletes.String() + "END",
+		"CREATE TRIGGER logData_update INSTEAD OF UPDATE ON logData BEGIN " + updates.String() + "END",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to rebuild logData view: %v", err)
+		}
+	}
+	return nil
+}
+
+// dropBefore drops partitions that ended before cutoff, returning the
+// accounts that had entries in them.
+func (p *partitionSet) dropBefore(db *sql.DB, cutoff time.Time) ([]string, int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var expired, remaining []partition
+	for _, part := range p.sorted() {
+		if part.end.After(cutoff) {
+			remaining = append(remaining, part)
+		} else {
+			expired = append(expired, part)
+		}
+	}
+	if len(expired) == 0 {
+		return nil, 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	accounts := map[string]bool{}
+	var dropped int64
+	for _, part := range expired {
+		rows, err := tx.Query("SELECT account, COUNT(*) FROM " + part.name + " GROUP BY account")
+		if err != nil {
+			return nil, 0, err
+		}
+		for rows.Next() {
+			var account string
+			var n int64
+			if err := rows.Scan(&account, &n); err == nil {
+				accounts[account] = true
+				dropped += n
+			}
+		}
+		rows.Close()
+		if err := deleteEntryRows(tx, "SELECT id FROM "+part.name); err != nil {
+			return nil, 0, err
+		}
+		if _, err := tx.Exec("DROP TABLE " + part.name); err != nil {
+			return nil, 0, err
+		}
+		if _, err := tx.Exec("DELETE FROM log_partitions WHERE name = ?", part.name); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := rebuildView(tx, remaining); err != nil {
+		return nil, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	for _, part := range expired {
+		delete(p.byName, part.name)
+		slog.Info("Dropped partition", "partition", part.name)
+	}
+
+	names := make([]string, 0, len(accounts))
+	for account := range accounts {
+		names = append(names, account)
+	}
+	return names, dropped, nil
+}
+
+// deleteLogData deletes the entries matching where from every table holding
+// them and returns how many were removed. Deleting through the logData view
+// works too but reports no affected rows.
+func deleteLogData(ex execer, where string, args []interface{}) (int64, error) {
+	var deleted int64
+	for _, table := range logDataTables() {
+		res, err := ex.Exec("DELETE FROM "+table+" WHERE "+where, args...)
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
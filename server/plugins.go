@@ -0,0 +1,99 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"plugin"
+)
+
+// Ingest plugins are Go plugins, built with go build -buildmode=plugin
+// against this module, listed in INGEST_PLUGINS. A plugin exports
+//
+//	func Transform(entry *server.LogData) bool
+//
+// which is called with every entry before it is stored, after level scheme
+// normalization and level thresholds. It may change anything but the
+// account, for enrichment such as geo-IP or internal ID lookups, and
+// returns false to drop the entry. A plugin may also export
+//
+//	var Accounts []string
+//
+// to only see the entries of those accounts. Plugins run in the server
+// process, so they must be built with the server's Go toolchain and module
+// versions, and must not block.
+
+// ingestPlugin is a plugin loaded from INGEST_PLUGINS.
+type ingestPlugin struct {
+	path      string
+	transform func(*LogData) bool
+	// accounts are the accounts the plugin sees, every one when nil.
+	accounts map[string]bool
+}
+
+// ingestPlugins are the plugins of the running Server, in INGEST_PLUGINS
+// order. It is set by load.
+var ingestPlugins []ingestPlugin
+
+// loadIngestPlugins opens the plugins at paths.
+func loadIngestPlugins(paths []string) ([]ingestPlugin, error) {
+	var plugins []ingestPlugin
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open ingest plugin %s: %v", path, err)
+		}
+		sym, err := p.Lookup("Transform")
+		if err != nil {
+			return nil, fmt.Errorf("ingest plugin %s: %v", path, err)
+		}
+		transform, ok := sym.(func(*LogData) bool)
+		if !ok {
+			return nil, fmt.Errorf("ingest plugin %s: Transform must be a func(*server.LogData) bool, not %T", path, sym)
+		}
+		loaded := ingestPlugin{path: path, transform: transform}
+		if sym, err := p.Lookup("Accounts"); err == nil {
+			accounts, ok := sym.(*[]string)
+			if !ok {
+				return nil, fmt.Errorf("ingest plugin %s: Accounts must be a []string, not %T", path, sym)
+			}
+			loaded.accounts = map[string]bool{}
+			for _, account := range *accounts {
+				loaded.accounts[account] = true
+			}
+		}
+		slog.Info("Loaded ingest plugin", "path", path, "accounts", len(loaded.accounts))
+		plugins = append(plugins, loaded)
+	}
+	return plugins, nil
+}
+
+// pluginsKeep runs the ingest plugins on logData and reports whether it is
+// to be stored. Dropped entries are counted in account_usage. A plugin that
+// panics is logged and skipped, keeping the entry.
+func pluginsKeep(db *sql.DB, logData *LogData) bool {
+	account := logData.Account
+	defer func() { logData.Account = account }()
+	for _, p := range ingestPlugins {
+		if p.accounts != nil && !p.accounts[account] {
+			continue
+		}
+		if !p.run(logData) {
+			if err := addDropped(db, account); err != nil {
+				slog.Error("Error counting dropped entry", "account", account, "err", err)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func (p ingestPlugin) run(logData *LogData) (keep bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			slog.Error("Ingest plugin panicked", "path", p.path, "err", err)
+			keep = true
+		}
+	}()
+	return p.transform(logData)
+}
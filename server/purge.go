@@ -0,0 +1,79 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// handleDeleteLogData serves DELETE /logdata, purging the entries of one account
+// that match the /getdata filters. With dry_run=true it only counts them.
+func handleDeleteLogData(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" || account == allAccounts {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "A single account query parameter is required")
+			return
+		}
+
+		params, err := parseQueryParams(query)
+		if err != nil {
+			requestLogger(r).Warn("Invalid query parameters", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		params.Account = account
+		where, args := buildLogFilter(params)
+
+		if query.Get("dry_run") == "true" {
+			var count int64
+			if err := db.QueryRow("SELECT COUNT(*) FROM logData WHERE "+where, args...).Scan(&count); err != nil {
+				requestLogger(r).Error("Error counting log data", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to count log data")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"dry_run": true, "count": count})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			requestLogger(r).Error("Error starting purge", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete log data")
+			return
+		}
+		defer tx.Rollback()
+
+		if err := deleteEntryRows(tx, "SELECT id FROM logData WHERE "+where, args...); err != nil {
+			requestLogger(r).Error("Error deleting annotations", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete log data")
+			return
+		}
+		deleted, err := deleteLogData(tx, where, args)
+		if err != nil {
+			requestLogger(r).Error("Error deleting log data", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete log data")
+			return
+		}
+		if err := recomputeUsage(tx, account); err != nil {
+			requestLogger(r).Error("Error updating usage", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete log data")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			requestLogger(r).Error("Error committing purge", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete log data")
+			return
+		}
+
+		queryResults.invalidateAccount(account)
+		vacuums.deleted(deleted)
+		recordAudit(db, r, "admin", "purge", account, fmt.Sprintf("%s deleted=%d", r.URL.RawQuery, deleted))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"deleted": deleted})
+	}
+}
@@ -0,0 +1,776 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// QueryParams represents query parameters for GET /getdata.
+type QueryParams struct {
+	Account string `json:"account"`
+	System  string `json:"system"`
+	User    string `json:"user"`
+	Module  string `json:"module"`
+	Task    string `json:"task"`
+	TraceID string `json:"trace_id"`
+	// SessionID selects the entries of one user session.
+	SessionID string `json:"session_id,omitempty"`
+	// Fingerprint selects the entries of one fingerprint group.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// MsgRegex is an RE2 pattern matched against msg.
+	MsgRegex string `json:"msg_regex"`
+	// Search is the query= search string, parsed into searchWhere and
+	// searchArgs; see parseSearch.
+	Search      string `json:"query,omitempty"`
+	searchWhere string
+	searchArgs  []interface{}
+	// Level and MinLevel are on the canonical scale; see LevelScheme.
+	Level     *int   `json:"level"`
+	MinLevel  *int   `json:"min_level,omitempty"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	// TimeField is the column start_time and end_time bound: timestamp,
+	// the default, or received_at.
+	TimeField string `json:"time_field,omitempty"`
+	// TimeZone is the IANA zone of start_time and end_time without an
+	// offset, and of /histogram buckets; UTC when empty.
+	TimeZone string `json:"tz,omitempty"`
+	Limit    *int64 `json:"limit"`
+	Offset   *int64 `json:"offset"`
+	// AsOf leaves out entries stored after the one with this id, so the
+	// pages of a query stay consistent while new entries arrive.
+	AsOf *int64 `json:"as_of,omitempty"`
+	// Matches holds the <column>_<mode> filters on metadata columns.
+	Matches []MetaMatch `json:"matches,omitempty"`
+	// AnyOf holds the filters on metadata columns given several values,
+	// and Levels a level filter given several; an entry matches any value.
+	// A filter given one value sets System, User, Module, Task or Level.
+	AnyOf  []MetaAnyOf `json:"any_of,omitempty"`
+	Levels []int       `json:"levels,omitempty"`
+	// Fields holds field.<name>=<value> filters matched against LogData.Fields.
+	Fields map[string]string `json:"fields"`
+	// FieldBounds holds the field_gte.<name> and field_lte.<name> filters.
+	FieldBounds []FieldBound `json:"field_bounds,omitempty"`
+	// Projection lists the LogData JSON keys to return, all when empty.
+	Projection []string `json:"projection"`
+	// Collapse returns the newest entry of each group of entries with the
+	// same fingerprint or msg, with the group in LogData.Collapsed. It is
+	// read by /getdata only; see collapseKeys.
+	Collapse string `json:"collapse,omitempty"`
+	// OmitStackTrace leaves stack_trace empty, for compact listings.
+	OmitStackTrace bool `json:"omit_stack_trace,omitempty"`
+	// OrderBy is one of sortColumns and Direction "asc" or "desc"; empty
+	// means newest first.
+	OrderBy   string `json:"order_by"`
+	Direction string `json:"direction"`
+	// endExclusive leaves out entries at EndTime, for ranges that tile such
+	// as those of /export, and withHashes selects the entry hashes too.
+	endExclusive bool
+	withHashes   bool
+	// subtree selects the accounts below Account too; see account_prefix.
+	subtree bool
+}
+
+// MetaMatch is a <column>_<mode>=<value> filter on one of metaColumns. Mode
+// is "prefix" or "contains" (case-sensitive) or "ilike" (equal ignoring
+// ASCII case).
+type MetaMatch struct {
+	Column string `json:"column"`
+	Mode   string `json:"mode"`
+	Value  string `json:"value"`
+}
+
+// MetaAnyOf is a filter on one of metaColumns matching any of Values, as
+// repeated parameters or a comma-separated list such as module=auth,payments.
+type MetaAnyOf struct {
+	Column string   `json:"column"`
+	Values []string `json:"values"`
+}
+
+// FieldBound is a field_<op>.<name>=<value> filter, op being "gte" or
+// "lte". Values compare as the type of the account's custom column for the
+// field, and as numbers otherwise.
+type FieldBound struct {
+	Name  string `json:"name"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// fieldBoundOps maps the ops of FieldBound to SQL.
+var fieldBoundOps = map[string]string{"gte": ">=", "lte": "<="}
+
+// metaColumns and matchModes list the columns and modes of MetaMatch.
+var (
+	metaColumns = []string{"system", "user", "module", "task"}
+	matchModes  = []string{"prefix", "contains", "ilike"}
+)
+
+// sortColumns lists the order_by values accepted by /getdata.
+var sortColumns = map[string]bool{"timestamp": true, "received_at": true, "level": true, "id": true}
+
+// fieldNameRe restricts structured field names usable in filters, since the
+// name ends up in a JSON path expression.
+var fieldNameRe = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+// allAccounts selects every account in /getdata, admin token required.
+const allAccounts = "*"
+
+// parseQueryParams reads the /getdata filters other than account from a query string.
+func parseQueryParams(query url.Values) (QueryParams, error) {
+	params := QueryParams{
+		TraceID:     query.Get("trace_id"),
+		SessionID:   query.Get("session_id"),
+		Fingerprint: query.Get("fingerprint"),
+		MsgRegex:    query.Get("msg_regex"),
+		Level:       nil,
+		StartTime:   query.Get("start_time"),
+		EndTime:     query.Get("end_time"),
+		Limit:       nil,
+		Offset:      nil,
+		Fields:      map[string]string{},
+	}
+
+	for _, column := range metaColumns {
+		values := listValues(query, column)
+		if len(values) > 1 {
+			params.AnyOf = append(params.AnyOf, MetaAnyOf{Column: column, Values: values})
+		} else if len(values) == 1 {
+			*params.metaColumn(column) = values[0]
+		}
+	}
+	for _, column := range metaColumns {
+		for _, mode := range matchModes {
+			if value := query.Get(column + "_" + mode); value != "" {
+				params.Matches = append(params.Matches, MetaMatch{Column: column, Mode: mode, Value: value})
+			}
+		}
+	}
+
+	for key, values := range query {
+		prefix, name, ok := strings.Cut(key, ".")
+		if !ok || len(values) == 0 {
+			continue
+		}
+		op, bound := strings.CutPrefix(prefix, "field_")
+		if prefix != "field" && (!bound || fieldBoundOps[op] == "") {
+			continue
+		}
+		if !fieldNameRe.MatchString(name) {
+			return params, fmt.Errorf("Invalid field filter name: %s", name)
+		}
+		if bound {
+			params.FieldBounds = append(params.FieldBounds, FieldBound{Name: name, Op: op, Value: values[0]})
+		} else {
+			params.Fields[name] = values[0]
+		}
+	}
+
+	if params.MsgRegex != "" {
+		if _, err := compileMsgRegex(params.MsgRegex); err != nil {
+			return params, err
+		}
+	}
+
+	if params.Search = query.Get("query"); params.Search != "" {
+		var err error
+		if params.searchWhere, params.searchArgs, err = parseSearch(query.Get("account"), params.Search); err != nil {
+			return params, fmt.Errorf("Invalid query: %v", err)
+		}
+	}
+
+	if query.Get("fields") != "" {
+		for _, name := range strings.Split(query.Get("fields"), ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := projections[name]; !ok {
+				return params, fmt.Errorf("Invalid fields entry: %s", name)
+			}
+			params.Projection = append(params.Projection, name)
+		}
+	}
+
+	params.OrderBy = query.Get("order_by")
+	if params.OrderBy != "" && !sortColumns[params.OrderBy] {
+		return params, fmt.Errorf("Invalid order_by: must be timestamp, received_at, level or id")
+	}
+	params.TimeField = query.Get("time_field")
+	if params.TimeField != "" && params.TimeField != "timestamp" && params.TimeField != "received_at" {
+		return params, fmt.Errorf("Invalid time_field: must be timestamp or received_at")
+	}
+	loc := time.UTC
+	if tz := query.Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return params, fmt.Errorf("Invalid tz: must be an IANA time zone such as Europe/Paris")
+		}
+		params.TimeZone = tz
+	}
+	// Bounds are kept as RFC 3339, which the handlers and timeBound read
+	now := timeNow().In(loc)
+	for _, bound := range []struct {
+		name  string
+		value *string
+	}{{"start_time", &params.StartTime}, {"end_time", &params.EndTime}} {
+		if *bound.value == "" {
+			continue
+		}
+		t, err := parseTimeBound(*bound.value, loc, now)
+		if err != nil {
+			return params, fmt.Errorf("Invalid %s: %v", bound.name, err)
+		}
+		*bound.value = t.In(loc).Format(time.RFC3339Nano)
+	}
+	if params.StartTime != "" && params.EndTime == "" {
+		params.EndTime = now.Format(time.RFC3339Nano)
+	}
+	params.Direction = strings.ToLower(query.Get("direction"))
+	if params.Direction != "" && params.Direction != "asc" && params.Direction != "desc" {
+		return params, fmt.Errorf("Invalid direction: must be asc or desc")
+	}
+
+	for _, text := range listValues(query, "level") {
+		level, ok := levelSchemes.parse(query.Get("account"), text)
+		if !ok {
+			return params, fmt.Errorf("Invalid level: %s", text)
+		}
+		if !slices.Contains(params.Levels, level) {
+			params.Levels = append(params.Levels, level)
+		}
+	}
+	if len(params.Levels) == 1 {
+		params.Level, params.Levels = &params.Levels[0], nil
+	}
+	if text := query.Get("min_level"); text != "" {
+		level, ok := levelSchemes.parse(query.Get("account"), text)
+		if !ok {
+			return params, fmt.Errorf("Invalid min_level: %s", text)
+		}
+		params.MinLevel = &level
+	}
+
+	params.OmitStackTrace = query.Get("include_stack_trace") == "false"
+
+	if text := query.Get("as_of"); text != "" {
+		asOf, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || asOf < 0 {
+			return params, fmt.Errorf("Invalid as_of: must be an entry id")
+		}
+		params.AsOf = &asOf
+	}
+
+	var limit, offset int64 = 100, 0
+	if query.Get("limit") != "" {
+		if _, err := fmt.Sscanf(query.Get("limit"), "%d", &limit); err == nil {
+			params.Limit = &limit
+		}
+	}
+	if query.Get("offset") != "" {
+		if _, err := fmt.Sscanf(query.Get("offset"), "%d", &offset); err == nil {
+			params.Offset = &offset
+		}
+	}
+	return params, nil
+}
+
+// listValues returns the distinct values of the query parameter name, which
+// may be repeated and hold comma-separated lists, in order.
+func listValues(query url.Values, name string) []string {
+	var values []string
+	for _, raw := range query[name] {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" && !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// metaColumn returns the single-value filter on column, one of metaColumns.
+func (params *QueryParams) metaColumn(column string) *string {
+	switch column {
+	case "system":
+		return &params.System
+	case "user":
+		return &params.User
+	case "module":
+		return &params.Module
+	}
+	return &params.Task
+}
+
+// inList returns the placeholders of an IN list of n values.
+func inList(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
+
+// buildLogQuery turns params into a SELECT over logData returning
+// logDataColumns, and hashColumns with withHashes or collapseColumns with
+// Collapse. An Account of allAccounts drops the account filter.
+func buildLogQuery(params QueryParams) (string, []interface{}) {
+	where, args := buildLogFilter(params)
+	start, end := params.partitionRange()
+	columns := logDataColumns
+	if params.OmitStackTrace {
+		// Skipping the column spares SQLite reading long traces at all
+		columns = strings.Replace(columns, "stack_trace", "NULL", 1)
+	}
+	if params.withHashes {
+		columns += ", " + hashColumns
+	}
+	source := logDataSource(start, end)
+	if params.Collapse != "" {
+		source, where = collapsedSource(params, source, where), "collapse_rank = 1"
+		columns += ", " + collapseColumns
+	}
+	sqlQuery := "SELECT " + columns + " FROM " + source + " WHERE " + where
+	orderBy, direction := "timestamp", "DESC"
+	if params.OrderBy != "" {
+		orderBy = params.OrderBy
+	}
+	if params.Direction != "" {
+		direction = strings.ToUpper(params.Direction)
+	}
+	sqlQuery += " ORDER BY " + orderBy + " " + direction
+	if orderBy != "id" {
+		// Keep pages stable when entries share a sort value
+		sqlQuery += ", id " + direction
+	}
+	if params.Limit != nil {
+		sqlQuery += fmt.Sprintf(" LIMIT %d", *params.Limit)
+	}
+	if params.Offset != nil {
+		sqlQuery += fmt.Sprintf(" OFFSET %d", *params.Offset)
+	}
+	return sqlQuery, args
+}
+
+// queryEntries runs the query buildLogQuery makes of params, observed by
+// slowQueries, and calls each with the entries in order until it returns an
+// error. Rows failing to scan are logged and skipped. Errors of the query are
+// returned as they are, for queryAborted.
+func queryEntries(ctx context.Context, db *sql.DB, params QueryParams, each func(LogData) error) error {
+	sqlQuery, args := buildLogQuery(params)
+	var rowsRead int64
+	defer slowQueries.observe(sqlQuery, args, time.Now(), &rowsRead)
+	rows, err := db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rowsRead++
+		var logData LogData
+		switch {
+		case params.withHashes:
+			logData, err = scanHashedLogData(rows)
+		case params.Collapse != "":
+			logData, err = scanCollapsedLogData(rows)
+		default:
+			logData, err = scanLogData(rows)
+		}
+		if err != nil {
+			loggerFrom(ctx).Error("Error scanning row", "err", err)
+			continue
+		}
+		if err := each(logData); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// buildLogFilter returns the WHERE clause (without the keyword) selecting the
+// logData rows matched by params, ignoring Limit and Offset.
+func buildLogFilter(params QueryParams) (string, []interface{}) {
+	sqlQuery := "account = ?"
+	args := []interface{}{params.Account}
+	// Custom columns are per account, so subtrees filter fields as JSON
+	columnAccount := params.Account
+	if params.Account == allAccounts {
+		sqlQuery = "1 = 1"
+		args = nil
+	} else if params.subtree {
+		sqlQuery, args = subtreeFilter(params.Account)
+		columnAccount = allAccounts
+	}
+	if params.System != "" {
+		sqlQuery += " AND system = ?"
+		args = append(args, params.System)
+	}
+	if params.User != "" {
+		sqlQuery += " AND user = ?"
+		args = append(args, params.User)
+	}
+	if params.Module != "" {
+		sqlQuery += " AND module = ?"
+		args = append(args, params.Module)
+	}
+	if params.Task != "" {
+		sqlQuery += " AND task = ?"
+		args = append(args, params.Task)
+	}
+	for _, f := range params.AnyOf {
+		sqlQuery += " AND " + f.Column + " IN " + inList(len(f.Values))
+		for _, value := range f.Values {
+			args = append(args, value)
+		}
+	}
+	for _, m := range params.Matches {
+		switch m.Mode {
+		case "prefix":
+			sqlQuery += " AND substr(" + m.Column + ", 1, ?) = ?"
+			args = append(args, utf8.RuneCountInString(m.Value), m.Value)
+		case "contains":
+			sqlQuery += " AND instr(" + m.Column + ", ?) > 0"
+			args = append(args, m.Value)
+		case "ilike":
+			sqlQuery += " AND " + m.Column + " = ? COLLATE NOCASE"
+			args = append(args, m.Value)
+		}
+	}
+	if params.TraceID != "" {
+		sqlQuery += " AND trace_id = ?"
+		args = append(args, params.TraceID)
+	}
+	if params.SessionID != "" {
+		sqlQuery += " AND session_id = ?"
+		args = append(args, params.SessionID)
+	}
+	if params.Fingerprint != "" {
+		sqlQuery += " AND fingerprint = ?"
+		args = append(args, params.Fingerprint)
+	}
+	if params.Level != nil {
+		sqlQuery += " AND level = ?"
+		args = append(args, *params.Level)
+	}
+	if len(params.Levels) > 0 {
+		sqlQuery += " AND level IN " + inList(len(params.Levels))
+		for _, level := range params.Levels {
+			args = append(args, level)
+		}
+	}
+	if params.MinLevel != nil {
+		sqlQuery += " AND level >= ?"
+		args = append(args, *params.MinLevel)
+	}
+	if params.MsgRegex != "" {
+		sqlQuery += " AND " + storedText("msg") + " REGEXP ?"
+		args = append(args, params.MsgRegex)
+	}
+	if params.searchWhere != "" {
+		sqlQuery += " AND (" + params.searchWhere + ")"
+		args = append(args, params.searchArgs...)
+	}
+	if params.StartTime != "" {
+		sqlQuery += " AND " + params.timeColumn() + " >= ?"
+		args = append(args, timeBound(params.StartTime))
+	}
+	if params.EndTime != "" {
+		op := " <= ?"
+		if params.endExclusive {
+			op = " < ?"
+		}
+		sqlQuery += " AND " + params.timeColumn() + op
+		args = append(args, timeBound(params.EndTime))
+	}
+	if params.AsOf != nil {
+		sqlQuery += " AND id <= ?"
+		args = append(args, *params.AsOf)
+	}
+	for name, value := range params.Fields {
+		if where, filterArgs, ok := customFilter(columnAccount, name, "=", value); ok {
+			sqlQuery += " AND " + where
+			args = append(args, filterArgs...)
+			continue
+		}
+		sqlQuery += " AND CAST(json_extract(" + storedText("fields") + ", ?) AS TEXT) = ?"
+		args = append(args, fmt.Sprintf(`$."%s"`, name), value)
+	}
+	for _, b := range params.FieldBounds {
+		if where, filterArgs, ok := customFilter(columnAccount, b.Name, fieldBoundOps[b.Op], b.Value); ok {
+			sqlQuery += " AND " + where
+			args = append(args, filterArgs...)
+			continue
+		}
+		var value interface{} = b.Value
+		if n, err := strconv.ParseFloat(b.Value, 64); err == nil {
+			value = n
+		}
+		sqlQuery += " AND json_extract(" + storedText("fields") + ", ?) " + fieldBoundOps[b.Op] + " ?"
+		args = append(args, fmt.Sprintf(`$."%s"`, b.Name), value)
+	}
+	return sqlQuery, args
+}
+
+// timeColumn is the column bounded by start_time and end_time.
+func (params QueryParams) timeColumn() string {
+	if params.TimeField == "received_at" {
+		return "received_at"
+	}
+	return "timestamp"
+}
+
+// partitionRange returns the bounds selecting the partitions params read,
+// zero when open. Unparseable bounds still filter in SQL but read every
+// partition, and so do bounds on received_at, as partitions go by
+// timestamp.
+func (params QueryParams) partitionRange() (time.Time, time.Time) {
+	if params.timeColumn() != "timestamp" {
+		return time.Time{}, time.Time{}
+	}
+	start, _ := time.Parse(time.RFC3339, params.StartTime)
+	end, _ := time.Parse(time.RFC3339, params.EndTime)
+	return start, end
+}
+
+// localLayouts are the start_time and end_time layouts without an offset,
+// read in the tz zone.
+var localLayouts = []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999", "2006-01-02T15:04", "2006-01-02"}
+
+// parseTimeBound parses a start_time or end_time: RFC 3339, a local time in
+// one of localLayouts, unix seconds or milliseconds, or now with an
+// optional offset such as now-15m, now-24h or now-7d.
+func parseTimeBound(s string, loc *time.Location, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+		// Milliseconds from 1973 on, as seconds that big are past year 5000
+		if n < 1e11 {
+			return time.Unix(n, 0), nil
+		}
+		return time.UnixMilli(n), nil
+	}
+	if rest, ok := strings.CutPrefix(s, "now"); ok {
+		if rest == "" {
+			return now, nil
+		}
+		sign := rest[0]
+		d, err := parseRelativeDuration(rest[1:])
+		if (sign == '-' || sign == '+') && err == nil {
+			if sign == '-' {
+				d = -d
+			}
+			return now.Add(d), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q must be RFC 3339, unix seconds or milliseconds, or relative such as now-15m, now-24h or now-7d", s)
+}
+
+// parseRelativeDuration parses a Go duration, or a whole number of days
+// ("7d") or weeks ("2w").
+func parseRelativeDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("invalid duration %q", s)
+	}
+	return d, err
+}
+
+// timeBound returns an RFC 3339 bound as UTC time, which the driver formats
+// like stored timestamps so they compare in order. Other bounds are
+// compared as given.
+func timeBound(s string) interface{} {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC()
+	}
+	return s
+}
+
+// matches reports whether logData satisfies the filters in params, for
+// entries that are not in the database (e.g. archives). Time bounds and
+// paging are left to the caller.
+func (params QueryParams) matches(logData LogData) bool {
+	if params.Account != allAccounts && params.Account != logData.Account && !(params.subtree && accountWithin(logData.Account, params.Account)) {
+		return false
+	}
+	if (params.System != "" && params.System != logData.System) ||
+		(params.User != "" && params.User != logData.User) ||
+		(params.Module != "" && params.Module != logData.Module) ||
+		(params.Task != "" && params.Task != logData.Task) ||
+		(params.TraceID != "" && params.TraceID != logData.TraceID) ||
+		(params.SessionID != "" && params.SessionID != logData.SessionID) ||
+		(params.Fingerprint != "" && params.Fingerprint != logData.Fingerprint) ||
+		(params.Level != nil && *params.Level != logData.Level) ||
+		(params.MinLevel != nil && logData.Level < *params.MinLevel) ||
+		(params.AsOf != nil && logData.ID != nil && *logData.ID > *params.AsOf) ||
+		(len(params.Levels) > 0 && !slices.Contains(params.Levels, logData.Level)) {
+		return false
+	}
+	for _, f := range params.AnyOf {
+		if !slices.Contains(f.Values, metaValue(logData, f.Column)) {
+			return false
+		}
+	}
+	for _, m := range params.Matches {
+		if !m.matches(logData) {
+			return false
+		}
+	}
+	if params.MsgRegex != "" {
+		if ok, _ := sqliteRegexp(params.MsgRegex, logData.Msg); !ok {
+			return false
+		}
+	}
+	for name, value := range params.Fields {
+		v, ok := logData.Fields[name]
+		if !ok || fmt.Sprint(v) != value {
+			return false
+		}
+	}
+	for _, b := range params.FieldBounds {
+		v, ok := logData.Fields[b.Name]
+		if !ok || !b.matches(logData.Account, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the field value v of an entry of account is
+// within the bound.
+func (b FieldBound) matches(account string, v any) bool {
+	c, ok := customColumns.column(account, b.Name)
+	if !ok {
+		c = CustomColumn{Type: "real"}
+	}
+	x, xok := c.convert(v)
+	y, yok := c.convert(b.Value)
+	if !xok || !yok {
+		return false
+	}
+	var order int
+	if c.Type == "text" {
+		order = strings.Compare(x.(string), y.(string))
+	} else {
+		order = compareNumbers(x, y)
+	}
+	if b.Op == "gte" {
+		return order >= 0
+	}
+	return order <= 0
+}
+
+// compareNumbers compares two values converted to integer or real.
+func compareNumbers(x, y any) int {
+	toFloat := func(v any) float64 {
+		if n, ok := v.(int64); ok {
+			return float64(n)
+		}
+		return v.(float64)
+	}
+	if a, ok := x.(int64); ok {
+		if b, ok := y.(int64); ok {
+			return cmp.Compare(a, b)
+		}
+	}
+	return cmp.Compare(toFloat(x), toFloat(y))
+}
+
+func (m MetaMatch) matches(logData LogData) bool {
+	value := metaValue(logData, m.Column)
+	switch m.Mode {
+	case "prefix":
+		return strings.HasPrefix(value, m.Value)
+	case "contains":
+		return strings.Contains(value, m.Value)
+	case "ilike":
+		return asciiEqualFold(value, m.Value)
+	}
+	return false
+}
+
+// metaValue returns the value of logData in column, one of metaColumns.
+func metaValue(logData LogData, column string) string {
+	return map[string]string{"system": logData.System, "user": logData.User,
+		"module": logData.Module, "task": logData.Task}[column]
+}
+
+// asciiEqualFold compares like SQLite's NOCASE collation, folding only ASCII.
+func asciiEqualFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		ca, cb := a[i], b[i]
+		if 'A' <= ca && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if 'A' <= cb && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
+
+// projections maps the names accepted by fields= to LogData values.
+var projections = map[string]func(LogData) any{
+	"id":           func(l LogData) any { return l.ID },
+	"ulid":         func(l LogData) any { return l.ULID },
+	"account":      func(l LogData) any { return l.Account },
+	"system":       func(l LogData) any { return l.System },
+	"user":         func(l LogData) any { return l.User },
+	"module":       func(l LogData) any { return l.Module },
+	"task":         func(l LogData) any { return l.Task },
+	"timestamp":    func(l LogData) any { return l.Timestamp },
+	"received_at":  func(l LogData) any { return l.ReceivedAt },
+	"msg":          func(l LogData) any { return l.Msg },
+	"level":        func(l LogData) any { return l.Level },
+	"stack_trace":  func(l LogData) any { return l.StackTrace },
+	"fields":       func(l LogData) any { return l.Fields },
+	"trace_id":     func(l LogData) any { return l.TraceID },
+	"span_id":      func(l LogData) any { return l.SpanID },
+	"session_id":   func(l LogData) any { return l.SessionID },
+	"expires_at":   func(l LogData) any { return l.ExpiresAt },
+	"repeat_count": func(l LogData) any { return l.RepeatCount },
+	"sampled_rate": func(l LogData) any { return l.SampledRate },
+	"fingerprint":  func(l LogData) any { return l.Fingerprint },
+	"source_seq":   func(l LogData) any { return l.SourceSeq },
+	"annotations":  func(l LogData) any { return l.Annotations },
+	"collapsed":    func(l LogData) any { return l.Collapsed },
+}
+
+// project returns logs reduced to the keys in names.
+func project(logs []LogData, names []string) []map[string]any {
+	out := make([]map[string]any, len(logs))
+	for i, logData := range logs {
+		out[i] = projectEntry(logData, names)
+	}
+	return out
+}
+
+// projectEntry returns the named keys of logData.
+func projectEntry(logData LogData, names []string) map[string]any {
+	out := make(map[string]any, len(names))
+	for _, name := range names {
+		out[name] = projections[name](logData)
+	}
+	return out
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxCachedResponse is the largest response body kept by the query cache.
+const maxCachedResponse = 1 << 20
+
+// queryResults caches read responses when QUERY_CACHE_TTL is set, so
+// dashboards refreshing the same queries do not repeat them against SQLite.
+var queryResults *queryCache
+
+type queryCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cachedQuery
+}
+
+// cachedQuery is a response body with the filter that produced it, for
+// invalidation, and the number of entries it holds, for the audit log.
+type cachedQuery struct {
+	params  QueryParams
+	body    []byte
+	rows    int
+	expires time.Time
+}
+
+func newQueryCache(cfg *Config) *queryCache {
+	return &queryCache{ttl: cfg.QueryCacheTTL, maxEntries: cfg.QueryCacheMaxEntries, entries: map[string]cachedQuery{}}
+}
+
+// queryCacheKey identifies a read by its path and its parameters in sorted
+// order. The account is part of the parameters once requireScope has run.
+func queryCacheKey(r *http.Request) string {
+	return r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+// serve writes the cached response for key, returning the number of entries
+// it holds and whether there was one.
+func (c *queryCache) serve(w http.ResponseWriter, key string) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Cache", "hit")
+	w.Write(entry.body)
+	return entry.rows, true
+}
+
+// write encodes value as the response to the read of params, caching it
+// under key.
+func (c *queryCache) write(w http.ResponseWriter, key string, params QueryParams, rows int, value any) {
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(value)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body.Bytes())
+	c.store(key, params, rows, body.Bytes())
+}
+
+// store caches body, a JSON response holding rows entries, as the response
+// to the read of params under key. A nil or oversized body is not kept.
+func (c *queryCache) store(key string, params QueryParams, rows int, body []byte) {
+	if c == nil || body == nil || len(body) > maxCachedResponse {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		// Drop expired entries, or else the one expiring first
+		var oldest string
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			} else if oldest == "" || entry.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = cachedQuery{params: params, body: body, rows: rows, expires: now.Add(c.ttl)}
+}
+
+// invalidate drops the cached reads whose filter matches a stored entry. It
+// is an insert hook.
+func (c *queryCache) invalidate(logData LogData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		ts := logData.Timestamp
+		if entry.params.TimeField == "received_at" && logData.ReceivedAt != nil {
+			ts = *logData.ReceivedAt
+		}
+		if entry.params.matches(logData) && withinBounds(entry.params, ts) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// invalidateAccount drops the cached reads of account, and those across
+// accounts, after changes that bypass the insert hooks.
+func (c *queryCache) invalidateAccount(account string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if entry.params.Account == account || entry.params.Account == allAccounts || entry.params.subtree && accountWithin(account, entry.params.Account) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// withinBounds reports whether ts lies within the start_time/end_time of
+// params. Bounds that do not parse are treated as absent.
+func withinBounds(params QueryParams, ts time.Time) bool {
+	if start, err := time.Parse(time.RFC3339Nano, params.StartTime); err == nil && ts.Before(start) {
+		return false
+	}
+	if end, err := time.Parse(time.RFC3339Nano, params.EndTime); err == nil && ts.After(end) {
+		return false
+	}
+	return true
+}
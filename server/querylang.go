@@ -0,0 +1,285 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The query= parameter of /getdata and the other query endpoints takes a
+// search string, for UIs with a single search box and searches too complex
+// for separate parameters:
+//
+//	level>=error AND (module=api OR module=worker) "connection reset"
+//	user!=admin msg~"timeout after \\d+s" NOT field.status=200
+//
+// Terms are key=value comparisons (=, !=, ~ and !~ for regexps, and <, <=,
+// > and >= on level and fields) or bare words and quoted phrases looked for
+// in msg. Terms next to each other must all match; AND, OR, NOT and
+// parentheses combine them, AND binding tighter than OR. The string is
+// parsed into a condition added to the other filters.
+
+// maxSearchTerms bounds the terms of a search string, keeping the SQL it
+// parses into small.
+const maxSearchTerms = 100
+
+// searchColumns maps the keys of search terms to their logData columns.
+// Other keys name fields, with an optional field. prefix.
+var searchColumns = map[string]string{
+	"system":      "system",
+	"user":        "user",
+	"module":      "module",
+	"task":        "task",
+	"trace_id":    "trace_id",
+	"session_id":  "session_id",
+	"fingerprint": "fingerprint",
+	"msg":         storedText("msg"),
+}
+
+// searchOps lists the comparison operators, longest first.
+var searchOps = []string{"!=", "!~", ">=", "<=", "=", "~", ">", "<"}
+
+type searchParser struct {
+	s       string
+	pos     int
+	account string
+	terms   int
+}
+
+// parseSearch parses a search string for account into a WHERE condition,
+// empty when the string has no terms.
+func parseSearch(account, s string) (string, []interface{}, error) {
+	p := &searchParser{s: s, account: account}
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return "", nil, nil
+	}
+	where, args, err := p.or()
+	if err != nil {
+		return "", nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.s) {
+		return "", nil, fmt.Errorf("unexpected %q at position %d", p.s[p.pos], p.pos)
+	}
+	return where, args, nil
+}
+
+func (p *searchParser) skipSpace() {
+	for p.pos < len(p.s) && strings.ContainsRune(" \t\r\n", rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// wordEnd reports whether a bare word ends at i: at a space, a parenthesis,
+// a quote or an operator.
+func (p *searchParser) wordEnd(i int) bool {
+	if i >= len(p.s) || strings.IndexByte(" \t\r\n()\"=~<>", p.s[i]) >= 0 {
+		return true
+	}
+	return p.s[i] == '!' && i+1 < len(p.s) && (p.s[i+1] == '=' || p.s[i+1] == '~')
+}
+
+func (p *searchParser) word() string {
+	start := p.pos
+	for !p.wordEnd(p.pos) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// atKeyword reports whether the operator word k is next.
+func (p *searchParser) atKeyword(k string) bool {
+	p.skipSpace()
+	return strings.HasPrefix(p.s[p.pos:], k) && p.wordEnd(p.pos+len(k))
+}
+
+// keyword skips the operator word k and reports whether it was next.
+func (p *searchParser) keyword(k string) bool {
+	if p.atKeyword(k) {
+		p.pos += len(k)
+		return true
+	}
+	return false
+}
+
+// phrase reads a double-quoted string.
+func (p *searchParser) phrase() (string, error) {
+	start, end := p.pos, p.pos+1
+	for end < len(p.s) && p.s[end] != '"' {
+		if p.s[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.s) {
+		return "", fmt.Errorf("unterminated phrase at position %d", start)
+	}
+	value, err := strconv.Unquote(p.s[start : end+1])
+	if err != nil {
+		return "", fmt.Errorf("invalid phrase at position %d", start)
+	}
+	p.pos = end + 1
+	return value, nil
+}
+
+func (p *searchParser) or() (string, []interface{}, error) {
+	where, args, err := p.and()
+	if err != nil {
+		return "", nil, err
+	}
+	for p.keyword("OR") {
+		right, rightArgs, err := p.and()
+		if err != nil {
+			return "", nil, err
+		}
+		where, args = where+" OR "+right, append(args, rightArgs...)
+	}
+	return where, args, nil
+}
+
+func (p *searchParser) and() (string, []interface{}, error) {
+	where, args, err := p.not()
+	if err != nil {
+		return "", nil, err
+	}
+	for {
+		if p.pos == len(p.s) || p.s[p.pos] == ')' || p.atKeyword("OR") {
+			return where, args, nil
+		}
+		// Terms next to each other are ANDed too
+		p.keyword("AND")
+		right, rightArgs, err := p.not()
+		if err != nil {
+			return "", nil, err
+		}
+		where, args = where+" AND "+right, append(args, rightArgs...)
+	}
+}
+
+func (p *searchParser) not() (string, []interface{}, error) {
+	if p.keyword("NOT") {
+		where, args, err := p.not()
+		// Conditions on missing values are NULL, which NOT keeps NULL
+		return "NOT IFNULL(" + where + ", 0)", args, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == '(' {
+		p.pos++
+		where, args, err := p.or()
+		if err != nil {
+			return "", nil, err
+		}
+		if p.skipSpace(); p.pos == len(p.s) || p.s[p.pos] != ')' {
+			return "", nil, fmt.Errorf("expected ) at position %d", p.pos)
+		}
+		p.pos++
+		return "(" + where + ")", args, nil
+	}
+	return p.term()
+}
+
+// term reads a comparison, a bare word or a phrase.
+func (p *searchParser) term() (string, []interface{}, error) {
+	if p.terms++; p.terms > maxSearchTerms {
+		return "", nil, fmt.Errorf("more than %d terms", maxSearchTerms)
+	}
+	start := p.pos
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		text, err := p.phrase()
+		return "instr(lower(" + storedText("msg") + "), lower(?)) > 0", []interface{}{text}, err
+	}
+	key := p.word()
+	if key == "" {
+		if p.pos == len(p.s) {
+			return "", nil, fmt.Errorf("expected a term at the end")
+		}
+		return "", nil, fmt.Errorf("expected a term at position %d", p.pos)
+	}
+
+	p.skipSpace()
+	op := ""
+	for _, o := range searchOps {
+		if strings.HasPrefix(p.s[p.pos:], o) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		// A bare word, which the next term follows
+		p.pos = start + len(key)
+		return "instr(lower(" + storedText("msg") + "), lower(?)) > 0", []interface{}{key}, nil
+	}
+	p.pos += len(op)
+	p.skipSpace()
+	var value string
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		var err error
+		if value, err = p.phrase(); err != nil {
+			return "", nil, err
+		}
+	} else if value = p.word(); value == "" {
+		return "", nil, fmt.Errorf("expected a value for %s at position %d", key, p.pos)
+	}
+	return p.comparison(key, op, value, start)
+}
+
+// comparison returns the condition of the term key op value read at pos.
+func (p *searchParser) comparison(key, op, value string, pos int) (string, []interface{}, error) {
+	if key == "level" && (op == "~" || op == "!~") {
+		return "", nil, fmt.Errorf("level takes no %s at position %d", op, pos)
+	}
+	if op == "~" || op == "!~" {
+		if _, err := compileMsgRegex(value); err != nil {
+			return "", nil, fmt.Errorf("invalid pattern for %s at position %d: %s", key, pos, strings.TrimPrefix(err.Error(), "Invalid msg_regex: "))
+		}
+	}
+	not := ""
+	if op[0] == '!' {
+		not = "NOT "
+	}
+
+	if key == "level" {
+		level, ok := levelSchemes.parse(p.account, value)
+		if !ok {
+			return "", nil, fmt.Errorf("invalid level %s at position %d", value, pos)
+		}
+		return "level " + op + " ?", []interface{}{level}, nil
+	}
+	if column, ok := searchColumns[key]; ok {
+		switch op {
+		case "=":
+			return column + " = ?", []interface{}{value}, nil
+		case "!=":
+			return column + " IS NOT ?", []interface{}{value}, nil
+		case "~", "!~":
+			return not + "IFNULL(" + column + ", '') REGEXP ?", []interface{}{value}, nil
+		}
+		return "", nil, fmt.Errorf("%s takes no %s at position %d", key, op, pos)
+	}
+
+	name := strings.TrimPrefix(key, "field.")
+	if !fieldNameRe.MatchString(name) {
+		return "", nil, fmt.Errorf("invalid field name %s at position %d", name, pos)
+	}
+	path := fmt.Sprintf(`$."%s"`, name)
+	text := "CAST(json_extract(" + storedText("fields") + ", ?) AS TEXT)"
+	switch op {
+	case "=":
+		if where, args, ok := customFilter(p.account, name, op, value); ok {
+			return where, args, nil
+		}
+		return text + " = ?", []interface{}{path, value}, nil
+	case "!=":
+		return text + " IS NOT ?", []interface{}{path, value}, nil
+	case "~", "!~":
+		return not + "IFNULL(" + text + ", '') REGEXP ?", []interface{}{path, value}, nil
+	}
+	if where, args, ok := customFilter(p.account, name, op, value); ok {
+		return where, args, nil
+	}
+	var bound interface{} = value
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		bound = n
+	}
+	return "json_extract(" + storedText("fields") + ", ?) " + op + " ?", []interface{}{path, bound}, nil
+}
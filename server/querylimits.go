@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// queryLimiter bounds the read queries running at once across HTTP and gRPC.
+type queryLimiter struct {
+	slots chan struct{}
+}
+
+func newQueryLimiter(cfg *Config) *queryLimiter {
+	if cfg.MaxConcurrentQueries <= 0 {
+		return &queryLimiter{}
+	}
+	return &queryLimiter{slots: make(chan struct{}, cfg.MaxConcurrentQueries)}
+}
+
+// acquire takes a query slot without waiting, reporting false when none is
+// free. Each successful acquire must be followed by release.
+func (l *queryLimiter) acquire() bool {
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *queryLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// wrap runs next when a query slot is free and answers 429 otherwise.
+func (l *queryLimiter) wrap(next http.HandlerFunc) http.HandlerFunc {
+	if l.slots == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire() {
+			requestLogger(r).Warn("Too many concurrent queries", "max", cap(l.slots))
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusTooManyRequests, codeTooManyQueries, "Too many concurrent queries; retry later")
+			return
+		}
+		defer l.release()
+		next(w, r)
+	}
+}
+
+// checkQueryRows applies cfg.Live().MaxQueryRows and cfg.Live().MaxQueryScanRows to a
+// query: a missing limit becomes MaxQueryRows, and a larger limit or a page
+// ending past MaxQueryScanRows is an error.
+func checkQueryRows(cfg *Config, params *QueryParams) error {
+	if cfg.Live().MaxQueryRows > 0 {
+		if params.Limit == nil {
+			limit := cfg.Live().MaxQueryRows
+			params.Limit = &limit
+		} else if *params.Limit > cfg.Live().MaxQueryRows {
+			return fmt.Errorf("limit exceeds the maximum of %d rows", cfg.Live().MaxQueryRows)
+		}
+	}
+	if cfg.Live().MaxQueryScanRows > 0 && params.Limit != nil {
+		var offset int64
+		if params.Offset != nil {
+			offset = *params.Offset
+		}
+		if offset+*params.Limit > cfg.Live().MaxQueryScanRows {
+			return fmt.Errorf("offset + limit exceeds the maximum of %d rows; narrow the time range instead of paging this deep", cfg.Live().MaxQueryScanRows)
+		}
+	}
+	return nil
+}
+
+// limitQueryRows applies checkQueryRows, answering 413 when it fails. It
+// reports whether the query may run.
+func limitQueryRows(w http.ResponseWriter, r *http.Request, cfg *Config, params *QueryParams) bool {
+	if err := checkQueryRows(cfg, params); err != nil {
+		requestLogger(r).Warn("Query too large", "err", err)
+		writeError(w, http.StatusRequestEntityTooLarge, codeQueryTooLarge, err.Error())
+		return false
+	}
+	return true
+}
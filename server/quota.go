@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Quota limits what one account may store. Zero means unlimited.
+type Quota struct {
+	MaxRows  int64 `json:"max_rows"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// Usage is the storage consumed by one account.
+type Usage struct {
+	Account  string `json:"account"`
+	Rows     int64  `json:"rows"`
+	Bytes    int64  `json:"bytes"`
+	MaxRows  int64  `json:"max_rows,omitempty"`
+	MaxBytes int64  `json:"max_bytes,omitempty"`
+	// Redactions counts the matches masked by REDACTION_RULES.
+	Redactions int64 `json:"redactions"`
+	// Dropped counts the entries below a level threshold, not stored.
+	Dropped int64 `json:"dropped"`
+}
+
+// quotaError rejects ingestion for an account over quota; status is the HTTP
+// status to answer with.
+type quotaError struct {
+	status int
+	msg    string
+}
+
+func (e *quotaError) Error() string { return e.msg }
+
+const accountUsageSchema = `CREATE TABLE IF NOT EXISTS account_usage (
+    account TEXT PRIMARY KEY,
+    rows INTEGER NOT NULL,
+    bytes INTEGER NOT NULL,
+    redactions INTEGER NOT NULL DEFAULT 0,
+    dropped INTEGER NOT NULL DEFAULT 0
+)`
+
+// usageSizeExpr computes the stored size of a logData row, matching entrySize.
+const usageSizeExpr = `length(account) + length(system) + length(user) + length(module) + length(task) +
+	length(msg) + COALESCE(length(stack_trace), 0) + COALESCE(length(fields), 0)`
+
+// entrySize approximates the bytes a row takes, counting its text columns
+// with msg, stack trace and fields as stored by packEntry.
+func entrySize(logData LogData, msg, stackTrace, fields any) int64 {
+	return int64(len(logData.Account) + len(logData.System) + len(logData.User) + len(logData.Module) +
+		len(logData.Task) + storedSize(msg) + storedSize(stackTrace) + storedSize(fields))
+}
+
+// initializeUsage creates account_usage, backfilling it from existing rows the first time.
+func initializeUsage(db *sql.DB) error {
+	var exists string
+	err := db.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name='account_usage'").Scan(&exists)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check if account_usage table exists: %v", err)
+	}
+	if _, err := db.Exec(accountUsageSchema); err != nil {
+		return fmt.Errorf("failed to create account_usage table: %v", err)
+	}
+	if exists == "account_usage" {
+		if err := ensureColumn(db, "account_usage", "redactions", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		return ensureColumn(db, "account_usage", "dropped", "INTEGER NOT NULL DEFAULT 0")
+	}
+	if _, err := db.Exec(`INSERT INTO account_usage (account, rows, bytes)
+		SELECT account, COUNT(*), SUM(` + usageSizeExpr + `) FROM logData GROUP BY account`); err != nil {
+		return fmt.Errorf("failed to backfill account_usage: %v", err)
+	}
+	slog.Info("Created account_usage table")
+	return nil
+}
+
+const (
+	addUsageSQL = `INSERT INTO account_usage (account, rows, bytes) VALUES (?, 1, ?)
+		ON CONFLICT (account) DO UPDATE SET rows = rows + 1, bytes = bytes + excluded.bytes`
+	usageSQL = "SELECT rows, bytes FROM account_usage WHERE account = ?"
+)
+
+// addUsage accounts for one newly stored row.
+func addUsage(ex execer, account string, size int64) error {
+	_, err := writeStatements.exec(ex, addUsageSQL, account, size)
+	return err
+}
+
+// addRedactions counts matches masked by REDACTION_RULES in an account's entries.
+func addRedactions(ex execer, account string, n int) error {
+	_, err := ex.Exec(`INSERT INTO account_usage (account, rows, bytes, redactions) VALUES (?, 0, 0, ?)
+		ON CONFLICT (account) DO UPDATE SET redactions = redactions + excluded.redactions`, account, n)
+	return err
+}
+
+// addDropped counts an entry of account dropped by its level threshold.
+func addDropped(ex execer, account string) error {
+	_, err := ex.Exec(`INSERT INTO account_usage (account, rows, bytes, dropped) VALUES (?, 0, 0, 1)
+		ON CONFLICT (account) DO UPDATE SET dropped = dropped + 1`, account)
+	return err
+}
+
+// recomputeUsage recounts an account's usage after rows were removed.
+func recomputeUsage(ex execer, account string) error {
+	_, err := ex.Exec(`INSERT INTO account_usage (account, rows, bytes)
+		SELECT ?, COUNT(*), COALESCE(SUM(`+usageSizeExpr+`), 0) FROM logData WHERE account = ?
+		ON CONFLICT (account) DO UPDATE SET rows = excluded.rows, bytes = excluded.bytes`, account, account)
+	return err
+}
+
+// quotaFor returns the effective quota of account: its override from
+// ACCOUNT_QUOTAS, else the global defaults.
+func quotaFor(cfg *Config, account string) Quota {
+	if q, ok := cfg.Live().AccountQuotas[account]; ok {
+		return q
+	}
+	return cfg.Live().DefaultQuota
+}
+
+// checkQuota returns a *quotaError when account has reached its quota, by
+// its usage in q.
+func checkQuota(q rowQuerier, cfg *Config, account string) error {
+	quota := quotaFor(cfg, account)
+	if quota.MaxRows == 0 && quota.MaxBytes == 0 {
+		return nil
+	}
+	var rows, bytes int64
+	err := writeStatements.queryRow(context.Background(), q, usageSQL, account).Scan(&rows, &bytes)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read usage: %v", err)
+	}
+	if quota.MaxBytes > 0 && bytes >= quota.MaxBytes {
+		return &quotaError{status: http.StatusInsufficientStorage, msg: fmt.Sprintf("Storage quota of %d bytes exceeded", quota.MaxBytes)}
+	}
+	if quota.MaxRows > 0 && rows >= quota.MaxRows {
+		return &quotaError{status: http.StatusTooManyRequests, msg: fmt.Sprintf("Row quota of %d entries exceeded", quota.MaxRows)}
+	}
+	return nil
+}
+
+// handleGetUsage serves GET /usage?account=. Admins may omit account to list all.
+func handleGetUsage(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		account := r.URL.Query().Get("account")
+		if account == "" && !isAdmin(r, cfg) {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		sqlQuery := "SELECT account, rows, bytes, redactions, dropped FROM account_usage"
+		var args []interface{}
+		if account != "" {
+			sqlQuery += " WHERE account = ?"
+			args = append(args, account)
+		}
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		rows, err := db.QueryContext(ctx, sqlQuery+" ORDER BY account", args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying usage", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch usage")
+			return
+		}
+		defer rows.Close()
+
+		usage := []Usage{}
+		for rows.Next() {
+			var u Usage
+			if err := rows.Scan(&u.Account, &u.Rows, &u.Bytes, &u.Redactions, &u.Dropped); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			quota := quotaFor(cfg, u.Account)
+			u.MaxRows, u.MaxBytes = quota.MaxRows, quota.MaxBytes
+			usage = append(usage, u)
+		}
+		if account != "" && len(usage) == 0 {
+			quota := quotaFor(cfg, account)
+			usage = append(usage, Usage{Account: account, MaxRows: quota.MaxRows, MaxBytes: quota.MaxBytes})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if account != "" {
+			json.NewEncoder(w).Encode(usage[0])
+			return
+		}
+		json.NewEncoder(w).Encode(usage)
+	}
+}
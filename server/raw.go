@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RawIngestResult is the response of POST /logdata/raw.
+type RawIngestResult struct {
+	Lines    int `json:"lines"`
+	Stored   int `json:"stored"`
+	Rejected int `json:"rejected"`
+}
+
+// rawParam returns the name parameter of a raw ingestion request, or else
+// its X-Logdata-<Name> header.
+func rawParam(r *http.Request, name string) string {
+	if v := r.URL.Query().Get(name); v != "" {
+		return v
+	}
+	return r.Header.Get("X-Logdata-" + strings.ToUpper(name[:1]) + name[1:])
+}
+
+// lineLevel returns the level a raw line starts with, as in "ERROR: ..." or
+// "[warn] ...", and whether it has one.
+func lineLevel(line string) (string, bool) {
+	word, _, _ := strings.Cut(line, " ")
+	word = strings.ToLower(strings.Trim(word, "[]:"))
+	_, ok := levelNames[word]
+	return word, ok
+}
+
+// handleRawLogData serves POST /logdata/raw: a text body of one entry per
+// line, for shell scripts and cron jobs. The account comes from X-Account or
+// the account parameter; system and module, and optionally user, task and
+// the default level, from parameters or X-Logdata-* headers. Entries are
+// timestamped when received, and take the level a line starts with.
+func handleRawLogData(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		account := requestAccount(r)
+		if account == "" {
+			requestLogger(r).Warn("Missing X-Account header")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "X-Account header or account parameter required")
+			return
+		}
+		base := LogData{
+			Account: account,
+			System:  rawParam(r, "system"),
+			User:    rawParam(r, "user"),
+			Module:  rawParam(r, "module"),
+			Task:    rawParam(r, "task"),
+		}
+		if base.System == "" || base.Module == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "system and module are required")
+			return
+		}
+		if base.User == "" {
+			base.User = base.System
+		}
+		if base.Task == "" {
+			base.Task = "raw"
+		}
+		level := rawParam(r, "level")
+		base.Level, base.levelName = parseLevel(level, LevelInfo), levelText(level)
+		if base.TTL = rawParam(r, "ttl"); base.TTL != "" {
+			if d, err := parseRelativeDuration(base.TTL); err != nil || d <= 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "ttl must be a positive duration such as 30m or 2d")
+				return
+			}
+		}
+
+		var lines []string
+		reader := bufio.NewReader(r.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if line = strings.TrimRight(line, "\r\n"); strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+			if err == io.EOF {
+				break
+			}
+			if isBodyTooLarge(err) {
+				requestLogger(r).Warn("Request body too large", "err", err)
+				writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+					fmt.Sprintf("Request body exceeds %d bytes", cfg.Live().MaxBodyBytes), nil)
+				return
+			}
+			if err != nil {
+				requestLogger(r).Error("Error reading request body", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to read request body")
+				return
+			}
+		}
+		if limit := accountSettings.maxBatchSize(cfg, account); len(lines) > limit {
+			requestLogger(r).Warn("Raw batch too large", "lines", len(lines))
+			writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+				fmt.Sprintf("Batch exceeds %d entries", limit), nil)
+			return
+		}
+
+		res := RawIngestResult{Lines: len(lines)}
+		for _, line := range lines {
+			logData := base
+			logData.Msg = line
+			if name, ok := lineLevel(line); ok {
+				logData.Level, logData.levelName = levelNames[name], name
+			}
+			if errs := logData.checkLimits(cfg); len(errs) > 0 {
+				payload, _ := json.Marshal(logData)
+				rejectLog(db, cfg, account, payload, "Payload limits exceeded")
+				requestLogger(r).Warn("Skipping oversized raw line", "errors", errs)
+				res.Rejected++
+				continue
+			}
+			now := timeNow().UTC()
+			logData.Timestamp, logData.ReceivedAt = now, &now
+			logData.resolveExpiry(now)
+			sources.enrich(&logData, clientAddr(r, cfg))
+			var quotaErr *quotaError
+			if err := insertLogData(db, cfg, &logData); errors.As(err, &quotaErr) {
+				requestLogger(r).Warn("Rejected raw log data", "account", account, "err", err)
+				writeError(w, quotaErr.status, codeQuotaExceeded, err.Error())
+				return
+			} else if err != nil {
+				requestLogger(r).Error("Error saving log data", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save log data")
+				return
+			}
+			res.Stored++
+		}
+
+		requestLogger(r).Info("Stored raw log data", "account", account, "stored", res.Stored, "lines", res.Lines)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaCheckInterval is how often the staleness of read replicas is
+// measured.
+const replicaCheckInterval = time.Second
+
+// readReplica is the database of a read replica opened read-only. staleness
+// is how long ago the oldest entry it misses was received, and ok is false
+// until it is first measured or when measuring fails.
+type readReplica struct {
+	name      string
+	db        *sql.DB
+	mu        sync.RWMutex
+	staleness time.Duration
+	ok        bool
+}
+
+// replicaRouter sends heavy reads to the read replicas fresh enough, in
+// turn, and to the primary's read pool when none is.
+type replicaRouter struct {
+	primary      *sql.DB
+	replicas     []*readReplica
+	maxStaleness time.Duration
+	next         atomic.Uint64
+}
+
+// openReadReplicas opens the databases of cfg.ReadReplicas, returning nil
+// when there are none.
+func openReadReplicas(cfg *Config, primary *sql.DB) (*replicaRouter, error) {
+	if len(cfg.ReadReplicas) == 0 {
+		return nil, nil
+	}
+	rr := &replicaRouter{primary: primary, maxStaleness: cfg.ReadReplicaMaxStaleness}
+	for i, dsn := range cfg.ReadReplicas {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		db, err := sql.Open(sqliteDriver, fmt.Sprintf("%s%s_busy_timeout=%d&_query_only=1", dsn, sep, cfg.SQLiteBusyTimeout.Milliseconds()))
+		if err != nil {
+			rr.close()
+			return nil, fmt.Errorf("failed to open read replica %s: %v", dsn, err)
+		}
+		db.SetMaxOpenConns(cfg.SQLiteReadConns)
+		db.SetMaxIdleConns(cfg.SQLiteReadIdleConns)
+		db.SetConnMaxLifetime(cfg.SQLiteConnMaxLifetime)
+		db.SetConnMaxIdleTime(cfg.SQLiteConnMaxIdleTime)
+		rr.replicas = append(rr.replicas, &readReplica{name: fmt.Sprintf("replica-%d", i+1), db: db})
+	}
+	rr.check()
+	return rr, nil
+}
+
+// run measures the staleness of the replicas every replicaCheckInterval.
+func (rr *replicaRouter) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for tick(stop, ticker) {
+		rr.check()
+	}
+}
+
+// check measures how stale each replica is: the entries it misses are those
+// with an id above its own highest, and it is as stale as the first of them
+// is old.
+func (rr *replicaRouter) check() {
+	now := timeNow()
+	for _, rep := range rr.replicas {
+		staleness, err := rr.measure(rep, now)
+		rep.mu.Lock()
+		if err != nil && rep.ok {
+			slog.Warn("Read replica unavailable", "replica", rep.name, "err", err)
+		}
+		rep.staleness, rep.ok = staleness, err == nil
+		rep.mu.Unlock()
+	}
+}
+
+func (rr *replicaRouter) measure(rep *readReplica, now time.Time) (time.Duration, error) {
+	var lastID int64
+	if err := rep.db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM logData").Scan(&lastID); err != nil {
+		return 0, err
+	}
+	var received sql.NullString
+	err := rr.primary.QueryRow("SELECT COALESCE(received_at, timestamp) FROM logData WHERE id > ? ORDER BY id LIMIT 1", lastID).Scan(&received)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return max(now.Sub(storedTime(received.String)), 0), nil
+}
+
+// pick returns the next replica whose staleness is within bounds, or nil.
+func (rr *replicaRouter) pick() (*readReplica, time.Duration) {
+	n := uint64(len(rr.replicas))
+	start := rr.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		rep := rr.replicas[(start+i)%n]
+		rep.mu.RLock()
+		staleness, ok := rep.staleness, rep.ok
+		rep.mu.RUnlock()
+		if ok && staleness <= rr.maxStaleness {
+			return rep, staleness
+		}
+	}
+	return nil, 0
+}
+
+// route serves reads with a handler built for each replica and one for the
+// primary's read pool, reporting the database used in X-Read-Source and, on
+// replicas, its staleness in seconds in X-Replica-Staleness. Without
+// replicas it is the primary's handler alone.
+func (rr *replicaRouter) route(primary *sql.DB, cfg *Config, build func(*sql.DB, *Config) http.HandlerFunc) http.HandlerFunc {
+	onPrimary := build(primary, cfg)
+	if rr == nil {
+		return onPrimary
+	}
+	handlers := make(map[*readReplica]http.HandlerFunc, len(rr.replicas))
+	for _, rep := range rr.replicas {
+		handlers[rep] = build(rep.db, cfg)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !needsPrimary(r) {
+			if rep, staleness := rr.pick(); rep != nil {
+				w.Header().Set("X-Read-Source", rep.name)
+				w.Header().Set("X-Replica-Staleness", fmt.Sprintf("%.3f", staleness.Seconds()))
+				handlers[rep](w, r)
+				return
+			}
+		}
+		w.Header().Set("X-Read-Source", "primary")
+		onPrimary(w, r)
+	}
+}
+
+// needsPrimary reports whether r filters on a custom column, as replicas
+// only know their own.
+func needsPrimary(r *http.Request) bool {
+	query := r.URL.Query()
+	for key := range query {
+		prefix, name, ok := strings.Cut(key, ".")
+		if ok && strings.HasPrefix(prefix, "field") {
+			if _, custom := customColumns.column(query.Get("account"), name); custom {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (rr *replicaRouter) close() {
+	if rr == nil {
+		return
+	}
+	for _, rep := range rr.replicas {
+		rep.db.Close()
+	}
+}
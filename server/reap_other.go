@@ -0,0 +1,6 @@
+//go:build !unix
+
+package server
+
+// reapChildren is only needed for PID 1 on Unix.
+func reapChildren() {}
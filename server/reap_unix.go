@@ -0,0 +1,29 @@
+//go:build unix
+
+package server
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reapChildren waits for the orphaned processes reparented to the server
+// when it runs as PID 1 in a container, such as those of `docker exec`
+// sessions, which would otherwise remain zombies. The server starts no
+// processes of its own.
+func reapChildren() {
+	if os.Getpid() != 1 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGCHLD)
+	for range signals {
+		for {
+			var status syscall.WaitStatus
+			if pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil); pid <= 0 || err != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Receipt statuses.
+const (
+	receiptPending    = "pending"
+	receiptStored     = "stored"
+	receiptSampledOut = "sampled_out"
+	receiptFailed     = "failed"
+)
+
+// Receipt reports what became of an entry accepted with ack=async.
+type Receipt struct {
+	ID      string `json:"id"`
+	Account string `json:"account"`
+	Status  string `json:"status"`
+	// ULID is the stored entry's, which differs from ID when the entry
+	// was merged into an earlier duplicate.
+	ULID  string `json:"ulid,omitempty"`
+	Error string `json:"error,omitempty"`
+	// AcceptedAt and CompletedAt are unknown for receipts recovered from
+	// the database.
+	AcceptedAt  *time.Time `json:"accepted_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// asyncWrite is an entry accepted with ack=async, waiting to be stored.
+type asyncWrite struct {
+	logData LogData
+	key     string
+	body    []byte
+}
+
+// asyncWriter stores ack=async entries from a queue of cfg.AsyncAckQueueSize
+// and keeps their receipts in memory for cfg.ReceiptTTL after completion.
+type asyncWriter struct {
+	db    *sql.DB
+	cfg   *Config
+	queue chan asyncWrite
+	done  chan struct{}
+	// stop ends expire when the writer is closed.
+	stop chan struct{}
+
+	mu       sync.Mutex
+	receipts map[string]*Receipt
+	closed   bool
+}
+
+func newAsyncWriter(db *sql.DB, cfg *Config) *asyncWriter {
+	aw := &asyncWriter{
+		db:       db,
+		cfg:      cfg,
+		queue:    make(chan asyncWrite, cfg.AsyncAckQueueSize),
+		done:     make(chan struct{}),
+		stop:     make(chan struct{}),
+		receipts: map[string]*Receipt{},
+	}
+	go aw.run()
+	go aw.expire(time.Minute)
+	return aw
+}
+
+// enqueue queues an entry under a pending receipt named by its ULID, or
+// reports false when the queue is full or closed.
+func (aw *asyncWriter) enqueue(write asyncWrite) bool {
+	id := write.logData.ULID
+	now := timeNow().UTC()
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	if aw.closed {
+		return false
+	}
+	aw.receipts[id] = &Receipt{ID: id, Account: write.logData.Account, Status: receiptPending, AcceptedAt: &now}
+	select {
+	case aw.queue <- write:
+		return true
+	default:
+		delete(aw.receipts, id)
+		return false
+	}
+}
+
+// run stores queued entries one at a time, as POST /logdata would.
+func (aw *asyncWriter) run() {
+	defer close(aw.done)
+	for write := range aw.queue {
+		id := write.logData.ULID
+		logData := write.logData
+		var err error
+		inserted := true
+		if write.key != "" {
+			inserted, err = insertLogDataIdempotent(aw.db, aw.cfg, logData.Account, write.key, &logData, http.StatusOK)
+		} else {
+			err = insertLogData(aw.db, aw.cfg, &logData)
+		}
+		var quotaErr *quotaError
+		switch {
+		case err == nil && !inserted:
+			// Another request stored an entry with the key first
+			if _, stored, ok := lookupIdempotentResponse(aw.db, aw.cfg, logData.Account, write.key); ok {
+				var response struct {
+					ULID string `json:"ulid"`
+				}
+				if json.Unmarshal([]byte(stored), &response) == nil && response.ULID != "" {
+					aw.complete(id, receiptStored, response.ULID, "")
+					break
+				}
+			}
+			aw.complete(id, receiptFailed, "", "Idempotency key already used")
+		case errors.As(err, &quotaErr):
+			slog.Warn("Rejected async log data", "account", logData.Account, "receipt", id, "err", err)
+			aw.complete(id, receiptFailed, "", err.Error())
+		case err != nil:
+			slog.Error("Error saving async log data", "receipt", id, "err", err)
+			rejectLog(aw.db, aw.cfg, logData.Account, write.body, fmt.Sprintf("Failed to save log data: %v", err))
+			aw.complete(id, receiptFailed, "", "Failed to save log data")
+		case logData.ULID == "":
+			aw.complete(id, receiptSampledOut, "", "")
+		default:
+			aw.complete(id, receiptStored, logData.ULID, "")
+		}
+	}
+}
+
+// close stops accepting entries and waits until the queued ones are
+// stored or ctx is done. Closing it again only waits.
+func (aw *asyncWriter) close(ctx context.Context) {
+	aw.mu.Lock()
+	if !aw.closed {
+		aw.closed = true
+		close(aw.queue)
+		close(aw.stop)
+	}
+	aw.mu.Unlock()
+	select {
+	case <-aw.done:
+	case <-ctx.Done():
+		slog.Warn("Async entries still queued at shutdown", "entries", len(aw.queue))
+	}
+}
+
+func (aw *asyncWriter) complete(id, status, ulid, errMsg string) {
+	now := timeNow().UTC()
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	if receipt, ok := aw.receipts[id]; ok {
+		receipt.Status, receipt.ULID, receipt.Error, receipt.CompletedAt = status, ulid, errMsg, &now
+	}
+}
+
+// receipt returns a copy of the receipt id of account.
+func (aw *asyncWriter) receipt(id, account string) (Receipt, bool) {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	receipt, ok := aw.receipts[id]
+	if !ok || receipt.Account != account {
+		return Receipt{}, false
+	}
+	copied := *receipt
+	return copied, true
+}
+
+// expire drops completed receipts older than cfg.ReceiptTTL every interval,
+// until the writer is closed.
+func (aw *asyncWriter) expire(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-aw.stop:
+			return
+		}
+		cutoff := timeNow().Add(-aw.cfg.ReceiptTTL)
+		aw.mu.Lock()
+		for id, receipt := range aw.receipts {
+			if receipt.CompletedAt != nil && receipt.CompletedAt.Before(cutoff) {
+				delete(aw.receipts, id)
+			}
+		}
+		aw.mu.Unlock()
+	}
+}
+
+// handleGetReceipt serves GET /receipts/{id}?account=. Receipts no longer in
+// memory (expired, or from before a restart) are reported stored when the
+// entry is in the database, and 404 otherwise.
+func handleGetReceipt(db *sql.DB, aw *asyncWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		id := strings.ToUpper(strings.Trim(strings.TrimPrefix(r.URL.Path, "/receipts/"), "/"))
+		if !ulidRe.MatchString(id) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid receipt ID")
+			return
+		}
+		account := requestAccount(r)
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		receipt, ok := aw.receipt(id, account)
+		if !ok {
+			ts := ulidTime(id)
+			var exists bool
+			err := db.QueryRowContext(r.Context(), "SELECT 1 FROM "+logDataSource(ts, ts.Add(time.Millisecond))+" WHERE account = ? AND ulid = ?",
+				account, id).Scan(&exists)
+			if err != nil && err != sql.ErrNoRows {
+				requestLogger(r).Error("Error looking up receipt", "receipt", id, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch receipt")
+				return
+			}
+			if !exists {
+				writeError(w, http.StatusNotFound, codeNotFound, "Receipt not found")
+				return
+			}
+			receipt = Receipt{ID: id, Account: account, Status: receiptStored, ULID: id}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(receipt)
+	}
+}
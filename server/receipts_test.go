@@ -0,0 +1,56 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"log-server/server"
+	"log-server/server/testutil"
+)
+
+func TestAsyncDuplicateKeyReceipt(t *testing.T) {
+	// The writer holds the first entry until the second, with the same
+	// key, is queued behind it
+	release := make(chan struct{})
+	srv := testutil.NewServer(t, nil, server.WithEntryInterceptors(func(entry *server.LogData) {
+		if entry.Msg == "first" {
+			<-release
+		}
+	}))
+	post := func(msg string) string {
+		body, _ := json.Marshal(srv.Entry("acme", msg))
+		rec := serve(srv, http.MethodPost, "/logdata?ack=async", string(body), map[string]string{"X-Account": "acme", "Idempotency-Key": "key-1"})
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("POST /logdata?ack=async: %d %s", rec.Code, rec.Body.String())
+		}
+		var res struct{ Receipt string }
+		json.NewDecoder(rec.Body).Decode(&res)
+		return res.Receipt
+	}
+	first, second := post("first"), post("second")
+	close(release)
+
+	receipt := func(id string) server.Receipt {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			var receipt server.Receipt
+			rec := serve(srv, http.MethodGet, "/receipts/"+id+"?account=acme", "", nil)
+			if err := json.NewDecoder(rec.Body).Decode(&receipt); err == nil && receipt.Status != "pending" {
+				return receipt
+			}
+		}
+		t.Fatalf("receipt %s still pending", id)
+		return server.Receipt{}
+	}
+	stored := receipt(first)
+	if stored.Status != "stored" || stored.ULID != first {
+		t.Fatalf("first receipt = %+v, want stored as %s", stored, first)
+	}
+	if duplicate := receipt(second); duplicate.Status != "stored" || duplicate.ULID != first {
+		t.Fatalf("second receipt = %+v, want the first entry's ULID %s", duplicate, first)
+	}
+	if entries := srv.Entries("acme", nil); len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+}
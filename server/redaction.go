@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RedactionRule masks sensitive data in the msg and string fields of the
+// entries of Account and Module (empty matches any) before they are stored.
+// Detectors names built-in detectors (see redactionDetectors) and Patterns
+// adds RE2 patterns; matches are replaced by Replacement, "[REDACTED]" by
+// default.
+type RedactionRule struct {
+	Account     string   `json:"account,omitempty"`
+	Module      string   `json:"module,omitempty"`
+	Detectors   []string `json:"detectors,omitempty"`
+	Patterns    []string `json:"patterns,omitempty"`
+	Replacement string   `json:"replacement,omitempty"`
+
+	redactors []redactor
+	// secretKeys masks whole string fields with a secret-sounding key.
+	secretKeys bool
+}
+
+// redactor masks the matches of re, or only their value group when it is
+// set. valid, when set, confirms a match before it is masked.
+type redactor struct {
+	re    *regexp.Regexp
+	value int
+	valid func(string) bool
+}
+
+// secretKeyRe matches field keys whose values the token detector masks whole.
+var secretKeyRe = regexp.MustCompile(`(?i)^(?:password|passwd|pwd|secret|.*_secret|api[_-]?key|access[_-]?key|.*token|authorization|cookie)$`)
+
+// redactionDetectors are the detectors REDACTION_RULES may name.
+var redactionDetectors = map[string][]redactor{
+	"email": {{re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)}},
+	"credit_card": {{
+		re:    regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid: luhnValid,
+	}},
+	"token": {
+		// Authorization headers
+		{re: regexp.MustCompile(`(?i)\b(?:bearer|basic)\s+([A-Za-z0-9._~+/-]+=*)`), value: 1},
+		// key=value and key: value pairs with a secret-sounding key
+		{re: regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|api[_-]?key|access[_-]?key|token)["']?\s*[=:]\s*["']?([^\s"',;&]+)`), value: 1},
+		// JSON Web Tokens
+		{re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+		// AWS access key IDs and GitHub, GitLab and Slack tokens
+		{re: regexp.MustCompile(`\b(?:AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|glpat-[A-Za-z0-9_-]{20,}|xox[abprs]-[A-Za-z0-9-]{10,})\b`)},
+	},
+}
+
+// compileRedactionRules validates rules and compiles their detectors and
+// patterns.
+func compileRedactionRules(rules []RedactionRule) error {
+	for i := range rules {
+		rule := &rules[i]
+		if len(rule.Detectors) == 0 && len(rule.Patterns) == 0 {
+			return fmt.Errorf("rule %d: detectors or patterns required", i)
+		}
+		for _, name := range rule.Detectors {
+			detector, ok := redactionDetectors[name]
+			if !ok {
+				return fmt.Errorf("rule %d: unknown detector %q", i, name)
+			}
+			rule.redactors = append(rule.redactors, detector...)
+			rule.secretKeys = rule.secretKeys || name == "token"
+		}
+		for _, pattern := range rule.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("rule %d: invalid pattern: %v", i, err)
+			}
+			rule.redactors = append(rule.redactors, redactor{re: re})
+		}
+		if rule.Replacement == "" {
+			rule.Replacement = "[REDACTED]"
+		}
+	}
+	return nil
+}
+
+// redact applies every REDACTION_RULES rule matching logData to its msg, stack
+// trace and the string values of its fields, returning the number of matches
+// masked.
+func redact(cfg *Config, logData *LogData) int {
+	count := 0
+	for _, rule := range cfg.Live().RedactionRules {
+		if (rule.Account != "" && rule.Account != logData.Account) ||
+			(rule.Module != "" && rule.Module != logData.Module) {
+			continue
+		}
+		var n int
+		logData.Msg, n = rule.redactString(logData.Msg)
+		count += n
+		logData.StackTrace, n = rule.redactString(logData.StackTrace)
+		count += n
+		if logData.Fields != nil {
+			_, n = rule.redactValue(logData.Fields)
+			count += n
+		}
+	}
+	return count
+}
+
+// redactPayload applies every REDACTION_RULES rule of account to a raw
+// ingestion payload. Rules limited to a module apply too, since the payload
+// may not parse.
+func redactPayload(cfg *Config, account, payload string) string {
+	for _, rule := range cfg.Live().RedactionRules {
+		if rule.Account == "" || rule.Account == account {
+			payload, _ = rule.redactString(payload)
+		}
+	}
+	return payload
+}
+
+// redactValue redacts strings in a decoded JSON value, descending into
+// objects and arrays.
+func (rule RedactionRule) redactValue(value any) (any, int) {
+	count := 0
+	switch v := value.(type) {
+	case string:
+		return rule.redactString(v)
+	case map[string]any:
+		for key, inner := range v {
+			if s, ok := inner.(string); ok && s != "" && rule.secretKeys && secretKeyRe.MatchString(key) {
+				v[key] = rule.Replacement
+				count++
+				continue
+			}
+			var n int
+			v[key], n = rule.redactValue(inner)
+			count += n
+		}
+	case []any:
+		for i, inner := range v {
+			var n int
+			v[i], n = rule.redactValue(inner)
+			count += n
+		}
+	}
+	return value, count
+}
+
+func (rule RedactionRule) redactString(s string) (string, int) {
+	count := 0
+	for _, r := range rule.redactors {
+		matches := r.re.FindAllStringSubmatchIndex(s, -1)
+		if matches == nil {
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			start, end := m[2*r.value], m[2*r.value+1]
+			if start < 0 || (r.valid != nil && !r.valid(s[start:end])) {
+				continue
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(rule.Replacement)
+			last = end
+			count++
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+	}
+	return s, count
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum used by
+// card numbers.
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
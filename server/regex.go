@@ -0,0 +1,81 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is go-sqlite3 with the REGEXP and unpack functions
+// registered on every connection.
+const sqliteDriver = "sqlite3_logdata"
+
+const (
+	// maxRegexLength and maxRegexInsts bound msg_regex patterns. Go regexps
+	// run in linear time, so these only cap the per-row cost.
+	maxRegexLength = 512
+	maxRegexInsts  = 5000
+	// maxCachedRegexps bounds the compiled pattern cache.
+	maxCachedRegexps = 256
+)
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("regexp", sqliteRegexp, true); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("unpack", sqliteUnpack, true)
+		},
+	})
+}
+
+var regexpCache = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: map[string]*regexp.Regexp{}}
+
+// sqliteRegexp implements "value REGEXP pattern" for patterns accepted by
+// compileMsgRegex.
+func sqliteRegexp(pattern, value string) (bool, error) {
+	regexpCache.Lock()
+	re, ok := regexpCache.m[pattern]
+	regexpCache.Unlock()
+	if !ok {
+		var err error
+		if re, err = compileMsgRegex(pattern); err != nil {
+			return false, err
+		}
+		regexpCache.Lock()
+		if len(regexpCache.m) >= maxCachedRegexps {
+			clear(regexpCache.m)
+		}
+		regexpCache.m[pattern] = re
+		regexpCache.Unlock()
+	}
+	return re.MatchString(value), nil
+}
+
+// compileMsgRegex compiles a msg_regex pattern (RE2 syntax), rejecting
+// overlong or overly complex patterns.
+func compileMsgRegex(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > maxRegexLength {
+		return nil, fmt.Errorf("Invalid msg_regex: longer than %d characters", maxRegexLength)
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid msg_regex: %v", err)
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid msg_regex: %v", err)
+	}
+	if len(prog.Inst) > maxRegexInsts {
+		return nil, fmt.Errorf("Invalid msg_regex: pattern too complex")
+	}
+	return regexp.Compile(pattern)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/joho/godotenv"
+)
+
+// envFile holds environment variables read at startup and on every reload.
+// Variables set in the process environment take precedence over it.
+const envFile = ".env"
+
+// liveEnv lists the variables behind LiveConfig, which a reload applies.
+// Changes to other variables take effect on the next restart.
+var liveEnv = map[string]bool{
+	"MAX_BODY_BYTES": true, "MAX_MSG_LENGTH": true, "MAX_FIELD_LENGTH": true, "MAX_BATCH_SIZE": true,
+	"IMPORT_BATCH_SIZE": true, "QUOTA_MAX_ROWS": true, "QUOTA_MAX_BYTES": true, "ACCOUNT_QUOTAS": true,
+	"RETENTION_PERIOD": true, "SAMPLING_RULES": true, "REDACTION_RULES": true, "DEDUP_WINDOW": true,
+	"TIMESTAMP_MAX_FUTURE": true, "TIMESTAMP_MAX_PAST": true, "TIMESTAMP_SKEW_ACTION": true,
+	"QUERY_TIMEOUT": true, "MAX_QUERY_ROWS": true, "MAX_QUERY_SCAN_ROWS": true, "LOG_LEVEL": true,
+}
+
+var reloadMu sync.Mutex
+
+// fileEnv holds the variables last taken from envFile.
+var fileEnv = map[string]string{}
+
+// loadEnvFile sets the variables of envFile that the process environment
+// does not, unsets those removed from it since the last load, and returns
+// the names of the variables it changed.
+func loadEnvFile() ([]string, error) {
+	values, err := godotenv.Read(envFile)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for name := range fileEnv {
+		if _, ok := values[name]; !ok {
+			os.Unsetenv(name)
+			changed = append(changed, name)
+		}
+	}
+	loaded := map[string]string{}
+	for name, value := range values {
+		if _, fromFile := fileEnv[name]; !fromFile {
+			if _, set := os.LookupEnv(name); set {
+				continue
+			}
+		}
+		if old, ok := fileEnv[name]; !ok || old != value {
+			changed = append(changed, name)
+		}
+		os.Setenv(name, value)
+		loaded[name] = value
+	}
+	fileEnv = loaded
+	slices.Sort(changed)
+	return changed, nil
+}
+
+// reloadConfig re-reads envFile and applies the new LiveConfig to cfg. It
+// returns the changed variables that were applied and those that need a
+// restart. An invalid configuration is rejected whole.
+func reloadConfig(cfg *Config) (applied, restart []string, err error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	changed, err := loadEnvFile()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("failed to read %s: %v", envFile, err)
+	}
+	next, err := LoadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := logLevel.UnmarshalText([]byte(next.Live().LogLevel)); err != nil {
+		return nil, nil, fmt.Errorf("invalid LOG_LEVEL %q", next.Live().LogLevel)
+	}
+	cfg.live.Store(next.Live())
+	for _, name := range changed {
+		if liveEnv[name] {
+			applied = append(applied, name)
+		} else {
+			restart = append(restart, name)
+		}
+	}
+	slog.Info("Configuration reloaded", "applied", applied, "restart_required", restart)
+	return applied, restart, nil
+}
+
+// reloadOnSIGHUP reloads the configuration whenever the process gets SIGHUP.
+func reloadOnSIGHUP(cfg *Config) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if _, _, err := reloadConfig(cfg); err != nil {
+			slog.Error("Configuration reload failed; keeping the current configuration", "err", err)
+		}
+	}
+}
+
+// ReloadResponse is the POST /admin/reload response.
+type ReloadResponse struct {
+	Message         string   `json:"message"`
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// handleReload serves POST /admin/reload, which reloads the configuration
+// like SIGHUP does.
+func handleReload(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		applied, restart, err := reloadConfig(cfg)
+		if err != nil {
+			requestLogger(r).Warn("Configuration reload failed", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid configuration: "+err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ReloadResponse{Message: "Configuration reloaded", Applied: applied, RestartRequired: restart})
+	}
+}
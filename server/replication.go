@@ -0,0 +1,343 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Replication lets read replicas follow a primary. A replica pulls entries by
+// id from the primary's GET /replication/entries and stores them with the
+// same id and ULID, so its /getdata answers match the primary's. Replicas
+// refuse writes. The same endpoint moves accounts between shards.
+
+const replicationStateSchema = `CREATE TABLE IF NOT EXISTS replication_state (
+    primary_url TEXT PRIMARY KEY,
+    last_id INTEGER NOT NULL,
+    updated_at DATETIME NOT NULL
+)`
+
+// maxReplicationBatch bounds the limit of one GET /replication/entries.
+const maxReplicationBatch = 10000
+
+// handleReplicationEntries serves GET /replication/entries?after_id=&limit=,
+// the entries with an id above after_id in id order, of one account when
+// account is set. POST imports entries moved from another node.
+func handleReplicationEntries(db, readDB *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listReplicationEntries(readDB, cfg, w, r)
+		case http.MethodPost:
+			importEntries(db, w, r)
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func listReplicationEntries(db *sql.DB, cfg *Config, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	afterID, err := strconv.ParseInt(query.Get("after_id"), 10, 64)
+	if err != nil || afterID < 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "after_id must be a non-negative integer")
+		return
+	}
+	limit := 1000
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxReplicationBatch {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxReplicationBatch))
+			return
+		}
+	}
+
+	ctx, cancel := queryContext(r, cfg)
+	defer cancel()
+	sqlQuery, args := "SELECT "+logDataColumns+", "+hashColumns+" FROM logData WHERE id > ?", []interface{}{afterID}
+	if account := query.Get("account"); account != "" {
+		sqlQuery += " AND account = ?"
+		args = append(args, account)
+	}
+	rows, err := db.QueryContext(ctx, sqlQuery+" ORDER BY id LIMIT ?", append(args, limit)...)
+	if queryAborted(w, r, cfg, err) {
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error querying replication entries", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch entries")
+		return
+	}
+	defer rows.Close()
+
+	entries := []LogData{}
+	for rows.Next() {
+		logData, err := scanHashedLogData(rows)
+		if err != nil {
+			requestLogger(r).Error("Error scanning row", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch entries")
+			return
+		}
+		entries = append(entries, logData)
+	}
+	if queryAborted(w, r, cfg, rows.Err()) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// importEntries stores a JSON array of entries read from another node's
+// GET /replication/entries under new ids, keeping their ULIDs and repeat
+// counts. Entries whose ULID is already stored are skipped, so an
+// interrupted move can be retried. Quotas, sampling and hooks do not apply.
+func importEntries(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	var entries []LogData
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		requestLogger(r).Warn("Invalid request body", "err", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	for i, logData := range entries {
+		if logData.ULID == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Entry %d has no ulid", i))
+			return
+		}
+		if err := logData.Validate(); err != nil {
+			writeErrorDetails(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed for entry %d: %v", i, err), fieldErrors(err, fmt.Sprintf("%d.", i)))
+			return
+		}
+		if partitions != nil {
+			if err := partitions.ensure(db, logData.Timestamp); err != nil {
+				requestLogger(r).Error("Error creating partition", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to import entries")
+				return
+			}
+		}
+		if err := encryption.ensure(db, logData.Account); err != nil {
+			requestLogger(r).Error("Error creating data key", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to import entries")
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		requestLogger(r).Error("Error starting import", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to import entries")
+		return
+	}
+	defer tx.Rollback()
+	var stored, skipped int
+	for _, logData := range entries {
+		inserted, err := importLogDataTx(tx, logData)
+		if err != nil {
+			requestLogger(r).Error("Error importing entry", "ulid", logData.ULID, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to import entries")
+			return
+		}
+		if inserted {
+			stored++
+		} else {
+			skipped++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		requestLogger(r).Error("Error committing import", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to import entries")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"stored": stored, "skipped": skipped})
+}
+
+// withReadOnlyReplica answers every request other than GET and HEAD with 503
+// on a replica, since writes there would diverge from the primary.
+func withReadOnlyReplica(cfg *Config, next http.Handler) http.Handler {
+	if cfg.ReplicateFrom == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			requestLogger(r).Warn("Write rejected on replica", "method", r.Method, "path", r.URL.Path)
+			writeError(w, http.StatusServiceUnavailable, codeReadOnlyReplica, fmt.Sprintf("Read-only replica, write to %s", cfg.ReplicateFrom))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// replicator pulls new entries from the primary at cfg.ReplicateFrom.
+type replicator struct {
+	db     *sql.DB
+	cfg    *Config
+	client *http.Client
+	lastID int64
+}
+
+func newReplicator(db *sql.DB, cfg *Config) (*replicator, error) {
+	if _, err := db.Exec(replicationStateSchema); err != nil {
+		return nil, fmt.Errorf("failed to create replication_state table: %v", err)
+	}
+	rep := &replicator{db: db, cfg: cfg, client: &http.Client{Timeout: time.Minute}}
+	err := db.QueryRow("SELECT last_id FROM replication_state WHERE primary_url = ?", cfg.ReplicateFrom).Scan(&rep.lastID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to load replication state: %v", err)
+	}
+	return rep, nil
+}
+
+// run pulls batches until caught up, then waits interval before polling
+// again. Errors are logged and retried after interval.
+func (rep *replicator) run(stop <-chan struct{}, interval time.Duration) {
+	slog.Info("Replicating from primary", "primary", rep.cfg.ReplicateFrom, "after_id", rep.lastID)
+	for {
+		n, err := rep.pull()
+		if err != nil {
+			slog.Error("Error replicating from primary", "primary", rep.cfg.ReplicateFrom, "err", err)
+		}
+		if (err != nil || n < rep.cfg.ReplicationBatchSize) && !sleep(stop, interval) {
+			return
+		}
+	}
+}
+
+// pull fetches and stores one batch, returning how many entries it held.
+func (rep *replicator) pull() (int, error) {
+	url := fmt.Sprintf("%s/replication/entries?after_id=%d&limit=%d",
+		strings.TrimSuffix(rep.cfg.ReplicateFrom, "/"), rep.lastID, rep.cfg.ReplicationBatchSize)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if rep.cfg.ReplicationToken != "" {
+		req.Header.Set("Authorization", "Bearer "+rep.cfg.ReplicationToken)
+	}
+	resp, err := rep.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("primary returned %s", resp.Status)
+	}
+	var entries []LogData
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return 0, fmt.Errorf("invalid replication response: %v", err)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	if err := rep.store(entries); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// store inserts entries and advances the replication cursor in one
+// transaction, so a crash never skips or duplicates entries.
+func (rep *replicator) store(entries []LogData) error {
+	for _, logData := range entries {
+		if partitions != nil {
+			if err := partitions.ensure(rep.db, logData.Timestamp); err != nil {
+				return err
+			}
+		}
+		if err := encryption.ensure(rep.db, logData.Account); err != nil {
+			return err
+		}
+	}
+	tx, err := rep.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	lastID := rep.lastID
+	for _, logData := range entries {
+		if logData.ID == nil || *logData.ID <= lastID {
+			return fmt.Errorf("primary returned entries out of order")
+		}
+		if err := insertReplicatedTx(tx, logData); err != nil {
+			return fmt.Errorf("failed to store entry %d: %v", *logData.ID, err)
+		}
+		lastID = *logData.ID
+	}
+	if _, err := tx.Exec(`INSERT INTO replication_state (primary_url, last_id, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(primary_url) DO UPDATE SET last_id = excluded.last_id, updated_at = excluded.updated_at`,
+		rep.cfg.ReplicateFrom, lastID, timeNow().UTC()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	rep.lastID = lastID
+	return nil
+}
+
+// insertReplicatedTx stores an entry from the primary as is, keeping its id,
+// ULID and repeat count. Insert hooks do not run on replicas.
+func insertReplicatedTx(ex queryExecer, logData LogData) error {
+	fields, err := encodeFields(logData.Fields)
+	if err != nil {
+		return fmt.Errorf("invalid fields: %v", err)
+	}
+	msg, stackTrace, storedFields, err := packEntry(logData.Account, logData.Msg, logData.StackTrace, fields)
+	if err != nil {
+		return err
+	}
+	if logData.RepeatCount < 1 {
+		logData.RepeatCount = 1
+	}
+	if logData.Fingerprint == "" {
+		logData.Fingerprint = fingerprint(logData)
+	}
+	if err := recordFingerprint(ex, logData); err != nil {
+		return fmt.Errorf("failed to record fingerprint: %v", err)
+	}
+	var receivedAt sql.NullTime
+	if logData.ReceivedAt != nil {
+		receivedAt = sql.NullTime{Time: logData.ReceivedAt.UTC(), Valid: true}
+	}
+	if partitions != nil {
+		if _, err := ex.Exec("UPDATE log_sequence SET id = MAX(id, ?)", *logData.ID); err != nil {
+			return fmt.Errorf("failed to advance log_sequence: %v", err)
+		}
+	}
+	if _, err := ex.Exec(
+		`INSERT INTO `+tableFor(logData.Timestamp)+` (id, account, system, user, module, task, timestamp, msg, level, stack_trace, fields, trace_id, span_id, ulid, repeat_count, sampled_rate, fingerprint, received_at, source_seq, session_id, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		*logData.ID, logData.Account, logData.System, logData.User, logData.Module,
+		logData.Task, logData.Timestamp.UTC(), msg, logData.Level, stackTrace, storedFields,
+		nullString(logData.TraceID), nullString(logData.SpanID), logData.ULID, logData.RepeatCount,
+		sql.NullFloat64{Float64: logData.SampledRate, Valid: logData.SampledRate > 0}, logData.Fingerprint, receivedAt,
+		nullString(logData.SourceSeq), nullString(logData.SessionID), nullTime(logData.ExpiresAt),
+	); err != nil {
+		return err
+	}
+	if err := customColumns.store(ex, *logData.ID, logData); err != nil {
+		return err
+	}
+	// Entries replicated before received_at was kept count as seen on arrival
+	seenAt := timeNow()
+	if receivedAt.Valid {
+		seenAt = receivedAt.Time
+	}
+	if err := recordHeartbeat(ex, logData, seenAt); err != nil {
+		return fmt.Errorf("failed to record heartbeat: %v", err)
+	}
+	// The primary's chain is kept, so replicas verify alike
+	if logData.Hash != "" {
+		err = storeEntryHash(ex, *logData.ID, logData.Account, logData.PrevHash, logData.Hash)
+	} else {
+		err = chainEntry(ex, *logData.ID, logData)
+	}
+	if err != nil {
+		return err
+	}
+	return addUsage(ex, logData.Account, entrySize(logData, msg, stackTrace, storedFields))
+}
@@ -0,0 +1,490 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxReportRows bounds the rows of one report.
+const maxReportRows = 1000
+
+// Report runs a query on a cron schedule and delivers the results as a CSV
+// or HTML summary. The query is a saved search, named by Search, or a
+// /getdata query string. With GroupBy, the report counts the matching
+// entries per value of that column (e.g. errors by module) instead of
+// listing them. Each run covers the trailing WindowSeconds.
+type Report struct {
+	ID            int64      `json:"id"`
+	Account       string     `json:"account"`
+	Name          string     `json:"name"`
+	Schedule      string     `json:"schedule"`
+	Search        string     `json:"search,omitempty"`
+	Query         string     `json:"query,omitempty"`
+	GroupBy       string     `json:"group_by,omitempty"`
+	WindowSeconds int64      `json:"window_seconds"`
+	Format        string     `json:"format"`
+	WebhookURL    string     `json:"webhook_url,omitempty"`
+	Email         string     `json:"email,omitempty"`
+	Enabled       bool       `json:"enabled"`
+	LastRunAt     *time.Time `json:"last_run_at,omitempty"`
+	NextRunAt     *time.Time `json:"next_run_at,omitempty"`
+}
+
+// Validate checks the schedule and query and that the report has somewhere
+// to go.
+func (rep *Report) Validate() error {
+	if rep.Account == "" || rep.Name == "" {
+		return fmt.Errorf("account and name are required")
+	}
+	if _, err := parseCron(rep.Schedule); err != nil {
+		return fmt.Errorf("invalid schedule: %v", err)
+	}
+	if rep.WindowSeconds <= 0 {
+		return fmt.Errorf("window_seconds must be positive")
+	}
+	if rep.Format != "csv" && rep.Format != "html" {
+		return fmt.Errorf("format must be csv or html")
+	}
+	if rep.GroupBy != "" && (!topNColumns[rep.GroupBy] || rep.GroupBy == "trace_id") {
+		return fmt.Errorf("group_by must be system, user, module, task or level")
+	}
+	if rep.Search != "" && rep.Query != "" {
+		return fmt.Errorf("search and query are mutually exclusive")
+	}
+	if rep.Query != "" {
+		search := SavedSearch{Account: rep.Account, Name: "report", Query: rep.Query}
+		if err := search.Validate(); err != nil {
+			return err
+		}
+		rep.Query = search.Query
+	}
+	if rep.WebhookURL == "" && rep.Email == "" {
+		return fmt.Errorf("webhook_url or email is required")
+	}
+	return nil
+}
+
+// ReportEvent is the payload delivered to a report's webhook. Content is
+// the CSV or HTML summary.
+type ReportEvent struct {
+	Report      Report    `json:"report"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	Rows        int       `json:"rows"`
+	ContentType string    `json:"content_type"`
+	Content     string    `json:"content"`
+}
+
+const reportsSchema = `CREATE TABLE IF NOT EXISTS reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account TEXT NOT NULL,
+    name TEXT NOT NULL,
+    schedule TEXT NOT NULL,
+    search TEXT NOT NULL DEFAULT '',
+    query TEXT NOT NULL DEFAULT '',
+    group_by TEXT NOT NULL DEFAULT '',
+    window_seconds INTEGER NOT NULL,
+    format TEXT NOT NULL,
+    webhook_url TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
+    enabled INTEGER NOT NULL DEFAULT 1,
+    last_run_at DATETIME,
+    next_run_at DATETIME
+)`
+
+const reportColumns = "id, account, name, schedule, search, query, group_by, window_seconds, format, webhook_url, email, enabled, last_run_at, next_run_at"
+
+func scanReport(scan func(dest ...interface{}) error) (Report, error) {
+	var rep Report
+	var lastRun, nextRun sql.NullTime
+	err := scan(&rep.ID, &rep.Account, &rep.Name, &rep.Schedule, &rep.Search, &rep.Query, &rep.GroupBy,
+		&rep.WindowSeconds, &rep.Format, &rep.WebhookURL, &rep.Email, &rep.Enabled, &lastRun, &nextRun)
+	if lastRun.Valid {
+		rep.LastRunAt = &lastRun.Time
+	}
+	if nextRun.Valid {
+		rep.NextRunAt = &nextRun.Time
+	}
+	return rep, err
+}
+
+// reportOutput is a rendered report.
+type reportOutput struct {
+	start, end  time.Time
+	rows        int
+	contentType string
+	body        []byte
+}
+
+// generateReport runs rep over the window ending at end and renders it.
+func generateReport(ctx context.Context, db *sql.DB, cfg *Config, rep Report, end time.Time) (reportOutput, error) {
+	out := reportOutput{start: end.Add(-time.Duration(rep.WindowSeconds) * time.Second).UTC(), end: end.UTC()}
+	rawQuery := rep.Query
+	if rep.Search != "" {
+		if err := db.QueryRowContext(ctx, "SELECT query FROM saved_searches WHERE account = ? AND name = ?", rep.Account, rep.Search).Scan(&rawQuery); err != nil {
+			if err == sql.ErrNoRows {
+				return out, fmt.Errorf("saved search %s not found", rep.Search)
+			}
+			return out, err
+		}
+	}
+	query, _ := url.ParseQuery(rawQuery)
+	params, err := parseQueryParams(query)
+	if err != nil {
+		return out, err
+	}
+	params.Account = rep.Account
+	params.StartTime, params.EndTime = out.start.Format(time.RFC3339), out.end.Format(time.RFC3339)
+	limit := int64(maxReportRows)
+	if max := cfg.Live().MaxQueryRows; max > 0 && max < limit {
+		limit = max
+	}
+	params.Limit, params.Offset = &limit, nil
+
+	var table [][]string
+	if rep.GroupBy != "" {
+		table, err = reportCounts(ctx, db, params, rep.GroupBy, limit)
+	} else {
+		table, err = reportEntries(ctx, db, params)
+	}
+	if err != nil {
+		return out, err
+	}
+	out.rows = len(table) - 1
+
+	var buf bytes.Buffer
+	if rep.Format == "html" {
+		out.contentType = "text/html; charset=utf-8"
+		err = reportHTML.Execute(&buf, map[string]interface{}{
+			"Report": rep, "Start": out.start.Format(time.RFC3339), "End": out.end.Format(time.RFC3339),
+			"Header": table[0], "Rows": table[1:],
+		})
+	} else {
+		out.contentType = "text/csv; charset=utf-8"
+		err = csv.NewWriter(&buf).WriteAll(table)
+	}
+	out.body = buf.Bytes()
+	return out, err
+}
+
+// reportEntries lists the entries matching params, newest first, with a
+// header row.
+func reportEntries(ctx context.Context, db *sql.DB, params QueryParams) ([][]string, error) {
+	params.OmitStackTrace = true
+	table := [][]string{{"timestamp", "level", "system", "module", "user", "task", "msg", "repeat_count"}}
+	err := queryEntries(ctx, db, params, func(logData LogData) error {
+		table = append(table, []string{logData.Timestamp.UTC().Format(time.RFC3339Nano), strconv.Itoa(logData.Level),
+			logData.System, logData.Module, logData.User, logData.Task, logData.Msg, strconv.Itoa(max(logData.RepeatCount, 1))})
+		return nil
+	})
+	return table, err
+}
+
+// reportCounts counts the entries matching params per value of column,
+// highest first, with a header row.
+func reportCounts(ctx context.Context, db *sql.DB, params QueryParams, column string, limit int64) ([][]string, error) {
+	where, args := buildLogFilter(params)
+	start, end := params.partitionRange()
+	sqlQuery := fmt.Sprintf("SELECT %s, SUM(repeat_count) FROM %s WHERE %s GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT %d",
+		column, logDataSource(start, end), where, limit)
+	var rowsRead int64
+	defer slowQueries.observe(sqlQuery, args, time.Now(), &rowsRead)
+	rows, err := db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	table := [][]string{{column, "count"}}
+	for rows.Next() {
+		rowsRead++
+		var value string
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, err
+		}
+		table = append(table, []string{value, strconv.FormatInt(count, 10)})
+	}
+	return table, rows.Err()
+}
+
+var reportHTML = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Report.Name}}</title></head>
+<body>
+<h2>{{.Report.Name}}</h2>
+<p>{{.Report.Account}}, {{.Start}} to {{.End}}: {{len .Rows}} rows</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+</body></html>
+`))
+
+// runReports runs the reports that are due every interval.
+func runReports(stop <-chan struct{}, db, readDB *sql.DB, cfg *Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for tick(stop, ticker) {
+		if err := runDueReports(db, readDB, cfg, timeNow()); err != nil {
+			slog.Error("Error running reports", "err", err)
+		}
+	}
+}
+
+func runDueReports(db, readDB *sql.DB, cfg *Config, now time.Time) error {
+	rows, err := db.Query("SELECT "+reportColumns+" FROM reports WHERE enabled = 1 AND next_run_at <= ?", now.UTC())
+	if err != nil {
+		return err
+	}
+	var reports []Report
+	for rows.Next() {
+		rep, err := scanReport(rows.Scan)
+		if err != nil {
+			slog.Error("Error scanning report", "err", err)
+			continue
+		}
+		reports = append(reports, rep)
+	}
+	rows.Close()
+
+	for _, rep := range reports {
+		// Windows end on the minute the report was due
+		if err := deliverReport(readDB, cfg, rep, now.Truncate(time.Minute)); err != nil {
+			slog.Error("Error running report", "report_id", rep.ID, "report", rep.Name, "err", err)
+		}
+		// A failed run is not retried until the next scheduled time
+		schedule, _ := parseCron(rep.Schedule)
+		if _, err := db.Exec("UPDATE reports SET last_run_at = ?, next_run_at = ? WHERE id = ?",
+			now.UTC(), schedule.next(now), rep.ID); err != nil {
+			slog.Error("Error updating report", "report_id", rep.ID, "err", err)
+		}
+	}
+	return nil
+}
+
+// deliverReport generates rep for the window ending at now and sends it to
+// its webhook and email recipients.
+func deliverReport(db *sql.DB, cfg *Config, rep Report, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	out, err := generateReport(ctx, db, cfg, rep, now)
+	if err != nil {
+		return err
+	}
+	slog.Info("Report generated", "report_id", rep.ID, "report", rep.Name, "account", rep.Account, "rows", out.rows)
+	var errs []string
+	if rep.WebhookURL != "" {
+		event := ReportEvent{Report: rep, WindowStart: out.start, WindowEnd: out.end, Rows: out.rows,
+			ContentType: out.contentType, Content: string(out.body)}
+		if err := postJSON(rep.WebhookURL, event); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if rep.Email != "" {
+		subject := fmt.Sprintf("[logdata] Report %s for %s", rep.Name, rep.Account)
+		summary := fmt.Sprintf("%d rows between %s and %s.", out.rows, out.start.Format(time.RFC3339), out.end.Format(time.RFC3339))
+		if rep.Format == "html" {
+			err = sendEmailMessage(cfg, strings.Split(rep.Email, ","), subject, out.contentType, string(out.body))
+		} else {
+			err = sendEmailMessage(cfg, strings.Split(rep.Email, ","), subject, "text/plain; charset=utf-8", summary,
+				emailAttachment{name: rep.Name + ".csv", contentType: out.contentType, data: out.body})
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("delivery failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// handleReports serves the report API:
+// GET/POST /reports, GET/PUT/DELETE /reports/{id},
+// GET /reports/{id}/preview, which renders the report for the window
+// ending now, and POST /reports/{id}/run, which also delivers it.
+func handleReports(db, readDB *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/reports"), "/")
+		if rest == "" {
+			switch r.Method {
+			case http.MethodGet:
+				listReports(db, w, r)
+			case http.MethodPost:
+				saveReport(db, w, r, 0)
+			default:
+				requestLogger(r).Warn("Method not allowed", "method", r.Method)
+				writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			}
+			return
+		}
+
+		idText, action, _ := strings.Cut(rest, "/")
+		id, err := strconv.ParseInt(idText, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid report id")
+			return
+		}
+		if action != "" && action != "preview" && action != "run" {
+			writeError(w, http.StatusNotFound, codeNotFound, "Not found")
+			return
+		}
+		account := r.URL.Query().Get("account")
+		if account == "" && r.Method != http.MethodPut {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && action != "run", r.Method == http.MethodPost && action == "run":
+			rep, err := scanReport(db.QueryRow("SELECT "+reportColumns+" FROM reports WHERE id = ? AND account = ?", id, account).Scan)
+			if err == sql.ErrNoRows {
+				writeError(w, http.StatusNotFound, codeNotFound, "Report not found")
+				return
+			} else if err != nil {
+				requestLogger(r).Error("Error loading report", "report_id", id, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch report")
+				return
+			}
+			switch action {
+			case "preview":
+				out, err := generateReport(r.Context(), readDB, cfg, rep, timeNow())
+				if err != nil {
+					requestLogger(r).Error("Error generating report", "report_id", id, "err", err)
+					writeErrorDetails(w, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Failed to generate report: %v", err), nil)
+					return
+				}
+				w.Header().Set("Content-Type", out.contentType)
+				w.Write(out.body)
+			case "run":
+				if err := deliverReport(readDB, cfg, rep, timeNow()); err != nil {
+					requestLogger(r).Error("Error running report", "report_id", id, "err", err)
+					writeErrorDetails(w, http.StatusBadGateway, codeUpstreamFailed, fmt.Sprintf("Failed to run report: %v", err), nil)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]string{"message": "Report sent"})
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(rep)
+			}
+		case action != "":
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		case r.Method == http.MethodPut:
+			saveReport(db, w, r, id)
+		case r.Method == http.MethodDelete:
+			res, err := db.Exec("DELETE FROM reports WHERE id = ? AND account = ?", id, account)
+			if err != nil {
+				requestLogger(r).Error("Error deleting report", "report_id", id, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete report")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Report not found")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Report deleted"})
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func listReports(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	account := r.URL.Query().Get("account")
+	if account == "" {
+		requestLogger(r).Warn("Missing account query parameter")
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+		return
+	}
+	rows, err := db.Query("SELECT "+reportColumns+" FROM reports WHERE account = ? ORDER BY id", account)
+	if err != nil {
+		requestLogger(r).Error("Error querying reports", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch reports")
+		return
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		rep, err := scanReport(rows.Scan)
+		if err != nil {
+			requestLogger(r).Error("Error scanning row", "err", err)
+			continue
+		}
+		reports = append(reports, rep)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// saveReport creates a report (id 0) or replaces an existing one, scheduling
+// its next run from now.
+func saveReport(db *sql.DB, w http.ResponseWriter, r *http.Request, id int64) {
+	rep := Report{Enabled: true, WindowSeconds: 24 * 60 * 60, Format: "csv"}
+	if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+		requestLogger(r).Warn("Invalid request body", "err", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if err := rep.Validate(); err != nil {
+		requestLogger(r).Warn("Validation failed", "err", err)
+		writeErrorDetails(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err), nil)
+		return
+	}
+	if !accountAllowed(r, rep.Account) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+		return
+	}
+	schedule, _ := parseCron(rep.Schedule)
+	next := schedule.next(timeNow())
+	rep.NextRunAt = &next
+
+	if id == 0 {
+		res, err := db.Exec(`INSERT INTO reports (account, name, schedule, search, query, group_by, window_seconds, format, webhook_url, email, enabled, next_run_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			rep.Account, rep.Name, rep.Schedule, rep.Search, rep.Query, rep.GroupBy, rep.WindowSeconds, rep.Format,
+			rep.WebhookURL, rep.Email, rep.Enabled, next)
+		if err != nil {
+			requestLogger(r).Error("Error saving report", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save report")
+			return
+		}
+		rep.ID, _ = res.LastInsertId()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rep)
+		return
+	}
+
+	res, err := db.Exec(`UPDATE reports SET name = ?, schedule = ?, search = ?, query = ?, group_by = ?, window_seconds = ?,
+		format = ?, webhook_url = ?, email = ?, enabled = ?, next_run_at = ? WHERE id = ? AND account = ?`,
+		rep.Name, rep.Schedule, rep.Search, rep.Query, rep.GroupBy, rep.WindowSeconds, rep.Format,
+		rep.WebhookURL, rep.Email, rep.Enabled, next, id, rep.Account)
+	if err != nil {
+		requestLogger(r).Error("Error updating report", "report_id", id, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save report")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Report not found")
+		return
+	}
+	rep.ID = id
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const levelRetentionSchema = `CREATE TABLE IF NOT EXISTS level_retention (
+    account TEXT NOT NULL,
+    min_level INTEGER NOT NULL,
+    period TEXT NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (account, min_level)
+)`
+
+// RetentionRule keeps the entries of Account with a canonical level from
+// MinLevel up to the account's next rule for Period, instead of
+// RETENTION_PERIOD. Entries below the account's lowest rule follow
+// RETENTION_PERIOD.
+type RetentionRule struct {
+	Account  string `json:"account"`
+	MinLevel int    `json:"min_level"`
+	// Period is a duration such as 72h, 14d or 26w.
+	Period    string    `json:"period"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks that the rule names an account, a level and a period.
+func (rule RetentionRule) Validate() error {
+	if rule.Account == "" {
+		return fmt.Errorf("account is required")
+	}
+	if rule.MinLevel <= 0 {
+		return fmt.Errorf("min_level must be positive")
+	}
+	if d, err := parseRelativeDuration(rule.Period); err != nil || d <= 0 {
+		return fmt.Errorf("period must be a positive duration such as 72h or 14d")
+	}
+	return nil
+}
+
+func (rule RetentionRule) duration() time.Duration {
+	d, _ := parseRelativeDuration(rule.Period)
+	return d
+}
+
+// retentionRules holds the level_retention rows in memory. It is set at
+// startup.
+var retentionRules *retentionRuleSet
+
+type retentionRuleSet struct {
+	db *sql.DB
+
+	mu sync.RWMutex
+	// rules are sorted by account and level.
+	rules []RetentionRule
+}
+
+func newRetentionRuleSet(db *sql.DB) *retentionRuleSet {
+	return &retentionRuleSet{db: db}
+}
+
+// reload refreshes the in-memory rules from the database.
+func (s *retentionRuleSet) reload() error {
+	rows, err := s.db.Query("SELECT account, min_level, period, updated_at FROM level_retention ORDER BY account, min_level")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var rules []RetentionRule
+	for rows.Next() {
+		var rule RetentionRule
+		if err := rows.Scan(&rule.Account, &rule.MinLevel, &rule.Period, &rule.UpdatedAt); err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.rules = rules
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *retentionRuleSet) list() []RetentionRule {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rules
+}
+
+// runRetention removes entries older than RETENTION_PERIOD or their level's
+// retention rule hourly, or every period when it is shorter, and entries
+// past their expires_at every EXPIRY_INTERVAL. It rereads the period each
+// time, so a reload takes effect by the next run; 0 keeps the entries no rule
+// covers. Expired partitions are dropped whole; otherwise rows are deleted.
+// On an edge instance, entries not forwarded yet are kept.
+func runRetention(stop <-chan struct{}, db *sql.DB, cfg *Config) {
+	for {
+		interval := cfg.ExpiryInterval
+		period := cfg.Live().RetentionPeriod
+		rules := retentionRules.list()
+		var cutoff time.Time
+		if period > 0 {
+			cutoff = forwarding.holdBack(timeNow().UTC().Add(-period))
+			interval = min(period, interval)
+		}
+		for _, rule := range rules {
+			interval = min(rule.duration(), interval)
+		}
+		if err := applyRetention(db, cutoff, rules); err != nil {
+			slog.Error("Error applying retention", "err", err)
+		}
+		if !sleep(stop, interval) {
+			return
+		}
+	}
+}
+
+// expiry is the condition selecting the expired entries of a logData table
+// under one rule.
+type expiry struct {
+	where string
+	args  []interface{}
+}
+
+// expiries returns the conditions selecting the entries expired by rules,
+// by cutoff for the entries no rule covers, unless zero, and by their own
+// expires_at. It also returns
+// the cutoff before which every entry expired.
+func expiries(cutoff time.Time, rules []RetentionRule) ([]expiry, time.Time) {
+	var expired []expiry
+	dropCutoff := cutoff
+	covered, coveredArgs := "", []interface{}{}
+	for i, rule := range rules {
+		ruleCutoff := forwarding.holdBack(timeNow().UTC().Add(-rule.duration()))
+		e := expiry{"account = ? AND level >= ? AND timestamp < ?", []interface{}{rule.Account, rule.MinLevel, ruleCutoff}}
+		if i+1 < len(rules) && rules[i+1].Account == rule.Account {
+			e.where += " AND level < ?"
+			e.args = append(e.args, rules[i+1].MinLevel)
+		}
+		expired = append(expired, e)
+		if i == 0 || rules[i-1].Account != rule.Account {
+			covered += " AND NOT (account = ? AND level >= ?)"
+			coveredArgs = append(coveredArgs, rule.Account, rule.MinLevel)
+		}
+		if ruleCutoff.Before(dropCutoff) {
+			dropCutoff = ruleCutoff
+		}
+	}
+	if !cutoff.IsZero() {
+		expired = append(expired, expiry{"timestamp < ?" + covered, append([]interface{}{cutoff}, coveredArgs...)})
+	}
+	own := expiry{"expires_at <= ?", []interface{}{timeNow().UTC()}}
+	if forwarding != nil {
+		own.where += " AND timestamp < ?"
+		own.args = append(own.args, forwarding.holdBack(timeNow().UTC()))
+	}
+	return append(expired, own), dropCutoff
+}
+
+// applyRetention removes entries with a timestamp before cutoff, or before
+// the cutoff of their level's rule, or past their expires_at, and updates
+// the usage of affected accounts. A zero cutoff keeps the entries no rule
+// covers.
+func applyRetention(db *sql.DB, cutoff time.Time, rules []RetentionRule) error {
+	expired, dropCutoff := expiries(cutoff, rules)
+	accounts := map[string]bool{}
+	if partitions != nil && !dropCutoff.IsZero() {
+		dropped, rows, err := partitions.dropBefore(db, dropCutoff)
+		if err != nil {
+			return err
+		}
+		for _, account := range dropped {
+			accounts[account] = true
+		}
+		vacuums.deleted(rows)
+	}
+
+	// Rows left in the remaining tables, including the base partition
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var removed int64
+	for _, table := range logDataTables() {
+		for _, e := range expired {
+			rows, err := tx.Query("SELECT DISTINCT account FROM "+table+" WHERE "+e.where, e.args...)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var account string
+				if err := rows.Scan(&account); err == nil {
+					accounts[account] = true
+				}
+			}
+			rows.Close()
+			if err := deleteEntryRows(tx, "SELECT id FROM "+table+" WHERE "+e.where, e.args...); err != nil {
+				return err
+			}
+			res, err := tx.Exec("DELETE FROM "+table+" WHERE "+e.where, e.args...)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			removed += n
+		}
+	}
+	for account := range accounts {
+		if err := recomputeUsage(tx, account); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if removed > 0 {
+		slog.Info("Removed expired entries", "count", removed, "cutoff", cutoff, "rules", len(rules))
+	}
+	vacuums.deleted(removed)
+	return nil
+}
+
+// handleRetentionRules serves GET, PUT and DELETE /retention, an account's
+// per-level retention rules.
+func handleRetentionRules(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := r.URL.Query().Get("account")
+		if account == "" && r.Method != http.MethodPut {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			rows, err := db.Query("SELECT account, min_level, period, updated_at FROM level_retention WHERE account = ? ORDER BY min_level", account)
+			if err != nil {
+				requestLogger(r).Error("Error querying retention rules", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch retention rules")
+				return
+			}
+			defer rows.Close()
+			rules := []RetentionRule{}
+			for rows.Next() {
+				var rule RetentionRule
+				if err := rows.Scan(&rule.Account, &rule.MinLevel, &rule.Period, &rule.UpdatedAt); err != nil {
+					requestLogger(r).Error("Error scanning row", "err", err)
+					continue
+				}
+				rules = append(rules, rule)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rules)
+
+		case http.MethodPut:
+			var rule RetentionRule
+			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+				requestLogger(r).Warn("Invalid request body", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+				return
+			}
+			if rule.Account == "" {
+				rule.Account = account
+			}
+			if err := rule.Validate(); err != nil {
+				requestLogger(r).Warn("Validation failed", "err", err)
+				writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+				return
+			}
+			if !accountAllowed(r, rule.Account) {
+				writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+				return
+			}
+			rule.UpdatedAt = timeNow().UTC()
+			if _, err := db.Exec(`INSERT INTO level_retention (account, min_level, period, updated_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT (account, min_level) DO UPDATE SET period = excluded.period, updated_at = excluded.updated_at`,
+				rule.Account, rule.MinLevel, rule.Period, rule.UpdatedAt); err != nil {
+				requestLogger(r).Error("Error saving retention rule", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save retention rule")
+				return
+			}
+			if err := retentionRules.reload(); err != nil {
+				requestLogger(r).Error("Error reloading retention rules", "err", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rule)
+
+		case http.MethodDelete:
+			minLevel, err := strconv.Atoi(r.URL.Query().Get("min_level"))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "min_level query parameter required")
+				return
+			}
+			res, err := db.Exec("DELETE FROM level_retention WHERE account = ? AND min_level = ?", account, minLevel)
+			if err != nil {
+				requestLogger(r).Error("Error deleting retention rule", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete retention rule")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Retention rule not found")
+				return
+			}
+			if err := retentionRules.reload(); err != nil {
+				requestLogger(r).Error("Error reloading retention rules", "err", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Retention rule deleted"})
+
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
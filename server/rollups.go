@@ -0,0 +1,228 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Rollup is an aggregated entry count for one time bucket.
+type Rollup struct {
+	Bucket  string `json:"bucket"`
+	Account string `json:"account"`
+	System  string `json:"system"`
+	Module  string `json:"module"`
+	Level   int    `json:"level"`
+	Count   int64  `json:"count"`
+}
+
+// rollupResolutions maps a resolution name to its summary table and bucket format.
+var rollupResolutions = map[string]struct {
+	table  string
+	format string
+}{
+	"hour": {"log_rollups_hourly", "%Y-%m-%d %H:00:00"},
+	"day":  {"log_rollups_daily", "%Y-%m-%d 00:00:00"},
+}
+
+// rollupBucketLayout is the Go layout of stored bucket values.
+const rollupBucketLayout = "2006-01-02 15:04:05"
+
+var rollupSchema = []string{
+	`CREATE TABLE IF NOT EXISTS log_rollups_hourly (
+    bucket TEXT NOT NULL,
+    account TEXT NOT NULL,
+    system TEXT NOT NULL,
+    module TEXT NOT NULL,
+    level INTEGER NOT NULL,
+    count INTEGER NOT NULL,
+    PRIMARY KEY (account, bucket, system, module, level)
+)`,
+	`CREATE TABLE IF NOT EXISTS log_rollups_daily (
+    bucket TEXT NOT NULL,
+    account TEXT NOT NULL,
+    system TEXT NOT NULL,
+    module TEXT NOT NULL,
+    level INTEGER NOT NULL,
+    count INTEGER NOT NULL,
+    PRIMARY KEY (account, bucket, system, module, level)
+)`,
+	// rollup_state keeps the highest logData id already folded into the rollups
+	`CREATE TABLE IF NOT EXISTS rollup_state (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    last_id INTEGER NOT NULL
+)`,
+}
+
+// runRollups folds new logData rows into the summary tables every interval.
+func runRollups(stop <-chan struct{}, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := updateRollups(db); err != nil {
+			slog.Error("Error updating rollups", "err", err)
+		}
+		if !tick(stop, ticker) {
+			return
+		}
+	}
+}
+
+// updateRollups aggregates rows inserted since the previous run. Rollups only
+// ever grow: rows removed from logData later are still counted.
+func updateRollups(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lastID, maxID int64
+	if err := tx.QueryRow("SELECT COALESCE((SELECT last_id FROM rollup_state WHERE id = 1), 0)").Scan(&lastID); err != nil {
+		return fmt.Errorf("failed to read rollup state: %v", err)
+	}
+	if err := tx.QueryRow("SELECT COALESCE(MAX(id), 0) FROM logData").Scan(&maxID); err != nil {
+		return fmt.Errorf("failed to read max id: %v", err)
+	}
+	if maxID <= lastID {
+		return nil
+	}
+
+	for _, res := range rollupResolutions {
+		// "WHERE true" disambiguates the upsert clause from a join constraint
+		_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (bucket, account, system, module, level, count)
+			SELECT * FROM (
+				SELECT strftime('%s', timestamp), account, system, module, level, SUM(repeat_count)
+				FROM logData WHERE id > ? AND id <= ?
+				GROUP BY 1, account, system, module, level
+			) WHERE true
+			ON CONFLICT (account, bucket, system, module, level) DO UPDATE SET count = count + excluded.count`,
+			res.table, res.format), lastID, maxID)
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %v", res.table, err)
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO rollup_state (id, last_id) VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET last_id = excluded.last_id`, maxID); err != nil {
+		return fmt.Errorf("failed to save rollup state: %v", err)
+	}
+	return tx.Commit()
+}
+
+// addRollupRepeat counts a repeat merged into the entry id, of logData's
+// account, system, module and level and in the hourly bucket hour, in the
+// rollups when they already count that entry. Later entries are folded in
+// by updateRollups with their repeats.
+func addRollupRepeat(tx *sql.Tx, logData LogData, id int64, hour string) error {
+	var lastID int64
+	if err := tx.QueryRow("SELECT COALESCE((SELECT last_id FROM rollup_state WHERE id = 1), 0)").Scan(&lastID); err != nil {
+		return fmt.Errorf("failed to read rollup state: %v", err)
+	}
+	if id > lastID {
+		return nil
+	}
+	bucket, err := time.Parse(rollupBucketLayout, hour)
+	if err != nil {
+		return fmt.Errorf("invalid bucket %q: %v", hour, err)
+	}
+	buckets := map[string]time.Time{"hour": bucket, "day": bucket.Truncate(24 * time.Hour)}
+	for name, res := range rollupResolutions {
+		if _, err := tx.Exec("UPDATE "+res.table+" SET count = count + 1 WHERE account = ? AND bucket = ? AND system = ? AND module = ? AND level = ?",
+			logData.Account, buckets[name].Format(rollupBucketLayout), logData.System, logData.Module, logData.Level); err != nil {
+			return fmt.Errorf("failed to update %s: %v", res.table, err)
+		}
+	}
+	return nil
+}
+
+func handleGetRollups(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		resolution := query.Get("resolution")
+		if resolution == "" {
+			resolution = "hour"
+		}
+		res, ok := rollupResolutions[resolution]
+		if !ok {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "resolution must be hour or day")
+			return
+		}
+
+		sqlQuery := "SELECT bucket, account, system, module, level, count FROM " + res.table + " WHERE account = ?"
+		args := []interface{}{account}
+		if system := query.Get("system"); system != "" {
+			sqlQuery += " AND system = ?"
+			args = append(args, system)
+		}
+		if module := query.Get("module"); module != "" {
+			sqlQuery += " AND module = ?"
+			args = append(args, module)
+		}
+		if query.Get("level") != "" {
+			if level, ok := levelSchemes.parse(account, query.Get("level")); ok {
+				sqlQuery += " AND level = ?"
+				args = append(args, level)
+			}
+		}
+		for _, bound := range []struct{ param, op string }{{"start_time", ">="}, {"end_time", "<="}} {
+			value := query.Get(bound.param)
+			if value == "" {
+				continue
+			}
+			t, err := parseTimeBound(value, time.UTC, timeNow())
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid %s: %v", bound.param, err))
+				return
+			}
+			sqlQuery += " AND bucket " + bound.op + " ?"
+			args = append(args, t.UTC().Format(rollupBucketLayout))
+		}
+		sqlQuery += " ORDER BY bucket ASC, system, module, level"
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		rows, err := db.QueryContext(ctx, sqlQuery, args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying rollups", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch rollups")
+			return
+		}
+		defer rows.Close()
+
+		rollups := []Rollup{}
+		for rows.Next() {
+			var rollup Rollup
+			if err := rows.Scan(&rollup.Bucket, &rollup.Account, &rollup.System, &rollup.Module, &rollup.Level, &rollup.Count); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			rollups = append(rollups, rollup)
+		}
+		if err := rows.Err(); queryAborted(w, r, cfg, err) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rollups)
+	}
+}
@@ -0,0 +1,54 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"log-server/server"
+	"log-server/server/testutil"
+)
+
+func TestMergedRepeatCountedInRollups(t *testing.T) {
+	srv := testutil.NewServer(t, map[string]string{"DEDUP_WINDOW": "1m", "ROLLUP_INTERVAL": "1h"})
+	body, _ := json.Marshal(srv.Entry("acme", "connection reset"))
+	post := func() {
+		t.Helper()
+		if rec := serve(srv, http.MethodPost, "/logdata", string(body), map[string]string{"X-Account": "acme"}); rec.Code != http.StatusOK {
+			t.Fatalf("POST /logdata: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	counts := func() map[string]int64 {
+		t.Helper()
+		counts := map[string]int64{}
+		for _, resolution := range []string{"hour", "day"} {
+			var rollups []server.Rollup
+			rec := serve(srv, http.MethodGet, "/rollups?account=acme&resolution="+resolution, "", nil)
+			if err := json.NewDecoder(rec.Body).Decode(&rollups); err != nil {
+				t.Fatalf("GET /rollups: %d %v", rec.Code, err)
+			}
+			for _, rollup := range rollups {
+				counts[resolution] += rollup.Count
+			}
+		}
+		return counts
+	}
+
+	// The rollup job's first run, on start, folds in the entry
+	post()
+	srv.Start()
+	for deadline := time.Now().Add(5 * time.Second); counts()["hour"] == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("entry not rolled up")
+		}
+	}
+	// The duplicate is merged into the entry already rolled up
+	post()
+	if got := counts(); got["hour"] != 2 || got["day"] != 2 {
+		t.Fatalf("rollup counts = %v, want 2 in each resolution", got)
+	}
+	if entries := srv.Entries("acme", nil); len(entries) != 1 || entries[0].RepeatCount != 2 {
+		t.Fatalf("got %+v, want one entry repeated twice", entries)
+	}
+}
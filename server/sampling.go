@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// SamplingRule keeps only Rate (0 to 1) of the entries of Account and Module
+// (empty matches any) with a level at or below MaxLevel (0 matches any).
+type SamplingRule struct {
+	Account  string  `json:"account,omitempty"`
+	Module   string  `json:"module,omitempty"`
+	MaxLevel int     `json:"max_level,omitempty"`
+	Rate     float64 `json:"rate"`
+}
+
+// sampledOutResponse is the POST /logdata response body for a dropped entry.
+const sampledOutResponse = `{"message":"Log data sampled out"}`
+
+func validateSamplingRules(rules []SamplingRule) error {
+	for i, rule := range rules {
+		if rule.Rate < 0 || rule.Rate > 1 {
+			return fmt.Errorf("rule %d: rate must be between 0 and 1", i)
+		}
+	}
+	return nil
+}
+
+// sample applies the first SAMPLING_RULES rule matching logData. It reports
+// whether the entry is kept, recording the rule's rate in SampledRate.
+func sample(cfg *Config, logData *LogData) bool {
+	for _, rule := range cfg.Live().SamplingRules {
+		if (rule.Account != "" && rule.Account != logData.Account) ||
+			(rule.Module != "" && rule.Module != logData.Module) ||
+			(rule.MaxLevel != 0 && logData.Level > rule.MaxLevel) {
+			continue
+		}
+		if rule.Rate < 1 && rand.Float64() >= rule.Rate {
+			return false
+		}
+		if rule.Rate < 1 {
+			logData.SampledRate = rule.Rate
+		}
+		return true
+	}
+	return true
+}
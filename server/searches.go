@@ -0,0 +1,260 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SavedSearch is a named /getdata query string (filters, fields and sort)
+// stored for an account.
+type SavedSearch struct {
+	ID        int64     `json:"id"`
+	Account   string    `json:"account"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Link runs the search through /getdata.
+	Link string `json:"link,omitempty"`
+}
+
+// Validate checks the name and that Query parses as /getdata parameters.
+// The account is always taken from the request, so Query must not set one.
+func (s *SavedSearch) Validate() error {
+	if s.Account == "" || s.Name == "" {
+		return fmt.Errorf("account and name are required")
+	}
+	if strings.ContainsAny(s.Name, "/?#") {
+		return fmt.Errorf("name must not contain '/', '?' or '#'")
+	}
+	query, err := url.ParseQuery(strings.TrimPrefix(s.Query, "?"))
+	if err != nil {
+		return fmt.Errorf("invalid query: %v", err)
+	}
+	if query.Has("account") {
+		return fmt.Errorf("query must not set account")
+	}
+	if _, err := parseQueryParams(query); err != nil {
+		return err
+	}
+	s.Query = query.Encode()
+	return nil
+}
+
+const savedSearchesSchema = `CREATE TABLE IF NOT EXISTS saved_searches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account TEXT NOT NULL,
+    name TEXT NOT NULL,
+    query TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    UNIQUE (account, name)
+)`
+
+const savedSearchColumns = "id, account, name, query, created_at, updated_at"
+
+func scanSavedSearch(scan func(dest ...interface{}) error) (SavedSearch, error) {
+	var search SavedSearch
+	err := scan(&search.ID, &search.Account, &search.Name, &search.Query, &search.CreatedAt, &search.UpdatedAt)
+	return search, err
+}
+
+// searchLink returns the /getdata URL running search.
+func searchLink(search SavedSearch) string {
+	link := "/getdata?account=" + url.QueryEscape(search.Account)
+	if search.Query != "" {
+		link += "&" + search.Query
+	}
+	return link
+}
+
+// handleSavedSearches serves the saved search API:
+// GET/POST /searches, GET/PUT/DELETE /searches/{name} and
+// GET /searches/{name}/run, which answers like /getdata.
+func handleSavedSearches(db, readDB *sql.DB, cfg *Config) http.HandlerFunc {
+	getData := handleGetLogData(readDB, cfg)
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/searches"), "/")
+		if rest == "" {
+			switch r.Method {
+			case http.MethodGet:
+				listSavedSearches(db, w, r)
+			case http.MethodPost:
+				saveSavedSearch(db, w, r, "")
+			default:
+				requestLogger(r).Warn("Method not allowed", "method", r.Method)
+				writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			}
+			return
+		}
+
+		name, action, _ := strings.Cut(rest, "/")
+		if action != "" && action != "run" {
+			writeError(w, http.StatusNotFound, codeNotFound, "Not found")
+			return
+		}
+		account := r.URL.Query().Get("account")
+		if account == "" && r.Method != http.MethodPut {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet:
+			search, err := scanSavedSearch(db.QueryRow("SELECT "+savedSearchColumns+" FROM saved_searches WHERE account = ? AND name = ?", account, name).Scan)
+			if err == sql.ErrNoRows {
+				writeError(w, http.StatusNotFound, codeNotFound, "Saved search not found")
+				return
+			} else if err != nil {
+				requestLogger(r).Error("Error loading saved search", "name", name, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch saved search")
+				return
+			}
+			if action == "run" {
+				runSavedSearch(getData, w, r, search)
+				return
+			}
+			search.Link = searchLink(search)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(search)
+		case action != "":
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		case r.Method == http.MethodPut:
+			saveSavedSearch(db, w, r, name)
+		case r.Method == http.MethodDelete:
+			res, err := db.Exec("DELETE FROM saved_searches WHERE account = ? AND name = ?", account, name)
+			if err != nil {
+				requestLogger(r).Error("Error deleting saved search", "name", name, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete saved search")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Saved search not found")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Saved search deleted"})
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// runSavedSearch serves search through /getdata. Parameters given on the
+// request override the saved ones, e.g. to page with offset.
+func runSavedSearch(getData http.HandlerFunc, w http.ResponseWriter, r *http.Request, search SavedSearch) {
+	query, _ := url.ParseQuery(search.Query)
+	for key, values := range r.URL.Query() {
+		query[key] = values
+	}
+	query.Set("account", search.Account)
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = "/getdata"
+	r2.URL.RawQuery = query.Encode()
+	getData(w, r2)
+}
+
+func listSavedSearches(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	account := r.URL.Query().Get("account")
+	if account == "" {
+		requestLogger(r).Warn("Missing account query parameter")
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+		return
+	}
+	rows, err := db.Query("SELECT "+savedSearchColumns+" FROM saved_searches WHERE account = ? ORDER BY name", account)
+	if err != nil {
+		requestLogger(r).Error("Error querying saved searches", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch saved searches")
+		return
+	}
+	defer rows.Close()
+
+	searches := []SavedSearch{}
+	for rows.Next() {
+		search, err := scanSavedSearch(rows.Scan)
+		if err != nil {
+			requestLogger(r).Error("Error scanning row", "err", err)
+			continue
+		}
+		search.Link = searchLink(search)
+		searches = append(searches, search)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(searches)
+}
+
+// saveSavedSearch creates a search (empty name) or replaces the query of the
+// search called name.
+func saveSavedSearch(db *sql.DB, w http.ResponseWriter, r *http.Request, name string) {
+	var search SavedSearch
+	if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+		requestLogger(r).Warn("Invalid request body", "err", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if name != "" {
+		search.Name = name
+	}
+	if err := search.Validate(); err != nil {
+		requestLogger(r).Warn("Validation failed", "err", err)
+		writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+		return
+	}
+	if !accountAllowed(r, search.Account) {
+		writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+		return
+	}
+
+	now := timeNow().UTC()
+	if name == "" {
+		var exists bool
+		db.QueryRow("SELECT 1 FROM saved_searches WHERE account = ? AND name = ?", search.Account, search.Name).Scan(&exists)
+		if exists {
+			writeError(w, http.StatusConflict, codeConflict, "Saved search already exists")
+			return
+		}
+		res, err := db.Exec("INSERT INTO saved_searches (account, name, query, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+			search.Account, search.Name, search.Query, now, now)
+		if err != nil {
+			requestLogger(r).Error("Error saving saved search", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save saved search")
+			return
+		}
+		search.ID, _ = res.LastInsertId()
+		search.CreatedAt, search.UpdatedAt = now, now
+		search.Link = searchLink(search)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(search)
+		return
+	}
+
+	res, err := db.Exec("UPDATE saved_searches SET query = ?, updated_at = ? WHERE account = ? AND name = ?",
+		search.Query, now, search.Account, name)
+	if err != nil {
+		requestLogger(r).Error("Error updating saved search", "name", name, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save saved search")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "Saved search not found")
+		return
+	}
+	search, err = scanSavedSearch(db.QueryRow("SELECT "+savedSearchColumns+" FROM saved_searches WHERE account = ? AND name = ?", search.Account, name).Scan)
+	if err != nil {
+		requestLogger(r).Error("Error loading saved search", "name", name, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch saved search")
+		return
+	}
+	search.Link = searchLink(search)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(search)
+}
@@ -0,0 +1,1581 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/grpc"
+)
+
+// LogData represents a log entry in the logData table.
+type LogData struct {
+	ID         *int64         `json:"id,omitempty"`
+	ULID       string         `json:"ulid,omitempty"`
+	Account    string         `json:"account"`
+	System     string         `json:"system"`
+	User       string         `json:"user"`
+	Module     string         `json:"module"`
+	Task       string         `json:"task"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Msg        string         `json:"msg"`
+	Level      int            `json:"level"`
+	StackTrace string         `json:"stack_trace"`
+	Fields     map[string]any `json:"fields,omitempty"`
+	TraceID    string         `json:"trace_id,omitempty"`
+	SpanID     string         `json:"span_id,omitempty"`
+	// SessionID groups the entries of one user session across systems.
+	SessionID string `json:"session_id,omitempty"`
+	// ExpiresAt removes the entry before its retention would, and TTL sets
+	// it from the time the entry is received. TTL is not stored.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       string     `json:"ttl,omitempty"`
+	// ReceivedAt is when the server received the entry, whatever the
+	// producer's clock says. It is unset for entries stored before it was
+	// recorded.
+	ReceivedAt *time.Time `json:"received_at,omitempty"`
+	// RepeatCount is how many identical entries this row stands for when
+	// DEDUP_WINDOW collapses duplicates.
+	RepeatCount int `json:"repeat_count,omitempty"`
+	// SampledRate is the fraction of similar entries kept by SAMPLING_RULES;
+	// each stored entry stands for 1/SampledRate. Unset when not sampled.
+	SampledRate float64 `json:"sampled_rate,omitempty"`
+	// Fingerprint groups entries whose messages differ only in numbers and
+	// identifiers; it is set when the entry is stored.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// ClientID is an optional idempotency key, used when no Idempotency-Key header is sent.
+	ClientID string `json:"client_id,omitempty"`
+	// SourceSeq identifies the entry at its source, such as an agent's spool
+	// sequence number. An entry whose source_seq is already stored for the
+	// account is a replay and is not stored again.
+	SourceSeq string `json:"source_seq,omitempty"`
+	// Annotations are returned by /getdata with include_annotations=true.
+	Annotations []Annotation `json:"annotations,omitempty"`
+	// Collapsed is the group the entry stands for in /getdata with
+	// collapse=, ignored on ingestion.
+	Collapsed *CollapsedGroup `json:"collapsed,omitempty"`
+	// Hash chains the entry to PrevHash, the hash of the account's previous
+	// entry, with INTEGRITY_CHAIN. They are returned by /export and ignored
+	// on ingestion.
+	Hash     string `json:"hash,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
+
+	// levelName is the level's name when the producer sent one, for level
+	// schemes defining it.
+	levelName string
+}
+
+// initSQL creates the logData table of a new database.
+//
+//go:embed init.sql
+var initSQL string
+
+// logDataColumns is the column list matched by scanLogData.
+const logDataColumns = "id, account, system, user, module, task, timestamp, msg, level, stack_trace, fields, trace_id, span_id, ulid, repeat_count, sampled_rate, fingerprint, received_at, source_seq, session_id, expires_at"
+
+// scanLogData reads a row selected with logDataColumns, followed by the
+// columns scanned into extra.
+func scanLogData(rows *sql.Rows, extra ...any) (LogData, error) {
+	var logData LogData
+	var id int64
+	var stackTrace, fields, traceID, spanID, ulid, fingerprint, sourceSeq, sessionID sql.NullString
+	var sampledRate sql.NullFloat64
+	var receivedAt, expiresAt sql.NullTime
+	dest := []any{&id, &logData.Account, &logData.System, &logData.User,
+		&logData.Module, &logData.Task, &logData.Timestamp, &logData.Msg, &logData.Level,
+		&stackTrace, &fields, &traceID, &spanID, &ulid, &logData.RepeatCount, &sampledRate, &fingerprint, &receivedAt, &sourceSeq, &sessionID, &expiresAt}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return logData, err
+	}
+	if receivedAt.Valid {
+		logData.ReceivedAt = &receivedAt.Time
+	}
+	if expiresAt.Valid {
+		logData.ExpiresAt = &expiresAt.Time
+	}
+	logData.ID = &id
+	logData.TraceID = traceID.String
+	logData.SpanID = spanID.String
+	logData.ULID = ulid.String
+	logData.SampledRate = sampledRate.Float64
+	logData.Fingerprint = fingerprint.String
+	logData.SourceSeq = sourceSeq.String
+	logData.SessionID = sessionID.String
+	var err error
+	if logData.Msg, err = unpackValue(logData.Account, logData.Msg); err != nil {
+		slog.Error("Error reading stored msg", "id", id, "err", err)
+	}
+	if logData.StackTrace, err = unpackValue(logData.Account, stackTrace.String); err != nil {
+		slog.Error("Error reading stored stack trace", "id", id, "err", err)
+	}
+	if fields.String, err = unpackValue(logData.Account, fields.String); err != nil {
+		slog.Error("Error reading stored fields", "id", id, "err", err)
+	}
+	if logData.Fields, err = decodeFields(fields); err != nil {
+		slog.Error("Error decoding fields", "id", id, "err", err)
+	}
+	return logData, nil
+}
+
+// Validate ensures LogData has required fields. Its error is a
+// ValidationError listing every missing one.
+func (l LogData) Validate() error {
+	var errs ValidationError
+	for _, f := range []struct {
+		name  string
+		value string
+	}{
+		{"account", l.Account}, {"system", l.System}, {"user", l.User}, {"module", l.Module}, {"task", l.Task}, {"msg", l.Msg},
+	} {
+		if f.value == "" {
+			errs = append(errs, FieldError{Field: f.name, Reason: "required"})
+		}
+	}
+	if l.Timestamp.IsZero() {
+		errs = append(errs, FieldError{Field: "timestamp", Reason: "required"})
+	}
+	if l.TTL != "" {
+		if l.ExpiresAt != nil {
+			errs = append(errs, FieldError{Field: "ttl", Reason: "excludes expires_at"})
+		} else if d, err := parseRelativeDuration(l.TTL); err != nil || d <= 0 {
+			errs = append(errs, FieldError{Field: "ttl", Reason: "must be a positive duration such as 30m or 2d"})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// encodeFields serializes structured fields for storage, returning NULL for none.
+func encodeFields(fields map[string]any) (sql.NullString, error) {
+	if len(fields) == 0 {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// nullString maps an empty string to NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullTime maps a nil time to NULL, and others to UTC.
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// decodeFields parses stored structured fields, ignoring NULL.
+func decodeFields(raw sql.NullString) (map[string]any, error) {
+	if !raw.Valid || raw.String == "" {
+		return nil, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(raw.String), &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// Server is a logdata server: its databases, the state loaded from them and
+// the routes of its listeners. That state is process-wide, as are the level
+// schemes or the encryption keys, so a process runs one Server at a time and
+// New replaces the state of the previous one.
+type Server struct {
+	cfg      *Config
+	db       *sql.DB
+	readDB   *sql.DB
+	replicas *replicaRouter
+	writes   *asyncWriter
+	queries  *queryLimiter
+	webhooks *webhookDispatcher
+	archive  *archiver
+	queryMux *http.ServeMux
+	// ingestMux is queryMux unless INGEST_ADDR sets a listener apart.
+	ingestMux *http.ServeMux
+	// handler is queryMux wrapped for the query listener.
+	handler http.Handler
+	// debugHandler serves /debug on DEBUG_ADDR, nil when unset.
+	debugHandler http.Handler
+	endpoints    *endpointCounters
+	mirrors      []*mirror
+	// grpc, fluent and gelf are the listeners started by runJobs, nil when
+	// not configured.
+	grpc   *grpc.Server
+	fluent *fluentListener
+	gelf   *gelfListener
+	// stop is closed by Close to stop the jobs started by runJobs, and jobs
+	// counts those still running.
+	stop     chan struct{}
+	stopOnce sync.Once
+	jobs     sync.WaitGroup
+
+	ingestInterceptors []Interceptor
+	queryInterceptors  []Interceptor
+	entryInterceptors  []EntryInterceptor
+}
+
+// Main runs the server binary with opts. It takes its configuration from
+// .env and the environment, and returns once the listeners stopped on
+// SIGTERM or SIGINT.
+func Main(opts ...Option) {
+	standalone := flag.Bool("standalone", false, "run without configuration: default DATABASE_PATH and PORT, and print startup info as JSON")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration, report pending migrations as JSON and exit")
+	migrateOnly := flag.Bool("migrate-only", false, "validate the configuration, run migrations, report them as JSON and exit")
+	flag.Parse()
+
+	if _, err := loadEnvFile(); err != nil {
+		slog.Info("No .env file found, using environment variables")
+	}
+	if *standalone {
+		if err := applyStandaloneDefaults(); err != nil {
+			fatal("Invalid configuration", "err", err)
+		}
+	}
+
+	if *checkConfig || *migrateOnly {
+		runPreflight(*migrateOnly, opts)
+		return
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+	logger, err := newLogger(cfg)
+	if err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+	slog.SetDefault(logger)
+
+	srv, err := New(cfg, opts...)
+	if err != nil {
+		fatal("Failed to start server", "err", err)
+	}
+	srv.runJobs()
+	go reloadOnSIGHUP(cfg)
+	go reapChildren()
+
+	query, err := newHTTPListener(cfg, "query", cfg.QueryAddr, cfg.QueryTLS, srv.handler)
+	if err != nil {
+		fatal("Server failed", "err", err)
+	}
+	listeners := []*httpListener{query}
+	if cfg.IngestAddr != "" {
+		// Browsers only query, so the ingest listener skips CORS
+		ingest, err := newHTTPListener(cfg, "ingest", cfg.IngestAddr, cfg.IngestTLS,
+			withRequestLogging(withEndpointCounters(srv.endpoints, srv.ingestMux,
+				withReadOnlyReplica(cfg, withAPIVersions(withAdmission(srv.ingestMux))))))
+		if err != nil {
+			fatal("Server failed", "err", err)
+		}
+		listeners = append(listeners, ingest)
+	}
+	if srv.debugHandler != nil {
+		// Profiling stays off the public listeners; the admin token still
+		// guards it
+		debug, err := newHTTPListener(cfg, "debug", cfg.DebugAddr, ListenerTLS{}, srv.debugHandler)
+		if err != nil {
+			fatal("Server failed", "err", err)
+		}
+		listeners = append(listeners, debug)
+	}
+	if *standalone {
+		printStartupInfo(cfg, listeners)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		shutdownOnSignal(cfg, listeners, srv)
+		close(stopped)
+	}()
+	for _, l := range listeners {
+		go func() {
+			if err := l.serve(); !errors.Is(err, http.ErrServerClosed) {
+				fatal("Server failed", "listener", l.name, "err", err)
+			}
+		}()
+	}
+	<-stopped
+	slog.Info("Server stopped")
+}
+
+// New opens the database of cfg, migrating its schema, and sets up the
+// routes with opts applied. It starts no listener nor background job
+// (retention, rollups, alerts and the like): ServeHTTP serves the query
+// listener's routes, and those of the ingest listener too when INGEST_ADDR
+// is unset.
+func New(cfg *Config, opts ...Option) (*Server, error) {
+	db, readDB, err := openDatabase(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	s := &Server{cfg: cfg, db: db, readDB: readDB, stop: make(chan struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+	entryInterceptors = s.entryInterceptors
+	if err := s.load(); err != nil {
+		db.Close()
+		readDB.Close()
+		return nil, err
+	}
+	if s.replicas, err = openReadReplicas(cfg, readDB); err != nil {
+		writeStatements.close()
+		readStatements.close()
+		db.Close()
+		readDB.Close()
+		return nil, err
+	}
+	s.queries = newQueryLimiter(cfg)
+	s.writes = newAsyncWriter(db, cfg)
+	admission = newAdmissionControl(cfg, s.writes)
+	s.routes()
+	return s, nil
+}
+
+// load initializes the database schema and the process-wide state.
+func (s *Server) load() error {
+	cfg, db := s.cfg, s.db
+	if err := configure(cfg); err != nil {
+		return err
+	}
+	if err := initializeDatabase(db); err != nil {
+		return fmt.Errorf("failed to initialize database: %v", err)
+	}
+	var err error
+	writeStatements, readStatements = nil, nil
+	hot := []string{recordFingerprintSQL, recordHeartbeatSQL, addUsageSQL, usageSQL, insertCustomValueSQL}
+	if partitions == nil {
+		// When partitioned, logData is a view and entries go to the day tables
+		hot = append(hot, insertEntrySQL, addRepeatSQL)
+	}
+	if writeStatements, err = prepareStatements(db, hot...); err != nil {
+		return err
+	}
+	if readStatements, err = prepareStatements(s.readDB, traceSQL, sessionSQL); err != nil {
+		return err
+	}
+
+	allowlists = newAllowlistSet(db)
+	if err := allowlists.reload(); err != nil {
+		return fmt.Errorf("failed to load account allowlists: %v", err)
+	}
+	accountSettings = newAccountSettingSet(db)
+	if err := accountSettings.reload(); err != nil {
+		return fmt.Errorf("failed to load account settings: %v", err)
+	}
+	levelSchemes = newLevelSchemeSet(db)
+	if err := levelSchemes.reload(); err != nil {
+		return fmt.Errorf("failed to load level schemes: %v", err)
+	}
+	extractionRules = newExtractionRuleSet(db)
+	if err := extractionRules.reload(); err != nil {
+		return fmt.Errorf("failed to load extraction rules: %v", err)
+	}
+	levelThresholds = newLevelThresholdSet(db)
+	if err := levelThresholds.reload(); err != nil {
+		return fmt.Errorf("failed to load level thresholds: %v", err)
+	}
+	retentionRules = newRetentionRuleSet(db)
+	if err := retentionRules.reload(); err != nil {
+		return fmt.Errorf("failed to load retention rules: %v", err)
+	}
+	customColumns = newCustomColumnSet(db)
+	if err := customColumns.reload(); err != nil {
+		return fmt.Errorf("failed to load custom columns: %v", err)
+	}
+	vacuums = newVacuumer(db, cfg)
+
+	resetInsertHooks()
+	queryResults, slowQueries = nil, nil
+	s.webhooks = newWebhookDispatcher(db, cfg)
+	if err := s.webhooks.reload(); err != nil {
+		return fmt.Errorf("failed to load webhook subscriptions: %v", err)
+	}
+	registerInsertHook(s.webhooks.dispatch)
+	emailNotifiers = newEmailNotifierSet(db, cfg)
+	if err := emailNotifiers.reload(); err != nil {
+		return fmt.Errorf("failed to load email notifiers: %v", err)
+	}
+	registerInsertHook(emailNotifiers.notify)
+	s.mirrors = nil
+	if len(cfg.MirrorKafkaBrokers) > 0 {
+		s.mirrors = append(s.mirrors, newKafkaMirror(cfg))
+	}
+	if cfg.MirrorNATSURL != "" {
+		natsMirror, err := newNATSMirror(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to configure NATS mirror: %v", err)
+		}
+		s.mirrors = append(s.mirrors, natsMirror)
+	}
+	for _, m := range s.mirrors {
+		registerInsertHook(m.enqueue)
+	}
+	if cfg.QueryCacheTTL > 0 {
+		queryResults = newQueryCache(cfg)
+		registerInsertHook(queryResults.invalidate)
+	}
+
+	if cfg.SlowQueryThreshold > 0 {
+		slowQueries = newSlowQueryLog(db, cfg)
+	}
+	if cfg.ArchiveEndpoint != "" && cfg.ArchiveBucket != "" {
+		if s.archive, err = newArchiver(db, cfg); err != nil {
+			return fmt.Errorf("failed to configure archival: %v", err)
+		}
+	}
+	return nil
+}
+
+// configure sets the process-wide state read from cfg alone, checking the
+// settings only usable once loaded, such as keys, plugins and databases.
+func configure(cfg *Config) error {
+	var err error
+	partitions, oidc, encryption, compression = nil, nil, nil, nil
+	if cfg.PartitionBy != "" {
+		if partitions, err = newPartitionSet(cfg.PartitionBy); err != nil {
+			return fmt.Errorf("invalid configuration: %v", err)
+		}
+	}
+	if cfg.OIDCIssuer != "" {
+		oidc = newOIDCVerifier(cfg)
+	}
+	integrityChain = cfg.IntegrityChain
+	accountSeparator = cfg.AccountSeparator
+	if ingestPlugins, err = loadIngestPlugins(cfg.IngestPlugins); err != nil {
+		return err
+	}
+	if sources, err = newSourceEnricher(cfg); err != nil {
+		return err
+	}
+	if cfg.EncryptionKey != nil {
+		if encryption, err = newEncryptor(cfg); err != nil {
+			return fmt.Errorf("invalid configuration: %v", err)
+		}
+	}
+	if cfg.StorageCompression != "" {
+		if compression, err = newCompressor(cfg); err != nil {
+			return fmt.Errorf("invalid configuration: %v", err)
+		}
+	}
+	return nil
+}
+
+// routes registers the handlers of both listeners.
+func (s *Server) routes() {
+	cfg, db, readDB, replicas := s.cfg, s.db, s.readDB, s.replicas
+	queries, writes, webhooks, archive := s.queries, s.writes, s.webhooks, s.archive
+	queryMux := http.NewServeMux()
+	ingestMux := queryMux
+	if cfg.IngestAddr != "" {
+		ingestMux = http.NewServeMux()
+	}
+	s.queryMux, s.ingestMux = queryMux, ingestMux
+	ingest := func(h http.HandlerFunc) http.HandlerFunc { return intercept(s.ingestInterceptors, h) }
+	read := func(h http.HandlerFunc) http.HandlerFunc { return intercept(s.queryInterceptors, h) }
+	postLogData := ingest(requireScope(cfg, scopeIngest, handlePostLogData(db, cfg, writes)))
+	postRawLogData := ingest(requireScope(cfg, scopeIngest, handleRawLogData(db, cfg)))
+	postBatch := ingest(requireScope(cfg, scopeIngest, handleBatchLogData(db, cfg)))
+	purgeLogData := requireAdmin(cfg, handleDeleteLogData(db))
+	patchLogData := requireScope(cfg, scopeAdmin, handlePatchLogData(db))
+	getLogEntry := read(requireScope(cfg, scopeRead, handleGetLogEntry(readDB, cfg)))
+	getLogContext := read(requireScope(cfg, scopeRead, queries.wrap(handleGetLogContext(readDB, cfg))))
+	logDataRoutes := withGzip(cfg, withBodyLimit(cfg, routeLogData(postLogData, postRawLogData, postBatch, purgeLogData, patchLogData, getLogEntry, getLogContext)))
+	if ingestMux != queryMux {
+		// POST /logdata is served by the ingest listener, the other methods by the query listener
+		ingestRoutes := withGzip(cfg, withBodyLimit(cfg, routeLogData(postLogData, postRawLogData, postBatch, handleNotOnListener(), handleNotOnListener(), handleNotOnListener(), handleNotOnListener())))
+		ingestMux.HandleFunc("/logdata", ingestRoutes)
+		ingestMux.HandleFunc("/logdata/", ingestRoutes)
+		ingestMux.HandleFunc("/healthz", handleHealthz())
+		ingestMux.HandleFunc("/readyz", handleReadyz(readDB))
+		logDataRoutes = withGzip(cfg, withBodyLimit(cfg, routeLogData(handleNotOnListener(), handleNotOnListener(), handleNotOnListener(), purgeLogData, patchLogData, getLogEntry, getLogContext)))
+	}
+	// Handle both /logdata and /logdata/
+	queryMux.HandleFunc("/logdata", logDataRoutes)
+	queryMux.HandleFunc("/logdata/", logDataRoutes)
+	ingestMux.HandleFunc("/receipts/", withGzip(cfg, ingest(requireScope(cfg, scopeIngest, handleGetReceipt(readDB, writes)))))
+	ingestMux.HandleFunc("/import", withLongRequest(cfg, withGzip(cfg, ingest(requireScope(cfg, scopeIngest, handleImport(db, cfg))))))
+	queryMux.HandleFunc("/getdata", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(replicas.route(readDB, cfg, handleGetLogData))))))
+	// Exports are gzip files already, so they skip withGzip
+	queryMux.HandleFunc("/export", withLongRequest(cfg, read(requireScope(cfg, scopeRead, replicas.route(readDB, cfg, handleExport)))))
+	queryMux.HandleFunc("/trace/", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleGetTrace(readDB, cfg))))))
+	queryMux.HandleFunc("/sessions", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleSessions(readDB, cfg))))))
+	queryMux.HandleFunc("/sessions/", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleSessions(readDB, cfg))))))
+	queryMux.HandleFunc("/verify", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleVerify(readDB, cfg))))))
+	queryMux.HandleFunc("/users/activity", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleUserActivity(readDB, cfg))))))
+	queryMux.HandleFunc("/usage", withGzip(cfg, read(requireScope(cfg, scopeRead, handleGetUsage(readDB, cfg)))))
+	queryMux.HandleFunc("/silence", withGzip(cfg, read(requireScope(cfg, scopeRead, handleSilence(db, readDB, cfg)))))
+	queryMux.HandleFunc("/fingerprints", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleGetFingerprints(readDB, cfg))))))
+	queryMux.HandleFunc("/count", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(replicas.route(readDB, cfg, handleGetCount))))))
+	queryMux.HandleFunc("/histogram", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(replicas.route(readDB, cfg, handleGetHistogram))))))
+	queryMux.HandleFunc("/topn", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(replicas.route(readDB, cfg, handleGetTopN))))))
+	queryMux.HandleFunc("/values", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(replicas.route(readDB, cfg, handleGetValues))))))
+	queryMux.HandleFunc("/rollups", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleGetRollups(readDB, cfg))))))
+	queryMux.HandleFunc("/anomalies", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleGetAnomalies(readDB, cfg))))))
+	queryMux.HandleFunc("/searches", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleSavedSearches(db, readDB, cfg))))))
+	queryMux.HandleFunc("/searches/", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleSavedSearches(db, readDB, cfg))))))
+	queryMux.HandleFunc("/alerts", withGzip(cfg, requireScope(cfg, scopeAdmin, handleAlertRules(db, cfg))))
+	queryMux.HandleFunc("/alerts/", withGzip(cfg, requireScope(cfg, scopeAdmin, handleAlertRules(db, cfg))))
+	queryMux.HandleFunc("/reports", withGzip(cfg, requireScope(cfg, scopeAdmin, handleReports(db, readDB, cfg))))
+	queryMux.HandleFunc("/reports/", withGzip(cfg, requireScope(cfg, scopeAdmin, handleReports(db, readDB, cfg))))
+	queryMux.HandleFunc("/extractions", withGzip(cfg, requireScope(cfg, scopeAdmin, handleExtractionRules(db))))
+	queryMux.HandleFunc("/extractions/", withGzip(cfg, requireScope(cfg, scopeAdmin, handleExtractionRules(db))))
+	queryMux.HandleFunc("/columns", withGzip(cfg, requireScope(cfg, scopeAdmin, handleCustomColumns(db))))
+	queryMux.HandleFunc("/columns/", withGzip(cfg, requireScope(cfg, scopeAdmin, handleCustomColumns(db))))
+	queryMux.HandleFunc("/levels", withGzip(cfg, requireScope(cfg, scopeAdmin, handleLevelSchemes(db))))
+	queryMux.HandleFunc("/thresholds", withGzip(cfg, requireScope(cfg, scopeAdmin, handleLevelThresholds(db))))
+	queryMux.HandleFunc("/retention", withGzip(cfg, requireScope(cfg, scopeAdmin, handleRetentionRules(db))))
+	queryMux.HandleFunc("/webhooks", withGzip(cfg, requireScope(cfg, scopeAdmin, handleWebhookSubscriptions(db, webhooks))))
+	queryMux.HandleFunc("/webhooks/", withGzip(cfg, requireScope(cfg, scopeAdmin, handleWebhookSubscriptions(db, webhooks))))
+	queryMux.HandleFunc("/notifiers", withGzip(cfg, requireScope(cfg, scopeAdmin, handleEmailNotifiers(db))))
+	ingestMux.HandleFunc("/gelf", withGzip(cfg, withBodyLimit(cfg, ingest(requireScope(cfg, scopeIngest, handleGELF(db, cfg))))))
+	splunkEvents := withGzip(cfg, withBodyLimit(cfg, ingest(requireScope(cfg, scopeIngest, handleSplunkEvents(db, cfg)))))
+	ingestMux.HandleFunc("/services/collector", splunkEvents)
+	ingestMux.HandleFunc("/services/collector/event", splunkEvents)
+	ingestMux.HandleFunc("/services/collector/event/1.0", splunkEvents)
+	ingestMux.HandleFunc("/loki/api/v1/push", withGzip(cfg, withBodyLimit(cfg, ingest(requireScope(cfg, scopeIngest, handleLokiPush(db, cfg))))))
+	queryMux.HandleFunc("/loki/api/v1/query_range", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleLokiQueryRange(readDB, cfg))))))
+	queryMux.HandleFunc("/loki/api/v1/query", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleLokiQuery(readDB, cfg))))))
+	queryMux.HandleFunc("/loki/api/v1/labels", withGzip(cfg, read(requireScope(cfg, scopeRead, handleLokiLabels(cfg)))))
+	queryMux.HandleFunc("/loki/api/v1/label/", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleLokiLabelValues(readDB, cfg))))))
+	queryMux.HandleFunc("/healthz", handleHealthz())
+	queryMux.HandleFunc("/readyz", handleReadyz(readDB))
+	queryMux.HandleFunc("/openapi.json", withGzip(cfg, handleOpenAPI()))
+	queryMux.HandleFunc("/docs", handleDocs())
+	queryMux.HandleFunc("/replication/entries", withGzip(cfg, requireAdmin(cfg, handleReplicationEntries(db, readDB, cfg))))
+	queryMux.HandleFunc("/admin/reload", requireAdmin(cfg, handleReload(cfg)))
+	queryMux.HandleFunc("/admin/maintenance", requireAdmin(cfg, handleMaintenance(db)))
+	queryMux.HandleFunc("/admin/allowlists", withGzip(cfg, requireAdmin(cfg, handleAllowlists(db))))
+	queryMux.HandleFunc("/admin/allowlists/", withGzip(cfg, requireAdmin(cfg, handleAllowlists(db))))
+	queryMux.HandleFunc("/admin/queries", withGzip(cfg, requireAdmin(cfg, handleSlowQueries(readDB, cfg))))
+	queryMux.HandleFunc("/admin/queries/history", withGzip(cfg, requireAdmin(cfg, handleSlowQueryHistory(db, cfg))))
+	queryMux.HandleFunc("/admin/audit", withGzip(cfg, requireAdmin(cfg, handleAuditLog(readDB, cfg))))
+	queryMux.HandleFunc("/admin/snapshot", withLongRequest(cfg, withGzip(cfg, requireAdmin(cfg, handleSnapshot(db, readDB)))))
+	queryMux.HandleFunc("/admin/rejected", withGzip(cfg, requireAdmin(cfg, withBodyLimit(cfg, handleRejectedLogs(db, cfg)))))
+	queryMux.HandleFunc("/admin/compress", withLongRequest(cfg, requireAdmin(cfg, handleCompress(db))))
+	queryMux.HandleFunc("/admin/vacuum", requireAdmin(cfg, handleVacuum(db)))
+	queryMux.HandleFunc("/admin/accounts/", withLongRequest(cfg, requireAdmin(cfg, handleAccounts(db, readDB, cfg, archive, webhooks))))
+	queryMux.HandleFunc("/admin/rejected/", withGzip(cfg, requireAdmin(cfg, withBodyLimit(cfg, handleRejectedLogs(db, cfg)))))
+
+	if archive != nil {
+		queryMux.HandleFunc("/archive/query", withGzip(cfg, read(requireScope(cfg, scopeRead, queries.wrap(handleArchiveQuery(readDB, archive))))))
+	}
+	debugMux := queryMux
+	if cfg.DebugAddr != "" {
+		debugMux = http.NewServeMux()
+		s.debugHandler = withRequestLogging(debugMux)
+	}
+	s.debugRoutes(debugMux)
+	s.endpoints = newEndpointCounters()
+	s.handler = withRequestLogging(withEndpointCounters(s.endpoints, queryMux,
+		withCORS(cfg, withReadOnlyReplica(cfg, withAPIVersions(withAdmission(queryMux))))))
+}
+
+// runJobs starts the background jobs and the listeners other than HTTP ones.
+func (s *Server) runJobs() {
+	cfg, db, readDB := s.cfg, s.db, s.readDB
+	if vacuums.schedule != nil {
+		s.goJob(vacuums.run)
+	}
+	// The primary archives; replicas only serve archive queries
+	if s.archive != nil && cfg.ArchiveInterval > 0 && cfg.ReplicateFrom == "" {
+		s.goJob(func(stop <-chan struct{}) { s.archive.run(stop, cfg.ArchiveInterval) })
+	}
+
+	if cfg.FluentForwardPort != "" {
+		fluent, err := listenFluentForward(cfg)
+		if err != nil {
+			fatal("Fluentd forward listener failed", "err", err)
+		}
+		s.fluent = fluent
+		go func() {
+			if err := fluent.serve(db, cfg); err != nil {
+				fatal("Fluentd forward listener failed", "err", err)
+			}
+		}()
+	}
+	if cfg.GELFUDPPort != "" {
+		gelf, err := listenGELF(cfg)
+		if err != nil {
+			fatal("GELF listener failed", "err", err)
+		}
+		s.gelf = gelf
+		go func() {
+			if err := gelf.serve(db, cfg); err != nil {
+				fatal("GELF listener failed", "err", err)
+			}
+		}()
+	}
+
+	if len(cfg.KafkaBrokers) > 0 {
+		s.goJob(func(stop <-chan struct{}) { runKafkaConsumer(stop, db, cfg) })
+	}
+	if cfg.NATSURL != "" {
+		s.goJob(func(stop <-chan struct{}) { runNATSConsumer(stop, db, cfg) })
+	}
+
+	if cfg.RollupInterval > 0 {
+		s.goJob(func(stop <-chan struct{}) { runRollups(stop, db, cfg.RollupInterval) })
+	}
+	// Retention reads forwarding, so it is set first
+	if cfg.ForwardTo != "" {
+		fwd, err := newForwarder(db, cfg)
+		if err != nil {
+			fatal("Failed to start forwarding", "err", err)
+		}
+		forwarding = fwd
+		s.goJob(func(stop <-chan struct{}) { fwd.run(stop, cfg.ForwardInterval) })
+	}
+	s.goJob(func(stop <-chan struct{}) { runRetention(stop, db, cfg) })
+
+	s.goJob(func(stop <-chan struct{}) { runIdempotencyCleanup(stop, db, cfg.IdempotencyTTL) })
+	s.goJob(func(stop <-chan struct{}) { runDeadLetterCleanup(stop, db, cfg.DeadLetterTTL) })
+
+	if s.replicas != nil {
+		s.goJob(s.replicas.run)
+	}
+
+	if cfg.ReplicateFrom != "" {
+		rep, err := newReplicator(db, cfg)
+		if err != nil {
+			fatal("Failed to start replication", "err", err)
+		}
+		s.goJob(func(stop <-chan struct{}) { rep.run(stop, cfg.ReplicationInterval) })
+	}
+
+	// Alerts fire on the primary only, so replicas do not notify twice
+	if cfg.AlertInterval > 0 && cfg.ReplicateFrom == "" {
+		s.goJob(func(stop <-chan struct{}) { runAlerts(stop, db, cfg, cfg.AlertInterval) })
+	}
+	// Likewise anomalies and reports
+	if cfg.AnomalyInterval > 0 && cfg.ReplicateFrom == "" {
+		s.goJob(func(stop <-chan struct{}) { runAnomalies(stop, db, cfg, cfg.AnomalyInterval) })
+	}
+	if cfg.SilenceWebhookURL != "" && cfg.SilenceInterval > 0 && cfg.ReplicateFrom == "" {
+		s.goJob(func(stop <-chan struct{}) { runSilenceAlerts(stop, db, cfg, cfg.SilenceInterval) })
+	}
+	if cfg.ReportInterval > 0 && cfg.ReplicateFrom == "" {
+		s.goJob(func(stop <-chan struct{}) { runReports(stop, db, readDB, cfg, cfg.ReportInterval) })
+	}
+
+	if cfg.GRPCPort != "" {
+		s.grpc = newGRPCServer(db, readDB, cfg, s.queries)
+		go func() {
+			if err := serveGRPC(s.grpc, cfg); err != nil {
+				fatal("gRPC server failed", "err", err)
+			}
+		}()
+	}
+}
+
+// goJob runs job in the background until Close closes stop, counting it in
+// s.jobs.
+func (s *Server) goJob(job func(stop <-chan struct{})) {
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		job(s.stop)
+	}()
+}
+
+// sleep waits d and reports whether it did, returning false early once stop
+// is closed.
+func sleep(stop <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// tick waits for the next tick of ticker and reports whether it came,
+// returning false early once stop is closed.
+func tick(stop <-chan struct{}, ticker *time.Ticker) bool {
+	select {
+	case <-ticker.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// stopContext returns a context canceled once stop is closed, for the jobs
+// blocking on calls that take one.
+func stopContext(stop <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// ServeHTTP serves the query listener's routes as the listener does.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Close stops the background jobs and the listeners started by Start, waits
+// up to SHUTDOWN_TIMEOUT for the runs in progress and the entries accepted
+// with ack=async to be stored, then closes the databases.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	return s.close(ctx)
+}
+
+// close stops the background jobs and the gRPC, Fluentd forward and GELF
+// listeners, letting the job runs and what the listeners are storing finish
+// until ctx is done, then stores the queued ack=async entries and closes the
+// databases.
+func (s *Server) close(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		jobs := make(chan struct{})
+		go func() {
+			s.jobs.Wait()
+			close(jobs)
+		}()
+		select {
+		case <-jobs:
+		case <-ctx.Done():
+		}
+	}()
+	if s.grpc != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopGRPC(ctx, s.grpc)
+		}()
+	}
+	if s.fluent != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.fluent.close(ctx)
+		}()
+	}
+	if s.gelf != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.gelf.close(ctx)
+		}()
+	}
+	wg.Wait()
+	s.writes.close(ctx)
+	writeStatements.close()
+	readStatements.close()
+	s.replicas.close()
+	if s.readDB != s.db {
+		s.readDB.Close()
+	}
+	return s.db.Close()
+}
+
+// logDataMigrations lists columns added to logData after the initial schema,
+// applied in order to databases created by older versions.
+var logDataMigrations = []struct {
+	name       string
+	definition string
+}{
+	{"stack_trace", "TEXT"},
+	{"fields", "TEXT"},
+	{"trace_id", "TEXT"},
+	{"span_id", "TEXT"},
+	{"ulid", "TEXT"},
+	{"repeat_count", "INTEGER NOT NULL DEFAULT 1"},
+	{"sampled_rate", "REAL"},
+	{"fingerprint", "TEXT"},
+	{"received_at", "DATETIME"},
+	{"source_seq", "TEXT"},
+	{"session_id", "TEXT"},
+	{"expires_at", "DATETIME"},
+}
+
+// logDataIndexes lists indexes that must exist on logData. They serve the
+// filters of buildLogFilter: a time range within an account, an account's
+// module and level, traces and sessions, and finding expired entries.
+// idx_account_source_seq keeps replays of an entry out.
+var logDataIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS idx_trace_id ON logData(trace_id)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS idx_ulid ON logData(ulid)",
+	"CREATE INDEX IF NOT EXISTS idx_account_timestamp ON logData(account, timestamp)",
+	"CREATE INDEX IF NOT EXISTS idx_account_module_level ON logData(account, module, level)",
+	"CREATE INDEX IF NOT EXISTS idx_account_fingerprint ON logData(account, fingerprint)",
+	"CREATE INDEX IF NOT EXISTS idx_account_received_at ON logData(account, received_at)",
+	"CREATE INDEX IF NOT EXISTS idx_account_session_id ON logData(account, session_id) WHERE session_id IS NOT NULL",
+	"CREATE UNIQUE INDEX IF NOT EXISTS idx_account_source_seq ON logData(account, source_seq) WHERE source_seq IS NOT NULL",
+	"CREATE INDEX IF NOT EXISTS idx_expires_at ON logData(expires_at) WHERE expires_at IS NOT NULL",
+}
+
+// ensureColumn adds a column to table if it does not exist yet.
+func ensureColumn(db *sql.DB, table, name, definition string) error {
+	var columnExists string
+	err := db.QueryRow("SELECT name FROM pragma_table_info(?) WHERE name=?", table, name).Scan(&columnExists)
+	if err == sql.ErrNoRows {
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition))
+		if err != nil {
+			return fmt.Errorf("failed to add %s column: %v", name, err)
+		}
+		slog.Info("Added column", "column", name, "table", table)
+	} else if err != nil {
+		return fmt.Errorf("failed to check for %s column: %v", name, err)
+	}
+	return nil
+}
+
+func initializeDatabase(db *sql.DB) error {
+	// Check if logData table exists
+	var tableExists, kind string
+	err := db.QueryRow("SELECT name, type FROM sqlite_master WHERE type IN ('table', 'view') AND name='logData'").Scan(&tableExists, &kind)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check if logData table exists: %v", err)
+	}
+	if kind == "view" && partitions == nil {
+		return fmt.Errorf("database is partitioned: PARTITION_BY must be set")
+	}
+
+	if tableExists != "logData" {
+		// Create table if it doesn't exist
+		_, err = db.Exec(initSQL)
+		if err != nil {
+			return fmt.Errorf("failed to create logData table: %v", err)
+		}
+		slog.Info("Created logData table")
+	} else {
+		slog.Info("logData table already exists")
+	}
+	if partitions != nil {
+		if err := partitions.init(db); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(accountKeysSchema); err != nil {
+		return fmt.Errorf("failed to create account_keys table: %v", err)
+	}
+	if encryption != nil {
+		if err := encryption.init(db); err != nil {
+			return err
+		}
+	}
+
+	// Add columns introduced after the initial schema
+	tables := logDataTables()
+	for _, table := range tables {
+		for _, column := range logDataMigrations {
+			if err := ensureColumn(db, table, column.name, column.definition); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Partitions copy their indexes from the first table
+	for _, stmt := range logDataIndexes {
+		if _, err := db.Exec(strings.Replace(stmt, " ON logData(", " ON "+tables[0]+"(", 1)); err != nil {
+			return fmt.Errorf("failed to create index: %v", err)
+		}
+	}
+	if partitions != nil {
+		if err := partitions.finishInit(db); err != nil {
+			return err
+		}
+	}
+	if err := backfillULIDs(db); err != nil {
+		return fmt.Errorf("failed to assign ULIDs: %v", err)
+	}
+
+	if _, err := db.Exec(rejectedLogsSchema); err != nil {
+		return fmt.Errorf("failed to create rejected_logs table: %v", err)
+	}
+	if err := initializeAuditLog(db); err != nil {
+		return err
+	}
+	if _, err := db.Exec(slowQueriesSchema); err != nil {
+		return fmt.Errorf("failed to create slow_queries table: %v", err)
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_slow_queries_occurred_at ON slow_queries(occurred_at)"); err != nil {
+		return fmt.Errorf("failed to index slow_queries: %v", err)
+	}
+	if _, err := db.Exec(fingerprintsSchema); err != nil {
+		return fmt.Errorf("failed to create fingerprints table: %v", err)
+	}
+	if _, err := db.Exec(sourceHeartbeatsSchema); err != nil {
+		return fmt.Errorf("failed to create source_heartbeats table: %v", err)
+	}
+	if _, err := db.Exec(accountNetworksSchema); err != nil {
+		return fmt.Errorf("failed to create account_networks table: %v", err)
+	}
+	if _, err := db.Exec(accountSettingsSchema); err != nil {
+		return fmt.Errorf("failed to create account_settings table: %v", err)
+	}
+	if _, err := db.Exec(levelSchemesSchema); err != nil {
+		return fmt.Errorf("failed to create level_schemes table: %v", err)
+	}
+	if err := initializeUsage(db); err != nil {
+		return err
+	}
+	if _, err := db.Exec(archivesSchema); err != nil {
+		return fmt.Errorf("failed to create archives table: %v", err)
+	}
+	if _, err := db.Exec(annotationsSchema); err != nil {
+		return fmt.Errorf("failed to create annotations table: %v", err)
+	}
+	if _, err := db.Exec(entryHashesSchema); err != nil {
+		return fmt.Errorf("failed to create entry_hashes table: %v", err)
+	}
+	if _, err := db.Exec(idempotencyKeysSchema); err != nil {
+		return fmt.Errorf("failed to create idempotency_keys table: %v", err)
+	}
+	if _, err := db.Exec(webhookSubscriptionsSchema); err != nil {
+		return fmt.Errorf("failed to create webhook_subscriptions table: %v", err)
+	}
+	if err := initializeAlertRules(db); err != nil {
+		return err
+	}
+	if _, err := db.Exec(savedSearchesSchema); err != nil {
+		return fmt.Errorf("failed to create saved_searches table: %v", err)
+	}
+	if _, err := db.Exec(reportsSchema); err != nil {
+		return fmt.Errorf("failed to create reports table: %v", err)
+	}
+	if _, err := db.Exec(extractionRulesSchema); err != nil {
+		return fmt.Errorf("failed to create extraction_rules table: %v", err)
+	}
+	if _, err := db.Exec(levelThresholdsSchema); err != nil {
+		return fmt.Errorf("failed to create level_thresholds table: %v", err)
+	}
+	if _, err := db.Exec(levelRetentionSchema); err != nil {
+		return fmt.Errorf("failed to create level_retention table: %v", err)
+	}
+	if err := initializeEmailNotifiers(db); err != nil {
+		return err
+	}
+	if _, err := db.Exec(customColumnsSchema); err != nil {
+		return fmt.Errorf("failed to create custom column tables: %v", err)
+	}
+	for _, stmt := range rollupSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create rollup tables: %v", err)
+		}
+	}
+	for _, stmt := range anomalySchema {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create anomaly tables: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// insertLogData stores a validated log entry, setting its ID and ULID, and
+// runs the insert hooks. It returns a *quotaError when the account is over
+// quota. An entry dropped by its level threshold, an ingest plugin or
+// sampling is not stored and its ULID is cleared.
+func insertLogData(db *sql.DB, cfg *Config, logData *LogData) error {
+	keep, redactions, err := prepareInsert(db, cfg, logData)
+	if !keep || err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	merged, err := storeLogDataTx(tx, cfg, logData, redactions)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Repeats of a merged entry do not trigger alerts and webhooks again
+	if !merged {
+		runInsertHooks(*logData)
+	}
+	return nil
+}
+
+// prepareInsert runs the steps every entry goes through before it is
+// stored, whichever path it is stored by: interceptors, level schemes and
+// thresholds, plugins, sampling, extraction rules, custom columns and
+// redaction, then the partition and key it needs. It returns false,
+// with the ULID cleared, for entries that are dropped, and the number of
+// redactions to count with the entry.
+func prepareInsert(db *sql.DB, cfg *Config, logData *LogData) (bool, int, error) {
+	interceptEntry(logData)
+	levelSchemes.normalize(logData)
+	if levelThresholds.drops(*logData) || !pluginsKeep(db, logData) || !sample(cfg, logData) {
+		logData.ULID = ""
+		return false, 0, nil
+	}
+	extractionRules.apply(logData)
+	customColumns.normalize(logData)
+	redactions := redact(cfg, logData)
+	if partitions != nil {
+		if err := partitions.ensure(db, logData.Timestamp); err != nil {
+			return false, 0, err
+		}
+	}
+	if err := encryption.ensure(db, logData.Account); err != nil {
+		return false, 0, err
+	}
+	if logData.ULID == "" {
+		logData.ULID = newULID(logData.Timestamp)
+	}
+	return true, redactions, nil
+}
+
+// storeLogDataTx stores an entry prepared by prepareInsert in tx, merged
+// into a duplicate or as a new entry, and counts its redactions. It returns
+// a *quotaError when the account has reached its quota, counting the
+// entries stored earlier in tx. It reports whether it was merged; the
+// caller runs the insert hooks of new entries once tx is committed.
+func storeLogDataTx(tx *sql.Tx, cfg *Config, logData *LogData, redactions int) (bool, error) {
+	if err := checkQuota(tx, cfg, logData.Account); err != nil {
+		return false, err
+	}
+	merged, err := mergeDuplicate(tx, cfg, logData)
+	if err != nil {
+		return false, err
+	}
+	if !merged {
+		id, err := insertLogDataTx(tx, *logData)
+		if err != nil {
+			return false, err
+		}
+		logData.ID = &id
+	}
+	if redactions > 0 {
+		if err := addRedactions(tx, logData.Account, redactions); err != nil {
+			return false, fmt.Errorf("failed to count redactions: %v", err)
+		}
+	}
+	return merged, nil
+}
+
+// insertLogDataTx inserts a log entry without running hooks, returning its id,
+// and counts it in its fingerprint group. With partitioning the partition
+// must already exist and ids come from log_sequence so they stay unique
+// across partitions.
+func insertLogDataTx(ex queryExecer, logData LogData) (int64, error) {
+	fields, err := encodeFields(logData.Fields)
+	if err != nil {
+		return 0, fmt.Errorf("invalid fields: %v", err)
+	}
+	logData.Fingerprint = fingerprint(logData)
+	if err := recordFingerprint(ex, logData); err != nil {
+		return 0, fmt.Errorf("failed to record fingerprint: %v", err)
+	}
+	msg, stackTrace, storedFields, err := packEntry(logData.Account, logData.Msg, logData.StackTrace, fields)
+	if err != nil {
+		return 0, err
+	}
+	if logData.ULID == "" {
+		logData.ULID = newULID(logData.Timestamp)
+	}
+	// Entries from /import and replication keep the time they were first received
+	receivedAt := timeNow().UTC()
+	if logData.ReceivedAt != nil {
+		receivedAt = logData.ReceivedAt.UTC()
+	}
+	id := "NULL"
+	if partitions != nil {
+		if _, err := ex.Exec("UPDATE log_sequence SET id = id + 1"); err != nil {
+			return 0, fmt.Errorf("failed to allocate id: %v", err)
+		}
+		id = "(SELECT id FROM log_sequence)"
+	}
+	res, err := writeStatements.exec(ex, insertEntryQuery(tableFor(logData.Timestamp), id),
+		logData.Account, logData.System, logData.User, logData.Module,
+		logData.Task, logData.Timestamp.UTC(), msg, logData.Level, stackTrace, storedFields,
+		nullString(logData.TraceID), nullString(logData.SpanID), logData.ULID,
+		sql.NullFloat64{Float64: logData.SampledRate, Valid: logData.SampledRate > 0}, logData.Fingerprint, receivedAt,
+		nullString(logData.SourceSeq), nullString(logData.SessionID), nullTime(logData.ExpiresAt),
+	)
+	if err != nil {
+		return 0, err
+	}
+	if err := addUsage(ex, logData.Account, entrySize(logData, msg, stackTrace, storedFields)); err != nil {
+		return 0, fmt.Errorf("failed to update usage: %v", err)
+	}
+	if err := recordHeartbeat(ex, logData, receivedAt); err != nil {
+		return 0, fmt.Errorf("failed to record heartbeat: %v", err)
+	}
+	logID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := customColumns.store(ex, logID, logData); err != nil {
+		return 0, err
+	}
+	return logID, chainEntry(ex, logID, logData)
+}
+
+// insertEntryQuery returns the INSERT of an entry into table, with id as the
+// id expression.
+func insertEntryQuery(table, id string) string {
+	return `INSERT INTO ` + table + ` (id, account, system, user, module, task, timestamp, msg, level, stack_trace, fields, trace_id, span_id, ulid, sampled_rate, fingerprint, received_at, source_seq, session_id, expires_at)
+		 VALUES (` + id + `, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+}
+
+// routeLogData sends /logdata/raw to raw, /logdata/batch to batch, GET /logdata/{id}/context to
+// surrounding, GET /logdata/{ulid} to lookup, other /logdata/{id} requests to
+// entry, DELETE /logdata to purge and everything else to ingest.
+func routeLogData(ingest, raw, batch, purge, entry, lookup, surrounding http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/logdata"), "/")
+		switch {
+		case rest == "raw":
+			raw(w, r)
+		case rest == "batch":
+			batch(w, r)
+		case strings.HasSuffix(rest, "/context") && r.Method == http.MethodGet:
+			surrounding(w, r)
+		case rest != "" && r.Method == http.MethodGet:
+			lookup(w, r)
+		case rest != "":
+			entry(w, r)
+		case r.Method == http.MethodDelete:
+			purge(w, r)
+		default:
+			ingest(w, r)
+		}
+	}
+}
+
+// handlePostLogData serves POST /logdata. With ack=async the entry is
+// queued on aw once validated, and a receipt is returned with 202.
+func handlePostLogData(db *sql.DB, cfg *Config, aw *asyncWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Log raw request body
+		body, err := io.ReadAll(r.Body)
+		if isBodyTooLarge(err) {
+			requestLogger(r).Warn("Request body too large", "err", err)
+			writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+				fmt.Sprintf("Request body exceeds %d bytes", cfg.Live().MaxBodyBytes), nil)
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error reading request body", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to read request body")
+			return
+		}
+		requestLogger(r).Debug("Raw request body", "body", string(body))
+		r.Body = io.NopCloser(strings.NewReader(string(body))) // Restore body for decoding
+
+		if r.Method != http.MethodPost {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		ack := r.URL.Query().Get("ack")
+		if ack != "" && ack != "sync" && ack != "async" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "ack must be sync or async")
+			return
+		}
+
+		account := r.Header.Get("X-Account")
+		if account == "" {
+			requestLogger(r).Warn("Missing X-Account header")
+			rejectLog(db, cfg, account, body, "X-Account header required")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "X-Account header required")
+			return
+		}
+
+		var logData LogData
+		if err := json.NewDecoder(r.Body).Decode(&logData); err != nil {
+			requestLogger(r).Warn("Invalid request body", "err", err)
+			rejectLog(db, cfg, account, body, fmt.Sprintf("Invalid request body: %v", err))
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+
+		requestLogger(r).Debug("Received log data", "log_data", logData)
+		logData.splitStackTrace()
+		logData.defaultTTL(r)
+		if err := logData.Validate(); err != nil {
+			requestLogger(r).Warn("Validation failed", "err", err)
+			rejectLog(db, cfg, account, body, fmt.Sprintf("Validation failed: %v", err))
+			writeErrorDetails(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err), fieldErrors(err, ""))
+			return
+		}
+
+		if errs := logData.checkLimits(cfg); len(errs) > 0 {
+			requestLogger(r).Warn("Payload limits exceeded", "errors", errs)
+			rejectLog(db, cfg, account, body, "Payload limits exceeded")
+			writeErrorDetails(w, http.StatusUnprocessableEntity, codePayloadTooLarge, "Payload limits exceeded", errs)
+			return
+		}
+
+		if err := logData.checkClock(cfg); err != nil {
+			requestLogger(r).Warn("Timestamp out of range", "err", err)
+			rejectLog(db, cfg, account, body, fmt.Sprintf("Validation failed: %v", err))
+			writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+			return
+		}
+
+		if logData.Account != account {
+			requestLogger(r).Warn("Account mismatch", "body_account", logData.Account, "account", account)
+			rejectLog(db, cfg, account, body, "Account in body must match X-Account header")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account in body must match X-Account header")
+			return
+		}
+
+		logData.ULID = newULID(logData.Timestamp)
+		key := idempotencyKey(r, logData)
+		sources.enrich(&logData, clientAddr(r, cfg))
+		if key != "" {
+			if status, stored, ok := lookupIdempotentResponse(db, cfg, account, key); ok {
+				requestLogger(r).Info("Replaying idempotent response", "key", key, "account", account)
+				writeIdempotentReplay(w, status, stored)
+				return
+			}
+		}
+
+		if ack == "async" {
+			if err := checkQuota(db, cfg, account); err != nil {
+				var quotaErr *quotaError
+				if errors.As(err, &quotaErr) {
+					requestLogger(r).Warn("Rejected log data", "account", account, "err", err)
+					writeError(w, quotaErr.status, codeQuotaExceeded, err.Error())
+					return
+				}
+				requestLogger(r).Error("Error checking quota", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save log data")
+				return
+			}
+			if !aw.enqueue(asyncWrite{logData: logData, key: key, body: body}) {
+				requestLogger(r).Warn("Async write queue full", "account", account)
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Async write queue full; retry later or use ack=sync")
+				return
+			}
+			requestLogger(r).Info("Log data accepted", "account", account, "receipt", logData.ULID)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Location", "/receipts/"+logData.ULID)
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, `{"message":"Log data accepted","receipt":"%s"}`+"\n", logData.ULID)
+			return
+		}
+
+		if key != "" {
+			var inserted bool
+			inserted, err = insertLogDataIdempotent(db, cfg, account, key, &logData, http.StatusOK)
+			if err == nil && !inserted {
+				// A concurrent request with the same key won the race
+				if status, stored, ok := lookupIdempotentResponse(db, cfg, account, key); ok {
+					writeIdempotentReplay(w, status, stored)
+					return
+				}
+			}
+		} else {
+			err = insertLogData(db, cfg, &logData)
+		}
+		var quotaErr *quotaError
+		if errors.As(err, &quotaErr) {
+			requestLogger(r).Warn("Rejected log data", "account", account, "err", err)
+			writeError(w, quotaErr.status, codeQuotaExceeded, err.Error())
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error saving log data", "err", err)
+			rejectLog(db, cfg, account, body, fmt.Sprintf("Failed to save log data: %v", err))
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save log data")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if logData.ULID == "" {
+			requestLogger(r).Info("Log data sampled out", "account", account)
+			fmt.Fprintln(w, sampledOutResponse)
+			return
+		}
+		requestLogger(r).Info("Log data saved", "account", account)
+		fmt.Fprintln(w, savedResponse(logData.ULID))
+	}
+}
+
+func handleGetLogData(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		account := query.Get("account")
+		subtree := false
+		if prefix := query.Get("account_prefix"); prefix != "" {
+			if account != "" || accountSeparator == "" {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "account_prefix requires ACCOUNT_SEPARATOR and excludes account")
+				return
+			}
+			account, subtree = prefix, true
+		}
+		admin := isAdmin(r, cfg)
+		if account == "" {
+			if !admin {
+				requestLogger(r).Warn("Missing account query parameter")
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+				return
+			}
+			account = allAccounts
+		}
+		crossAccount := account == allAccounts
+		if crossAccount && !admin {
+			requestLogger(r).Warn("Cross-account query denied")
+			writeError(w, http.StatusForbidden, codeForbidden, "Admin token required for cross-account queries")
+			return
+		}
+
+		params, err := parseQueryParams(query)
+		if err != nil {
+			requestLogger(r).Warn("Invalid query parameters", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		params.Account, params.subtree = account, subtree
+		if cursor := query.Get("cursor"); cursor != "" {
+			if query.Has("offset") || query.Has("as_of") {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "cursor excludes offset and as_of")
+				return
+			}
+			offset, asOf, err := decodeCursor(cursor)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+				return
+			}
+			params.Offset, params.AsOf = &offset, &asOf
+		}
+		if !limitQueryRows(w, r, cfg, &params) {
+			return
+		}
+		if params.Collapse = query.Get("collapse"); params.Collapse != "" {
+			if collapseKeys[params.Collapse] == "" {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid collapse: must be fingerprint or msg")
+				return
+			}
+			if len(params.Projection) > 0 && !slices.Contains(params.Projection, "collapsed") {
+				params.Projection = append(params.Projection, "collapsed")
+			}
+		}
+		format := query.Get("format")
+		if format != "" && format != "json" && format != "ndjson" {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid format: must be json or ndjson")
+			return
+		}
+		ndjson := format == "ndjson"
+		if ndjson && !allowedBySettings(w, r, accountSettings.checkStreaming(account)) {
+			return
+		}
+		if !allowedBySettings(w, r, accountSettings.checkQuery(params)) {
+			return
+		}
+		envelope := query.Get("envelope") == "true"
+		if envelope && ndjson {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "envelope requires format=json")
+			return
+		}
+		action := "query"
+		if crossAccount {
+			action = "cross_account_query"
+		}
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		if query.Get("explain") == "true" {
+			explanation, err := explainLogQuery(ctx, db, cfg, params, cfg.Live().MaxQueryScanRows)
+			if queryAborted(w, r, cfg, err) {
+				return
+			}
+			if err != nil {
+				requestLogger(r).Error("Error explaining query", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to explain query")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(explanation)
+			return
+		}
+		// The first page anchors the query at the newest entry, for the
+		// client to pass as as_of with the next pages
+		anchored := params
+		if anchored.AsOf == nil {
+			var maxID int64
+			err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM logData").Scan(&maxID)
+			if queryAborted(w, r, cfg, err) {
+				return
+			}
+			if err != nil {
+				requestLogger(r).Error("Error reading max id", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch log data")
+				return
+			}
+			anchored.AsOf = &maxID
+		}
+		w.Header().Set("X-As-Of", strconv.FormatInt(*anchored.AsOf, 10))
+		key := queryCacheKey(r)
+		if rows, ok := queryResults.serve(w, key); ok {
+			recordRead(db, r, cfg, action, account, rows)
+			return
+		}
+
+		// Entries are written as they are read, unless annotations are to be
+		// attached: their query cannot run alongside the open rows when the
+		// readers share a single connection
+		stream := newEntryStream(w, params.Projection, ndjson)
+		if envelope {
+			stream.paginate = func(rows int) Pagination {
+				pagination := Pagination{Limit: params.Limit}
+				if params.Offset != nil {
+					pagination.Offset = *params.Offset
+				}
+				// A full page may be followed by another
+				if params.Limit != nil && int64(rows) == *params.Limit && rows > 0 {
+					next := encodeCursor(pagination.Offset+int64(rows), *anchored.AsOf)
+					pagination.NextCursor = &next
+				}
+				return pagination
+			}
+		}
+		annotate := query.Get("include_annotations") == "true"
+		var logs []LogData
+		var writeErr error
+		err = queryEntries(ctx, db, anchored, func(logData LogData) error {
+			if annotate {
+				logs = append(logs, logData)
+				return nil
+			}
+			writeErr = stream.write(logData)
+			return writeErr
+		})
+		switch {
+		case writeErr != nil:
+			requestLogger(r).Warn("Query response aborted", "entries", stream.rows, "err", writeErr)
+			return
+		case err != nil && stream.rows > 0:
+			// Once entries are sent, the response can only be cut short
+			requestLogger(r).Error("Query response aborted", "entries", stream.rows, "err", err)
+			return
+		case queryAborted(w, r, cfg, err):
+			return
+		case err != nil:
+			requestLogger(r).Error("Error querying log data", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch log data")
+			return
+		}
+
+		if annotate {
+			if err := attachAnnotations(ctx, db, logs); queryAborted(w, r, cfg, err) {
+				return
+			} else if err != nil {
+				requestLogger(r).Error("Error loading annotations", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch annotations")
+				return
+			}
+			for _, logData := range logs {
+				if err := stream.write(logData); err != nil {
+					requestLogger(r).Warn("Query response aborted", "entries", stream.rows, "err", err)
+					return
+				}
+			}
+		}
+		recordRead(db, r, cfg, action, account, stream.rows)
+		queryResults.store(key, params, stream.rows, stream.close())
+	}
+}
+
+func handleGetTrace(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		traceID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/trace/"), "/")
+		if traceID == "" {
+			requestLogger(r).Warn("Missing trace id")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Trace id required")
+			return
+		}
+
+		account := r.URL.Query().Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		// Oldest first, so the trace reads in causal order across systems
+		var rowsRead int64
+		defer slowQueries.observe(traceSQL, []any{account, traceID}, time.Now(), &rowsRead)
+		rows, err := readStatements.query(ctx, db, traceSQL, account, traceID)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying trace", "trace_id", traceID, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch trace")
+			return
+		}
+		defer rows.Close()
+
+		var logs []LogData
+		for rows.Next() {
+			rowsRead++
+			logData, err := scanLogData(rows)
+			if err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			logs = append(logs, logData)
+		}
+		if err := rows.Err(); err != nil {
+			if !queryAborted(w, r, cfg, err) {
+				requestLogger(r).Error("Error reading trace", "trace_id", traceID, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch trace")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logs)
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Session summarizes the entries of one session_id.
+type Session struct {
+	SessionID string    `json:"session_id"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Entries   int64     `json:"entries"`
+	// Errors counts the entries at error level and above.
+	Errors  int64    `json:"errors"`
+	Systems []string `json:"systems"`
+	Users   []string `json:"users"`
+}
+
+// handleSessions serves GET /sessions, the sessions among the entries
+// matching the /getdata filters, and GET /sessions/{id}, a session's
+// entries.
+func handleSessions(db *sql.DB, cfg *Config) http.HandlerFunc {
+	list, get := handleListSessions(db, cfg), handleGetSession(db, cfg)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.Trim(strings.TrimPrefix(r.URL.Path, "/sessions"), "/") == "" {
+			list(w, r)
+			return
+		}
+		get(w, r)
+	}
+}
+
+// handleListSessions lists up to limit (default 100) sessions, most recently
+// active first.
+func handleListSessions(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+		if account == allAccounts {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Sessions are listed for one account")
+			return
+		}
+		limit := 100
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid limit")
+				return
+			}
+			limit = int(min(int64(n), cfg.Live().MaxQueryRows))
+		}
+
+		params, err := parseQueryParams(query)
+		if err != nil {
+			requestLogger(r).Warn("Invalid query parameters", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		params.Account = account
+		if !allowedBySettings(w, r, accountSettings.checkQuery(params)) {
+			return
+		}
+		start, end := params.partitionRange()
+		key := queryCacheKey(r)
+		if _, ok := queryResults.serve(w, key); ok {
+			return
+		}
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		where, args := buildLogFilter(params)
+		sqlQuery := fmt.Sprintf(`SELECT session_id, MIN(timestamp), MAX(timestamp), SUM(repeat_count),
+				SUM(CASE WHEN level >= %d THEN repeat_count ELSE 0 END), json_group_array(DISTINCT system), json_group_array(DISTINCT user)
+			FROM %s WHERE %s AND session_id IS NOT NULL
+			GROUP BY session_id ORDER BY MAX(timestamp) DESC, session_id LIMIT %d`,
+			LevelError, logDataSource(start, end), where, limit)
+		var rowsRead int64
+		defer slowQueries.observe(sqlQuery, args, time.Now(), &rowsRead)
+		rows, err := db.QueryContext(ctx, sqlQuery, args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying sessions", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch sessions")
+			return
+		}
+		defer rows.Close()
+
+		sessions := []Session{}
+		for rows.Next() {
+			rowsRead++
+			var s Session
+			var first, last, systems, users string
+			if err := rows.Scan(&s.SessionID, &first, &last, &s.Entries, &s.Errors, &systems, &users); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			s.FirstSeen, s.LastSeen = storedTime(first), storedTime(last)
+			json.Unmarshal([]byte(systems), &s.Systems)
+			json.Unmarshal([]byte(users), &s.Users)
+			sessions = append(sessions, s)
+		}
+		if err := rows.Err(); err != nil {
+			if !queryAborted(w, r, cfg, err) {
+				requestLogger(r).Error("Error reading sessions", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch sessions")
+			}
+			return
+		}
+
+		queryResults.write(w, key, params, len(sessions), sessions)
+	}
+}
+
+// storedTime parses a timestamp as SQLite returns it from an aggregate,
+// which loses the column's type.
+func storedTime(s string) time.Time {
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// handleGetSession returns the entries of a session across systems, oldest
+// first, so the journey reads in order.
+func handleGetSession(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		sessionID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/sessions/"), "/")
+		account := r.URL.Query().Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		var rowsRead int64
+		defer slowQueries.observe(sessionSQL, []any{account, sessionID}, time.Now(), &rowsRead)
+		rows, err := readStatements.query(ctx, db, sessionSQL, account, sessionID)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying session", "session_id", sessionID, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch session")
+			return
+		}
+		defer rows.Close()
+
+		logs := []LogData{}
+		for rows.Next() {
+			rowsRead++
+			logData, err := scanLogData(rows)
+			if err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			logs = append(logs, logData)
+		}
+		if err := rows.Err(); err != nil {
+			if !queryAborted(w, r, cfg, err) {
+				requestLogger(r).Error("Error reading session", "session_id", sessionID, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch session")
+			}
+			return
+		}
+		if len(logs) == 0 {
+			writeError(w, http.StatusNotFound, codeNotFound, "Session not found")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logs)
+	}
+}
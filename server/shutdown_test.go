@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"log-server/server/testutil"
+)
+
+// freePort returns a TCP port nothing listens on.
+func freePort(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return strconv.Itoa(lis.Addr().(*net.TCPAddr).Port)
+}
+
+func TestCloseStopsListeners(t *testing.T) {
+	grpcPort, fluentPort := freePort(t), freePort(t)
+	srv := testutil.NewServer(t, map[string]string{
+		"GRPC_PORT":           grpcPort,
+		"FLUENT_FORWARD_PORT": fluentPort,
+		"GELF_UDP_PORT":       freePort(t),
+		"SHUTDOWN_TIMEOUT":    "5s",
+	})
+	srv.Start()
+	var fluent net.Conn
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var err error
+		if fluent, err = net.Dial("tcp", "127.0.0.1:"+fluentPort); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Fluentd forward listener not started: %v", err)
+		}
+	}
+	defer fluent.Close()
+
+	// The idle forward connection does not hold up the shutdown
+	start := time.Now()
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Close took %v with an idle forward connection", elapsed)
+	}
+	fluent.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := fluent.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("forward connection still open after Close: %v", err)
+	}
+	for _, port := range []string{grpcPort, fluentPort} {
+		if conn, err := net.Dial("tcp", "127.0.0.1:"+port); err == nil {
+			conn.Close()
+			t.Errorf("port %s still accepting connections after Close", port)
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
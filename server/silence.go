@@ -0,0 +1,216 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Missing logs are often the first sign of an outage, so every stored entry
+// refreshes the heartbeat of its source, its account, system and module.
+// GET /silence lists the sources that have not logged within an expected
+// interval, SILENCE_AFTER by default, and with SILENCE_WEBHOOK_URL set a
+// background job notifies each source going silent once.
+
+const sourceHeartbeatsSchema = `CREATE TABLE IF NOT EXISTS source_heartbeats (
+    account TEXT NOT NULL,
+    system TEXT NOT NULL,
+    module TEXT NOT NULL,
+    first_seen DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    notified_at DATETIME,
+    PRIMARY KEY (account, system, module)
+)`
+
+const recordHeartbeatSQL = `INSERT INTO source_heartbeats (account, system, module, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (account, system, module) DO UPDATE SET
+			first_seen = MIN(first_seen, excluded.first_seen),
+			last_seen = MAX(last_seen, excluded.last_seen)`
+
+// SilentSource is a source that has not logged since LastSeen, for
+// SilentSeconds. NotifiedAt is when SILENCE_WEBHOOK_URL was told about the
+// current silence, if it was.
+type SilentSource struct {
+	Account       string     `json:"account"`
+	System        string     `json:"system"`
+	Module        string     `json:"module"`
+	FirstSeen     time.Time  `json:"first_seen"`
+	LastSeen      time.Time  `json:"last_seen"`
+	SilentSeconds int64      `json:"silent_seconds"`
+	NotifiedAt    *time.Time `json:"notified_at,omitempty"`
+}
+
+// recordHeartbeat marks the source of logData as seen at receivedAt, the
+// time it was received rather than its timestamp, so entries sent late
+// still count as signs of life and imported ones keep their original time.
+func recordHeartbeat(ex execer, logData LogData, receivedAt time.Time) error {
+	at := receivedAt.UTC()
+	_, err := writeStatements.exec(ex, recordHeartbeatSQL, logData.Account, logData.System, logData.Module, at, at)
+	return err
+}
+
+// silentSources returns the sources of account, every account when empty,
+// silent since before now - after, the longest silent first.
+func silentSources(readDB *sql.DB, r *http.Request, cfg *Config, account, system, module string, after time.Duration, limit int) ([]SilentSource, error) {
+	now := timeNow().UTC()
+	sqlQuery := "SELECT account, system, module, first_seen, last_seen, notified_at FROM source_heartbeats WHERE last_seen < ?"
+	args := []interface{}{now.Add(-after)}
+	for _, filter := range []struct{ column, value string }{{"account", account}, {"system", system}, {"module", module}} {
+		if filter.value != "" {
+			sqlQuery += " AND " + filter.column + " = ?"
+			args = append(args, filter.value)
+		}
+	}
+	sqlQuery += " ORDER BY last_seen, account, system, module LIMIT ?"
+	args = append(args, limit)
+
+	ctx, cancel := queryContext(r, cfg)
+	defer cancel()
+	rows, err := readDB.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sources := []SilentSource{}
+	for rows.Next() {
+		var s SilentSource
+		var notified sql.NullTime
+		if err := rows.Scan(&s.Account, &s.System, &s.Module, &s.FirstSeen, &s.LastSeen, &notified); err != nil {
+			return nil, err
+		}
+		s.SilentSeconds = int64(now.Sub(s.LastSeen).Seconds())
+		// A notification of an earlier silence does not cover this one
+		if notified.Valid && notified.Time.After(s.LastSeen) {
+			s.NotifiedAt = &notified.Time
+		}
+		sources = append(sources, s)
+	}
+	return sources, rows.Err()
+}
+
+// runSilenceAlerts notifies SILENCE_WEBHOOK_URL every interval of the
+// sources silent for SILENCE_AFTER since the previous run.
+func runSilenceAlerts(stop <-chan struct{}, db *sql.DB, cfg *Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for tick(stop, ticker) {
+		if err := notifySilences(db, cfg, timeNow()); err != nil {
+			slog.Error("Error checking silent sources", "err", err)
+		}
+	}
+}
+
+// notifySilences posts each source silent for SILENCE_AFTER and not yet
+// notified of it, marking it notified once posted. A source logging again
+// ends its silence, and the next one is notified anew.
+func notifySilences(db *sql.DB, cfg *Config, now time.Time) error {
+	now = now.UTC()
+	rows, err := db.Query(`SELECT account, system, module, first_seen, last_seen FROM source_heartbeats
+		WHERE last_seen < ? AND (notified_at IS NULL OR notified_at < last_seen) ORDER BY last_seen`, now.Add(-cfg.SilenceAfter))
+	if err != nil {
+		return err
+	}
+	var sources []SilentSource
+	for rows.Next() {
+		var s SilentSource
+		if err := rows.Scan(&s.Account, &s.System, &s.Module, &s.FirstSeen, &s.LastSeen); err != nil {
+			rows.Close()
+			return err
+		}
+		s.SilentSeconds = int64(now.Sub(s.LastSeen).Seconds())
+		sources = append(sources, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range sources {
+		slog.Warn("Source went silent", "account", s.Account, "system", s.System, "module", s.Module, "last_seen", s.LastSeen)
+		if err := postJSON(cfg.SilenceWebhookURL, s); err != nil {
+			slog.Error("Error sending silence webhook", "account", s.Account, "err", err)
+			continue
+		}
+		if _, err := db.Exec("UPDATE source_heartbeats SET notified_at = ? WHERE account = ? AND system = ? AND module = ?",
+			now, s.Account, s.System, s.Module); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleSilence serves GET /silence?account=&system=&module=&after=&limit=,
+// the sources silent for after (default SILENCE_AFTER), the longest silent
+// first, and DELETE /silence?account=&system=&module=, which forgets a
+// retired source. Admins may omit account to list every account's sources.
+func handleSilence(db, readDB *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" && (r.Method != http.MethodGet || !isAdmin(r, cfg)) {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			after := cfg.SilenceAfter
+			if v := query.Get("after"); v != "" {
+				d, err := parseRelativeDuration(v)
+				if err != nil || d <= 0 {
+					writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid after: must be a positive duration such as 30m or 1d")
+					return
+				}
+				after = d
+			}
+			limit := 100
+			if v := query.Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid limit")
+					return
+				}
+				limit = int(min(int64(n), cfg.Live().MaxQueryRows))
+			}
+			sources, err := silentSources(readDB, r, cfg, account, query.Get("system"), query.Get("module"), after, limit)
+			if queryAborted(w, r, cfg, err) {
+				return
+			}
+			if err != nil {
+				requestLogger(r).Error("Error querying silent sources", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch silent sources")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(sources)
+
+		case http.MethodDelete:
+			system, module := query.Get("system"), query.Get("module")
+			if system == "" || module == "" {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "system and module are required")
+				return
+			}
+			res, err := db.Exec("DELETE FROM source_heartbeats WHERE account = ? AND system = ? AND module = ?", account, system, module)
+			if err != nil {
+				requestLogger(r).Error("Error deleting heartbeat", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete source")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Source not found")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("Source %s/%s forgotten", system, module)})
+
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSlowQueries bounds the distinct statements kept by the slow query log;
+// the least recently seen is dropped for a new one.
+const maxSlowQueries = 100
+
+// maxSlowQueryParam bounds the length of each argument kept in
+// slow_queries.params, so long search strings do not bloat the table.
+const maxSlowQueryParam = 64
+
+const slowQueriesSchema = `CREATE TABLE IF NOT EXISTS slow_queries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    occurred_at DATETIME NOT NULL,
+    statement TEXT NOT NULL,
+    params TEXT NOT NULL,
+    rows INTEGER,
+    duration_ms INTEGER NOT NULL
+)`
+
+// slowQueries collects the read queries slower than SLOW_QUERY_THRESHOLD for
+// GET /admin/queries, and records each run in slow_queries for GET
+// /admin/queries/history. It is set at startup.
+var slowQueries *slowQueryLog
+
+type slowQueryLog struct {
+	db        *sql.DB
+	threshold time.Duration
+	// retention is how long runs are kept in slow_queries; zero records none.
+	retention time.Duration
+
+	mu          sync.Mutex
+	byStatement map[string]*SlowQuery
+}
+
+// SlowQuery aggregates the slow runs of one statement. Plan is its EXPLAIN
+// QUERY PLAN for the latest arguments, and Suggestion an index that would
+// avoid a table scan or a sort, when one is missing.
+type SlowQuery struct {
+	Statement  string    `json:"statement"`
+	Count      int64     `json:"count"`
+	TotalMs    int64     `json:"total_ms"`
+	MaxMs      int64     `json:"max_ms"`
+	LastSeen   time.Time `json:"last_seen"`
+	Plan       []string  `json:"plan,omitempty"`
+	Suggestion string    `json:"suggestion,omitempty"`
+
+	sql  string
+	args []any
+}
+
+// statementNumberRe matches the limits and offsets formatted into
+// statements, so pages of one query share a statement.
+var statementNumberRe = regexp.MustCompile(`\b(LIMIT|OFFSET) \d+`)
+
+// SlowQueryRun is a row of slow_queries, one run of a slow statement. Params
+// are its arguments, each cut to maxSlowQueryParam bytes, and Rows the rows
+// it returned, absent for statements read as a single value.
+type SlowQueryRun struct {
+	ID         int64     `json:"id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Statement  string    `json:"statement"`
+	Params     []string  `json:"params"`
+	Rows       *int64    `json:"rows,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+func newSlowQueryLog(db *sql.DB, cfg *Config) *slowQueryLog {
+	return &slowQueryLog{db: db, threshold: cfg.SlowQueryThreshold, retention: cfg.SlowQueryRetention, byStatement: map[string]*SlowQuery{}}
+}
+
+// observe records a query started at start when it ran longer than the
+// threshold. Callers defer it so the time spent reading rows counts, and
+// count the rows they read in rows, or pass nil.
+func (l *slowQueryLog) observe(sqlQuery string, args []any, start time.Time, rows *int64) {
+	elapsed := time.Since(start)
+	if l == nil || elapsed < l.threshold {
+		return
+	}
+	statement := statementNumberRe.ReplaceAllString(strings.Join(strings.Fields(sqlQuery), " "), "$1 ?")
+	slog.Warn("Slow query", "statement", statement, "duration", elapsed)
+	l.aggregate(statement, sqlQuery, args, elapsed)
+	if l.retention > 0 {
+		l.store(statement, args, elapsed, rows)
+	}
+}
+
+// aggregate adds a run of statement to the statements GET /admin/queries
+// lists.
+func (l *slowQueryLog) aggregate(statement, sqlQuery string, args []any, elapsed time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	q, ok := l.byStatement[statement]
+	if !ok {
+		if len(l.byStatement) >= maxSlowQueries {
+			var oldest *SlowQuery
+			for _, other := range l.byStatement {
+				if oldest == nil || other.LastSeen.Before(oldest.LastSeen) {
+					oldest = other
+				}
+			}
+			delete(l.byStatement, oldest.Statement)
+		}
+		q = &SlowQuery{Statement: statement}
+		l.byStatement[statement] = q
+	}
+	q.Count++
+	q.TotalMs += elapsed.Milliseconds()
+	q.MaxMs = max(q.MaxMs, elapsed.Milliseconds())
+	q.LastSeen = timeNow().UTC()
+	q.sql, q.args = sqlQuery, args
+}
+
+// store appends a run of statement to slow_queries, dropping the runs older
+// than the retention. Failures are logged but never fail the query.
+func (l *slowQueryLog) store(statement string, args []any, elapsed time.Duration, rows *int64) {
+	params := make([]string, len(args))
+	for i, arg := range args {
+		var text string
+		switch v := arg.(type) {
+		case time.Time:
+			text = v.UTC().Format(time.RFC3339Nano)
+		case []byte:
+			text = fmt.Sprintf("<%d bytes>", len(v))
+		default:
+			text = fmt.Sprint(v)
+		}
+		if len(text) > maxSlowQueryParam {
+			text = strings.ToValidUTF8(text[:maxSlowQueryParam], "") + "..."
+		}
+		params[i] = text
+	}
+	encoded, _ := json.Marshal(params)
+	now := timeNow().UTC()
+	if _, err := l.db.Exec("INSERT INTO slow_queries (occurred_at, statement, params, rows, duration_ms) VALUES (?, ?, ?, ?, ?)",
+		now, statement, string(encoded), rows, elapsed.Milliseconds()); err != nil {
+		slog.Error("Error writing slow query log", "err", err)
+		return
+	}
+	if _, err := l.db.Exec("DELETE FROM slow_queries WHERE occurred_at < ?", now.Add(-l.retention)); err != nil {
+		slog.Error("Error pruning slow query log", "err", err)
+	}
+}
+
+// snapshot returns copies of the recorded queries, slowest in total first.
+func (l *slowQueryLog) snapshot() []SlowQuery {
+	queries := []SlowQuery{}
+	if l == nil {
+		return queries
+	}
+	l.mu.Lock()
+	for _, q := range l.byStatement {
+		queries = append(queries, *q)
+	}
+	l.mu.Unlock()
+	slices.SortFunc(queries, func(a, b SlowQuery) int { return int(b.TotalMs - a.TotalMs) })
+	return queries
+}
+
+func (l *slowQueryLog) reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.byStatement = map[string]*SlowQuery{}
+	l.mu.Unlock()
+}
+
+// explainQuery returns the EXPLAIN QUERY PLAN steps of a query.
+func explainQuery(ctx context.Context, db *sql.DB, sqlQuery string, args []any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return nil, err
+		}
+		plan = append(plan, detail)
+	}
+	return plan, rows.Err()
+}
+
+var (
+	// equalityColumnRe and rangeColumnRe find the filters an index on
+	// logData could serve, in the statements built by buildLogFilter.
+	equalityColumnRe = regexp.MustCompile(`\b(account|system|user|module|task|level|trace_id|session_id) = \?`)
+	rangeColumnRe    = regexp.MustCompile(`\btimestamp (?:>=|<=|<|>) \?|ORDER BY timestamp`)
+)
+
+// suggestIndex proposes an index on logData for a plan that scans a log
+// table or sorts its rows: the columns filtered by equality, then timestamp
+// when it bounds or orders the query. It returns "" when the plan uses
+// indexes or an index with those leading columns exists.
+func suggestIndex(ctx context.Context, db *sql.DB, sqlQuery string, plan []string) (string, error) {
+	needed := false
+	for _, step := range plan {
+		scansTable := strings.HasPrefix(step, "SCAN logData") && !strings.Contains(step, "USING")
+		if scansTable || strings.HasPrefix(step, "USE TEMP B-TREE FOR ORDER BY") {
+			needed = true
+		}
+	}
+	if !needed {
+		return "", nil
+	}
+	var columns []string
+	for _, m := range equalityColumnRe.FindAllStringSubmatch(sqlQuery, -1) {
+		if !slices.Contains(columns, m[1]) {
+			columns = append(columns, m[1])
+		}
+	}
+	if rangeColumnRe.MatchString(sqlQuery) {
+		columns = append(columns, "timestamp")
+	}
+	if len(columns) == 0 {
+		return "", nil
+	}
+
+	// Partitions copy their indexes from the first table
+	table := logDataTables()[0]
+	indexes, err := indexColumns(ctx, db, table)
+	if err != nil {
+		return "", err
+	}
+	for _, existing := range indexes {
+		if len(existing) >= len(columns) && slices.Equal(existing[:len(columns)], columns) {
+			return "", nil
+		}
+	}
+	return fmt.Sprintf("CREATE INDEX idx_%s ON %s(%s)", strings.Join(columns, "_"), table, strings.Join(columns, ", ")), nil
+}
+
+// indexColumns returns the columns of each index on table, in index order.
+func indexColumns(ctx context.Context, db *sql.DB, table string) (map[string][]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT il.name, ii.name FROM pragma_index_list(?) il, pragma_index_info(il.name) ii
+		ORDER BY il.name, ii.seqno`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	indexes := map[string][]string{}
+	for rows.Next() {
+		var index string
+		var column sql.NullString
+		if err := rows.Scan(&index, &column); err != nil {
+			return nil, err
+		}
+		indexes[index] = append(indexes[index], column.String)
+	}
+	return indexes, rows.Err()
+}
+
+// handleSlowQueries serves GET /admin/queries, the slow query log with each
+// statement's plan and index suggestion, and DELETE /admin/queries, which
+// clears it.
+func handleSlowQueries(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			ctx, cancel := queryContext(r, cfg)
+			defer cancel()
+			queries := slowQueries.snapshot()
+			for i := range queries {
+				q := &queries[i]
+				plan, err := explainQuery(ctx, db, q.sql, q.args)
+				if err != nil {
+					requestLogger(r).Warn("Error explaining query", "statement", q.Statement, "err", err)
+					continue
+				}
+				q.Plan = plan
+				if q.Suggestion, err = suggestIndex(ctx, db, q.sql, plan); err != nil {
+					requestLogger(r).Warn("Error listing indexes", "err", err)
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(queries)
+		case http.MethodDelete:
+			slowQueries.reset()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Slow query log cleared"})
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// handleSlowQueryHistory serves GET /admin/queries/history, slow_queries
+// newest first, filtered by statement, a min_duration and an RFC 3339
+// start_time/end_time, paged with limit (default 100) and offset.
+func handleSlowQueryHistory(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		sqlQuery := "SELECT id, occurred_at, statement, params, rows, duration_ms FROM slow_queries WHERE 1=1"
+		var args []interface{}
+		if v := query.Get("statement"); v != "" {
+			sqlQuery += " AND statement = ?"
+			args = append(args, v)
+		}
+		if v := query.Get("min_duration"); v != "" {
+			d, err := parseRelativeDuration(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid min_duration: %v", err))
+				return
+			}
+			sqlQuery += " AND duration_ms >= ?"
+			args = append(args, d.Milliseconds())
+		}
+		for _, bound := range []struct{ name, op string }{{"start_time", ">="}, {"end_time", "<="}} {
+			if v := query.Get(bound.name); v != "" {
+				t, err := parseTimeBound(v, time.UTC, timeNow())
+				if err != nil {
+					writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid %s: %v", bound.name, err))
+					return
+				}
+				sqlQuery += " AND occurred_at " + bound.op + " ?"
+				args = append(args, t.UTC())
+			}
+		}
+
+		var limit, offset int64 = 100, 0
+		if query.Get("limit") != "" {
+			fmt.Sscanf(query.Get("limit"), "%d", &limit)
+		}
+		if query.Get("offset") != "" {
+			fmt.Sscanf(query.Get("offset"), "%d", &offset)
+		}
+		sqlQuery += fmt.Sprintf(" ORDER BY id DESC LIMIT %d OFFSET %d", limit, offset)
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		rows, err := db.QueryContext(ctx, sqlQuery, args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying slow query log", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch slow query log")
+			return
+		}
+		defer rows.Close()
+
+		runs := []SlowQueryRun{}
+		for rows.Next() {
+			var run SlowQueryRun
+			var params string
+			var n sql.NullInt64
+			if err := rows.Scan(&run.ID, &run.OccurredAt, &run.Statement, &params, &n, &run.DurationMs); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			json.Unmarshal([]byte(params), &run.Params)
+			if n.Valid {
+				run.Rows = &n.Int64
+			}
+			runs = append(runs, run)
+		}
+		if err := rows.Err(); err != nil {
+			if !queryAborted(w, r, cfg, err) {
+				requestLogger(r).Error("Error reading slow query log", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch slow query log")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(runs)
+	}
+}
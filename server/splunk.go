@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// splunkEvent is an event of the Splunk HTTP Event Collector API, as the
+// Docker splunk log driver sends them.
+type splunkEvent struct {
+	Event      json.RawMessage `json:"event"`
+	Time       json.Number     `json:"time"`
+	Host       string          `json:"host"`
+	Source     string          `json:"source"`
+	SourceType string          `json:"sourcetype"`
+	Index      string          `json:"index"`
+	Fields     map[string]any  `json:"fields"`
+}
+
+// dockerLine is the event of the Docker splunk driver's inline and json
+// formats: the line, the stream it was written to, the tag (by default the
+// container ID) and the labels and environment variables of the container.
+type dockerLine struct {
+	Line   json.RawMessage   `json:"line"`
+	Source string            `json:"source"`
+	Tag    string            `json:"tag"`
+	Attrs  map[string]string `json:"attrs"`
+}
+
+// SplunkResponse is the response of the Splunk HEC endpoints.
+type SplunkResponse struct {
+	Text string `json:"text"`
+	Code int    `json:"code"`
+}
+
+// handleSplunkEvents serves POST /services/collector/event, the Splunk HTTP
+// Event Collector API, for the Docker splunk log driver and other HEC
+// clients. The body is a stream of events; each is stored in the X-Account
+// (or token) account, or else the account its index names. OPTIONS answers
+// the driver's connection check.
+func handleSplunkEvents(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Method != http.MethodPost {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if isBodyTooLarge(err) {
+			requestLogger(r).Warn("Request body too large", "err", err)
+			writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+				fmt.Sprintf("Request body exceeds %d bytes", cfg.Live().MaxBodyBytes), nil)
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error reading request body", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Failed to read request body")
+			return
+		}
+
+		var entries []LogData
+		dec := json.NewDecoder(bytes.NewReader(body))
+		for dec.More() {
+			var event splunkEvent
+			if err := dec.Decode(&event); err != nil {
+				requestLogger(r).Warn("Invalid HEC request", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+				return
+			}
+			logData, err := splunkEntry(event)
+			if err != nil {
+				requestLogger(r).Warn("Invalid HEC event", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid event: %v", err))
+				return
+			}
+			if account := requestAccount(r); account != "" {
+				logData.Account = account
+			}
+			if !accountAllowed(r, logData.Account) {
+				requestLogger(r).Warn("Access denied", "account", logData.Account)
+				writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+				return
+			}
+			entries = append(entries, logData)
+		}
+		if limit := accountSettings.maxBatchSize(cfg, batchAccounts(entries)...); len(entries) > limit {
+			requestLogger(r).Warn("HEC batch too large", "entries", len(entries))
+			writeErrorDetails(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+				fmt.Sprintf("Batch exceeds %d entries", limit), nil)
+			return
+		}
+
+		stored := 0
+		for _, logData := range entries {
+			payload, _ := json.Marshal(logData)
+			logData.splitStackTrace()
+			if err := logData.Validate(); err != nil {
+				rejectLog(db, cfg, logData.Account, payload, fmt.Sprintf("Validation failed: %v", err))
+				requestLogger(r).Warn("Skipping invalid HEC event", "err", err)
+				continue
+			}
+			if errs := logData.checkLimits(cfg); len(errs) > 0 {
+				rejectLog(db, cfg, logData.Account, payload, "Payload limits exceeded")
+				requestLogger(r).Warn("Skipping oversized HEC event", "errors", errs)
+				continue
+			}
+			if err := logData.checkClock(cfg); err != nil {
+				rejectLog(db, cfg, logData.Account, payload, fmt.Sprintf("Validation failed: %v", err))
+				requestLogger(r).Warn("Skipping HEC event out of time range", "err", err)
+				continue
+			}
+			sources.enrich(&logData, clientAddr(r, cfg))
+			var quotaErr *quotaError
+			if err := insertLogData(db, cfg, &logData); errors.As(err, &quotaErr) {
+				requestLogger(r).Warn("Rejected HEC events", "account", logData.Account, "err", err)
+				writeError(w, quotaErr.status, codeQuotaExceeded, err.Error())
+				return
+			} else if err != nil {
+				requestLogger(r).Error("Error saving log data", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save log data")
+				return
+			}
+			stored++
+		}
+
+		requestLogger(r).Info("Stored HEC events", "stored", stored, "events", len(entries))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SplunkResponse{Text: "Success", Code: 0})
+	}
+}
+
+// splunkEntry maps a HEC event onto LogData. Docker events map the host to
+// the system, the tag to the module and the stream to the task, and keep
+// the container attributes in Fields; a JSON line (the driver's json
+// format) takes its msg from msg, message or log and keeps its other keys.
+// Other events are stored as their text, with the source as the module.
+func splunkEntry(event splunkEvent) (LogData, error) {
+	logData := LogData{
+		Account: event.Index,
+		System:  event.Host,
+		User:    "docker",
+		Module:  event.Source,
+		Task:    "event",
+		Level:   LevelInfo,
+	}
+	fields := map[string]any{}
+	for k, v := range event.Fields {
+		fields[k] = v
+	}
+
+	var line dockerLine
+	var text string
+	if json.Unmarshal(event.Event, &text) == nil {
+		// The driver's raw format, or a plain HEC event
+		logData.Msg = text
+	} else if err := json.Unmarshal(event.Event, &line); err == nil && line.Line != nil {
+		logData.Module = line.Tag
+		logData.Task = line.Source
+		for k, v := range line.Attrs {
+			fields[k] = v
+		}
+		var record map[string]any
+		if json.Unmarshal(line.Line, &text) == nil {
+			logData.Msg = text
+		} else if json.Unmarshal(line.Line, &record) == nil {
+			for _, key := range []string{"msg", "message", "log"} {
+				if s, ok := record[key].(string); ok && logData.Msg == "" {
+					logData.Msg = s
+					delete(record, key)
+				}
+			}
+			for k, v := range record {
+				fields[k] = v
+			}
+		} else {
+			logData.Msg = string(line.Line)
+		}
+	} else if len(event.Event) > 0 {
+		logData.Msg = string(event.Event)
+	} else {
+		return LogData{}, fmt.Errorf("event is required")
+	}
+	if logData.Module == "" {
+		logData.Module = "docker"
+	}
+	if logData.Task == "" {
+		logData.Task = "event"
+	}
+	if name, ok := lineLevel(logData.Msg); ok {
+		logData.Level, logData.levelName = levelNames[name], name
+	}
+
+	logData.Timestamp = timeNow().UTC()
+	if event.Time != "" {
+		// Seconds since the epoch, with a fraction
+		secs, err := strconv.ParseFloat(string(event.Time), 64)
+		if err != nil {
+			return LogData{}, fmt.Errorf("invalid time %q", event.Time)
+		}
+		logData.Timestamp = time.UnixMicro(int64(secs * 1e6)).UTC()
+	}
+	if len(fields) > 0 {
+		logData.Fields = fields
+	}
+	return logData, nil
+}
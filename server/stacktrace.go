@@ -0,0 +1,54 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// stackFrameRe matches the lines that start a stack trace in Java,
+	// JavaScript, Go and Python output.
+	stackFrameRe = regexp.MustCompile(`^(?:\s+at \S|Caused by: |\s*\.\.\. \d+ more$|goroutine \d+ \[|\s+File ".*", line \d+|\t\S+\.go:\d+)`)
+	// tracebackRe matches the header of a Python traceback, which ends with
+	// the exception message rather than starting with it.
+	tracebackRe = regexp.MustCompile(`^Traceback \(most recent call last\):`)
+)
+
+// splitStackTrace moves a stack trace sent as part of a multi-line msg to
+// StackTrace, leaving the lines before it, or for a bare Python traceback
+// its final exception line, as the message. Entries with a StackTrace of
+// their own are left alone.
+func (l *LogData) splitStackTrace() {
+	if l.StackTrace != "" || !strings.Contains(l.Msg, "\n") {
+		return
+	}
+	lines := strings.Split(strings.ReplaceAll(l.Msg, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case tracebackRe.MatchString(line):
+			msg := strings.TrimSpace(strings.Join(lines[:i], "\n"))
+			if msg == "" {
+				msg = lastLine(lines[i:])
+			}
+			if msg != "" {
+				l.Msg, l.StackTrace = msg, strings.TrimRight(strings.Join(lines[i:], "\n"), "\n")
+			}
+			return
+		case i > 0 && stackFrameRe.MatchString(line):
+			if msg := strings.TrimSpace(strings.Join(lines[:i], "\n")); msg != "" {
+				l.Msg, l.StackTrace = msg, strings.TrimRight(strings.Join(lines[i:], "\n"), "\n")
+			}
+			return
+		}
+	}
+}
+
+// lastLine returns the last non-blank line, trimmed.
+func lastLine(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// standaloneDefaults are the settings --standalone uses when they are not
+// set, so the server runs without any configuration, e.g. for evaluation
+// with `docker run`.
+var standaloneDefaults = map[string]string{
+	"DATABASE_PATH": "data/logdata.db",
+	"PORT":          "8080",
+}
+
+// applyStandaloneDefaults sets the unset standaloneDefaults and creates the
+// database's directory.
+func applyStandaloneDefaults() error {
+	for name, value := range standaloneDefaults {
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, value)
+		}
+	}
+	if path := os.Getenv("DATABASE_PATH"); !isMemoryDatabase(path) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("failed to create database directory: %v", err)
+		}
+	}
+	return nil
+}
+
+// StartupInfo is the JSON line --standalone prints to stdout once the
+// server listens, for scripts and orchestrators waiting on it.
+type StartupInfo struct {
+	Event             string `json:"event"`
+	PID               int    `json:"pid"`
+	Addr              string `json:"addr"`
+	URL               string `json:"url"`
+	OpenAPI           string `json:"openapi"`
+	IngestAddr        string `json:"ingest_addr,omitempty"`
+	IngestURL         string `json:"ingest_url,omitempty"`
+	Database          string `json:"database"`
+	AuthRequired      bool   `json:"auth_required"`
+	GRPCPort          string `json:"grpc_port,omitempty"`
+	FluentForwardPort string `json:"fluent_forward_port,omitempty"`
+}
+
+// printStartupInfo describes the query listener, listeners[0], and the
+// ingest listener when there is one.
+func printStartupInfo(cfg *Config, listeners []*httpListener) {
+	query := listeners[0]
+	info := StartupInfo{
+		Event: "started", PID: os.Getpid(), Addr: query.lis.Addr().String(), URL: query.url(), OpenAPI: query.url() + "/openapi.json",
+		Database: cfg.DatabasePath, AuthRequired: cfg.AuthRequired,
+		GRPCPort: cfg.GRPCPort, FluentForwardPort: cfg.FluentForwardPort,
+	}
+	for _, l := range listeners[1:] {
+		if l.name == "ingest" {
+			info.IngestAddr, info.IngestURL = l.lis.Addr().String(), l.url()
+		}
+	}
+	json.NewEncoder(os.Stdout).Encode(info)
+}
+
+// shutdownOnSignal stops the listeners on SIGTERM or SIGINT, letting
+// requests in flight finish within cfg.ShutdownTimeout, then closes srv,
+// which stops its gRPC, Fluentd forward and GELF listeners the same way. Without a handler a
+// server running as PID 1 in a container would ignore SIGTERM until killed.
+func shutdownOnSignal(cfg *Config, listeners []*httpListener, srv *Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+	slog.Info("Shutting down", "signal", sig.String(), "timeout", cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.srv.Shutdown(ctx); err != nil {
+				slog.Warn("Requests still in flight at shutdown", "listener", l.name, "err", err)
+			}
+		}()
+	}
+	wg.Wait()
+	srv.close(ctx)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Statements run for every stored entry or common read, kept prepared.
+var (
+	insertEntrySQL = insertEntryQuery("logData", "NULL")
+	traceSQL       = "SELECT " + logDataColumns + " FROM logData WHERE account = ? AND trace_id = ? ORDER BY timestamp ASC, id ASC"
+	sessionSQL     = "SELECT " + logDataColumns + " FROM logData WHERE account = ? AND session_id = ? ORDER BY timestamp ASC, id ASC"
+)
+
+// writeStatements and readStatements hold the statements prepared on the
+// write database and the read pool. They are nil until New prepares them,
+// and their methods then run queries unprepared.
+var writeStatements, readStatements *preparedStatements
+
+// preparedStatements are statements prepared once on a database and reused
+// by every request, sparing SQLite from parsing them each time. Queries are
+// looked up by their text, so callers pass the same strings they prepared.
+type preparedStatements struct {
+	db    *sql.DB
+	stmts map[string]*sql.Stmt
+}
+
+// prepareStatements prepares queries on db.
+func prepareStatements(db *sql.DB, queries ...string) (*preparedStatements, error) {
+	p := &preparedStatements{db: db, stmts: make(map[string]*sql.Stmt, len(queries))}
+	for _, query := range queries {
+		stmt, err := db.Prepare(query)
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("failed to prepare statement: %v", err)
+		}
+		p.stmts[query] = stmt
+	}
+	return p, nil
+}
+
+// exec runs query on ex, which is p's database or one of its transactions,
+// through the prepared statement when there is one.
+func (p *preparedStatements) exec(ex execer, query string, args ...any) (sql.Result, error) {
+	if p != nil {
+		if stmt, ok := p.stmts[query]; ok {
+			switch ex := ex.(type) {
+			case *sql.Tx:
+				// Reuses the statement prepared on the transaction's connection
+				return ex.Stmt(stmt).Exec(args...)
+			case *sql.DB:
+				if ex == p.db {
+					return stmt.Exec(args...)
+				}
+			}
+		}
+	}
+	return ex.Exec(query, args...)
+}
+
+// rowQuerier is a database or one of its transactions.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// queryRow runs query on q, which is p's database or one of its
+// transactions, through the prepared statement when there is one.
+func (p *preparedStatements) queryRow(ctx context.Context, q rowQuerier, query string, args ...any) *sql.Row {
+	if p != nil {
+		if stmt, ok := p.stmts[query]; ok {
+			switch q := q.(type) {
+			case *sql.Tx:
+				return q.StmtContext(ctx, stmt).QueryRowContext(ctx, args...)
+			case *sql.DB:
+				if q == p.db {
+					return stmt.QueryRowContext(ctx, args...)
+				}
+			}
+		}
+	}
+	return q.QueryRowContext(ctx, query, args...)
+}
+
+// query runs query on db through the prepared statement when there is one.
+func (p *preparedStatements) query(ctx context.Context, db *sql.DB, query string, args ...any) (*sql.Rows, error) {
+	if p != nil && db == p.db {
+		if stmt, ok := p.stmts[query]; ok {
+			return stmt.QueryContext(ctx, args...)
+		}
+	}
+	return db.QueryContext(ctx, query, args...)
+}
+
+func (p *preparedStatements) close() {
+	if p == nil {
+		return
+	}
+	for _, stmt := range p.stmts {
+		stmt.Close()
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// streamFlushRows is how many entries /getdata writes between flushes.
+const streamFlushRows = 100
+
+// entryStream writes the entries of a /getdata response as they are read, as
+// a JSON array or, with format=ndjson, one entry per line. It keeps a copy of
+// JSON responses for the query cache while they are small enough.
+type entryStream struct {
+	w          http.ResponseWriter
+	flusher    http.Flusher
+	ndjson     bool
+	projection []string
+	cached     *bytes.Buffer
+	// rows counts the entries written.
+	rows int
+	// paginate, when set, wraps the array in an EntryPage, returning its
+	// Pagination given the number of entries written.
+	paginate func(rows int) Pagination
+	started  time.Time
+}
+
+// EntryPage is the body of /getdata with envelope=true.
+type EntryPage struct {
+	Data       []LogData  `json:"data"`
+	Pagination Pagination `json:"pagination"`
+	// QueryTimeMS is the time from the start of the query to the last
+	// entry; cached responses repeat the original's.
+	QueryTimeMS int64 `json:"query_time_ms"`
+}
+
+// Pagination locates an EntryPage in the results of its query.
+type Pagination struct {
+	Limit  *int64 `json:"limit"`
+	Offset int64  `json:"offset"`
+	// NextCursor fetches the next page, null on the last one.
+	NextCursor *string `json:"next_cursor"`
+}
+
+// encodeCursor returns the cursor of the page at offset of a query anchored
+// at asOf.
+func encodeCursor(offset, asOf int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d", offset, asOf)))
+}
+
+// decodeCursor returns the offset and as_of of a cursor.
+func decodeCursor(cursor string) (offset, asOf int64, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		_, err = fmt.Sscanf(string(b), "%d.%d", &offset, &asOf)
+	}
+	if err != nil || offset < 0 || asOf < 0 {
+		return 0, 0, fmt.Errorf("Invalid cursor")
+	}
+	return offset, asOf, nil
+}
+
+func newEntryStream(w http.ResponseWriter, projection []string, ndjson bool) *entryStream {
+	s := &entryStream{w: w, ndjson: ndjson, projection: projection, started: time.Now()}
+	s.flusher, _ = w.(http.Flusher)
+	if queryResults != nil && !ndjson {
+		s.cached = &bytes.Buffer{}
+	}
+	return s
+}
+
+// start sends the headers and opens the array.
+func (s *entryStream) start() {
+	if s.ndjson {
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+		return
+	}
+	s.w.Header().Set("Content-Type", "application/json")
+	if s.paginate != nil {
+		s.emit([]byte(`{"data":[`))
+		return
+	}
+	s.emit([]byte("["))
+}
+
+// emit writes b to the response and the cached copy, dropping the copy once
+// it outgrows the cache.
+func (s *entryStream) emit(b []byte) error {
+	if s.cached != nil {
+		if s.cached.Len()+len(b) > maxCachedResponse {
+			s.cached = nil
+		} else {
+			s.cached.Write(b)
+		}
+	}
+	_, err := s.w.Write(b)
+	return err
+}
+
+// write adds an entry to the response.
+func (s *entryStream) write(logData LogData) error {
+	var value any = logData
+	if len(s.projection) > 0 {
+		value = projectEntry(logData, s.projection)
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	switch {
+	case s.rows == 0:
+		s.start()
+	case !s.ndjson:
+		b = append([]byte(",\n"), b...)
+	}
+	if s.ndjson {
+		b = append(b, '\n')
+	}
+	if err := s.emit(b); err != nil {
+		return err
+	}
+	if s.rows++; s.rows%streamFlushRows == 0 && s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+// close ends the response, and returns its body when the cache may keep it.
+func (s *entryStream) close() []byte {
+	if s.rows == 0 {
+		s.start()
+	}
+	switch {
+	case s.paginate != nil:
+		pagination, _ := json.Marshal(s.paginate(s.rows))
+		s.emit([]byte(fmt.Sprintf(`],"pagination":%s,"query_time_ms":%d}`+"\n", pagination, time.Since(s.started).Milliseconds())))
+	case !s.ndjson:
+		s.emit([]byte("]\n"))
+	}
+	if s.cached == nil {
+		return nil
+	}
+	return s.cached.Bytes()
+}
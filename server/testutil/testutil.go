@@ -0,0 +1,186 @@
+// Package testutil runs a complete logdata server in process, against an
+// in-memory SQLite database, so client libraries and adapters can run
+// integration tests without the server binary or a database file.
+//
+//	srv := testutil.NewServer(t, nil)
+//	c := client.New(client.Config{Server: srv.URL, Account: "acme", Token: testutil.AdminToken})
+//	...
+//	entries := srv.Entries("acme", nil)
+//
+// The server's state is process-wide, and NewServer sets the configuration
+// with t.Setenv, so tests using it cannot run in parallel.
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"log-server/server"
+)
+
+// AdminToken is the ADMIN_TOKEN of servers started by NewServer, unless
+// their env sets another one.
+const AdminToken = "testutil-admin"
+
+// databases numbers the in-memory databases, each server getting its own.
+var databases atomic.Int64
+
+// Server is a logdata server started for a test. It serves HTTP both
+// directly, as an http.Handler, and at URL.
+type Server struct {
+	*server.Server
+	// URL is the base URL of the server, for clients that take one.
+	URL string
+	// Clock is the server's clock. It stands still unless set or advanced.
+	Clock *Clock
+
+	t          testing.TB
+	adminToken string
+}
+
+// NewServer starts a server configured with env and opts, on top of an
+// in-memory database, PORT and the AdminToken, and stops it when the test
+// ends. Its background jobs, such as retention and alerts, do not run.
+func NewServer(t testing.TB, env map[string]string, opts ...server.Option) *Server {
+	t.Helper()
+	vars := map[string]string{
+		"DATABASE_PATH": fmt.Sprintf("file:testutil-%d?mode=memory", databases.Add(1)),
+		"PORT":          "0",
+		"ADMIN_TOKEN":   AdminToken,
+	}
+	for name, value := range env {
+		vars[name] = value
+	}
+	for name, value := range vars {
+		t.Setenv(name, value)
+	}
+
+	cfg, err := server.LoadConfig()
+	if err != nil {
+		t.Fatalf("testutil: invalid configuration: %v", err)
+	}
+	clock := NewClock(time.Now().UTC().Truncate(time.Second))
+	server.SetClock(clock.Now)
+	t.Cleanup(func() { server.SetClock(nil) })
+	srv, err := server.New(cfg, opts...)
+	if err != nil {
+		t.Fatalf("testutil: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	hs := httptest.NewServer(srv)
+	t.Cleanup(hs.Close)
+	return &Server{Server: srv, URL: hs.URL, Clock: clock, t: t, adminToken: vars["ADMIN_TOKEN"]}
+}
+
+// Entry returns a valid entry of account with msg, at level info and the
+// clock's time. Its system, user, module and task are "test".
+func (s *Server) Entry(account, msg string) server.LogData {
+	return server.LogData{
+		Account:   account,
+		System:    "test",
+		User:      "test",
+		Module:    "test",
+		Task:      "test",
+		Timestamp: s.Clock.Now(),
+		Msg:       msg,
+		Level:     server.LevelInfo,
+	}
+}
+
+// Seed stores entries as POST /import does, so without sampling,
+// deduplication or webhooks, and fails the test if any is rejected.
+func (s *Server) Seed(entries ...server.LogData) {
+	s.t.Helper()
+	byAccount := map[string]*bytes.Buffer{}
+	var accounts []string
+	for _, entry := range entries {
+		body := byAccount[entry.Account]
+		if body == nil {
+			body = &bytes.Buffer{}
+			byAccount[entry.Account] = body
+			accounts = append(accounts, entry.Account)
+		}
+		if err := json.NewEncoder(body).Encode(entry); err != nil {
+			s.t.Fatalf("testutil: encoding entry: %v", err)
+		}
+	}
+	for _, account := range accounts {
+		var res server.ImportResult
+		s.do(http.MethodPost, "/import", account, byAccount[account], &res)
+		if res.Rejected > 0 || res.Error != "" {
+			s.t.Fatalf("testutil: seeding %s: %d of %d entries rejected %v %s", account, res.Rejected, res.Lines, res.Errors, res.Error)
+		}
+	}
+}
+
+// Entries returns the stored entries of account matching the /getdata
+// parameters in params, which may be nil.
+func (s *Server) Entries(account string, params url.Values) []server.LogData {
+	s.t.Helper()
+	query := url.Values{}
+	for name, values := range params {
+		query[name] = values
+	}
+	query.Set("account", account)
+	var entries []server.LogData
+	s.do(http.MethodGet, "/getdata?"+query.Encode(), account, nil, &entries)
+	return entries
+}
+
+// do sends an admin request to the server and decodes its JSON response
+// into out, failing the test on an error status.
+func (s *Server) do(method, path, account string, body io.Reader, out any) {
+	s.t.Helper()
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Authorization", "Bearer "+s.adminToken)
+	req.Header.Set("X-Account", account)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code >= 300 {
+		s.t.Fatalf("testutil: %s %s: %d %s", method, path, rec.Code, rec.Body.String())
+	}
+	if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+		s.t.Fatalf("testutil: %s %s: decoding response: %v", method, path, err)
+	}
+}
+
+// Clock is a fake clock for the server. It is safe for concurrent use.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock standing at now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the clock's time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,95 @@
+package testutil_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"log-server/server"
+	"log-server/server/testutil"
+)
+
+func TestNewServer(t *testing.T) {
+	srv := testutil.NewServer(t, nil)
+	resp, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /healthz: %d, want 200", resp.StatusCode)
+	}
+
+	// Each server has its own database
+	srv.Seed(srv.Entry("acme", "first server"))
+	if entries := testutil.NewServer(t, nil).Entries("acme", nil); len(entries) != 0 {
+		t.Fatalf("second server has %d entries, want none", len(entries))
+	}
+}
+
+func TestSeedAndEntries(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	srv := testutil.NewServer(t, nil)
+	first := srv.Entry("acme", "first")
+	second := srv.Entry("acme", "second")
+	second.Level = server.LevelError
+	srv.Seed(first, second, srv.Entry("other", "other account"))
+
+	entries := srv.Entries("acme", nil)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries of acme, want 2", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Account != "acme" {
+			t.Errorf("entry of %s in the entries of acme", entry.Account)
+		}
+	}
+	errorEntries := srv.Entries("acme", url.Values{"level": {"error"}})
+	if len(errorEntries) != 1 || errorEntries[0].Msg != "second" {
+		t.Fatalf("got %v for level=error, want the second entry", errorEntries)
+	}
+	if strings.Contains(logs.String(), "deadline") {
+		t.Errorf("Seed logged a deadline warning:\n%s", logs.String())
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := testutil.NewClock(start)
+	if got := clock.Now(); !got.Equal(start) {
+		t.Fatalf("Now() = %v, want %v", got, start)
+	}
+	clock.Advance(time.Hour)
+	if got := clock.Now(); !got.Equal(start.Add(time.Hour)) {
+		t.Fatalf("after Advance, Now() = %v, want %v", got, start.Add(time.Hour))
+	}
+	clock.Set(start)
+	if got := clock.Now(); !got.Equal(start) {
+		t.Fatalf("after Set, Now() = %v, want %v", got, start)
+	}
+}
+
+func TestServerClock(t *testing.T) {
+	srv := testutil.NewServer(t, nil)
+	srv.Clock.Set(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	entry := srv.Entry("acme", "at the clock's time")
+	if !entry.Timestamp.Equal(srv.Clock.Now()) {
+		t.Fatalf("Entry timestamp = %v, want the clock's %v", entry.Timestamp, srv.Clock.Now())
+	}
+
+	// The server resolves relative times by the clock, not the system's
+	srv.Seed(entry)
+	srv.Clock.Advance(time.Minute)
+	got := srv.Entries("acme", url.Values{"start_time": {"now-2m"}})
+	if len(got) != 1 {
+		t.Fatalf("got %d entries for start_time=now-2m, want 1", len(got))
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const levelThresholdsSchema = `CREATE TABLE IF NOT EXISTS level_thresholds (
+    account TEXT NOT NULL,
+    module TEXT NOT NULL,
+    min_level INTEGER NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (account, module)
+)`
+
+// LevelThreshold is the lowest canonical level stored for the entries of
+// Account and Module. Entries below it are dropped at ingest and counted in
+// the account's usage. An empty Module applies to the modules of the
+// account without a threshold of their own.
+type LevelThreshold struct {
+	Account   string    `json:"account"`
+	Module    string    `json:"module"`
+	MinLevel  int       `json:"min_level"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks that the threshold names an account and a level.
+func (t LevelThreshold) Validate() error {
+	if t.Account == "" {
+		return fmt.Errorf("account is required")
+	}
+	if t.MinLevel <= 0 {
+		return fmt.Errorf("min_level must be positive")
+	}
+	return nil
+}
+
+// levelThresholds holds the level_thresholds rows in memory. It is set at
+// startup.
+var levelThresholds *levelThresholdSet
+
+type levelThresholdSet struct {
+	db *sql.DB
+
+	mu        sync.RWMutex
+	byAccount map[string]map[string]int
+}
+
+func newLevelThresholdSet(db *sql.DB) *levelThresholdSet {
+	return &levelThresholdSet{db: db, byAccount: map[string]map[string]int{}}
+}
+
+// reload refreshes the in-memory thresholds from the database.
+func (s *levelThresholdSet) reload() error {
+	rows, err := s.db.Query("SELECT account, module, min_level, updated_at FROM level_thresholds")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byAccount := map[string]map[string]int{}
+	for rows.Next() {
+		var t LevelThreshold
+		if err := rows.Scan(&t.Account, &t.Module, &t.MinLevel, &t.UpdatedAt); err != nil {
+			return err
+		}
+		if byAccount[t.Account] == nil {
+			byAccount[t.Account] = map[string]int{}
+		}
+		byAccount[t.Account][t.Module] = t.MinLevel
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.byAccount = byAccount
+	s.mu.Unlock()
+	return nil
+}
+
+// drops reports whether logData, with its level already canonical, is below
+// the threshold of its module and is not to be stored. Dropped entries are
+// counted in account_usage.
+func (s *levelThresholdSet) drops(logData LogData) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	modules := s.byAccount[logData.Account]
+	minLevel, ok := modules[logData.Module]
+	if !ok {
+		minLevel, ok = modules[""]
+	}
+	s.mu.RUnlock()
+	if !ok || logData.Level >= minLevel {
+		return false
+	}
+	if err := addDropped(s.db, logData.Account); err != nil {
+		slog.Error("Error counting dropped entry", "account", logData.Account, "err", err)
+	}
+	return true
+}
+
+// handleLevelThresholds serves GET, PUT and DELETE /thresholds, an account's
+// per-module level thresholds.
+func handleLevelThresholds(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := r.URL.Query().Get("account")
+		if account == "" && r.Method != http.MethodPut {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			rows, err := db.Query("SELECT account, module, min_level, updated_at FROM level_thresholds WHERE account = ? ORDER BY module", account)
+			if err != nil {
+				requestLogger(r).Error("Error querying level thresholds", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch level thresholds")
+				return
+			}
+			defer rows.Close()
+			thresholds := []LevelThreshold{}
+			for rows.Next() {
+				var t LevelThreshold
+				if err := rows.Scan(&t.Account, &t.Module, &t.MinLevel, &t.UpdatedAt); err != nil {
+					requestLogger(r).Error("Error scanning row", "err", err)
+					continue
+				}
+				thresholds = append(thresholds, t)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(thresholds)
+
+		case http.MethodPut:
+			var threshold LevelThreshold
+			if err := json.NewDecoder(r.Body).Decode(&threshold); err != nil {
+				requestLogger(r).Warn("Invalid request body", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+				return
+			}
+			if threshold.Account == "" {
+				threshold.Account = account
+			}
+			if err := threshold.Validate(); err != nil {
+				requestLogger(r).Warn("Validation failed", "err", err)
+				writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+				return
+			}
+			if !accountAllowed(r, threshold.Account) {
+				writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+				return
+			}
+			threshold.UpdatedAt = timeNow().UTC()
+			if _, err := db.Exec(`INSERT INTO level_thresholds (account, module, min_level, updated_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT (account, module) DO UPDATE SET min_level = excluded.min_level, updated_at = excluded.updated_at`,
+				threshold.Account, threshold.Module, threshold.MinLevel, threshold.UpdatedAt); err != nil {
+				requestLogger(r).Error("Error saving level threshold", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save level threshold")
+				return
+			}
+			if err := levelThresholds.reload(); err != nil {
+				requestLogger(r).Error("Error reloading level thresholds", "err", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(threshold)
+
+		case http.MethodDelete:
+			res, err := db.Exec("DELETE FROM level_thresholds WHERE account = ? AND module = ?", account, r.URL.Query().Get("module"))
+			if err != nil {
+				requestLogger(r).Error("Error deleting level threshold", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete level threshold")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Level threshold not found")
+				return
+			}
+			if err := levelThresholds.reload(); err != nil {
+				requestLogger(r).Error("Error reloading level thresholds", "err", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Level threshold deleted"})
+
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
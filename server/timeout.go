@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// queryContext returns the context for the queries of r: canceled when the
+// client disconnects and, when cfg.Live().QueryTimeout is set, after that long.
+func queryContext(r *http.Request, cfg *Config) (context.Context, context.CancelFunc) {
+	if cfg.Live().QueryTimeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), cfg.Live().QueryTimeout)
+}
+
+// queryAborted handles a query error caused by its context: it responds 504
+// when the query ran past cfg.Live().QueryTimeout and only logs when the client went
+// away. It reports false for other errors, which the caller handles.
+func queryAborted(w http.ResponseWriter, r *http.Request, cfg *Config, err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		requestLogger(r).Warn("Query timed out", "timeout", cfg.Live().QueryTimeout)
+		writeError(w, http.StatusGatewayTimeout, codeQueryTimeout,
+			fmt.Sprintf("Query exceeded the maximum duration of %s; narrow the time range or filters", cfg.Live().QueryTimeout))
+		return true
+	case errors.Is(err, context.Canceled):
+		requestLogger(r).Info("Query canceled by client")
+		return true
+	}
+	return false
+}
+
+// withLongRequest extends the read and write deadlines of requests that
+// stream large bodies, which HTTP_READ_TIMEOUT and HTTP_WRITE_TIMEOUT would
+// cut off, to cfg.LongRequestTimeout from their start. Writers without
+// deadlines, such as those of in-process requests, are left as they are.
+func withLongRequest(cfg *Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var deadline time.Time
+		if cfg.LongRequestTimeout > 0 {
+			deadline = time.Now().Add(cfg.LongRequestTimeout)
+		}
+		rc := http.NewResponseController(w)
+		if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			requestLogger(r).Warn("Failed to extend read deadline", "err", err)
+		}
+		if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			requestLogger(r).Warn("Failed to extend write deadline", "err", err)
+		}
+		next(w, r)
+	}
+}
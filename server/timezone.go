@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	// The image has no zoneinfo, and tz= takes any IANA zone
+	_ "time/tzdata"
+)
+
+// location returns the zone of params.TimeZone, UTC when unset.
+func (params QueryParams) location() *time.Location {
+	if params.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(params.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// offsetExpr returns an SQL expression for loc's UTC offset in seconds at
+// the unix time unix, for the zone changes between from and to. Zone
+// changes are listed latest first, as most entries are recent.
+func offsetExpr(unix string, loc *time.Location, from, to time.Time) string {
+	type change struct{ at, offset int64 }
+	_, offset := from.In(loc).Zone()
+	changes := []change{{0, int64(offset)}}
+	for t := from.In(loc); t.Before(to); {
+		_, end := t.ZoneBounds()
+		if end.IsZero() {
+			break
+		}
+		t = end.In(loc)
+		_, offset := t.Zone()
+		changes = append(changes, change{t.Unix(), int64(offset)})
+	}
+	if len(changes) == 1 {
+		return fmt.Sprint(changes[0].offset)
+	}
+	var b strings.Builder
+	b.WriteString("CASE")
+	for i := len(changes) - 1; i > 0; i-- {
+		fmt.Fprintf(&b, " WHEN %s >= %d THEN %d", unix, changes[i].at, changes[i].offset)
+	}
+	fmt.Fprintf(&b, " ELSE %d END", changes[0].offset)
+	return b.String()
+}
+
+// wallTime returns the time in loc whose wall clock reads as the unix time
+// wall does in UTC.
+func wallTime(wall int64, loc *time.Location) time.Time {
+	t := time.Unix(wall, 0).UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+}
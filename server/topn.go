@@ -0,0 +1,140 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// topNColumns lists the columns /topn can group by, besides field.<name>.
+var topNColumns = map[string]bool{"system": true, "user": true, "module": true, "task": true, "level": true, "trace_id": true}
+
+// topNMetrics maps the metrics accepted by /topn to SQL aggregates.
+var topNMetrics = map[string]string{
+	"count":          "SUM(repeat_count)",
+	"distinct_users": "COUNT(DISTINCT user)",
+	"errors":         fmt.Sprintf("SUM(CASE WHEN level >= %d THEN repeat_count ELSE 0 END)", LevelError),
+}
+
+// TopNValue is one value of the grouped field and its metric.
+type TopNValue struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// handleGetTopN implements GET /topn: the n (default 10, at most 1000) values
+// of field with the highest metric among entries matching the /getdata
+// filters.
+func handleGetTopN(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+		if account == allAccounts && !isAdmin(r, cfg) {
+			requestLogger(r).Warn("Cross-account query denied")
+			writeError(w, http.StatusForbidden, codeForbidden, "Admin token required for cross-account queries")
+			return
+		}
+
+		field := query.Get("field")
+		var group string
+		var groupArgs []interface{}
+		if name, ok := strings.CutPrefix(field, "field."); ok && fieldNameRe.MatchString(name) {
+			group = "CAST(json_extract(" + storedText("fields") + ", ?) AS TEXT)"
+			groupArgs = append(groupArgs, fmt.Sprintf(`$."%s"`, name))
+		} else if topNColumns[field] {
+			group = field
+		} else {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "field must be system, user, module, task, level, trace_id or field.<name>")
+			return
+		}
+
+		metric := query.Get("metric")
+		if metric == "" {
+			metric = "count"
+		}
+		aggregate, ok := topNMetrics[metric]
+		if !ok {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "metric must be count, distinct_users or errors")
+			return
+		}
+
+		n := 10
+		if v := query.Get("n"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil || n < 1 || n > 1000 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "n must be between 1 and 1000")
+				return
+			}
+		}
+
+		params, err := parseQueryParams(query)
+		if err != nil {
+			requestLogger(r).Warn("Invalid query parameters", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		params.Account = account
+		if !allowedBySettings(w, r, accountSettings.checkQuery(params)) {
+			return
+		}
+		start, end := params.partitionRange()
+		key := queryCacheKey(r)
+		if _, ok := queryResults.serve(w, key); ok {
+			return
+		}
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		where, args := buildLogFilter(params)
+		sqlQuery := fmt.Sprintf(`SELECT %s AS value, %s AS metric FROM %s
+			WHERE %s GROUP BY value HAVING value IS NOT NULL AND metric > 0 ORDER BY metric DESC, value LIMIT %d`,
+			group, aggregate, logDataSource(start, end), where, n)
+		args = append(groupArgs, args...)
+		var rowsRead int64
+		defer slowQueries.observe(sqlQuery, args, time.Now(), &rowsRead)
+		rows, err := db.QueryContext(ctx, sqlQuery, args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying top values", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch top values")
+			return
+		}
+		defer rows.Close()
+
+		values := []TopNValue{}
+		for rows.Next() {
+			rowsRead++
+			var v TopNValue
+			if err := rows.Scan(&v.Value, &v.Count); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			values = append(values, v)
+		}
+		if err := rows.Err(); err != nil {
+			if !queryAborted(w, r, cfg, err) {
+				requestLogger(r).Error("Error reading top values", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch top values")
+			}
+			return
+		}
+
+		queryResults.write(w, key, params, len(values), values)
+	}
+}
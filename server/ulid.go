@@ -0,0 +1,157 @@
+package server
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// crockford is the ULID base32 alphabet.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ulidRe = regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{26}$`)
+
+// newULID returns a ULID for t: a 48-bit millisecond timestamp followed by 80
+// random bits, so identifiers sort by time.
+func newULID(t time.Time) string {
+	var b [16]byte
+	ms := uint64(t.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := rand.Read(b[6:]); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+
+	// 128 bits as 26 characters of 5 bits, the first holding only 3
+	var out [26]byte
+	hi := uint64(b[0])<<56 | uint64(b[1])<<48 | uint64(b[2])<<40 | uint64(b[3])<<32 |
+		uint64(b[4])<<24 | uint64(b[5])<<16 | uint64(b[6])<<8 | uint64(b[7])
+	lo := uint64(b[8])<<56 | uint64(b[9])<<48 | uint64(b[10])<<40 | uint64(b[11])<<32 |
+		uint64(b[12])<<24 | uint64(b[13])<<16 | uint64(b[14])<<8 | uint64(b[15])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// ulidTime returns the timestamp encoded in a valid ULID.
+func ulidTime(ulid string) time.Time {
+	var ms int64
+	for _, c := range ulid[:10] {
+		ms = ms<<5 | int64(strings.IndexRune(crockford, c))
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+// backfillULIDs assigns a ULID derived from its timestamp to every entry
+// stored before ULIDs existed.
+func backfillULIDs(db *sql.DB) error {
+	for _, table := range logDataTables() {
+		for {
+			rows, err := db.Query("SELECT id, timestamp FROM " + table + " WHERE ulid IS NULL LIMIT 1000")
+			if err != nil {
+				return err
+			}
+			ids := map[int64]time.Time{}
+			for rows.Next() {
+				var id int64
+				var ts time.Time
+				if err := rows.Scan(&id, &ts); err != nil {
+					rows.Close()
+					return err
+				}
+				ids[id] = ts
+			}
+			rows.Close()
+			if len(ids) == 0 {
+				break
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			for id, ts := range ids {
+				if _, err := tx.Exec("UPDATE "+table+" SET ulid = ? WHERE id = ?", newULID(ts), id); err != nil {
+					tx.Rollback()
+					return err
+				}
+			}
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			slog.Info("Assigned ULIDs", "count", len(ids), "table", table)
+		}
+	}
+	return nil
+}
+
+// handleGetLogEntry serves GET /logdata/{ulid}?account=.
+func handleGetLogEntry(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ulid := strings.ToUpper(strings.Trim(strings.TrimPrefix(r.URL.Path, "/logdata/"), "/"))
+		if !ulidRe.MatchString(ulid) {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid log entry ULID")
+			return
+		}
+
+		account := r.URL.Query().Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+
+		// The ULID encodes the entry timestamp, so only its partition is read
+		ts := ulidTime(ulid)
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		rows, err := db.QueryContext(ctx, "SELECT "+logDataColumns+" FROM "+logDataSource(ts, ts.Add(time.Millisecond))+" WHERE account = ? AND ulid = ?",
+			account, ulid)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying log entry", "ulid", ulid, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch log entry")
+			return
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			if err := rows.Err(); queryAborted(w, r, cfg, err) {
+				return
+			}
+			writeError(w, http.StatusNotFound, codeNotFound, "Log entry not found")
+			return
+		}
+		logData, err := scanLogData(rows)
+		if err != nil {
+			requestLogger(r).Error("Error scanning log entry", "ulid", ulid, "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch log entry")
+			return
+		}
+		rows.Close()
+
+		logs := []LogData{logData}
+		if err := attachAnnotations(ctx, db, logs); queryAborted(w, r, cfg, err) {
+			return
+		} else if err != nil {
+			requestLogger(r).Error("Error loading annotations", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch annotations")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logs[0])
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// userActivityOrders maps the sort values accepted by /users/activity to
+// ORDER BY clauses.
+var userActivityOrders = map[string]string{
+	"errors":    "errors DESC, entries DESC, user",
+	"entries":   "entries DESC, user",
+	"last_seen": "last_seen, user",
+}
+
+// UserActivity summarizes the entries of one user.
+type UserActivity struct {
+	User      string    `json:"user"`
+	Entries   int64     `json:"entries"`
+	Errors    int64     `json:"errors"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Levels counts the entries by level.
+	Levels map[string]int64 `json:"levels"`
+}
+
+// handleUserActivity implements GET /users/activity: per-user counts by
+// level and last-seen times among the entries matching the /getdata filters,
+// up to limit (default 100) users. sort=errors (the default) puts the users
+// with the most errors first, entries the most active, and last_seen the
+// longest silent.
+func handleUserActivity(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+		if account == allAccounts {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "User activity is listed for one account")
+			return
+		}
+		limit := 100
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid limit")
+				return
+			}
+			limit = int(min(int64(n), cfg.Live().MaxQueryRows))
+		}
+		sort := query.Get("sort")
+		if sort == "" {
+			sort = "errors"
+		}
+		order, ok := userActivityOrders[sort]
+		if !ok {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "sort must be errors, entries or last_seen")
+			return
+		}
+
+		params, err := parseQueryParams(query)
+		if err != nil {
+			requestLogger(r).Warn("Invalid query parameters", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		params.Account = account
+		if !allowedBySettings(w, r, accountSettings.checkQuery(params)) {
+			return
+		}
+		start, end := params.partitionRange()
+		key := queryCacheKey(r)
+		if _, ok := queryResults.serve(w, key); ok {
+			return
+		}
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		where, args := buildLogFilter(params)
+		// Levels are counted per user first, then gathered into one row
+		sqlQuery := fmt.Sprintf(`SELECT user, SUM(n) AS entries, SUM(CASE WHEN level >= %d THEN n ELSE 0 END) AS errors,
+				MIN(first_seen), MAX(last_seen) AS last_seen, json_group_object(level, n)
+			FROM (SELECT user, level, SUM(repeat_count) AS n, MIN(timestamp) AS first_seen, MAX(timestamp) AS last_seen
+				FROM %s WHERE %s AND user IS NOT NULL GROUP BY user, level)
+			GROUP BY user ORDER BY %s LIMIT %d`,
+			LevelError, logDataSource(start, end), where, order, limit)
+		var rowsRead int64
+		defer slowQueries.observe(sqlQuery, args, time.Now(), &rowsRead)
+		rows, err := db.QueryContext(ctx, sqlQuery, args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying user activity", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch user activity")
+			return
+		}
+		defer rows.Close()
+
+		users := []UserActivity{}
+		for rows.Next() {
+			rowsRead++
+			var u UserActivity
+			var first, last, levels string
+			if err := rows.Scan(&u.User, &u.Entries, &u.Errors, &first, &last, &levels); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			u.FirstSeen, u.LastSeen = storedTime(first), storedTime(last)
+			json.Unmarshal([]byte(levels), &u.Levels)
+			users = append(users, u)
+		}
+		if err := rows.Err(); err != nil {
+			if !queryAborted(w, r, cfg, err) {
+				requestLogger(r).Error("Error reading user activity", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch user activity")
+			}
+			return
+		}
+
+		queryResults.write(w, key, params, len(users), users)
+	}
+}
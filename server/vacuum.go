@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Deleted entries leave free pages in the database file, which SQLite only
+// gives back to the file system when vacuumed, and query plans rely on the
+// statistics ANALYZE gathers. A vacuum run releases the free pages
+// vacuumBatchPages at a time, so writes wait for one batch at most, until
+// none is left or VACUUM_WINDOW ends, then runs ANALYZE. Runs follow
+// VACUUM_SCHEDULE, start once retention, purges and archival deleted
+// VACUUM_AFTER_DELETES rows, or are requested with POST /admin/vacuum.
+//
+// Releasing pages needs auto_vacuum=INCREMENTAL, which databases created
+// with VACUUM_SCHEDULE set have. Older databases are converted by a full
+// VACUUM, POST /admin/vacuum?full=true, which rewrites the file and blocks
+// writes until done.
+
+const (
+	vacuumBatchPages = 1000
+	// analysisLimit bounds the rows ANALYZE reads per index, keeping it
+	// short on large tables at the cost of approximate statistics.
+	analysisLimit = 1000
+	// vacuumHistory is the number of finished runs kept for GET /admin/vacuum.
+	vacuumHistory = 10
+)
+
+// VacuumRun reports a vacuum run, as it progresses and once finished.
+type VacuumRun struct {
+	// Trigger is schedule, deletes or manual.
+	Trigger    string     `json:"trigger"`
+	Full       bool       `json:"full,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Phase is vacuum, analyze or done.
+	Phase string `json:"phase"`
+	// AutoVacuum is the database's auto_vacuum mode: none, full or
+	// incremental. Only full runs release pages of none databases.
+	AutoVacuum      string `json:"auto_vacuum"`
+	PageSize        int64  `json:"page_size"`
+	FreePagesBefore int64  `json:"free_pages_before"`
+	FreePages       int64  `json:"free_pages"`
+	BytesReclaimed  int64  `json:"bytes_reclaimed"`
+	// WindowEnded reports that VACUUM_WINDOW ended with free pages left.
+	WindowEnded bool `json:"window_ended,omitempty"`
+	// LockSeconds is how long the run held the write connection, in all
+	// and at most at once, and WriteWaits the writes that waited for a
+	// connection meanwhile, for WriteWaitSeconds in all.
+	LockSeconds      float64 `json:"lock_seconds"`
+	MaxLockSeconds   float64 `json:"max_lock_seconds"`
+	WriteWaits       int64   `json:"write_waits"`
+	WriteWaitSeconds float64 `json:"write_wait_seconds"`
+	Error            string  `json:"error,omitempty"`
+}
+
+// VacuumStatus is the body of GET /admin/vacuum.
+type VacuumStatus struct {
+	Schedule string `json:"schedule,omitempty"`
+	// NextRunAt is the next scheduled run, absent without a schedule.
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+	// DeletedSinceRun counts the rows deleted since the last run, towards
+	// VACUUM_AFTER_DELETES.
+	DeletedSinceRun int64       `json:"deleted_since_run"`
+	Running         *VacuumRun  `json:"running,omitempty"`
+	History         []VacuumRun `json:"history"`
+}
+
+// vacuums runs vacuums on the database of the running Server. It is set by
+// load.
+var vacuums *vacuumer
+
+type vacuumer struct {
+	db  *sql.DB
+	cfg *Config
+	// schedule is nil without VACUUM_SCHEDULE.
+	schedule *cronSchedule
+
+	mu      sync.Mutex
+	running *VacuumRun
+	// history holds the finished runs, newest first.
+	history []VacuumRun
+	// pending counts the rows deleted since the last run.
+	pending int64
+}
+
+func newVacuumer(db *sql.DB, cfg *Config) *vacuumer {
+	v := &vacuumer{db: db, cfg: cfg}
+	if cfg.VacuumSchedule != "" {
+		v.schedule, _ = parseCron(cfg.VacuumSchedule)
+	}
+	return v
+}
+
+// run starts the scheduled runs.
+func (v *vacuumer) run(stop <-chan struct{}) {
+	for {
+		next := v.schedule.next(timeNow())
+		if !sleep(stop, time.Until(next)) {
+			return
+		}
+		if _, ok := v.start("schedule", false); !ok {
+			slog.Warn("Skipping scheduled vacuum, a run is in progress")
+		}
+	}
+}
+
+// deleted counts n rows deleted, starting a run once VACUUM_AFTER_DELETES
+// rows were.
+func (v *vacuumer) deleted(n int64) {
+	if v == nil || n <= 0 {
+		return
+	}
+	v.mu.Lock()
+	v.pending += n
+	due := v.cfg.VacuumAfterDeletes > 0 && v.pending >= int64(v.cfg.VacuumAfterDeletes) && v.running == nil
+	v.mu.Unlock()
+	if due {
+		v.start("deletes", false)
+	}
+}
+
+// start begins a run in the background and returns its report, or false
+// when a run is in progress.
+func (v *vacuumer) start(trigger string, full bool) (VacuumRun, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.running != nil {
+		return *v.running, false
+	}
+	v.running = &VacuumRun{Trigger: trigger, Full: full, StartedAt: timeNow().UTC(), Phase: "vacuum"}
+	v.pending = 0
+	go v.vacuum(v.running)
+	return *v.running, true
+}
+
+// update applies f to the running report.
+func (v *vacuumer) update(f func(run *VacuumRun)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	f(v.running)
+}
+
+func (v *vacuumer) vacuum(run *VacuumRun) {
+	before := v.db.Stats()
+	err := v.vacuumPages(run)
+	if err == nil {
+		v.update(func(run *VacuumRun) { run.Phase = "analyze" })
+		err = v.locked(func(conn *sql.Conn) error {
+			if _, err := conn.ExecContext(context.Background(), fmt.Sprintf("PRAGMA analysis_limit = %d", analysisLimit)); err != nil {
+				return err
+			}
+			_, err := conn.ExecContext(context.Background(), "ANALYZE")
+			return err
+		})
+	}
+
+	after := v.db.Stats()
+	v.mu.Lock()
+	finished := timeNow().UTC()
+	run.FinishedAt, run.Phase = &finished, "done"
+	run.WriteWaits = after.WaitCount - before.WaitCount
+	run.WriteWaitSeconds = (after.WaitDuration - before.WaitDuration).Seconds()
+	if err != nil {
+		run.Error = err.Error()
+	}
+	v.history = append([]VacuumRun{*run}, v.history...)[:min(len(v.history)+1, vacuumHistory)]
+	v.running = nil
+	v.mu.Unlock()
+	if err != nil {
+		slog.Error("Vacuum failed", "trigger", run.Trigger, "err", err)
+		return
+	}
+	slog.Info("Vacuumed database", "trigger", run.Trigger, "full", run.Full, "bytes_reclaimed", run.BytesReclaimed,
+		"free_pages", run.FreePages, "lock_seconds", run.LockSeconds, "max_lock_seconds", run.MaxLockSeconds,
+		"write_waits", run.WriteWaits, "window_ended", run.WindowEnded)
+}
+
+// vacuumPages releases the free pages, in batches for incremental
+// databases or at once for full runs.
+func (v *vacuumer) vacuumPages(run *VacuumRun) error {
+	ctx := context.Background()
+	var mode int
+	var pageSize, free int64
+	if err := v.db.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return err
+	}
+	if err := v.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return err
+	}
+	if err := v.db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&free); err != nil {
+		return err
+	}
+	v.update(func(run *VacuumRun) {
+		run.AutoVacuum = []string{"none", "full", "incremental"}[mode]
+		run.PageSize, run.FreePagesBefore, run.FreePages = pageSize, free, free
+	})
+
+	if run.Full {
+		var pagesBefore, pagesAfter int64
+		err := v.locked(func(conn *sql.Conn) error {
+			if err := conn.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pagesBefore); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+				return err
+			}
+			if err := conn.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pagesAfter); err != nil {
+				return err
+			}
+			return conn.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&free)
+		})
+		v.update(func(run *VacuumRun) {
+			run.AutoVacuum, run.FreePages = "incremental", free
+			run.BytesReclaimed = (pagesBefore - pagesAfter) * pageSize
+		})
+		return err
+	}
+	if mode != 2 {
+		if free > 0 {
+			slog.Warn("Database has free pages but auto_vacuum is not incremental; POST /admin/vacuum?full=true converts it", "free_pages", free)
+		}
+		return nil
+	}
+
+	deadline := timeNow().Add(v.cfg.VacuumWindow)
+	for free > 0 {
+		if !timeNow().Before(deadline) {
+			v.update(func(run *VacuumRun) { run.WindowEnded = true })
+			return nil
+		}
+		err := v.locked(func(conn *sql.Conn) error {
+			// Each step of the pragma releases one page
+			rows, err := conn.QueryContext(ctx, fmt.Sprintf("PRAGMA incremental_vacuum(%d)", vacuumBatchPages))
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+			}
+			if err := rows.Close(); err != nil {
+				return err
+			}
+			return conn.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&free)
+		})
+		if err != nil {
+			return err
+		}
+		v.update(func(run *VacuumRun) {
+			run.BytesReclaimed += (run.FreePages - free) * pageSize
+			run.FreePages = free
+		})
+	}
+	return nil
+}
+
+// locked runs f on the write connection, adding the time it held it to the
+// running report's lock time.
+func (v *vacuumer) locked(f func(conn *sql.Conn) error) error {
+	conn, err := v.db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = f(conn)
+	held := time.Since(start).Seconds()
+	conn.Close()
+	v.update(func(run *VacuumRun) {
+		run.LockSeconds += held
+		run.MaxLockSeconds = max(run.MaxLockSeconds, held)
+	})
+	return err
+}
+
+func (v *vacuumer) status() VacuumStatus {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	status := VacuumStatus{Schedule: v.cfg.VacuumSchedule, DeletedSinceRun: v.pending, History: append([]VacuumRun{}, v.history...)}
+	if v.schedule != nil {
+		next := v.schedule.next(timeNow()).UTC()
+		status.NextRunAt = &next
+	}
+	if v.running != nil {
+		run := *v.running
+		status.Running = &run
+	}
+	return status
+}
+
+// handleVacuum serves GET /admin/vacuum, the running and recent runs, and
+// POST /admin/vacuum, which starts a run, a full one with full=true.
+func handleVacuum(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(vacuums.status())
+
+		case http.MethodPost:
+			full := r.URL.Query().Get("full") == "true"
+			run, ok := vacuums.start("manual", full)
+			if !ok {
+				writeError(w, http.StatusConflict, codeConflict, "A vacuum is already running")
+				return
+			}
+			recordAudit(db, r, "admin", "vacuum", "", fmt.Sprintf("full=%t", full))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(run)
+
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// valueColumns lists the columns /values returns.
+var valueColumns = map[string]bool{"system": true, "user": true, "module": true, "task": true}
+
+// handleGetValues implements GET /values?field=, the distinct values of a
+// metadata column with their entry counts, in value order, among entries
+// matching the /getdata filters. limit (default 1000, at most 10000) bounds
+// the values returned.
+func handleGetValues(db *sql.DB, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		account := query.Get("account")
+		if account == "" {
+			requestLogger(r).Warn("Missing account query parameter")
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+			return
+		}
+		if account == allAccounts && !isAdmin(r, cfg) {
+			requestLogger(r).Warn("Cross-account query denied")
+			writeError(w, http.StatusForbidden, codeForbidden, "Admin token required for cross-account queries")
+			return
+		}
+
+		field := query.Get("field")
+		if !valueColumns[field] {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "field must be system, user, module or task")
+			return
+		}
+		limit := 1000
+		if v := query.Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 10000 {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "limit must be between 1 and 10000")
+				return
+			}
+		}
+		// limit means something else to the filters
+		query.Del("limit")
+
+		params, err := parseQueryParams(query)
+		if err != nil {
+			requestLogger(r).Warn("Invalid query parameters", "err", err)
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		params.Account = account
+		if !allowedBySettings(w, r, accountSettings.checkQuery(params)) {
+			return
+		}
+		start, end := params.partitionRange()
+		key := queryCacheKey(r)
+		if _, ok := queryResults.serve(w, key); ok {
+			return
+		}
+
+		ctx, cancel := queryContext(r, cfg)
+		defer cancel()
+		where, args := buildLogFilter(params)
+		sqlQuery := fmt.Sprintf(`SELECT %s AS value, SUM(repeat_count) FROM %s
+			WHERE %s GROUP BY value ORDER BY value LIMIT %d`,
+			field, logDataSource(start, end), where, limit)
+		var rowsRead int64
+		defer slowQueries.observe(sqlQuery, args, time.Now(), &rowsRead)
+		rows, err := db.QueryContext(ctx, sqlQuery, args...)
+		if queryAborted(w, r, cfg, err) {
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("Error querying distinct values", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch values")
+			return
+		}
+		defer rows.Close()
+
+		values := []TopNValue{}
+		for rows.Next() {
+			rowsRead++
+			var v TopNValue
+			if err := rows.Scan(&v.Value, &v.Count); err != nil {
+				requestLogger(r).Error("Error scanning row", "err", err)
+				continue
+			}
+			values = append(values, v)
+		}
+		if err := rows.Err(); err != nil {
+			if !queryAborted(w, r, cfg, err) {
+				requestLogger(r).Error("Error reading distinct values", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch values")
+			}
+			return
+		}
+
+		queryResults.write(w, key, params, len(values), values)
+	}
+}
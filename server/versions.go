@@ -0,0 +1,17 @@
+package server
+
+import "net/http"
+
+// apiVersion prefixes the routes of the current API. The unversioned paths
+// are its aliases, kept for existing agents. A breaking change, such as a
+// new response envelope, is served under the next prefix by a mux of its
+// own that forwards the routes it leaves unchanged to the previous one.
+const apiVersion = "/v1"
+
+// withAPIVersions serves mux under apiVersion and at the unversioned paths.
+func withAPIVersions(mux http.Handler) http.Handler {
+	router := http.NewServeMux()
+	router.Handle(apiVersion+"/", http.StripPrefix(apiVersion, mux))
+	router.Handle("/", mux)
+	return router
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebhookSubscription forwards entries matching its filter to URL.
+type WebhookSubscription struct {
+	ID       int64  `json:"id"`
+	Account  string `json:"account"`
+	URL      string `json:"url"`
+	System   string `json:"system,omitempty"`
+	Module   string `json:"module,omitempty"`
+	MinLevel int    `json:"min_level"`
+	// Secret signs deliveries with an X-Logdata-Signature HMAC-SHA256 header.
+	Secret  string `json:"secret,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Validate ensures the subscription has an account and an http(s) URL.
+func (s WebhookSubscription) Validate() error {
+	if s.Account == "" {
+		return fmt.Errorf("account is required")
+	}
+	if !strings.HasPrefix(s.URL, "http://") && !strings.HasPrefix(s.URL, "https://") {
+		return fmt.Errorf("url must be an http(s) URL")
+	}
+	return nil
+}
+
+// matches reports whether logData passes the subscription filter.
+func (s WebhookSubscription) matches(logData LogData) bool {
+	return s.Enabled && s.Account == logData.Account && logData.Level >= s.MinLevel &&
+		(s.System == "" || s.System == logData.System) &&
+		(s.Module == "" || s.Module == logData.Module)
+}
+
+const webhookSubscriptionsSchema = `CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    account TEXT NOT NULL,
+    url TEXT NOT NULL,
+    system TEXT NOT NULL DEFAULT '',
+    module TEXT NOT NULL DEFAULT '',
+    min_level INTEGER NOT NULL DEFAULT 0,
+    secret TEXT NOT NULL DEFAULT '',
+    enabled INTEGER NOT NULL DEFAULT 1
+)`
+
+const webhookSubscriptionColumns = "id, account, url, system, module, min_level, secret, enabled"
+
+func scanWebhookSubscription(scan func(dest ...interface{}) error) (WebhookSubscription, error) {
+	var sub WebhookSubscription
+	err := scan(&sub.ID, &sub.Account, &sub.URL, &sub.System, &sub.Module, &sub.MinLevel, &sub.Secret, &sub.Enabled)
+	return sub, err
+}
+
+const (
+	// webhookQueueSize bounds pending deliveries; entries beyond it are dropped.
+	webhookQueueSize = 1000
+	webhookWorkers   = 4
+	webhookMaxDelay  = time.Minute
+)
+
+type webhookDelivery struct {
+	sub     WebhookSubscription
+	logData LogData
+}
+
+// webhookDispatcher keeps subscriptions in memory and delivers matching entries
+// from a bounded queue with exponential backoff retries.
+type webhookDispatcher struct {
+	db    *sql.DB
+	cfg   *Config
+	mu    sync.RWMutex
+	subs  []WebhookSubscription
+	queue chan webhookDelivery
+}
+
+func newWebhookDispatcher(db *sql.DB, cfg *Config) *webhookDispatcher {
+	d := &webhookDispatcher{db: db, cfg: cfg, queue: make(chan webhookDelivery, webhookQueueSize)}
+	for i := 0; i < webhookWorkers; i++ {
+		go d.worker()
+	}
+	return d
+}
+
+// reload refreshes the in-memory subscription list from the database.
+func (d *webhookDispatcher) reload() error {
+	rows, err := d.db.Query("SELECT " + webhookSubscriptionColumns + " FROM webhook_subscriptions WHERE enabled = 1")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var subs []WebhookSubscription
+	for rows.Next() {
+		sub, err := scanWebhookSubscription(rows.Scan)
+		if err != nil {
+			return err
+		}
+		subs = append(subs, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.subs = subs
+	d.mu.Unlock()
+	return nil
+}
+
+// dispatch queues logData for every matching subscription, unless the
+// account's settings turn webhooks off. It is an insert hook.
+func (d *webhookDispatcher) dispatch(logData LogData) {
+	if !accountSettings.webhooksEnabled(logData.Account) {
+		return
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, sub := range d.subs {
+		if !sub.matches(logData) {
+			continue
+		}
+		select {
+		case d.queue <- webhookDelivery{sub: sub, logData: logData}:
+		default:
+			slog.Warn("Webhook queue full, dropping delivery", "subscription_id", sub.ID)
+		}
+	}
+}
+
+func (d *webhookDispatcher) worker() {
+	for delivery := range d.queue {
+		d.deliver(delivery)
+	}
+}
+
+// deliver POSTs one entry, retrying failures with exponential backoff.
+func (d *webhookDispatcher) deliver(delivery webhookDelivery) {
+	body, err := json.Marshal(delivery.logData)
+	if err != nil {
+		slog.Error("Error encoding webhook payload", "err", err)
+		return
+	}
+
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := d.post(delivery.sub, body)
+		if err == nil {
+			return
+		}
+		if attempt >= d.cfg.WebhookMaxAttempts {
+			slog.Warn("Giving up webhook delivery", "subscription_id", delivery.sub.ID, "attempts", attempt, "err", err)
+			return
+		}
+		slog.Warn("Webhook delivery failed, retrying", "subscription_id", delivery.sub.ID, "attempt", attempt, "delay", delay, "err", err)
+		time.Sleep(delay)
+		delay = min(delay*2, webhookMaxDelay)
+	}
+}
+
+func (d *webhookDispatcher) post(sub WebhookSubscription, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sub.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(sub.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Logdata-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// handleWebhookSubscriptions serves GET/POST /webhooks and GET/DELETE /webhooks/{id}.
+func handleWebhookSubscriptions(db *sql.DB, dispatcher *webhookDispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/webhooks"), "/")
+		account := r.URL.Query().Get("account")
+
+		switch {
+		case rest == "" && r.Method == http.MethodGet:
+			if account == "" {
+				requestLogger(r).Warn("Missing account query parameter")
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Account query parameter required")
+				return
+			}
+			rows, err := db.Query("SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE account = ? ORDER BY id", account)
+			if err != nil {
+				requestLogger(r).Error("Error querying webhook subscriptions", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to fetch webhook subscriptions")
+				return
+			}
+			defer rows.Close()
+			subs := []WebhookSubscription{}
+			for rows.Next() {
+				sub, err := scanWebhookSubscription(rows.Scan)
+				if err != nil {
+					requestLogger(r).Error("Error scanning row", "err", err)
+					continue
+				}
+				sub.Secret = "" // never echo signing secrets back
+				subs = append(subs, sub)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(subs)
+
+		case rest == "" && r.Method == http.MethodPost:
+			sub := WebhookSubscription{Enabled: true}
+			if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+				requestLogger(r).Warn("Invalid request body", "err", err)
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+				return
+			}
+			if err := sub.Validate(); err != nil {
+				requestLogger(r).Warn("Validation failed", "err", err)
+				writeError(w, http.StatusBadRequest, codeValidationFailed, fmt.Sprintf("Validation failed: %v", err))
+				return
+			}
+			if !accountAllowed(r, sub.Account) {
+				writeError(w, http.StatusForbidden, codeForbidden, "Token not valid for this account")
+				return
+			}
+			res, err := db.Exec(`INSERT INTO webhook_subscriptions (account, url, system, module, min_level, secret, enabled)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`, sub.Account, sub.URL, sub.System, sub.Module, sub.MinLevel, sub.Secret, sub.Enabled)
+			if err != nil {
+				requestLogger(r).Error("Error saving webhook subscription", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to save webhook subscription")
+				return
+			}
+			sub.ID, _ = res.LastInsertId()
+			if err := dispatcher.reload(); err != nil {
+				requestLogger(r).Error("Error reloading webhook subscriptions", "err", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(sub)
+
+		case rest != "" && r.Method == http.MethodDelete:
+			id, err := strconv.ParseInt(rest, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid webhook subscription id")
+				return
+			}
+			res, err := db.Exec("DELETE FROM webhook_subscriptions WHERE id = ? AND account = ?", id, account)
+			if err != nil {
+				requestLogger(r).Error("Error deleting webhook subscription", "subscription_id", id, "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "Failed to delete webhook subscription")
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				writeError(w, http.StatusNotFound, codeNotFound, "Webhook subscription not found")
+				return
+			}
+			if err := dispatcher.reload(); err != nil {
+				requestLogger(r).Error("Error reloading webhook subscriptions", "err", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Webhook subscription deleted"})
+
+		default:
+			requestLogger(r).Warn("Method not allowed", "method", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}